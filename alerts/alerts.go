@@ -0,0 +1,247 @@
+// Package alerts evaluates monitoring rules against adoption statistics and
+// validator runs, and sends notifications when they start firing.
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/stats"
+	"github.com/EFForg/starttls-backend/validator"
+	raven "github.com/getsentry/raven-go"
+)
+
+// Metrics that rules can be evaluated against. The MTA-STS metrics are read
+// from aggregated scans for the rule's Source, eg. TOP_DOMAINS or LOCAL.
+// ValidatorFailureRate is the percentage of domains that failed in the most
+// recent run of the validator named by the rule's Source.
+const (
+	MTASTSEnforce        = "mta_sts_enforce"
+	MTASTSTesting        = "mta_sts_testing"
+	MTASTSTotal          = "mta_sts_total"
+	MTASTSPercent        = "mta_sts_percent"
+	ValidatorFailureRate = "validator_failure_rate"
+)
+
+// Rule describes a condition that should trigger an alert.
+type Rule struct {
+	Name   string `json:"name"`
+	Metric string `json:"metric"`
+	Source string `json:"source"`
+	// Above fires the rule when the latest value of the metric exceeds it.
+	Above *float64 `json:"above,omitempty"`
+	// DropPercent fires the rule when the metric has fallen by more than this
+	// percentage since WindowDays ago.
+	DropPercent float64 `json:"drop_percent,omitempty"`
+	// WindowDays defaults to 7, for week-over-week changes.
+	WindowDays int `json:"window_days,omitempty"`
+}
+
+func (r Rule) window() time.Duration {
+	if r.WindowDays <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return time.Duration(r.WindowDays) * 24 * time.Hour
+}
+
+// Validate checks that a rule has a known metric and exactly one condition.
+func (r Rule) Validate() error {
+	switch r.Metric {
+	case MTASTSEnforce, MTASTSTesting, MTASTSTotal, MTASTSPercent:
+		if r.Source == "" {
+			return fmt.Errorf("rule %q: source is required", r.Name)
+		}
+	case ValidatorFailureRate:
+		if r.Source == "" {
+			return fmt.Errorf("rule %q: source must name a validator", r.Name)
+		}
+		if r.DropPercent != 0 {
+			return fmt.Errorf("rule %q: drop_percent isn't supported for %s", r.Name, r.Metric)
+		}
+	default:
+		return fmt.Errorf("rule %q: unknown metric %q", r.Name, r.Metric)
+	}
+	if (r.Above == nil) == (r.DropPercent == 0) {
+		return fmt.Errorf("rule %q: exactly one of above and drop_percent must be set", r.Name)
+	}
+	return nil
+}
+
+// LoadRules reads a JSON array of rules from path.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if err = rule.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// Alert is a notification that a rule has started firing.
+type Alert struct {
+	Rule    Rule
+	Time    time.Time
+	Value   float64
+	Message string
+}
+
+// Notifier sends alerts somewhere a human will see them.
+type Notifier interface {
+	Notify(Alert) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface.
+type NotifierFunc func(Alert) error
+
+// Notify calls f(a).
+func (f NotifierFunc) Notify(a Alert) error {
+	return f(a)
+}
+
+// SentryNotifier reports alerts to Sentry.
+type SentryNotifier struct{}
+
+// Notify captures a message for the alert.
+func (SentryNotifier) Notify(a Alert) error {
+	raven.CaptureMessage(a.Message, map[string]string{
+		"alert":  a.Rule.Name,
+		"metric": a.Rule.Metric,
+		"source": a.Rule.Source,
+	})
+	return nil
+}
+
+// StatsStore provides the aggregated scans that rules are evaluated against.
+type StatsStore interface {
+	GetStats(string) (stats.Series, error)
+}
+
+// Engine evaluates rules and notifies when they start firing. A rule which
+// keeps firing is only notified once, until it has resolved.
+type Engine struct {
+	Rules     []Rule
+	Store     StatsStore
+	Notifiers []Notifier
+	// lastRun overrides validator.LastRun in tests.
+	lastRun func(string) (validator.RunSummary, bool)
+
+	mu     sync.Mutex
+	firing map[string]bool
+}
+
+// Evaluate checks every rule, notifies about rules that have started firing,
+// and returns their alerts.
+func (e *Engine) Evaluate() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.firing == nil {
+		e.firing = make(map[string]bool)
+	}
+	alerts := []Alert{}
+	for _, rule := range e.Rules {
+		alert, firing, err := e.evaluate(rule)
+		if err != nil {
+			log.Printf("[alerts] Could not evaluate rule %q: %v", rule.Name, err)
+			continue
+		}
+		wasFiring := e.firing[rule.Name]
+		e.firing[rule.Name] = firing
+		if !firing || wasFiring {
+			continue
+		}
+		alerts = append(alerts, alert)
+		for _, notifier := range e.Notifiers {
+			if err := notifier.Notify(alert); err != nil {
+				log.Printf("[alerts] Could not send alert %q: %v", rule.Name, err)
+			}
+		}
+	}
+	return alerts
+}
+
+func (e *Engine) evaluate(rule Rule) (Alert, bool, error) {
+	alert := Alert{Rule: rule, Time: time.Now()}
+	if rule.Metric == ValidatorFailureRate {
+		lastRun := e.lastRun
+		if lastRun == nil {
+			lastRun = validator.LastRun
+		}
+		summary, ok := lastRun(rule.Source)
+		if !ok {
+			return alert, false, nil
+		}
+		alert.Value = summary.FailureRate()
+		alert.Message = fmt.Sprintf("%s: %.1f%% of domains failed the last %s validation, above %.1f%%",
+			rule.Name, alert.Value, rule.Source, *rule.Above)
+		return alert, alert.Value > *rule.Above, nil
+	}
+
+	series, err := e.Store.GetStats(rule.Source)
+	if err != nil {
+		return alert, false, err
+	}
+	if len(series) == 0 {
+		return alert, false, nil
+	}
+	latest := series[len(series)-1]
+	alert.Value = metricValue(rule.Metric, latest)
+	if rule.Above != nil {
+		alert.Message = fmt.Sprintf("%s: %s for %s is %.1f, above %.1f",
+			rule.Name, rule.Metric, rule.Source, alert.Value, *rule.Above)
+		return alert, alert.Value > *rule.Above, nil
+	}
+	previous, ok := valueBefore(series, rule.Metric, latest.Time.Add(-rule.window()))
+	if !ok || previous == 0 {
+		return alert, false, nil
+	}
+	drop := 100 * (previous - alert.Value) / previous
+	alert.Message = fmt.Sprintf("%s: %s for %s dropped %.1f%% (from %.1f to %.1f) in %d days",
+		rule.Name, rule.Metric, rule.Source, drop, previous, alert.Value, int(rule.window().Hours()/24))
+	return alert, drop > rule.DropPercent, nil
+}
+
+func metricValue(metric string, a checker.AggregatedScan) float64 {
+	switch metric {
+	case MTASTSEnforce:
+		return float64(a.MTASTSEnforce)
+	case MTASTSTesting:
+		return float64(a.MTASTSTesting)
+	case MTASTSTotal:
+		return float64(a.TotalMTASTS())
+	case MTASTSPercent:
+		return a.PercentMTASTS()
+	}
+	return 0
+}
+
+// valueBefore returns the metric from the latest scan in series, which is
+// ordered by time, at or before t.
+func valueBefore(series stats.Series, metric string, t time.Time) (float64, bool) {
+	for i := len(series) - 1; i >= 0; i-- {
+		if !series[i].Time.After(t) {
+			return metricValue(metric, series[i]), true
+		}
+	}
+	return 0, false
+}
+
+// EvaluateRegularly evaluates the engine's rules at regular intervals.
+func EvaluateRegularly(e *Engine, interval time.Duration) {
+	for {
+		e.Evaluate()
+		<-time.After(interval)
+	}
+}
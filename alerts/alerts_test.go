@@ -0,0 +1,129 @@
+package alerts
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/stats"
+	"github.com/EFForg/starttls-backend/validator"
+)
+
+type mockStatsStore map[string]stats.Series
+
+func (m mockStatsStore) GetStats(source string) (stats.Series, error) {
+	return m[source], nil
+}
+
+type recordingNotifier struct {
+	alerts []Alert
+}
+
+func (n *recordingNotifier) Notify(a Alert) error {
+	n.alerts = append(n.alerts, a)
+	return nil
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func enforceSeries(counts ...int) stats.Series {
+	series := stats.Series{}
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i, count := range counts {
+		series = append(series, checker.AggregatedScan{
+			Time:          start.Add(time.Duration(i) * 24 * time.Hour),
+			Source:        checker.TopDomainsSource,
+			WithMXs:       1000,
+			MTASTSEnforce: count,
+		})
+	}
+	return series
+}
+
+func TestDropPercent(t *testing.T) {
+	rule := Rule{Name: "enforce drop", Metric: MTASTSEnforce, Source: checker.TopDomainsSource, DropPercent: 5}
+	var tests = []struct {
+		name   string
+		series stats.Series
+		want   bool
+	}{
+		{"steady", enforceSeries(100, 100, 100, 100, 100, 100, 100, 100), false},
+		{"small drop", enforceSeries(100, 100, 100, 100, 100, 100, 100, 96), false},
+		{"large drop", enforceSeries(100, 100, 100, 100, 100, 100, 100, 90), true},
+		{"not enough history", enforceSeries(100, 90), false},
+		{"no data", nil, false},
+	}
+	for _, test := range tests {
+		notifier := &recordingNotifier{}
+		e := Engine{
+			Rules:     []Rule{rule},
+			Store:     mockStatsStore{checker.TopDomainsSource: test.series},
+			Notifiers: []Notifier{notifier},
+		}
+		alerts := e.Evaluate()
+		if (len(alerts) == 1) != test.want || len(notifier.alerts) != len(alerts) {
+			t.Errorf("%s: got alerts %v, want firing %v", test.name, alerts, test.want)
+		}
+	}
+}
+
+func TestValidatorFailureRate(t *testing.T) {
+	summary := validator.RunSummary{Attempted: 10, Failed: 2}
+	notifier := &recordingNotifier{}
+	e := Engine{
+		Rules: []Rule{{Name: "validator failures", Metric: ValidatorFailureRate,
+			Source: "Live policy list", Above: floatPtr(10)}},
+		Notifiers: []Notifier{notifier},
+		lastRun: func(name string) (validator.RunSummary, bool) {
+			return summary, name == "Live policy list"
+		},
+	}
+	e.Evaluate()
+	if len(notifier.alerts) != 1 || !strings.Contains(notifier.alerts[0].Message, "20.0%") {
+		t.Fatalf("expected an alert for a 20%% failure rate, got %v", notifier.alerts)
+	}
+	// Rules that keep firing aren't notified again until they've resolved.
+	e.Evaluate()
+	if len(notifier.alerts) != 1 {
+		t.Errorf("expected a single notification while the rule is firing, got %d", len(notifier.alerts))
+	}
+	summary.Failed = 0
+	e.Evaluate()
+	summary.Failed = 5
+	e.Evaluate()
+	if len(notifier.alerts) != 2 {
+		t.Errorf("expected a second notification after the rule resolved, got %d", len(notifier.alerts))
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	var tests = []struct {
+		rules   string
+		wantErr bool
+	}{
+		{`[{"name": "a", "metric": "mta_sts_enforce", "source": "TOP_DOMAINS", "drop_percent": 5}]`, false},
+		{`[{"name": "b", "metric": "validator_failure_rate", "source": "Live policy list", "above": 10}]`, false},
+		{`[{"name": "c", "metric": "unknown", "source": "LOCAL", "above": 10}]`, true},
+		{`[{"name": "d", "metric": "mta_sts_total", "source": "LOCAL"}]`, true},
+		{`[{"name": "e", "metric": "mta_sts_total", "source": "LOCAL", "above": 1, "drop_percent": 5}]`, true},
+		{`not json`, true},
+	}
+	for _, test := range tests {
+		f, err := ioutil.TempFile("", "rules")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(test.rules)
+		f.Close()
+		_, err = LoadRules(f.Name())
+		os.Remove(f.Name())
+		if (err != nil) != test.wantErr {
+			t.Errorf("LoadRules(%s) returned error %v, want error %v", test.rules, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+// scanIdentity tells the operators of the servers we check who's connecting
+// to them, and how to opt out.
+type scanIdentity struct {
+	UserAgent    string `json:"user_agent"`
+	EHLOHostname string `json:"ehlo_hostname"`
+	Contact      string `json:"contact,omitempty"`
+	// Domain and OptedOut are only set if a domain was given.
+	Domain   string `json:"domain,omitempty"`
+	OptedOut bool   `json:"opted_out,omitempty"`
+}
+
+// AboutScans is the handler for /about-scans, which SCANNER_INFO_URL should
+// point to.
+//   GET /about-scans
+//        Describes how our scans identify themselves, and how to opt out.
+//        domain (optional): Also reports whether this domain has opted out
+//          of scans.
+func (api API) aboutScans(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed}
+	}
+	identity := scanIdentity{
+		UserAgent:    checker.UserAgent(),
+		EHLOHostname: checker.EHLOHostname(),
+		Contact:      os.Getenv("SCANNER_CONTACT"),
+	}
+	if r.FormValue("domain") != "" {
+		domain, err := getASCIIDomain(r)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		identity.Domain = domain
+		identity.OptedOut = api.DontScan[domain]
+	}
+	return response{StatusCode: http.StatusOK, Response: identity, templateName: "about_scans"}
+}
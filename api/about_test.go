@@ -0,0 +1,22 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestAboutScansReportsOptOut(t *testing.T) {
+	resp, err := http.Get(server.URL + "/about-scans?domain=dontscan.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := scanIdentity{}
+	json.NewDecoder(resp.Body).Decode(&response{Response: &identity})
+	if identity.UserAgent == "" || identity.EHLOHostname == "" {
+		t.Errorf("Expected scanner identity, got %+v", identity)
+	}
+	if identity.Domain != "dontscan.com" || !identity.OptedOut {
+		t.Errorf("Expected dontscan.com to have opted out, got %+v", identity)
+	}
+}
@@ -0,0 +1,433 @@
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/report"
+	"github.com/EFForg/starttls-backend/slo"
+)
+
+// adminOnly restricts a handler to maintainers: requests bearing the admin
+// key set in the ADMIN_KEY env var, or an API key with an admin scope, sent
+// as `Authorization: Bearer <key>`, or the session cookie of a maintainer
+// signed in with OIDC. GET requests need the "list-read" or "admin-write"
+// scope, and others "admin-write". If ADMIN_KEY isn't set, only API keys and
+// sessions are accepted.
+func (api API) adminOnly(handler apiHandler) apiHandler {
+	return func(r *http.Request) response {
+		scope := models.ScopeAdminWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			scope = models.ScopeListRead
+		}
+		r, refused := api.authenticateAdmin(r, scope)
+		if refused != nil {
+			return *refused
+		}
+		return handler(r)
+	}
+}
+
+// authenticateAdmin checks that r bears the admin key, or an unrevoked API
+// key with scope, which keys with the "admin-write" scope always have, or an
+// OIDC session, which has every scope. It returns r with the API key or
+// session attached, or a response refusing the request.
+func (api API) authenticateAdmin(r *http.Request, scope string) (*http.Request, *response) {
+	if isAdmin(r) {
+		return r, nil
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if given == "" {
+		// Session cookies are SameSite=Strict, so other sites can't make
+		// requests with them.
+		if api.OIDC != nil {
+			if session, ok := api.OIDC.session(r, time.Now()); ok {
+				return withAdminSession(r, session), nil
+			}
+		}
+		return r, &response{StatusCode: http.StatusUnauthorized, Message: "a valid admin key is required"}
+	}
+	key, err := api.Database.UseAPIKey(models.HashAPIKey(given))
+	if err == sql.ErrNoRows {
+		return r, &response{StatusCode: http.StatusUnauthorized, Message: "a valid admin key is required"}
+	}
+	if err != nil {
+		refused := serverError(err.Error())
+		return r, &refused
+	}
+	if !key.HasScope(scope) && !key.HasScope(models.ScopeAdminWrite) {
+		return r, &response{StatusCode: http.StatusForbidden,
+			Message: "this API key doesn't have the " + scope + " scope"}
+	}
+	return withRequestKey(r, key), nil
+}
+
+// getActor returns who is making an admin request, for the audit log: the
+// owner of the API key it was authenticated with, or the signed-in
+// maintainer, or else the actor parameter, which is required with the admin
+// key.
+func getActor(r *http.Request) (string, error) {
+	if key, ok := requestKey(r); ok {
+		return key.Actor(), nil
+	}
+	if session, ok := requestSession(r); ok {
+		return session.Email, nil
+	}
+	actor := r.FormValue("actor")
+	if actor == "" {
+		return "", errors.New("query parameter actor not specified")
+	}
+	return actor, nil
+}
+
+// isAdmin returns true if the request bears the admin key.
+func isAdmin(r *http.Request) bool {
+	key := os.Getenv("ADMIN_KEY")
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(given)) == 1
+}
+
+// SLOReport handles requests to /admin/slo
+//   GET /admin/slo
+//        Sets a summary of error budget burn for each service level
+//        objective as response.
+func (api API) sloReport(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/slo only accepts GET requests"}
+	}
+	return response{StatusCode: http.StatusOK, Response: slo.Default().Summaries()}
+}
+
+// CheckerStats handles requests to /admin/checker
+//   GET /admin/checker
+//        Sets the checker's network resource usage as response.
+func (api API) checkerStats(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/checker only accepts GET requests"}
+	}
+	return response{StatusCode: http.StatusOK, Response: checker.GetResourceStats()}
+}
+
+// ScanStats handles requests to /admin/scans
+//   GET /admin/scans
+//        Sets the scan scheduler's ScanSchedulerStats as response.
+func (api API) scanStats(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/scans only accepts GET requests"}
+	}
+	if api.Scans == nil {
+		return response{StatusCode: http.StatusOK, Response: ScanSchedulerStats{}}
+	}
+	return response{StatusCode: http.StatusOK, Response: api.Scans.Stats()}
+}
+
+// DeprecationStats handles requests to /admin/deprecations
+//   GET /admin/deprecations
+//        Sets each deprecated surface's DeprecationUsage since the server
+//        started as response.
+func (api API) deprecationStats(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/deprecations only accepts GET requests"}
+	}
+	return response{StatusCode: http.StatusOK, Response: deprecatedUsage.report(deprecations)}
+}
+
+// KeyQuota handles requests to /admin/keys/quota
+//   POST /admin/keys/quota
+//        id: ID of the API key.
+//        quota: Number of scans the key can request per day. 0 is unlimited.
+//        Sets the updated models.APIKey as response.
+func (api API) keyQuota(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/keys/quota only accepts POST requests"}
+	}
+	id, err := getKeyID(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	param, err := getParam("quota", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	quota, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || quota < 0 {
+		return badRequest("quota must be a non-negative integer")
+	}
+	key, err := api.Database.SetAPIKeyQuota(id, quota)
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound, Message: "no such API key"}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	return response{StatusCode: http.StatusOK, Response: key}
+}
+
+// AdminKeys handles requests to /admin/keys
+//   GET /admin/keys?email=<email>
+//        Sets the address's models.APIKeys, with usage stats, as response.
+//   POST /admin/keys
+//        email: Address to issue the key to.
+//        name (optional): Label for the new key.
+//        scopes: Scopes for the new key, which can include "list-read" and
+//          "admin-write" for the admin endpoints.
+//        actor: Who issued it, for the audit log.
+//        Sets the new models.APIKey, including its secret key, as response.
+func (api API) adminKeys(r *http.Request) response {
+	address, err := getParam("email", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if r.Method == http.MethodGet {
+		keys, err := api.Database.GetAPIKeys(address)
+		if err != nil {
+			return serverError(err.Error())
+		}
+		return response{StatusCode: http.StatusOK, Response: keys}
+	}
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/keys only accepts POST and GET requests"}
+	}
+	actor, err := getActor(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if parsed, err := mail.ParseAddress(address); err != nil || parsed.Address != address {
+		return badRequest("%s is not a valid email address", address)
+	}
+	r.ParseForm()
+	scopes, err := models.ParseAdminScopes(r.Form["scopes"])
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if len(scopes) == 0 {
+		return badRequest("query parameter scopes not specified")
+	}
+	resp := api.createKey(models.APIKey{Email: address, Name: r.FormValue("name"), Scopes: scopes})
+	if key, ok := resp.Response.(models.APIKey); ok {
+		api.audit(models.AuditEntry{Actor: actor, Action: "key.issue", Subject: address,
+			Details: fmt.Sprintf("key %d with scopes %s", key.ID, strings.Join(key.Scopes, ","))})
+	}
+	return resp
+}
+
+// AdminRevokeKey handles requests to /admin/keys/revoke
+//   POST /admin/keys/revoke
+//        id: ID of the API key to revoke.
+//        email: Address the key was issued to.
+//        actor: Who revoked it, for the audit log.
+//        Sets the revoked models.APIKey as response.
+func (api API) adminRevokeKey(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/keys/revoke only accepts POST requests"}
+	}
+	actor, err := getActor(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	id, err := getKeyID(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	address, err := getParam("email", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	key, err := api.Database.RevokeAPIKey(id, address)
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound, Message: "no such API key"}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	api.audit(models.AuditEntry{Actor: actor, Action: "key.revoke", Subject: address,
+		Details: fmt.Sprintf("key %d", id)})
+	return response{StatusCode: http.StatusOK, Response: key}
+}
+
+// Promote handles requests to /admin/promote
+//   POST /admin/promote
+//        domain: Domain queued in testing to promote to enforce. It's only
+//          promoted if it passes a final verification from every vantage
+//          point, whose evidence is recorded either way.
+//        release (optional): If "true", instead return a domain its owner
+//          held back from the list to testing, to be verified again.
+//        actor (with release): Who released it, for the audit log.
+//        Sets the models.Promotion evidence as response. If owners confirm
+//        promotions, a domain that passes is emailed a confirmation link
+//        instead, and the response is 202 Accepted.
+//   GET /admin/promote?domain=<domain>
+//        Sets the domain's models.Promotions, most recent first, as response.
+func (api API) promote(r *http.Request) response {
+	domain, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if r.Method == http.MethodGet {
+		promotions, err := api.Database.GetPromotions(domain)
+		if err != nil {
+			return serverError(err.Error())
+		}
+		return response{StatusCode: http.StatusOK, Response: promotions}
+	}
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/promote only accepts POST and GET requests"}
+	}
+	if r.FormValue("release") == "true" {
+		return api.releaseHeld(r, domain)
+	}
+	if api.Promoter == nil {
+		return response{StatusCode: http.StatusServiceUnavailable,
+			Message: "promotion vantage points aren't configured"}
+	}
+	p, err := api.Promoter.Promote(api.Database, domain)
+	if err != nil && p.Domain == "" {
+		return badRequest(err.Error())
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if p.AwaitingConfirmation {
+		return api.requestConfirmation(p)
+	}
+	if !p.Promoted {
+		return response{StatusCode: http.StatusConflict,
+			Message: "domain failed verification and wasn't promoted", Response: p}
+	}
+	api.notifyAdded(domain)
+	return response{StatusCode: http.StatusOK, Response: p}
+}
+
+// scanExplanation explains a stored scan.
+type scanExplanation struct {
+	Timestamp time.Time `json:"timestamp"`
+	checker.Explanation
+}
+
+// Explain handles requests to /admin/explain
+//   GET /admin/explain?domain=<domain>
+//        timestamp (optional): Explain the latest scan at or before this
+//          RFC 3339 time, instead of the latest scan.
+//        Sets a checker.Explanation of the rules that decided the scan's
+//        status and grade, and the scan's timestamp, as response.
+func (api API) explain(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/explain only accepts GET requests"}
+	}
+	domain, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	var scan models.Scan
+	if param := r.URL.Query().Get("timestamp"); param != "" {
+		at, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return badRequest("timestamp must be an RFC 3339 time, like 2006-01-02T15:04:05Z")
+		}
+		scan, err = api.Database.GetScanAt(domain, at)
+	} else {
+		scan, err = api.Database.GetLatestScan(domain)
+	}
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound, Message: "no such scan"}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	return response{StatusCode: http.StatusOK,
+		Response: scanExplanation{Timestamp: scan.Timestamp, Explanation: checker.Explain(scan.Data)}}
+}
+
+// OperationsReport handles requests to /admin/report
+//   GET /admin/report
+//        period (optional): "weekly" or "monthly" for the latest report of
+//          that period. Defaults to the latest of either.
+//        Sets the most recent report.Report as response.
+//   POST /admin/report
+//        period (optional, default "weekly"): "weekly" or "monthly".
+//        Generates, stores and emails a report for the week or month until
+//        now, and sets it as response.
+func (api API) operationsReport(r *http.Request) response {
+	period := report.Period(r.FormValue("period"))
+	if period != "" && period != report.Weekly && period != report.Monthly {
+		return badRequest("period must be weekly or monthly")
+	}
+	switch r.Method {
+	case http.MethodGet:
+		latest, err := api.Database.GetLatestReport(period)
+		if err == sql.ErrNoRows {
+			return response{StatusCode: http.StatusNotFound, Message: "no reports have been generated"}
+		}
+		if err != nil {
+			return serverError(err.Error())
+		}
+		return response{StatusCode: http.StatusOK, Response: latest}
+	case http.MethodPost:
+		if api.Reports == nil {
+			return response{StatusCode: http.StatusServiceUnavailable,
+				Message: "report generation isn't configured"}
+		}
+		generator := *api.Reports
+		if period != "" {
+			generator.Period = period
+		}
+		generated, err := generator.Generate(time.Now())
+		if err != nil {
+			return serverError(err.Error())
+		}
+		return response{StatusCode: http.StatusOK, Response: generated}
+	default:
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/report only accepts GET and POST requests"}
+	}
+}
+
+// History handles requests to /admin/history
+//   GET /admin/history?domain=<domain>
+//        states (optional): "true" to list only the domain's state changes.
+//        Sets the audit log entries about the domain, most recent first, as
+//        response. Each state change is a "domain.state" entry, with the old
+//        and new states and the reason in its details.
+func (api API) history(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/history only accepts GET requests"}
+	}
+	domain, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	entries, err := api.Database.GetAuditLog(domain)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if r.FormValue("states") == "true" {
+		changes := []models.AuditEntry{}
+		for _, entry := range entries {
+			if entry.Action == models.ActionStateChange {
+				changes = append(changes, entry)
+			}
+		}
+		entries = changes
+	}
+	return response{StatusCode: http.StatusOK, Response: entries}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/report"
+)
+
+func TestAdminRequiresKey(t *testing.T) {
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+
+	resp, err := http.Get(server.URL + "/admin/slo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected admin endpoint to require a key, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/admin/slo", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected admin endpoint to accept key, got %d", resp.StatusCode)
+	}
+}
+
+func TestExplainScan(t *testing.T) {
+	defer teardown()
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+
+	start := time.Now().UTC()
+	first := checker.NewSampleDomainResult("example.com")
+	first.Grade = "F"
+	api.Database.PutScan(models.Scan{Domain: "example.com", Data: first, Timestamp: start})
+	latest := checker.NewSampleDomainResult("example.com")
+	latest.Grade, _ = checker.ScoreDomain(latest)
+	api.Database.PutScan(models.Scan{Domain: "example.com", Data: latest, Timestamp: start.Add(time.Minute)})
+
+	explain := func(query string) (int, scanExplanation) {
+		req, _ := http.NewRequest("GET", server.URL+"/admin/explain?"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Response scanExplanation `json:"response"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Response
+	}
+	code, e := explain("domain=example.com")
+	if code != http.StatusOK || e.Changed || len(e.Decisions) == 0 {
+		t.Errorf("Expected latest scan to be explained, got %d %+v", code, e)
+	}
+	at := start.Add(30 * time.Second).Format(time.RFC3339)
+	code, e = explain("domain=example.com&timestamp=" + url.QueryEscape(at))
+	if code != http.StatusOK || !e.Changed || e.Grade != "F" {
+		t.Errorf("Expected earlier scan to be explained, got %d %+v", code, e)
+	}
+	if code, _ = explain("domain=example.com&timestamp=yesterday"); code != http.StatusBadRequest {
+		t.Errorf("Expected invalid timestamp to be refused, got %d", code)
+	}
+	if code, _ = explain("domain=missing.com"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a domain without scans, got %d", code)
+	}
+}
+
+func TestOperationsReport(t *testing.T) {
+	defer teardown()
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+
+	latest := func() (int, report.Report) {
+		req, _ := http.NewRequest("GET", server.URL+"/admin/report", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Response report.Report `json:"response"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Response
+	}
+	if code, _ := latest(); code != http.StatusNotFound {
+		t.Errorf("Expected 404 before any report is generated, got %d", code)
+	}
+	api.Database.PutAuditEntry(models.AuditEntry{Actor: "admin", Action: "moderation.reject", Subject: "example.com"})
+	g := report.Generator{Store: api.Database}
+	if _, err := g.Generate(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	code, r := latest()
+	if code != http.StatusOK || len(r.Actions) != 1 || !strings.Contains(r.Markdown, "moderation.reject: 1") {
+		t.Errorf("Expected latest report, got %d %+v", code, r)
+	}
+}
+
+func TestDomainHistory(t *testing.T) {
+	defer teardown()
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+
+	http.PostForm(server.URL+"/api/queue", validQueueData(true))
+	token, err := api.Database.GetTokenByDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	http.PostForm(server.URL+"/api/validate", url.Values{"token": {token}})
+	api.Database.PutAuditEntry(models.AuditEntry{Actor: "admin", Action: "domain.patch", Subject: "example.com"})
+
+	history := func(query string) (int, []models.AuditEntry) {
+		req, _ := http.NewRequest("GET", server.URL+"/admin/history?"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Response []models.AuditEntry `json:"response"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Response
+	}
+	code, entries := history("domain=example.com")
+	if code != http.StatusOK || len(entries) != 2 || entries[0].Action != "domain.patch" {
+		t.Errorf("Expected the domain's whole history, most recent first, got %d %+v", code, entries)
+	}
+	code, entries = history("domain=example.com&states=true")
+	if code != http.StatusOK || len(entries) != 1 || entries[0].Actor != models.ActorToken ||
+		entries[0].Details != "unvalidated to queued: contact email validated" {
+		t.Errorf("Expected the validation to be recorded, got %d %+v", code, entries)
+	}
+}
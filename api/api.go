@@ -1,9 +1,9 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -19,7 +19,12 @@ import (
 	"github.com/EFForg/starttls-backend/email"
 	"github.com/EFForg/starttls-backend/models"
 	"github.com/EFForg/starttls-backend/policy"
+	"github.com/EFForg/starttls-backend/promotion"
+	"github.com/EFForg/starttls-backend/report"
+	"github.com/EFForg/starttls-backend/slo"
+	"github.com/EFForg/starttls-backend/tracing"
 	"github.com/EFForg/starttls-backend/util"
+	"github.com/EFForg/starttls-backend/views"
 	raven "github.com/getsentry/raven-go"
 )
 
@@ -40,6 +45,7 @@ type checkPerformer func(API, string) (checker.DomainResult, error)
 //     status_code // HTTP status code of request
 //     message // Any error message accompanying the status_code. If 200, empty.
 //     response // Response data (as JSON) from this request.
+//     warnings // Deprecation warnings, if the request used deprecated features.
 // }
 // Any POST request accepts either URL query parameters or data value parameters,
 // and prefers the latter if both are present.
@@ -49,7 +55,63 @@ type API struct {
 	List                PolicyList
 	DontScan            map[string]bool
 	Emailer             EmailSender
-	Templates           map[string]*template.Template
+	Views               *views.Views
+	// Promoter verifies queued domains before they're promoted to enforce.
+	Promoter *promotion.Verifier
+	// ChallengeSecret signs the MX challenges hosting providers publish to
+	// enroll their customers' domains. Provider enrollment is disabled if
+	// it's empty.
+	ChallengeSecret []byte
+	// PromotionSecret signs the links in the emails asking owners to confirm
+	// their domains' promotions. If it's empty, promotions aren't confirmed
+	// by owners.
+	PromotionSecret []byte
+	// UnsubscribeSecret signs the opt-out links in the notifications sent to
+	// domains' contacts. If it's empty, notifications aren't sent.
+	UnsubscribeSecret []byte
+	// RemovalGrace is how long domains whose owners confirmed their removal
+	// stay pending removal. Defaults to models.DefaultRemovalGrace.
+	RemovalGrace time.Duration
+	// Scans caps the scans running at once, overall and per client. If nil,
+	// scans aren't limited.
+	Scans *ScanScheduler
+	// MXCoverage is how much of a domain's observed mailservers the MX
+	// patterns it's queued with must cover.
+	MXCoverage models.CoveragePolicy
+	// QueuePolicy bounds how many weeks each class of domains can be queued
+	// for.
+	QueuePolicy models.QueuePolicy
+	// Capture records a sample of requests for replay against staging. If
+	// nil, no requests are captured.
+	Capture *TrafficCapture
+	// Firehose streams sanitized scan events to researchers. If nil, the
+	// firehose is disabled.
+	Firehose *Firehose
+	// Reports generates operations reports on request. If nil, reports can
+	// only be retrieved.
+	Reports *report.Generator
+	// ScanStore caches the hostname results of scans. If nil, they're read
+	// from and written to Database.
+	ScanStore checker.ScanStore
+	// ScanCache is the cache in front of ScanStore that every scan shares,
+	// so that concurrent scans needing the same hostname only check it once.
+	// Set it with NewScanCache. If nil, hostname results aren't cached.
+	ScanCache *checker.ScanCache
+	// CheckerConfig holds the settings scans are performed with. If nil,
+	// DefaultCheckerConfig is used.
+	CheckerConfig *checker.Config
+	// DomainCache caches the full results of scans, so repeated scans of
+	// popular domains are answered straight away. If nil, domain results
+	// aren't cached.
+	DomainCache *checker.DomainCache
+	// Captcha verifies the CAPTCHA solved with each queue submission. If
+	// nil, submissions don't need one.
+	Captcha CaptchaVerifier
+	// OIDC signs maintainers in to the admin endpoints with an identity
+	// provider. If nil, they need an admin or API key.
+	OIDC *OIDC
+	// lookupTXTOverride is used to mock TXT lookups of MX challenges.
+	lookupTXTOverride func(string) ([]string, error)
 }
 
 // PolicyList interface wraps a policy-list like structure.
@@ -65,20 +127,48 @@ type EmailSender interface {
 	// SendValidation sends a validation e-mail for a particular domain,
 	// with a particular validation token.
 	SendValidation(*models.Domain, string) error
+	// SendAPIKeyVerification sends a token for verifying an email address
+	// before issuing it an API key.
+	SendAPIKeyVerification(string, string) error
+	// SendDataExportVerification sends a token for verifying an email
+	// address before exporting the data stored about it.
+	SendDataExportVerification(string, string) error
+	// SendDataErasureVerification sends a token for verifying an email
+	// address before erasing the data stored about it.
+	SendDataErasureVerification(string, string) error
+	// SendSubmissionRejected tells a domain that a reviewer rejected its
+	// flagged submission, with the reviewer's note.
+	SendSubmissionRejected(*models.Domain, string) error
+	// SendPromotionConfirmation asks a domain's owner to confirm it's ready
+	// to be promoted to enforce, with the query strings of links to confirm
+	// the promotion or hold it back, which work until the given time.
+	SendPromotionConfirmation(*models.Domain, string, string, time.Time) error
+	// SendListAdded tells a domain's contact that it was added to the list.
+	SendListAdded(*models.Domain) error
+	// SendRemovalVerification sends a token for confirming that a domain
+	// should be removed from the list, after a grace period.
+	SendRemovalVerification(*models.Domain, string, time.Duration) error
 }
 
 type response struct {
 	StatusCode   int         `json:"status_code"`
 	Message      string      `json:"message"`
 	Response     interface{} `json:"response"`
+	Warnings     []string    `json:"warnings,omitempty"`
 	templateName string      `json:"-"`
+	header       http.Header `json:"-"`
+	// code is the machine-readable error code set in v2 responses, if it's
+	// more specific than the one for StatusCode.
+	code string
+	// details is extra data for the HTML template, left out of JSON responses.
+	details interface{}
 }
 
 type apiHandler func(r *http.Request) response
 
-func (api *API) checkDomain(domain string) (checker.DomainResult, error) {
+func (api *API) checkDomain(ctx context.Context, domain string, verbose bool) (checker.DomainResult, error) {
 	if api.checkDomainOverride == nil {
-		return defaultCheck(*api, domain)
+		return defaultCheck(ctx, *api, domain, verbose)
 	}
 	return api.checkDomainOverride(*api, domain)
 }
@@ -86,9 +176,8 @@ func (api *API) checkDomain(domain string) (checker.DomainResult, error) {
 func (api *API) wrapper(handler apiHandler) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		response := handler(r)
-		if response.StatusCode == http.StatusInternalServerError {
-			packet := raven.NewPacket(response.Message, raven.NewHttp(r))
-			raven.Capture(packet, nil)
+		if !writeHeader(w, r, response) {
+			return
 		}
 		if strings.Contains(r.Header.Get("accept"), "text/html") {
 			api.writeHTML(w, response)
@@ -98,71 +187,259 @@ func (api *API) wrapper(handler apiHandler) func(w http.ResponseWriter, r *http.
 	}
 }
 
+// writeHeader reports server errors, and copies resp's headers to w. It
+// returns false if the client's cached copy of the response is still fresh,
+// in which case nothing more should be written.
+func writeHeader(w http.ResponseWriter, r *http.Request, resp response) bool {
+	if resp.StatusCode == http.StatusInternalServerError {
+		packet := raven.NewPacket(resp.Message, raven.NewHttp(r))
+		raven.Capture(packet, nil)
+	}
+	for key, values := range resp.header {
+		w.Header()[key] = values
+	}
+	if etag := resp.header.Get("ETag"); etag != "" && r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+	return true
+}
+
 func pingHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
 }
 
+// ServeMux is a request multiplexer, like *http.ServeMux, that handlers can
+// be registered with.
+type ServeMux interface {
+	http.Handler
+	Handle(pattern string, handler http.Handler)
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
 // RegisterHandlers binds API functions to the given http server,
 // and returns the resulting handler.
-func (api *API) RegisterHandlers(mux *http.ServeMux) http.Handler {
+func (api *API) RegisterHandlers(mux ServeMux) http.Handler {
 	mux.HandleFunc("/sns", HandleSESNotification(api.Database))
-	mux.HandleFunc("/api/scan", api.wrapper(api.scan))
+	mux.HandleFunc("/api/suppressions", api.wrapper(api.suppress))
+	mux.HandleFunc("/api/unsubscribe", api.wrapper(api.unsubscribe))
+	mux.HandleFunc("/api/scan", api.wrapper(deprecated(apiV1, deprecated(htmlFormPosts, api.meteredScan))))
+	mux.HandleFunc("/api/scan/diff", api.wrapper(api.scanDiff))
+	mux.HandleFunc("/api/scan/hostnames", api.wrapper(api.scanHostnames))
 	mux.Handle("/api/queue",
-		throttleHandler(time.Hour, 20, http.HandlerFunc(api.wrapper(api.queue))))
-	mux.HandleFunc("/api/validate", api.wrapper(api.validate))
+		throttleHandler(time.Hour, 20, http.HandlerFunc(api.wrapper(deprecated(apiV1, deprecated(htmlFormPosts, api.queue))))))
+	mux.Handle("/api/queue/watch",
+		throttleHandler(time.Minute, 10, http.HandlerFunc(api.wrapper(api.watch))))
+	mux.Handle("/api/queue/resend",
+		throttleHandler(time.Hour, 20, http.HandlerFunc(api.wrapper(api.resendValidation()))))
+	mux.HandleFunc("/api/validate", api.wrapper(deprecated(apiV1, api.validate)))
+	mux.HandleFunc("/api/v2/scan", api.wrapperV2(api.meteredScan, presentScan))
+	mux.Handle("/api/v2/queue",
+		throttleHandler(time.Hour, 20, http.HandlerFunc(api.wrapperV2(api.queue, presentQueue))))
+	mux.HandleFunc("/api/v2/validate", api.wrapperV2(api.validate, presentValidation))
+	mux.HandleFunc("/api/v2/", api.wrapperV2(v2NotFound, nil))
+	mux.Handle("/api/domains/search",
+		throttleHandler(time.Minute, 60, http.HandlerFunc(api.wrapper(api.searchDomains))))
+	mux.HandleFunc("/api/promotion", api.wrapper(api.promotion))
+	mux.HandleFunc("/api/provider/challenge", api.wrapper(api.withAPIKey(models.ScopeQueue, api.providerChallenge)))
+	mux.HandleFunc("/api/provider/enroll", api.wrapper(api.withAPIKey(models.ScopeQueue, api.providerEnroll)))
+	mux.HandleFunc("/api/dane/generate", api.wrapper(api.daneGenerate))
 	mux.HandleFunc("/api/stats", api.wrapper(api.stats))
+	mux.HandleFunc("/api/list", api.policyList)
+	mux.HandleFunc("/api/firehose", api.firehose)
 	mux.HandleFunc("/api/ping", pingHandler)
+	mux.HandleFunc("/api/openapi.json", openAPI)
+	mux.Handle("/api/graphql", throttleHandler(time.Hour, 100, http.HandlerFunc(api.graphQL)))
+	mux.HandleFunc("/about-scans", api.wrapper(api.aboutScans))
+	if api.Views != nil {
+		mux.Handle("/static/", http.StripPrefix("/static/", api.Views.Static()))
+	}
+	mux.Handle("/api/keys/register",
+		throttleHandler(time.Hour, 5, http.HandlerFunc(api.wrapper(api.registerForKeys))))
+	mux.HandleFunc("/api/keys/verify", api.wrapper(api.verifyForKeys))
+	mux.Handle("/api/export/request",
+		throttleHandler(time.Hour, 5, http.HandlerFunc(api.wrapper(api.requestExport))))
+	mux.HandleFunc("/api/export", api.wrapper(api.export))
+	mux.Handle("/api/erase/request",
+		throttleHandler(time.Hour, 5, http.HandlerFunc(api.wrapper(api.requestErasure))))
+	mux.HandleFunc("/api/erase", api.wrapper(api.erase))
+	mux.Handle("/api/remove/request",
+		throttleHandler(time.Hour, 5, http.HandlerFunc(api.wrapper(api.requestRemoval))))
+	mux.HandleFunc("/api/remove", api.wrapper(api.remove))
+	mux.HandleFunc("/api/keys", api.wrapper(api.withAPIKey(models.ScopeKeys, api.keys)))
+	mux.HandleFunc("/api/keys/rotate", api.wrapper(api.withAPIKey(models.ScopeKeys, api.rotateKey)))
+	mux.HandleFunc("/api/keys/revoke", api.wrapper(api.withAPIKey(models.ScopeKeys, api.revokeKey)))
+	mux.HandleFunc("/api/keys/", api.wrapper(api.withAPIKey(models.ScopeKeys, api.keyUsage)))
+	mux.HandleFunc("/api/webhooks", api.wrapper(api.byContact(api.webhooks)))
+	mux.HandleFunc("/api/webhooks/remove", api.wrapper(api.byContact(api.removeWebhook)))
+	mux.HandleFunc("/api/webhooks/deliveries", api.wrapper(api.byContact(api.webhookDeliveries)))
+	mux.HandleFunc("/admin/login", api.wrapper(api.adminLogin))
+	mux.HandleFunc("/admin/login/callback", api.wrapper(api.adminLoginCallback))
+	mux.HandleFunc("/admin/logout", api.wrapper(api.adminLogout))
+	mux.HandleFunc("/admin/slo", api.wrapper(api.adminOnly(api.sloReport)))
+	mux.HandleFunc("/admin/checker", api.wrapper(api.adminOnly(api.checkerStats)))
+	mux.HandleFunc("/admin/scans", api.wrapper(api.adminOnly(api.scanStats)))
+	mux.HandleFunc("/admin/deprecations", api.wrapper(api.adminOnly(api.deprecationStats)))
+	mux.HandleFunc("/admin/keys", api.wrapper(api.adminOnly(api.adminKeys)))
+	mux.HandleFunc("/admin/keys/revoke", api.wrapper(api.adminOnly(api.adminRevokeKey)))
+	mux.HandleFunc("/admin/keys/quota", api.wrapper(api.adminOnly(api.keyQuota)))
+	mux.HandleFunc("/admin/promote", api.wrapper(api.adminOnly(api.promote)))
+	mux.HandleFunc("/admin/moderation", api.wrapper(api.adminOnly(api.moderation)))
+	mux.HandleFunc("/admin/blocks", api.wrapper(api.adminOnly(api.blocks)))
+	mux.HandleFunc("/admin/blocks/remove", api.wrapper(api.adminOnly(api.removeBlock)))
+	mux.HandleFunc("/admin/import", api.wrapper(api.adminOnly(api.importDomains)))
+	mux.HandleFunc("/admin/domains", api.wrapper(api.adminOnly(api.adminDomains)))
+	mux.HandleFunc("/admin/domains/", api.wrapper(api.adminOnly(api.adminDomain)))
+	mux.HandleFunc("/admin/explain", api.wrapper(api.adminOnly(api.explain)))
+	mux.HandleFunc("/admin/history", api.wrapper(api.adminOnly(api.history)))
+	mux.HandleFunc("/admin/webhooks", api.wrapper(api.byMaintainer(api.webhooks)))
+	mux.HandleFunc("/admin/webhooks/remove", api.wrapper(api.byMaintainer(api.removeWebhook)))
+	mux.HandleFunc("/admin/webhooks/deliveries", api.wrapper(api.byMaintainer(api.webhookDeliveries)))
+	mux.HandleFunc("/admin/report", api.wrapper(api.adminOnly(api.operationsReport)))
+	if api.Capture != nil {
+		return middleware(api.Capture.handler(mux))
+	}
 	return middleware(mux)
 }
 
-func defaultCheck(api API, domain string) (checker.DomainResult, error) {
+func defaultCheck(ctx context.Context, api API, domain string, verbose bool) (checker.DomainResult, error) {
 	policyChan := models.Domain{Name: domain}.AsyncPolicyListCheck(api.Database, api.List)
-	c := checker.Checker{
-		Cache: &checker.ScanCache{
-			ScanStore:  api.Database,
-			ExpireTime: 5 * time.Minute,
-		},
-		Timeout: 3 * time.Second,
-	}
-	result := c.CheckDomain(domain, nil)
+	c := api.newChecker(verbose)
+	result := c.CheckDomainContext(ctx, domain, nil)
 	policyResult := <-policyChan
 	result.ExtraResults["policylist"] = &policyResult
+	result.Checker = withPolicyListCheck(result.Checker)
 	return result, nil
 }
 
+// withPolicyListCheck returns a copy of settings that also lists the policy
+// list check, which scans requested through the API perform. Cached results
+// share their settings, so they aren't changed in place.
+func withPolicyListCheck(settings *checker.Settings) *checker.Settings {
+	if settings == nil {
+		return nil
+	}
+	s := *settings
+	s.Checks = append(append([]string{}, s.Checks...), checker.PolicyList)
+	return &s
+}
+
+// DefaultCheckerConfig returns the checker settings scans requested through
+// the API are performed with, unless they're configured otherwise. Users are
+// waiting for these scans, so they time out sooner than bulk scans.
+func DefaultCheckerConfig() checker.Config {
+	cfg := checker.DefaultConfig()
+	cfg.Timeout = 3 * time.Second
+	cfg.Deadline = 30 * time.Second
+	cfg.CacheExpiry = 5 * time.Minute
+	// Slow mailservers shouldn't keep users waiting, if we've checked them
+	// recently.
+	cfg.StaleWhileRevalidate = time.Hour
+	// Popular hostnames checked around the same time shouldn't all be
+	// re-checked at once.
+	cfg.CacheJitter = time.Minute
+	return cfg
+}
+
+// NewScanCache returns the hostname scan cache configured by CheckerConfig,
+// in front of ScanStore, for ScanCache. Returns nil if caching is disabled.
+func (api API) NewScanCache() *checker.ScanCache {
+	cfg := DefaultCheckerConfig()
+	if api.CheckerConfig != nil {
+		cfg = *api.CheckerConfig
+	}
+	if cfg.CacheExpiry <= 0 {
+		return nil
+	}
+	var store checker.ScanStore = api.Database
+	if api.ScanStore != nil {
+		store = api.ScanStore
+	}
+	return &checker.ScanCache{
+		ScanStore:            store,
+		ExpireTime:           cfg.CacheExpiry,
+		StaleWhileRevalidate: cfg.StaleWhileRevalidate,
+		Jitter:               cfg.CacheJitter,
+	}
+}
+
+// newChecker returns the checker that scans requested through the API are
+// performed with. Scans record how their status and grade were decided, for
+// /admin/explain, and verbose scans record SMTP sessions.
+func (api API) newChecker(verbose bool) *checker.Checker {
+	cfg := DefaultCheckerConfig()
+	if api.CheckerConfig != nil {
+		cfg = *api.CheckerConfig
+	}
+	c := &checker.Checker{
+		Cache:              api.ScanCache,
+		DomainCache:        api.DomainCache,
+		Timeout:            cfg.Timeout,
+		Deadline:           cfg.Deadline,
+		GreylistRetries:    cfg.GreylistRetries,
+		GreylistRetryDelay: cfg.GreylistRetryDelay,
+		FeedTimeout:        cfg.FeedTimeout,
+		RecordDecisions:    true,
+	}
+	if verbose {
+		// Cached hostname results don't include transcripts.
+		c.Cache = nil
+		c.DomainCache = nil
+		c.HostnameCheck = checker.VerboseHostnameCheck
+	}
+	return c
+}
+
 // Scan is the handler for /api/scan.
 //   POST /api/scan
 //        domain: Mail domain to scan.
-//        Scans domain and returns data from it.
+//        verbose (optional): "true" to record the SMTP session with each
+//          mailserver. Always performs a new scan.
+//        Scans domain and returns data from it, or the most recent trusted
+//        scan if it's recent enough.
 //   GET /api/scan?domain=<domain>
-//        Retrieves most recent scan for domain.
+//        Retrieves the most recent trusted scan for domain (see
+//        models.Scan.Trusted), without triggering a new scan.
+//        Sets Cache-Control and ETag headers derived from the scan's age.
+//        verbose (optional): "true" to include SMTP session transcripts,
+//          if the scan recorded them.
 // Both set a models.Scan JSON as the response.
 func (api API) scan(r *http.Request) response {
 	domain, err := getASCIIDomain(r)
 	if err != nil {
 		return response{StatusCode: http.StatusBadRequest, Message: err.Error()}
 	}
+	verbose := r.FormValue("verbose") == "true"
 	// Check if we shouldn't scan this domain
-	if api.DontScan != nil {
-		if _, ok := api.DontScan[domain]; ok {
-			return response{StatusCode: http.StatusTooManyRequests}
-		}
+	if api.refusesScan(domain) {
+		return response{StatusCode: http.StatusTooManyRequests, code: errScanRefused}
 	}
 	// POST: Force scan to be conducted
 	if r.Method == http.MethodPost {
 		// 0. If last scan was recent and on same scan version, return cached scan.
-		scan, err := api.Database.GetLatestScan(domain)
-		if err == nil && scan.Version == models.ScanVersion &&
+		scan, err := api.Database.GetLatestTrustedScan(domain)
+		if err == nil && scan.Version == models.ScanVersion && !verbose &&
 			time.Now().Before(scan.Timestamp.Add(cacheScanTime)) {
+			scan.Data = scan.Data.WithoutTranscripts()
 			return response{
 				StatusCode:   http.StatusOK,
 				Response:     scan,
 				templateName: "scan",
 			}
 		}
-		// 1. Conduct scan via starttls-checker
-		scanData, err := api.checkDomain(domain)
+		// 1. Conduct scan via starttls-checker, once there's a free slot.
+		if api.Scans != nil {
+			client := scanClientOf(r)
+			if err := api.Scans.acquire(client); err != nil {
+				return response{StatusCode: http.StatusTooManyRequests, Message: err.Error(),
+					header: http.Header{"Retry-After": {"10"}}}
+			}
+			defer api.Scans.release(client)
+		}
+		start := time.Now()
+		scanData, err := api.checkDomain(r.Context(), domain, verbose)
+		slo.Record(slo.Scan, err == nil, time.Since(start))
 		if err != nil {
 			return response{StatusCode: http.StatusInternalServerError, Message: err.Error()}
 		}
@@ -171,12 +448,29 @@ func (api API) scan(r *http.Request) response {
 			Data:      scanData,
 			Timestamp: time.Now(),
 			Version:   models.ScanVersion,
+			Source:    models.SourceAPI,
+			Profile:   models.ProfileFull,
+			Checker:   scanData.Checker,
+		}
+		if !scanData.CachedAt.IsZero() {
+			// The domain was checked recently, and that scan was already
+			// stored and published.
+			scan.Timestamp = scanData.CachedAt
+			return response{
+				StatusCode:   http.StatusOK,
+				Response:     scan,
+				templateName: "scan",
+			}
 		}
 		// 2. Put scan into DB
+		_, span := tracing.Start(r.Context(), "db.put_scan", "domain", domain)
 		err = api.Database.PutScan(scan)
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			return response{StatusCode: http.StatusInternalServerError, Message: err.Error()}
 		}
+		api.Firehose.Publish(scan)
 		return response{
 			StatusCode:   http.StatusOK,
 			Response:     scan,
@@ -184,17 +478,42 @@ func (api API) scan(r *http.Request) response {
 		}
 		// GET: Just fetch the most recent scan
 	} else if r.Method == http.MethodGet {
-		scan, err := api.Database.GetLatestScan(domain)
+		scan, err := api.Database.GetLatestTrustedScan(domain)
 		if err != nil {
 			return response{StatusCode: http.StatusNotFound, Message: err.Error()}
 		}
-		return response{StatusCode: http.StatusOK, Response: scan}
+		header := scanCacheHeader(scan, time.Now())
+		if verbose {
+			header.Set("ETag", strings.TrimSuffix(header.Get("ETag"), "\"")+"-verbose\"")
+		} else {
+			scan.Data = scan.Data.WithoutTranscripts()
+		}
+		return response{
+			StatusCode:   http.StatusOK,
+			Response:     scan,
+			templateName: "scan",
+			header:       header,
+		}
 	} else {
 		return response{StatusCode: http.StatusMethodNotAllowed,
 			Message: "/api/scan only accepts POST and GET requests"}
 	}
 }
 
+// scanCacheHeader returns HTTP caching headers for a stored scan. Clients may
+// reuse the scan until a POST to /api/scan would trigger a fresh one.
+func scanCacheHeader(scan models.Scan, now time.Time) http.Header {
+	maxAge := scan.Timestamp.Add(cacheScanTime).Sub(now)
+	if maxAge < 0 || scan.Version != models.ScanVersion {
+		maxAge = 0
+	}
+	header := http.Header{}
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	header.Set("ETag", fmt.Sprintf("\"%s-%d-%d\"", scan.Domain, scan.Timestamp.Unix(), scan.Version))
+	header.Set("Last-Modified", scan.Timestamp.UTC().Format(http.TimeFormat))
+	return header
+}
+
 // MaxHostnames is the maximum number of hostnames that can be specified for a single domain's TLS policy.
 const MaxHostnames = 8
 
@@ -217,7 +536,8 @@ func getDomainParams(r *http.Request) (models.Domain, error) {
 	} else {
 		domain.Email = email.ValidationAddress(&domain)
 	}
-	queueWeeks, err := getInt("weeks", r, 4, 52, 4)
+	// The domain's class decides the default and bounds.
+	queueWeeks, err := getInt("weeks", r, 1, 53, 0)
 	if err != nil {
 		return domain, err
 	}
@@ -250,7 +570,18 @@ func getDomainParams(r *http.Request) (models.Domain, error) {
 //        hostnames: List of MX hostnames to put into this domain's TLS policy. Up to 8.
 //        Sets models.Domain object as response.
 //        weeks (optional, default 4): How many weeks is this domain queued for.
+//          Must be within the bounds api.QueuePolicy sets for its class.
 //        email (optional): Contact email associated with domain.
+//        captcha: Token from a solved CAPTCHA, if api.Captcha is set. The
+//          hCaptcha and reCAPTCHA widgets' own fields are accepted too.
+//          Invalid tokens are refused, responding 403.
+//        If the hostnames don't cover enough of the domain's mailservers,
+//        sets the models.MXCoverage shortfall as response.
+//        Submissions that come close to a domain on the no-scan list are
+//        held for review, responding 202, before the validation email is sent.
+//        Submissions matching the blocklist are refused, responding 403.
+//        Submissions are refused if the domain's postmaster or contact
+//        email is on the suppression list.
 //   GET  /api/queue?domain=<domain>
 //        Sets models.Domain object as response.
 func (api API) queue(r *http.Request) response {
@@ -260,22 +591,58 @@ func (api API) queue(r *http.Request) response {
 		if err != nil {
 			return badRequest(err.Error())
 		}
-		ok, msg, scan := domain.IsQueueable(api.Database, api.Database, api.List)
+		if refused := api.checkCaptcha(r); refused != nil {
+			return *refused
+		}
+		if domain.QueueWeeks == 0 {
+			domain.QueueWeeks = api.QueuePolicy.Class(domain.Name).DefaultWeeks()
+		}
+		if err = api.QueuePolicy.CheckWeeks(domain); err != nil {
+			return badRequest(err.Error())
+		}
+		block, err := api.blockingSubmission(domain)
+		if err != nil {
+			return serverError(err.Error())
+		}
+		if block != nil {
+			return response{StatusCode: http.StatusForbidden, code: errSubmissionBlocked,
+				Message: fmt.Sprintf("Submissions for %s aren't accepted.", domain.Name)}
+		}
+		suppressed, err := api.suppressedAddress(domain)
+		if err != nil {
+			return serverError(err.Error())
+		}
+		if suppressed != "" {
+			refused := badRequest("%s has bounced or unsubscribed from our emails, so we can't email it about %s", suppressed, domain.Name)
+			refused.code = errEmailSuppressed
+			return refused
+		}
+		ok, msg, scan, coverage := domain.IsQueueable(api.Database, api.Database, api.List, api.MXCoverage)
 		if !ok {
-			return badRequest(msg)
+			return response{StatusCode: http.StatusBadRequest, Message: msg, Response: coverage, code: errNotQueueable}
+		}
+		if coverage.Partial() {
+			api.audit(models.AuditEntry{Actor: "queue", Action: "coverage.partial", Subject: domain.Name,
+				Details: fmt.Sprintf("MX patterns %v don't cover %v (%.0f%% covered, %.0f%% required)",
+					domain.MXs, coverage.Uncovered, coverage.Percent, coverage.RequiredPercent)})
 		}
 		domain.PopulateFromScan(scan)
+		if reasons := domain.ModerationFlags(api.DontScan); len(reasons) > 0 {
+			return api.flagSubmission(domain, reasons)
+		}
 		token, err := domain.InitializeWithToken(api.Database, api.Database)
 		if err != nil {
 			return serverError(err.Error())
 		}
-		if err = api.Emailer.SendValidation(&domain, token); err != nil {
+		if err = api.Emailer.SendValidation(&domain, token.Token); err != nil {
 			log.Print(err)
 			return serverError("Unable to send validation e-mail")
 		}
 		return response{
-			StatusCode: http.StatusOK,
-			Response:   fmt.Sprintf("Thank you for submitting your domain. Please check postmaster@%s to validate that you control the domain.", domain.Name),
+			StatusCode:   http.StatusOK,
+			Response:     fmt.Sprintf("Thank you for submitting your domain. Please check %s to validate that you control the domain.", email.ValidationAddress(&domain)),
+			templateName: "queued",
+			details:      newQueueConfirmation(domain, token, time.Now()),
 		}
 	}
 	// GET: Retrieve domain status from queue
@@ -328,6 +695,11 @@ func getASCIIDomain(r *http.Request) (string, error) {
 	if err != nil {
 		return domain, err
 	}
+	return asciiDomain(domain)
+}
+
+// asciiDomain converts a lowercase domain name to ASCII.
+func asciiDomain(domain string) (string, error) {
 	ascii, err := idna.ToASCII(domain)
 	if err != nil {
 		return "", fmt.Errorf("could not convert domain %s to ASCII (%s)", domain, err)
@@ -335,6 +707,12 @@ func getASCIIDomain(r *http.Request) (string, error) {
 	return ascii, nil
 }
 
+// refusesScan returns true if domain is on the list of domains we don't scan.
+func (api API) refusesScan(domain string) bool {
+	_, ok := api.DontScan[domain]
+	return ok
+}
+
 // Retrieves and lowercases `param` as a query parameter from `http.Request` r.
 // If fails, then returns an error.
 func getParam(param string, r *http.Request) (string, error) {
@@ -380,18 +758,17 @@ func (api *API) writeJSON(w http.ResponseWriter, apiResponse response) {
 	fmt.Fprintf(w, "%s\n", b)
 }
 
-// ParseTemplates initializes our HTML template data
+// ParseTemplates initializes our HTML templates and static assets from dir,
+// or from those embedded in the binary if dir is empty. Templates in dir are
+// reloaded whenever they're used, so they can be edited without restarting.
 func (api *API) ParseTemplates(dir string) {
-	names := []string{"default", "scan"}
-	api.Templates = make(map[string]*template.Template)
+	names := []string{"default", "scan", "queued"}
+	api.Views = views.New(dir)
 	for _, name := range names {
-		path := fmt.Sprintf("%s/%s.html.tmpl", dir, name)
-		tmpl, err := template.ParseFiles(path)
-		if err != nil {
+		if _, err := api.Views.HTML(name); err != nil {
 			raven.CaptureError(err, nil)
 			log.Fatal(err)
 		}
-		api.Templates[name] = tmpl
 	}
 }
 
@@ -401,23 +778,25 @@ func (api *API) writeHTML(w http.ResponseWriter, apiResponse response) {
 		response
 		BaseURL    string
 		StatusText string
+		Details    interface{}
 	}{
 		response:   apiResponse,
 		BaseURL:    os.Getenv("FRONTEND_WEBSITE_LINK"),
 		StatusText: http.StatusText(apiResponse.StatusCode),
+		Details:    apiResponse.details,
 	}
 	if apiResponse.templateName == "" {
 		apiResponse.templateName = "default"
 	}
-	tmpl, ok := api.Templates[apiResponse.templateName]
-	if !ok {
-		err := fmt.Errorf("Template not found: %s", apiResponse.templateName)
+	tmpl, err := api.Views.HTML(apiResponse.templateName)
+	if err != nil {
+		err = fmt.Errorf("Template not found: %s: %v", apiResponse.templateName, err)
 		raven.CaptureError(err, nil)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(apiResponse.StatusCode)
-	err := tmpl.Execute(w, data)
+	err = tmpl.Execute(w, data)
 	if err != nil {
 		log.Println(err)
 		raven.CaptureError(err, nil)
@@ -479,7 +858,7 @@ func HandleSESNotification(database db.Database) func(http.ResponseWriter, *http
 		raven.CaptureMessage("Received SES notification", tags, ravenExtraContent(data.Raw))
 
 		for _, recipient := range data.Recipients {
-			err = database.PutBlacklistedEmail(recipient.EmailAddress, data.Reason, data.Timestamp)
+			err = database.PutBlacklistedEmail(recipient.EmailAddress, strings.ToLower(data.Reason), data.Timestamp)
 			if err != nil {
 				raven.CaptureError(err, nil)
 			}
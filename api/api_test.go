@@ -21,9 +21,20 @@ import (
 var api *API
 var server *httptest.Server
 
+// handler serves the test server's requests. Handlers copy the API when
+// they're registered, so tests that change its settings call rebind.
+var handler http.Handler
+
+func rebind() {
+	handler = api.RegisterHandlers(http.NewServeMux())
+}
+
 func mockCheckPerform(message string) func(API, string) (checker.DomainResult, error) {
 	return func(api API, domain string) (checker.DomainResult, error) {
-		return checker.NewSampleDomainResult(domain), nil
+		result := checker.NewSampleDomainResult(domain)
+		settings := api.newChecker(false).Settings()
+		result.Checker = withPolicyListCheck(&settings)
+		return result, nil
 	}
 }
 
@@ -58,6 +69,65 @@ type mockEmailer struct{}
 
 func (e mockEmailer) SendValidation(domain *models.Domain, token string) error { return nil }
 
+// lastAPIKeyToken records the most recent API key verification token sent.
+var lastAPIKeyToken string
+
+func (e mockEmailer) SendAPIKeyVerification(address string, token string) error {
+	lastAPIKeyToken = token
+	return nil
+}
+
+// lastExportToken records the most recent data export verification token
+// sent.
+var lastExportToken string
+
+func (e mockEmailer) SendDataExportVerification(address string, token string) error {
+	lastExportToken = token
+	return nil
+}
+
+// lastErasureToken records the most recent data erasure verification token
+// sent.
+var lastErasureToken string
+
+func (e mockEmailer) SendDataErasureVerification(address string, token string) error {
+	lastErasureToken = token
+	return nil
+}
+
+// lastRejected records the domain of the most recent rejection email sent.
+var lastRejected string
+
+func (e mockEmailer) SendSubmissionRejected(domain *models.Domain, note string) error {
+	lastRejected = domain.Name
+	return nil
+}
+
+// lastConfirm and lastHold record the links in the most recent promotion
+// confirmation email sent.
+var lastConfirm, lastHold string
+
+func (e mockEmailer) SendPromotionConfirmation(domain *models.Domain, confirm string, hold string, expires time.Time) error {
+	lastConfirm, lastHold = confirm, hold
+	return nil
+}
+
+// lastAdded records the domain of the most recent list addition email sent.
+var lastAdded string
+
+func (e mockEmailer) SendListAdded(domain *models.Domain) error {
+	lastAdded = domain.Name
+	return nil
+}
+
+// lastRemovalToken records the most recent removal verification token sent.
+var lastRemovalToken string
+
+func (e mockEmailer) SendRemovalVerification(domain *models.Domain, token string, grace time.Duration) error {
+	lastRemovalToken = token
+	return nil
+}
+
 func testHTMLPost(path string, data url.Values, t *testing.T) ([]byte, int) {
 	req, err := http.NewRequest("POST", server.URL+path, strings.NewReader(data.Encode()))
 	if err != nil {
@@ -87,6 +157,9 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if _, err = sqldb.Migrate(); err != nil {
+		log.Fatal(err)
+	}
 	fakeList := map[string]bool{
 		"eff.org": true,
 	}
@@ -98,8 +171,10 @@ func TestMain(m *testing.M) {
 		DontScan:            map[string]bool{"dontscan.com": true},
 	}
 	api.ParseTemplates("../views")
-	mux := http.NewServeMux()
-	server = httptest.NewServer(api.RegisterHandlers(mux))
+	rebind()
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
 	defer server.Close()
 	code := m.Run()
 	os.Exit(code)
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// Blocks handles requests to /admin/blocks
+//   GET /admin/blocks
+//        Sets every models.SubmissionBlock, oldest first, as response.
+//   POST /admin/blocks
+//        kind: "domain" to block a domain and its subdomains, or "email"
+//          to block contact email addresses matching a glob pattern.
+//        pattern: Domain or email pattern to block, eg. "*@mailinator.com".
+//        reason (optional): Why it's blocked.
+//        actor: Who blocked it, for the audit log.
+//        Sets the models.SubmissionBlock as response.
+func (api API) blocks(r *http.Request) response {
+	if r.Method == http.MethodGet {
+		blocks, err := api.Database.GetSubmissionBlocks()
+		if err != nil {
+			return serverError(err.Error())
+		}
+		return response{StatusCode: http.StatusOK, Response: blocks}
+	}
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/blocks only accepts POST and GET requests"}
+	}
+	actor, err := getActor(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	block := models.SubmissionBlock{
+		Kind:    r.FormValue("kind"),
+		Pattern: r.FormValue("pattern"),
+		Reason:  r.FormValue("reason"),
+	}
+	if err := block.Validate(); err != nil {
+		return badRequest(err.Error())
+	}
+	block, err = api.Database.PutSubmissionBlock(block)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	api.audit(models.AuditEntry{Actor: actor, Action: "blocklist.add",
+		Subject: block.Pattern, Details: fmt.Sprintf("%s: %s", block.Kind, block.Reason)})
+	return response{StatusCode: http.StatusOK, Response: block}
+}
+
+// RemoveBlock handles requests to /admin/blocks/remove
+//   POST /admin/blocks/remove
+//        id: ID of the block to remove.
+//        actor: Who removed it, for the audit log.
+//        Sets the removed models.SubmissionBlock as response.
+func (api API) removeBlock(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/blocks/remove only accepts POST requests"}
+	}
+	actor, err := getActor(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	id, err := getKeyID(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	block, err := api.Database.RemoveSubmissionBlock(id)
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound, Message: "no such block"}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	api.audit(models.AuditEntry{Actor: actor, Action: "blocklist.remove",
+		Subject: block.Pattern, Details: block.Kind})
+	return response{StatusCode: http.StatusOK, Response: block}
+}
+
+// blockingSubmission returns the block refusing submissions of the domain,
+// or nil if none do.
+func (api API) blockingSubmission(domain models.Domain) (*models.SubmissionBlock, error) {
+	blocks, err := api.Database.GetSubmissionBlocks()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't check the submission blocklist: %v", err)
+	}
+	for _, block := range blocks {
+		if block.Blocks(domain) {
+			return &block, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func blocksRequest(t *testing.T, path string, data url.Values) (*http.Response, models.SubmissionBlock) {
+	req, _ := http.NewRequest("POST", server.URL+path, strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Response models.SubmissionBlock `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp, body.Response
+}
+
+func TestBlocklist(t *testing.T) {
+	defer teardown()
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+
+	if resp, _ := blocksRequest(t, "/admin/blocks", url.Values{"kind": {"email"}, "pattern": {"*@fake-email.org"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a block without an actor to be refused, got %d", resp.StatusCode)
+	}
+	if resp, _ := blocksRequest(t, "/admin/blocks", url.Values{"kind": {"email"}, "pattern": {"fake-email.org"}, "actor": {"alice"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid pattern to be refused, got %d", resp.StatusCode)
+	}
+	resp, block := blocksRequest(t, "/admin/blocks", url.Values{"kind": {"email"}, "pattern": {"*@Fake-Email.org"},
+		"reason": {"Junk submissions"}, "actor": {"alice"}})
+	if resp.StatusCode != http.StatusOK || block.Pattern != "*@fake-email.org" {
+		t.Fatalf("Blocking the pattern failed with %d: %+v", resp.StatusCode, block)
+	}
+	entries, _ := api.Database.GetAuditLog("*@fake-email.org")
+	if len(entries) != 1 || entries[0].Action != "blocklist.add" || entries[0].Actor != "alice" {
+		t.Errorf("Expected the block to be audited, got %+v", entries)
+	}
+
+	resp, err := http.PostForm(server.URL+"/api/queue", validQueueData(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a blocked submission to be refused, got %d", resp.StatusCode)
+	}
+	if _, err := api.Database.GetDomain("example.com", models.StateUnconfirmed); err == nil {
+		t.Error("Expected the refused submission not to be stored")
+	}
+
+	id := strconv.FormatInt(block.ID, 10)
+	if resp, _ = blocksRequest(t, "/admin/blocks/remove", url.Values{"id": {id}, "actor": {"alice"}}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Removing the block failed with %d", resp.StatusCode)
+	}
+	if resp, _ = blocksRequest(t, "/admin/blocks/remove", url.Values{"id": {id}, "actor": {"alice"}}); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected removing a missing block to 404, got %d", resp.StatusCode)
+	}
+	if blocks, _ := api.Database.GetSubmissionBlocks(); len(blocks) != 0 {
+		t.Errorf("Expected the blocklist to be empty, got %+v", blocks)
+	}
+}
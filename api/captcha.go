@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Siteverify endpoints of the CAPTCHA providers CaptchaFromEnv supports.
+const (
+	hCaptchaVerifyURL  = "https://hcaptcha.com/siteverify"
+	reCAPTCHAVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+)
+
+// captchaFields are the form fields a CAPTCHA token is read from: our own,
+// then the ones the hCaptcha and reCAPTCHA widgets add to forms.
+var captchaFields = []string{"captcha", "h-captcha-response", "g-recaptcha-response"}
+
+// ErrCaptchaRejected is returned by a CaptchaVerifier for missing, invalid,
+// or already used tokens.
+var ErrCaptchaRejected = errors.New("CAPTCHA verification failed; please try again")
+
+// CaptchaVerifier checks the CAPTCHA tokens solved by people submitting
+// forms. Verify returns ErrCaptchaRejected if token isn't a valid solution,
+// and any other error if it couldn't be checked. remoteIP is the address the
+// token was submitted from, which providers use as an extra signal.
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, token string, remoteIP string) error
+}
+
+// SiteverifyCaptcha verifies tokens with a provider's siteverify endpoint,
+// which hCaptcha and reCAPTCHA both implement.
+type SiteverifyCaptcha struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+var captchaClient = &http.Client{Timeout: 10 * time.Second}
+
+// NewHCaptcha returns a verifier for hCaptcha tokens, using the site's
+// secret key.
+func NewHCaptcha(secret string) *SiteverifyCaptcha {
+	return &SiteverifyCaptcha{URL: hCaptchaVerifyURL, Secret: secret}
+}
+
+// NewReCAPTCHA returns a verifier for reCAPTCHA tokens, using the site's
+// secret key.
+func NewReCAPTCHA(secret string) *SiteverifyCaptcha {
+	return &SiteverifyCaptcha{URL: reCAPTCHAVerifyURL, Secret: secret}
+}
+
+// Verify asks the provider whether token is a valid solution.
+func (c *SiteverifyCaptcha) Verify(ctx context.Context, token string, remoteIP string) error {
+	if token == "" {
+		return ErrCaptchaRejected
+	}
+	form := url.Values{"secret": {c.Secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := c.Client
+	if client == nil {
+		client = captchaClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("couldn't reach CAPTCHA provider: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CAPTCHA provider responded with %s", resp.Status)
+	}
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("couldn't read CAPTCHA provider's response: %v", err)
+	}
+	if !result.Success {
+		return ErrCaptchaRejected
+	}
+	return nil
+}
+
+// CaptchaFromEnv returns a verifier for CAPTCHA_PROVIDER, "hcaptcha" or
+// "recaptcha", with the secret key CAPTCHA_SECRET. It returns nil if
+// CAPTCHA_PROVIDER isn't set.
+func CaptchaFromEnv() (CaptchaVerifier, error) {
+	provider := os.Getenv("CAPTCHA_PROVIDER")
+	if provider == "" {
+		return nil, nil
+	}
+	secret := os.Getenv("CAPTCHA_SECRET")
+	if secret == "" {
+		return nil, errors.New("CAPTCHA_SECRET must be set with CAPTCHA_PROVIDER")
+	}
+	switch strings.ToLower(provider) {
+	case "hcaptcha":
+		return NewHCaptcha(secret), nil
+	case "recaptcha":
+		return NewReCAPTCHA(secret), nil
+	}
+	return nil, fmt.Errorf("CAPTCHA_PROVIDER must be hcaptcha or recaptcha, not %s", provider)
+}
+
+// checkCaptcha verifies the CAPTCHA token submitted with r, if the API
+// requires one. It returns nil if the token is valid, or a response refusing
+// the request otherwise.
+func (api API) checkCaptcha(r *http.Request) *response {
+	if api.Captcha == nil {
+		return nil
+	}
+	var token string
+	for _, field := range captchaFields {
+		if token = r.FormValue(field); token != "" {
+			break
+		}
+	}
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	err = api.Captcha.Verify(r.Context(), token, remoteIP)
+	if err == ErrCaptchaRejected {
+		return &response{StatusCode: http.StatusForbidden, Message: err.Error(), code: errCaptchaRejected}
+	}
+	if err != nil {
+		log.Print(err)
+		return &response{StatusCode: http.StatusServiceUnavailable,
+			Message: "Unable to verify the CAPTCHA; please try again later"}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+type mockCaptcha struct {
+	err       error
+	lastToken string
+}
+
+func (c *mockCaptcha) Verify(_ context.Context, token string, _ string) error {
+	c.lastToken = token
+	if token != "solved" {
+		return ErrCaptchaRejected
+	}
+	return c.err
+}
+
+func TestSiteverifyCaptcha(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("response") == "down" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		success := r.FormValue("secret") == "secret" && r.FormValue("response") == "solved" &&
+			r.FormValue("remoteip") == "192.0.2.1"
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": success})
+	}))
+	defer provider.Close()
+	c := NewHCaptcha("secret")
+	c.URL = provider.URL
+
+	if err := c.Verify(context.Background(), "solved", "192.0.2.1"); err != nil {
+		t.Errorf("Expected a solved CAPTCHA to be verified, got %v", err)
+	}
+	for _, token := range []string{"", "wrong"} {
+		if err := c.Verify(context.Background(), token, "192.0.2.1"); err != ErrCaptchaRejected {
+			t.Errorf("Expected token %q to be rejected, got %v", token, err)
+		}
+	}
+	if err := c.Verify(context.Background(), "down", "192.0.2.1"); err == nil || err == ErrCaptchaRejected {
+		t.Errorf("Expected a provider error, got %v", err)
+	}
+}
+
+func TestCaptchaFromEnv(t *testing.T) {
+	if c, err := CaptchaFromEnv(); c != nil || err != nil {
+		t.Errorf("Expected CAPTCHAs to be disabled by default, got %v, %v", c, err)
+	}
+	os.Setenv("CAPTCHA_PROVIDER", "reCAPTCHA")
+	defer os.Unsetenv("CAPTCHA_PROVIDER")
+	if _, err := CaptchaFromEnv(); err == nil {
+		t.Error("Expected a provider without a secret to be refused")
+	}
+	os.Setenv("CAPTCHA_SECRET", "secret")
+	defer os.Unsetenv("CAPTCHA_SECRET")
+	c, err := CaptchaFromEnv()
+	if err != nil || c.(*SiteverifyCaptcha).URL != reCAPTCHAVerifyURL {
+		t.Errorf("Expected a reCAPTCHA verifier, got %+v, %v", c, err)
+	}
+	os.Setenv("CAPTCHA_PROVIDER", "other")
+	if _, err := CaptchaFromEnv(); err == nil {
+		t.Error("Expected an unknown provider to be refused")
+	}
+}
+
+func TestQueueCaptcha(t *testing.T) {
+	defer teardown()
+	captcha := &mockCaptcha{}
+	api.Captcha = captcha
+	rebind()
+	defer func() { api.Captcha = nil; rebind() }()
+
+	requestData := validQueueData(true)
+	if resp, _ := http.PostForm(server.URL+"/api/queue", requestData); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a submission without a CAPTCHA to be refused, got %d", resp.StatusCode)
+	}
+	requestData.Set("h-captcha-response", "solved")
+	if resp, _ := http.PostForm(server.URL+"/api/queue", requestData); resp.StatusCode != http.StatusOK || captcha.lastToken != "solved" {
+		t.Errorf("Expected a submission with a solved CAPTCHA to be queued, got %d", resp.StatusCode)
+	}
+	teardown()
+	captcha.err = errors.New("provider down")
+	if resp, _ := http.PostForm(server.URL+"/api/queue", requestData); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected a submission to be refused if the CAPTCHA can't be verified, got %d", resp.StatusCode)
+	}
+}
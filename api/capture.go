@@ -0,0 +1,208 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCaptureRate is the share of requests captured if
+// TRAFFIC_CAPTURE_RATE isn't set.
+const defaultCaptureRate = 0.01
+
+// maxCapturedBody is the largest request body whose form parameters are
+// captured.
+const maxCapturedBody = 64 * 1024
+
+// CapturedRequest is an anonymized record of a request to the API, which can
+// be replayed against a staging instance with cmd/replay.
+type CapturedRequest struct {
+	Time   time.Time  `json:"time"`
+	Method string     `json:"method"`
+	Path   string     `json:"path"`
+	Query  url.Values `json:"query,omitempty"`
+	Form   url.Values `json:"form,omitempty"`
+	Accept string     `json:"accept,omitempty"`
+	// Client and Key are pseudonyms for the requester's IP address and API
+	// key, stable for the life of the process.
+	Client string `json:"client"`
+	Key    string `json:"key,omitempty"`
+	// Status and DurationMillis describe the response we gave.
+	Status         int   `json:"status"`
+	DurationMillis int64 `json:"duration_ms"`
+}
+
+// TrafficCapture writes a sample of API requests as JSON lines, with
+// addresses, API keys, tokens and email addresses replaced by pseudonyms.
+// Admin endpoints and SES notifications are never captured.
+type TrafficCapture struct {
+	// Rate is the share of requests captured, between 0 and 1.
+	Rate float64
+
+	secret []byte
+	mu     sync.Mutex
+	out    io.Writer
+	sample func() float64
+}
+
+// NewTrafficCapture returns a capture that writes a share rate of requests to
+// out.
+func NewTrafficCapture(out io.Writer, rate float64) *TrafficCapture {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal(err)
+	}
+	return &TrafficCapture{Rate: rate, secret: secret, out: out, sample: mathrand.Float64}
+}
+
+// TrafficCaptureFromEnv returns a capture appending to the file at
+// TRAFFIC_CAPTURE_FILE, sampling TRAFFIC_CAPTURE_RATE of requests. It returns
+// nil if TRAFFIC_CAPTURE_FILE isn't set.
+func TrafficCaptureFromEnv() (*TrafficCapture, error) {
+	path := os.Getenv("TRAFFIC_CAPTURE_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	rate := defaultCaptureRate
+	if value := os.Getenv("TRAFFIC_CAPTURE_RATE"); value != "" {
+		var err error
+		rate, err = strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("TRAFFIC_CAPTURE_RATE must be a number between 0 and 1")
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewTrafficCapture(f, rate), nil
+}
+
+// pseudonym returns a stable stand-in for value, which can't be reversed
+// without the capture's secret.
+func (c *TrafficCapture) pseudonym(prefix string, value string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(value))
+	return prefix + "-" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// anonymize replaces the values of sensitive parameters.
+func (c *TrafficCapture) anonymize(params url.Values) url.Values {
+	if len(params) == 0 {
+		return nil
+	}
+	anonymized := url.Values{}
+	for name, values := range params {
+		for _, value := range values {
+			switch name {
+			case "email":
+				value = c.pseudonym("user", value) + "@example.com"
+			case "token", "key", "secret", "captcha", "h-captcha-response", "g-recaptcha-response":
+				value = "redacted"
+			}
+			anonymized.Add(name, value)
+		}
+	}
+	return anonymized
+}
+
+// record builds the anonymized record of r.
+func (c *TrafficCapture) record(r *http.Request, form url.Values) CapturedRequest {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	captured := CapturedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  c.anonymize(r.URL.Query()),
+		Form:   c.anonymize(form),
+		Accept: r.Header.Get("Accept"),
+		Client: c.pseudonym("client", host),
+	}
+	if key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); key != "" {
+		captured.Key = c.pseudonym("key", key)
+	}
+	return captured
+}
+
+func (c *TrafficCapture) write(captured CapturedRequest) {
+	line, err := json.Marshal(captured)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err = c.out.Write(append(line, '\n')); err != nil {
+		log.Printf("couldn't capture request: %v", err)
+	}
+}
+
+// readForm returns the form parameters in r's body, leaving the body to be
+// read again by the handler.
+func readForm(r *http.Request) url.Values {
+	if r.Body == nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxCapturedBody+1))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil || len(body) > maxCapturedBody {
+		return nil
+	}
+	form, _ := url.ParseQuery(string(body))
+	return form
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// handler captures a sample of the requests served by next.
+func (c *TrafficCapture) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") || r.URL.Path == "/sns" ||
+			r.URL.Path == "/api/suppressions" || c.sample() >= c.Rate {
+			next.ServeHTTP(w, r)
+			return
+		}
+		captured := c.record(r, readForm(r))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+		captured.Time = start.UTC()
+		captured.Status = recorder.status
+		captured.DurationMillis = time.Since(start).Milliseconds()
+		c.write(captured)
+	})
+}
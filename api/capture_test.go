@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTrafficCaptureAnonymizes(t *testing.T) {
+	var out bytes.Buffer
+	capture := NewTrafficCapture(&out, 1)
+	var handlerBody string
+	handler := capture.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		handlerBody = string(body)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	form := url.Values{"domain": {"example.com"}, "email": {"alice@example.com"}, "token": {"abc123"}}
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/api/queue?weeks=4", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer secret-key")
+		r.RemoteAddr = "192.0.2.1:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if handlerBody != form.Encode() {
+		t.Errorf("Expected handler to read the full body, got %q", handlerBody)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 captured requests, got %d", len(lines))
+	}
+	for _, secret := range []string{"alice", "abc123", "secret-key", "192.0.2.1"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("Expected %s to be left out of the capture", secret)
+		}
+	}
+	var first, second CapturedRequest
+	json.Unmarshal([]byte(lines[0]), &first)
+	json.Unmarshal([]byte(lines[1]), &second)
+	if first.Path != "/api/queue" || first.Query.Get("weeks") != "4" || first.Form.Get("domain") != "example.com" {
+		t.Errorf("Expected request to be captured, got %+v", first)
+	}
+	if first.Status != http.StatusTeapot {
+		t.Errorf("Expected status %d, got %d", http.StatusTeapot, first.Status)
+	}
+	if !strings.HasSuffix(first.Form.Get("email"), "@example.com") || first.Form.Get("token") != "redacted" {
+		t.Errorf("Expected sensitive parameters to be replaced, got %v", first.Form)
+	}
+	// Pseudonyms are stable, so requests from one client can be grouped.
+	if first.Client != second.Client || first.Key == "" || first.Key != second.Key {
+		t.Errorf("Expected stable pseudonyms, got %+v and %+v", first, second)
+	}
+}
+
+func TestTrafficCaptureSkipsAdmin(t *testing.T) {
+	var out bytes.Buffer
+	capture := NewTrafficCapture(&out, 1)
+	handler := capture.handler(http.NewServeMux())
+	for _, path := range []string{"/admin/scans", "/sns", "/api/suppressions"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected admin requests not to be captured, got %s", out.String())
+	}
+}
+
+func TestTrafficCaptureSamples(t *testing.T) {
+	var out bytes.Buffer
+	capture := NewTrafficCapture(&out, 0.5)
+	samples := []float64{0.2, 0.7}
+	capture.sample = func() float64 {
+		sample := samples[0]
+		samples = samples[1:]
+		return sample
+	}
+	handler := capture.handler(http.NewServeMux())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/scan?domain=a.com", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/scan?domain=b.com", nil))
+	if !strings.Contains(out.String(), "a.com") || strings.Contains(out.String(), "b.com") {
+		t.Errorf("Expected only the first request to be sampled, got %s", out.String())
+	}
+}
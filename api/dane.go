@@ -0,0 +1,101 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/util"
+)
+
+// certRenewalWarning is how soon before a certificate expires we remind
+// operators that renewing it may change its key.
+const certRenewalWarning = 30 * 24 * time.Hour
+
+// daneRecords are the TLSA records we recommend for a mailserver.
+type daneRecords struct {
+	Hostname string `json:"hostname"`
+	// Domain and ScannedAt identify the scan the certificate was observed in.
+	Domain       string               `json:"domain"`
+	ScannedAt    time.Time            `json:"scanned_at"`
+	CertNotAfter *time.Time           `json:"cert_not_after,omitempty"`
+	Records      []checker.TLSARecord `json:"records"`
+	// Guidance explains how to publish the records and roll keys over.
+	Guidance []string `json:"guidance"`
+}
+
+// daneGuidance explains how to publish TLSA records for a mailserver, and
+// keep them valid when its certificate changes.
+func daneGuidance(result checker.HostnameResult, now time.Time) []string {
+	guidance := []string{
+		"TLSA records only take effect in a DNSSEC-signed zone. Sign the zone that contains your MX hostname before publishing them.",
+		"The 3 1 1 record pins your mailserver's public key. It stays valid across certificate renewals, as long as the key is reused.",
+	}
+	if result.IssuerSPKISHA256 != "" {
+		guidance = append(guidance,
+			"The 2 1 1 record pins your certificate authority's intermediate key. Publishing it too keeps delivery working if your key changes unexpectedly, as long as the CA doesn't.")
+	} else {
+		guidance = append(guidance,
+			"Your mailserver didn't send its certificate chain, so we can't suggest a 2 1 1 record for your certificate authority.")
+	}
+	guidance = append(guidance,
+		"To roll over to a new key, publish a 3 1 1 record for the new key alongside the current one, wait at least twice the records' TTL, switch certificates, then remove the old record.")
+	if result.CertNotAfter != nil && result.CertNotAfter.Sub(now) < certRenewalWarning {
+		guidance = append(guidance, fmt.Sprintf(
+			"Your certificate expires on %s. If renewing it will change its key, publish the new key's record first.",
+			result.CertNotAfter.Format("2006-01-02")))
+	}
+	return guidance
+}
+
+// DANEGenerate handles requests to /api/dane/generate
+//   GET /api/dane/generate?hostname=<hostname>
+//        hostname: MX hostname to generate TLSA records for.
+//        Sets the recommended TLSA records, generated from the certificate
+//        observed in the latest scan of the hostname, with guidance on
+//        publishing them and rolling keys over, as response.
+func (api API) daneGenerate(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/dane/generate only accepts GET requests"}
+	}
+	hostname, err := getParam("hostname", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if !util.ValidDomainName(hostname) {
+		return badRequest("%s is not a valid hostname", hostname)
+	}
+	scan, err := api.Database.GetLatestScanWithHostname(hostname)
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound,
+			Message: fmt.Sprintf("%s hasn't been scanned yet. Scan a domain that uses it as an MX first.", hostname)}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	// Scans key hostnames as their MX records name them, which is usually
+	// fully qualified.
+	result, ok := scan.Data.HostnameResults[hostname+"."]
+	if !ok {
+		result = scan.Data.HostnameResults[hostname]
+	}
+	records := checker.GenerateTLSA(result)
+	if len(records) == 0 {
+		return response{StatusCode: http.StatusNotFound,
+			Message: fmt.Sprintf("No certificate was observed on %s in the latest scan of %s. Make sure it supports STARTTLS, and scan %s again.",
+				hostname, scan.Domain, scan.Domain)}
+	}
+	return response{StatusCode: http.StatusOK, Response: daneRecords{
+		Hostname:     hostname,
+		Domain:       scan.Domain,
+		ScannedAt:    scan.Timestamp,
+		CertNotAfter: result.CertNotAfter,
+		Records:      records,
+		Guidance:     daneGuidance(result, time.Now()),
+	}}
+}
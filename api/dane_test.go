@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func TestDANEGenerate(t *testing.T) {
+	defer teardown()
+
+	result := checker.NewSampleDomainResult("example.com")
+	hostnameResult := result.HostnameResults["mx.example.com"]
+	hostnameResult.CertSPKISHA256 = "aa11"
+	hostnameResult.IssuerSPKISHA256 = "bb22"
+	result.HostnameResults["mx.example.com"] = hostnameResult
+	api.Database.PutScan(models.Scan{Domain: "example.com", Data: result, Timestamp: time.Now()})
+
+	resp, err := http.Get(server.URL + "/api/dane/generate?hostname=MX.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Response daneRecords `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	records := body.Response.Records
+	if len(records) != 2 || records[0].Record != "_25._tcp.mx.example.com. IN TLSA 3 1 1 aa11" {
+		t.Errorf("Unexpected records %+v", records)
+	}
+	if body.Response.Domain != "example.com" || len(body.Response.Guidance) == 0 {
+		t.Errorf("Expected scan details and guidance, got %+v", body.Response)
+	}
+
+	// Real scans key hostnames by their fully qualified MX records.
+	delete(result.HostnameResults, "mx.example.com")
+	result.HostnameResults["mx.example.com."] = hostnameResult
+	api.Database.PutScan(models.Scan{Domain: "example.com", Data: result, Timestamp: time.Now().Add(time.Minute)})
+	resp, err = http.Get(server.URL + "/api/dane/generate?hostname=mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Response = daneRecords{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK || len(body.Response.Records) != 2 {
+		t.Errorf("Expected records for a fully qualified MX, got %d: %+v", resp.StatusCode, body.Response)
+	}
+}
+
+func TestDANEGenerateWithoutScan(t *testing.T) {
+	defer teardown()
+
+	// Scans from before we recorded key hashes have no certificate.
+	api.Database.PutScan(models.Scan{Domain: "example.com", Data: checker.NewSampleDomainResult("example.com"), Timestamp: time.Now()})
+	for _, hostname := range []string{"mx.example.com", "mx.unscanned.com"} {
+		resp, err := http.Get(server.URL + "/api/dane/generate?hostname=" + hostname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s, got %d", hostname, resp.StatusCode)
+		}
+	}
+	resp, _ := http.Get(server.URL + "/api/dane/generate?hostname=not_a_hostname")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid hostname, got %d", resp.StatusCode)
+	}
+}
+
+func TestDANEGuidanceWarnsBeforeExpiry(t *testing.T) {
+	now := time.Now()
+	notAfter := now.Add(7 * 24 * time.Hour)
+	guidance := daneGuidance(checker.HostnameResult{CertSPKISHA256: "aa11", CertNotAfter: &notAfter}, now)
+	if !strings.Contains(guidance[len(guidance)-1], "expires on") {
+		t.Errorf("Expected warning about certificate expiry, got %v", guidance)
+	}
+	notAfter = now.Add(90 * 24 * time.Hour)
+	guidance = daneGuidance(checker.HostnameResult{CertSPKISHA256: "aa11", CertNotAfter: &notAfter}, now)
+	if strings.Contains(strings.Join(guidance, " "), "expires on") {
+		t.Errorf("Expected no expiry warning, got %v", guidance)
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deprecation describes part of the API that's going away: a whole endpoint,
+// or a parameter or way of calling one.
+type deprecation struct {
+	// Name identifies the deprecated surface in usage stats.
+	Name    string
+	Message string
+	// Since is when the surface was deprecated, and Sunset when it will stop
+	// working, if that's been decided.
+	Since  time.Time
+	Sunset time.Time
+	// Link points to documentation of the deprecation and alternatives.
+	Link string
+	// Applies reports whether a request uses the deprecated surface. If nil,
+	// every request to the endpoint does.
+	Applies func(*http.Request) bool
+}
+
+// deprecationLink is where deprecations are documented.
+const deprecationLink = "https://github.com/EFForg/starttls-backend#deprecations"
+
+// htmlFormPosts are forms posted straight to the API by browsers, which get
+// HTML responses. The frontend should post JSON requests instead.
+var htmlFormPosts = deprecation{
+	Name:    "html-form-posts",
+	Message: "Posting forms to the API for an HTML response is deprecated; send requests with `Accept: application/json` instead.",
+	Since:   time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC),
+	Link:    deprecationLink,
+	Applies: func(r *http.Request) bool {
+		return r.Method == http.MethodPost && strings.Contains(r.Header.Get("accept"), "text/html")
+	},
+}
+
+// apiV1 are requests to the v1 endpoints that have v2 replacements, whose
+// contracts are kept stable.
+var apiV1 = deprecation{
+	Name:    "api-v1",
+	Message: "The v1 scan, queue and validate endpoints are deprecated; use their /api/v2 replacements instead.",
+	Since:   time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC),
+	Link:    deprecationLink,
+}
+
+// deprecations lists every deprecated surface, for /admin/deprecations.
+var deprecations = []deprecation{htmlFormPosts, apiV1}
+
+// warning returns the message added to responses that use d.
+func (d deprecation) warning() string {
+	if d.Sunset.IsZero() {
+		return d.Message
+	}
+	return fmt.Sprintf("%s It will stop working on %s.", d.Message, d.Sunset.Format("2006-01-02"))
+}
+
+// apply adds Deprecation, Sunset and Link headers (RFC 9745 and RFC 8594) and
+// a warning to resp.
+func (d deprecation) apply(resp response) response {
+	if resp.header == nil {
+		resp.header = http.Header{}
+	}
+	resp.header.Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	if !d.Sunset.IsZero() {
+		resp.header.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Link != "" {
+		resp.header.Add("Link", "<"+d.Link+">; rel=\"deprecation\"")
+	}
+	resp.Warnings = append(resp.Warnings, d.warning())
+	return resp
+}
+
+// deprecated marks the requests to handler that d applies to as deprecated,
+// and counts them.
+func deprecated(d deprecation, handler apiHandler) apiHandler {
+	return func(r *http.Request) response {
+		resp := handler(r)
+		if d.Applies != nil && !d.Applies(r) {
+			return resp
+		}
+		deprecatedUsage.record(d.Name, time.Now())
+		return d.apply(resp)
+	}
+}
+
+// DeprecationUsage reports how much a deprecated surface is still used.
+type DeprecationUsage struct {
+	Name    string     `json:"name"`
+	Message string     `json:"message"`
+	Since   time.Time  `json:"since"`
+	Sunset  *time.Time `json:"sunset,omitempty"`
+	// Requests counts uses since the server started.
+	Requests int64      `json:"requests"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+type usageCount struct {
+	requests int64
+	lastUsed time.Time
+}
+
+// deprecationUsage counts requests to deprecated surfaces.
+type deprecationUsage struct {
+	mu     sync.Mutex
+	counts map[string]*usageCount
+}
+
+var deprecatedUsage = &deprecationUsage{counts: make(map[string]*usageCount)}
+
+func (u *deprecationUsage) record(name string, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	count, ok := u.counts[name]
+	if !ok {
+		count = &usageCount{}
+		u.counts[name] = count
+	}
+	count.requests++
+	count.lastUsed = now
+}
+
+// report returns the usage of each of deprecations, sorted by name.
+func (u *deprecationUsage) report(deprecations []deprecation) []DeprecationUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	report := []DeprecationUsage{}
+	for _, d := range deprecations {
+		usage := DeprecationUsage{Name: d.Name, Message: d.Message, Since: d.Since}
+		if !d.Sunset.IsZero() {
+			sunset := d.Sunset
+			usage.Sunset = &sunset
+		}
+		if count, ok := u.counts[d.Name]; ok {
+			lastUsed := count.lastUsed
+			usage.Requests, usage.LastUsed = count.requests, &lastUsed
+		}
+		report = append(report, usage)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Name < report[j].Name })
+	return report
+}
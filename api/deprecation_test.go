@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeprecatedMarksResponses(t *testing.T) {
+	d := deprecation{
+		Name:    "test-old-param",
+		Message: "The old parameter is deprecated.",
+		Since:   time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Sunset:  time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Link:    deprecationLink,
+		Applies: func(r *http.Request) bool { return r.FormValue("old") != "" },
+	}
+	handler := deprecated(d, func(r *http.Request) response {
+		return response{StatusCode: http.StatusOK}
+	})
+
+	resp := handler(httptest.NewRequest("GET", "/api/test", nil))
+	if len(resp.Warnings) != 0 || resp.header.Get("Deprecation") != "" {
+		t.Errorf("Expected requests without the deprecated parameter to be left alone, got %+v", resp)
+	}
+
+	resp = handler(httptest.NewRequest("GET", "/api/test?old=1", nil))
+	if resp.header.Get("Deprecation") != "@1577836800" {
+		t.Errorf("Expected Deprecation header, got %q", resp.header.Get("Deprecation"))
+	}
+	if resp.header.Get("Sunset") != "Fri, 01 Jan 2021 00:00:00 GMT" {
+		t.Errorf("Expected Sunset header, got %q", resp.header.Get("Sunset"))
+	}
+	if resp.header.Get("Link") != "<"+deprecationLink+">; rel=\"deprecation\"" {
+		t.Errorf("Expected Link header, got %q", resp.header.Get("Link"))
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0] != "The old parameter is deprecated. It will stop working on 2021-01-01." {
+		t.Errorf("Expected deprecation warning, got %v", resp.Warnings)
+	}
+
+	report := deprecatedUsage.report([]deprecation{d})
+	if len(report) != 1 || report[0].Requests != 1 || report[0].LastUsed == nil || report[0].Sunset == nil {
+		t.Errorf("Expected one deprecated request to be counted, got %+v", report)
+	}
+}
+
+func TestHTMLFormPostsDeprecated(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/queue", nil)
+	r.Header.Set("Accept", "text/html")
+	if !htmlFormPosts.Applies(r) {
+		t.Error("Expected HTML form posts to be deprecated")
+	}
+	r.Header.Set("Accept", "application/json")
+	if htmlFormPosts.Applies(r) {
+		t.Error("Expected JSON requests not to be deprecated")
+	}
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+// ScanDiff is the handler for /api/scan/diff.
+//   GET /api/scan/diff?domain=<domain>
+//        Compares the two most recent trusted scans of domain (see
+//        models.Scan.Trusted), so census and quick scans aren't compared
+//        with full ones, and sets a
+//        checker.ScanDiff JSON listing the checks whose status changed
+//        between them as the response.
+func (api API) scanDiff(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed}
+	}
+	domain, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	scans, err := api.Database.GetLatestTrustedScans(domain, 2)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if len(scans) < 2 {
+		return response{StatusCode: http.StatusNotFound,
+			Message: "need at least two scans of " + domain + " to compare"}
+	}
+	return response{StatusCode: http.StatusOK, Response: checker.Diff(scans[1].Data, scans[0].Data)}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/EFForg/starttls-backend/models"
+	"golang.org/x/net/idna"
+)
+
+// mergePatchType is the media type of JSON merge patches (RFC 7396).
+const mergePatchType = "application/merge-patch+json"
+
+// domainETag identifies a version of a domain's row, for optimistic
+// concurrency control.
+func domainETag(domain models.Domain) string {
+	return fmt.Sprintf("\"%s-%s-%d\"", domain.Name, domain.State, domain.LastUpdated.UnixNano())
+}
+
+// Page sizes for /admin/domains.
+const (
+	defaultDomainPageSize = 100
+	maxDomainPageSize     = 1000
+)
+
+// AdminDomains handles requests to /admin/domains
+//   GET /admin/domains?state=<state>
+//        limit (optional): The most domains to list, up to 1000. Defaults to
+//          100.
+//        sort (optional): "name" (the default), or "last_updated" for the
+//          most recently updated first.
+//        after (optional): The next cursor of the previous page.
+//        Sets a models.DomainList of a page of the domains in state, and the
+//        cursor for the next page, as response.
+func (api API) adminDomains(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/domains only accepts GET requests"}
+	}
+	state, err := getParam("state", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	limit, err := getInt("limit", r, 1, maxDomainPageSize+1, defaultDomainPageSize)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	page := models.DomainPage{
+		Limit: limit,
+		Sort:  r.URL.Query().Get("sort"),
+		After: r.URL.Query().Get("after"),
+	}
+	if err := page.Validate(); err != nil {
+		return badRequest(err.Error())
+	}
+	list, err := api.Database.GetDomainPage(models.DomainState(state), page)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	return response{StatusCode: http.StatusOK, Response: list}
+}
+
+// Result limits for /api/domains/search.
+const (
+	defaultDomainSearchSize = 10
+	maxDomainSearchSize     = 100
+)
+
+// publicDomainStates are the states of domains anyone can search for, since
+// they're published on the policy list or its queue anyway.
+var publicDomainStates = []models.DomainState{models.StateTesting, models.StateEnforce}
+
+// SearchDomains handles requests to /api/domains/search
+//   GET /api/domains/search?q=<query>
+//        match (optional): "prefix" (the default), for names starting with
+//          the query, or "substring", for names containing it.
+//        state (optional, repeatable): Only search domains in these states.
+//          Without the admin key or a "list-read" API key, only queued and
+//          added domains can be searched, which is also the default; with
+//          one, every state is.
+//        limit (optional): The most domains to return, up to 100. Defaults
+//          to 10.
+//        Sets the matching domains, in order of name, as response.
+func (api API) searchDomains(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/domains/search only accepts GET requests"}
+	}
+	query, err := getParam("q", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	limit, err := getInt("limit", r, 1, maxDomainSearchSize+1, defaultDomainSearchSize)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	search := models.DomainSearch{Query: query, Match: r.FormValue("match"), Limit: limit}
+	if err := search.Validate(); err != nil {
+		return badRequest(err.Error())
+	}
+	_, refused := api.authenticateAdmin(r, models.ScopeListRead)
+	admin := refused == nil
+	var states []models.DomainState
+	for _, state := range r.Form["state"] {
+		state := models.DomainState(strings.ToLower(state))
+		if !admin && !containsState(publicDomainStates, state) {
+			return response{StatusCode: http.StatusUnauthorized,
+				Message: fmt.Sprintf("searching %s domains requires the admin key", state)}
+		}
+		states = append(states, state)
+	}
+	if len(states) == 0 && !admin {
+		states = publicDomainStates
+	}
+	domains, err := api.Database.SearchDomains(search, states...)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	return response{StatusCode: http.StatusOK, Response: domains}
+}
+
+func containsState(states []models.DomainState, state models.DomainState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminDomain handles requests to /admin/domains/{domain}
+//   GET /admin/domains/{domain}
+//        state (optional): State of the domain's policy to retrieve. Defaults
+//          to the domain's most important state, as in models.GetDomain.
+//        Sets the models.Domain as response, and its version as the ETag
+//        header.
+//   PATCH /admin/domains/{domain}
+//        Body is a JSON merge patch of the domain's "mxs", "queue_weeks" or
+//          "mta_sts", with Content-Type application/merge-patch+json.
+//        If-Match header must be the ETag of the version being patched.
+//        state (optional): As for GET.
+//        actor: Who made the correction, for the audit log.
+//        Sets the patched models.Domain as response, and its new version as
+//        the ETag header.
+func (api API) adminDomain(r *http.Request) response {
+	name, err := idna.ToASCII(strings.ToLower(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/domains/"), "/")))
+	if err != nil || name == "" || strings.Contains(name, "/") {
+		return response{StatusCode: http.StatusNotFound, Message: "no such endpoint"}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/domains/{domain} only accepts GET and PATCH requests"}
+	}
+	var domain models.Domain
+	if state := r.URL.Query().Get("state"); state != "" {
+		domain, err = api.Database.GetDomain(name, models.DomainState(state))
+	} else {
+		domain, err = models.GetDomain(api.Database, name)
+	}
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound, Message: "no such domain"}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if r.Method == http.MethodGet {
+		return response{StatusCode: http.StatusOK, Response: domain,
+			header: http.Header{"Etag": {domainETag(domain)}}}
+	}
+	return api.patchDomain(r, domain)
+}
+
+func (api API) patchDomain(r *http.Request, domain models.Domain) response {
+	actor, err := getActor(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), mergePatchType) {
+		return response{StatusCode: http.StatusUnsupportedMediaType,
+			Message: "patches must have Content-Type " + mergePatchType}
+	}
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return response{StatusCode: http.StatusPreconditionRequired,
+			Message: "patches must have an If-Match header with the domain's ETag"}
+	}
+	if ifMatch != domainETag(domain) {
+		return response{StatusCode: http.StatusPreconditionFailed,
+			Message: "domain has changed since it was retrieved"}
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, 1<<16))
+	if err != nil {
+		return badRequest("couldn't read patch: %v", err)
+	}
+	patched, changes, err := domain.MergePatch(body, api.QueuePolicy)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if len(patched.MXs) > MaxHostnames {
+		return badRequest("no more than %d MX hostnames are allowed", MaxHostnames)
+	}
+	if len(changes) == 0 {
+		return response{StatusCode: http.StatusOK, Response: domain,
+			header: http.Header{"Etag": {domainETag(domain)}}}
+	}
+	updated, err := api.Database.UpdateDomain(patched, domain.LastUpdated)
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusPreconditionFailed,
+			Message: "domain has changed since it was retrieved"}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	api.audit(models.AuditEntry{Actor: actor, Action: "domain.patch",
+		Subject: domain.Name, Details: fmt.Sprintf("%s: %s", domain.State, strings.Join(changes, "; "))})
+	return response{StatusCode: http.StatusOK, Response: updated,
+		header: http.Header{"Etag": {domainETag(updated)}}}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func adminDomainRequest(t *testing.T, method string, path string, body string, etag string) (*http.Response, response) {
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+	req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", mergePatchType)
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded response
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp, decoded
+}
+
+func TestPatchDomain(t *testing.T) {
+	defer teardown()
+	api.Database.PutDomain(models.Domain{Name: "patch.com", Email: "admin@patch.com",
+		MXs: []string{"mx1.patch.com"}, QueueWeeks: 4})
+
+	resp, _ := adminDomainRequest(t, "GET", "/admin/domains/patch.com", "", "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("Expected domain with an ETag, got %d", resp.StatusCode)
+	}
+
+	patch := `{"mxs": ["mx2.patch.com"], "queue_weeks": 6}`
+	resp, _ = adminDomainRequest(t, "PATCH", "/admin/domains/patch.com?actor=alice", patch, "")
+	if resp.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("Expected patch without If-Match to be refused, got %d", resp.StatusCode)
+	}
+	resp, _ = adminDomainRequest(t, "PATCH", "/admin/domains/patch.com?actor=alice", `{"email": "a@b.com"}`, etag)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected patch of email to be rejected, got %d", resp.StatusCode)
+	}
+	resp, _ = adminDomainRequest(t, "PATCH", "/admin/domains/patch.com?actor=alice", patch, etag)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Fatalf("Expected patch to succeed with a new ETag, got %d", resp.StatusCode)
+	}
+	domain, err := api.Database.GetDomain("patch.com", models.StateUnconfirmed)
+	if err != nil || domain.QueueWeeks != 6 || len(domain.MXs) != 1 || domain.MXs[0] != "mx2.patch.com" {
+		t.Errorf("Expected domain to be patched, got %+v (%v)", domain, err)
+	}
+	entries, err := api.Database.GetAuditLog("patch.com")
+	if err != nil || len(entries) != 1 || entries[0].Actor != "alice" || entries[0].Action != "domain.patch" {
+		t.Errorf("Expected patch in the audit log, got %v (%v)", entries, err)
+	}
+
+	resp, _ = adminDomainRequest(t, "PATCH", "/admin/domains/patch.com?actor=bob", `{"queue_weeks": 8}`, etag)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected patch of a stale version to fail, got %d", resp.StatusCode)
+	}
+
+	resp, _ = adminDomainRequest(t, "GET", "/admin/domains/missing.com", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected unknown domain to be missing, got %d", resp.StatusCode)
+	}
+}
+
+func TestListDomains(t *testing.T) {
+	defer teardown()
+	for _, name := range []string{"c.com", "a.com", "b.com"} {
+		api.Database.PutDomain(models.Domain{Name: name, MXs: []string{"mx." + name}})
+		api.Database.SetStatus(name, models.StateTesting, models.StateChange{})
+	}
+
+	names := []string{}
+	path := "/admin/domains?state=queued&limit=2"
+	for path != "" {
+		resp, decoded := adminDomainRequest(t, "GET", path, "", "")
+		var list models.DomainList
+		raw, _ := json.Marshal(decoded.Response)
+		json.Unmarshal(raw, &list)
+		if resp.StatusCode != http.StatusOK || len(list.Domains) > 2 {
+			t.Fatalf("Expected a page of at most 2 domains, got %d %+v", resp.StatusCode, list)
+		}
+		for _, domain := range list.Domains {
+			names = append(names, domain.Name)
+		}
+		path = ""
+		if list.Next != "" {
+			path = "/admin/domains?state=queued&limit=2&after=" + list.Next
+		}
+	}
+	if strings.Join(names, " ") != "a.com b.com c.com" {
+		t.Errorf("Expected every queued domain in order of name, got %v", names)
+	}
+
+	if resp, _ := adminDomainRequest(t, "GET", "/admin/domains?state=queued&sort=email", "", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected unknown sort order to be refused, got %d", resp.StatusCode)
+	}
+	if resp, _ := adminDomainRequest(t, "GET", "/admin/domains?state=queued&limit=5000", "", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected oversized page to be refused, got %d", resp.StatusCode)
+	}
+}
+
+func searchDomainNames(decoded response) []string {
+	var domains []models.Domain
+	raw, _ := json.Marshal(decoded.Response)
+	json.Unmarshal(raw, &domains)
+	names := []string{}
+	for _, domain := range domains {
+		names = append(names, domain.Name)
+	}
+	return names
+}
+
+func TestSearchDomains(t *testing.T) {
+	defer teardown()
+	for _, name := range []string{"mail.example.com", "gmail.com", "hotmail.com"} {
+		api.Database.PutDomain(models.Domain{Name: name, Email: "admin@" + name, MXs: []string{"mx." + name}})
+	}
+	api.Database.SetStatus("gmail.com", models.StateTesting, models.StateChange{})
+	api.Database.SetStatus("mail.example.com", models.StateEnforce, models.StateChange{})
+
+	resp, err := http.Get(server.URL + "/api/domains/search?q=MAIL&match=substring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded response
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	names := searchDomainNames(decoded)
+	if resp.StatusCode != http.StatusOK || strings.Join(names, " ") != "gmail.com mail.example.com" {
+		t.Errorf("Expected only public matching domains, got %d %v", resp.StatusCode, names)
+	}
+
+	resp, err = http.Get(server.URL + "/api/domains/search?q=mail&state=unvalidated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected searching unconfirmed domains to need the admin key, got %d", resp.StatusCode)
+	}
+
+	resp, decoded = adminDomainRequest(t, "GET", "/api/domains/search?q=hot&state=unvalidated", "", "")
+	if names := searchDomainNames(decoded); resp.StatusCode != http.StatusOK || len(names) != 1 || names[0] != "hotmail.com" {
+		t.Errorf("Expected admins to find unconfirmed domains, got %d %v", resp.StatusCode, names)
+	}
+
+	resp, _ = adminDomainRequest(t, "GET", "/api/domains/search?q=mail&match=suffix", "", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected unknown match to be refused, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/mail"
+)
+
+// RequestErasure handles requests to /api/erase/request
+//   POST /api/erase/request
+//        email: Address to erase the stored data about. A verification
+//          token is sent to it, which can be redeemed at /api/erase.
+func (api API) requestErasure(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/erase/request only accepts POST requests"}
+	}
+	address, err := getParam("email", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if parsed, err := mail.ParseAddress(address); err != nil || parsed.Address != address {
+		return badRequest("%s is not a valid email address", address)
+	}
+	token, err := api.Database.PutDataErasureToken(address)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if err = api.Emailer.SendDataErasureVerification(address, token); err != nil {
+		log.Print(err)
+		return serverError("Unable to send verification e-mail")
+	}
+	return response{StatusCode: http.StatusOK,
+		Response: "Please check " + address + " for a token to confirm erasing its data."}
+}
+
+// Erase handles requests to /api/erase
+//   POST /api/erase
+//        token: Verification token sent by /api/erase/request. Each token
+//          can be used once.
+//        Erases the token's email address: its unconfirmed submissions are
+//        deleted, its confirmed domains lose their contact address, and its
+//        API keys are revoked. Sets a models.Erasure summarizing what was
+//        erased as response.
+func (api API) erase(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/erase only accepts POST requests"}
+	}
+	token, err := getParam("token", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	address, err := api.Database.UseDataErasureToken(token)
+	if err == sql.ErrNoRows {
+		return badRequest("token is invalid, expired, or has already been used")
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	erasure, err := api.Database.EraseEmail(address, "owner")
+	if err != nil {
+		return serverError(err.Error())
+	}
+	return response{StatusCode: http.StatusOK, Response: erasure}
+}
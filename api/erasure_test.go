@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func erasureRequest(t *testing.T, path string, data url.Values) (*http.Response, models.Erasure) {
+	resp, err := http.Post(server.URL+path, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Response models.Erasure `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp, body.Response
+}
+
+func TestErase(t *testing.T) {
+	defer teardown()
+	api.Database.PutDomain(models.Domain{Name: "pending.com", Email: "someone@example.com", MXs: []string{"mx.pending.com"}})
+	api.Database.PutToken("pending.com")
+	api.Database.PutDomain(models.Domain{Name: "queued.com", Email: "someone@example.com", MXs: []string{"mx.queued.com"}})
+	api.Database.SetStatus("queued.com", models.StateTesting, models.StateChange{})
+	key, _ := api.Database.PutAPIKey(models.APIKey{Email: "someone@example.com"}, "hash")
+	api.Database.PutAuditEntry(models.AuditEntry{Actor: key.Actor(), Action: "webhook.register", Subject: "queued.com"})
+	api.Database.PutAuditEntry(models.AuditEntry{Actor: "admin", Action: "key.issue", Subject: "Someone@example.com"})
+
+	if resp, _ := erasureRequest(t, "/api/erase/request", url.Values{"email": {"someone@example.com"}}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Requesting erasure failed with %d", resp.StatusCode)
+	}
+	resp, erasure := erasureRequest(t, "/api/erase", url.Values{"token": {lastErasureToken}})
+	if resp.StatusCode != http.StatusOK || len(erasure.Removed) != 1 || len(erasure.Anonymized) != 1 ||
+		erasure.RevokedKeys != 1 || erasure.RedactedAuditEntries != 2 {
+		t.Fatalf("Erasing failed with %d: %+v", resp.StatusCode, erasure)
+	}
+	if _, err := api.Database.GetDomain("pending.com", models.StateUnconfirmed); err == nil {
+		t.Error("Expected unconfirmed submission to be removed")
+	}
+	if domain, err := api.Database.GetDomain("queued.com", models.StateTesting); err != nil || domain.Email != "" {
+		t.Errorf("Expected queued domain to stay queued without a contact, got %+v (%v)", domain, err)
+	}
+	if _, err := api.Database.UseAPIKey("hash"); err == nil {
+		t.Error("Expected the address's API key to be revoked")
+	}
+	export, _ := api.Database.GetDataExport("someone@example.com")
+	if len(export.Domains) != 0 || len(export.APIKeys) != 0 || len(export.EmailTokens) != 0 {
+		t.Errorf("Expected nothing left about the address, got %+v", export)
+	}
+	entries, _ := api.Database.GetAuditLog("pending.com")
+	if len(entries) != 1 || entries[0].Details != "unvalidated removed: contact erased on request" {
+		t.Errorf("Expected removal in the audit log, got %v", entries)
+	}
+	entries, _ = api.Database.GetAuditLogSince(time.Time{})
+	for _, e := range entries {
+		if strings.Contains(strings.ToLower(e.Actor+e.Subject+e.Details), "someone@example.com") {
+			t.Errorf("Expected the address to be redacted from the audit log, got %+v", e)
+		}
+	}
+
+	if resp, _ = erasureRequest(t, "/api/erase", url.Values{"token": {lastErasureToken}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a used token to be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/mail"
+)
+
+// RequestExport handles requests to /api/export/request
+//   POST /api/export/request
+//        email: Address to export the stored data about. A verification
+//          token is sent to it, which can be redeemed at /api/export.
+func (api API) requestExport(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/export/request only accepts POST requests"}
+	}
+	address, err := getParam("email", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if parsed, err := mail.ParseAddress(address); err != nil || parsed.Address != address {
+		return badRequest("%s is not a valid email address", address)
+	}
+	token, err := api.Database.PutDataExportToken(address)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if err = api.Emailer.SendDataExportVerification(address, token); err != nil {
+		log.Print(err)
+		return serverError("Unable to send verification e-mail")
+	}
+	return response{StatusCode: http.StatusOK,
+		Response: "Please check " + address + " for a token to download its data."}
+}
+
+// Export handles requests to /api/export
+//   POST /api/export
+//        token: Verification token sent by /api/export/request. Each token
+//          can be used once.
+//        Sets a models.DataExport of everything stored about the token's
+//        email address as response.
+func (api API) export(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/export only accepts POST requests"}
+	}
+	token, err := getParam("token", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	address, err := api.Database.UseDataExportToken(token)
+	if err == sql.ErrNoRows {
+		return badRequest("token is invalid, expired, or has already been used")
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	export, err := api.Database.GetDataExport(address)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	return response{StatusCode: http.StatusOK, Response: export}
+}
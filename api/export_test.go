@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func exportRequest(t *testing.T, path string, data url.Values) (*http.Response, models.DataExport) {
+	resp, err := http.Post(server.URL+path, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Response models.DataExport `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp, body.Response
+}
+
+func TestExport(t *testing.T) {
+	defer teardown()
+	api.Database.PutDomain(models.Domain{Name: "example.com", Email: "Someone@example.com", MXs: []string{"mx.example.com"}})
+	api.Database.PutToken("example.com")
+	api.Database.PutDomain(models.Domain{Name: "other.com", Email: "other@example.com", MXs: []string{"mx.other.com"}})
+	api.Database.PutBlacklistedEmail("someone@example.com", "bounce", "2017-07-21T18:47:13.498Z")
+
+	if resp, _ := exportRequest(t, "/api/export/request", url.Values{"email": {"not an address"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid address to be rejected, got %d", resp.StatusCode)
+	}
+	if resp, _ := exportRequest(t, "/api/export/request", url.Values{"email": {"someone@example.com"}}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Requesting an export failed with %d", resp.StatusCode)
+	}
+	resp, export := exportRequest(t, "/api/export", url.Values{"token": {lastExportToken}})
+	if resp.StatusCode != http.StatusOK || export.Email != "someone@example.com" {
+		t.Fatalf("Exporting failed with %d: %+v", resp.StatusCode, export)
+	}
+	if len(export.Domains) != 1 || export.Domains[0].Name != "example.com" {
+		t.Errorf("Expected only the address's domain, got %+v", export.Domains)
+	}
+	if len(export.ValidationTokens) != 1 || export.ValidationTokens[0].Token != "" {
+		t.Errorf("Expected the domain's validation token without its secret, got %+v", export.ValidationTokens)
+	}
+	if len(export.EmailTokens) != 1 || export.EmailTokens[0].Purpose != models.PurposeDataExport || !export.EmailTokens[0].Used {
+		t.Errorf("Expected the used export token, got %+v", export.EmailTokens)
+	}
+	if len(export.Bounces) != 1 || export.Bounces[0].Reason != "bounce" {
+		t.Errorf("Expected the address's bounce, got %+v", export.Bounces)
+	}
+
+	if resp, _ = exportRequest(t, "/api/export", url.Values{"token": {lastExportToken}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a used token to be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// Defaults for FirehoseFromEnv.
+const (
+	defaultFirehoseSubscribers     = 32
+	defaultFirehosePerClient       = 2
+	defaultFirehoseEventsPerSecond = 10
+	// firehoseBuffer is how many events may wait for a slow subscriber before
+	// they're dropped.
+	firehoseBuffer = 64
+)
+
+// firehoseEventTimeResolution is the resolution of event times, so that they
+// can't easily be matched up with individual scan requests.
+const firehoseEventTimeResolution = time.Minute
+
+var errFirehoseFull = errors.New("too many firehose subscribers; please try again later")
+
+// firehoseEvent is a scan, stripped of anything that identifies the domain or
+// its mailservers.
+type firehoseEvent struct {
+	Time time.Time `json:"time"`
+	// DomainHash is a keyed hash of the domain, so that subscribers can tell
+	// repeated scans of a domain apart from scans of different domains,
+	// without being able to tell which domain it is.
+	DomainHash string        `json:"domain_hash"`
+	Grade      checker.Grade `json:"grade,omitempty"`
+	// Checks maps each check's name to its worst status on any of the
+	// domain's preferred mailservers, eg. "starttls": "Success".
+	Checks     map[string]string `json:"checks"`
+	MTASTSMode string            `json:"mta_sts_mode,omitempty"`
+}
+
+// firehoseDropped tells a subscriber how many events it missed because it
+// read them too slowly or exceeded its rate.
+type firehoseDropped struct {
+	Dropped int `json:"dropped"`
+}
+
+// Firehose streams sanitized scan events to subscribers, for researchers
+// monitoring adoption in real time. Events carry a keyed hash of the scanned
+// domain, its grade and its check outcomes, and nothing else. Each subscriber
+// is sent at most EventsPerSecond events; the rest are dropped.
+type Firehose struct {
+	MaxSubscribers  int
+	MaxPerClient    int
+	EventsPerSecond float64
+
+	secret      []byte
+	mu          sync.Mutex
+	subscribers map[*firehoseSubscriber]bool
+	clients     map[string]int
+}
+
+type firehoseSubscriber struct {
+	events chan firehoseEvent
+	// dropped counts events dropped since the subscriber was last told.
+	mu      sync.Mutex
+	dropped int
+}
+
+// NewFirehose returns a Firehose hashing domains with secret.
+func NewFirehose(secret []byte, maxSubscribers int, maxPerClient int, eventsPerSecond float64) *Firehose {
+	return &Firehose{
+		MaxSubscribers:  maxSubscribers,
+		MaxPerClient:    maxPerClient,
+		EventsPerSecond: eventsPerSecond,
+		secret:          secret,
+		subscribers:     make(map[*firehoseSubscriber]bool),
+		clients:         make(map[string]int),
+	}
+}
+
+// FirehoseFromEnv returns a Firehose configured by the env vars
+// FIREHOSE_SECRET, FIREHOSE_MAX_SUBSCRIBERS, FIREHOSE_MAX_PER_CLIENT and
+// FIREHOSE_EVENTS_PER_SECOND, or nil if FIREHOSE_SECRET isn't set.
+func FirehoseFromEnv() (*Firehose, error) {
+	secret := os.Getenv("FIREHOSE_SECRET")
+	if secret == "" {
+		return nil, nil
+	}
+	maxSubscribers, err := envInt("FIREHOSE_MAX_SUBSCRIBERS", defaultFirehoseSubscribers)
+	if err != nil {
+		return nil, err
+	}
+	maxPerClient, err := envInt("FIREHOSE_MAX_PER_CLIENT", defaultFirehosePerClient)
+	if err != nil {
+		return nil, err
+	}
+	rate := float64(defaultFirehoseEventsPerSecond)
+	if value := os.Getenv("FIREHOSE_EVENTS_PER_SECOND"); value != "" {
+		if rate, err = strconv.ParseFloat(value, 64); err != nil || rate <= 0 {
+			return nil, errors.New("FIREHOSE_EVENTS_PER_SECOND must be a positive number")
+		}
+	}
+	return NewFirehose([]byte(secret), maxSubscribers, maxPerClient, rate), nil
+}
+
+// sanitize reduces a scan to a firehoseEvent.
+func (f *Firehose) sanitize(scan models.Scan) firehoseEvent {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write([]byte(scan.Domain))
+	event := firehoseEvent{
+		Time:       scan.Timestamp.UTC().Truncate(firehoseEventTimeResolution),
+		DomainHash: hex.EncodeToString(mac.Sum(nil)),
+		Grade:      scan.Data.Grade,
+		Checks:     make(map[string]string),
+	}
+	worst := make(map[string]checker.Status)
+	for _, hostname := range scan.Data.PreferredHostnames {
+		result, ok := scan.Data.HostnameResults[hostname]
+		if !ok || result.Result == nil {
+			continue
+		}
+		for name, check := range result.Checks {
+			worst[name] = checker.SetStatus(worst[name], check.Status)
+		}
+	}
+	for name, status := range worst {
+		event.Checks[name] = checker.Result{Status: status}.StatusText()
+	}
+	if mtasts := scan.Data.MTASTSResult; mtasts != nil && mtasts.Result != nil {
+		event.Checks[checker.MTASTS] = mtasts.StatusText()
+		event.MTASTSMode = mtasts.Mode
+	}
+	return event
+}
+
+// Publish sends a sanitized scan to every subscriber. It doesn't block:
+// subscribers that have fallen behind miss the event. A nil Firehose does
+// nothing.
+func (f *Firehose) Publish(scan models.Scan) {
+	if f == nil {
+		return
+	}
+	event := f.sanitize(scan)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subscribers {
+		select {
+		case s.events <- event:
+		default:
+			s.drop()
+		}
+	}
+}
+
+func (s *firehoseSubscriber) drop() {
+	s.mu.Lock()
+	s.dropped++
+	s.mu.Unlock()
+}
+
+// takeDropped returns the events dropped since it was last called.
+func (s *firehoseSubscriber) takeDropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dropped := s.dropped
+	s.dropped = 0
+	return dropped
+}
+
+func (f *Firehose) subscribe(client string) (*firehoseSubscriber, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.subscribers) >= f.MaxSubscribers || f.clients[client] >= f.MaxPerClient {
+		return nil, errFirehoseFull
+	}
+	s := &firehoseSubscriber{events: make(chan firehoseEvent, firehoseBuffer)}
+	f.subscribers[s] = true
+	f.clients[client]++
+	return s, nil
+}
+
+func (f *Firehose) unsubscribe(client string, s *firehoseSubscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subscribers, s)
+	f.clients[client]--
+	if f.clients[client] <= 0 {
+		delete(f.clients, client)
+	}
+}
+
+// serve streams events to s as newline-delimited JSON until the request is
+// done.
+func (f *Firehose) serve(w http.ResponseWriter, r *http.Request, s *firehoseSubscriber) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		// Send the headers now, so the client knows it's subscribed.
+		flusher.Flush()
+	}
+	encoder := json.NewEncoder(w)
+	// Token bucket holding up to a second's worth of events, and at least one.
+	burst := math.Max(1, f.EventsPerSecond)
+	tokens, last := burst, time.Now()
+	for {
+		select {
+		case event := <-s.events:
+			now := time.Now()
+			tokens += now.Sub(last).Seconds() * f.EventsPerSecond
+			if tokens > burst {
+				tokens = burst
+			}
+			last = now
+			if tokens < 1 {
+				s.drop()
+				continue
+			}
+			tokens--
+			if dropped := s.takeDropped(); dropped > 0 {
+				if encoder.Encode(firehoseDropped{Dropped: dropped}) != nil {
+					return
+				}
+			}
+			if encoder.Encode(event) != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Firehose handles requests to /api/firehose
+//   GET /api/firehose
+//        Streams a firehoseEvent for each new scan, as newline-delimited
+//        JSON, until the client disconnects. Lines like {"dropped": 3}
+//        report events missed for reading too slowly or exceeding the
+//        rate limit. Responds with a 404 unless the firehose is enabled.
+func (api *API) firehose(w http.ResponseWriter, r *http.Request) {
+	if api.Firehose == nil {
+		api.wrapper(func(*http.Request) response {
+			return response{StatusCode: http.StatusNotFound, Message: "the scan firehose isn't enabled"}
+		})(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		api.wrapper(func(*http.Request) response {
+			return response{StatusCode: http.StatusMethodNotAllowed,
+				Message: "/api/firehose only accepts GET requests"}
+		})(w, r)
+		return
+	}
+	client := scanClientOf(r)
+	s, err := api.Firehose.subscribe(client)
+	if err != nil {
+		api.wrapper(func(*http.Request) response {
+			return response{StatusCode: http.StatusTooManyRequests, Message: err.Error(),
+				header: http.Header{"Retry-After": {"60"}}}
+		})(w, r)
+		return
+	}
+	defer api.Firehose.unsubscribe(client, s)
+	api.Firehose.serve(w, r, s)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func TestFirehoseSanitizesScans(t *testing.T) {
+	f := NewFirehose([]byte("secret"), 1, 1, 10)
+	scan := models.Scan{Domain: "example.com", Data: checker.NewSampleDomainResult("example.com"),
+		Timestamp: time.Date(2020, 1, 1, 12, 34, 56, 0, time.UTC)}
+	event := f.sanitize(scan)
+	encoded, _ := json.Marshal(event)
+	if strings.Contains(string(encoded), "example.com") {
+		t.Errorf("Expected event not to name the domain or its mailservers, got %s", encoded)
+	}
+	if event.DomainHash != f.sanitize(scan).DomainHash || len(event.DomainHash) != 64 {
+		t.Errorf("Expected a stable domain hash, got %s", event.DomainHash)
+	}
+	if other := NewFirehose([]byte("other"), 1, 1, 10).sanitize(scan); other.DomainHash == event.DomainHash {
+		t.Errorf("Expected domain hash to depend on the secret")
+	}
+	if !event.Time.Equal(time.Date(2020, 1, 1, 12, 34, 0, 0, time.UTC)) {
+		t.Errorf("Expected event time to be truncated, got %v", event.Time)
+	}
+	if event.Checks[checker.STARTTLS] != "Success" {
+		t.Errorf("Expected check outcomes, got %v", event.Checks)
+	}
+}
+
+func TestFirehoseStreamsEvents(t *testing.T) {
+	f := NewFirehose([]byte("secret"), 1, 1, 1000)
+	a := &API{Firehose: f}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := httptest.NewServer(http.HandlerFunc(a.firehose))
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	second, _ := http.Get(server.URL)
+	if second.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected subscribers to be limited, got %d", second.StatusCode)
+	}
+
+	f.Publish(models.Scan{Domain: "example.com", Data: checker.NewSampleDomainResult("example.com"), Timestamp: time.Now()})
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event firehoseEvent
+	if err := json.Unmarshal([]byte(line), &event); err != nil || event.DomainHash == "" {
+		t.Errorf("Expected a firehose event, got %s", line)
+	}
+}
+
+func TestFirehoseDisabled(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&API{}).firehose(w, httptest.NewRequest("GET", "/api/firehose", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected firehose to be disabled by default, got %d", w.Code)
+	}
+}
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/graphql"
+	"github.com/EFForg/starttls-backend/models"
+	"golang.org/x/net/idna"
+)
+
+const (
+	// maxGraphQLRequestSize limits the size of GraphQL requests.
+	maxGraphQLRequestSize = 16 << 10
+	// maxGraphQLDepth and maxGraphQLCost limit how deeply queries can nest
+	// fields and how many they can resolve, counting each domain a domains
+	// search may return, and each hostname or check a scan usually has.
+	maxGraphQLDepth = 10
+	maxGraphQLCost  = 10000
+	// graphQLScanListSize is the number of hostnames, or checks, a scan's
+	// lists are counted as having.
+	graphQLScanListSize = 10
+)
+
+// policyEntry is a domain's policy on the policy list.
+type policyEntry struct {
+	domain string
+	mode   string
+	mxs    []string
+}
+
+// graphQLDomainName converts a domain name argument to lowercase ASCII.
+func graphQLDomainName(args graphql.Args, name string) (string, error) {
+	ascii, err := idna.ToASCII(strings.ToLower(args.String(name)))
+	if err != nil || ascii == "" {
+		return "", fmt.Errorf("%s must be a domain name", name)
+	}
+	return ascii, nil
+}
+
+// scalar returns a field resolved by calling get on its source.
+func scalar(get func(source interface{}) interface{}) *graphql.Field {
+	return &graphql.Field{Resolve: func(_ context.Context, source interface{}, _ graphql.Args) (interface{}, error) {
+		return get(source), nil
+	}}
+}
+
+// optional returns s, or nil for null if it's empty.
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// sortedResults returns results in order of name.
+func sortedResults(results map[string]*checker.Result) []*checker.Result {
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sorted := make([]*checker.Result, 0, len(results))
+	for _, name := range names {
+		sorted = append(sorted, results[name])
+	}
+	return sorted
+}
+
+// graphQLSchema returns the read-only GraphQL schema of domains, their
+// scans and their policies:
+//
+//   type Query {
+//     domain(name: String!): Domain
+//     domains(query: String!, match: String, states: [String!], limit: Int): [Domain!]!
+//     scan(domain: String!): Scan
+//     policy(domain: String!): Policy
+//   }
+//   type Domain {
+//     name: String!, state: String!, mxs: [String!]!, mtaSts: Boolean!,
+//     mtaStsMode: String, queueWeeks: Int!, lastUpdated: String!,
+//     onPolicyList: Boolean!, policy: Policy, latestScan: Scan
+//   }
+//   type Scan {
+//     domain: String!, timestamp: String!, status: String!, grade: String,
+//     message: String, preferredHostnames: [String!]!,
+//     hostnames: [HostnameScan!]!, mtaSts: Check
+//   }
+//   type HostnameScan { hostname: String!, status: String!, checks: [Check!]! }
+//   type Check { name: String!, status: String!, description: String, messages: [String!]!, checks: [Check!]! }
+//   type Policy { domain: String!, mode: String!, mxs: [String!]! }
+//
+// The schema holds the request's scans, so it's built for each request. The
+// latest scans of the domains a search returns are loaded together, the first
+// time one of them is selected.
+func (api API) graphQLSchema() *graphql.Schema {
+	scans := map[string]*models.Scan{}
+	var unloaded []string
+	loadLater := func(domains []models.Domain) {
+		for _, domain := range domains {
+			if _, ok := scans[domain.Name]; !ok {
+				unloaded = append(unloaded, domain.Name)
+			}
+		}
+	}
+	latestScan := func(domain string) (interface{}, error) {
+		if _, ok := scans[domain]; !ok {
+			loaded, err := api.Database.GetLatestScansOf(append(unloaded, domain))
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range append(unloaded, domain) {
+				scans[name] = nil
+				if scan, ok := loaded[name]; ok {
+					scan.Data = scan.Data.WithoutTranscripts()
+					scans[name] = &scan
+				}
+			}
+			unloaded = nil
+		}
+		if scan := scans[domain]; scan != nil {
+			return scan, nil
+		}
+		return nil, nil
+	}
+	scanListSize := func(graphql.Args) int { return graphQLScanListSize }
+	policyOf := func(domain string) (interface{}, error) {
+		list := api.List.Raw()
+		p, err := list.Get(domain)
+		if err != nil {
+			return nil, nil
+		}
+		mxs := p.MXs
+		if mxs == nil {
+			mxs = []string{}
+		}
+		return &policyEntry{domain: domain, mode: p.Mode, mxs: mxs}, nil
+	}
+
+	checkType := &graphql.Object{Name: "Check"}
+	checkType.Fields = map[string]*graphql.Field{
+		"name":        scalar(func(s interface{}) interface{} { return s.(*checker.Result).Name }),
+		"status":      scalar(func(s interface{}) interface{} { return s.(*checker.Result).StatusText() }),
+		"description": scalar(func(s interface{}) interface{} { return optional(s.(*checker.Result).Description()) }),
+		"messages": scalar(func(s interface{}) interface{} {
+			if messages := s.(*checker.Result).Messages; messages != nil {
+				return messages
+			}
+			return []string{}
+		}),
+		"checks": {Type: checkType, ListSize: scanListSize, Resolve: func(_ context.Context, s interface{}, _ graphql.Args) (interface{}, error) {
+			return sortedResults(s.(*checker.Result).Checks), nil
+		}},
+	}
+	hostnameType := &graphql.Object{Name: "HostnameScan", Fields: map[string]*graphql.Field{
+		"hostname": scalar(func(s interface{}) interface{} { return s.(checker.HostnameResult).Hostname }),
+		"status":   scalar(func(s interface{}) interface{} { return s.(checker.HostnameResult).StatusText() }),
+		"checks": {Type: checkType, ListSize: scanListSize, Resolve: func(_ context.Context, s interface{}, _ graphql.Args) (interface{}, error) {
+			return sortedResults(s.(checker.HostnameResult).Checks), nil
+		}},
+	}}
+	scanType := &graphql.Object{Name: "Scan", Fields: map[string]*graphql.Field{
+		"domain":    scalar(func(s interface{}) interface{} { return s.(*models.Scan).Domain }),
+		"timestamp": scalar(func(s interface{}) interface{} { return s.(*models.Scan).Timestamp }),
+		"status": scalar(func(s interface{}) interface{} {
+			return newScanV2(*s.(*models.Scan)).Status
+		}),
+		"grade":   scalar(func(s interface{}) interface{} { return optional(string(s.(*models.Scan).Data.Grade)) }),
+		"message": scalar(func(s interface{}) interface{} { return optional(s.(*models.Scan).Data.Message) }),
+		"preferredHostnames": scalar(func(s interface{}) interface{} {
+			if hostnames := s.(*models.Scan).Data.PreferredHostnames; hostnames != nil {
+				return hostnames
+			}
+			return []string{}
+		}),
+		"hostnames": {Type: hostnameType, ListSize: scanListSize, Resolve: func(_ context.Context, s interface{}, _ graphql.Args) (interface{}, error) {
+			results := s.(*models.Scan).Data.HostnameResults
+			hostnames := make([]string, 0, len(results))
+			for hostname := range results {
+				hostnames = append(hostnames, hostname)
+			}
+			sort.Strings(hostnames)
+			sorted := make([]checker.HostnameResult, 0, len(results))
+			for _, hostname := range hostnames {
+				if results[hostname].Result != nil {
+					sorted = append(sorted, results[hostname])
+				}
+			}
+			return sorted, nil
+		}},
+		"mtaSts": {Type: checkType, Resolve: func(_ context.Context, s interface{}, _ graphql.Args) (interface{}, error) {
+			if result := s.(*models.Scan).Data.MTASTSResult; result != nil {
+				return result.Result, nil
+			}
+			return nil, nil
+		}},
+	}}
+	policyType := &graphql.Object{Name: "Policy", Fields: map[string]*graphql.Field{
+		"domain": scalar(func(s interface{}) interface{} { return s.(*policyEntry).domain }),
+		"mode":   scalar(func(s interface{}) interface{} { return s.(*policyEntry).mode }),
+		"mxs":    scalar(func(s interface{}) interface{} { return s.(*policyEntry).mxs }),
+	}}
+	domainType := &graphql.Object{Name: "Domain", Fields: map[string]*graphql.Field{
+		"name":  scalar(func(s interface{}) interface{} { return s.(models.Domain).Name }),
+		"state": scalar(func(s interface{}) interface{} { return string(s.(models.Domain).State) }),
+		"mxs": scalar(func(s interface{}) interface{} {
+			return newDomainV2(s.(models.Domain)).MXs
+		}),
+		"mtaSts":       scalar(func(s interface{}) interface{} { return s.(models.Domain).MTASTS }),
+		"mtaStsMode":   scalar(func(s interface{}) interface{} { return optional(s.(models.Domain).MTASTSMode) }),
+		"queueWeeks":   scalar(func(s interface{}) interface{} { return s.(models.Domain).QueueWeeks }),
+		"lastUpdated":  scalar(func(s interface{}) interface{} { return s.(models.Domain).LastUpdated }),
+		"onPolicyList": scalar(func(s interface{}) interface{} { return api.List.HasDomain(s.(models.Domain).Name) }),
+		"policy": {Type: policyType, Resolve: func(_ context.Context, s interface{}, _ graphql.Args) (interface{}, error) {
+			return policyOf(s.(models.Domain).Name)
+		}},
+		"latestScan": {Type: scanType, Cost: 2, Resolve: func(_ context.Context, s interface{}, _ graphql.Args) (interface{}, error) {
+			return latestScan(s.(models.Domain).Name)
+		}},
+	}}
+
+	query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"domain": {Type: domainType, Args: map[string]bool{"name": true}, Cost: 2,
+			Resolve: func(_ context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+				name, err := graphQLDomainName(args, "name")
+				if err != nil {
+					return nil, err
+				}
+				domain, err := models.GetDomain(api.Database, name)
+				if err == sql.ErrNoRows {
+					return nil, nil
+				}
+				return domain, err
+			}},
+		"domains": {Type: domainType, Args: map[string]bool{"query": true, "match": false, "states": false, "limit": false},
+			Cost: 10,
+			ListSize: func(args graphql.Args) int {
+				if limit, err := args.Int("limit", defaultDomainSearchSize); err == nil && limit <= maxDomainSearchSize {
+					return limit
+				}
+				return maxDomainSearchSize
+			},
+			Resolve: func(_ context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+				limit, err := args.Int("limit", defaultDomainSearchSize)
+				if err != nil {
+					return nil, err
+				}
+				if limit < 1 || limit > maxDomainSearchSize {
+					return nil, fmt.Errorf("limit must be between 1 and %d", maxDomainSearchSize)
+				}
+				search := models.DomainSearch{Query: strings.ToLower(args.String("query")), Match: args.String("match"), Limit: limit}
+				if err = search.Validate(); err != nil {
+					return nil, err
+				}
+				names, err := args.Strings("states")
+				if err != nil {
+					return nil, err
+				}
+				states := publicDomainStates
+				if len(names) > 0 {
+					states = nil
+				}
+				for _, name := range names {
+					state := models.DomainState(strings.ToLower(name))
+					if !containsState(publicDomainStates, state) {
+						return nil, fmt.Errorf("only %v domains can be listed", publicDomainStates)
+					}
+					states = append(states, state)
+				}
+				domains, err := api.Database.SearchDomains(search, states...)
+				loadLater(domains)
+				return domains, err
+			}},
+		"scan": {Type: scanType, Args: map[string]bool{"domain": true}, Cost: 2,
+			Resolve: func(_ context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+				domain, err := graphQLDomainName(args, "domain")
+				if err != nil {
+					return nil, err
+				}
+				return latestScan(domain)
+			}},
+		"policy": {Type: policyType, Args: map[string]bool{"domain": true},
+			Resolve: func(_ context.Context, _ interface{}, args graphql.Args) (interface{}, error) {
+				domain, err := graphQLDomainName(args, "domain")
+				if err != nil {
+					return nil, err
+				}
+				return policyOf(domain)
+			}},
+	}}
+	return &graphql.Schema{Query: query, MaxDepth: maxGraphQLDepth, MaxCost: maxGraphQLCost}
+}
+
+// GraphQL handles requests to /api/graphql
+//   GET /api/graphql?query=<query>
+//        variables (optional): JSON object of the query's variables.
+//        operationName (optional): Operation in the query to execute.
+//   POST /api/graphql
+//        A JSON object with the query, and optional variables and
+//        operationName.
+//        Responds with the GraphQL response, a JSON object with the
+//        selected data and any errors. See graphQLSchema for the schema.
+func (api *API) graphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				writeGraphQL(w, http.StatusBadRequest, graphql.Response{
+					Errors: []graphql.Error{{Message: "variables must be a JSON object"}}})
+				return
+			}
+		}
+	case http.MethodPost:
+		body := http.MaxBytesReader(w, r.Body, maxGraphQLRequestSize)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			writeGraphQL(w, http.StatusBadRequest, graphql.Response{
+				Errors: []graphql.Error{{Message: fmt.Sprintf("request must be a JSON object of at most %d bytes", maxGraphQLRequestSize)}}})
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if len(req.Query) > maxGraphQLRequestSize {
+		writeGraphQL(w, http.StatusBadRequest, graphql.Response{
+			Errors: []graphql.Error{{Message: fmt.Sprintf("query must be at most %d bytes", maxGraphQLRequestSize)}}})
+		return
+	}
+	resp := api.graphQLSchema().Execute(r.Context(), req)
+	status := http.StatusOK
+	if resp.Data == nil {
+		status = http.StatusBadRequest
+	}
+	writeGraphQL(w, status, resp)
+}
+
+func writeGraphQL(w http.ResponseWriter, status int, resp graphql.Response) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
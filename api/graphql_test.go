@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+type graphQLResult struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message string        `json:"message"`
+		Path    []interface{} `json:"path"`
+	} `json:"errors"`
+}
+
+// graphQLQuery POSTs query with variables to /api/graphql.
+func graphQLQuery(t *testing.T, query string, variables map[string]interface{}) (*http.Response, graphQLResult) {
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	resp, err := http.Post(server.URL+"/api/graphql", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result graphQLResult
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("/api/graphql returned invalid JSON: %v", err)
+	}
+	return resp, result
+}
+
+func TestGraphQLDomain(t *testing.T) {
+	defer teardown()
+	http.PostForm(server.URL+"/api/queue", validQueueData(true))
+	resp, result := graphQLQuery(t, `query Domain($name: String!) {
+		domain(name: $name) {
+			name
+			state
+			mxs
+			onPolicyList
+			latestScan { status hostnames { hostname checks { name status } } }
+		}
+		missing: domain(name: "missing.example") { name }
+	}`, map[string]interface{}{"name": "Example.com"})
+	if resp.StatusCode != http.StatusOK || len(result.Errors) > 0 {
+		t.Fatalf("Expected the query to succeed, got %d: %+v", resp.StatusCode, result.Errors)
+	}
+	domain, ok := result.Data["domain"].(map[string]interface{})
+	if !ok || domain["name"] != "example.com" || domain["state"] != "unvalidated" || domain["onPolicyList"] != false {
+		t.Fatalf("Expected the queued domain, got %+v", result.Data["domain"])
+	}
+	if mxs, _ := domain["mxs"].([]interface{}); len(mxs) != 1 {
+		t.Errorf("Expected the queued domain's MX, got %v", domain["mxs"])
+	}
+	scan, ok := domain["latestScan"].(map[string]interface{})
+	if !ok || scan["status"] != "success" {
+		t.Fatalf("Expected the domain's latest scan, got %+v", domain["latestScan"])
+	}
+	if hostnames, _ := scan["hostnames"].([]interface{}); len(hostnames) == 0 {
+		t.Errorf("Expected the scan's hostnames, got %v", scan["hostnames"])
+	}
+	if result.Data["missing"] != nil {
+		t.Errorf("Expected domains that aren't submitted to be null, got %v", result.Data["missing"])
+	}
+}
+
+func TestGraphQLPolicy(t *testing.T) {
+	defer teardown()
+	query := url.Values{
+		"query":     {`query($domain: String!) { policy(domain: $domain) { domain mode mxs } }`},
+		"variables": {`{"domain": "eff.org"}`},
+	}
+	resp, err := http.Get(server.URL + "/api/graphql?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result graphQLResult
+	json.NewDecoder(resp.Body).Decode(&result)
+	policy, ok := result.Data["policy"].(map[string]interface{})
+	if resp.StatusCode != http.StatusOK || !ok || policy["mode"] != "enforce" {
+		t.Fatalf("Expected eff.org's policy, got %d: %+v", resp.StatusCode, result)
+	}
+	if mxs, _ := policy["mxs"].([]interface{}); len(mxs) != 1 || mxs[0] != "mx.fake.com" {
+		t.Errorf("Expected eff.org's policy MXs, got %v", policy["mxs"])
+	}
+
+	_, result = graphQLQuery(t, `{ policy(domain: "example.com") { mode } }`, nil)
+	if _, ok := result.Data["policy"]; !ok || result.Data["policy"] != nil {
+		t.Errorf("Expected domains not on the list to have a null policy, got %+v", result)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	defer teardown()
+	resp, result := graphQLQuery(t, `{ domain(name: "eff.org") { secret } }`, nil)
+	if resp.StatusCode != http.StatusBadRequest || len(result.Errors) == 0 || result.Data != nil {
+		t.Errorf("Expected unknown fields to be rejected, got %d: %+v", resp.StatusCode, result)
+	}
+	resp, result = graphQLQuery(t, `mutation { domain(name: "eff.org") { name } }`, nil)
+	if resp.StatusCode != http.StatusBadRequest || len(result.Errors) == 0 {
+		t.Errorf("Expected mutations to be rejected, got %d: %+v", resp.StatusCode, result)
+	}
+
+	resp, result = graphQLQuery(t, `{ domains(query: "example", states: ["unvalidated"]) { name } }`, nil)
+	if resp.StatusCode != http.StatusOK || len(result.Errors) != 1 || result.Data["domains"] != nil {
+		t.Errorf("Expected listing unvalidated domains to fail, got %d: %+v", resp.StatusCode, result)
+	}
+
+	resp, err := http.Post(server.URL+"/api/graphql", "application/json",
+		bytes.NewReader(make([]byte, maxGraphQLRequestSize+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected oversized requests to be rejected, got %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/graphql", nil)
+	if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected PUT to be rejected, got %v", resp)
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	defer teardown()
+	resp, result := graphQLQuery(t, `{
+		a: domains(query: "example", limit: 100) { ...Scans }
+		b: domains(query: "example", limit: 100) { ...Scans }
+		c: domains(query: "example", limit: 100) { ...Scans }
+		d: domains(query: "example", limit: 100) { ...Scans }
+	}
+	fragment Scans on Domain { latestScan { hostnames { checks { name } } } }`, nil)
+	if resp.StatusCode != http.StatusBadRequest || len(result.Errors) != 1 || result.Data != nil {
+		t.Errorf("Expected costly queries to be rejected, got %d: %+v", resp.StatusCode, result)
+	}
+	resp, result = graphQLQuery(t, `{ domain(name: "example.com") { latestScan { mtaSts {
+		checks { checks { checks { checks { checks { checks { checks { checks { name } } } } } } } }
+	} } } }`, nil)
+	if resp.StatusCode != http.StatusBadRequest || len(result.Errors) != 1 {
+		t.Errorf("Expected deeply nested queries to be rejected, got %d: %+v", resp.StatusCode, result)
+	}
+}
+
+func TestGraphQLLoadsScansTogether(t *testing.T) {
+	defer teardown()
+	for _, name := range []string{"a.example.com", "b.example.com"} {
+		if err := api.Database.PutDomain(models.Domain{Name: name, Email: "admin@" + name}); err != nil {
+			t.Fatal(err)
+		}
+		api.Database.SetStatus(name, models.StateTesting, models.StateChange{})
+		scan := models.Scan{Domain: name, Data: checker.DomainResult{Domain: name, Status: checker.DomainSuccess}, Timestamp: time.Now()}
+		if err := api.Database.PutScan(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, result := graphQLQuery(t, `{ domains(query: "example.com", match: "substring") { name latestScan { domain status } } }`, nil)
+	domains, _ := result.Data["domains"].([]interface{})
+	if len(result.Errors) > 0 || len(domains) != 2 {
+		t.Fatalf("Expected both domains, got %+v", result)
+	}
+	for _, d := range domains {
+		domain := d.(map[string]interface{})
+		if scan, _ := domain["latestScan"].(map[string]interface{}); scan == nil || scan["domain"] != domain["name"] {
+			t.Errorf("Expected %v's own scan, got %v", domain["name"], domain["latestScan"])
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/EFForg/starttls-backend/checker/checkerpb"
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcCodes are the gRPC status codes of the HTTP statuses API key checks
+// refuse requests with.
+var grpcCodes = map[int]codes.Code{
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+// ScanServer returns the gRPC Scanner service, which scans domains with the
+// same checker as /api/scan. Like authenticated requests to /api/scan, each
+// scan needs an API key with the scan scope, sent as "authorization: Bearer
+// <key>" metadata, and counts against its daily quota, and domains on the
+// no-scan list are refused.
+func (api API) ScanServer() *checkerpb.ScanServer {
+	return &checkerpb.ScanServer{
+		Checker:   api.newChecker(false),
+		Authorize: api.authorizeGRPCScan,
+		Validate:  api.validateGRPCScan,
+	}
+}
+
+func (api API) authorizeGRPCScan(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	authorization := ""
+	if values := md.Get("authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+	key, httpStatus, err := api.useAPIKey(authorization, models.ScopeScan)
+	if err != nil {
+		return status.Error(grpcCodes[httpStatus], err.Error())
+	}
+	if _, err = api.Database.UseAPIKeyScan(key); err == sql.ErrNoRows {
+		return status.Error(codes.ResourceExhausted, "this API key's daily scan quota has been used up")
+	} else if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+func (api API) validateGRPCScan(_ context.Context, domain string) (string, error) {
+	ascii, err := asciiDomain(strings.ToLower(domain))
+	if err != nil || !util.ValidDomainName(ascii) {
+		return "", status.Errorf(codes.InvalidArgument, "%s is not a valid domain name", domain)
+	}
+	if api.refusesScan(ascii) {
+		return "", status.Errorf(codes.PermissionDenied, "%s can't be scanned", ascii)
+	}
+	return ascii, nil
+}
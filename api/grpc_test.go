@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/checker/checkerpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestScanServer(t *testing.T) {
+	defer teardown()
+	s := api.ScanServer()
+	scan := func(key string, domain string) error {
+		ctx := context.Background()
+		if key != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+key))
+		}
+		_, err := s.Scan(ctx, &checkerpb.ScanRequest{Domain: domain})
+		return err
+	}
+	if err := scan("", "eff.org"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected scans without a key to be refused, got %v", err)
+	}
+	if err := scan(registerTestKey(t, "queue").Key, "eff.org"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected scans with a key without the scan scope to be refused, got %v", err)
+	}
+	key := registerTestKey(t, "scan").Key
+	if err := scan(key, "not a domain"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected invalid domains to be refused, got %v", err)
+	}
+	if err := scan(key, "DontScan.com"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected domains on the no-scan list to be refused, got %v", err)
+	}
+}
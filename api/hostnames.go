@@ -0,0 +1,61 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// scanHostnames is a stored scan's full hostname results.
+type scanHostnames struct {
+	Domain    string                                `json:"domain"`
+	Timestamp time.Time                             `json:"timestamp"`
+	Results   map[string]checker.FullHostnameResult `json:"results"`
+}
+
+// ScanHostnames is the handler for /api/scan/hostnames.
+//   GET /api/scan/hostnames?domain=<domain>
+//        timestamp (optional): Use the latest scan at or before this
+//          RFC 3339 time, instead of the latest scan.
+//        verbose (optional): "true" to include SMTP session transcripts,
+//          if the scan recorded them.
+//        Sets every field of each of the scan's hostname results, like
+//        certificates and negotiated TLS versions, which /api/scan leaves
+//        out, and the scan's timestamp, as response.
+func (api API) scanHostnames(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed}
+	}
+	domain, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	var scan models.Scan
+	if param := r.URL.Query().Get("timestamp"); param != "" {
+		at, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return badRequest("timestamp must be an RFC 3339 time, like 2006-01-02T15:04:05Z")
+		}
+		scan, err = api.Database.GetScanAt(domain, at)
+	} else {
+		scan, err = api.Database.GetLatestScan(domain)
+	}
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound, Message: "no such scan"}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if r.FormValue("verbose") != "true" {
+		scan.Data = scan.Data.WithoutTranscripts()
+	}
+	results := make(map[string]checker.FullHostnameResult)
+	for hostname, result := range scan.Data.HostnameResults {
+		results[hostname] = result.Full()
+	}
+	return response{StatusCode: http.StatusOK,
+		Response: scanHostnames{Domain: scan.Domain, Timestamp: scan.Timestamp, Results: results}}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// MaxImportRows is the most domains a single import can hold.
+const MaxImportRows = 5000
+
+// maxImportBytes limits the size of an import's body.
+const maxImportBytes = 4 << 20
+
+// ImportDomains handles requests to /admin/import
+//   POST /admin/import?actor=<actor>
+//        Body is a JSON array of models.ImportRows, with Content-Type
+//        application/json, or a CSV with Content-Type text/csv whose header
+//        names the domain, mxs, state and optionally email, mta_sts and
+//        queue_weeks columns. MX hostnames in a CSV are separated by spaces.
+//        Up to 5000 domains, each imported in the state "queued" or "added"
+//        without email validation.
+//        actor: Who imported the domains, for the audit log.
+//        dry_run (optional): "true" to only validate the domains.
+//        Sets a models.ImportResult as response. If any row is invalid,
+//        responds 400 with the errors of each row, and imports nothing.
+//        Domains are imported in a single transaction, so if any can't be
+//        stored, none are.
+func (api API) importDomains(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/import only accepts POST requests"}
+	}
+	actor, err := getActor(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	body := http.MaxBytesReader(nil, r.Body, maxImportBytes)
+	var rows []models.ImportRow
+	switch contentType := r.Header.Get("Content-Type"); {
+	case strings.HasPrefix(contentType, "application/json"):
+		err = json.NewDecoder(body).Decode(&rows)
+	case strings.HasPrefix(contentType, "text/csv"):
+		rows, err = models.ParseImportCSV(body)
+	default:
+		return response{StatusCode: http.StatusUnsupportedMediaType,
+			Message: "imports must have Content-Type application/json or text/csv"}
+	}
+	if err != nil {
+		return badRequest("couldn't read import: %v", err)
+	}
+	if len(rows) == 0 {
+		return badRequest("import has no domains")
+	}
+	if len(rows) > MaxImportRows {
+		return badRequest("No more than %d domains can be imported at once", MaxImportRows)
+	}
+	domains, result, err := api.validateImport(rows)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if len(result.Errors) > 0 {
+		return response{StatusCode: http.StatusBadRequest,
+			Message:  fmt.Sprintf("%d of %d rows are invalid; nothing was imported", len(result.Errors), len(rows)),
+			Response: result}
+	}
+	if r.URL.Query().Get("dry_run") != "true" {
+		err = api.Database.ImportDomains(domains, models.StateChange{Actor: actor, Reason: "imported"})
+		if err != nil {
+			return serverError("importing failed, so nothing was imported: %v", err)
+		}
+	}
+	for _, domain := range domains {
+		result.Imported = append(result.Imported, domain.Name)
+	}
+	return response{StatusCode: http.StatusOK, Response: result}
+}
+
+// validateImport returns the domains to import from rows, and the errors of
+// any rows that can't be imported, as a result with nothing imported yet.
+func (api API) validateImport(rows []models.ImportRow) ([]models.Domain, models.ImportResult, error) {
+	result := models.ImportResult{Imported: []string{}, Errors: []models.ImportError{}}
+	domains := []models.Domain{}
+	seen := make(map[string]int)
+	for i, row := range rows {
+		fail := func(format string, a ...interface{}) {
+			result.Errors = append(result.Errors,
+				models.ImportError{Row: i + 1, Domain: row.Domain, Error: fmt.Sprintf(format, a...)})
+		}
+		domain, err := row.ToDomain()
+		if err != nil {
+			fail(err.Error())
+			continue
+		}
+		if len(domain.MXs) > MaxHostnames {
+			fail("No more than %d MX hostnames are permitted", MaxHostnames)
+			continue
+		}
+		if first, ok := seen[domain.Name]; ok {
+			fail("%s is also in row %d", domain.Name, first)
+			continue
+		}
+		seen[domain.Name] = i + 1
+		if domain.State == models.StateTesting {
+			if domain.QueueWeeks == 0 {
+				domain.QueueWeeks = api.QueuePolicy.Class(domain.Name).DefaultWeeks()
+			}
+			if err = api.QueuePolicy.CheckWeeks(domain); err != nil {
+				fail(err.Error())
+				continue
+			}
+		}
+		existing, err := models.GetDomain(api.Database, domain.Name)
+		if err == nil {
+			fail("%s is already stored as %s", domain.Name, existing.State)
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, result, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, result, nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func importRequest(t *testing.T, query string, contentType string, body string) (*http.Response, models.ImportResult) {
+	req, _ := http.NewRequest("POST", server.URL+"/admin/import?"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var decoded struct {
+		Response models.ImportResult `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp, decoded.Response
+}
+
+func TestImportDomains(t *testing.T) {
+	defer teardown()
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+	api.Database.PutDomain(models.Domain{Name: "existing.com", MXs: []string{"mx.existing.com"}})
+	api.Database.PutDomain(models.Domain{Name: "held.com", MXs: []string{"mx.held.com"}})
+	api.Database.SetStatus("held.com", models.StateHeld, models.StateChange{})
+
+	invalid := `[{"domain": "good.com", "mxs": ["mx.good.com"], "state": "added"},
+		{"domain": "existing.com", "mxs": ["mx.existing.com"], "state": "added"},
+		{"domain": "good.com", "mxs": ["mx.good.com"], "state": "queued"},
+		{"domain": "held.com", "mxs": ["mx.held.com"], "state": "queued"}]`
+	resp, result := importRequest(t, "actor=alice", "application/json", invalid)
+	if resp.StatusCode != http.StatusBadRequest || len(result.Errors) != 3 || result.Errors[1].Row != 3 ||
+		result.Errors[2].Domain != "held.com" {
+		t.Fatalf("Expected the existing, held and repeated domains to be refused, got %d %+v", resp.StatusCode, result)
+	}
+	if _, err := api.Database.GetDomain("good.com", models.StateEnforce); err == nil {
+		t.Error("Expected nothing to be imported from an invalid import")
+	}
+
+	csv := "domain,mxs,state\ngood.com,mx.good.com,added\nqueued.com,mx1.queued.com mx2.queued.com,queued\n"
+	if resp, _ = importRequest(t, "actor=alice", "text/plain", csv); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected an unknown content type to be refused, got %d", resp.StatusCode)
+	}
+	resp, result = importRequest(t, "actor=alice&dry_run=true", "text/csv", csv)
+	if resp.StatusCode != http.StatusOK || len(result.Imported) != 2 {
+		t.Fatalf("Expected a dry run to validate both domains, got %d %+v", resp.StatusCode, result)
+	}
+	if _, err := api.Database.GetDomain("good.com", models.StateEnforce); err == nil {
+		t.Error("Expected a dry run not to import anything")
+	}
+	resp, result = importRequest(t, "actor=alice", "text/csv", csv)
+	if resp.StatusCode != http.StatusOK || len(result.Imported) != 2 {
+		t.Fatalf("Importing failed with %d: %+v", resp.StatusCode, result)
+	}
+	if _, err := api.Database.GetDomain("good.com", models.StateEnforce); err != nil {
+		t.Errorf("Expected good.com to be on the list: %v", err)
+	}
+	queued, err := api.Database.GetDomain("queued.com", models.StateTesting)
+	if err != nil || len(queued.MXs) != 2 || queued.QueueWeeks == 0 {
+		t.Errorf("Expected queued.com to be queued with its MXs and default weeks, got %+v, %v", queued, err)
+	}
+	entries, _ := api.Database.GetAuditLog("queued.com")
+	if len(entries) != 1 || entries[0].Actor != "alice" || !strings.Contains(entries[0].Details, "imported") {
+		t.Errorf("Expected the import to be audited, got %+v", entries)
+	}
+}
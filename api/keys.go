@@ -0,0 +1,331 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+type keyHandler func(r *http.Request, key models.APIKey) response
+
+// requestKeyKey is the context key for the API key a request was
+// authenticated with.
+type requestKeyKey struct{}
+
+// withRequestKey records that r was authenticated with key.
+func withRequestKey(r *http.Request, key models.APIKey) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestKeyKey{}, key))
+}
+
+// requestKey returns the API key r was authenticated with, if any.
+func requestKey(r *http.Request) (models.APIKey, bool) {
+	key, ok := r.Context().Value(requestKeyKey{}).(models.APIKey)
+	return key, ok
+}
+
+// withAPIKey restricts a handler to requests bearing an unrevoked API key with
+// the given scope, sent as `Authorization: Bearer <key>`. Each authenticated
+// request is counted in the key's usage stats, and has the key attached.
+func (api API) withAPIKey(scope string, handler keyHandler) apiHandler {
+	return func(r *http.Request) response {
+		key, status, err := api.useAPIKey(r.Header.Get("Authorization"), scope)
+		if err != nil {
+			return response{StatusCode: status, Message: err.Error()}
+		}
+		return handler(withRequestKey(r, key), key)
+	}
+}
+
+// useAPIKey looks up the unrevoked API key given as a "Bearer <key>"
+// authorization, counting its use, and checks that it has scope. If it
+// doesn't, it returns the HTTP status to refuse the request with, and why.
+func (api API) useAPIKey(authorization string, scope string) (models.APIKey, int, error) {
+	given := strings.TrimPrefix(authorization, "Bearer ")
+	if given == "" {
+		return models.APIKey{}, http.StatusUnauthorized, errors.New("an API key is required")
+	}
+	key, err := api.Database.UseAPIKey(models.HashAPIKey(given))
+	if err == sql.ErrNoRows {
+		return key, http.StatusUnauthorized, errors.New("invalid or revoked API key")
+	}
+	if err != nil {
+		return key, http.StatusInternalServerError, err
+	}
+	if !key.HasScope(scope) {
+		return key, http.StatusForbidden, errors.New("this API key doesn't have the " + scope + " scope")
+	}
+	return key, http.StatusOK, nil
+}
+
+// RegisterForKeys handles requests to /api/keys/register
+//   POST /api/keys/register
+//        email: Address to register for API keys. A verification token
+//          is sent to it, which can be redeemed at /api/keys/verify.
+func (api API) registerForKeys(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/keys/register only accepts POST requests"}
+	}
+	address, err := getParam("email", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if parsed, err := mail.ParseAddress(address); err != nil || parsed.Address != address {
+		return badRequest("%s is not a valid email address", address)
+	}
+	token, err := api.Database.PutAPIKeyToken(address)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if err = api.Emailer.SendAPIKeyVerification(address, token); err != nil {
+		log.Print(err)
+		return serverError("Unable to send verification e-mail")
+	}
+	return response{StatusCode: http.StatusOK,
+		Response: "Please check " + address + " for a token to create your first API key."}
+}
+
+// getKeyParams extracts the name and scopes of a new API key from a request.
+// Keys have every scope if none are specified.
+func getKeyParams(r *http.Request) (models.APIKey, error) {
+	r.ParseForm()
+	scopes, err := models.ParseScopes(r.Form["scopes"])
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if len(scopes) == 0 {
+		scopes = models.Scopes
+	}
+	return models.APIKey{Name: r.FormValue("name"), Scopes: scopes}, nil
+}
+
+// createKey stores a new API key, and returns it along with its secret.
+func (api API) createKey(key models.APIKey) response {
+	secret := models.NewAPIKeySecret()
+	key, err := api.Database.PutAPIKey(key, models.HashAPIKey(secret))
+	if err != nil {
+		return serverError(err.Error())
+	}
+	key.Key = secret
+	return response{StatusCode: http.StatusOK, Response: key}
+}
+
+// VerifyForKeys handles requests to /api/keys/verify
+//   POST /api/keys/verify
+//        token: Verification token sent by /api/keys/register.
+//        name (optional): Label for the new key.
+//        scopes (optional): Scopes for the new key, any of "scan", "queue"
+//          and "keys". Defaults to all of them.
+//        Sets the new models.APIKey, including its secret key, as response.
+func (api API) verifyForKeys(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/keys/verify only accepts POST requests"}
+	}
+	token, err := getParam("token", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	key, err := getKeyParams(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	key.Email, err = api.Database.UseAPIKeyToken(token)
+	if err == sql.ErrNoRows {
+		return badRequest("token is invalid, expired, or has already been used")
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	return api.createKey(key)
+}
+
+// Keys handles requests to /api/keys, authenticated with a key with the
+// "keys" scope.
+//   GET /api/keys
+//        Sets the owner's models.APIKeys, with usage stats, as response.
+//   POST /api/keys
+//        name (optional): Label for the new key.
+//        scopes (optional): Scopes for the new key. Defaults to all of them.
+//        Sets the new models.APIKey, including its secret key, as response.
+func (api API) keys(r *http.Request, owner models.APIKey) response {
+	if r.Method == http.MethodGet {
+		keys, err := api.Database.GetAPIKeys(owner.Email)
+		if err != nil {
+			return serverError(err.Error())
+		}
+		return response{StatusCode: http.StatusOK, Response: keys}
+	}
+	if r.Method == http.MethodPost {
+		key, err := getKeyParams(r)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		key.Email = owner.Email
+		return api.createKey(key)
+	}
+	return response{StatusCode: http.StatusMethodNotAllowed,
+		Message: "/api/keys only accepts POST and GET requests"}
+}
+
+func getKeyID(r *http.Request) (int64, error) {
+	param, err := getParam("id", r)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(param, 10, 64)
+}
+
+// RotateKey handles requests to /api/keys/rotate, authenticated with a key
+// with the "keys" scope.
+//   POST /api/keys/rotate
+//        id: ID of the owner's key to rotate. The old secret stops working.
+//        Sets the models.APIKey, including its new secret key, as response.
+func (api API) rotateKey(r *http.Request, owner models.APIKey) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/keys/rotate only accepts POST requests"}
+	}
+	id, err := getKeyID(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	secret := models.NewAPIKeySecret()
+	key, err := api.Database.RotateAPIKey(id, owner.Email, models.HashAPIKey(secret))
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound, Message: "no such API key"}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	key.Key = secret
+	return response{StatusCode: http.StatusOK, Response: key}
+}
+
+// RevokeKey handles requests to /api/keys/revoke, authenticated with a key
+// with the "keys" scope.
+//   POST /api/keys/revoke
+//        id: ID of the owner's key to revoke.
+//        Sets the revoked models.APIKey as response.
+func (api API) revokeKey(r *http.Request, owner models.APIKey) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/keys/revoke only accepts POST requests"}
+	}
+	id, err := getKeyID(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	key, err := api.Database.RevokeAPIKey(id, owner.Email)
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound, Message: "no such API key"}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	return response{StatusCode: http.StatusOK, Response: key}
+}
+
+// keyUsageDays is how far back /api/keys/{id}/usage reports daily usage.
+const keyUsageDays = 30
+
+// keyUsage reports an API key's usage and remaining scan quota.
+type keyUsage struct {
+	Key        models.APIKey `json:"key"`
+	ScansToday int64         `json:"scans_today"`
+	// ScansRemaining is -1 if the key has no scan quota.
+	ScansRemaining int64                `json:"scans_remaining"`
+	Days           []models.APIKeyUsage `json:"days"`
+}
+
+// withQuotaHeaders sets X-RateLimit headers describing a key's remaining
+// daily scan quota, if it has one.
+func withQuotaHeaders(resp response, key models.APIKey, scansToday int64) response {
+	if key.ScanQuota <= 0 {
+		return resp
+	}
+	if resp.header == nil {
+		resp.header = http.Header{}
+	}
+	resp.header.Set("X-RateLimit-Limit", strconv.FormatInt(key.ScanQuota, 10))
+	resp.header.Set("X-RateLimit-Remaining", strconv.FormatInt(key.ScansRemaining(scansToday), 10))
+	return resp
+}
+
+// KeyUsage handles requests to /api/keys/{id}/usage, authenticated with a key
+// with the "keys" scope.
+//   GET /api/keys/{id}/usage
+//        Sets the owner's key, its scans today and remaining scan quota,
+//        and its requests and scans on each of the last 30 days as response.
+func (api API) keyUsage(r *http.Request, owner models.APIKey) response {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/keys/"), "/"), "/")
+	if len(parts) != 2 || parts[1] != "usage" {
+		return response{StatusCode: http.StatusNotFound, Message: "no such endpoint"}
+	}
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/keys/{id}/usage only accepts GET requests"}
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return badRequest("invalid API key id %s", parts[0])
+	}
+	keys, err := api.Database.GetAPIKeys(owner.Email)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	usage := keyUsage{}
+	found := false
+	for _, key := range keys {
+		if key.ID == id {
+			usage.Key, found = key, true
+		}
+	}
+	if !found {
+		return response{StatusCode: http.StatusNotFound, Message: "no such API key"}
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	usage.Days, err = api.Database.GetAPIKeyUsage(id, today.AddDate(0, 0, 1-keyUsageDays))
+	if err != nil {
+		return serverError(err.Error())
+	}
+	for _, day := range usage.Days {
+		if !day.Day.Before(today) {
+			usage.ScansToday = day.Scans
+		}
+	}
+	usage.ScansRemaining = usage.Key.ScansRemaining(usage.ScansToday)
+	return withQuotaHeaders(response{StatusCode: http.StatusOK, Response: usage}, usage.Key, usage.ScansToday)
+}
+
+// meteredScan handles requests to /api/scan. Requests may be authenticated
+// with a key with the "scan" scope, in which case new scans are counted
+// against the key's daily scan quota. See scan.
+func (api API) meteredScan(r *http.Request) response {
+	if r.Header.Get("Authorization") == "" {
+		return api.scan(r)
+	}
+	return api.withAPIKey(models.ScopeScan, func(r *http.Request, key models.APIKey) response {
+		if r.Method != http.MethodPost {
+			return api.scan(r)
+		}
+		scans, err := api.Database.UseAPIKeyScan(key)
+		if err == sql.ErrNoRows {
+			return withQuotaHeaders(response{StatusCode: http.StatusTooManyRequests, code: errQuotaExceeded,
+				Message: "this API key's daily scan quota has been used up"}, key, key.ScanQuota)
+		}
+		if err != nil {
+			return serverError(err.Error())
+		}
+		r = withScanClient(r, "key:"+strconv.FormatInt(key.ID, 10))
+		return withQuotaHeaders(api.scan(r), key, scans)
+	})(r)
+}
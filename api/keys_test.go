@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func keyRequest(t *testing.T, method string, path string, key string, data url.Values) (*http.Response, models.APIKey) {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(data.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Response models.APIKey `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp, body.Response
+}
+
+func formatID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+func registerTestKey(t *testing.T, scopes string) models.APIKey {
+	resp, _ := keyRequest(t, "POST", "/api/keys/register", "", url.Values{"email": {"someone@example.com"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Registering for keys failed with %d", resp.StatusCode)
+	}
+	resp, key := keyRequest(t, "POST", "/api/keys/verify", "",
+		url.Values{"token": {lastAPIKeyToken}, "name": {"first"}, "scopes": {scopes}})
+	if resp.StatusCode != http.StatusOK || key.Key == "" {
+		t.Fatalf("Verifying for keys failed with %d", resp.StatusCode)
+	}
+	return key
+}
+
+func TestRegisterForKeys(t *testing.T) {
+	defer teardown()
+
+	resp, _ := keyRequest(t, "POST", "/api/keys/register", "", url.Values{"email": {"not an address"}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid address to be rejected, got %d", resp.StatusCode)
+	}
+	key := registerTestKey(t, "")
+	if key.Email != "someone@example.com" || len(key.Scopes) != len(models.Scopes) {
+		t.Errorf("Expected a key with every scope for the registered address, got %+v", key)
+	}
+	resp, _ = keyRequest(t, "POST", "/api/keys/verify", "", url.Values{"token": {lastAPIKeyToken}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a used token to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestManageKeys(t *testing.T) {
+	defer teardown()
+	first := registerTestKey(t, "keys,scan")
+
+	resp, second := keyRequest(t, "POST", "/api/keys", first.Key, url.Values{"name": {"second"}, "scopes": {"scan"}})
+	if resp.StatusCode != http.StatusOK || second.Email != first.Email || second.Key == "" {
+		t.Fatalf("Creating a key failed with %d: %+v", resp.StatusCode, second)
+	}
+	resp, _ = keyRequest(t, "GET", "/api/keys", second.Key, nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a key without the keys scope to be refused, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/api/keys", nil)
+	req.Header.Set("Authorization", "Bearer "+first.Key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		Response []models.APIKey `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list.Response) != 2 || list.Response[0].Key != "" {
+		t.Fatalf("Expected two keys without secrets, got %+v", list.Response)
+	}
+	if list.Response[0].Requests < 2 || list.Response[0].LastUsed.IsZero() {
+		t.Errorf("Expected usage stats to be recorded, got %+v", list.Response[0])
+	}
+
+	resp, rotated := keyRequest(t, "POST", "/api/keys/rotate", first.Key, url.Values{"id": {formatID(second.ID)}})
+	if resp.StatusCode != http.StatusOK || rotated.Key == "" || rotated.Key == second.Key {
+		t.Fatalf("Rotating a key failed with %d", resp.StatusCode)
+	}
+	resp, _ = keyRequest(t, "GET", "/api/keys", second.Key, nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a rotated secret to stop working, got %d", resp.StatusCode)
+	}
+
+	resp, _ = keyRequest(t, "POST", "/api/keys/revoke", first.Key, url.Values{"id": {formatID(first.ID)}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Revoking a key failed with %d", resp.StatusCode)
+	}
+	resp, _ = keyRequest(t, "GET", "/api/keys", first.Key, nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to stop working, got %d", resp.StatusCode)
+	}
+}
+
+func TestKeyQuota(t *testing.T) {
+	defer teardown()
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+	key := registerTestKey(t, "keys,scan")
+
+	resp, updated := keyRequest(t, "POST", "/admin/keys/quota", "secret",
+		url.Values{"id": {formatID(key.ID)}, "quota": {"1"}})
+	if resp.StatusCode != http.StatusOK || updated.ScanQuota != 1 {
+		t.Fatalf("Setting a quota failed with %d: %+v", resp.StatusCode, updated)
+	}
+	resp, _ = keyRequest(t, "POST", "/api/scan", key.Key, url.Values{"domain": {"eff.org"}})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("Expected a scan with 0 remaining, got %d with %q remaining",
+			resp.StatusCode, resp.Header.Get("X-RateLimit-Remaining"))
+	}
+	resp, _ = keyRequest(t, "POST", "/api/scan", key.Key, url.Values{"domain": {"eff.org"}})
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected the quota to be enforced, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/api/keys/"+formatID(key.ID)+"/usage", nil)
+	req.Header.Set("Authorization", "Bearer "+key.Key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var usage struct {
+		Response keyUsage `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&usage)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || usage.Response.ScansToday != 1 || usage.Response.ScansRemaining != 0 {
+		t.Errorf("Expected one scan today and none remaining, got %d: %+v", resp.StatusCode, usage.Response)
+	}
+	if len(usage.Response.Days) != 1 || usage.Response.Days[0].Requests < 3 {
+		t.Errorf("Expected today's requests to be counted, got %+v", usage.Response.Days)
+	}
+}
+
+func TestAdminKeys(t *testing.T) {
+	defer teardown()
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+	user := registerTestKey(t, "keys")
+
+	if resp, _ := keyRequest(t, "GET", "/admin/slo", user.Key, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a key without an admin scope to be refused, got %d", resp.StatusCode)
+	}
+	if resp, _ := keyRequest(t, "POST", "/api/keys", user.Key, url.Values{"scopes": {"list-read"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected users not to be able to grant admin scopes, got %d", resp.StatusCode)
+	}
+	if resp, _ := keyRequest(t, "POST", "/admin/keys", "secret",
+		url.Values{"email": {"reader@example.com"}, "scopes": {"list-read"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a key issued without an actor to be refused, got %d", resp.StatusCode)
+	}
+	resp, reader := keyRequest(t, "POST", "/admin/keys", "secret",
+		url.Values{"email": {"reader@example.com"}, "scopes": {"list-read"}, "actor": {"alice"}})
+	if resp.StatusCode != http.StatusOK || reader.Key == "" || !reader.HasScope(models.ScopeListRead) {
+		t.Fatalf("Issuing a list-read key failed with %d: %+v", resp.StatusCode, reader)
+	}
+	entries, _ := api.Database.GetAuditLog("reader@example.com")
+	if len(entries) != 1 || entries[0].Action != "key.issue" || entries[0].Actor != "alice" {
+		t.Errorf("Expected the key to be audited, got %+v", entries)
+	}
+	if resp, _ = keyRequest(t, "GET", "/admin/slo", reader.Key, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a list-read key to read admin endpoints, got %d", resp.StatusCode)
+	}
+	blockData := url.Values{"kind": {"domain"}, "pattern": {"junk.example"}}
+	if resp, _ = keyRequest(t, "POST", "/admin/blocks", reader.Key, blockData); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a list-read key not to change anything, got %d", resp.StatusCode)
+	}
+
+	_, writer := keyRequest(t, "POST", "/admin/keys", "secret",
+		url.Values{"email": {"writer@example.com"}, "scopes": {"admin-write"}, "actor": {"alice"}})
+	if resp, _ = keyRequest(t, "POST", "/admin/blocks", writer.Key, blockData); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected an admin-write key to change admin endpoints, got %d", resp.StatusCode)
+	}
+	entries, _ = api.Database.GetAuditLog("junk.example")
+	if len(entries) != 1 || entries[0].Actor != "writer@example.com (key "+formatID(writer.ID)+")" {
+		t.Errorf("Expected the key's owner to be audited as the actor, got %+v", entries)
+	}
+	resp, revoked := keyRequest(t, "POST", "/admin/keys/revoke", writer.Key,
+		url.Values{"id": {formatID(reader.ID)}, "email": {"reader@example.com"}})
+	if resp.StatusCode != http.StatusOK || !revoked.Revoked {
+		t.Fatalf("Revoking the key failed with %d: %+v", resp.StatusCode, revoked)
+	}
+	if resp, _ = keyRequest(t, "GET", "/admin/slo", reader.Key, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be refused, got %d", resp.StatusCode)
+	}
+}
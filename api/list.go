@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+)
+
+// PolicyList handles requests to /api/list
+//   GET /api/list
+//        Responds with the current policy list.
+//   GET /api/list?canonical=true
+//        Responds with just the current policy list, as canonical JSON
+//        (see policy.List.MarshalCanonical), so its bytes can be signed
+//        or diffed against mirrors.
+func (api *API) policyList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || r.URL.Query().Get("canonical") != "true" {
+		api.wrapper(api.list)(w, r)
+		return
+	}
+	body, err := api.List.Raw().MarshalCanonical()
+	if err != nil {
+		api.wrapper(func(*http.Request) response { return serverError(err.Error()) })(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func (api *API) list(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed}
+	}
+	return response{StatusCode: http.StatusOK, Response: api.List.Raw()}
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetCanonicalList(t *testing.T) {
+	resp, err := http.Get(server.URL + "/api/list?canonical=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/list failed with error %d", resp.StatusCode)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	want := `"policies":{"eff.org":{"mode":"enforce","mxs":["mx.fake.com"]}},"policy-aliases":{},"timestamp":`
+	if !strings.Contains(string(body), want) || !strings.HasPrefix(string(body), `{"author":""`) {
+		t.Errorf("Expected canonical list containing %s, got %s", want, body)
+	}
+}
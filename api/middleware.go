@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/EFForg/starttls-backend/tracing"
 	raven "github.com/getsentry/raven-go"
 	"github.com/gorilla/handlers"
 	"github.com/ulule/limiter"
@@ -16,15 +17,15 @@ import (
 	"github.com/ulule/limiter/drivers/store/memory"
 )
 
-func middleware(mux *http.ServeMux) http.Handler {
+func middleware(mux http.Handler) http.Handler {
 	allowedOrigins := strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",")
 	originsOk := handlers.AllowedOrigins(allowedOrigins)
 
-	return handlers.LoggingHandler(os.Stdout,
+	return tracing.Middleware(handlers.LoggingHandler(os.Stdout,
 		recoveryHandler(
 			throttleHandler(time.Minute, 10, handlers.CORS(originsOk)(mux)),
 		),
-	)
+	))
 }
 
 func throttleHandler(period time.Duration, limit int64, f http.Handler) http.Handler {
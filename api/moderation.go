@@ -0,0 +1,128 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// moderationActor attributes flags raised by our anti-abuse rules in the
+// audit log.
+const moderationActor = "anti-abuse"
+
+// flagSubmission holds a queue submission for review instead of emailing its
+// validation link.
+func (api API) flagSubmission(domain models.Domain, reasons []string) response {
+	if err := domain.InitializeFlagged(api.Database); err != nil {
+		return serverError(err.Error())
+	}
+	m := models.Moderation{Domain: domain.Name, Reasons: reasons, Flagged: time.Now()}
+	if err := api.Database.PutModeration(m); err != nil {
+		return serverError(err.Error())
+	}
+	api.audit(models.AuditEntry{Actor: moderationActor, Action: "moderation.flag",
+		Subject: domain.Name, Details: strings.Join(reasons, "; ")})
+	return response{
+		StatusCode: http.StatusAccepted,
+		Response: fmt.Sprintf("Thank you for submitting your domain. Our team needs to review this submission "+
+			"before we can send a validation email to %s.", domain.Name),
+	}
+}
+
+// audit records an entry in the audit log. Failing to record it shouldn't
+// fail the action itself, so errors are only logged.
+func (api API) audit(entry models.AuditEntry) {
+	if _, err := api.Database.PutAuditEntry(entry); err != nil {
+		log.Printf("Couldn't record %s on %s in the audit log: %v", entry.Action, entry.Subject, err)
+	}
+}
+
+// Moderation handles requests to /admin/moderation
+//   GET /admin/moderation
+//        Sets the models.Moderations awaiting review, oldest first, as
+//        response.
+//   POST /admin/moderation
+//        domain: Domain whose flagged submission to decide on.
+//        action: "approve" to email the domain's validation link, or
+//          "reject" to fail the submission and tell the domain why.
+//        actor: Who made the decision, for the audit log.
+//        note (optional): Reason for the decision. Included in the
+//          rejection email.
+//        Sets the decided models.Moderation as response.
+func (api API) moderation(r *http.Request) response {
+	if r.Method == http.MethodGet {
+		pending, err := api.Database.GetPendingModerations()
+		if err != nil {
+			return serverError(err.Error())
+		}
+		return response{StatusCode: http.StatusOK, Response: pending}
+	}
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/moderation only accepts POST and GET requests"}
+	}
+	name, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	action, err := getParam("action", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	decision := map[string]string{
+		"approve": models.DecisionApproved,
+		"reject":  models.DecisionRejected,
+	}[action]
+	if decision == "" {
+		return badRequest("action must be approve or reject")
+	}
+	reviewer, err := getActor(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	note := r.FormValue("note")
+	domain, err := api.Database.GetDomain(name, models.StateFlagged)
+	if err != nil {
+		return response{StatusCode: http.StatusNotFound,
+			Message: fmt.Sprintf("%s has no submission awaiting review", name)}
+	}
+	m, err := api.Database.DecideModeration(name, decision, reviewer, note)
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound,
+			Message: fmt.Sprintf("%s has no submission awaiting review", name)}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	api.audit(models.AuditEntry{Actor: reviewer, Action: "moderation." + action,
+		Subject: name, Details: note})
+	if decision == models.DecisionRejected {
+		if err := api.Database.SetStatus(name, models.StateFailed,
+			models.StateChange{Actor: reviewer, Reason: note}); err != nil {
+			return serverError(err.Error())
+		}
+		if err := api.Emailer.SendSubmissionRejected(&domain, note); err != nil {
+			log.Print(err)
+			return serverError("Unable to send rejection e-mail")
+		}
+		return response{StatusCode: http.StatusOK, Response: m}
+	}
+	if err := api.Database.SetStatus(name, models.StateUnconfirmed,
+		models.StateChange{Actor: reviewer, Reason: "submission approved"}); err != nil {
+		return serverError(err.Error())
+	}
+	token, err := api.Database.PutToken(name)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if err := api.Emailer.SendValidation(&domain, token.Token); err != nil {
+		log.Print(err)
+		return serverError("Unable to send validation e-mail")
+	}
+	return response{StatusCode: http.StatusOK, Response: m}
+}
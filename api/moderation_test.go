@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// queueFlagged queues a subdomain of a domain on the no-scan list, which is
+// held for review.
+func queueFlagged(t *testing.T) url.Values {
+	data := url.Values{}
+	data.Set("domain", "mail.dontscan.com")
+	http.PostForm(server.URL+"/api/scan", data)
+	data.Add("hostnames", "mx.mail.dontscan.com")
+	resp, err := http.PostForm(server.URL+"/api/queue", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected submission to be held for review, got %d", resp.StatusCode)
+	}
+	return data
+}
+
+func moderate(t *testing.T, method string, data url.Values) (*http.Response, response) {
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+	req, _ := http.NewRequest(method, server.URL+"/admin/moderation", strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body response
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp, body
+}
+
+func TestModerationQueue(t *testing.T) {
+	defer teardown()
+	queueFlagged(t)
+
+	domain, err := models.GetDomain(api.Database, "mail.dontscan.com")
+	if err != nil || domain.State != models.StateFlagged {
+		t.Fatalf("Expected flagged domain, got %v (%v)", domain, err)
+	}
+	_, body := moderate(t, "GET", url.Values{})
+	pending, _ := body.Response.([]interface{})
+	if len(pending) != 1 || pending[0].(map[string]interface{})["domain"] != "mail.dontscan.com" {
+		t.Errorf("Expected flagged domain in review queue, got %v", body.Response)
+	}
+}
+
+func TestModerationReject(t *testing.T) {
+	defer teardown()
+	data := queueFlagged(t)
+	data.Set("action", "reject")
+	resp, _ := moderate(t, "POST", data)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected decision without a reviewer to be rejected, got %d", resp.StatusCode)
+	}
+
+	data.Set("actor", "alice")
+	data.Set("note", "not your domain")
+	resp, _ = moderate(t, "POST", data)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected rejection to succeed, got %d", resp.StatusCode)
+	}
+	if lastRejected != "mail.dontscan.com" {
+		t.Errorf("Expected rejection email for mail.dontscan.com, got %q", lastRejected)
+	}
+	if _, err := api.Database.GetDomain("mail.dontscan.com", models.StateFailed); err != nil {
+		t.Errorf("Expected rejected domain to fail: %v", err)
+	}
+	entries, err := api.Database.GetAuditLog("mail.dontscan.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[1].Actor != "alice" || entries[1].Action != "moderation.reject" {
+		t.Errorf("Expected reviewer's decision in the audit log, got %v", entries)
+	}
+	if entries[0].Action != models.ActionStateChange || entries[0].Details != "flagged to failed: not your domain" {
+		t.Errorf("Expected rejection's state change in the audit log, got %v", entries)
+	}
+
+	resp, _ = moderate(t, "POST", data)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected deciding twice to fail, got %d", resp.StatusCode)
+	}
+}
+
+func TestModerationApprove(t *testing.T) {
+	defer teardown()
+	data := queueFlagged(t)
+	data.Set("action", "approve")
+	data.Set("actor", "alice")
+	resp, _ := moderate(t, "POST", data)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected approval to succeed, got %d", resp.StatusCode)
+	}
+	if _, err := api.Database.GetDomain("mail.dontscan.com", models.StateUnconfirmed); err != nil {
+		t.Errorf("Expected approved domain to await validation: %v", err)
+	}
+	if _, err := api.Database.GetTokenByDomain("mail.dontscan.com"); err != nil {
+		t.Errorf("Expected approved domain to have a validation token: %v", err)
+	}
+}
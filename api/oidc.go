@@ -0,0 +1,464 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// Cookies set by the OIDC sign-in flow.
+const (
+	// adminSessionCookie holds a signed-in maintainer's session.
+	adminSessionCookie = "admin_session"
+	// oidcLoginCookie holds the state of a sign-in in progress, so the
+	// callback can check it came from the same browser.
+	oidcLoginCookie = "oidc_login"
+)
+
+const (
+	defaultAdminSessionLifetime = 8 * time.Hour
+	oidcLoginLifetime           = 10 * time.Minute
+)
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+// OIDC signs maintainers in to the admin endpoints with an OpenID Connect
+// identity provider, using the authorization code flow with PKCE. Signed-in
+// maintainers get a session cookie, which the admin endpoints accept like an
+// API key with the "admin-write" scope.
+type OIDC struct {
+	// Issuer is the provider's issuer URL. Its endpoints are discovered from
+	// Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is this server's /admin/login/callback URL, as registered
+	// with the provider.
+	RedirectURL string
+	// Admins are the email addresses allowed to sign in, or "@example.com"
+	// for every address at a domain.
+	Admins []string
+	// SessionSecret signs the session cookies.
+	SessionSecret []byte
+	// SessionLifetime is how long a sign-in lasts. Defaults to 8 hours.
+	SessionLifetime time.Duration
+	// Client makes requests to the provider. Defaults to a client with a 10
+	// second timeout.
+	Client *http.Client
+
+	mu        sync.Mutex
+	endpoints *oidcEndpoints
+}
+
+// oidcEndpoints is the part of the provider's discovery document we use.
+type oidcEndpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// oidcLogin is the state of a sign-in in progress.
+type oidcLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Expires  int64  `json:"expires"`
+}
+
+// adminSession is a signed-in maintainer.
+type adminSession struct {
+	Email   string `json:"email"`
+	Expires int64  `json:"expires"`
+}
+
+// audience is an ID token's aud claim, which is either a string or a list of
+// them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// idTokenClaims are the claims we check in an ID token.
+type idTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Audience      audience `json:"aud"`
+	Expires       int64    `json:"exp"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+}
+
+// adminSessionKey is the context key for the session a request was
+// authenticated with.
+type adminSessionKey struct{}
+
+// withAdminSession records that r was authenticated with session.
+func withAdminSession(r *http.Request, session adminSession) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), adminSessionKey{}, session))
+}
+
+// requestSession returns the session r was authenticated with, if any.
+func requestSession(r *http.Request) (adminSession, bool) {
+	session, ok := r.Context().Value(adminSessionKey{}).(adminSession)
+	return session, ok
+}
+
+// OIDCFromEnv returns OIDC sign-in for the provider at OIDC_ISSUER, with the
+// client credentials OIDC_CLIENT_ID and OIDC_CLIENT_SECRET, redirecting back
+// to OIDC_REDIRECT_URL. OIDC_ADMINS is a comma-separated list of the
+// addresses, or "@domains", allowed to sign in, and OIDC_SESSION_SECRET signs
+// their sessions, which last OIDC_SESSION_LIFETIME. It returns nil if
+// OIDC_ISSUER isn't set.
+func OIDCFromEnv() (*OIDC, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	o := &OIDC{
+		Issuer:        strings.TrimSuffix(issuer, "/"),
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		SessionSecret: []byte(os.Getenv("OIDC_SESSION_SECRET")),
+	}
+	for _, admin := range strings.Split(os.Getenv("OIDC_ADMINS"), ",") {
+		if admin = strings.ToLower(strings.TrimSpace(admin)); admin != "" {
+			o.Admins = append(o.Admins, admin)
+		}
+	}
+	for name, value := range map[string]string{"OIDC_CLIENT_ID": o.ClientID, "OIDC_CLIENT_SECRET": o.ClientSecret,
+		"OIDC_REDIRECT_URL": o.RedirectURL, "OIDC_SESSION_SECRET": string(o.SessionSecret)} {
+		if value == "" {
+			return nil, fmt.Errorf("%s must be set with OIDC_ISSUER", name)
+		}
+	}
+	if len(o.Admins) == 0 {
+		return nil, errors.New("OIDC_ADMINS must be set with OIDC_ISSUER")
+	}
+	if value := os.Getenv("OIDC_SESSION_LIFETIME"); value != "" {
+		lifetime, err := time.ParseDuration(value)
+		if err != nil || lifetime <= 0 {
+			return nil, errors.New("OIDC_SESSION_LIFETIME must be a positive duration, like 8h")
+		}
+		o.SessionLifetime = lifetime
+	}
+	return o, nil
+}
+
+func (o *OIDC) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return oidcClient
+}
+
+func (o *OIDC) sessionLifetime() time.Duration {
+	if o.SessionLifetime > 0 {
+		return o.SessionLifetime
+	}
+	return defaultAdminSessionLifetime
+}
+
+// allowed returns true if email may sign in.
+func (o *OIDC) allowed(email string) bool {
+	email = strings.ToLower(email)
+	for _, admin := range o.Admins {
+		if email == admin || (strings.HasPrefix(admin, "@") && strings.HasSuffix(email, admin)) {
+			return true
+		}
+	}
+	return false
+}
+
+// discover fetches the provider's endpoints the first time they're needed.
+func (o *OIDC) discover(ctx context.Context) (*oidcEndpoints, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.endpoints != nil {
+		return o.endpoints, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't discover OIDC provider: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("couldn't discover OIDC provider: %s", resp.Status)
+	}
+	endpoints := &oidcEndpoints{}
+	if err = json.NewDecoder(resp.Body).Decode(endpoints); err != nil {
+		return nil, fmt.Errorf("couldn't read OIDC provider's configuration: %v", err)
+	}
+	if strings.TrimSuffix(endpoints.Issuer, "/") != o.Issuer {
+		return nil, fmt.Errorf("OIDC provider's issuer is %s, not %s", endpoints.Issuer, o.Issuer)
+	}
+	o.endpoints = endpoints
+	return endpoints, nil
+}
+
+// seal encodes v as a cookie value, signed so that it can't be forged.
+func (o *OIDC) seal(v interface{}) string {
+	payload, _ := json.Marshal(v)
+	mac := hmac.New(sha256.New, o.SessionSecret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// open decodes a cookie value sealed by seal into v.
+func (o *OIDC) open(value string, v interface{}) error {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return errors.New("malformed cookie")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, o.SessionSecret)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errors.New("invalid cookie signature")
+	}
+	return json.Unmarshal(payload, v)
+}
+
+// cookie returns a cookie for the sign-in flow. Cookies are only sent over
+// HTTPS, unless this server is redirected back to over HTTP, eg. in
+// development.
+func (o *OIDC) cookie(name string, value string, path string, expires time.Time) *http.Cookie {
+	c := &http.Cookie{Name: name, Value: value, Path: path, Expires: expires, HttpOnly: true,
+		Secure: strings.HasPrefix(o.RedirectURL, "https://"), SameSite: http.SameSiteStrictMode}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+// session returns the maintainer signed in by r's session cookie, if any.
+func (o *OIDC) session(r *http.Request, now time.Time) (adminSession, bool) {
+	c, err := r.Cookie(adminSessionCookie)
+	if err != nil {
+		return adminSession{}, false
+	}
+	var s adminSession
+	if err = o.open(c.Value, &s); err != nil || now.Unix() >= s.Expires || !o.allowed(s.Email) {
+		return adminSession{}, false
+	}
+	return s, true
+}
+
+// exchange redeems an authorization code for the signed-in user's verified
+// claims.
+func (o *OIDC) exchange(ctx context.Context, code string, login oidcLogin, now time.Time) (idTokenClaims, error) {
+	var claims idTokenClaims
+	endpoints, err := o.discover(ctx)
+	if err != nil {
+		return claims, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.RedirectURL},
+		"code_verifier": {login.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return claims, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	resp, err := o.client().Do(req)
+	if err != nil {
+		return claims, fmt.Errorf("couldn't reach OIDC provider: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return claims, fmt.Errorf("OIDC provider refused the authorization code: %s", resp.Status)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return claims, fmt.Errorf("couldn't read OIDC provider's response: %v", err)
+	}
+	// The ID token came straight from the token endpoint over TLS, so the
+	// provider's certificate vouches for it in place of its signature, as
+	// OpenID Connect Core section 3.1.3.7 allows.
+	parts := strings.Split(tokens.IDToken, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("OIDC provider returned a malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, errors.New("OIDC provider returned a malformed ID token")
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, errors.New("OIDC provider returned a malformed ID token")
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != o.Issuer {
+		return claims, fmt.Errorf("ID token was issued by %s, not %s", claims.Issuer, o.Issuer)
+	}
+	if !containsString(claims.Audience, o.ClientID) {
+		return claims, errors.New("ID token wasn't issued to this server")
+	}
+	if now.Unix() >= claims.Expires {
+		return claims, errors.New("ID token has expired")
+	}
+	if !hmac.Equal([]byte(claims.Nonce), []byte(login.Nonce)) {
+		return claims, errors.New("ID token is for another sign-in")
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return claims, errors.New("your identity provider didn't vouch for your email address")
+	}
+	return claims, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// randomToken returns a random string for the sign-in flow's state, nonce
+// and PKCE verifier.
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// AdminLogin handles requests to /admin/login
+//   GET /admin/login
+//        Redirects to the OIDC provider to sign in, which redirects back to
+//        /admin/login/callback.
+func (api API) adminLogin(r *http.Request) response {
+	if api.OIDC == nil {
+		return response{StatusCode: http.StatusNotFound, Message: "OIDC sign-in isn't configured"}
+	}
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/login only accepts GET requests"}
+	}
+	endpoints, err := api.OIDC.discover(r.Context())
+	if err != nil {
+		log.Print(err)
+		return response{StatusCode: http.StatusServiceUnavailable, Message: "Unable to reach the identity provider"}
+	}
+	expires := time.Now().Add(oidcLoginLifetime)
+	login := oidcLogin{State: randomToken(), Nonce: randomToken(), Verifier: randomToken(), Expires: expires.Unix()}
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {api.OIDC.ClientID},
+		"redirect_uri":          {api.OIDC.RedirectURL},
+		"scope":                 {"openid email"},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	location := endpoints.AuthorizationEndpoint
+	if strings.Contains(location, "?") {
+		location += "&" + query.Encode()
+	} else {
+		location += "?" + query.Encode()
+	}
+	// The provider redirects back from another site, so the login cookie
+	// has to be sent with cross-site navigations.
+	c := api.OIDC.cookie(oidcLoginCookie, api.OIDC.seal(login), "/admin/login", expires)
+	c.SameSite = http.SameSiteLaxMode
+	return response{StatusCode: http.StatusFound, Message: "Redirecting to your identity provider",
+		header: http.Header{"Location": {location}, "Set-Cookie": {c.String()}}}
+}
+
+// AdminLoginCallback handles requests to /admin/login/callback
+//   GET /admin/login/callback
+//        code, state: Set by the OIDC provider after signing in.
+//        Sets a session cookie for the admin endpoints if the signed-in
+//        address is one of api.OIDC.Admins, and the address and the
+//        session's expiry as response.
+func (api API) adminLoginCallback(r *http.Request) response {
+	if api.OIDC == nil {
+		return response{StatusCode: http.StatusNotFound, Message: "OIDC sign-in isn't configured"}
+	}
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/login/callback only accepts GET requests"}
+	}
+	if problem := r.FormValue("error"); problem != "" {
+		return response{StatusCode: http.StatusUnauthorized,
+			Message: fmt.Sprintf("Sign-in failed: %s %s", problem, r.FormValue("error_description"))}
+	}
+	now := time.Now()
+	var login oidcLogin
+	c, err := r.Cookie(oidcLoginCookie)
+	if err == nil {
+		err = api.OIDC.open(c.Value, &login)
+	}
+	if err != nil || now.Unix() >= login.Expires ||
+		!hmac.Equal([]byte(r.FormValue("state")), []byte(login.State)) {
+		return badRequest("this sign-in has expired or was started elsewhere; please sign in again")
+	}
+	claims, err := api.OIDC.exchange(r.Context(), r.FormValue("code"), login, now)
+	if err != nil {
+		return response{StatusCode: http.StatusUnauthorized, Message: err.Error()}
+	}
+	if !api.OIDC.allowed(claims.Email) {
+		return response{StatusCode: http.StatusForbidden,
+			Message: fmt.Sprintf("%s isn't allowed to sign in", claims.Email)}
+	}
+	expires := now.Add(api.OIDC.sessionLifetime())
+	session := adminSession{Email: strings.ToLower(claims.Email), Expires: expires.Unix()}
+	api.audit(models.AuditEntry{Actor: session.Email, Action: "admin.login", Subject: session.Email})
+	header := http.Header{}
+	header.Add("Set-Cookie", api.OIDC.cookie(adminSessionCookie, api.OIDC.seal(session), "/", expires).String())
+	header.Add("Set-Cookie", api.OIDC.cookie(oidcLoginCookie, "", "/admin/login", time.Time{}).String())
+	return response{StatusCode: http.StatusOK, header: header,
+		Response: map[string]interface{}{"email": session.Email, "expires": expires.UTC()}}
+}
+
+// AdminLogout handles requests to /admin/logout
+//   POST /admin/logout
+//        Clears the session cookie set by /admin/login/callback.
+func (api API) adminLogout(r *http.Request) response {
+	if api.OIDC == nil {
+		return response{StatusCode: http.StatusNotFound, Message: "OIDC sign-in isn't configured"}
+	}
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/admin/logout only accepts POST requests"}
+	}
+	return response{StatusCode: http.StatusOK, Response: "Signed out.",
+		header: http.Header{"Set-Cookie": {api.OIDC.cookie(adminSessionCookie, "", "/", time.Time{}).String()}}}
+}
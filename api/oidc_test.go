@@ -0,0 +1,182 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeOIDCProvider issues ID tokens for email to whoever redeems the code
+// "code" with the right PKCE verifier.
+type fakeOIDCProvider struct {
+	*httptest.Server
+	email     string
+	nonce     string
+	challenge string
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	p := &fakeOIDCProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcEndpoints{Issuer: p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize", TokenEndpoint: p.URL + "/token"})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if id != "client" || secret != "client-secret" || r.FormValue("code") != "code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		claims, _ := json.Marshal(map[string]interface{}{"iss": p.URL, "aud": []string{"client"},
+			"exp": time.Now().Add(time.Hour).Unix(), "nonce": p.nonce, "email": p.email, "email_verified": true})
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".signature"})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// signIn signs in with the OIDC provider, as client, and returns the
+// callback's response.
+func (p *fakeOIDCProvider) signIn(t *testing.T, client *http.Client) *http.Response {
+	resp, err := client.Get(server.URL + "/admin/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil || !strings.HasPrefix(location.String(), p.URL+"/authorize?") {
+		t.Fatalf("Expected a redirect to the provider, got %d to %s", resp.StatusCode, location)
+	}
+	query := location.Query()
+	if query.Get("client_id") != "client" || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("Expected a PKCE authorization request, got %s", location)
+	}
+	p.nonce, p.challenge = query.Get("nonce"), query.Get("code_challenge")
+	resp, err = client.Get(server.URL + "/admin/login/callback?code=code&state=" + url.QueryEscape(query.Get("state")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func newBrowser(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	defer teardown()
+	provider := newFakeOIDCProvider(t)
+	defer provider.Close()
+	api.OIDC = &OIDC{Issuer: provider.URL, ClientID: "client", ClientSecret: "client-secret",
+		RedirectURL: server.URL + "/admin/login/callback", Admins: []string{"@eff.org"},
+		SessionSecret: []byte("session-secret")}
+	rebind()
+	defer func() { api.OIDC = nil; rebind() }()
+
+	browser := newBrowser(t)
+	blockData := url.Values{"kind": {"domain"}, "pattern": {"junk.example"}}
+	if resp, _ := browser.PostForm(server.URL+"/admin/blocks", blockData); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected admin requests to need a sign-in, got %d", resp.StatusCode)
+	}
+	provider.email = "alice@eff.org"
+	if resp := provider.signIn(t, browser); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected alice@eff.org to sign in, got %d", resp.StatusCode)
+	}
+	if resp, _ := browser.PostForm(server.URL+"/admin/blocks", blockData); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a signed-in maintainer to block a domain, got %d", resp.StatusCode)
+	}
+	entries, _ := api.Database.GetAuditLog("junk.example")
+	if len(entries) != 1 || entries[0].Actor != "alice@eff.org" {
+		t.Errorf("Expected the block to be audited as alice@eff.org, got %+v", entries)
+	}
+	if resp, _ := browser.PostForm(server.URL+"/admin/logout", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected to sign out, got %d", resp.StatusCode)
+	}
+	if resp, _ := browser.Get(server.URL + "/admin/blocks"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected admin requests to need a sign-in after signing out, got %d", resp.StatusCode)
+	}
+
+	provider.email = "mallory@example.com"
+	if resp := provider.signIn(t, newBrowser(t)); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an address that isn't an admin to be refused, got %d", resp.StatusCode)
+	}
+	resp, err := browser.Get(server.URL + "/admin/login/callback?code=code&state=forged")
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a callback without a sign-in to be refused, got %v", resp.StatusCode)
+	}
+}
+
+func TestOIDCSession(t *testing.T) {
+	o := &OIDC{Admins: []string{"alice@eff.org"}, SessionSecret: []byte("secret")}
+	now := time.Now()
+	request := func(value string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/admin/slo", nil)
+		r.AddCookie(&http.Cookie{Name: adminSessionCookie, Value: value})
+		return r
+	}
+	valid := o.seal(adminSession{Email: "alice@eff.org", Expires: now.Add(time.Hour).Unix()})
+	if s, ok := o.session(request(valid), now); !ok || s.Email != "alice@eff.org" {
+		t.Errorf("Expected a valid session, got %+v", s)
+	}
+	forger := &OIDC{SessionSecret: []byte("guess")}
+	for name, value := range map[string]string{
+		"expired":  o.seal(adminSession{Email: "alice@eff.org", Expires: now.Unix()}),
+		"forged":   forger.seal(adminSession{Email: "alice@eff.org", Expires: now.Add(time.Hour).Unix()}),
+		"revoked":  o.seal(adminSession{Email: "bob@eff.org", Expires: now.Add(time.Hour).Unix()}),
+		"garbage":  "garbage",
+		"tampered": strings.Replace(valid, ".", "x.", 1),
+	} {
+		if _, ok := o.session(request(value), now); ok {
+			t.Errorf("Expected a %s session to be refused", name)
+		}
+	}
+}
+
+func TestOIDCFromEnv(t *testing.T) {
+	if o, err := OIDCFromEnv(); o != nil || err != nil {
+		t.Errorf("Expected OIDC to be disabled by default, got %v, %v", o, err)
+	}
+	env := map[string]string{"OIDC_ISSUER": "https://id.eff.org/", "OIDC_CLIENT_ID": "client",
+		"OIDC_CLIENT_SECRET": "secret", "OIDC_REDIRECT_URL": "https://starttls-everywhere.org/admin/login/callback",
+		"OIDC_SESSION_SECRET": "secret"}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+	if _, err := OIDCFromEnv(); err == nil {
+		t.Error("Expected OIDC without any admins to be refused")
+	}
+	os.Setenv("OIDC_ADMINS", "Alice@eff.org, @staff.eff.org")
+	defer os.Unsetenv("OIDC_ADMINS")
+	o, err := OIDCFromEnv()
+	if err != nil || o.Issuer != "https://id.eff.org" || o.sessionLifetime() != defaultAdminSessionLifetime {
+		t.Fatalf("Expected OIDC to be configured, got %+v, %v", o, err)
+	}
+	if !o.allowed("alice@EFF.org") || !o.allowed("bob@staff.eff.org") || o.allowed("bob@eff.org") {
+		t.Errorf("Expected only the listed admins to be allowed, got %v", o.Admins)
+	}
+	os.Setenv("OIDC_SESSION_LIFETIME", "forever")
+	defer os.Unsetenv("OIDC_SESSION_LIFETIME")
+	if _, err := OIDCFromEnv(); err == nil {
+		t.Error("Expected an invalid session lifetime to be refused")
+	}
+}
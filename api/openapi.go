@@ -0,0 +1,516 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/graphql"
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/policy"
+	"github.com/EFForg/starttls-backend/stats"
+)
+
+// apiVersion is the version of the API described by the OpenAPI document.
+const apiVersion = "2.0.0"
+
+// param documents a request parameter. They're read from the query string
+// of GET requests, and the form body of POST requests.
+type param struct {
+	Name        string
+	Description string
+	Required    bool
+	// Multiple is set for parameters that can be given more than once.
+	Multiple bool
+	// InPath is set for parameters that are part of the path, like {id}.
+	InPath bool
+}
+
+// route documents an endpoint in the OpenAPI document.
+type route struct {
+	Path    string
+	Method  string
+	Summary string
+	Params  []param
+	// Data is a value of the type of data set as the response.
+	Data interface{}
+	// V2 routes wrap their data in a v2Envelope, rather than a response.
+	V2 bool
+	// Raw routes respond with their data itself, rather than wrapping it.
+	Raw bool
+	// ContentType is the media type of the response, if it isn't JSON.
+	ContentType string
+	// Body is a value of the type of JSON request body the route reads,
+	// for routes that don't read form parameters.
+	Body       interface{}
+	Deprecated bool
+}
+
+var (
+	domainParam  = param{Name: "domain", Description: "Mail domain.", Required: true}
+	verboseParam = param{Name: "verbose", Description: `"true" to include SMTP session transcripts.`}
+	queueParams  = []param{
+		domainParam,
+		{Name: "email", Description: "Contact email for the domain."},
+		{Name: "hostnames", Description: "MX hostname patterns for the domain's policy, up to 8.", Multiple: true},
+		{Name: "mta_sts", Description: `"on" to use the domain's MTA-STS policy instead of hostnames.`},
+		{Name: "weeks", Description: "Weeks to keep the domain queued for."},
+		{Name: "captcha", Description: "Token from a solved CAPTCHA, if they're required."},
+	}
+	tokenParam = param{Name: "token", Description: "Validation token emailed to the domain.", Required: true}
+	emailParam = param{Name: "email", Description: "Email address.", Required: true}
+	keyParams  = []param{
+		{Name: "name", Description: "Label for the new key."},
+		{Name: "scopes", Description: `Scopes for the new key, any of "scan", "queue" and "keys". Defaults to all of them.`, Multiple: true},
+	}
+	keyIDParam  = param{Name: "id", Description: "ID of one of the owner's keys.", Required: true}
+	hookIDParam = param{Name: "id", Description: "ID of the webhook.", Required: true}
+)
+
+// emailedToken documents the token param of endpoints that redeem a token
+// emailed by endpoint.
+func emailedToken(endpoint string) param {
+	return param{Name: "token", Required: true,
+		Description: "Verification token emailed by " + endpoint + ". Each token can be used once."}
+}
+
+// routes are the public endpoints documented in the OpenAPI document. Keep
+// them in sync with RegisterHandlers and the handlers' doc comments.
+var routes = []route{
+	{Path: "/api/v2/scan", Method: http.MethodPost, Summary: "Scan a domain.",
+		Params: []param{domainParam, verboseParam}, Data: ScanV2{}, V2: true},
+	{Path: "/api/v2/scan", Method: http.MethodGet, Summary: "Read a domain's latest scan.",
+		Params: []param{domainParam, verboseParam}, Data: ScanV2{}, V2: true},
+	{Path: "/api/v2/queue", Method: http.MethodPost, Summary: "Submit a domain to the policy list.",
+		Params: queueParams, Data: SubmissionV2{}, V2: true},
+	{Path: "/api/v2/queue", Method: http.MethodGet, Summary: "Read a submitted domain's state.",
+		Params: []param{domainParam}, Data: DomainV2{}, V2: true},
+	{Path: "/api/v2/validate", Method: http.MethodPost, Summary: "Validate a domain's submission.",
+		Params: []param{tokenParam}, Data: ValidationV2{}, V2: true},
+
+	{Path: "/api/scan", Method: http.MethodPost, Summary: "Scan a domain.",
+		Params: []param{domainParam, verboseParam}, Data: models.Scan{}, Deprecated: true},
+	{Path: "/api/scan", Method: http.MethodGet, Summary: "Read a domain's latest scan.",
+		Params: []param{domainParam, verboseParam}, Data: models.Scan{}, Deprecated: true},
+	{Path: "/api/scan/diff", Method: http.MethodGet, Summary: "Compare a domain's two latest scans.",
+		Params: []param{domainParam}, Data: checker.ScanDiff{}},
+	{Path: "/api/scan/hostnames", Method: http.MethodGet, Summary: "Read every field of a scan's hostname results.",
+		Params: []param{domainParam, verboseParam,
+			{Name: "timestamp", Description: "Read the latest scan at or before this RFC 3339 time."}},
+		Data: scanHostnames{}},
+	{Path: "/api/queue", Method: http.MethodPost, Summary: "Submit a domain to the policy list.",
+		Params: queueParams, Data: "", Deprecated: true},
+	{Path: "/api/queue", Method: http.MethodGet, Summary: "Read a submitted domain's state.",
+		Params: []param{domainParam}, Data: models.Domain{}, Deprecated: true},
+	{Path: "/api/validate", Method: http.MethodPost, Summary: "Validate a domain's submission.",
+		Params: []param{tokenParam}, Data: "", Deprecated: true},
+	{Path: "/api/queue/watch", Method: http.MethodGet, Summary: "Wait for a submitted domain's state to change.",
+		Params: []param{domainParam,
+			{Name: "state", Description: "The state the client last saw. Responds once the domain is in a different one."},
+			{Name: "timeout", Description: "Seconds to wait for a change, up to 60. Defaults to 30."}},
+		Data: domainStatus{}},
+	{Path: "/api/queue/resend", Method: http.MethodPost, Summary: "Resend a submission's validation email.",
+		Params: []param{domainParam}, Data: ""},
+	{Path: "/api/domains/search", Method: http.MethodGet, Summary: "Search queued and listed domains by name.",
+		Params: []param{{Name: "q", Description: "Query to match domain names against.", Required: true},
+			{Name: "match", Description: `"prefix" (the default) or "substring".`},
+			{Name: "state", Description: "Only search domains in these states.", Multiple: true},
+			{Name: "limit", Description: "The most domains to return, up to 100. Defaults to 10."}},
+		Data: []models.Domain{}},
+	{Path: "/api/promotion", Method: http.MethodPost, Summary: "Confirm or hold back a domain's promotion, from an emailed link.",
+		Params: []param{domainParam,
+			{Name: "id", Description: "From the emailed link.", Required: true},
+			{Name: "action", Description: `"confirm" or "hold".`, Required: true},
+			{Name: "expires", Description: "From the emailed link.", Required: true},
+			{Name: "signature", Description: "From the emailed link.", Required: true},
+			{Name: "problem", Description: "What's wrong, if the owner is holding the domain back."}},
+		Data: models.Promotion{}},
+	{Path: "/api/unsubscribe", Method: http.MethodPost, Summary: "Opt out of notifications, from an emailed link.",
+		Params: []param{emailParam, {Name: "signature", Description: "From the emailed link.", Required: true}}},
+	{Path: "/api/list", Method: http.MethodGet, Summary: "Read the policy list.", Data: policy.List{}},
+	{Path: "/api/stats", Method: http.MethodGet, Summary: "Read MTA-STS adoption statistics.",
+		Data: map[string]stats.Series{}},
+	{Path: "/api/firehose", Method: http.MethodGet, Summary: "Stream sanitized scan events, as newline-delimited JSON.",
+		Data: firehoseEvent{}, Raw: true, ContentType: "application/x-ndjson"},
+	{Path: "/api/graphql", Method: http.MethodGet, Summary: "Execute a GraphQL query.",
+		Params: []param{{Name: "query", Description: "GraphQL query.", Required: true},
+			{Name: "variables", Description: "JSON object of the query's variables."},
+			{Name: "operationName", Description: "Operation in the query to execute."}},
+		Data: graphql.Response{}, Raw: true},
+	{Path: "/api/graphql", Method: http.MethodPost, Summary: "Execute a GraphQL query.",
+		Body: graphql.Request{}, Data: graphql.Response{}, Raw: true},
+	{Path: "/api/dane/generate", Method: http.MethodGet, Summary: "Generate TLSA records for a scanned mailserver.",
+		Params: []param{{Name: "hostname", Description: "MX hostname.", Required: true}}, Data: daneRecords{}},
+
+	{Path: "/api/keys/register", Method: http.MethodPost, Summary: "Email a token to create a first API key.",
+		Params: []param{emailParam}, Data: ""},
+	{Path: "/api/keys/verify", Method: http.MethodPost, Summary: "Create a first API key with an emailed token.",
+		Params: append([]param{emailedToken("/api/keys/register")}, keyParams...), Data: models.APIKey{}},
+	{Path: "/api/keys", Method: http.MethodGet, Summary: "List the owner's API keys. Requires a key with the keys scope.",
+		Data: []models.APIKey{}},
+	{Path: "/api/keys", Method: http.MethodPost, Summary: "Create an API key. Requires a key with the keys scope.",
+		Params: keyParams, Data: models.APIKey{}},
+	{Path: "/api/keys/rotate", Method: http.MethodPost, Summary: "Rotate an API key's secret. Requires a key with the keys scope.",
+		Params: []param{keyIDParam}, Data: models.APIKey{}},
+	{Path: "/api/keys/revoke", Method: http.MethodPost, Summary: "Revoke an API key. Requires a key with the keys scope.",
+		Params: []param{keyIDParam}, Data: models.APIKey{}},
+	{Path: "/api/keys/{id}/usage", Method: http.MethodGet, Summary: "Read an API key's usage. Requires a key with the keys scope.",
+		Params: []param{{Name: "id", Description: "ID of one of the owner's keys.", Required: true, InPath: true}},
+		Data:   keyUsage{}},
+	{Path: "/api/provider/challenge", Method: http.MethodGet,
+		Summary: "Read the TXT records proving control of MX hostnames. Requires a key with the queue scope.",
+		Params:  []param{{Name: "hostname", Description: "MX hostname.", Required: true, Multiple: true}},
+		Data:    []mxChallenge{}},
+	{Path: "/api/provider/enroll", Method: http.MethodPost,
+		Summary: "Queue domains whose MX hostnames publish the challenge. Requires a key with the queue scope.",
+		Params: []param{{Name: "domain", Description: "Mail domain, up to 100.", Required: true, Multiple: true},
+			{Name: "weeks", Description: "Weeks to keep the domains queued for."}},
+		Data: []enrollment{}},
+	{Path: "/api/webhooks", Method: http.MethodGet,
+		Summary: "List a domain's webhooks. Requires a key with the queue scope issued to the domain's contact.",
+		Params:  []param{domainParam}, Data: []models.Webhook{}},
+	{Path: "/api/webhooks", Method: http.MethodPost,
+		Summary: "Register a webhook for a domain's state changes. Requires a key with the queue scope issued to the domain's contact.",
+		Params:  []param{domainParam, {Name: "url", Description: "HTTPS URL to POST events to.", Required: true}},
+		Data:    models.Webhook{}},
+	{Path: "/api/webhooks/remove", Method: http.MethodPost,
+		Summary: "Remove a webhook. Requires a key with the queue scope issued to the domain's contact.",
+		Params:  []param{hookIDParam}, Data: models.Webhook{}},
+	{Path: "/api/webhooks/deliveries", Method: http.MethodGet,
+		Summary: "List a webhook's recent deliveries. Requires a key with the queue scope issued to the domain's contact.",
+		Params:  []param{hookIDParam, {Name: "limit", Description: "How many deliveries to list, up to 200. Defaults to 50."}},
+		Data:    webhookDeliveryLog{}},
+
+	{Path: "/api/export/request", Method: http.MethodPost, Summary: "Email a token to export the data stored about an address.",
+		Params: []param{emailParam}, Data: ""},
+	{Path: "/api/export", Method: http.MethodPost, Summary: "Export the data stored about an address.",
+		Params: []param{emailedToken("/api/export/request")}, Data: models.DataExport{}},
+	{Path: "/api/erase/request", Method: http.MethodPost, Summary: "Email a token to erase the data stored about an address.",
+		Params: []param{emailParam}, Data: ""},
+	{Path: "/api/erase", Method: http.MethodPost, Summary: "Erase the data stored about an address.",
+		Params: []param{emailedToken("/api/erase/request")}, Data: models.Erasure{}},
+	{Path: "/api/remove/request", Method: http.MethodPost, Summary: "Email a token to remove a domain from the list.",
+		Params: []param{domainParam}, Data: ""},
+	{Path: "/api/remove", Method: http.MethodPost, Summary: "Remove a domain from the list, after a grace period.",
+		Params: []param{emailedToken("/api/remove/request")}, Data: pendingRemoval{}},
+}
+
+// undocumentedRoutes are the patterns under /api/ registered in
+// RegisterHandlers that deliberately aren't in routes.
+var undocumentedRoutes = map[string]bool{
+	// The health check, this document itself, and the v2 catch-all.
+	"/api/ping":         true,
+	"/api/openapi.json": true,
+	"/api/v2/":          true,
+	// Called by the mail provider, with its own key.
+	"/api/suppressions": true,
+}
+
+// jsonShapes maps types with their own MarshalJSON to types with the same
+// JSON encoding, whose fields describe it.
+var jsonShapes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(checker.Result{}):         reflect.TypeOf(resultShape{}),
+	reflect.TypeOf(checker.HostnameResult{}): reflect.TypeOf(resultShape{}),
+	reflect.TypeOf(checker.MTASTSResult{}):   reflect.TypeOf(mtastsResultShape{}),
+	reflect.TypeOf(stats.Series{}):           reflect.TypeOf([]seriesPoint{}),
+	reflect.TypeOf(graphql.OrderedMap{}):     reflect.TypeOf(map[string]interface{}{}),
+}
+
+type rawResult checker.Result
+
+type resultShape struct {
+	rawResult
+	StatusText  string `json:"status_text,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type mtastsResultShape struct {
+	rawResult
+	Policy string   `json:"policy"`
+	Mode   string   `json:"mode"`
+	MXs    []string `json:"mxs"`
+	ID     string   `json:"id,omitempty"`
+}
+
+type seriesPoint struct {
+	X time.Time `json:"x"`
+	Y float64   `json:"y"`
+}
+
+// schema is an OpenAPI 3 schema object.
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// schemaGenerator derives schemas from Go types, following the rules
+// encoding/json encodes them with. Structs become components, so they can
+// refer to themselves.
+type schemaGenerator struct {
+	components map[string]*schema
+	names      map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{components: map[string]*schema{}, names: map[reflect.Type]string{}}
+}
+
+// schemaOf returns the schema of t's JSON encoding.
+func (g *schemaGenerator) schemaOf(t reflect.Type) *schema {
+	if t == nil {
+		return &schema{}
+	}
+	if t.Kind() == reflect.Ptr {
+		return g.schemaOf(t.Elem())
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return &schema{Type: "string", Format: "date-time"}
+	}
+	shape, ok := jsonShapes[t]
+	if !ok {
+		shape = t
+	}
+	switch shape.Kind() {
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if shape.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: g.schemaOf(shape.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: g.schemaOf(shape.Elem())}
+	case reflect.Struct:
+		return g.component(t, shape)
+	}
+	return &schema{}
+}
+
+// component returns a reference to t's component, describing it with the
+// fields of shape the first time.
+func (g *schemaGenerator) component(t reflect.Type, shape reflect.Type) *schema {
+	name, ok := g.names[t]
+	if !ok {
+		name = g.componentName(t)
+		g.names[t] = name
+		s := &schema{Type: "object", Properties: map[string]*schema{}}
+		g.components[name] = s
+		g.addFields(s, shape)
+		sort.Strings(s.Required)
+	}
+	return &schema{Ref: "#/components/schemas/" + name}
+}
+
+// componentName names t's component after it, or after its package too if
+// another type already has its name.
+func (g *schemaGenerator) componentName(t reflect.Type) string {
+	name := exportedName(t.Name())
+	if _, taken := g.components[name]; taken || name == "" {
+		pkg := t.PkgPath()
+		name = exportedName(pkg[strings.LastIndex(pkg, "/")+1:]) + name
+	}
+	return name
+}
+
+// addFields adds the properties of struct t to s, including the fields of
+// embedded structs.
+func (g *schemaGenerator) addFields(s *schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, options = tag[:comma], tag[comma+1:]
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			g.addFields(s, fieldType)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := g.schemaOf(field.Type)
+		if strings.Contains(options, "string") {
+			property = &schema{Type: "string"}
+		}
+		s.Properties[name] = property
+		if !strings.Contains(options, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+func exportedName(name string) string {
+	if name == "" {
+		return name
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// openAPIParameter is an OpenAPI 3 query parameter.
+type openAPIParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type openAPIBody struct {
+	Description string                      `json:"description,omitempty"`
+	Required    bool                        `json:"required,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIOperation struct {
+	Summary     string                 `json:"summary"`
+	Parameters  []openAPIParameter     `json:"parameters,omitempty"`
+	RequestBody *openAPIBody           `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIBody `json:"responses"`
+	Deprecated  bool                   `json:"deprecated,omitempty"`
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       map[string]string                      `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components map[string]map[string]*schema          `json:"components"`
+}
+
+// paramSchema returns the schema of p's value.
+func (p param) schema() *schema {
+	if p.Multiple {
+		return &schema{Type: "array", Items: &schema{Type: "string"}}
+	}
+	return &schema{Type: "string"}
+}
+
+// envelope returns the schema of the response a route's data is wrapped in.
+func (g *schemaGenerator) envelope(rt route, data *schema) *schema {
+	if rt.Raw {
+		return data
+	}
+	if rt.V2 {
+		return &schema{Type: "object", Required: []string{"data"}, Properties: map[string]*schema{
+			"data":     data,
+			"error":    g.schemaOf(reflect.TypeOf(v2Error{})),
+			"warnings": {Type: "array", Items: &schema{Type: "string"}},
+		}}
+	}
+	return &schema{Type: "object", Required: []string{"message", "response", "status_code"}, Properties: map[string]*schema{
+		"status_code": {Type: "integer"},
+		"message":     {Type: "string"},
+		"response":    data,
+		"warnings":    {Type: "array", Items: &schema{Type: "string"}},
+	}}
+}
+
+// operation documents rt.
+func (g *schemaGenerator) operation(rt route) openAPIOperation {
+	contentType := rt.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	failure := g.envelope(rt, &schema{Nullable: true})
+	if rt.Raw {
+		failure = g.schemaOf(reflect.TypeOf(rt.Data))
+	}
+	op := openAPIOperation{Summary: rt.Summary, Deprecated: rt.Deprecated, Responses: map[string]openAPIBody{
+		"200": {Description: "Success.", Content: map[string]openAPIMediaType{
+			contentType: {Schema: g.envelope(rt, g.schemaOf(reflect.TypeOf(rt.Data)))}}},
+		"default": {Description: "Failure.", Content: map[string]openAPIMediaType{
+			"application/json": {Schema: failure}}},
+	}}
+	if rt.Body != nil {
+		op.RequestBody = &openAPIBody{Required: true, Content: map[string]openAPIMediaType{
+			"application/json": {Schema: g.schemaOf(reflect.TypeOf(rt.Body))}}}
+	}
+	form := &schema{Type: "object", Properties: map[string]*schema{}}
+	for _, p := range rt.Params {
+		if p.InPath || rt.Method == http.MethodGet {
+			in := "query"
+			if p.InPath {
+				in = "path"
+			}
+			op.Parameters = append(op.Parameters, openAPIParameter{Name: p.Name, In: in,
+				Description: p.Description, Required: p.Required, Schema: p.schema()})
+			continue
+		}
+		property := p.schema()
+		property.Description = p.Description
+		form.Properties[p.Name] = property
+		if p.Required {
+			form.Required = append(form.Required, p.Name)
+		}
+	}
+	if len(form.Properties) > 0 {
+		op.RequestBody = &openAPIBody{Required: true, Content: map[string]openAPIMediaType{
+			"application/x-www-form-urlencoded": {Schema: form}}}
+	}
+	return op
+}
+
+// newOpenAPIDocument returns the OpenAPI 3 document describing routes.
+func newOpenAPIDocument(routes []route) openAPIDocument {
+	g := newSchemaGenerator()
+	doc := openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    map[string]string{"title": "STARTTLS Everywhere", "version": apiVersion},
+		Paths:   map[string]map[string]openAPIOperation{},
+	}
+	for _, rt := range routes {
+		if doc.Paths[rt.Path] == nil {
+			doc.Paths[rt.Path] = map[string]openAPIOperation{}
+		}
+		doc.Paths[rt.Path][strings.ToLower(rt.Method)] = g.operation(rt)
+	}
+	doc.Components = map[string]map[string]*schema{"schemas": g.components}
+	return doc
+}
+
+// OpenAPI handles requests to /api/openapi.json
+//   GET /api/openapi.json
+//        Responds with an OpenAPI 3 document describing the public API.
+func openAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newOpenAPIDocument(routes))
+}
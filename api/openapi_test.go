@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// conforms checks that value, decoded from JSON, has the shape s describes.
+func conforms(t *testing.T, doc openAPIDocument, path string, s *schema, value interface{}) {
+	if s.Ref != "" {
+		s = doc.Components["schemas"][strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	if value == nil {
+		return
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if s.Type != "object" {
+			t.Errorf("%s is an object, but is documented as %q", path, s.Type)
+			return
+		}
+		for _, required := range s.Required {
+			if _, ok := v[required]; !ok {
+				t.Errorf("%s is missing required property %s", path, required)
+			}
+		}
+		for key, property := range v {
+			if s.AdditionalProperties != nil {
+				conforms(t, doc, path+"."+key, s.AdditionalProperties, property)
+			} else if propertySchema, ok := s.Properties[key]; ok {
+				conforms(t, doc, path+"."+key, propertySchema, property)
+			} else {
+				t.Errorf("%s.%s isn't documented", path, key)
+			}
+		}
+	case []interface{}:
+		if s.Type != "array" {
+			t.Errorf("%s is an array, but is documented as %q", path, s.Type)
+			return
+		}
+		for _, item := range v {
+			conforms(t, doc, path+"[]", s.Items, item)
+		}
+	case string:
+		if s.Type != "string" {
+			t.Errorf("%s is a string, but is documented as %q", path, s.Type)
+		}
+	case float64:
+		if s.Type != "integer" && s.Type != "number" {
+			t.Errorf("%s is a number, but is documented as %q", path, s.Type)
+		}
+	case bool:
+		if s.Type != "boolean" {
+			t.Errorf("%s is a boolean, but is documented as %q", path, s.Type)
+		}
+	}
+}
+
+// patternRecorder is a ServeMux that records the patterns registered with
+// it.
+type patternRecorder struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (m *patternRecorder) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+func (m *patternRecorder) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// registeredPattern returns the pattern mux routes requests for rt to.
+func registeredPattern(mux *http.ServeMux, rt route) string {
+	path := strings.NewReplacer("{", "", "}", "").Replace(rt.Path)
+	_, pattern := mux.Handler(httptest.NewRequest(rt.Method, path, nil))
+	return pattern
+}
+
+func TestOpenAPIRoutesRegistered(t *testing.T) {
+	mux := &patternRecorder{ServeMux: http.NewServeMux()}
+	api.RegisterHandlers(mux)
+	documented := make(map[string]bool)
+	for _, rt := range routes {
+		pattern := registeredPattern(mux.ServeMux, rt)
+		if pattern != rt.Path && !(strings.HasSuffix(pattern, "/") && strings.HasPrefix(rt.Path, pattern)) {
+			t.Errorf("Documented route %s %s isn't registered, got %q", rt.Method, rt.Path, pattern)
+		}
+		documented[pattern] = true
+	}
+	for _, pattern := range mux.patterns {
+		if strings.HasPrefix(pattern, "/api/") && !documented[pattern] && !undocumentedRoutes[pattern] {
+			t.Errorf("Registered route %s isn't documented", pattern)
+		}
+	}
+}
+
+func TestOpenAPIDescribesResponses(t *testing.T) {
+	defer teardown()
+	resp, err := http.Get(server.URL + "/api/openapi.json")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/openapi.json failed: %v", err)
+	}
+	var doc openAPIDocument
+	if err = json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" || doc.Paths["/api/v2/scan"]["post"].RequestBody == nil ||
+		!doc.Paths["/api/scan"]["get"].Deprecated {
+		t.Errorf("Expected the API to be documented, got %+v", doc)
+	}
+
+	http.PostForm(server.URL+"/api/queue", validQueueData(true))
+	for _, path := range []string{"/api/v2/scan", "/api/scan", "/api/v2/queue", "/api/queue", "/api/scan/hostnames"} {
+		resp, err := http.Get(server.URL + path + "?domain=example.com")
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		var body interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		op := doc.Paths[path]["get"]
+		conforms(t, doc, path, op.Responses["200"].Content["application/json"].Schema, body)
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// requestConfirmation emails the owner of the domain of p, which passed
+// verification, links to confirm or hold back its promotion.
+func (api API) requestConfirmation(p models.Promotion) response {
+	domain, err := api.Database.GetDomain(p.Domain, models.StateTesting)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	expires := time.Now().Add(models.PromotionLinkTTL)
+	confirm := models.PromotionLink(api.PromotionSecret, p, models.PromotionConfirm, expires)
+	hold := models.PromotionLink(api.PromotionSecret, p, models.PromotionHold, expires)
+	if err = api.Emailer.SendPromotionConfirmation(&domain, confirm, hold, expires); err != nil {
+		return serverError(fmt.Sprintf("domain passed verification, but the confirmation email failed to send: %v", err))
+	}
+	return response{StatusCode: http.StatusAccepted,
+		Message: "domain passed verification, and its owner has been asked to confirm the promotion", Response: p}
+}
+
+// releaseHeld returns a domain its owner held back from the list to testing.
+func (api API) releaseHeld(r *http.Request, name string) response {
+	actor, err := getActor(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if _, err := api.Database.GetDomain(name, models.StateHeld); err != nil {
+		return response{StatusCode: http.StatusNotFound,
+			Message: fmt.Sprintf("%s isn't held back from the list", name)}
+	}
+	if err := api.Database.SetStatus(name, models.StateTesting,
+		models.StateChange{Actor: actor, Reason: "released from hold"}); err != nil {
+		return serverError(err.Error())
+	}
+	api.audit(models.AuditEntry{Actor: actor, Action: "promotion.release", Subject: name})
+	return response{StatusCode: http.StatusOK, Message: fmt.Sprintf("%s has been returned to testing", name)}
+}
+
+// Promotion handles requests to /api/promotion, from the links in emails
+// asking owners to confirm their domains' promotions.
+//   POST /api/promotion
+//        domain, id, action, expires, signature: From the emailed link.
+//          action is "confirm" to verify the domain again, and promote it
+//          to enforce if it still passes, or "hold" to hold it back from
+//          the list until an admin releases it.
+//        problem (optional): What's wrong, if the owner is holding the
+//          domain back.
+func (api API) promotion(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/promotion only accepts POST requests"}
+	}
+	if len(api.PromotionSecret) == 0 {
+		return response{StatusCode: http.StatusServiceUnavailable,
+			Message: "promotions aren't confirmed by owners"}
+	}
+	name, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return badRequest("id must be an integer")
+	}
+	expires, err := strconv.ParseInt(r.FormValue("expires"), 10, 64)
+	if err != nil {
+		return badRequest("expires must be an integer")
+	}
+	action := r.FormValue("action")
+	if action != models.PromotionConfirm && action != models.PromotionHold {
+		return badRequest("action must be confirm or hold")
+	}
+	// Signatures are case-sensitive, so they're not read with getParam.
+	err = models.CheckPromotionLink(api.PromotionSecret, name, id, action, expires,
+		r.FormValue("signature"), time.Now())
+	if err != nil {
+		return response{StatusCode: http.StatusForbidden, Message: err.Error()}
+	}
+	promotions, err := api.Database.GetPromotions(name)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if len(promotions) == 0 || promotions[0].ID != id || !promotions[0].AwaitingConfirmation {
+		return response{StatusCode: http.StatusConflict,
+			Message: fmt.Sprintf("%s's promotion is no longer awaiting confirmation", name)}
+	}
+	if _, err = api.Database.GetDomain(name, models.StateTesting); err != nil {
+		return response{StatusCode: http.StatusConflict,
+			Message: fmt.Sprintf("%s is no longer queued for the policy list", name)}
+	}
+	if action == models.PromotionHold {
+		if err = api.Database.SetStatus(name, models.StateHeld,
+			models.StateChange{Actor: "owner", Reason: r.FormValue("problem")}); err != nil {
+			return serverError(err.Error())
+		}
+		api.audit(models.AuditEntry{Actor: "owner", Action: "promotion.hold",
+			Subject: name, Details: r.FormValue("problem")})
+		return response{StatusCode: http.StatusOK,
+			Message: fmt.Sprintf("Thanks for letting us know. %s has been held back from the list, and our team will be in touch.", name)}
+	}
+	if api.Promoter == nil {
+		return response{StatusCode: http.StatusServiceUnavailable,
+			Message: "promotion vantage points aren't configured"}
+	}
+	p, err := api.Promoter.Confirm(api.Database, name)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if !p.Promoted {
+		return response{StatusCode: http.StatusConflict,
+			Message:  fmt.Sprintf("Thanks for confirming. %s no longer passes our checks, so it hasn't been added to the list yet; our team will be in touch.", name),
+			Response: p}
+	}
+	api.audit(models.AuditEntry{Actor: "owner", Action: "promotion.confirm", Subject: name})
+	api.notifyAdded(name)
+	return response{StatusCode: http.StatusOK, Response: p}
+}
+
+// notifyAdded tells the contact of name, which was just promoted to enforce,
+// that it's on the list.
+func (api API) notifyAdded(name string) {
+	if len(api.UnsubscribeSecret) == 0 {
+		return
+	}
+	domain, err := api.Database.GetDomain(name, models.StateEnforce)
+	if err != nil {
+		log.Printf("Couldn't look up %s to tell its contact it was added to the list: %v", name, err)
+		return
+	}
+	if err = api.Emailer.SendListAdded(&domain); err != nil {
+		log.Printf("Couldn't tell the contact of %s it was added to the list: %v", name, err)
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/promotion"
+)
+
+// mockVantage scans every domain with the same result.
+type mockVantage struct {
+	result checker.DomainResult
+}
+
+func (mockVantage) Name() string { return "mock" }
+
+func (v mockVantage) Scan(domain string) (checker.DomainResult, error) {
+	return v.result, nil
+}
+
+// passingResult returns a successful scan of domain, whose certificate is
+// valid for another year.
+func passingResult(domain string) checker.DomainResult {
+	result := checker.NewSampleDomainResult(domain)
+	notAfter := time.Now().Add(365 * 24 * time.Hour)
+	for hostname, hostnameResult := range result.HostnameResults {
+		hostnameResult.CertNotAfter = &notAfter
+		result.HostnameResults[hostname] = hostnameResult
+	}
+	return result
+}
+
+// unqueuedPolicy lets domains be promoted as soon as they're queued.
+var unqueuedPolicy = models.QueuePolicy{Default: &models.QueueClass{Name: "test"}}
+
+func TestPromotionConfirmation(t *testing.T) {
+	defer teardown()
+	api.PromotionSecret = []byte("secret")
+	api.UnsubscribeSecret = []byte("secret")
+	api.Promoter = &promotion.Verifier{MinVantages: 1, QueuePolicy: unqueuedPolicy,
+		Vantages: []promotion.Vantage{mockVantage{passingResult("confirm.com")}}}
+	rebind()
+	defer func() { api.PromotionSecret, api.UnsubscribeSecret, api.Promoter = nil, nil, nil; rebind() }()
+	lastAdded = ""
+
+	api.Database.PutDomain(models.Domain{Name: "confirm.com", Email: "admin@confirm.com",
+		MXs: []string{"mx.confirm.com"}, State: models.StateTesting})
+	api.Database.SetStatus("confirm.com", models.StateTesting, models.StateChange{})
+	p, err := api.Database.PutPromotion(models.Promotion{Domain: "confirm.com", Time: time.Now().Add(-time.Hour),
+		AwaitingConfirmation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(models.PromotionLinkTTL)
+	link := func(action string) url.Values {
+		values, _ := url.ParseQuery(models.PromotionLink(api.PromotionSecret, p, action, expires))
+		return values
+	}
+
+	forged := link(models.PromotionHold)
+	forged.Set("action", models.PromotionConfirm)
+	if _, code := testHTMLPost("/api/promotion", forged, t); code != http.StatusForbidden {
+		t.Errorf("Expected forged link to be rejected, got %d", code)
+	}
+	if _, code := testHTMLPost("/api/promotion", link(models.PromotionConfirm), t); code != http.StatusOK {
+		t.Errorf("Expected promotion to be confirmed, got %d", code)
+	}
+	if _, err := api.Database.GetDomain("confirm.com", models.StateEnforce); err != nil {
+		t.Errorf("Expected confirmed domain to be enforced: %v", err)
+	}
+	if lastAdded != "confirm.com" {
+		t.Errorf("Expected the contact to be told the domain was added, got %q", lastAdded)
+	}
+	if _, code := testHTMLPost("/api/promotion", link(models.PromotionHold), t); code != http.StatusConflict {
+		t.Errorf("Expected confirmed promotion not to be held, got %d", code)
+	}
+}
+
+func TestPromotionConfirmationVerifiesAgain(t *testing.T) {
+	defer teardown()
+	api.PromotionSecret = []byte("secret")
+	failing := passingResult("changed.com")
+	failing.Status = checker.DomainFailure
+	api.Promoter = &promotion.Verifier{MinVantages: 1, QueuePolicy: unqueuedPolicy,
+		Vantages: []promotion.Vantage{mockVantage{failing}}}
+	rebind()
+	defer func() { api.PromotionSecret, api.Promoter = nil, nil; rebind() }()
+
+	api.Database.PutDomain(models.Domain{Name: "changed.com", Email: "admin@changed.com",
+		MXs: []string{"mx.changed.com"}, State: models.StateTesting})
+	api.Database.SetStatus("changed.com", models.StateTesting, models.StateChange{})
+	p, err := api.Database.PutPromotion(models.Promotion{Domain: "changed.com", Time: time.Now().Add(-time.Hour),
+		AwaitingConfirmation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, _ := url.ParseQuery(models.PromotionLink(api.PromotionSecret, p, models.PromotionConfirm,
+		time.Now().Add(time.Hour)))
+	if _, code := testHTMLPost("/api/promotion", values, t); code != http.StatusConflict {
+		t.Errorf("Expected domain that no longer passes not to be promoted, got %d", code)
+	}
+	if _, err := api.Database.GetDomain("changed.com", models.StateTesting); err != nil {
+		t.Errorf("Expected domain to stay in testing: %v", err)
+	}
+	promotions, err := api.Database.GetPromotions("changed.com")
+	if err != nil || len(promotions) != 2 || promotions[0].Promoted || len(promotions[0].Failures) == 0 {
+		t.Errorf("Expected the failed verification to be recorded, got %+v (%v)", promotions, err)
+	}
+}
+
+func TestPromotionHold(t *testing.T) {
+	defer teardown()
+	api.PromotionSecret = []byte("secret")
+	rebind()
+	defer func() { api.PromotionSecret = nil; rebind() }()
+
+	api.Database.PutDomain(models.Domain{Name: "hold.com", Email: "admin@hold.com",
+		MXs: []string{"mx.hold.com"}, State: models.StateTesting})
+	api.Database.SetStatus("hold.com", models.StateTesting, models.StateChange{})
+	p, err := api.Database.PutPromotion(models.Promotion{Domain: "hold.com", Time: time.Now(),
+		AwaitingConfirmation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, _ := url.ParseQuery(models.PromotionLink(api.PromotionSecret, p, models.PromotionHold,
+		time.Now().Add(time.Hour)))
+	values.Set("problem", "moving to a new provider")
+	if _, code := testHTMLPost("/api/promotion", values, t); code != http.StatusOK {
+		t.Errorf("Expected promotion to be held, got %d", code)
+	}
+	if _, err := api.Database.GetDomain("hold.com", models.StateHeld); err != nil {
+		t.Errorf("Expected domain to be held: %v", err)
+	}
+	entries, err := api.Database.GetAuditLog("hold.com")
+	if err != nil || len(entries) != 3 || entries[0].Details != "moving to a new provider" ||
+		entries[1].Details != "queued to held: moving to a new provider" {
+		t.Errorf("Expected hold to be audited with the problem, got %+v (%v)", entries, err)
+	}
+}
+
+func TestReleaseHeld(t *testing.T) {
+	defer teardown()
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+	api.Database.PutDomain(models.Domain{Name: "held.com", Email: "admin@held.com",
+		MXs: []string{"mx.held.com"}, State: models.StateHeld})
+	api.Database.SetStatus("held.com", models.StateHeld, models.StateChange{})
+
+	release := func(data url.Values) int {
+		data.Set("domain", "held.com")
+		data.Set("release", "true")
+		req, _ := http.NewRequest("POST", server.URL+"/admin/promote", strings.NewReader(data.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := release(url.Values{}); code != http.StatusBadRequest {
+		t.Errorf("Expected release without an actor to be refused, got %d", code)
+	}
+	if code := release(url.Values{"actor": {"alice"}}); code != http.StatusOK {
+		t.Fatalf("Expected held domain to be released, got %d", code)
+	}
+	entries, err := api.Database.GetAuditLog("held.com")
+	if err != nil || len(entries) == 0 || entries[0].Action != "promotion.release" || entries[0].Actor != "alice" {
+		t.Errorf("Expected release to be audited as alice, got %+v (%v)", entries, err)
+	}
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/util"
+	"golang.org/x/net/idna"
+)
+
+// MaxEnrollDomains is the most domains that can be enrolled in one request.
+const MaxEnrollDomains = 100
+
+// maxEnrollScanAge is how old the scan whose MX records a provider vouches
+// for can be. The domain's MX records may have changed since older scans.
+const maxEnrollScanAge = 24 * time.Hour
+
+// mxChallenge is the TXT record a provider publishes to prove control of an
+// MX hostname.
+type mxChallenge struct {
+	Hostname string `json:"hostname"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+}
+
+// enrollment reports whether a single domain was enrolled.
+type enrollment struct {
+	Domain  string `json:"domain"`
+	Queued  bool   `json:"queued"`
+	Message string `json:"message,omitempty"`
+}
+
+func (api API) lookupTXT(name string) ([]string, error) {
+	if api.lookupTXTOverride != nil {
+		return api.lookupTXTOverride(name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var r net.Resolver
+	return r.LookupTXT(ctx, name)
+}
+
+// ProviderChallenge handles requests to /api/provider/challenge, for hosting
+// providers vouching for their customers' domains.
+//   GET /api/provider/challenge
+//        hostname: MX hostname to prove control of. May be repeated.
+//        Sets the TXT records to publish for each hostname as response.
+func (api API) providerChallenge(r *http.Request, key models.APIKey) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/provider/challenge only accepts GET requests"}
+	}
+	if len(api.ChallengeSecret) == 0 {
+		return response{StatusCode: http.StatusServiceUnavailable,
+			Message: "provider verification isn't enabled on this server"}
+	}
+	r.ParseForm()
+	if len(r.Form["hostname"]) == 0 {
+		return badRequest("query parameter hostname not specified")
+	}
+	challenges := []mxChallenge{}
+	for _, hostname := range r.Form["hostname"] {
+		ascii, err := idna.ToASCII(strings.ToLower(hostname))
+		if err != nil || !util.ValidDomainName(ascii) {
+			return badRequest("Hostname %s is invalid", hostname)
+		}
+		challenges = append(challenges, mxChallenge{
+			Hostname: ascii,
+			Name:     models.MXChallengeName(ascii),
+			Value:    models.MXChallenge(api.ChallengeSecret, key.Email, ascii),
+		})
+	}
+	return response{StatusCode: http.StatusOK, Response: challenges}
+}
+
+// ProviderEnroll handles requests to /api/provider/enroll, which queues
+// domains whose MX hostnames the provider has proven control of, without
+// emailing each domain's postmaster.
+//   POST /api/provider/enroll
+//        domain: Mail domain to queue. May be repeated, up to 100 times.
+//          Each must have been scanned successfully in the last day, and
+//          every MX hostname that scan found must publish the provider's
+//          challenge.
+//        weeks (optional, default 4): How many weeks the domains are queued for.
+//          Must be within the bounds api.QueuePolicy sets for each domain's
+//          class.
+//        Sets a list of results, one per domain, as response.
+func (api API) providerEnroll(r *http.Request, key models.APIKey) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/provider/enroll only accepts POST requests"}
+	}
+	if len(api.ChallengeSecret) == 0 {
+		return response{StatusCode: http.StatusServiceUnavailable,
+			Message: "provider verification isn't enabled on this server"}
+	}
+	r.ParseForm()
+	names := r.PostForm["domain"]
+	if len(names) == 0 {
+		return badRequest("query parameter domain not specified")
+	}
+	if len(names) > MaxEnrollDomains {
+		return badRequest("No more than %d domains can be enrolled at once", MaxEnrollDomains)
+	}
+	weeks, err := getInt("weeks", r, 1, 53, 0)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	// Customers often share MX hostnames, so only look each one up once.
+	vouched := make(map[string]bool)
+	results := []enrollment{}
+	for _, name := range names {
+		result, err := api.enroll(name, weeks, key, vouched)
+		if err != nil {
+			return serverError(err.Error())
+		}
+		results = append(results, result)
+	}
+	return response{StatusCode: http.StatusOK, Response: results}
+}
+
+// scannedMXs returns every MX hostname scan found in the domain's MX
+// records. Results that don't record them separately have them taken from
+// the hostname results.
+func scannedMXs(scan models.Scan) []string {
+	mxs := []string{}
+	for _, record := range scan.Data.MXRecords {
+		mxs = append(mxs, strings.TrimSuffix(strings.ToLower(record.Hostname), "."))
+	}
+	if len(mxs) > 0 {
+		return mxs
+	}
+	for hostname := range scan.Data.HostnameResults {
+		mxs = append(mxs, strings.TrimSuffix(strings.ToLower(hostname), "."))
+	}
+	sort.Strings(mxs)
+	return mxs
+}
+
+// enroll queues a single domain on behalf of the provider with key, if it's
+// queueable and the provider has vouched for all of its MX hostnames.
+func (api API) enroll(name string, weeks int, key models.APIKey, vouched map[string]bool) (enrollment, error) {
+	ascii, err := idna.ToASCII(strings.ToLower(name))
+	if err != nil || !util.ValidDomainName(ascii) {
+		return enrollment{Domain: name, Message: fmt.Sprintf("Domain %s is invalid", name)}, nil
+	}
+	name = ascii
+	result := enrollment{Domain: name}
+	scan, err := api.Database.GetLatestScan(name)
+	mxs := scannedMXs(scan)
+	if err != nil || len(mxs) == 0 {
+		result.Message = "We haven't scanned this domain's mailservers yet. Please scan it first"
+		return result, nil
+	}
+	if time.Since(scan.Timestamp) > maxEnrollScanAge {
+		result.Message = "We haven't scanned this domain's mailservers in the last day. Please scan it again"
+		return result, nil
+	}
+	for _, mx := range mxs {
+		ok, seen := vouched[mx]
+		if !seen {
+			records, _ := api.lookupTXT(models.MXChallengeName(mx))
+			ok = models.HasMXChallenge(records, api.ChallengeSecret, key.Email, mx)
+			vouched[mx] = ok
+		}
+		if !ok {
+			result.Message = fmt.Sprintf("%s doesn't publish your challenge at %s", mx, models.MXChallengeName(mx))
+			return result, nil
+		}
+	}
+	domain := models.Domain{
+		Name:       name,
+		Email:      key.Email,
+		MXs:        mxs,
+		State:      models.StateUnconfirmed,
+		QueueWeeks: weeks,
+	}
+	if domain.QueueWeeks == 0 {
+		domain.QueueWeeks = api.QueuePolicy.Class(name).DefaultWeeks()
+	}
+	if err = api.QueuePolicy.CheckWeeks(domain); err != nil {
+		result.Message = err.Error()
+		return result, nil
+	}
+	block, err := api.blockingSubmission(domain)
+	if err != nil {
+		return result, err
+	}
+	if block != nil {
+		result.Message = fmt.Sprintf("Submissions for %s aren't accepted.", name)
+		return result, nil
+	}
+	ok, msg, _, _ := domain.IsQueueable(api.Database, api.Database, api.List, api.MXCoverage)
+	if !ok {
+		result.Message = msg
+		return result, nil
+	}
+	if err = domain.InitializeVouched(api.Database); err != nil {
+		return result, err
+	}
+	result.Queued = true
+	return result, nil
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func TestProviderEnroll(t *testing.T) {
+	defer teardown()
+	api.ChallengeSecret = []byte("secret")
+	defer func() { api.ChallengeSecret = nil; rebind() }()
+	key := registerTestKey(t, "queue")
+
+	// Only mx.vouched.com publishes the provider's challenge.
+	api.lookupTXTOverride = func(name string) ([]string, error) {
+		if name == models.MXChallengeName("mx.vouched.com") {
+			return []string{models.MXChallenge(api.ChallengeSecret, key.Email, "mx.vouched.com")}, nil
+		}
+		return []string{models.MXChallenge(api.ChallengeSecret, "someone-else@example.com", "mx.other.com")}, nil
+	}
+	rebind()
+	defer func() { api.lookupTXTOverride = nil; rebind() }()
+	for _, domain := range []string{"vouched.com", "other.com"} {
+		http.PostForm(server.URL+"/api/scan", url.Values{"domain": {domain}})
+	}
+
+	data := url.Values{"domain": {"vouched.com", "other.com", "unscanned.com"}}
+	resp, _ := keyRequest(t, "POST", "/api/provider/enroll", key.Key, data)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Enrolling failed with %d", resp.StatusCode)
+	}
+
+	domain, err := models.GetDomain(api.Database, "vouched.com")
+	if err != nil || domain.State != models.StateTesting {
+		t.Errorf("Expected vouched.com to be queued in testing, got %v %v", domain.State, err)
+	}
+	if domain.Email != key.Email {
+		t.Errorf("Expected provider's address as contact, got %s", domain.Email)
+	}
+	if _, err := models.GetDomain(api.Database, "other.com"); err == nil {
+		t.Error("Expected other.com not to be queued without the provider's challenge")
+	}
+}
+
+func TestProviderEnrollChecksRecentMXRecords(t *testing.T) {
+	defer teardown()
+	api.ChallengeSecret = []byte("secret")
+	defer func() { api.ChallengeSecret = nil; rebind() }()
+	key := registerTestKey(t, "queue")
+	api.lookupTXTOverride = func(name string) ([]string, error) {
+		return []string{models.MXChallenge(api.ChallengeSecret, key.Email, "mx.moved.com"),
+			models.MXChallenge(api.ChallengeSecret, key.Email, "mx.stale.com")}, nil
+	}
+	rebind()
+	defer func() { api.lookupTXTOverride = nil; rebind() }()
+
+	// The challenge is checked against the MX records that were found, not
+	// the patterns the scan was asked to expect.
+	result := checker.NewSampleDomainResult("moved.com")
+	result.MxHostnames = []string{"mx.moved.com"}
+	result.MXRecords = []checker.MXRecord{{Hostname: "mx.elsewhere.com.", Preferred: true}}
+	api.Database.PutScan(models.Scan{Domain: "moved.com", Data: result, Timestamp: time.Now()})
+	if mxs := scannedMXs(models.Scan{Data: result}); len(mxs) != 1 || mxs[0] != "mx.elsewhere.com" {
+		t.Errorf("Expected the scanned MX records, got %v", mxs)
+	}
+	enrolled, err := api.enroll("moved.com", 0, key, map[string]bool{})
+	if err != nil || enrolled.Queued {
+		t.Errorf("Expected moved.com not to be queued for an MX without the challenge, got %+v %v", enrolled, err)
+	}
+
+	result = checker.NewSampleDomainResult("stale.com")
+	api.Database.PutScan(models.Scan{Domain: "stale.com", Data: result, Timestamp: time.Now().Add(-48 * time.Hour)})
+	enrolled, err = api.enroll("stale.com", 0, key, map[string]bool{})
+	if err != nil || enrolled.Queued {
+		t.Errorf("Expected stale.com not to be queued on a stale scan, got %+v %v", enrolled, err)
+	}
+}
+
+func TestProviderChallenge(t *testing.T) {
+	defer teardown()
+	key := registerTestKey(t, "queue")
+	path := "/api/provider/challenge?hostname=MX.Example.com"
+	if resp, _ := keyRequest(t, "GET", path, key.Key, url.Values{}); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected challenges to be unavailable without a secret, got %d", resp.StatusCode)
+	}
+
+	api.ChallengeSecret = []byte("secret")
+	rebind()
+	defer func() { api.ChallengeSecret = nil; rebind() }()
+	req, _ := http.NewRequest("GET", server.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+key.Key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Response []mxChallenge `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if len(body.Response) != 1 || body.Response[0].Name != "_starttls-challenge.mx.example.com" ||
+		body.Response[0].Value != models.MXChallenge(api.ChallengeSecret, key.Email, "mx.example.com") {
+		t.Errorf("Unexpected challenges %+v", body.Response)
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/email"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// queueConfirmation tells HTML clients where we sent the validation email and
+// which contact address we recorded, so they can spot typos right away.
+type queueConfirmation struct {
+	Domain            string
+	ValidationAddress string
+	// ContactPreview is the submitted contact address with most of its local
+	// part masked, since anyone can queue a domain.
+	ContactPreview string
+	TokenExpires   time.Time
+	// ExpiresIn describes how long until TokenExpires, like "72 hours".
+	ExpiresIn string
+}
+
+func newQueueConfirmation(domain models.Domain, token models.Token, now time.Time) queueConfirmation {
+	return queueConfirmation{
+		Domain:            domain.Name,
+		ValidationAddress: email.ValidationAddress(&domain),
+		ContactPreview:    maskEmail(domain.Email),
+		TokenExpires:      token.Expires,
+		ExpiresIn:         describeDuration(token.Expires.Sub(now)),
+	}
+}
+
+// maskEmail replaces all but the first and last characters of address's local
+// part with asterisks. The domain is left as is.
+func maskEmail(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		at = len(address)
+	}
+	local := []rune(address[:at])
+	for i := range local {
+		if i != 0 && (i != len(local)-1 || len(local) <= 2) {
+			local[i] = '*'
+		}
+	}
+	return string(local) + address[at:]
+}
+
+// describeDuration rounds d down to whole hours, or minutes if it's under two
+// hours.
+func describeDuration(d time.Duration) string {
+	if d >= 2*time.Hour {
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
+}
+
+// Each domain's validation email can be resent resendLimit times per
+// resendPeriod.
+const (
+	resendPeriod = time.Hour
+	resendLimit  = 3
+)
+
+// ResendValidation returns the handler for /api/queue/resend. How often each
+// domain's validation email is resent is limited in the database, so the
+// limit holds across restarts and API servers.
+//   POST /api/queue/resend
+//        domain: Domain whose submission is waiting on email validation.
+//        Generates a new validation token and emails it to the domain's
+//        validation address, like /api/queue. The token emailed when the
+//        domain was submitted keeps working until it expires, so resends
+//        can't break the submitter's link, but tokens from earlier resends
+//        stop working. Responds 429 if the domain's email was already
+//        resent 3 times in the last hour.
+func (api API) resendValidation() apiHandler {
+	return func(r *http.Request) response {
+		if r.Method != http.MethodPost {
+			return response{StatusCode: http.StatusMethodNotAllowed,
+				Message: "/api/queue/resend only accepts POST requests"}
+		}
+		name, err := getASCIIDomain(r)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		domain, err := api.Database.GetDomain(name, models.StateUnconfirmed)
+		if err == sql.ErrNoRows {
+			return response{StatusCode: http.StatusNotFound,
+				Message: fmt.Sprintf("No submission of %s is waiting on email validation.", name)}
+		}
+		if err != nil {
+			return serverError(err.Error())
+		}
+		suppressed, err := api.suppressedAddress(domain)
+		if err != nil {
+			return serverError(err.Error())
+		}
+		if suppressed != "" {
+			refused := badRequest("%s has bounced or unsubscribed from our emails, so we can't email it about %s", suppressed, domain.Name)
+			refused.code = errEmailSuppressed
+			return refused
+		}
+		token, retryAt, err := api.Database.ResendToken(name, resendPeriod, resendLimit)
+		if err != nil {
+			return serverError(err.Error())
+		}
+		if !retryAt.IsZero() {
+			return response{StatusCode: http.StatusTooManyRequests,
+				Message: fmt.Sprintf("The validation email for %s was resent too many times. Please try again in %s.",
+					name, describeDuration(time.Until(retryAt)))}
+		}
+		if err = api.Emailer.SendValidation(&domain, token.Token); err != nil {
+			log.Print(err)
+			return serverError("Unable to send validation e-mail")
+		}
+		return response{
+			StatusCode:   http.StatusOK,
+			Response:     fmt.Sprintf("We've sent a new validation email for %s. Please check %s to validate that you control the domain.", name, email.ValidationAddress(&domain)),
+			templateName: "queued",
+			details:      newQueueConfirmation(domain, token, time.Now()),
+		}
+	}
+}
@@ -33,6 +33,26 @@ func TestQueueHTML(t *testing.T) {
 	if !strings.Contains(string(body), "Thank you for submitting your domain") {
 		t.Errorf("Response should describe domain status, got %s", string(body))
 	}
+	for _, expected := range []string{"postmaster@example.com", "t*****g@fake-email.org", "expires in 71 hours", "Posting forms to the API"} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Response should contain %s, got %s", expected, string(body))
+		}
+	}
+}
+
+func TestMaskEmail(t *testing.T) {
+	tests := map[string]string{
+		"testing@fake-email.org": "t*****g@fake-email.org",
+		"ab@example.com":         "a*@example.com",
+		"a@example.com":          "a@example.com",
+		"":                       "",
+		"not-an-address":         "n************s",
+	}
+	for address, expected := range tests {
+		if masked := maskEmail(address); masked != expected {
+			t.Errorf("Expected %q to be masked as %q, got %q", address, expected, masked)
+		}
+	}
 }
 
 func TestQueueErrorHTML(t *testing.T) {
@@ -45,6 +65,9 @@ func TestQueueErrorHTML(t *testing.T) {
 	if !strings.Contains(string(body), "Bad Request") {
 		t.Errorf("Response should contain failed status text, got %s", string(body))
 	}
+	if !strings.Contains(string(body), "Posting forms to the API") {
+		t.Errorf("Response should contain the deprecation warning, got %s", string(body))
+	}
 }
 
 func TestGetDomainHidesEmail(t *testing.T) {
@@ -106,6 +129,25 @@ func TestQueueDomainInvalidWeeks(t *testing.T) {
 	}
 }
 
+func TestQueueDomainClassWeeks(t *testing.T) {
+	defer teardown()
+	api.QueuePolicy = models.QueuePolicy{Classes: []models.QueueClass{
+		{Name: "partner", Domains: []string{"example.com"}, MinWeeks: 1, MaxWeeks: 8},
+	}}
+	rebind()
+	defer func() { api.QueuePolicy = models.QueuePolicy{}; rebind() }()
+
+	requestData := validQueueData(true)
+	requestData.Set("weeks", "10")
+	if resp, _ := http.PostForm(server.URL+"/api/queue", requestData); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected weeks over the class's maximum to be refused, got %d", resp.StatusCode)
+	}
+	requestData.Set("weeks", "2")
+	if resp, _ := http.PostForm(server.URL+"/api/queue", requestData); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected partner domain to be queued for 2 weeks, got %d", resp.StatusCode)
+	}
+}
+
 // Tests basic queuing workflow.
 // Requests domain to be queued, and validates corresponding e-mail token.
 // Domain status should then be updated to "queued".
@@ -270,3 +312,47 @@ func TestQueueTwice(t *testing.T) {
 		t.Errorf("Old validation token shouldn't work.")
 	}
 }
+
+func TestResendValidation(t *testing.T) {
+	defer teardown()
+	rebind()
+	data := validQueueData(true)
+	http.PostForm(server.URL+"/api/queue", data)
+	first, err := api.Database.GetTokenByDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resend := url.Values{"domain": {"example.com"}}
+	for i := 0; i < resendLimit; i++ {
+		resp, err := http.PostForm(server.URL+"/api/queue/resend", resend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected resend %d to succeed, got %d", i+1, resp.StatusCode)
+		}
+	}
+	resp, err := http.PostForm(server.URL+"/api/queue/resend", resend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected resends to be limited per domain, got %d", resp.StatusCode)
+	}
+
+	latest, err := api.Database.GetTokenByDomain("example.com")
+	if err != nil || latest == first {
+		t.Fatalf("Expected a new token, got %s (%v)", latest, err)
+	}
+	if resp, _ = http.PostForm(server.URL+"/api/validate", url.Values{"token": {first}}); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the submission's token to keep working, got %d", resp.StatusCode)
+	}
+	resp, err = http.PostForm(server.URL+"/api/queue/resend", url.Values{"domain": {"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a validated domain's email not to be resent, got %d", resp.StatusCode)
+	}
+}
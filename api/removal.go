@@ -0,0 +1,116 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/EFForg/starttls-backend/email"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// removalGrace returns how long domains stay pending removal.
+func (api API) removalGrace() time.Duration {
+	if api.RemovalGrace > 0 {
+		return api.RemovalGrace
+	}
+	return models.DefaultRemovalGrace
+}
+
+// RequestRemoval handles requests to /api/remove/request
+//   POST /api/remove/request
+//        domain: Queued or listed domain to remove from the list. A
+//          verification token is sent to its validation address, which can
+//          be redeemed at /api/remove.
+func (api API) requestRemoval(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/remove/request only accepts POST requests"}
+	}
+	name, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	domain, err := models.GetDomain(api.Database, name)
+	if err != nil && err != sql.ErrNoRows {
+		return serverError(err.Error())
+	}
+	if err == sql.ErrNoRows || (domain.State != models.StateTesting && domain.State != models.StateEnforce) {
+		if domain.State == models.StatePendingRemoval {
+			return response{StatusCode: http.StatusConflict,
+				Message: fmt.Sprintf("%s is already being removed from the list", name)}
+		}
+		return response{StatusCode: http.StatusNotFound,
+			Message: fmt.Sprintf("%s isn't queued for or on the list", name)}
+	}
+	address := email.ValidationAddress(&domain)
+	suppressed, err := api.Database.IsBlacklistedEmail(address)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if suppressed {
+		return badRequest("%s has bounced or unsubscribed from our emails, so we can't email it about %s", address, name)
+	}
+	token, err := api.Database.PutRemovalToken(name)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if err = api.Emailer.SendRemovalVerification(&domain, token, api.removalGrace()); err != nil {
+		log.Print(err)
+		return serverError("Unable to send verification e-mail")
+	}
+	return response{StatusCode: http.StatusOK,
+		Response: "Please check " + address + " for a token to confirm removing " + name + " from the list."}
+}
+
+// pendingRemoval is a domain whose owner confirmed its removal, and when it
+// will be removed.
+type pendingRemoval struct {
+	Domain string    `json:"domain"`
+	Due    time.Time `json:"due"`
+}
+
+// Remove handles requests to /api/remove
+//   POST /api/remove
+//        token: Verification token sent by /api/remove/request. Each token
+//          can be used once.
+//        Moves the token's domain to pending-removal. It's removed once its
+//        grace period is over. Sets the domain and when it will be removed
+//        as response.
+func (api API) remove(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/remove only accepts POST requests"}
+	}
+	token, err := getParam("token", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	name, err := api.Database.UseRemovalToken(token)
+	if err == sql.ErrNoRows {
+		return badRequest("token is invalid, expired, or has already been used")
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	domain, err := models.GetDomain(api.Database, name)
+	if err != nil && err != sql.ErrNoRows {
+		return serverError(err.Error())
+	}
+	if err == sql.ErrNoRows || (domain.State != models.StateTesting && domain.State != models.StateEnforce) {
+		return response{StatusCode: http.StatusConflict,
+			Message: fmt.Sprintf("%s is no longer queued for or on the list", name)}
+	}
+	if err = api.Database.SetStatus(name, models.StatePendingRemoval,
+		models.StateChange{Actor: "owner", Reason: "removal requested"}); err != nil {
+		return serverError(err.Error())
+	}
+	domain, err = api.Database.GetDomain(name, models.StatePendingRemoval)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	return response{StatusCode: http.StatusOK,
+		Response: pendingRemoval{Domain: name, Due: domain.RemovalDue(api.removalGrace())}}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func TestRemoval(t *testing.T) {
+	defer teardown()
+	api.Database.PutDomain(models.Domain{Name: "listed.com", Email: "someone@example.com", MXs: []string{"mx.listed.com"}})
+	api.Database.SetStatus("listed.com", models.StateEnforce, models.StateChange{})
+	api.Database.PutDomain(models.Domain{Name: "pending.com", MXs: []string{"mx.pending.com"}})
+
+	resp, err := http.PostForm(server.URL+"/api/remove/request", url.Values{"domain": {"pending.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected an unvalidated domain not to be removable, got %d", resp.StatusCode)
+	}
+	if resp, err = http.PostForm(server.URL+"/api/remove/request", url.Values{"domain": {"listed.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Requesting removal failed with %d", resp.StatusCode)
+	}
+
+	var removal pendingRemoval
+	resp = webhookRequest(t, "POST", "/api/remove", "", url.Values{"token": {lastRemovalToken}}, &removal)
+	if resp.StatusCode != http.StatusOK || removal.Domain != "listed.com" ||
+		time.Until(removal.Due) < models.DefaultRemovalGrace-time.Hour {
+		t.Fatalf("Expected the removal to be confirmed, got %d: %+v", resp.StatusCode, removal)
+	}
+	if _, err = api.Database.GetDomain("listed.com", models.StatePendingRemoval); err != nil {
+		t.Errorf("Expected the domain to be pending removal: %v", err)
+	}
+	resp = webhookRequest(t, "POST", "/api/remove", "", url.Values{"token": {lastRemovalToken}}, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a used token to be rejected, got %d", resp.StatusCode)
+	}
+	if resp, err = http.PostForm(server.URL+"/api/remove/request", url.Values{"domain": {"listed.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a domain pending removal not to be removed again, got %d", resp.StatusCode)
+	}
+
+	removed, err := models.RemoveDue(api.Database, models.DefaultRemovalGrace, removal.Due)
+	if err != nil || len(removed) != 1 || removed[0] != "listed.com" {
+		t.Errorf("Expected the domain to be removed after its grace period, got %v, %v", removed, err)
+	}
+	entries, _ := api.Database.GetAuditLog("listed.com")
+	if len(entries) == 0 || entries[0].Details != "pending-removal removed: removal grace period ended" {
+		t.Errorf("Expected the removal in the audit log, got %+v", entries)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -27,6 +28,9 @@ func TestScanHTMLRequest(t *testing.T) {
 	if !strings.Contains(string(body), "eff.org") {
 		t.Errorf("Response should contain scan domain, got %s", string(body))
 	}
+	if !strings.Contains(string(body), "Posting forms to the API") {
+		t.Errorf("Response should contain the deprecation warning, got %s", string(body))
+	}
 }
 
 func TestScanWriteHTML(t *testing.T) {
@@ -84,6 +88,9 @@ func TestBasicScan(t *testing.T) {
 	if scan.Domain != "eff.org" {
 		t.Errorf("Scan JSON expected to have Domain: eff.org, not %s\n", scan.Domain)
 	}
+	if scan.Checker == nil || scan.Checker.Timeout != "3s" || scan.Checker.Checks[len(scan.Checker.Checks)-1] != checker.PolicyList {
+		t.Errorf("Scan JSON expected to record the checker's settings, got %+v", scan.Checker)
+	}
 
 	// Check to see that scan results persisted.
 	resp, _ = http.Get(server.URL + "/api/scan?domain=eff.org")
@@ -104,11 +111,46 @@ func TestBasicScan(t *testing.T) {
 	if scan2.Domain != "eff.org" {
 		t.Errorf("Scan JSON expected to have Domain: eff.org, not %s\n", scan2.Domain)
 	}
+	if scan2.Checker == nil || scan2.Checker.Version != scan.Checker.Version {
+		t.Errorf("Stored scan expected to keep the checker's settings, got %+v", scan2.Checker)
+	}
 	if strings.Compare(scan.Data.Domain, scan2.Data.Domain) != 0 {
 		t.Errorf("Scan JSON mismatch:\n%v\n%v\n", scan.Data.Domain, scan2.Data.Domain)
 	}
 }
 
+func TestScanUsesCheckerConfig(t *testing.T) {
+	defer teardown()
+	cfg := DefaultCheckerConfig()
+	cfg.Timeout = 7 * time.Second
+	api.CheckerConfig = &cfg
+	rebind()
+	defer func() { api.CheckerConfig = nil; rebind() }()
+
+	resp, _ := http.PostForm(server.URL+"/api/scan", url.Values{"domain": {"eff.org"}})
+	scan := models.Scan{}
+	json.NewDecoder(resp.Body).Decode(&response{Response: &scan})
+	if scan.Checker == nil || scan.Checker.Timeout != "7s" {
+		t.Errorf("Expected the scan to use the configured timeout, got %+v", scan.Checker)
+	}
+}
+
+func TestScansShareScanCache(t *testing.T) {
+	a := API{Database: api.Database}
+	a.ScanCache = a.NewScanCache()
+	if a.ScanCache == nil || a.newChecker(false).Cache != a.newChecker(false).Cache {
+		t.Error("Expected every scan to share the API's hostname scan cache")
+	}
+	if a.newChecker(true).Cache != nil {
+		t.Error("Expected verbose scans not to use cached hostname results")
+	}
+	cfg := DefaultCheckerConfig()
+	cfg.CacheExpiry = 0
+	if (API{CheckerConfig: &cfg}).NewScanCache() != nil {
+		t.Error("Expected no scan cache when caching is disabled")
+	}
+}
+
 func TestDontScanList(t *testing.T) {
 	defer teardown()
 
@@ -126,7 +168,7 @@ func TestScanCached(t *testing.T) {
 	data := url.Values{}
 	data.Set("domain", "eff.org")
 	http.PostForm(server.URL+"/api/scan", data)
-	original, _ := api.checkDomain("eff.org")
+	original, _ := api.checkDomain(context.Background(), "eff.org", false)
 	// Perform scan again, with different expected result.
 	api.checkDomainOverride = mockCheckPerform("somethingelse")
 	resp, _ := http.PostForm(server.URL+"/api/scan", data)
@@ -141,3 +183,139 @@ func TestScanCached(t *testing.T) {
 		t.Fatalf("Scan expected to have been cached, not reperformed\n")
 	}
 }
+
+func TestScanIgnoresUntrustedScans(t *testing.T) {
+	defer teardown()
+	for _, source := range []models.ScanSource{models.SourceCensus, models.SourceAPI} {
+		scan := models.Scan{
+			Domain:    "eff.org",
+			Data:      checker.DomainResult{Domain: "eff.org", Message: string(source)},
+			Timestamp: time.Now(),
+			Version:   models.ScanVersion,
+			Source:    source,
+			Profile:   models.ProfileFull,
+		}
+		if source == models.SourceAPI {
+			scan.Profile = models.ProfileQuick
+		}
+		if err := api.Database.PutScan(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, _ := http.Get(server.URL + "/api/scan?domain=eff.org")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected census and quick scans not to be served, got %d", resp.StatusCode)
+	}
+	resp, _ = http.PostForm(server.URL+"/api/scan", url.Values{"domain": {"eff.org"}})
+	scan := models.Scan{}
+	json.NewDecoder(resp.Body).Decode(&response{Response: &scan})
+	if resp.StatusCode != http.StatusOK || scan.Source != models.SourceAPI || scan.Profile != models.ProfileFull {
+		t.Errorf("Expected a new full scan rather than a cached untrusted one, got %d: %+v", resp.StatusCode, scan)
+	}
+}
+
+func TestScanFromDomainCache(t *testing.T) {
+	defer teardown()
+	checkedAt := time.Now().Add(-10 * time.Minute).UTC().Truncate(time.Second)
+	override := api.checkDomainOverride
+	api.checkDomainOverride = func(api API, domain string) (checker.DomainResult, error) {
+		result := checker.NewSampleDomainResult(domain)
+		result.CachedAt = checkedAt
+		return result, nil
+	}
+	rebind()
+	defer func() { api.checkDomainOverride = override; rebind() }()
+
+	resp, _ := http.PostForm(server.URL+"/api/scan", url.Values{"domain": {"eff.org"}})
+	scan := models.Scan{}
+	json.NewDecoder(resp.Body).Decode(&response{Response: &scan})
+	if resp.StatusCode != http.StatusOK || !scan.Timestamp.Equal(checkedAt) {
+		t.Errorf("Expected the cached result, from when it was checked, got %d: %v", resp.StatusCode, scan.Timestamp)
+	}
+	if _, err := api.Database.GetLatestScan("eff.org"); err == nil {
+		t.Error("Expected cached results not to be stored as new scans")
+	}
+}
+
+func TestScanCacheHeader(t *testing.T) {
+	now := time.Now()
+	scan := models.Scan{
+		Domain:    "example.com",
+		Timestamp: now.Add(-30 * time.Second),
+		Version:   models.ScanVersion,
+	}
+	header := scanCacheHeader(scan, now)
+	if header.Get("Cache-Control") != "public, max-age=30" {
+		t.Errorf("Expected fresh scan to be cacheable for 30s, got %s", header.Get("Cache-Control"))
+	}
+	if header.Get("ETag") == "" {
+		t.Errorf("Expected scan to have an ETag")
+	}
+	scan.Timestamp = now.Add(-time.Hour)
+	header = scanCacheHeader(scan, now)
+	if header.Get("Cache-Control") != "public, max-age=0" {
+		t.Errorf("Expected stale scan to have max-age 0, got %s", header.Get("Cache-Control"))
+	}
+}
+
+func TestScanDiff(t *testing.T) {
+	defer teardown()
+
+	resp, _ := http.Get(server.URL + "/api/scan/diff?domain=diff.example.com")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected diff without earlier scans to 404, got %d", resp.StatusCode)
+	}
+	older := checker.NewSampleDomainResult("diff.example.com")
+	newer := checker.NewSampleDomainResult("diff.example.com")
+	newer.HostnameResults["mx.diff.example.com"].Checks[checker.STARTTLS].Failure("No STARTTLS")
+	for i, data := range []checker.DomainResult{older, newer} {
+		api.Database.PutScan(models.Scan{
+			Domain:    "diff.example.com",
+			Data:      data,
+			Timestamp: time.Now().Add(time.Duration(i) * time.Minute),
+		})
+	}
+	resp, err := http.Get(server.URL + "/api/scan/diff?domain=diff.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Response checker.ScanDiff `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	changes := body.Response.Changes
+	if len(changes) != 1 || changes[0].Check != checker.STARTTLS || *changes[0].New != checker.Failure {
+		t.Errorf("Expected STARTTLS check to have broken, got %+v", body.Response)
+	}
+}
+
+func TestScanHostnames(t *testing.T) {
+	defer teardown()
+
+	resp, _ := http.Get(server.URL + "/api/scan/hostnames?domain=hostnames.example.com")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected hostnames of an unscanned domain to 404, got %d", resp.StatusCode)
+	}
+	api.Database.PutScan(models.Scan{
+		Domain:    "hostnames.example.com",
+		Data:      checker.NewSampleDomainResult("hostnames.example.com"),
+		Timestamp: time.Now(),
+	})
+	resp, err := http.Get(server.URL + "/api/scan/hostnames?domain=hostnames.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Response struct {
+			Results map[string]struct {
+				TLSVersion string `json:"tls_version"`
+				Status     int    `json:"status"`
+			} `json:"results"`
+		} `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	result, ok := body.Response.Results["mx.hostnames.example.com"]
+	if !ok || result.TLSVersion != "TLS 1.3" {
+		t.Errorf("Expected the stored scan's full hostname results, got %+v", body.Response)
+	}
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for ScanSchedulerFromEnv.
+const (
+	defaultMaxActiveScans    = 32
+	defaultMaxScansPerClient = 4
+	defaultMaxQueuedScans    = 8
+	defaultScanQueueTimeout  = 30 * time.Second
+)
+
+// errScanQueueFull is returned when a client already has as many scans
+// waiting as we allow, or a scan waits too long for a slot.
+var errScanQueueFull = errors.New("too many scans in progress; please try again shortly")
+
+// ScanSchedulerStats reports the scans each client is running.
+type ScanSchedulerStats struct {
+	MaxActive    int `json:"max_active"`
+	MaxPerClient int `json:"max_per_client"`
+	MaxQueued    int `json:"max_queued"`
+	// Scans currently running, and waiting for a slot.
+	Active int `json:"active"`
+	Queued int `json:"queued"`
+	// Clients with scans running or waiting.
+	Clients int `json:"clients"`
+	// Most scans that have run at once.
+	PeakActive int `json:"peak_active"`
+	// Scans that had to wait for a slot, and scans refused with a 429.
+	TotalQueued   int `json:"total_queued"`
+	TotalRejected int `json:"total_rejected"`
+}
+
+// ScanScheduler caps the number of scans running at once, and the number each
+// client (an API key or IP address) can run, so that one heavy user can't
+// take every slot. Scans beyond a client's cap wait in line, up to MaxQueued
+// per client, for at most QueueTimeout.
+type ScanScheduler struct {
+	MaxPerClient int
+	MaxQueued    int
+	QueueTimeout time.Duration
+
+	slots   chan struct{}
+	mu      sync.Mutex
+	clients map[string]*scanClient
+	stats   ScanSchedulerStats
+}
+
+type scanClient struct {
+	slots   chan struct{}
+	waiting int
+}
+
+// NewScanScheduler returns a scheduler that runs at most maxActive scans at
+// once, and at most maxPerClient for a single client.
+func NewScanScheduler(maxActive int, maxPerClient int, maxQueued int, queueTimeout time.Duration) *ScanScheduler {
+	return &ScanScheduler{
+		MaxPerClient: maxPerClient,
+		MaxQueued:    maxQueued,
+		QueueTimeout: queueTimeout,
+		slots:        make(chan struct{}, maxActive),
+		clients:      make(map[string]*scanClient),
+		stats: ScanSchedulerStats{
+			MaxActive:    maxActive,
+			MaxPerClient: maxPerClient,
+			MaxQueued:    maxQueued,
+		},
+	}
+}
+
+// ScanSchedulerFromEnv returns a scheduler configured by the env vars
+// SCAN_MAX_ACTIVE, SCAN_MAX_PER_CLIENT, SCAN_MAX_QUEUED and
+// SCAN_QUEUE_TIMEOUT.
+func ScanSchedulerFromEnv() (*ScanScheduler, error) {
+	maxActive, err := envInt("SCAN_MAX_ACTIVE", defaultMaxActiveScans)
+	if err != nil {
+		return nil, err
+	}
+	maxPerClient, err := envInt("SCAN_MAX_PER_CLIENT", defaultMaxScansPerClient)
+	if err != nil {
+		return nil, err
+	}
+	maxQueued, err := envInt("SCAN_MAX_QUEUED", defaultMaxQueuedScans)
+	if err != nil {
+		return nil, err
+	}
+	queueTimeout := defaultScanQueueTimeout
+	if value := os.Getenv("SCAN_QUEUE_TIMEOUT"); value != "" {
+		if queueTimeout, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("SCAN_QUEUE_TIMEOUT must be a duration like 30s: %v", err)
+		}
+	}
+	return NewScanScheduler(maxActive, maxPerClient, maxQueued, queueTimeout), nil
+}
+
+func envInt(varName string, defaultValue int) (int, error) {
+	value := os.Getenv(varName)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", varName)
+	}
+	return n, nil
+}
+
+// Stats returns the scheduler's current usage.
+func (s *ScanScheduler) Stats() ScanSchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Clients = len(s.clients)
+	return stats
+}
+
+// acquire waits for a slot to run a scan for client. If it returns nil, the
+// caller must call release(client) once the scan is done.
+func (s *ScanScheduler) acquire(client string) error {
+	s.mu.Lock()
+	c, ok := s.clients[client]
+	if !ok {
+		c = &scanClient{slots: make(chan struct{}, s.MaxPerClient)}
+		s.clients[client] = c
+	}
+	full := len(c.slots) == cap(c.slots) || len(s.slots) == cap(s.slots)
+	if full && c.waiting >= s.MaxQueued {
+		s.stats.TotalRejected++
+		s.forget(client, c)
+		s.mu.Unlock()
+		return errScanQueueFull
+	}
+	c.waiting++
+	s.stats.Queued++
+	if full {
+		s.stats.TotalQueued++
+	}
+	s.mu.Unlock()
+
+	timeout := time.NewTimer(s.QueueTimeout)
+	defer timeout.Stop()
+	err := errScanQueueFull
+	select {
+	case c.slots <- struct{}{}:
+		select {
+		case s.slots <- struct{}{}:
+			err = nil
+		case <-timeout.C:
+			<-c.slots
+		}
+	case <-timeout.C:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.waiting--
+	s.stats.Queued--
+	if err != nil {
+		s.stats.TotalRejected++
+		s.forget(client, c)
+		return err
+	}
+	s.stats.Active++
+	if s.stats.Active > s.stats.PeakActive {
+		s.stats.PeakActive = s.stats.Active
+	}
+	return nil
+}
+
+func (s *ScanScheduler) release(client string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.clients[client]
+	<-s.slots
+	<-c.slots
+	s.stats.Active--
+	s.forget(client, c)
+}
+
+// forget removes client once it has no scans running or waiting. s.mu must
+// be held.
+func (s *ScanScheduler) forget(client string, c *scanClient) {
+	if len(c.slots) == 0 && c.waiting == 0 {
+		delete(s.clients, client)
+	}
+}
+
+// scanClientKey is the context key for the client a scan is run for.
+type scanClientKey struct{}
+
+// withScanClient records that scans requested by r are run for client.
+func withScanClient(r *http.Request, client string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), scanClientKey{}, client))
+}
+
+// scanClientOf returns the client scans requested by r are run for: the API
+// key it was authenticated with, or else its IP address.
+func scanClientOf(r *http.Request) string {
+	if client, ok := r.Context().Value(scanClientKey{}).(string); ok {
+		return client
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScanSchedulerCapsClients(t *testing.T) {
+	s := NewScanScheduler(3, 1, 1, 50*time.Millisecond)
+	if err := s.acquire("heavy"); err != nil {
+		t.Fatal(err)
+	}
+	// The heavy client's next scan waits in line, and times out.
+	if err := s.acquire("heavy"); err != errScanQueueFull {
+		t.Errorf("Expected queued scan to time out, got %v", err)
+	}
+	// Other clients still get a slot.
+	if err := s.acquire("light"); err != nil {
+		t.Errorf("Expected another client's scan to run: %v", err)
+	}
+	s.release("light")
+
+	// A queued scan runs once the client's earlier scan finishes.
+	done := make(chan error)
+	go func() { done <- s.acquire("heavy") }()
+	time.Sleep(10 * time.Millisecond)
+	s.release("heavy")
+	if err := <-done; err != nil {
+		t.Errorf("Expected queued scan to run after release: %v", err)
+	}
+	s.release("heavy")
+
+	stats := s.Stats()
+	if stats.Active != 0 || stats.Queued != 0 || stats.Clients != 0 {
+		t.Errorf("Expected scheduler to be idle, got %+v", stats)
+	}
+	if stats.PeakActive != 2 || stats.TotalQueued != 2 || stats.TotalRejected != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestScanSchedulerRejectsWhenQueueFull(t *testing.T) {
+	s := NewScanScheduler(1, 1, 0, time.Second)
+	if err := s.acquire("a"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	// Without room in the queue, scans are refused right away, even when
+	// it's the overall limit that's been reached.
+	for _, client := range []string{"a", "b"} {
+		if err := s.acquire(client); err != errScanQueueFull {
+			t.Errorf("Expected %s's scan to be refused, got %v", client, err)
+		}
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("Expected scans to be refused without waiting")
+	}
+	s.release("a")
+}
+
+func TestScanClientOf(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/scan", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	if client := scanClientOf(r); client != "ip:192.0.2.1" {
+		t.Errorf("Expected client to be identified by IP, got %s", client)
+	}
+	if client := scanClientOf(withScanClient(r, "key:1")); client != "key:1" {
+		t.Errorf("Expected client to be identified by API key, got %s", client)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// FakeNetwork logs emails instead of sending them, and never finds MX
+// challenges. Use it with checker.Config.FakeNetwork, so that scans run
+// against in-process fakes.
+func (api *API) FakeNetwork() {
+	api.lookupTXTOverride = func(name string) ([]string, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	api.Emailer = loggingEmailer{}
+}
+
+// loggingEmailer logs emails instead of sending them.
+type loggingEmailer struct{}
+
+func (loggingEmailer) SendValidation(domain *models.Domain, token string) error {
+	log.Printf("[mock network] validation email for %s", domain.Name)
+	return nil
+}
+
+func (loggingEmailer) SendAPIKeyVerification(address string, token string) error {
+	log.Printf("[mock network] API key verification email")
+	return nil
+}
+
+func (loggingEmailer) SendDataExportVerification(address string, token string) error {
+	log.Printf("[mock network] data export verification email")
+	return nil
+}
+
+func (loggingEmailer) SendDataErasureVerification(address string, token string) error {
+	log.Printf("[mock network] data erasure verification email")
+	return nil
+}
+
+func (loggingEmailer) SendSubmissionRejected(domain *models.Domain, note string) error {
+	log.Printf("[mock network] submission rejected email for %s", domain.Name)
+	return nil
+}
+
+func (loggingEmailer) SendPromotionConfirmation(domain *models.Domain, confirm string, hold string, expires time.Time) error {
+	log.Printf("[mock network] promotion confirmation email for %s", domain.Name)
+	return nil
+}
+
+func (loggingEmailer) SendListAdded(domain *models.Domain) error {
+	log.Printf("[mock network] list addition email for %s", domain.Name)
+	return nil
+}
+
+func (loggingEmailer) SendRemovalVerification(domain *models.Domain, token string, grace time.Duration) error {
+	log.Printf("[mock network] removal verification email for %s", domain.Name)
+	return nil
+}
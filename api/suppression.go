@@ -0,0 +1,127 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/email"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// MaxSuppressions is the most addresses a single webhook call can suppress.
+const MaxSuppressions = 100
+
+// isSuppressionWebhook returns true if the request bears the key in
+// SUPPRESSION_WEBHOOK_KEY, as a bearer token or the key query parameter,
+// since not every mail provider lets webhooks set headers.
+func isSuppressionWebhook(r *http.Request) bool {
+	key := os.Getenv("SUPPRESSION_WEBHOOK_KEY")
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if given == "" {
+		given = r.URL.Query().Get("key")
+	}
+	return key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(given)) == 1
+}
+
+// Suppress handles requests to /api/suppressions
+//   POST /api/suppressions
+//        email: Address to never email again. Up to 100 can be given.
+//        reason: "bounce", "complaint" or "unsubscribe".
+//        timestamp (optional, default now): When the mail provider
+//          received the notification, in RFC 3339.
+//        Requires the SUPPRESSION_WEBHOOK_KEY or the admin key. Sets the
+//        number of addresses suppressed as response.
+func (api API) suppress(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/suppressions only accepts POST requests"}
+	}
+	if !isSuppressionWebhook(r) && !isAdmin(r) {
+		return response{StatusCode: http.StatusUnauthorized,
+			Message: "a valid suppression webhook key is required"}
+	}
+	r.ParseForm()
+	addresses := r.PostForm["email"]
+	if len(addresses) == 0 {
+		return badRequest("query parameter email not specified")
+	}
+	if len(addresses) > MaxSuppressions {
+		return badRequest("No more than %d addresses can be suppressed at once", MaxSuppressions)
+	}
+	for _, address := range addresses {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return badRequest("%s is not a valid email address", address)
+		}
+	}
+	reason, err := models.ParseSuppressionReason(r.PostForm.Get("reason"))
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	if given := r.PostForm.Get("timestamp"); given != "" {
+		if _, err := time.Parse(time.RFC3339, given); err != nil {
+			return badRequest("timestamp must be in RFC 3339, not %q", given)
+		}
+		timestamp = given
+	}
+	for _, address := range addresses {
+		if err = api.Database.PutBlacklistedEmail(address, reason, timestamp); err != nil {
+			return serverError(err.Error())
+		}
+	}
+	return response{StatusCode: http.StatusOK, Response: len(addresses)}
+}
+
+// Unsubscribe handles requests to /api/unsubscribe, which the frontend's
+// /unsubscribe page posts the parameters of the opt-out links in our
+// notifications to.
+//   POST /api/unsubscribe
+//        email: Address to never email again.
+//        signature: The link's signature.
+func (api API) unsubscribe(r *http.Request) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/unsubscribe only accepts POST requests"}
+	}
+	if len(api.UnsubscribeSecret) == 0 {
+		return response{StatusCode: http.StatusNotFound,
+			Message: "notifications aren't sent, so there's nothing to opt out of"}
+	}
+	address := r.FormValue("email")
+	if _, err := mail.ParseAddress(address); err != nil {
+		return badRequest("%s is not a valid email address", address)
+	}
+	if err := models.CheckUnsubscribeLink(api.UnsubscribeSecret, address, r.FormValue("signature")); err != nil {
+		return response{StatusCode: http.StatusForbidden, Message: err.Error()}
+	}
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	if err := api.Database.PutBlacklistedEmail(address, models.SuppressUnsubscribe, timestamp); err != nil {
+		return serverError(err.Error())
+	}
+	return response{StatusCode: http.StatusOK,
+		Message: fmt.Sprintf("%s won't receive any more emails from us.", address)}
+}
+
+// suppressedAddress returns the first of the addresses we'd email about the
+// domain that's on the suppression list, or "" if none are.
+func (api API) suppressedAddress(domain models.Domain) (string, error) {
+	addresses := []string{email.ValidationAddress(&domain)}
+	if domain.Email != "" && !strings.EqualFold(domain.Email, addresses[0]) {
+		addresses = append(addresses, domain.Email)
+	}
+	for _, address := range addresses {
+		suppressed, err := api.Database.IsBlacklistedEmail(address)
+		if err != nil {
+			return "", fmt.Errorf("couldn't check the suppression list: %v", err)
+		}
+		if suppressed {
+			return address, nil
+		}
+	}
+	return "", nil
+}
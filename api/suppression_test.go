@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func TestSuppressRequiresKey(t *testing.T) {
+	defer teardown()
+	os.Setenv("SUPPRESSION_WEBHOOK_KEY", "secret")
+	defer os.Unsetenv("SUPPRESSION_WEBHOOK_KEY")
+
+	data := url.Values{"email": {"gone@example.com"}, "reason": {"bounce"}}
+	resp, err := http.PostForm(server.URL+"/api/suppressions?key=wrong", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the webhook to require its key, got %d", resp.StatusCode)
+	}
+	if suppressed, _ := api.Database.IsBlacklistedEmail("gone@example.com"); suppressed {
+		t.Error("Expected an unauthorized request not to suppress the address")
+	}
+}
+
+func TestSuppress(t *testing.T) {
+	defer teardown()
+	os.Setenv("SUPPRESSION_WEBHOOK_KEY", "secret")
+	defer os.Unsetenv("SUPPRESSION_WEBHOOK_KEY")
+
+	for _, data := range []url.Values{
+		{"email": {"gone@example.com"}, "reason": {"delivered"}},
+		{"email": {"not an address"}, "reason": {"bounce"}},
+		{"email": {"gone@example.com"}, "reason": {"bounce"}, "timestamp": {"yesterday"}},
+	} {
+		resp, err := http.PostForm(server.URL+"/api/suppressions?key=secret", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected %v to be refused, got %d", data, resp.StatusCode)
+		}
+	}
+
+	data := url.Values{"email": {"Gone@example.com", "postmaster@example.com"}, "reason": {"Unsubscribe"}}
+	resp, err := http.PostForm(server.URL+"/api/suppressions?key=secret", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Suppressing addresses failed with %d", resp.StatusCode)
+	}
+	export, _ := api.Database.GetDataExport("gone@example.com")
+	if len(export.Bounces) != 1 || export.Bounces[0].Reason != models.SuppressUnsubscribe {
+		t.Errorf("Expected the address to be unsubscribed, got %+v", export.Bounces)
+	}
+
+	// The queue refuses submissions we couldn't send validation emails for.
+	resp, err = http.PostForm(server.URL+"/api/queue", validQueueData(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a suppressed postmaster address to be refused, got %d", resp.StatusCode)
+	}
+	if _, err := api.Database.GetDomain("example.com", models.StateUnconfirmed); err == nil {
+		t.Error("Expected the refused submission not to be stored")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	defer teardown()
+	api.UnsubscribeSecret = []byte("secret")
+	rebind()
+	defer func() { api.UnsubscribeSecret = nil; rebind() }()
+
+	values, _ := url.ParseQuery(models.UnsubscribeLink(api.UnsubscribeSecret, "contact@example.com"))
+	forged := url.Values{"email": {"someone@example.com"}, "signature": values["signature"]}
+	resp, err := http.PostForm(server.URL+"/api/unsubscribe", forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a forged link to be refused, got %d", resp.StatusCode)
+	}
+	if resp, err = http.PostForm(server.URL+"/api/unsubscribe", values); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the address to be unsubscribed, got %d", resp.StatusCode)
+	}
+	if suppressed, _ := api.Database.IsBlacklistedEmail("contact@example.com"); !suppressed {
+		t.Error("Expected the address to be on the suppression list")
+	}
+	if suppressed, _ := api.Database.IsBlacklistedEmail("someone@example.com"); suppressed {
+		t.Error("Expected the forged address not to be on the suppression list")
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// Error codes set in v2 error responses, which integrators can rely on
+// instead of parsing messages. Responses that don't set a code get the
+// generic one for their status.
+const (
+	errInvalidRequest   = "invalid_request"
+	errUnauthorized     = "unauthorized"
+	errForbidden        = "forbidden"
+	errNotFound         = "not_found"
+	errMethodNotAllowed = "method_not_allowed"
+	errConflict         = "conflict"
+	errRateLimited      = "rate_limited"
+	errInternal         = "internal_error"
+	errUnavailable      = "unavailable"
+
+	errCaptchaRejected   = "captcha_rejected"
+	errNotQueueable      = "not_queueable"
+	errSubmissionBlocked = "submission_blocked"
+	errEmailSuppressed   = "email_suppressed"
+	errScanRefused       = "scan_refused"
+	errQuotaExceeded     = "quota_exceeded"
+)
+
+// statusErrorCodes are the generic error codes for each status.
+var statusErrorCodes = map[int]string{
+	http.StatusBadRequest:          errInvalidRequest,
+	http.StatusUnauthorized:        errUnauthorized,
+	http.StatusForbidden:           errForbidden,
+	http.StatusNotFound:            errNotFound,
+	http.StatusMethodNotAllowed:    errMethodNotAllowed,
+	http.StatusConflict:            errConflict,
+	http.StatusTooManyRequests:     errRateLimited,
+	http.StatusInternalServerError: errInternal,
+	http.StatusServiceUnavailable:  errUnavailable,
+}
+
+// errorCode returns resp's error code, or "" if it succeeded.
+func (resp response) errorCode() string {
+	if resp.StatusCode < http.StatusBadRequest {
+		return ""
+	}
+	if resp.code != "" {
+		return resp.code
+	}
+	if code, ok := statusErrorCodes[resp.StatusCode]; ok {
+		return code
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return errInternal
+	}
+	return errInvalidRequest
+}
+
+// v2Envelope is the body of every v2 response. Data is set if the request
+// succeeded, and Error if it didn't.
+type v2Envelope struct {
+	Data     interface{} `json:"data"`
+	Error    *v2Error    `json:"error,omitempty"`
+	Warnings []string    `json:"warnings,omitempty"`
+}
+
+// v2Error describes why a v2 request failed. Details has extra data for
+// some codes, like the MX coverage shortfall of an unqueueable domain.
+type v2Error struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// v2Presenter converts a successful v1 handler's response to its v2 data.
+type v2Presenter func(r *http.Request, resp response) interface{}
+
+// wrapperV2 serves a v1 handler under /api/v2, with its successful
+// responses converted by present and every response wrapped in a
+// v2Envelope. v2 responses are always JSON.
+func (api *API) wrapperV2(handler apiHandler, present v2Presenter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// v2 never renders HTML, so handlers shouldn't prepare it.
+		r.Header.Set("Accept", "application/json")
+		resp := handler(r)
+		if !writeHeader(w, r, resp) {
+			return
+		}
+		body := v2Envelope{Warnings: resp.Warnings}
+		if code := resp.errorCode(); code != "" {
+			message := resp.Message
+			if message == "" {
+				message = http.StatusText(resp.StatusCode)
+			}
+			body.Error = &v2Error{Code: code, Message: message, Details: resp.Response}
+		} else {
+			body.Data = present(r, resp)
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(resp.StatusCode)
+		b, err := json.MarshalIndent(body, "", "  ")
+		if err != nil {
+			msg := fmt.Sprintf("Internal error: could not format JSON. (%s)\n", err)
+			http.Error(w, msg, http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%s\n", b)
+	}
+}
+
+// ScanV2 is a scan in the v2 API.
+type ScanV2 struct {
+	Domain    string    `json:"domain"`
+	ScannedAt time.Time `json:"scanned_at"`
+	// Status summarises Result, like "success" or "no_support".
+	Status string `json:"status"`
+	// Result of the scan, encoded with the schema version it records.
+	Result checker.DomainResult `json:"result"`
+	// Checker that performed the scan. Nil for scans stored before it was
+	// recorded.
+	Checker *checker.Settings `json:"checker,omitempty"`
+}
+
+// DomainV2 is a domain submitted to the policy list, in the v2 API.
+type DomainV2 struct {
+	Domain      string    `json:"domain"`
+	State       string    `json:"state"`
+	MXs         []string  `json:"mxs"`
+	MTASTS      bool      `json:"mta_sts"`
+	MTASTSMode  string    `json:"mta_sts_mode,omitempty"`
+	QueueWeeks  int       `json:"queue_weeks"`
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+// SubmissionV2 is the outcome of submitting a domain to the queue, in the
+// v2 API. State is "unvalidated" if a validation email was sent to
+// ValidationAddress, or "flagged" if the submission is held for review
+// first.
+type SubmissionV2 struct {
+	Domain            string     `json:"domain"`
+	State             string     `json:"state"`
+	ValidationAddress string     `json:"validation_address,omitempty"`
+	TokenExpires      *time.Time `json:"token_expires,omitempty"`
+	Message           string     `json:"message"`
+}
+
+// ValidationV2 is the domain whose submission was validated, in the v2 API.
+type ValidationV2 struct {
+	Domain string `json:"domain"`
+}
+
+// domainStatusNames are the v2 names of each checker.DomainStatus.
+var domainStatusNames = map[checker.DomainStatus]string{
+	checker.DomainSuccess:            "success",
+	checker.DomainWarning:            "warning",
+	checker.DomainFailure:            "failure",
+	checker.DomainError:              "error",
+	checker.DomainNoSTARTTLSFailure:  "no_support",
+	checker.DomainCouldNotConnect:    "could_not_connect",
+	checker.DomainBadHostnameFailure: "bad_hostname",
+	checker.DomainTimedOut:           "timed_out",
+}
+
+func newScanV2(scan models.Scan) ScanV2 {
+	status, ok := domainStatusNames[scan.Data.Status]
+	if !ok {
+		status = "unknown"
+	}
+	return ScanV2{Domain: scan.Domain, ScannedAt: scan.Timestamp.UTC(), Status: status,
+		Result: scan.Data, Checker: scan.Checker}
+}
+
+func newDomainV2(domain models.Domain) DomainV2 {
+	mxs := domain.MXs
+	if mxs == nil {
+		mxs = []string{}
+	}
+	return DomainV2{Domain: domain.Name, State: string(domain.State), MXs: mxs, MTASTS: domain.MTASTS,
+		MTASTSMode: domain.MTASTSMode, QueueWeeks: domain.QueueWeeks, LastUpdated: domain.LastUpdated.UTC()}
+}
+
+// presentScan presents /api/scan responses as a ScanV2.
+func presentScan(r *http.Request, resp response) interface{} {
+	return newScanV2(resp.Response.(models.Scan))
+}
+
+// presentQueue presents /api/queue responses as a DomainV2 for lookups, or
+// a SubmissionV2 for submissions.
+func presentQueue(r *http.Request, resp response) interface{} {
+	if domain, ok := resp.Response.(models.Domain); ok {
+		return newDomainV2(domain)
+	}
+	message, _ := resp.Response.(string)
+	if confirmation, ok := resp.details.(queueConfirmation); ok {
+		return SubmissionV2{Domain: confirmation.Domain, State: models.StateUnconfirmed,
+			ValidationAddress: confirmation.ValidationAddress, TokenExpires: &confirmation.TokenExpires,
+			Message: message}
+	}
+	domain, _ := getASCIIDomain(r)
+	return SubmissionV2{Domain: domain, State: models.StateFlagged, Message: message}
+}
+
+// presentValidation presents /api/validate responses as the validated
+// domain's name.
+func presentValidation(r *http.Request, resp response) interface{} {
+	domain, _ := resp.Response.(string)
+	return ValidationV2{Domain: domain}
+}
+
+// v2NotFound responds to requests for paths under /api/v2 that don't exist.
+func v2NotFound(r *http.Request) response {
+	return response{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("%s isn't a v2 endpoint", r.URL.Path)}
+}
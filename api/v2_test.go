@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// v2Request makes a request to the v2 API, and decodes its data into data.
+func v2Request(t *testing.T, method string, path string, values url.Values, data interface{}) (*http.Response, *v2Error) {
+	var resp *http.Response
+	var err error
+	if method == http.MethodPost {
+		resp, err = http.PostForm(server.URL+path, values)
+	} else {
+		resp, err = http.Get(server.URL + path + "?" + values.Encode())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := struct {
+		Data  interface{} `json:"data"`
+		Error *v2Error    `json:"error"`
+	}{Data: data}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("%s %s returned invalid JSON: %v", method, path, err)
+	}
+	return resp, body.Error
+}
+
+func TestV2Scan(t *testing.T) {
+	defer teardown()
+	var scan ScanV2
+	resp, apiErr := v2Request(t, http.MethodPost, "/api/v2/scan", url.Values{"domain": {"eff.org"}}, &scan)
+	if resp.StatusCode != http.StatusOK || apiErr != nil {
+		t.Fatalf("POST to /api/v2/scan failed with %d: %+v", resp.StatusCode, apiErr)
+	}
+	if scan.Domain != "eff.org" || scan.Status != "success" || scan.ScannedAt.IsZero() || scan.Checker == nil {
+		t.Errorf("Expected a successful scan of eff.org, got %+v", scan)
+	}
+	if resp.Header.Get("Deprecation") != "" {
+		t.Errorf("Expected v2 not to be deprecated, got %q", resp.Header.Get("Deprecation"))
+	}
+
+	resp, apiErr = v2Request(t, http.MethodGet, "/api/v2/scan", url.Values{"domain": {"unscanned.example"}}, nil)
+	if resp.StatusCode != http.StatusNotFound || apiErr == nil || apiErr.Code != errNotFound {
+		t.Errorf("Expected a not_found error, got %d: %+v", resp.StatusCode, apiErr)
+	}
+	resp, apiErr = v2Request(t, http.MethodGet, "/api/v2/nothing", nil, nil)
+	if resp.StatusCode != http.StatusNotFound || apiErr == nil || apiErr.Code != errNotFound {
+		t.Errorf("Expected unknown v2 paths to be not_found, got %d: %+v", resp.StatusCode, apiErr)
+	}
+
+	resp, _ = http.Get(server.URL + "/api/scan?domain=eff.org")
+	if resp.Header.Get("Deprecation") == "" || resp.Header.Get("Link") == "" {
+		t.Errorf("Expected v1 scans to be deprecated, got %v", resp.Header)
+	}
+}
+
+func TestV2Queue(t *testing.T) {
+	defer teardown()
+	data := validQueueData(true)
+	var submission SubmissionV2
+	resp, apiErr := v2Request(t, http.MethodPost, "/api/v2/queue", data, &submission)
+	if resp.StatusCode != http.StatusOK || apiErr != nil {
+		t.Fatalf("POST to /api/v2/queue failed with %d: %+v", resp.StatusCode, apiErr)
+	}
+	if submission.Domain != "example.com" || submission.State != "unvalidated" ||
+		submission.ValidationAddress != "postmaster@example.com" || submission.TokenExpires == nil {
+		t.Errorf("Expected a submission awaiting validation, got %+v", submission)
+	}
+
+	var domain DomainV2
+	resp, apiErr = v2Request(t, http.MethodGet, "/api/v2/queue", url.Values{"domain": {"example.com"}}, &domain)
+	if resp.StatusCode != http.StatusOK || domain.State != "unvalidated" || len(domain.MXs) != 1 {
+		t.Errorf("Expected the queued domain, got %d: %+v, %+v", resp.StatusCode, domain, apiErr)
+	}
+
+	resp, apiErr = v2Request(t, http.MethodPost, "/api/v2/queue", url.Values{"domain": {"example.com"}}, nil)
+	if resp.StatusCode != http.StatusBadRequest || apiErr == nil || apiErr.Code != errInvalidRequest || apiErr.Message == "" {
+		t.Errorf("Expected an invalid_request error, got %d: %+v", resp.StatusCode, apiErr)
+	}
+	data.Set("domain", "unscanned.example")
+	data.Set("hostnames", "mx.unscanned.example")
+	resp, apiErr = v2Request(t, http.MethodPost, "/api/v2/queue", data, nil)
+	if resp.StatusCode != http.StatusBadRequest || apiErr == nil || apiErr.Code != errNotQueueable {
+		t.Errorf("Expected a not_queueable error, got %d: %+v", resp.StatusCode, apiErr)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		resp response
+		code string
+	}{
+		{response{StatusCode: http.StatusOK}, ""},
+		{response{StatusCode: http.StatusAccepted}, ""},
+		{badRequest("bad"), errInvalidRequest},
+		{serverError("broken"), errInternal},
+		{response{StatusCode: http.StatusTooManyRequests}, errRateLimited},
+		{response{StatusCode: http.StatusTooManyRequests, code: errQuotaExceeded}, errQuotaExceeded},
+		{response{StatusCode: http.StatusGone}, errInvalidRequest},
+		{response{StatusCode: http.StatusBadGateway}, errInternal},
+	}
+	for _, test := range tests {
+		if code := test.resp.errorCode(); code != test.code {
+			t.Errorf("Expected %d to have error code %q, got %q", test.resp.StatusCode, test.code, code)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// watchPollInterval is how often a watch checks whether a domain's state has
+// changed. State changes can come from other processes, like the promoter,
+// so we poll the database rather than waiting on in-process events.
+var watchPollInterval = 2 * time.Second
+
+// Bounds, in seconds, on how long a watch waits for a state change.
+const (
+	defaultWatchTimeout = 30
+	maxWatchTimeout     = 60
+)
+
+// domainStatus is a domain's queue state, as reported to watchers.
+type domainStatus struct {
+	Domain      string             `json:"domain"`
+	State       models.DomainState `json:"state"`
+	LastUpdated time.Time          `json:"last_updated,omitempty"`
+	// Changed is false if the watch timed out before the state changed.
+	Changed bool `json:"changed"`
+}
+
+func (api API) domainStatus(name string) domainStatus {
+	domain, err := models.GetDomain(api.Database, name)
+	if err != nil {
+		return domainStatus{Domain: name, State: models.StateUnknown}
+	}
+	return domainStatus{Domain: name, State: domain.State, LastUpdated: domain.LastUpdated}
+}
+
+// Watch handles requests to /api/queue/watch
+//   GET /api/queue/watch?domain=<domain>&state=<state>
+//        domain: Domain to watch the queue state of.
+//        state (optional): The state the client last saw, eg. "unvalidated".
+//          If the domain is in a different state, we respond immediately.
+//          Otherwise we wait for it to change: when its email is validated,
+//          it's queued, it's promoted to the list, and so on.
+//        timeout (optional, default 30): Seconds to wait for a change, up
+//          to 60. Watch again after a response with "changed": false.
+//        Sets the domain's current domainStatus as response.
+func (api API) watch(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/queue/watch only accepts GET requests"}
+	}
+	name, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	timeout, err := getInt("timeout", r, 0, maxWatchTimeout+1, defaultWatchTimeout)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	last := models.DomainState(r.FormValue("state"))
+	header := http.Header{"Cache-Control": []string{"no-store"}}
+	deadline := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer deadline.Stop()
+	poll := time.NewTicker(watchPollInterval)
+	defer poll.Stop()
+	for {
+		status := api.domainStatus(name)
+		if last == "" || status.State != last {
+			status.Changed = true
+			return response{StatusCode: http.StatusOK, Response: status, header: header}
+		}
+		select {
+		case <-poll.C:
+		case <-deadline.C:
+			return response{StatusCode: http.StatusOK, Response: status, header: header}
+		case <-r.Context().Done():
+			return response{StatusCode: http.StatusOK, Response: status, header: header}
+		}
+	}
+}
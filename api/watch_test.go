@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+func getWatch(t *testing.T, query string) domainStatus {
+	resp, err := http.Get(server.URL + "/api/queue/watch?" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected watch to succeed, got %d", resp.StatusCode)
+	}
+	status := domainStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(&response{Response: &status}); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestWatchReturnsNewState(t *testing.T) {
+	defer teardown()
+	status := getWatch(t, "domain=example.com&state=unvalidated")
+	if !status.Changed || status.State != models.StateUnknown {
+		t.Errorf("Expected unknown domain's state to differ from unvalidated, got %+v", status)
+	}
+}
+
+func TestWatchTimesOut(t *testing.T) {
+	defer teardown()
+	status := getWatch(t, "domain=example.com&state=unknown&timeout=0")
+	if status.Changed || status.State != models.StateUnknown {
+		t.Errorf("Expected watch to time out without a change, got %+v", status)
+	}
+}
+
+func TestWatchValidation(t *testing.T) {
+	defer teardown()
+	watchPollInterval = 10 * time.Millisecond
+	defer func() { watchPollInterval = 2 * time.Second }()
+
+	http.PostForm(server.URL+"/api/queue", validQueueData(true))
+	token, err := api.Database.GetTokenByDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := make(chan domainStatus)
+	go func() { updates <- getWatch(t, "domain=example.com&state=unvalidated&timeout=5") }()
+	time.Sleep(50 * time.Millisecond)
+	http.PostForm(server.URL+"/api/validate", url.Values{"token": {token}})
+
+	status := <-updates
+	if !status.Changed || status.State != models.StateTesting {
+		t.Errorf("Expected watch to report the domain was queued, got %+v", status)
+	}
+}
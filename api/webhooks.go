@@ -0,0 +1,205 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/webhooks"
+)
+
+// Bounds on how many of a webhook's deliveries are listed.
+const (
+	defaultDeliveryLogSize = 50
+	maxDeliveryLogSize     = 200
+)
+
+// webhookManager authorizes a request to manage a domain's webhooks, and
+// returns who is managing them, for the audit log, or a response refusing
+// the request.
+type webhookManager func(domain string) (string, *response)
+
+type webhookHandler func(r *http.Request, manage webhookManager) response
+
+// byContact serves a webhook handler to domains' contacts, who may manage
+// their own domains' webhooks with an API key with the "queue" scope issued
+// to the domain's contact address.
+func (api API) byContact(handler webhookHandler) apiHandler {
+	return api.withAPIKey(models.ScopeQueue, func(r *http.Request, key models.APIKey) response {
+		return handler(r, func(name string) (string, *response) {
+			domain, err := models.GetDomain(api.Database, name)
+			if err != nil && err != sql.ErrNoRows {
+				refused := serverError(err.Error())
+				return "", &refused
+			}
+			if err != nil || domain.Email == "" || !strings.EqualFold(domain.Email, key.Email) {
+				return "", &response{StatusCode: http.StatusForbidden,
+					Message: fmt.Sprintf("only the contact of %s can manage its webhooks", name)}
+			}
+			return key.Actor(), nil
+		})
+	})
+}
+
+// byMaintainer serves a webhook handler to maintainers, who may manage any
+// submitted domain's webhooks.
+func (api API) byMaintainer(handler webhookHandler) apiHandler {
+	return api.adminOnly(func(r *http.Request) response {
+		return handler(r, func(name string) (string, *response) {
+			// Only changes need an actor for the audit log.
+			actor, err := getActor(r)
+			if err != nil && r.Method != http.MethodGet {
+				refused := badRequest(err.Error())
+				return "", &refused
+			}
+			if _, err = models.GetDomain(api.Database, name); err == sql.ErrNoRows {
+				return "", &response{StatusCode: http.StatusNotFound,
+					Message: fmt.Sprintf("%s hasn't been submitted", name)}
+			} else if err != nil {
+				refused := serverError(err.Error())
+				return "", &refused
+			}
+			return actor, nil
+		})
+	})
+}
+
+// Webhooks handles requests to /api/webhooks, authenticated with a key with
+// the "queue" scope issued to the domain's contact, and /admin/webhooks.
+//   GET /api/webhooks?domain=<domain>
+//        Sets the domain's models.Webhooks, without their secrets, as
+//        response.
+//   POST /api/webhooks
+//        domain: Submitted domain to notify url of the state changes of.
+//        url: HTTPS URL of a public host to POST webhooks.Events to.
+//        actor (/admin/webhooks only): Who registered it, for the audit log.
+//        Sets the new models.Webhook as response, including the secret its
+//        deliveries are signed with, which isn't shown again.
+func (api API) webhooks(r *http.Request, manage webhookManager) response {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/webhooks only accepts GET and POST requests"}
+	}
+	name, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	owner, refused := manage(name)
+	if refused != nil {
+		return *refused
+	}
+	hooks, err := api.Database.GetWebhooks(name)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	if r.Method == http.MethodGet {
+		return response{StatusCode: http.StatusOK, Response: hooks}
+	}
+	url, err := webhooks.ValidateURL(r.FormValue("url"))
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	for _, hook := range hooks {
+		if hook.URL == url {
+			return response{StatusCode: http.StatusConflict,
+				Message: fmt.Sprintf("%s is already registered for %s", url, name)}
+		}
+	}
+	if len(hooks) >= models.MaxWebhooksPerDomain {
+		return response{StatusCode: http.StatusConflict,
+			Message: fmt.Sprintf("%s already has %d webhooks; remove one first", name, len(hooks))}
+	}
+	hook, err := api.Database.PutWebhook(models.Webhook{Domain: name, URL: url, Owner: owner,
+		Secret: models.NewWebhookSecret()})
+	if err != nil {
+		return serverError(err.Error())
+	}
+	api.audit(models.AuditEntry{Actor: owner, Action: "webhook.register", Subject: name,
+		Details: fmt.Sprintf("webhook %d to %s", hook.ID, url)})
+	return response{StatusCode: http.StatusOK, Response: hook}
+}
+
+// getManagedWebhook returns the webhook whose ID is the id parameter, and who
+// is managing it.
+func (api API) getManagedWebhook(r *http.Request, manage webhookManager) (models.Webhook, string, *response) {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return models.Webhook{}, "", &response{StatusCode: http.StatusBadRequest,
+			Message: "query parameter id must be a webhook's ID"}
+	}
+	hook, err := api.Database.GetWebhook(id)
+	if err == sql.ErrNoRows {
+		return hook, "", &response{StatusCode: http.StatusNotFound, Message: "no such webhook"}
+	}
+	if err != nil {
+		refused := serverError(err.Error())
+		return hook, "", &refused
+	}
+	hook.Secret = ""
+	owner, refused := manage(hook.Domain)
+	return hook, owner, refused
+}
+
+// RemoveWebhook handles requests to /api/webhooks/remove, authenticated like
+// /api/webhooks, and /admin/webhooks/remove.
+//   POST /api/webhooks/remove
+//        id: ID of the webhook to remove. Its undelivered deliveries are
+//          dropped.
+//        actor (/admin/webhooks/remove only): Who removed it.
+//        Sets the removed models.Webhook as response.
+func (api API) removeWebhook(r *http.Request, manage webhookManager) response {
+	if r.Method != http.MethodPost {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/webhooks/remove only accepts POST requests"}
+	}
+	hook, owner, refused := api.getManagedWebhook(r, manage)
+	if refused != nil {
+		return *refused
+	}
+	removed, err := api.Database.RemoveWebhook(hook.ID)
+	if err == sql.ErrNoRows {
+		return response{StatusCode: http.StatusNotFound, Message: "no such webhook"}
+	}
+	if err != nil {
+		return serverError(err.Error())
+	}
+	api.audit(models.AuditEntry{Actor: owner, Action: "webhook.remove", Subject: hook.Domain,
+		Details: fmt.Sprintf("webhook %d to %s", hook.ID, hook.URL)})
+	return response{StatusCode: http.StatusOK, Response: removed}
+}
+
+// webhookDeliveryLog is a webhook and its most recent deliveries.
+type webhookDeliveryLog struct {
+	Webhook    models.Webhook           `json:"webhook"`
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookDeliveries handles requests to /api/webhooks/deliveries,
+// authenticated like /api/webhooks, and /admin/webhooks/deliveries.
+//   GET /api/webhooks/deliveries?id=<id>
+//        limit (optional, default 50): How many deliveries to list, up to
+//          200.
+//        Sets the webhook and its most recent models.WebhookDeliveries, with
+//        the outcome of the latest attempt at each, as response.
+func (api API) webhookDeliveries(r *http.Request, manage webhookManager) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/webhooks/deliveries only accepts GET requests"}
+	}
+	limit, err := getInt("limit", r, 1, maxDeliveryLogSize+1, defaultDeliveryLogSize)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	hook, _, refused := api.getManagedWebhook(r, manage)
+	if refused != nil {
+		return *refused
+	}
+	deliveries, err := api.Database.GetWebhookDeliveries(hook.ID, limit)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	return response{StatusCode: http.StatusOK, Response: webhookDeliveryLog{Webhook: hook, Deliveries: deliveries}}
+}
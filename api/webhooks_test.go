@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// webhookRequest makes a request authenticated with key, and decodes its
+// response into response.
+func webhookRequest(t *testing.T, method string, path string, key string, data url.Values, response interface{}) *http.Response {
+	req, err := http.NewRequest(method, server.URL+path+"?"+data.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := struct {
+		Response interface{} `json:"response"`
+	}{Response: response}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp
+}
+
+func TestWebhooks(t *testing.T) {
+	defer teardown()
+	os.Setenv("ADMIN_KEY", "secret")
+	defer os.Unsetenv("ADMIN_KEY")
+	key := registerTestKey(t, "queue")
+	data := validQueueData(true)
+	data.Set("email", key.Email)
+	http.PostForm(server.URL+"/api/queue", data)
+
+	var hook models.Webhook
+	register := url.Values{"domain": {"example.com"}, "url": {"https://hooks.example.com/starttls"}}
+	resp := webhookRequest(t, "POST", "/api/webhooks", key.Key, register, &hook)
+	if resp.StatusCode != http.StatusOK || hook.ID == 0 || !strings.HasPrefix(hook.Secret, "whsec_") ||
+		hook.Owner != key.Actor() {
+		t.Fatalf("Expected the webhook to be registered, got %d: %+v", resp.StatusCode, hook)
+	}
+	if resp = webhookRequest(t, "POST", "/api/webhooks", key.Key, register, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected registering the same URL again to conflict, got %d", resp.StatusCode)
+	}
+	insecure := url.Values{"domain": {"example.com"}, "url": {"http://hooks.example.com/"}}
+	if resp = webhookRequest(t, "POST", "/api/webhooks", key.Key, insecure, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a plain HTTP URL to be rejected, got %d", resp.StatusCode)
+	}
+	internal := url.Values{"domain": {"example.com"}, "url": {"https://127.0.0.1/"}}
+	if resp = webhookRequest(t, "POST", "/api/webhooks", key.Key, internal, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a loopback URL to be rejected, got %d", resp.StatusCode)
+	}
+	other := url.Values{"domain": {"eff.org"}, "url": {"https://hooks.example.com/"}}
+	if resp = webhookRequest(t, "POST", "/api/webhooks", key.Key, other, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected registering a webhook for someone else's domain to be refused, got %d", resp.StatusCode)
+	}
+
+	var hooks []models.Webhook
+	resp = webhookRequest(t, "GET", "/admin/webhooks", "secret", url.Values{"domain": {"example.com"}}, &hooks)
+	if resp.StatusCode != http.StatusOK || len(hooks) != 1 || hooks[0].ID != hook.ID || hooks[0].Secret != "" {
+		t.Errorf("Expected maintainers to see the webhook without its secret, got %d: %+v", resp.StatusCode, hooks)
+	}
+
+	token, err := api.Database.GetTokenByDomain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	http.PostForm(server.URL+"/api/validate", url.Values{"token": {token}})
+	var log webhookDeliveryLog
+	resp = webhookRequest(t, "GET", "/api/webhooks/deliveries", key.Key, url.Values{"id": {formatID(hook.ID)}}, &log)
+	if resp.StatusCode != http.StatusOK || len(log.Deliveries) != 1 {
+		t.Fatalf("Expected validating the domain to queue a delivery, got %d: %+v", resp.StatusCode, log)
+	}
+	delivery := log.Deliveries[0]
+	if delivery.From != models.StateUnconfirmed || delivery.To != models.StateTesting ||
+		delivery.Status != models.DeliveryPending || log.Webhook.Secret != "" {
+		t.Errorf("Expected a pending delivery of the domain's validation, got %+v", log)
+	}
+
+	remove := url.Values{"id": {formatID(hook.ID)}}
+	if resp = webhookRequest(t, "POST", "/admin/webhooks/remove", "secret", remove, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected removing a webhook without an actor to be refused, got %d", resp.StatusCode)
+	}
+	remove.Set("actor", "maintainer")
+	if resp = webhookRequest(t, "POST", "/admin/webhooks/remove", "secret", remove, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected maintainers to remove the webhook, got %d", resp.StatusCode)
+	}
+	resp = webhookRequest(t, "GET", "/api/webhooks/deliveries", key.Key, url.Values{"id": {formatID(hook.ID)}}, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the removed webhook to be gone, got %d", resp.StatusCode)
+	}
+}
+
+func TestWebhooksRequireQueueScope(t *testing.T) {
+	defer teardown()
+	key := registerTestKey(t, "scan")
+	resp := webhookRequest(t, "GET", "/api/webhooks", key.Key, url.Values{"domain": {"example.com"}}, nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a key without the queue scope to be refused, got %d", resp.StatusCode)
+	}
+}
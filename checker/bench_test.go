@@ -0,0 +1,143 @@
+package checker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Benchmarks check representative domains on the fake network, so results
+// only depend on the checker and the machine running it. Besides time and
+// allocations, they report the checks per second and the SMTP connections,
+// DNS queries and MTA-STS policy fetches each check makes. Run them with:
+//   go test -run XXX -bench CheckDomain ./checker
+
+// benchmarkShapes are domains that exercise different paths through a check,
+// from a fully secure domain to ones that fail partway through.
+var benchmarkShapes = []struct {
+	name   string
+	domain string
+	// cached checks the domain with a warm hostname cache.
+	cached bool
+}{
+	{"secure", "example.com", false},
+	{"mtasts_testing", "testing.example.com", false},
+	{"no_mtasts", "nomtasts.example.com", false},
+	{"no_starttls", "nostarttls.example.com", false},
+	{"bad_cert", "badcert.example.com", false},
+	{"greylisted", "greylist.example.com", false},
+	{"no_connection", "noconnection.example.com", false},
+	{"no_mx", "nomx.example.com", false},
+	{"cached", "example.com", true},
+}
+
+// Custom benchmark metrics.
+const (
+	scansPerSecond       = "scans/s"
+	smtpConnectionsPerOp = "smtp-conns/op"
+	dnsQueriesPerOp      = "dns-queries/op"
+	policyFetchesPerOp   = "policy-fetches/op"
+)
+
+func benchmarkCheckDomain(b *testing.B, domain string, cached bool) {
+	cfg := DefaultConfig()
+	cfg.FakeNetwork = true
+	Configure(cfg)
+	defer Configure(DefaultConfig())
+	fake := fakeNetworkInUse()
+
+	c := Checker{Timeout: 5 * time.Second}
+	if cached {
+		c.Cache = MakeBoundedCache(time.Hour, 100)
+		c.CheckDomain(domain, nil)
+	}
+	before := fake.counts.snapshot()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		c.CheckDomain(domain, nil)
+	}
+	elapsed := time.Since(start)
+	b.StopTimer()
+	after := fake.counts.snapshot()
+	n := float64(b.N)
+	b.ReportMetric(n/elapsed.Seconds(), scansPerSecond)
+	b.ReportMetric(float64(after.SMTPConnections-before.SMTPConnections)/n, smtpConnectionsPerOp)
+	b.ReportMetric(float64(after.DNSQueries-before.DNSQueries)/n, dnsQueriesPerOp)
+	b.ReportMetric(float64(after.PolicyFetches-before.PolicyFetches)/n, policyFetchesPerOp)
+}
+
+func BenchmarkCheckDomain(b *testing.B) {
+	for _, shape := range benchmarkShapes {
+		shape := shape
+		b.Run(shape.name, func(b *testing.B) {
+			benchmarkCheckDomain(b, shape.domain, shape.cached)
+		})
+	}
+}
+
+// benchmarkBudget bounds a benchmark's results. Allocations and connection
+// counts are nearly the same on every machine, so their budgets are tight;
+// MinScansPerSecond is only meant to catch checks becoming many times slower.
+type benchmarkBudget struct {
+	MaxAllocsPerOp          int64   `json:"max_allocs_per_op"`
+	MaxSMTPConnectionsPerOp float64 `json:"max_smtp_conns_per_op"`
+	MaxDNSQueriesPerOp      float64 `json:"max_dns_queries_per_op"`
+	MaxPolicyFetchesPerOp   float64 `json:"max_policy_fetches_per_op"`
+	MinScansPerSecond       float64 `json:"min_scans_per_second"`
+}
+
+// benchmarkBudgetsPath holds the budget for each benchmark shape.
+var benchmarkBudgetsPath = filepath.Join("testdata", "benchmark_budgets.json")
+
+// TestBenchmarkBudgets fails if any benchmark shape goes over its budget. It
+// takes a few seconds per shape, so it only runs with CHECKER_BENCH_GATE set,
+// as it is before each release:
+//   CHECKER_BENCH_GATE=1 go test -run BenchmarkBudgets ./checker
+func TestBenchmarkBudgets(t *testing.T) {
+	if os.Getenv("CHECKER_BENCH_GATE") == "" {
+		t.Skip("set CHECKER_BENCH_GATE to check benchmark budgets")
+	}
+	data, err := ioutil.ReadFile(benchmarkBudgetsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var budgets map[string]benchmarkBudget
+	if err = json.Unmarshal(data, &budgets); err != nil {
+		t.Fatal(err)
+	}
+	for _, shape := range benchmarkShapes {
+		budget, ok := budgets[shape.name]
+		if !ok {
+			t.Errorf("%s has no budget in %s", shape.name, benchmarkBudgetsPath)
+			continue
+		}
+		r := testing.Benchmark(func(b *testing.B) {
+			benchmarkCheckDomain(b, shape.domain, shape.cached)
+		})
+		t.Logf("%s: %s %s", shape.name, r, r.MemString())
+		if r.AllocsPerOp() > budget.MaxAllocsPerOp {
+			t.Errorf("%s: %d allocs/op, budget is %d", shape.name, r.AllocsPerOp(), budget.MaxAllocsPerOp)
+		}
+		for _, metric := range []struct {
+			name string
+			max  float64
+		}{
+			{smtpConnectionsPerOp, budget.MaxSMTPConnectionsPerOp},
+			{dnsQueriesPerOp, budget.MaxDNSQueriesPerOp},
+			{policyFetchesPerOp, budget.MaxPolicyFetchesPerOp},
+		} {
+			if r.Extra[metric.name] > metric.max {
+				t.Errorf("%s: %.2f %s, budget is %.2f", shape.name, r.Extra[metric.name], metric.name, metric.max)
+			}
+		}
+		if r.Extra[scansPerSecond] < budget.MinScansPerSecond {
+			t.Errorf("%s: %.1f %s, budget is at least %.1f", shape.name, r.Extra[scansPerSecond],
+				scansPerSecond, budget.MinScansPerSecond)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const bigQueryEndpoint = "https://bigquery.googleapis.com/bigquery/v2"
+
+// BigQuerySink exports results to BigQuery with streaming inserts.
+type BigQuerySink struct {
+	Project string
+	Dataset string
+	Table   string
+	// AccessToken is an OAuth2 access token with the bigquery scope, eg.
+	// from `gcloud auth print-access-token`.
+	AccessToken string
+	// Endpoint overrides the BigQuery API root URL.
+	Endpoint string
+}
+
+type bigQueryField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+}
+
+var bigQuerySchema = []bigQueryField{
+	{"domain", "STRING", "REQUIRED"},
+	{"source", "STRING", "NULLABLE"},
+	{"timestamp", "TIMESTAMP", "REQUIRED"},
+	{"status", "INTEGER", "REQUIRED"},
+	{"mx_hostnames", "STRING", "REPEATED"},
+	{"preferred_hostnames", "STRING", "REPEATED"},
+	{"mta_sts_mode", "STRING", "NULLABLE"},
+	{"result", "STRING", "NULLABLE"},
+}
+
+func (s *BigQuerySink) tablesURL() string {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = bigQueryEndpoint
+	}
+	return fmt.Sprintf("%s/projects/%s/datasets/%s/tables", endpoint, s.Project, s.Dataset)
+}
+
+// EnsureTable creates the results table, partitioned by day, if it doesn't
+// exist.
+func (s *BigQuerySink) EnsureTable() error {
+	if !sinkTableName.MatchString(s.Table) || strings.Contains(s.Table, ".") {
+		return fmt.Errorf("invalid BigQuery table name %q", s.Table)
+	}
+	resp, err := s.do("GET", s.tablesURL()+"/"+s.Table, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("BigQuery returned %s looking up table %s", resp.Status, s.Table)
+	}
+	table := map[string]interface{}{
+		"tableReference": map[string]string{
+			"projectId": s.Project,
+			"datasetId": s.Dataset,
+			"tableId":   s.Table,
+		},
+		"schema":           map[string]interface{}{"fields": bigQuerySchema},
+		"timePartitioning": map[string]string{"type": "DAY", "field": "timestamp"},
+	}
+	return s.post(s.tablesURL(), table, nil)
+}
+
+// Insert streams rows into the table.
+func (s *BigQuerySink) Insert(rows []SinkRow) error {
+	type insertRow struct {
+		InsertID string  `json:"insertId"`
+		JSON     SinkRow `json:"json"`
+	}
+	request := struct {
+		Rows []insertRow `json:"rows"`
+	}{}
+	for _, row := range rows {
+		// The insert ID lets BigQuery de-duplicate retried inserts.
+		id := fmt.Sprintf("%s-%d", row.Domain, row.Timestamp.UnixNano())
+		request.Rows = append(request.Rows, insertRow{InsertID: id, JSON: row})
+	}
+	var response struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	if err := s.post(s.tablesURL()+"/"+s.Table+"/insertAll", request, &response); err != nil {
+		return err
+	}
+	if len(response.InsertErrors) > 0 {
+		first := response.InsertErrors[0]
+		message := ""
+		if len(first.Errors) > 0 {
+			message = first.Errors[0].Message
+		}
+		return fmt.Errorf("BigQuery rejected %d rows, eg. row %d: %s",
+			len(response.InsertErrors), first.Index, message)
+	}
+	return nil
+}
+
+func (s *BigQuerySink) post(url string, body interface{}, response interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("BigQuery returned %s: %s", resp.Status, message)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+func (s *BigQuerySink) do(method string, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	return sinkHTTPClient.Do(req)
+}
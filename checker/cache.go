@@ -1,7 +1,11 @@
 package checker
 
 import (
+	"container/list"
+	"context"
 	"fmt"
+	"hash/fnv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,6 +21,178 @@ type ScanStore interface {
 type ScanCache struct {
 	ScanStore
 	ExpireTime time.Duration
+	// StaleWhileRevalidate optionally lets Checkers use a scan for this long
+	// after it expires, marked as Stale, while a fresh scan runs in the
+	// background. If 0, expired scans are never used.
+	StaleWhileRevalidate time.Duration
+	// Jitter optionally expires each scan up to this much before ExpireTime,
+	// so scans of popular hostnames made around the same time don't all
+	// expire, and get re-checked, at once. A scan's jitter is derived from
+	// its hostname and timestamp, so it's the same each time it's read.
+	Jitter time.Duration
+	// MaxRefreshes caps the stale scans re-checked in the background at
+	// once. Stale scans found while it's reached are used without being
+	// re-checked. Defaults to defaultMaxRefreshes.
+	MaxRefreshes int
+
+	mu       sync.Mutex
+	inflight map[string]*inflightCheck
+	// refreshing counts the background refreshes running.
+	refreshing int
+}
+
+// CacheOptions override a ScanCache's freshness windows for one Checker's
+// lookups, so that Checkers sharing a cache can each trade freshness for
+// speed: interactive scans can use stale results rather than wait on slow
+// mailservers, while others insist on fresh ones.
+type CacheOptions struct {
+	// MaxAge, if set, is how long scans are fresh for, instead of the
+	// cache's ExpireTime. Jitter still applies.
+	MaxAge time.Duration
+	// StaleWhileRevalidate, if set, is how long expired scans may be used
+	// while they're re-checked, instead of the cache's.
+	StaleWhileRevalidate time.Duration
+	// NoStale never uses expired scans, whatever the cache's
+	// StaleWhileRevalidate.
+	NoStale bool
+}
+
+// defaultMaxRefreshes is how many background refreshes a ScanCache runs at
+// once by default.
+const defaultMaxRefreshes = 32
+
+func (c *ScanCache) maxRefreshes() int {
+	if c.MaxRefreshes > 0 {
+		return c.MaxRefreshes
+	}
+	return defaultMaxRefreshes
+}
+
+// inflightCheck is a check of a hostname that's running, which other callers
+// needing the hostname's result wait for instead of checking it again.
+type inflightCheck struct {
+	done   chan struct{}
+	result HostnameResult
+}
+
+// getHostnameScan is like GetHostnameScan, but also returns expired scans
+// within the StaleWhileRevalidate window, with stale set. opts override the
+// cache's windows.
+func (c *ScanCache) getHostnameScan(hostname string, opts CacheOptions) (result HostnameResult, stale bool, err error) {
+	result, err = c.ScanStore.GetHostnameScan(hostname)
+	if err != nil {
+		return result, false, err
+	}
+	maxAge, staleWhileRevalidate := c.ExpireTime, c.StaleWhileRevalidate
+	if opts.MaxAge > 0 {
+		maxAge = opts.MaxAge
+	}
+	if opts.StaleWhileRevalidate > 0 {
+		staleWhileRevalidate = opts.StaleWhileRevalidate
+	}
+	if opts.NoStale {
+		staleWhileRevalidate = 0
+	}
+	age := time.Now().Sub(result.Timestamp)
+	expireTime := c.expireTime(hostname, result.Timestamp, maxAge)
+	if age <= expireTime {
+		return result, false, nil
+	}
+	if age <= expireTime+staleWhileRevalidate {
+		return result, true, nil
+	}
+	return result, false, fmt.Errorf("most recent scan for %s expired", hostname)
+}
+
+// expireTime returns how long the scan of hostname made at timestamp is
+// fresh for: maxAge, less up to Jitter.
+func (c *ScanCache) expireTime(hostname string, timestamp time.Time, maxAge time.Duration) time.Duration {
+	if c.Jitter <= 0 {
+		return maxAge
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s %d", hostname, timestamp.UnixNano())
+	return maxAge - time.Duration(h.Sum64()%uint64(c.Jitter+1))
+}
+
+// join returns the running check of hostname, or starts tracking a new one
+// if none is running, in which case leader is true and the caller must run
+// it with c.run.
+func (c *ScanCache) join(hostname string) (call *inflightCheck, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inflight == nil {
+		c.inflight = make(map[string]*inflightCheck)
+	}
+	if call, ok := c.inflight[hostname]; ok {
+		return call, false
+	}
+	call = &inflightCheck{done: make(chan struct{})}
+	c.inflight[hostname] = call
+	return call, true
+}
+
+// run performs check as the call for hostname, and hands its result to the
+// callers waiting for it.
+func (c *ScanCache) run(hostname string, call *inflightCheck, check func() HostnameResult) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, hostname)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	call.result = check()
+}
+
+// check returns the result of check for hostname. If hostname is already
+// being checked, by another caller or a refresh, it waits for and returns
+// that check's result instead, with shared set, so concurrent scans that
+// need the same hostname only check it once. It stops waiting, and returns
+// ctx's error, if ctx is done first. check is expected to store its result.
+func (c *ScanCache) check(ctx context.Context, hostname string, check func() HostnameResult) (result HostnameResult, shared bool, err error) {
+	call, leader := c.join(hostname)
+	if !leader {
+		select {
+		case <-call.done:
+			return call.result, true, nil
+		case <-ctx.Done():
+			return HostnameResult{}, true, ctx.Err()
+		}
+	}
+	c.run(hostname, call, check)
+	return call.result, false, nil
+}
+
+// refresh stores the result of check for hostname in the background, unless
+// hostname is already being checked, or MaxRefreshes are already running.
+// Returns whether a refresh was started.
+func (c *ScanCache) refresh(hostname string, check func() HostnameResult) bool {
+	c.mu.Lock()
+	if c.refreshing >= c.maxRefreshes() {
+		c.mu.Unlock()
+		return false
+	}
+	c.refreshing++
+	c.mu.Unlock()
+	release := func() {
+		c.mu.Lock()
+		c.refreshing--
+		c.mu.Unlock()
+	}
+	call, leader := c.join(hostname)
+	if !leader {
+		release()
+		return false
+	}
+	go func() {
+		defer release()
+		c.run(hostname, call, func() HostnameResult {
+			result := check()
+			c.PutHostnameScan(hostname, result)
+			return result
+		})
+	}()
+	return true
 }
 
 // GetHostnameScan retrieves the scan from underlying storage if there is one
@@ -26,7 +202,7 @@ func (c *ScanCache) GetHostnameScan(hostname string) (HostnameResult, error) {
 	if err != nil {
 		return result, err
 	}
-	if time.Now().Sub(result.Timestamp) > c.ExpireTime {
+	if time.Now().Sub(result.Timestamp) > c.expireTime(hostname, result.Timestamp, c.ExpireTime) {
 		return result, fmt.Errorf("most recent scan for %s expired", hostname)
 	}
 	return result, nil
@@ -37,33 +213,234 @@ func (c *ScanCache) PutHostnameScan(hostname string, result HostnameResult) erro
 	return c.ScanStore.PutHostnameScan(hostname, result)
 }
 
-// SimpleStore is simple HostnameResult storage backed by map.
+// defaultCacheMaxEntries bounds the hostname results cached in memory.
+const defaultCacheMaxEntries = 100000
+
+// SimpleStore is simple HostnameResult storage backed by map. If MaxEntries
+// is set, it holds at most that many results, evicting the least recently
+// used ones first.
 type SimpleStore struct {
-	m  map[string]HostnameResult
-	mu sync.RWMutex
+	// MaxEntries is the most results stored at once. 0 for no limit.
+	MaxEntries int
+
+	m         map[string]*list.Element
+	lru       *list.List
+	evictions int
+	mu        sync.Mutex
+}
+
+// SimpleStoreStats describes a SimpleStore's use.
+type SimpleStoreStats struct {
+	Entries   int `json:"entries"`
+	Evictions int `json:"evictions"`
+}
+
+type simpleStoreEntry struct {
+	hostname string
+	result   HostnameResult
+}
+
+// NewSimpleStore creates a SimpleStore holding at most maxEntries results,
+// or any number if maxEntries is 0.
+func NewSimpleStore(maxEntries int) *SimpleStore {
+	return &SimpleStore{MaxEntries: maxEntries}
 }
 
 // GetHostnameScan wraps a map get. Returns error if not present in map.
 func (s *SimpleStore) GetHostnameScan(hostname string) (HostnameResult, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	result, ok := s.m[hostname]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.m[hostname]
 	if !ok {
-		return result, fmt.Errorf("Couldn't find scan for hostname %s", hostname)
+		return HostnameResult{}, fmt.Errorf("Couldn't find scan for hostname %s", hostname)
 	}
-	return result, nil
+	s.lru.MoveToFront(element)
+	return element.Value.(*simpleStoreEntry).result, nil
 }
 
-// PutHostnameScan wraps a map set. Can never return error.
+// PutHostnameScan wraps a map set, evicting the least recently used result
+// if the store is full. Can never return error.
 func (s *SimpleStore) PutHostnameScan(hostname string, result HostnameResult) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.m[hostname] = result
+	if s.m == nil {
+		s.m = make(map[string]*list.Element)
+		s.lru = list.New()
+	}
+	if element, ok := s.m[hostname]; ok {
+		element.Value.(*simpleStoreEntry).result = result
+		s.lru.MoveToFront(element)
+		return nil
+	}
+	s.m[hostname] = s.lru.PushFront(&simpleStoreEntry{hostname: hostname, result: result})
+	for s.MaxEntries > 0 && s.lru.Len() > s.MaxEntries {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.m, oldest.Value.(*simpleStoreEntry).hostname)
+		s.evictions++
+		cacheEvictions.Inc()
+	}
 	return nil
 }
 
+// Stats returns the number of results stored, and evicted so far.
+func (s *SimpleStore) Stats() SimpleStoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SimpleStoreStats{Entries: len(s.m), Evictions: s.evictions}
+}
+
+// MemoryCachedStore keeps the results read from and written to a slower
+// ScanStore, like a database, in a bounded SimpleStore in memory.
+type MemoryCachedStore struct {
+	Store  ScanStore
+	Memory *SimpleStore
+}
+
+// NewMemoryCachedStore wraps store, keeping up to maxEntries results in
+// memory.
+func NewMemoryCachedStore(store ScanStore, maxEntries int) *MemoryCachedStore {
+	return &MemoryCachedStore{Store: store, Memory: NewSimpleStore(maxEntries)}
+}
+
+// GetHostnameScan retrieves hostname's result from memory, or from Store if
+// it isn't in memory.
+func (s *MemoryCachedStore) GetHostnameScan(hostname string) (HostnameResult, error) {
+	if result, err := s.Memory.GetHostnameScan(hostname); err == nil {
+		return result, nil
+	}
+	result, err := s.Store.GetHostnameScan(hostname)
+	if err == nil {
+		s.Memory.PutHostnameScan(hostname, result)
+	}
+	return result, err
+}
+
+// PutHostnameScan stores hostname's result in memory and in Store.
+func (s *MemoryCachedStore) PutHostnameScan(hostname string, result HostnameResult) error {
+	s.Memory.PutHostnameScan(hostname, result)
+	return s.Store.PutHostnameScan(hostname, result)
+}
+
+// Warm loads results, like those most recently written to Store by an
+// earlier process, into memory, so they don't have to be checked or read
+// again. Results for the same hostname should be oldest first.
+func (s *MemoryCachedStore) Warm(results []HostnameResult) {
+	for _, result := range results {
+		s.Memory.PutHostnameScan(result.Hostname, result)
+	}
+}
+
 // MakeSimpleCache creates a cache with a SimpleStore backing it.
 func MakeSimpleCache(expiryTime time.Duration) *ScanCache {
-	store := SimpleStore{m: make(map[string]HostnameResult)}
-	return &ScanCache{ScanStore: &store, ExpireTime: expiryTime}
+	return MakeBoundedCache(expiryTime, 0)
+}
+
+// MakeBoundedCache creates a cache with a SimpleStore backing it, which holds
+// at most maxEntries results.
+func MakeBoundedCache(expiryTime time.Duration, maxEntries int) *ScanCache {
+	return &ScanCache{ScanStore: NewSimpleStore(maxEntries), ExpireTime: expiryTime}
+}
+
+// DomainCache keeps the results of full domain checks, including their
+// MTA-STS results, in memory for ExpireTime, so that repeated checks of
+// popular domains are answered without checking them again. It's safe for
+// concurrent use.
+type DomainCache struct {
+	ExpireTime time.Duration
+	// MaxEntries is the most results cached at once, after which the least
+	// recently used are evicted. 0 for no limit.
+	MaxEntries int
+
+	m   map[string]*list.Element
+	lru *list.List
+	mu  sync.Mutex
+}
+
+type domainCacheEntry struct {
+	key     string
+	result  DomainResult
+	checked time.Time
+	expires time.Time
+}
+
+// NewDomainCache creates a DomainCache holding results for expireTime, and
+// at most maxEntries of them, or any number if maxEntries is 0.
+func NewDomainCache(expireTime time.Duration, maxEntries int) *DomainCache {
+	return &DomainCache{ExpireTime: expireTime, MaxEntries: maxEntries}
+}
+
+// domainCacheKey identifies a domain check. Checks of the same domain with
+// different expected hostnames have different results.
+func domainCacheKey(domain string, expectedHostnames []string) string {
+	if expectedHostnames == nil {
+		return domain
+	}
+	return domain + " " + strings.Join(expectedHostnames, ",")
+}
+
+// get returns the unexpired result of checking domain with
+// expectedHostnames, if there is one.
+func (c *DomainCache) get(domain string, expectedHostnames []string) (DomainResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.m[domainCacheKey(domain, expectedHostnames)]
+	if !ok {
+		return DomainResult{}, false
+	}
+	entry := element.Value.(*domainCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.m, entry.key)
+		return DomainResult{}, false
+	}
+	c.lru.MoveToFront(element)
+	result := entry.result.withCopiedMaps()
+	result.CachedAt = entry.checked
+	return result, true
+}
+
+// put caches the result of checking domain with expectedHostnames, unless
+// it might be different if the domain were checked again soon, because it
+// timed out or failed temporarily.
+func (c *DomainCache) put(domain string, expectedHostnames []string, result DomainResult) {
+	if result.TimedOut || result.ErrorClass == TemporaryError {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]*list.Element)
+		c.lru = list.New()
+	}
+	key := domainCacheKey(domain, expectedHostnames)
+	now := time.Now()
+	entry := &domainCacheEntry{key: key, result: result.withCopiedMaps(), checked: now, expires: now.Add(c.ExpireTime)}
+	if element, ok := c.m[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.m[key] = c.lru.PushFront(entry)
+	for c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.m, oldest.Value.(*domainCacheEntry).key)
+	}
+}
+
+// withCopiedMaps returns a copy of d whose hostname and extra results can be
+// changed without changing d's.
+func (d DomainResult) withCopiedMaps() DomainResult {
+	hostnameResults := make(map[string]HostnameResult, len(d.HostnameResults))
+	for hostname, result := range d.HostnameResults {
+		hostnameResults[hostname] = result
+	}
+	d.HostnameResults = hostnameResults
+	extraResults := make(map[string]*Result, len(d.ExtraResults))
+	for name, result := range d.ExtraResults {
+		extraResults[name] = result
+	}
+	d.ExtraResults = extraResults
+	return d
 }
@@ -1,6 +1,8 @@
 package checker
 
 import (
+	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -34,3 +36,351 @@ func TestSimpleCacheExpires(t *testing.T) {
 		t.Errorf("Expected cache to expire and scan get to fail: %v", err)
 	}
 }
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	cache := MakeSimpleCache(time.Minute)
+	cache.StaleWhileRevalidate = time.Hour
+	cache.PutHostnameScan("hostname", HostnameResult{
+		Result:    &Result{Status: 3},
+		Timestamp: time.Now().Add(-10 * time.Minute),
+	})
+	refreshed := make(chan bool)
+	c := Checker{
+		Cache: cache,
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			<-refreshed
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+	}
+	result := c.checkHostname("domain", "hostname")
+	if !result.Stale || result.Status != 3 {
+		t.Fatalf("Expected stale cached result, got stale=%v status=%d", result.Stale, result.Status)
+	}
+	// A second lookup while the refresh is running shouldn't start another.
+	if result = c.checkHostname("domain", "hostname"); !result.Stale {
+		t.Errorf("Expected stale cached result while refreshing")
+	}
+	refreshed <- true
+	for i := 0; i < 100; i++ {
+		if result = c.checkHostname("domain", "hostname"); !result.Stale {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if result.Stale || result.Status != 0 {
+		t.Errorf("Expected refreshed result, got stale=%v status=%d", result.Stale, result.Status)
+	}
+
+	cache.PutHostnameScan("hostname", HostnameResult{
+		Result:    &Result{Status: 3},
+		Timestamp: time.Now().Add(-2 * time.Hour),
+	})
+	c.CheckHostname = mockCheckHostname
+	if result = c.checkHostname("domain", "hostname"); result.Stale || result.Status != 0 {
+		t.Errorf("Expected results older than the stale window to be re-checked, got stale=%v status=%d", result.Stale, result.Status)
+	}
+}
+
+func TestCacheOptionsPerChecker(t *testing.T) {
+	cache := MakeSimpleCache(time.Minute)
+	cache.StaleWhileRevalidate = time.Hour
+	cache.PutHostnameScan("hostname", HostnameResult{
+		Result:    &Result{Status: 3},
+		Timestamp: time.Now().Add(-10 * time.Minute),
+	})
+	if _, stale, err := cache.getHostnameScan("hostname", CacheOptions{}); err != nil || !stale {
+		t.Errorf("Expected stale result with the cache's windows, got stale=%v err=%v", stale, err)
+	}
+	if _, stale, err := cache.getHostnameScan("hostname", CacheOptions{MaxAge: time.Hour}); err != nil || stale {
+		t.Errorf("Expected fresh result with a longer MaxAge, got stale=%v err=%v", stale, err)
+	}
+	if _, _, err := cache.getHostnameScan("hostname", CacheOptions{StaleWhileRevalidate: time.Minute}); err == nil {
+		t.Error("Expected result outside a shorter stale window to expire")
+	}
+	if _, _, err := cache.getHostnameScan("hostname", CacheOptions{NoStale: true}); err == nil {
+		t.Error("Expected NoStale to skip expired results")
+	}
+
+	// Checkers sharing the cache each use their own options.
+	interactive := Checker{Cache: cache, CheckHostname: mockCheckHostname}
+	fresh := Checker{Cache: cache, CacheOptions: CacheOptions{NoStale: true}, CheckHostname: mockCheckHostname}
+	if result := fresh.checkHostname("domain", "hostname"); result.Stale || result.Status != 0 {
+		t.Errorf("Expected a fresh check, got stale=%v status=%d", result.Stale, result.Status)
+	}
+	cache.PutHostnameScan("hostname", HostnameResult{
+		Result:    &Result{Status: 3},
+		Timestamp: time.Now().Add(-10 * time.Minute),
+	})
+	cache.StaleWhileRevalidate = 0
+	interactive.CacheOptions.StaleWhileRevalidate = time.Hour
+	if result := interactive.checkHostname("domain", "hostname"); !result.Stale || result.Status != 3 {
+		t.Errorf("Expected stale cached result, got stale=%v status=%d", result.Stale, result.Status)
+	}
+}
+
+func TestCacheJitter(t *testing.T) {
+	cache := MakeSimpleCache(time.Hour)
+	cache.Jitter = 10 * time.Minute
+	timestamp := time.Now()
+	expiries := make(map[time.Duration]bool)
+	for _, hostname := range []string{"a", "b", "c", "d", "e"} {
+		expiry := cache.expireTime(hostname, timestamp, cache.ExpireTime)
+		if expiry > time.Hour || expiry < 50*time.Minute {
+			t.Errorf("Expected expiry within jitter of an hour, got %v", expiry)
+		}
+		if expiry != cache.expireTime(hostname, timestamp, cache.ExpireTime) {
+			t.Errorf("Expected the same expiry each time %s is read", hostname)
+		}
+		expiries[expiry] = true
+	}
+	if len(expiries) < 2 {
+		t.Errorf("Expected hostnames to expire at different times, got %v", expiries)
+	}
+	// Scans older than ExpireTime always expire.
+	cache.PutHostnameScan("a", HostnameResult{Result: &Result{}, Timestamp: time.Now().Add(-61 * time.Minute)})
+	if _, err := cache.GetHostnameScan("a"); err == nil {
+		t.Error("Expected scan older than ExpireTime to expire")
+	}
+}
+
+func TestSimpleStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewSimpleStore(2)
+	store.PutHostnameScan("a", HostnameResult{Hostname: "a"})
+	store.PutHostnameScan("b", HostnameResult{Hostname: "b"})
+	// Reading a makes b the least recently used.
+	if _, err := store.GetHostnameScan("a"); err != nil {
+		t.Fatal(err)
+	}
+	store.PutHostnameScan("c", HostnameResult{Hostname: "c"})
+	if _, err := store.GetHostnameScan("b"); err == nil {
+		t.Error("Expected least recently used result to be evicted")
+	}
+	for _, hostname := range []string{"a", "c"} {
+		if result, err := store.GetHostnameScan(hostname); err != nil || result.Hostname != hostname {
+			t.Errorf("Expected %s to be kept, got %v", hostname, err)
+		}
+	}
+	// Replacing a result doesn't evict anything.
+	store.PutHostnameScan("c", HostnameResult{Hostname: "c"})
+	if stats := store.Stats(); stats != (SimpleStoreStats{Entries: 2, Evictions: 1}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestBackgroundRefreshesCapped(t *testing.T) {
+	cache := MakeSimpleCache(time.Minute)
+	cache.MaxRefreshes = 1
+	release := make(chan bool)
+	check := func() HostnameResult {
+		<-release
+		return HostnameResult{Result: &Result{}, Timestamp: time.Now()}
+	}
+	if !cache.refresh("first", check) {
+		t.Fatal("Expected the first refresh to start")
+	}
+	if cache.refresh("second", check) {
+		t.Error("Expected a refresh over MaxRefreshes not to start")
+	}
+	release <- true
+	for i := 0; i < 100 && !cache.refresh("second", func() HostnameResult { return HostnameResult{} }); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := cache.ScanStore.GetHostnameScan("first"); err != nil {
+		t.Errorf("Expected the first refresh to be stored: %v", err)
+	}
+}
+
+func TestConcurrentChecksCoalesce(t *testing.T) {
+	var checks int32
+	release := make(chan bool)
+	c := Checker{
+		Cache: MakeSimpleCache(time.Hour),
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			atomic.AddInt32(&checks, 1)
+			<-release
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+	}
+	const callers = 10
+	results := make(chan HostnameResult, callers)
+	for i := 0; i < callers; i++ {
+		go func() { results <- c.checkHostname("domain", "hostname") }()
+	}
+	// Wait for the first check to start, and give the others time to join it.
+	for atomic.LoadInt32(&checks) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	first := <-results
+	for i := 1; i < callers; i++ {
+		if result := <-results; result.Result == nil || result.Result != first.Result {
+			t.Errorf("Expected every caller to get the same result, got %+v", result)
+		}
+	}
+	if checks != 1 {
+		t.Errorf("Expected concurrent lookups to share one check, got %d", checks)
+	}
+	if c.Cache.inflight["hostname"] != nil {
+		t.Error("Expected finished check to stop being tracked")
+	}
+	// Once the check is done, later misses check again.
+	c.Cache.PutHostnameScan("hostname", HostnameResult{Result: &Result{}, Timestamp: time.Now().Add(-2 * time.Hour)})
+	c.checkHostname("domain", "hostname")
+	if checks != 2 {
+		t.Errorf("Expected expired result to be checked again, got %d checks", checks)
+	}
+}
+
+func TestMemoryCachedStoreWarm(t *testing.T) {
+	backing := NewSimpleStore(0)
+	store := NewMemoryCachedStore(backing, 0)
+	store.Warm([]HostnameResult{
+		{Hostname: "mx.example.com", Result: &Result{Status: 1}, Timestamp: time.Now()},
+		{Hostname: "mx.example.com", Result: &Result{Status: 3}, Timestamp: time.Now()},
+	})
+	result, err := store.GetHostnameScan("mx.example.com")
+	if err != nil {
+		t.Fatalf("Expected warmed scan get to succeed: %v", err)
+	}
+	if result.Status != 3 {
+		t.Errorf("Expected most recent warmed scan with status 3, had status %d", result.Status)
+	}
+	if _, err := backing.GetHostnameScan("mx.example.com"); err == nil {
+		t.Errorf("Expected warming not to write scans back to the backing store")
+	}
+	backing.PutHostnameScan("mx2.example.com", HostnameResult{Result: &Result{Status: 2}, Timestamp: time.Now()})
+	if result, err = store.GetHostnameScan("mx2.example.com"); err != nil || result.Status != 2 {
+		t.Errorf("Expected scan missing from memory to be read from the backing store, got %v, %v", result, err)
+	}
+	if store.Memory.Stats().Entries != 2 {
+		t.Errorf("Expected scan read from the backing store to be kept in memory")
+	}
+}
+
+func TestDomainCache(t *testing.T) {
+	var mtastsChecks int32
+	c := Checker{
+		Timeout:          time.Second,
+		DomainCache:      NewDomainCache(time.Hour, 0),
+		lookupMXOverride: mockLookupMX,
+		CheckHostname:    mockCheckHostname,
+		checkMTASTSOverride: func(domain string, hostnameResults map[string]HostnameResult) *MTASTSResult {
+			atomic.AddInt32(&mtastsChecks, 1)
+			return mockCheckMTASTS(domain, hostnameResults)
+		},
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	first := c.CheckDomain("domain", nil)
+	first.ExtraResults["policylist"] = MakeResult("policylist")
+	second := c.CheckDomain("domain", nil)
+	if mtastsChecks != 1 {
+		t.Errorf("Expected cached domain result to be used, but checked MTA-STS %d times", mtastsChecks)
+	}
+	if second.MTASTSResult == nil || second.MTASTSResult.Mode != "testing" {
+		t.Errorf("Expected cached result to include MTA-STS result, got %+v", second.MTASTSResult)
+	}
+	if _, ok := second.ExtraResults["policylist"]; ok {
+		t.Error("Expected changes to a returned result not to change the cached result")
+	}
+	if !first.CachedAt.IsZero() || second.CachedAt.IsZero() {
+		t.Errorf("Expected only the cached result to say when it was checked, got %v and %v", first.CachedAt, second.CachedAt)
+	}
+	slower := c
+	slower.Timeout = time.Minute
+	if cached := slower.CheckDomain("domain", nil); cached.Checker == nil || cached.Checker.Timeout != "1s" {
+		t.Errorf("Expected cached result to keep the settings it was checked with, got %+v", cached.Checker)
+	}
+	if mtastsChecks != 1 {
+		t.Errorf("Expected cached domain result to be used, but checked MTA-STS %d times", mtastsChecks)
+	}
+	c.CheckDomain("domain", []string{"hostname1"})
+	if mtastsChecks != 2 {
+		t.Errorf("Expected check with different expected hostnames not to use cached result")
+	}
+
+	c.DomainCache = NewDomainCache(0, 0)
+	c.CheckDomain("domain", nil)
+	c.CheckDomain("domain", nil)
+	if mtastsChecks != 4 {
+		t.Errorf("Expected expired domain results to be checked again")
+	}
+}
+
+func TestDomainCacheSkipsTemporaryErrors(t *testing.T) {
+	cache := NewDomainCache(time.Hour, 1)
+	cache.put("temporary.example", nil, DomainResult{Domain: "temporary.example", ErrorClass: TemporaryError})
+	if _, ok := cache.get("temporary.example", nil); ok {
+		t.Error("Expected temporary errors not to be cached")
+	}
+	cache.put("a.example", nil, DomainResult{Domain: "a.example"})
+	cache.put("b.example", nil, DomainResult{Domain: "b.example"})
+	if _, ok := cache.get("a.example", nil); ok {
+		t.Error("Expected least recently used result to be evicted")
+	}
+	if result, ok := cache.get("b.example", nil); !ok || result.Domain != "b.example" {
+		t.Errorf("Expected cached result for b.example, got %+v", result)
+	}
+}
+
+func TestCoalescedCheckOutlivesFirstScansDeadline(t *testing.T) {
+	release := make(chan bool)
+	c := Checker{
+		Cache: MakeSimpleCache(time.Hour),
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			<-release
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan HostnameResult, 1)
+	go func() { first <- c.checkHostnameContext(ctx, "domain", "hostname") }()
+	for {
+		c.Cache.mu.Lock()
+		started := c.Cache.inflight["hostname"] != nil
+		c.Cache.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	results := make(chan HostnameResult, 1)
+	go func() { results <- c.checkHostname("domain", "hostname") }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	close(release)
+	if result := <-results; result.TimedOut || result.Result == nil {
+		t.Errorf("Expected a scan with time left to get the shared check's result, got %+v", result)
+	}
+	<-first
+}
+
+func TestCoalescedCheckStopsWaitingWhenCancelled(t *testing.T) {
+	release := make(chan bool)
+	c := Checker{
+		Cache: MakeSimpleCache(time.Hour),
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			<-release
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+	}
+	first := make(chan HostnameResult, 1)
+	go func() { first <- c.checkHostname("domain", "hostname") }()
+	for {
+		c.Cache.mu.Lock()
+		started := c.Cache.inflight["hostname"] != nil
+		c.Cache.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if result := c.checkHostnameContext(ctx, "domain", "hostname"); !result.TimedOut {
+		t.Errorf("Expected a cancelled scan to stop waiting for the running check, got %+v", result)
+	}
+	close(release)
+	<-first
+}
@@ -0,0 +1,118 @@
+package checker
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CensusEntry records the result of a domain's most recent full check
+// during a census, along with the MX records it was performed against.
+type CensusEntry struct {
+	Domain    string       `json:"domain"`
+	MXs       []string     `json:"mxs"`
+	Timestamp time.Time    `json:"timestamp"`
+	Result    DomainResult `json:"result"`
+}
+
+// CensusState stores census entries between runs.
+// Implementations should be safe for concurrent use.
+type CensusState interface {
+	GetCensusEntry(domain string) (CensusEntry, bool)
+	PutCensusEntry(CensusEntry)
+}
+
+// MemoryCensusState is a CensusState backed by a map, which can be loaded
+// from and saved to JSON lines.
+type MemoryCensusState struct {
+	m  map[string]CensusEntry
+	mu sync.RWMutex
+}
+
+// NewMemoryCensusState creates an empty MemoryCensusState.
+func NewMemoryCensusState() *MemoryCensusState {
+	return &MemoryCensusState{m: make(map[string]CensusEntry)}
+}
+
+// GetCensusEntry wraps a map get.
+func (s *MemoryCensusState) GetCensusEntry(domain string) (CensusEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.m[domain]
+	return entry, ok
+}
+
+// PutCensusEntry wraps a map set.
+func (s *MemoryCensusState) PutCensusEntry(entry CensusEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[entry.Domain] = entry
+}
+
+// Load reads census entries, one JSON object per line, from r.
+func (s *MemoryCensusState) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry CensusEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return err
+		}
+		s.PutCensusEntry(entry)
+	}
+	return scanner.Err()
+}
+
+// Save writes census entries, one JSON object per line, to w.
+func (s *MemoryCensusState) Save(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	encoder := json.NewEncoder(w)
+	for _, entry := range s.m {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckDomainsIncremental runs the checker on every domain from source like
+// CheckSource, but only performs full checks against domains whose MX
+// records changed since they were recorded in state, or whose recorded
+// result is older than maxAge. Other domains are resolved with a DNS lookup
+// only, and their recorded result is passed to resultHandler.
+func (c *Checker) CheckDomainsIncremental(domains DomainSource, resultHandler ResultHandler,
+	state CensusState, maxAge time.Duration) {
+	c.checkDomains(domains, resultHandler, func(domain string) DomainResult {
+		dnsResult, _ := c.CheckDomainDNSOnly(domain)
+		hostnames := append([]string{}, dnsResult.MXs...)
+		sort.Strings(hostnames)
+		entry, ok := state.GetCensusEntry(domain)
+		if ok && sameHostnames(entry.MXs, hostnames) && time.Since(entry.Timestamp) < maxAge {
+			return entry.Result
+		}
+		result := c.CheckDomain(domain, nil)
+		state.PutCensusEntry(CensusEntry{
+			Domain:    domain,
+			MXs:       hostnames,
+			Timestamp: time.Now(),
+			Result:    result,
+		})
+		return result
+	}, nil)
+}
+
+func sameHostnames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingHandler counts the domains it handles.
+type countingHandler struct {
+	count int
+}
+
+func (h *countingHandler) HandleDomain(r DomainResult) {
+	h.count++
+}
+
+func TestCheckDomainsIncremental(t *testing.T) {
+	var checks int32
+	c := Checker{
+		lookupMXOverride: mockLookupMX,
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			atomic.AddInt32(&checks, 1)
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTXTOverride:      mockLookupTXT,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	state := NewMemoryCensusState()
+	run := func(maxAge time.Duration) *countingHandler {
+		handler := countingHandler{}
+		reader := csv.NewReader(strings.NewReader("domain\ndomain.tld\n"))
+		c.CheckDomainsIncremental(NewCSVSource(reader, 0), &handler, state, maxAge)
+		return &handler
+	}
+
+	if handler := run(time.Hour); handler.count != 2 || checks != 4 {
+		t.Errorf("Expected first run to check all 4 hostnames, checked %d", checks)
+	}
+	checks = 0
+	if handler := run(time.Hour); handler.count != 2 || checks != 0 {
+		t.Errorf("Expected unchanged domains to be skipped, checked %d hostnames", checks)
+	}
+	// Results older than maxAge are rechecked.
+	if run(0); checks != 4 {
+		t.Errorf("Expected stale domains to be rechecked, checked %d hostnames", checks)
+	}
+
+	// Domains with changed MX records are rechecked.
+	entry, _ := state.GetCensusEntry("domain")
+	entry.MXs = []string{"oldhostname"}
+	state.PutCensusEntry(entry)
+	checks = 0
+	if run(time.Hour); checks != 2 {
+		t.Errorf("Expected domain with changed MXs to be rechecked, checked %d hostnames", checks)
+	}
+
+	// State survives a round trip through JSON lines.
+	var buf bytes.Buffer
+	if err := state.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMemoryCensusState()
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.GetCensusEntry("domain.tld"); !ok {
+		t.Errorf("Expected saved state to contain domain.tld")
+	}
+}
@@ -0,0 +1,162 @@
+package checker
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Checks that a certificate's validity period includes now.
+func checkCertExpiry(cert *x509.Certificate, now time.Time) (*Result, *Result) {
+	expired := MakeResult(CertExpired)
+	notYetValid := MakeResult(CertNotYetValid)
+	if now.After(cert.NotAfter) {
+		expired.Failure("Certificate expired on %s. Renew it, and make sure your mailserver "+
+			"has been reloaded to use the new certificate. Automating renewal, eg. with "+
+			"Let's Encrypt, prevents this from happening again.",
+			cert.NotAfter.UTC().Format(time.RFC1123))
+	}
+	if now.Before(cert.NotBefore) {
+		notYetValid.Failure("Certificate isn't valid until %s. Check that your mailserver's clock "+
+			"is correct, or keep serving the previous certificate until then.",
+			cert.NotBefore.UTC().Format(time.RFC1123))
+	}
+	return expired.Success(), notYetValid.Success()
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// Checks that the presented chain leads to a trusted root, and whether an
+// untrusted certificate is self-signed. The chain is verified during the
+// certificate's validity period, so that expired certificates are reported
+// only by the expiry check.
+func checkCertTrust(state tls.ConnectionState, now time.Time) (*Result, *Result) {
+	trustedRoot := MakeResult(CertTrustedRoot)
+	selfSigned := MakeResult(CertSelfSigned)
+	cert := state.PeerCertificates[0]
+	if now.After(cert.NotAfter) {
+		now = cert.NotAfter
+	} else if now.Before(cert.NotBefore) {
+		now = cert.NotBefore
+	}
+	err := verifyCertChain(state, now)
+	if err == nil {
+		return trustedRoot.Success(), selfSigned.Success()
+	}
+	if isSelfSigned(cert) {
+		selfSigned.Failure("Certificate is self-signed, so senders can't verify it. " +
+			"Use a certificate issued by a publicly trusted certificate authority, such as Let's Encrypt.")
+		return trustedRoot.Failure("Certificate root is not trusted, because the certificate is self-signed."), selfSigned
+	}
+	trustedRoot.Failure("Certificate root is not trusted: %v. Make sure your mailserver "+
+		"presents the full chain, including intermediate certificates, and that the "+
+		"certificate is issued by an authority in Mozilla's root store.", err)
+	return trustedRoot, selfSigned.Success()
+}
+
+// Returns the names a certificate is valid for: its DNS subject alternative
+// names, or its common name if it has none.
+func certNames(cert *x509.Certificate) []string {
+	if len(cert.DNSNames) == 0 && cert.Subject.CommonName != "" {
+		return []string{cert.Subject.CommonName}
+	}
+	return cert.DNSNames
+}
+
+// NameMismatch describes a certificate which isn't valid for the name of the
+// mailserver that presented it.
+type NameMismatch struct {
+	// Names the certificate is valid for.
+	CertificateNames []string `json:"certificate_names"`
+	// Names which were checked against the certificate: the MX hostname,
+	// and the mx patterns from the domain's MTA-STS policy, if any.
+	NamesTried []string `json:"names_tried"`
+}
+
+// Checks that the certificate is valid for hostname.
+func checkCertHostname(cert *x509.Certificate, hostname string) *Result {
+	result := MakeResult(CertHostname)
+	if err := cert.VerifyHostname(hostname); err != nil {
+		return result.Failure("Name in cert doesn't match hostname: %v. The certificate is valid for [%s]. "+
+			"Get a certificate that includes %s, the name of the server in your MX records.",
+			err, strings.Join(certNames(cert), ", "), hostname)
+	}
+	return result.Success()
+}
+
+// Returns the expiry of the certificate presented over client, or nil if
+// there isn't one.
+func certNotAfter(client *smtp.Client) *time.Time {
+	state, ok := client.TLSConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	notAfter := state.PeerCertificates[0].NotAfter
+	return &notAfter
+}
+
+// Returns the serial number, in hex, and the issuer of the certificate
+// presented over client, or empty strings if there isn't one.
+func certSerial(client *smtp.Client) (string, string) {
+	state, ok := client.TLSConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		return "", ""
+	}
+	leaf := state.PeerCertificates[0]
+	return fmt.Sprintf("%x", leaf.SerialNumber), leaf.Issuer.String()
+}
+
+// Returns details of a name mismatch between the certificate presented over
+// client and hostname, or nil if there isn't one.
+func nameMismatch(client *smtp.Client, hostname string) *NameMismatch {
+	state, ok := client.TLSConnectionState()
+	if !ok {
+		return nil
+	}
+	hostname = withoutPort(strings.TrimSuffix(hostname, "."))
+	cert := state.PeerCertificates[0]
+	if cert.VerifyHostname(hostname) == nil {
+		return nil
+	}
+	return &NameMismatch{
+		CertificateNames: append([]string{}, certNames(cert)...),
+		NamesTried:       []string{hostname},
+	}
+}
+
+// withMTASTSPatterns returns a copy of the hostname results, adding the mx
+// patterns from an MTA-STS policy to the names tried for any mismatched
+// certificates. Results are copied since they may be shared by a cache.
+func withMTASTSPatterns(hostnameResults map[string]HostnameResult, patterns []string) map[string]HostnameResult {
+	results := make(map[string]HostnameResult)
+	for hostname, result := range hostnameResults {
+		if result.NameMismatch != nil && len(patterns) > 0 {
+			mismatch := *result.NameMismatch
+			mismatch.NamesTried = append([]string{}, mismatch.NamesTried...)
+			for _, pattern := range patterns {
+				if pattern != "" && !containsString(mismatch.NamesTried, pattern) {
+					mismatch.NamesTried = append(mismatch.NamesTried, pattern)
+				}
+			}
+			result.NameMismatch = &mismatch
+		}
+		results[hostname] = result
+	}
+	return results
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
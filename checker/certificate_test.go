@@ -0,0 +1,131 @@
+package checker
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Creates a certificate for name with the test key, signed by parent, or
+// self-signed if parent is nil.
+func makeTestCert(t *testing.T, name string, notBefore, notAfter time.Time, isCA bool, parent *x509.Certificate) *x509.Certificate {
+	block, _ := pem.Decode([]byte(key))
+	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		DNSNames:              []string{name},
+	}
+	if parent == nil {
+		parent = &template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, parent, &privKey.PublicKey, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertificateClassification(t *testing.T) {
+	now := time.Now()
+	valid := func(name string, isCA bool, parent *x509.Certificate) *x509.Certificate {
+		return makeTestCert(t, name, now.Add(-time.Hour), now.Add(time.Hour), isCA, parent)
+	}
+	ca := valid("Test CA", true, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	certRoots = roots
+	defer func() {
+		certRoots = nil
+	}()
+
+	var tests = []struct {
+		name     string
+		chain    []*x509.Certificate
+		hostname string
+		failures []string
+	}{
+		{"valid", []*x509.Certificate{valid("mx.example.com", false, ca)}, "mx.example.com", nil},
+		{"name mismatch", []*x509.Certificate{valid("mx.example.com", false, ca)}, "mail.example.com",
+			[]string{CertHostname}},
+		{"self-signed", []*x509.Certificate{valid("mx.example.com", false, nil)}, "mx.example.com",
+			[]string{CertSelfSigned, CertTrustedRoot}},
+		{"untrusted root", []*x509.Certificate{valid("mx.example.com", false, valid("Other CA", true, nil))},
+			"mx.example.com", []string{CertTrustedRoot}},
+		{"expired", []*x509.Certificate{makeTestCert(t, "mx.example.com",
+			ca.NotBefore, now.Add(-time.Minute), false, ca)}, "mx.example.com", []string{CertExpired}},
+		{"not yet valid", []*x509.Certificate{makeTestCert(t, "mx.example.com",
+			now.Add(time.Minute), ca.NotAfter, false, ca)}, "mx.example.com", []string{CertNotYetValid}},
+	}
+	for _, test := range tests {
+		state := tls.ConnectionState{PeerCertificates: test.chain}
+		expired, notYetValid := checkCertExpiry(test.chain[0], now)
+		trustedRoot, selfSigned := checkCertTrust(state, now)
+		results := []*Result{checkCertHostname(test.chain[0], test.hostname), trustedRoot, selfSigned, expired, notYetValid}
+		failed := map[string]bool{}
+		for _, failure := range test.failures {
+			failed[failure] = true
+		}
+		for _, result := range results {
+			if failed[result.Name] != (result.Status == Failure) {
+				t.Errorf("%s: %s status = %d, messages %v", test.name, result.Name, result.Status, result.Messages)
+			}
+			if result.Status == Failure && (len(result.Messages) != 1 || !strings.HasPrefix(result.Messages[0], "Failure: ")) {
+				t.Errorf("%s: expected a single failure message for %s, got %v", test.name, result.Name, result.Messages)
+			}
+		}
+	}
+}
+
+func TestNameMismatchDiagnostics(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certStringHostnameMismatch), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer ln.Close()
+
+	result := FullCheckHostname("", ln.Addr().String(), testTimeout)
+	mismatch := result.NameMismatch
+	if mismatch == nil {
+		t.Fatal("expected name mismatch details")
+	}
+	if len(mismatch.CertificateNames) != 1 || mismatch.CertificateNames[0] != "you_give_love_a_bad_name" {
+		t.Errorf("expected the certificate's names, got %v", mismatch.CertificateNames)
+	}
+	if len(mismatch.NamesTried) != 1 || mismatch.NamesTried[0] != "127.0.0.1" {
+		t.Errorf("expected the MX hostname to be tried, got %v", mismatch.NamesTried)
+	}
+	message := result.Checks[Certificate].Checks[CertHostname].Messages[0]
+	if !strings.Contains(message, "[you_give_love_a_bad_name]") {
+		t.Errorf("expected the certificate's names in the failure message, got %s", message)
+	}
+
+	results := map[string]HostnameResult{"127.0.0.1": result}
+	withPatterns := withMTASTSPatterns(results, []string{"*.example.com", "127.0.0.1"})
+	tried := withPatterns["127.0.0.1"].NameMismatch.NamesTried
+	if len(tried) != 2 || tried[1] != "*.example.com" {
+		t.Errorf("expected MTA-STS patterns to be added to the names tried, got %v", tried)
+	}
+	if len(result.NameMismatch.NamesTried) != 1 {
+		t.Error("adding MTA-STS patterns shouldn't modify the original result")
+	}
+}
@@ -12,20 +12,84 @@ type Checker struct {
 	// If nil, a default timeout of 10 seconds is used.
 	Timeout time.Duration
 
+	// Deadline specifies the maximum time a single CheckDomain call may take.
+	// Hostnames that haven't been checked by then are marked as timed out,
+	// and the results collected so far are returned.
+	// If 0, domain checks have no deadline.
+	Deadline time.Duration
+
+	// RecordDecisions records how each domain's status and grade were
+	// decided in its result's Decisions, so they can be explained later.
+	RecordDecisions bool
+
+	// GreylistRetries specifies how many times to re-attempt checks against a
+	// hostname that responds with a temporary failure, as greylisting servers do.
+	// If 0, hostnames are not re-checked.
+	GreylistRetries int
+
+	// GreylistRetryDelay specifies how long to wait before each re-attempt.
+	// If 0, a default delay of 1 minute is used.
+	GreylistRetryDelay time.Duration
+
+	// PoolSize specifies the number of domains checked at once by bulk scans.
+	// If 0, a default pool size of 16 is used.
+	PoolSize int
+
 	// Cache specifies the hostname scan cache store and expire time.
+	// Concurrent checks of the same hostname through a cache share a single
+	// network check.
 	// If `nil`, then scans are not cached.
 	Cache *ScanCache
 
+	// CacheOptions override how long Cache's results are fresh, and how
+	// long they may be used stale, for this Checker's lookups.
+	CacheOptions CacheOptions
+
+	// DomainCache specifies where full domain results are cached, and for how
+	// long. Cached results are returned without checking the domain's
+	// hostnames or MTA-STS policy again.
+	// If `nil`, then domain results are not cached.
+	DomainCache *DomainCache
+
+	// Checkpoint, if set, records the progress of CheckCSV, so that an
+	// interrupted scan can be resumed with ResumeCSV.
+	Checkpoint CheckpointStore
+
+	// CheckpointInterval specifies how often progress is recorded.
+	// If 0, a default interval of 1 minute is used.
+	CheckpointInterval time.Duration
+
+	// Feeds annotate the results of domain checks with advisory information
+	// from external reputation feeds.
+	// If nil, the feeds set up by Configure are used.
+	Feeds []Feed
+
+	// FeedTimeout specifies how long to wait for Feeds to annotate a result.
+	// If 0, a default timeout of 2 seconds is used.
+	FeedTimeout time.Duration
+
 	// lookupMXOverride specifies an alternate function to retrieve hostnames for a given
 	// domain. It is used to mock DNS lookups during testing.
 	lookupMXOverride func(string) ([]*net.MX, error)
 
-	// CheckHostname defines the function that should be used to check each hostname.
-	// If nil, FullCheckHostname (all hostname checks) will be used.
+	// HostnameCheck specifies the checks run against each hostname.
+	// If nil, FullHostnameCheck is used.
+	HostnameCheck *HostnameCheck
+
+	// CheckHostname defines a custom function that should be used to check
+	// each hostname, instead of HostnameCheck.
 	CheckHostname func(string, string, time.Duration) HostnameResult
 
 	// checkMTASTSOverride is used to mock MTA-STS checks.
 	checkMTASTSOverride func(string, map[string]HostnameResult) *MTASTSResult
+
+	// checkMXHygieneOverride is used to mock MX record hygiene checks.
+	checkMXHygieneOverride func([]string) *Result
+
+	// lookupTXTOverride and lookupTLSAOverride are used to mock the DNS
+	// lookups in CheckDomainDNSOnly.
+	lookupTXTOverride  func(string) ([]string, error)
+	lookupTLSAOverride func(string) (bool, error)
 }
 
 func (c *Checker) timeout() time.Duration {
@@ -34,3 +98,28 @@ func (c *Checker) timeout() time.Duration {
 	}
 	return 10 * time.Second
 }
+
+// hostnameCheckConnections is the most connections a hostname check makes,
+// each of which is bounded by Timeout.
+const hostnameCheckConnections = 4
+
+// hostnameCheckTimeout bounds a hostname check that's shared between scans:
+// every attempt's connections, and the delays between greylisting retries.
+func (c *Checker) hostnameCheckTimeout() time.Duration {
+	retries := time.Duration(c.GreylistRetries)
+	return (retries+1)*hostnameCheckConnections*c.timeout() + retries*c.greylistRetryDelay()
+}
+
+func (c *Checker) poolSize() int {
+	if c.PoolSize > 0 {
+		return c.PoolSize
+	}
+	return defaultPoolSize
+}
+
+func (c *Checker) greylistRetryDelay() time.Duration {
+	if c.GreylistRetryDelay != 0 {
+		return c.GreylistRetryDelay
+	}
+	return time.Minute
+}
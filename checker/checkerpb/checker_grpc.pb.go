@@ -0,0 +1,105 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: checker.proto
+
+package checkerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ScannerClient is the client API for Scanner service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ScannerClient interface {
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*DomainResult, error)
+}
+
+type scannerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewScannerClient(cc grpc.ClientConnInterface) ScannerClient {
+	return &scannerClient{cc}
+}
+
+func (c *scannerClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*DomainResult, error) {
+	out := new(DomainResult)
+	err := c.cc.Invoke(ctx, "/starttls.checker.v1.Scanner/Scan", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ScannerServer is the server API for Scanner service.
+// All implementations must embed UnimplementedScannerServer
+// for forward compatibility
+type ScannerServer interface {
+	Scan(context.Context, *ScanRequest) (*DomainResult, error)
+	mustEmbedUnimplementedScannerServer()
+}
+
+// UnimplementedScannerServer must be embedded to have forward compatible implementations.
+type UnimplementedScannerServer struct {
+}
+
+func (UnimplementedScannerServer) Scan(context.Context, *ScanRequest) (*DomainResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedScannerServer) mustEmbedUnimplementedScannerServer() {}
+
+// UnsafeScannerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ScannerServer will
+// result in compilation errors.
+type UnsafeScannerServer interface {
+	mustEmbedUnimplementedScannerServer()
+}
+
+func RegisterScannerServer(s grpc.ServiceRegistrar, srv ScannerServer) {
+	s.RegisterService(&Scanner_ServiceDesc, srv)
+}
+
+func _Scanner_Scan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScannerServer).Scan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/starttls.checker.v1.Scanner/Scan",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScannerServer).Scan(ctx, req.(*ScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Scanner_ServiceDesc is the grpc.ServiceDesc for Scanner service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Scanner_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "starttls.checker.v1.Scanner",
+	HandlerType: (*ScannerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Scan",
+			Handler:    _Scanner_Scan_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "checker.proto",
+}
@@ -0,0 +1,222 @@
+// Package checkerpb contains protobuf messages for checker results,
+// conversions to and from the checker package's types, and a gRPC service
+// for requesting scans.
+package checkerpb
+
+import (
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//go:generate buf generate
+
+func fromResult(r *checker.Result) *Result {
+	if r == nil {
+		return nil
+	}
+	return &Result{
+		Name:     r.Name,
+		Status:   Status(r.Status),
+		Messages: r.Messages,
+		Checks:   fromResults(r.Checks),
+	}
+}
+
+func fromResults(results map[string]*checker.Result) map[string]*Result {
+	if results == nil {
+		return nil
+	}
+	converted := make(map[string]*Result)
+	for name, r := range results {
+		converted[name] = fromResult(r)
+	}
+	return converted
+}
+
+func toResult(r *Result) *checker.Result {
+	if r == nil {
+		return nil
+	}
+	return &checker.Result{
+		Name:     r.Name,
+		Status:   checker.Status(r.Status),
+		Messages: r.Messages,
+		Checks:   toResults(r.Checks),
+	}
+}
+
+func toResults(results map[string]*Result) map[string]*checker.Result {
+	if results == nil {
+		return nil
+	}
+	converted := make(map[string]*checker.Result)
+	for name, r := range results {
+		converted[name] = toResult(r)
+	}
+	return converted
+}
+
+// FromHostnameResult converts a checker.HostnameResult to a protobuf message.
+func FromHostnameResult(h checker.HostnameResult) *HostnameResult {
+	converted := &HostnameResult{
+		Result:           fromResult(h.Result),
+		Domain:           h.Domain,
+		Hostname:         h.Hostname,
+		TemporaryFailure: h.TemporaryFailure,
+		TimedOut:         h.TimedOut,
+		Stale:            h.Stale,
+		Extensions:       h.Extensions,
+		Transcript:       h.Transcript,
+		InfoResults:      fromResults(h.InfoResults),
+		CertSpkiSha256:   h.CertSPKISHA256,
+		IssuerSpkiSha256: h.IssuerSPKISHA256,
+		TlsVersion:       h.TLSVersion,
+		CipherSuite:      h.CipherSuite,
+		CertSerial:       h.CertSerial,
+		CertIssuer:       h.CertIssuer,
+	}
+	if h.NameMismatch != nil {
+		converted.NameMismatch = &NameMismatch{
+			CertificateNames: h.NameMismatch.CertificateNames,
+			NamesTried:       h.NameMismatch.NamesTried,
+		}
+	}
+	if h.CertNotAfter != nil {
+		converted.CertNotAfter = timestamppb.New(*h.CertNotAfter)
+	}
+	return converted
+}
+
+// ToHostnameResult converts a protobuf message to a checker.HostnameResult.
+func ToHostnameResult(h *HostnameResult) checker.HostnameResult {
+	converted := checker.HostnameResult{
+		Result:           toResult(h.GetResult()),
+		Domain:           h.GetDomain(),
+		Hostname:         h.GetHostname(),
+		TemporaryFailure: h.GetTemporaryFailure(),
+		TimedOut:         h.GetTimedOut(),
+		Stale:            h.GetStale(),
+		Extensions:       h.GetExtensions(),
+		Transcript:       h.GetTranscript(),
+		InfoResults:      toResults(h.GetInfoResults()),
+		CertSPKISHA256:   h.GetCertSpkiSha256(),
+		IssuerSPKISHA256: h.GetIssuerSpkiSha256(),
+		TLSVersion:       h.GetTlsVersion(),
+		CipherSuite:      h.GetCipherSuite(),
+		CertSerial:       h.GetCertSerial(),
+		CertIssuer:       h.GetCertIssuer(),
+	}
+	if mismatch := h.GetNameMismatch(); mismatch != nil {
+		converted.NameMismatch = &checker.NameMismatch{
+			CertificateNames: mismatch.CertificateNames,
+			NamesTried:       mismatch.NamesTried,
+		}
+	}
+	if h.GetCertNotAfter() != nil {
+		notAfter := h.CertNotAfter.AsTime().In(time.UTC)
+		converted.CertNotAfter = &notAfter
+	}
+	return converted
+}
+
+// FromDomainResult converts a checker.DomainResult to a protobuf message.
+func FromDomainResult(d checker.DomainResult) *DomainResult {
+	converted := &DomainResult{
+		SchemaVersion:      int32(checker.SchemaVersion),
+		Domain:             d.Domain,
+		Message:            d.Message,
+		Status:             DomainStatus(d.Status),
+		PreferredHostnames: d.PreferredHostnames,
+		MxHostnames:        d.MxHostnames,
+		SkippedHostnames:   d.SkippedHostnames,
+		ExtraResults:       fromResults(d.ExtraResults),
+		DaneHostnames:      d.DANEHostnames,
+		Grade:              string(d.Grade),
+		GradeReasons:       d.GradeReasons,
+		TimedOut:           d.TimedOut,
+		ErrorClass:         string(d.ErrorClass),
+	}
+	if d.HostnameResults != nil {
+		converted.Results = make(map[string]*HostnameResult)
+		for hostname, h := range d.HostnameResults {
+			converted.Results[hostname] = FromHostnameResult(h)
+		}
+	}
+	for _, mx := range d.MXRecords {
+		converted.MxRecords = append(converted.MxRecords, &MXRecord{
+			Hostname:  mx.Hostname,
+			Priority:  uint32(mx.Priority),
+			Status:    Status(mx.Status),
+			Preferred: mx.Preferred,
+		})
+	}
+	if d.MTASTSResult != nil {
+		converted.MtaSts = &MTASTSResult{
+			Result: fromResult(d.MTASTSResult.Result),
+			Policy: d.MTASTSResult.Policy,
+			Mode:   d.MTASTSResult.Mode,
+			Mxs:    d.MTASTSResult.MXs,
+			Id:     d.MTASTSResult.ID,
+		}
+	}
+	for _, a := range d.Annotations {
+		converted.Annotations = append(converted.Annotations, &Annotation{
+			Feed:     a.Feed,
+			Hostname: a.Hostname,
+			Message:  a.Message,
+		})
+	}
+	return converted
+}
+
+// ToDomainResult converts a protobuf message to a checker.DomainResult.
+func ToDomainResult(d *DomainResult) checker.DomainResult {
+	converted := checker.DomainResult{
+		SchemaVersion:      checker.SchemaVersion,
+		Domain:             d.GetDomain(),
+		Message:            d.GetMessage(),
+		Status:             checker.DomainStatus(d.GetStatus()),
+		PreferredHostnames: d.GetPreferredHostnames(),
+		MxHostnames:        d.GetMxHostnames(),
+		SkippedHostnames:   d.GetSkippedHostnames(),
+		ExtraResults:       toResults(d.GetExtraResults()),
+		DANEHostnames:      d.GetDaneHostnames(),
+		Grade:              checker.Grade(d.GetGrade()),
+		GradeReasons:       d.GetGradeReasons(),
+		TimedOut:           d.GetTimedOut(),
+		ErrorClass:         checker.ErrorClass(d.GetErrorClass()),
+	}
+	if d.GetResults() != nil {
+		converted.HostnameResults = make(map[string]checker.HostnameResult)
+		for hostname, h := range d.Results {
+			converted.HostnameResults[hostname] = ToHostnameResult(h)
+		}
+	}
+	for _, mx := range d.GetMxRecords() {
+		converted.MXRecords = append(converted.MXRecords, checker.MXRecord{
+			Hostname:  mx.Hostname,
+			Priority:  uint16(mx.Priority),
+			Status:    checker.Status(mx.Status),
+			Preferred: mx.Preferred,
+		})
+	}
+	if mtasts := d.GetMtaSts(); mtasts != nil {
+		converted.MTASTSResult = &checker.MTASTSResult{
+			Result: toResult(mtasts.Result),
+			Policy: mtasts.Policy,
+			Mode:   mtasts.Mode,
+			MXs:    mtasts.Mxs,
+			ID:     mtasts.Id,
+		}
+	}
+	for _, a := range d.GetAnnotations() {
+		converted.Annotations = append(converted.Annotations, checker.Annotation{
+			Feed:     a.Feed,
+			Hostname: a.Hostname,
+			Message:  a.Message,
+		})
+	}
+	return converted
+}
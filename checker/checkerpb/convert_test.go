@@ -0,0 +1,64 @@
+package checkerpb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDomainResultRoundTrip(t *testing.T) {
+	result := checker.NewSampleDomainResult("example.com")
+	hostnameResult := result.HostnameResults["mx.example.com"]
+	notAfter := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	hostnameResult.CertNotAfter = &notAfter
+	hostnameResult.CertSPKISHA256 = "0123abcd"
+	hostnameResult.IssuerSPKISHA256 = "4567ef00"
+	hostnameResult.TLSVersion = "TLS 1.3"
+	hostnameResult.CipherSuite = "TLS_AES_128_GCM_SHA256"
+	hostnameResult.NameMismatch = &checker.NameMismatch{
+		CertificateNames: []string{"other.example.com"},
+		NamesTried:       []string{"mx.example.com"},
+	}
+	result.HostnameResults["mx.example.com"] = hostnameResult
+	result.MXRecords = []checker.MXRecord{{Hostname: "mx.example.com", Priority: 10, Preferred: true}}
+	result.Grade, result.GradeReasons = checker.ScoreDomain(result)
+
+	encoded, err := proto.Marshal(FromDomainResult(result))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &DomainResult{}
+	if err = proto.Unmarshal(encoded, decoded); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(result)
+	got, _ := json.Marshal(ToDomainResult(decoded))
+	if string(want) != string(got) {
+		t.Errorf("Expected round trip through protobuf to preserve result:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestScanServerAuthorize(t *testing.T) {
+	s := &ScanServer{Authorize: func(context.Context) error {
+		return status.Error(codes.Unauthenticated, "no key")
+	}}
+	if _, err := s.Scan(context.Background(), &ScanRequest{Domain: "example.com"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected unauthorized scans to be refused, got %v", err)
+	}
+	s.Authorize = nil
+	if _, err := s.Scan(context.Background(), &ScanRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected scans without a domain to be refused, got %v", err)
+	}
+	s.Validate = func(_ context.Context, domain string) (string, error) {
+		return "", status.Error(codes.PermissionDenied, domain+" can't be scanned")
+	}
+	if _, err := s.Scan(context.Background(), &ScanRequest{Domain: "example.com"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected invalid domains to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package checkerpb
+
+import (
+	"context"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ScanServer implements the Scanner gRPC service with a checker.Checker.
+type ScanServer struct {
+	UnimplementedScannerServer
+	Checker *checker.Checker
+	// Authorize is called before every scan, if it's set. Scans are refused
+	// if it returns an error, which should be a gRPC status error.
+	Authorize func(context.Context) error
+	// Validate is called with each requested domain after it's authorized,
+	// if it's set, and returns the domain to check. Scans are refused if it
+	// returns an error, which should be a gRPC status error.
+	Validate func(ctx context.Context, domain string) (string, error)
+}
+
+// Scan checks the requested domain.
+func (s *ScanServer) Scan(ctx context.Context, req *ScanRequest) (*DomainResult, error) {
+	if s.Authorize != nil {
+		if err := s.Authorize(ctx); err != nil {
+			return nil, err
+		}
+	}
+	domain := req.GetDomain()
+	if domain == "" {
+		return nil, status.Error(codes.InvalidArgument, "domain is required")
+	}
+	if s.Validate != nil {
+		var err error
+		if domain, err = s.Validate(ctx, domain); err != nil {
+			return nil, err
+		}
+	}
+	c := s.Checker
+	if c == nil {
+		c = &checker.Checker{}
+	}
+	var expected []string
+	if len(req.GetMxHostnames()) > 0 {
+		expected = req.MxHostnames
+	}
+	return FromDomainResult(c.CheckDomainContext(ctx, domain, expected)), nil
+}
@@ -0,0 +1,178 @@
+package checker
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultCheckpointInterval = time.Minute
+
+// Checkpoint records the progress of a bulk scan, so that it can be resumed
+// after a crash.
+type Checkpoint struct {
+	// Checked is the number of domains, from the start of the input, whose
+	// results have all been handled, and Domain is the last of them.
+	Checked int       `json:"checked"`
+	Domain  string    `json:"domain"`
+	Time    time.Time `json:"time"`
+	// Finished is set once every domain in the input has been handled.
+	Finished bool `json:"finished"`
+}
+
+// CheckpointStore saves and loads a bulk scan's checkpoint.
+type CheckpointStore interface {
+	SaveCheckpoint(Checkpoint) error
+	// LoadCheckpoint returns an empty Checkpoint if none has been saved.
+	LoadCheckpoint() (Checkpoint, error)
+}
+
+// FileCheckpoint stores a checkpoint as JSON in the file at its path.
+type FileCheckpoint string
+
+// SaveCheckpoint replaces the file's checkpoint. The file is never left
+// partly written.
+func (path FileCheckpoint) SaveCheckpoint(checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(string(path)), filepath.Base(string(path))+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(path))
+}
+
+// LoadCheckpoint reads the file's checkpoint.
+func (path FileCheckpoint) LoadCheckpoint() (Checkpoint, error) {
+	var checkpoint Checkpoint
+	data, err := ioutil.ReadFile(string(path))
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+	err = json.Unmarshal(data, &checkpoint)
+	return checkpoint, err
+}
+
+// flusher is implemented by result handlers that buffer results, like
+// SinkHandler. They're flushed before each checkpoint, so that a checkpoint
+// never covers results that could still be lost.
+type flusher interface {
+	Flush() error
+}
+
+// checkpointer tracks which domains of a bulk scan have been handled. Results
+// arrive out of order, so the checkpoint only advances past domains once
+// every domain before them has been handled too.
+type checkpointer struct {
+	store    CheckpointStore
+	interval time.Duration
+	handler  ResultHandler
+
+	checkpoint Checkpoint
+	// handled holds the domains handled beyond checkpoint.Checked, by index.
+	handled  map[int]string
+	lastSave time.Time
+}
+
+func (c *Checker) newCheckpointer(handler ResultHandler, from Checkpoint) *checkpointer {
+	interval := c.CheckpointInterval
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+	return &checkpointer{
+		store:      c.Checkpoint,
+		interval:   interval,
+		handler:    handler,
+		checkpoint: from,
+		handled:    make(map[int]string),
+		lastSave:   time.Now(),
+	}
+}
+
+// done records that the domain at index has been handled, saving a
+// checkpoint if the last was long enough ago.
+func (p *checkpointer) done(index int, domain string) {
+	p.handled[index] = domain
+	for {
+		domain, ok := p.handled[p.checkpoint.Checked]
+		if !ok {
+			break
+		}
+		delete(p.handled, p.checkpoint.Checked)
+		p.checkpoint.Checked++
+		p.checkpoint.Domain = domain
+	}
+	if time.Since(p.lastSave) >= p.interval {
+		p.save()
+	}
+}
+
+func (p *checkpointer) finish() {
+	p.checkpoint.Finished = true
+	p.save()
+}
+
+func (p *checkpointer) save() {
+	p.lastSave = time.Now()
+	if f, ok := p.handler.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			log.Printf("Not saving checkpoint, since results couldn't be flushed: %v", err)
+			return
+		}
+	}
+	p.checkpoint.Time = p.lastSave
+	if err := p.store.SaveCheckpoint(p.checkpoint); err != nil {
+		log.Printf("Couldn't save checkpoint: %v", err)
+	}
+}
+
+// ResumeCSV continues a CheckCSV of the same CSV that was interrupted,
+// skipping the domains c.Checkpoint records as already handled.
+func (c *Checker) ResumeCSV(domains *csv.Reader, resultHandler ResultHandler, domainColumn int) error {
+	if c.Checkpoint == nil {
+		return fmt.Errorf("can't resume without a checkpoint")
+	}
+	checkpoint, err := c.Checkpoint.LoadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if checkpoint.Finished {
+		log.Printf("Nothing to resume: all %d domains were checked", checkpoint.Checked)
+		return nil
+	}
+	source := NewCSVSource(domains, domainColumn)
+	for i := 0; i < checkpoint.Checked; i++ {
+		domain, err := source.Next()
+		if err != nil {
+			return fmt.Errorf("checkpoint is past the end of the input, after %d domains", i)
+		}
+		if i == checkpoint.Checked-1 && domain != checkpoint.Domain {
+			return fmt.Errorf("checkpoint doesn't match the input: expected %s as domain %d, got %s",
+				checkpoint.Domain, checkpoint.Checked, domain)
+		}
+	}
+	log.Printf("Resuming after %d domains, at %s", checkpoint.Checked, checkpoint.Domain)
+	progress := c.newCheckpointer(resultHandler, checkpoint)
+	c.checkDomains(source, resultHandler, func(domain string) DomainResult {
+		return c.CheckDomain(domain, nil)
+	}, progress)
+	return nil
+}
@@ -0,0 +1,132 @@
+package checker
+
+import (
+	"encoding/csv"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryCheckpoint records every saved checkpoint.
+type memoryCheckpoint struct {
+	saved []Checkpoint
+}
+
+func (m *memoryCheckpoint) SaveCheckpoint(checkpoint Checkpoint) error {
+	m.saved = append(m.saved, checkpoint)
+	return nil
+}
+
+func (m *memoryCheckpoint) LoadCheckpoint() (Checkpoint, error) {
+	if len(m.saved) == 0 {
+		return Checkpoint{}, nil
+	}
+	return m.saved[len(m.saved)-1], nil
+}
+
+func checkpointTestChecker(store CheckpointStore) Checker {
+	return Checker{
+		Cache:                  MakeSimpleCache(10 * time.Minute),
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+		Checkpoint:             store,
+		CheckpointInterval:     time.Nanosecond,
+	}
+}
+
+const checkpointTestCSV = "empty\ndomain\ndomain.tld\nnoconnection\nnoconnection2\nnostarttls\n"
+
+func TestCheckCSVCheckpoints(t *testing.T) {
+	store := &memoryCheckpoint{}
+	c := checkpointTestChecker(store)
+	totals := AggregatedScan{}
+	c.CheckCSV(csv.NewReader(strings.NewReader(checkpointTestCSV)), &totals, 0)
+
+	if len(store.saved) < 2 {
+		t.Fatalf("Expected progress to be saved as domains were checked, got %v", store.saved)
+	}
+	last := store.saved[len(store.saved)-1]
+	if !last.Finished || last.Checked != 6 {
+		t.Errorf("Expected final checkpoint to cover all 6 domains, got %+v", last)
+	}
+	for i := 1; i < len(store.saved); i++ {
+		if store.saved[i].Checked < store.saved[i-1].Checked {
+			t.Errorf("Expected checkpoints to only move forward, got %v", store.saved)
+		}
+	}
+}
+
+func TestResumeCSV(t *testing.T) {
+	store := &memoryCheckpoint{saved: []Checkpoint{{Checked: 3, Domain: "domain.tld"}}}
+	c := checkpointTestChecker(store)
+	totals := AggregatedScan{}
+	err := c.ResumeCSV(csv.NewReader(strings.NewReader(checkpointTestCSV)), &totals, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals.Attempted != 3 {
+		t.Errorf("Expected the 3 remaining domains to be checked, got %d", totals.Attempted)
+	}
+	last := store.saved[len(store.saved)-1]
+	if !last.Finished || last.Checked != 6 || last.Domain == "" {
+		t.Errorf("Expected final checkpoint to cover all 6 domains, got %+v", last)
+	}
+
+	// A finished scan has nothing left to check.
+	totals = AggregatedScan{}
+	c.ResumeCSV(csv.NewReader(strings.NewReader(checkpointTestCSV)), &totals, 0)
+	if totals.Attempted != 0 {
+		t.Errorf("Expected no domains to be checked, got %d", totals.Attempted)
+	}
+}
+
+func TestResumeCSVMismatch(t *testing.T) {
+	for _, checkpoint := range []Checkpoint{
+		{Checked: 3, Domain: "other.tld"},
+		{Checked: 10, Domain: "nostarttls"},
+	} {
+		c := checkpointTestChecker(&memoryCheckpoint{saved: []Checkpoint{checkpoint}})
+		err := c.ResumeCSV(csv.NewReader(strings.NewReader(checkpointTestCSV)), &AggregatedScan{}, 0)
+		if err == nil {
+			t.Errorf("Expected error resuming from %+v", checkpoint)
+		}
+	}
+}
+
+func TestCheckpointerWaitsForEarlierDomains(t *testing.T) {
+	store := &memoryCheckpoint{}
+	c := Checker{Checkpoint: store, CheckpointInterval: time.Hour}
+	p := c.newCheckpointer(&AggregatedScan{}, Checkpoint{})
+	p.done(1, "b")
+	p.done(2, "c")
+	if p.checkpoint.Checked != 0 {
+		t.Errorf("Expected checkpoint not to advance past an unhandled domain, got %+v", p.checkpoint)
+	}
+	p.done(0, "a")
+	if p.checkpoint.Checked != 3 || p.checkpoint.Domain != "c" {
+		t.Errorf("Expected checkpoint to cover all 3 domains, got %+v", p.checkpoint)
+	}
+	if len(store.saved) != 0 {
+		t.Errorf("Expected no checkpoint to be saved before the interval, got %v", store.saved)
+	}
+}
+
+func TestFileCheckpoint(t *testing.T) {
+	path := FileCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"))
+	checkpoint, err := path.LoadCheckpoint()
+	if err != nil || checkpoint.Checked != 0 {
+		t.Fatalf("Expected empty checkpoint before saving, got %+v, %v", checkpoint, err)
+	}
+	saved := Checkpoint{Checked: 42, Domain: "example.com", Time: time.Now().UTC().Truncate(time.Second)}
+	if err = path.SaveCheckpoint(saved); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err = path.LoadCheckpoint()
+	if err != nil || checkpoint != saved {
+		t.Errorf("Expected %+v, got %+v, %v", saved, checkpoint, err)
+	}
+}
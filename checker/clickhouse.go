@@ -0,0 +1,93 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// sinkHTTPClient is used by sinks which talk to their database over HTTP.
+var sinkHTTPClient = &http.Client{Timeout: time.Minute}
+
+// Table names are interpolated into queries, so they're restricted to
+// plain (optionally database-qualified) identifiers.
+var sinkTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// ClickHouseSink exports results to ClickHouse over its HTTP interface.
+type ClickHouseSink struct {
+	// URL of the ClickHouse HTTP interface, eg. http://localhost:8123
+	URL      string
+	Table    string
+	User     string
+	Password string
+}
+
+const clickHouseSchema = `CREATE TABLE IF NOT EXISTS %s (
+	domain String,
+	source String,
+	timestamp DateTime,
+	status UInt8,
+	mx_hostnames Array(String),
+	preferred_hostnames Array(String),
+	mta_sts_mode String,
+	result String
+) ENGINE = MergeTree() ORDER BY (domain, timestamp)`
+
+// EnsureTable creates the results table if it doesn't exist.
+func (s *ClickHouseSink) EnsureTable() error {
+	if !sinkTableName.MatchString(s.Table) {
+		return fmt.Errorf("invalid ClickHouse table name %q", s.Table)
+	}
+	return s.query(fmt.Sprintf(clickHouseSchema, s.Table), nil)
+}
+
+// Insert stores rows in a single batched INSERT.
+func (s *ClickHouseSink) Insert(rows []SinkRow) error {
+	if !sinkTableName.MatchString(s.Table) {
+		return fmt.Errorf("invalid ClickHouse table name %q", s.Table)
+	}
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, row := range rows {
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	return s.query(fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", s.Table), &body)
+}
+
+// query runs a statement. Any data for the statement is sent as the request
+// body, so the statement itself is passed as a URL parameter.
+func (s *ClickHouseSink) query(statement string, data io.Reader) error {
+	params := url.Values{}
+	params.Set("query", statement)
+	// Accept the RFC 3339 timestamps that encoding/json produces.
+	params.Set("date_time_input_format", "best_effort")
+	if data == nil {
+		data = &bytes.Buffer{}
+	}
+	req, err := http.NewRequest("POST", s.URL+"/?"+params.Encode(), data)
+	if err != nil {
+		return err
+	}
+	if s.User != "" {
+		req.Header.Set("X-ClickHouse-User", s.User)
+		req.Header.Set("X-ClickHouse-Key", s.Password)
+	}
+	resp, err := sinkHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("ClickHouse returned %s: %s", resp.Status, message)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"flag"
@@ -13,95 +14,357 @@ import (
 	"time"
 
 	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/cli"
+	"github.com/EFForg/starttls-backend/db"
+	"github.com/EFForg/starttls-backend/models"
 )
 
 var out io.Writer = os.Stdout
 
-func setFlags() (domain, filePath, url *string, column *int, aggregate *bool) {
+// flags holds the command line options.
+type flags struct {
+	domain          *string
+	filePath        *string
+	url             *string
+	zoneFile        *string
+	resumeAfter     *string
+	column          *int
+	aggregate       *bool
+	tlsStats        *bool
+	sni             *bool
+	greylistRetries *int
+	greylistDelay   *time.Duration
+	greylistPass    *time.Duration
+	deadline        *time.Duration
+	statePath       *string
+	maxAge          *time.Duration
+	sink            *bool
+	configPath      *string
+	checkpointPath  *string
+	checkpointEvery *time.Duration
+	resume          *bool
+	outputPath      *string
+	gzip            *bool
+	store           *bool
+	source          *string
+}
+
+func setFlags() flags {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
-	domain = flag.String("domain", "", "Domain to check")
-	filePath = flag.String("file", "", "File path to a CSV of domains to check")
-	url = flag.String("url", "", "URL of a CSV of domains to check")
-	column = flag.Int("column", 0, "Zero indexed column of domains")
-	aggregate = flag.Bool("aggregate", false, "Write aggregated MTA-STS statistics to database, specified by ENV")
+	f := flags{
+		domain:          flag.String("domain", "", "Domain to check"),
+		filePath:        flag.String("file", "", "File path to a CSV of domains to check"),
+		url:             flag.String("url", "", "URL of a CSV of domains to check"),
+		zoneFile:        flag.String("zone", "", "File path to a DNS zone file, whose registrable domains will be checked"),
+		resumeAfter:     flag.String("resume-after", "", "Skip zone file domains up to and including this one, to resume an interrupted scan"),
+		column:          flag.Int("column", 0, "Zero indexed column of domains"),
+		aggregate:       flag.Bool("aggregate", false, "Write aggregated MTA-STS statistics to database, specified by ENV"),
+		tlsStats:        flag.Bool("tls-stats", false, "With -aggregate, check each MX hostname too, to count STARTTLS support, valid certificates, failures, negotiated TLS versions and ciphers, and mailserver software"),
+		sni:             flag.Bool("sni", false, "Compare certificates presented with and without SNI"),
+		greylistRetries: flag.Int("greylist-retries", 0, "Number of times to re-check hostnames that respond with a temporary failure"),
+		greylistDelay:   flag.Duration("greylist-delay", time.Minute, "Delay before re-checking hostnames that respond with a temporary failure"),
+		greylistPass:    flag.Duration("greylist-pass-delay", 15*time.Minute, "With -aggregate, re-check domains whose mailservers responded with a temporary failure at the end of the run, this long after the last one"),
+		deadline:        flag.Duration("deadline", 0, "Maximum time to spend checking each domain. Hostnames not checked in time are marked as timed out. 0 for no limit"),
+		statePath:       flag.String("state", "", "File path to census state from a previous run. If set, only domains whose MX records changed are fully checked, and the file is updated"),
+		maxAge:          flag.Duration("max-age", 7*24*time.Hour, "With -state, fully check domains whose previous result is older than this"),
+		sink:            flag.Bool("sink", false, "Export results to the BigQuery or ClickHouse table specified by ENV"),
+		configPath:      flag.String("config", "", "File path to a YAML or JSON checker config. Defaults to CHECKER_CONFIG. Env vars and flags take precedence"),
+		checkpointPath:  flag.String("checkpoint", "", "File path to record the progress of a CSV scan in, so it can be resumed with -resume"),
+		checkpointEvery: flag.Duration("checkpoint-interval", time.Minute, "With -checkpoint, how often to record progress"),
+		resume:          flag.Bool("resume", false, "With -checkpoint, skip the CSV domains that an interrupted run already checked"),
+		outputPath:      flag.String("output", "", "File path to write each domain's full result to, as a line of JSON. Defaults to stdout. With -resume, results are appended"),
+		gzip:            flag.Bool("gzip", false, "Gzip the results written by -output"),
+		store:           flag.Bool("db", false, "Store each domain's result in the scans table of the database specified by ENV"),
+		source:          flag.String("source", string(models.SourceCensus), "With -db, the source to label stored scans with"),
+	}
 
 	flag.Parse()
-	if *domain == "" && *filePath == "" && *url == "" {
-		flag.PrintDefaults()
-		os.Exit(1)
+	return f
+}
+
+// validate checks that the flags that were set can be combined.
+func (f flags) validate() error {
+	if *f.domain == "" && *f.filePath == "" && *f.url == "" && *f.zoneFile == "" {
+		return cli.UsageError("one of domain, file, url or zone is required")
 	}
-	if *domain != "" && (*column != 0 || *aggregate == true) {
-		log.Println("column and aggregate are not supported for single domain checks")
-		flag.PrintDefaults()
-		os.Exit(1)
+	if *f.domain != "" && (*f.column != 0 || *f.aggregate || *f.sink || *f.store) {
+		return cli.UsageError("column, aggregate, sink and db are not supported for single domain checks")
+	}
+	if *f.checkpointPath != "" && (*f.zoneFile != "" || *f.statePath != "") {
+		return cli.UsageError("checkpoint is only supported for CSV scans; use resume-after for zone files")
+	}
+	if *f.resume && *f.checkpointPath == "" {
+		return cli.UsageError("resume requires checkpoint")
 	}
-	return
+	return nil
+}
+
+// loadConfig loads the checker config from the -config file or the
+// environment, overriding it with any checker flags that were set.
+func loadConfig(f flags) (checker.Config, error) {
+	if *f.configPath != "" {
+		os.Setenv("CHECKER_CONFIG", *f.configPath)
+	}
+	cfg, err := checker.ConfigFromEnv()
+	if err != nil {
+		return cfg, err
+	}
+	flag.Visit(func(set *flag.Flag) {
+		switch set.Name {
+		case "greylist-retries":
+			cfg.GreylistRetries = *f.greylistRetries
+		case "greylist-delay":
+			cfg.GreylistRetryDelay = *f.greylistDelay
+		case "deadline":
+			cfg.Deadline = *f.deadline
+		}
+	})
+	return cfg, cfg.Validate()
 }
 
 // Run a series of security checks on an MTA domain.
 // =================================================
 // Validating (START)TLS configurations for all MX domains.
 func main() {
-	domain, filePath, url, column, aggregate := setFlags()
+	cmd := cli.New("starttls-check")
+	f := setFlags()
+	cmd.Run(func(ctx context.Context) error {
+		return run(ctx, f)
+	})
+}
 
-	c := checker.Checker{
-		Cache: checker.MakeSimpleCache(10 * time.Minute),
+// run checks the domains given by f. Once ctx is done, no more domains are
+// started, and the results so far are written out as usual.
+func run(ctx context.Context, f flags) error {
+	if err := f.validate(); err != nil {
+		return err
 	}
-	var resultHandler checker.ResultHandler
-	resultHandler = &domainWriter{}
-
-	if *domain != "" {
+	cfg, err := loadConfig(f)
+	if err != nil {
+		return cli.ConfigError(err)
+	}
+	checker.Configure(cfg)
+	c := cfg.NewChecker()
+	if *f.sni {
+		c.HostnameCheck = checker.SNIHostnameCheck
+	}
+	if *f.domain != "" {
 		// Handle single domain and return
-		result := c.CheckDomain(*domain, nil)
-		resultHandler.HandleDomain(result)
-		os.Exit(0)
+		results, err := openResults(f)
+		if err != nil {
+			return err
+		}
+		results.HandleDomain(c.CheckDomainContext(ctx, *f.domain, nil))
+		if err = results.Close(); err != nil {
+			return err
+		}
+		return ctx.Err()
 	}
 
 	var instream io.Reader
 	var label string
-	if *filePath != "" {
-		csvFile, err := os.Open(*filePath)
-		defer csvFile.Close()
+	if *f.filePath != "" || *f.zoneFile != "" {
+		path := *f.filePath
+		if path == "" {
+			path = *f.zoneFile
+		}
+		file, err := os.Open(path)
 		if err != nil {
-			log.Println(err)
-			os.Exit(1)
+			return err
 		}
-		instream = bufio.NewReader(csvFile)
-		label = csvFile.Name()
+		defer file.Close()
+		instream = bufio.NewReader(file)
+		label = file.Name()
 	} else {
-		resp, err := http.Get(*url)
+		resp, err := http.Get(*f.url)
 		if err != nil {
-			log.Println(err)
-			os.Exit(1)
+			return err
 		}
+		defer resp.Body.Close()
 		instream = resp.Body
-		label = *url
+		label = *f.url
 	}
 
-	domainReader := csv.NewReader(instream)
-	if *aggregate {
-		c = checker.Checker{
-			CheckHostname: checker.NoopCheckHostname,
+	var source checker.DomainSource
+	var csvReader *csv.Reader
+	if *f.zoneFile != "" {
+		source = checker.NewZoneFileSource(instream, *f.resumeAfter)
+	} else {
+		csvReader = csv.NewReader(instream)
+		source = checker.NewCSVSource(csvReader, *f.column)
+	}
+	source = contextSource{ctx: ctx, DomainSource: source}
+	// Every requested output is written in a single pass over the domains.
+	handlers := checker.MultiHandler{}
+	var results *checker.JSONLinesHandler
+	if *f.outputPath != "" {
+		if results, err = openResults(f); err != nil {
+			return err
 		}
-		resultHandler = &checker.AggregatedScan{
+		handlers = append(handlers, results)
+	}
+	var aggregated *checker.AggregatedScan
+	if *f.aggregate {
+		if !*f.tlsStats {
+			c = &checker.Checker{
+				HostnameCheck: checker.NoopHostnameCheck,
+				PoolSize:      cfg.PoolSize,
+			}
+		}
+		aggregated = &checker.AggregatedScan{
 			Time:   time.Now(),
 			Source: label,
 		}
+		handlers = append(handlers, aggregated)
+	}
+	if *f.sink {
+		sink, err := checker.SinkFromEnv()
+		if err != nil {
+			return cli.ConfigError(err)
+		}
+		if err = sink.EnsureTable(); err != nil {
+			return cli.ConfigError(err)
+		}
+		handlers = append(handlers, &checker.SinkHandler{Sink: sink, Source: label})
+	}
+	if *f.store {
+		scanHandler, err := openScanHandler(*f.source, *f.aggregate && !*f.tlsStats, c.Settings())
+		if err != nil {
+			return cli.ConfigError(err)
+		}
+		handlers = append(handlers, scanHandler)
 	}
-	c.CheckCSV(domainReader, resultHandler, *column)
-	json.NewEncoder(out).Encode(resultHandler)
+	if len(handlers) == 0 {
+		if results, err = openResults(f); err != nil {
+			return err
+		}
+		handlers = append(handlers, results)
+	}
+	var resultHandler checker.ResultHandler = handlers
+	if len(handlers) == 1 {
+		resultHandler = handlers[0]
+	}
+	if *f.statePath != "" {
+		if err := checkIncremental(c, source, resultHandler, *f.statePath, *f.maxAge); err != nil {
+			return err
+		}
+	} else if *f.checkpointPath != "" {
+		// Checkpointed scans read the CSV themselves, so they aren't stopped
+		// by ctx; interrupt them again and -resume instead.
+		c.Checkpoint = checker.FileCheckpoint(*f.checkpointPath)
+		c.CheckpointInterval = *f.checkpointEvery
+		if *f.resume {
+			if err := c.ResumeCSV(csvReader, resultHandler, *f.column); err != nil {
+				return err
+			}
+		} else {
+			c.CheckCSV(csvReader, resultHandler, *f.column)
+		}
+	} else {
+		c.CheckSource(source, resultHandler)
+	}
+	if aggregated != nil && aggregated.TemporaryErrors > 0 && ctx.Err() == nil {
+		log.Printf("Retrying %d domains whose MX lookups failed temporarily", aggregated.TemporaryErrors)
+		c.RetryTemporaryErrors(aggregated)
+	}
+	if aggregated != nil && aggregated.Greylisted > 0 && ctx.Err() == nil {
+		log.Printf("Retrying %d domains whose mailservers greylisted us", aggregated.Greylisted)
+		c.RetryGreylisted(aggregated, *f.greylistPass)
+	}
+	if err := handlers.Flush(); err != nil {
+		return err
+	}
+	if results != nil {
+		if err := results.Close(); err != nil {
+			return err
+		}
+		log.Printf("Wrote %d results, %d failed", results.Written, results.Failed)
+		if *f.outputPath == "" {
+			// Results were written to out.
+			return ctx.Err()
+		}
+	}
+	if err := json.NewEncoder(out).Encode(resultHandler); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// contextSource stops producing domains once ctx is done.
+type contextSource struct {
+	ctx context.Context
+	checker.DomainSource
+}
+
+func (s contextSource) Next() (string, error) {
+	if s.ctx.Err() != nil {
+		return "", io.EOF
+	}
+	return s.DomainSource.Next()
 }
 
-type domainWriter struct{}
+// openScanHandler connects to the database specified by ENV, and creates a
+// handler storing results there as scans labelled with source, and the
+// settings of the checker. Aggregated scans don't check hostnames, so they're
+// stored as quick scans.
+func openScanHandler(source string, quick bool, settings checker.Settings) (*models.ScanHandler, error) {
+	cfg, err := db.LoadEnvironmentVariables()
+	if err != nil {
+		return nil, err
+	}
+	database, err := db.InitSQLDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	h := &models.ScanHandler{Store: database, Source: models.ScanSource(source), Profile: models.ProfileFull,
+		Checker: &settings}
+	if quick {
+		h.Profile = models.ProfileQuick
+	}
+	return h, nil
+}
 
-func (w domainWriter) HandleDomain(r checker.DomainResult) {
-	b, err := json.Marshal(r)
+// openResults creates the handler that writes each domain's result to the
+// -output file, or to out.
+func openResults(f flags) (*checker.JSONLinesHandler, error) {
+	if *f.outputPath == "" {
+		return checker.NewJSONLinesHandler(out, *f.gzip), nil
+	}
+	mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if *f.resume {
+		// Gzip streams can be concatenated, so compressed results can be
+		// appended to as well.
+		mode = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(*f.outputPath, mode, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return checker.NewJSONLinesHandler(file, *f.gzip), nil
+}
+
+// checkIncremental loads census state from statePath, only fully checks
+// domains that have changed, and writes the updated state back.
+func checkIncremental(c *checker.Checker, source checker.DomainSource, resultHandler checker.ResultHandler,
+	statePath string, maxAge time.Duration) error {
+	state := checker.NewMemoryCensusState()
+	if stateFile, err := os.Open(statePath); err == nil {
+		err = state.Load(bufio.NewReader(stateFile))
+		stateFile.Close()
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	c.CheckDomainsIncremental(source, resultHandler, state, maxAge)
+	stateFile, err := os.Create(statePath)
 	if err != nil {
-		log.Println(err)
-		os.Exit(1)
+		return err
 	}
-	fmt.Fprintln(out, string(b))
+	defer stateFile.Close()
+	return state.Save(stateFile)
 }
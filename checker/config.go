@@ -0,0 +1,439 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the checker's settings. Durations are written like "10s" or
+// "5m". Use DefaultConfig, ReadConfig or ConfigFromEnv to get one with every
+// setting filled in.
+type Config struct {
+	// Timeout for each network request made during checks.
+	Timeout time.Duration `yaml:"timeout"`
+	// Deadline for each domain check. 0 for no deadline.
+	Deadline time.Duration `yaml:"deadline"`
+	// GreylistRetries and GreylistRetryDelay control re-checks of hostnames
+	// that respond with a temporary failure.
+	GreylistRetries    int           `yaml:"greylist_retries"`
+	GreylistRetryDelay time.Duration `yaml:"greylist_retry_delay"`
+	// CacheExpiry is how long hostname results are cached. 0 disables caching.
+	CacheExpiry time.Duration `yaml:"cache_expiry"`
+	// StaleWhileRevalidate is how long expired hostname results may be
+	// served while they're refreshed.
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"`
+	// CacheJitter is up to how much earlier than CacheExpiry each hostname
+	// result expires, so results cached together aren't all re-checked
+	// together.
+	CacheJitter time.Duration `yaml:"cache_jitter"`
+	// CacheMaxEntries is the most hostname results cached in memory, after
+	// which the least recently used are evicted. 0 for no limit.
+	CacheMaxEntries int `yaml:"cache_max_entries"`
+	// DomainCacheExpiry is how long full domain results, including their
+	// MTA-STS results, are cached in memory. 0, the default, disables
+	// domain caching.
+	DomainCacheExpiry time.Duration `yaml:"domain_cache_expiry"`
+	// Memcached is the "host:port" address of a memcached server to cache
+	// hostname results in. If empty, they're cached in memory.
+	Memcached string `yaml:"memcached"`
+	// MemcachedEncoding is how results are stored in memcached: "json" (the
+	// default), "gob" or "msgpack".
+	MemcachedEncoding string `yaml:"memcached_encoding"`
+	// PoolSize is the number of domains checked at once by bulk scans.
+	PoolSize int `yaml:"pool_size"`
+	// FeedTimeout is how long to wait for reputation feeds to annotate each
+	// domain check.
+	FeedTimeout time.Duration `yaml:"feed_timeout"`
+
+	// The remaining settings are shared by every Checker in the process, and
+	// take effect with Configure.
+
+	// EHLOHostname is the name we greet mailservers with. It should resolve
+	// to the address we scan from, so that operators can tell who's
+	// connecting to them. If it's empty, HOSTNAME is used. See
+	// ScannerInfoURL.
+	EHLOHostname string `yaml:"ehlo_hostname"`
+	// SMTPPort is the port mailservers are dialed on.
+	SMTPPort int `yaml:"smtp_port"`
+	// Resolver is the "host:port" address of the DNS server to query. If
+	// empty, the system's resolver is used.
+	Resolver string `yaml:"resolver"`
+	// Proxy is the URL of a SOCKS5 or HTTP proxy, like socks5://host:1080,
+	// to connect to mailservers and fetch MTA-STS policies through. If
+	// empty, connections are made directly.
+	Proxy string `yaml:"proxy"`
+	// MaxConnections and MaxConnectionBytes limit the checker's open sockets
+	// and the data read from each one.
+	MaxConnections     int `yaml:"max_connections"`
+	MaxConnectionBytes int `yaml:"max_connection_bytes"`
+	// MaxHostConnections and MaxHostConnectionsPerMinute limit connections to
+	// any one mailserver, so we don't get blocklisted.
+	MaxHostConnections          int `yaml:"max_host_connections"`
+	MaxHostConnectionsPerMinute int `yaml:"max_host_connections_per_minute"`
+	// Feeds are external reputation feeds that annotate check results.
+	Feeds []FeedConfig `yaml:"feeds"`
+	// FakeNetwork replaces DNS lookups, SMTP connections and MTA-STS policy
+	// fetches with deterministic in-process fakes, for end-to-end tests. See
+	// FakeScenarios.
+	FakeNetwork bool `yaml:"fake_network"`
+}
+
+// FeedConfig describes a reputation feed.
+type FeedConfig struct {
+	// Name identifies the feed in annotations.
+	Name string `yaml:"name"`
+	// Type is "list", for a list of compromised hostnames or SPKI hashes, or
+	// "crl", for a certificate revocation list.
+	Type string `yaml:"type"`
+	// Source is the URL or file path the feed is read from.
+	Source string `yaml:"source"`
+	// Refresh is how often the feed is re-read. 0 for hourly.
+	Refresh time.Duration `yaml:"refresh"`
+}
+
+// NewFeed returns the Feed fc describes.
+func (fc FeedConfig) NewFeed() (Feed, error) {
+	switch fc.Type {
+	case "list":
+		return NewListFeed(fc.Name, fc.Source, fc.Refresh), nil
+	case "crl":
+		return NewCRLFeed(fc.Name, fc.Source, fc.Refresh), nil
+	}
+	return nil, fmt.Errorf("feed %s has unknown type %q; must be list or crl", fc.Name, fc.Type)
+}
+
+// DefaultConfig returns the settings used when nothing is configured.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:            10 * time.Second,
+		GreylistRetryDelay: time.Minute,
+		CacheExpiry:        10 * time.Minute,
+		CacheMaxEntries:    defaultCacheMaxEntries,
+		PoolSize:           defaultPoolSize,
+		FeedTimeout:        defaultFeedTimeout,
+		SMTPPort:           25,
+		MaxConnections:     defaultMaxConnections,
+		MaxConnectionBytes: defaultMaxConnectionBytes,
+
+		MaxHostConnections:          defaultMaxHostConnections,
+		MaxHostConnectionsPerMinute: defaultMaxHostConnectionsPerMinute,
+	}
+}
+
+// Validate returns an error describing the first invalid setting in cfg.
+func (cfg Config) Validate() error {
+	switch {
+	case cfg.Timeout <= 0:
+		return fmt.Errorf("timeout must be positive, not %v", cfg.Timeout)
+	case cfg.Deadline < 0:
+		return fmt.Errorf("deadline can't be negative")
+	case cfg.GreylistRetries < 0:
+		return fmt.Errorf("greylist_retries can't be negative")
+	case cfg.GreylistRetryDelay <= 0:
+		return fmt.Errorf("greylist_retry_delay must be positive, not %v", cfg.GreylistRetryDelay)
+	case cfg.CacheExpiry < 0:
+		return fmt.Errorf("cache_expiry can't be negative")
+	case cfg.StaleWhileRevalidate < 0:
+		return fmt.Errorf("stale_while_revalidate can't be negative")
+	case cfg.CacheJitter < 0 || cfg.CacheJitter > cfg.CacheExpiry:
+		return fmt.Errorf("cache_jitter must be between 0 and cache_expiry, not %v", cfg.CacheJitter)
+	case cfg.CacheMaxEntries < 0:
+		return fmt.Errorf("cache_max_entries can't be negative")
+	case cfg.DomainCacheExpiry < 0:
+		return fmt.Errorf("domain_cache_expiry can't be negative")
+	case cfg.PoolSize <= 0:
+		return fmt.Errorf("pool_size must be positive, not %d", cfg.PoolSize)
+	case cfg.FeedTimeout <= 0:
+		return fmt.Errorf("feed_timeout must be positive, not %v", cfg.FeedTimeout)
+	case cfg.SMTPPort <= 0 || cfg.SMTPPort > 65535:
+		return fmt.Errorf("smtp_port %d is out of range", cfg.SMTPPort)
+	case cfg.MaxConnections <= 0:
+		return fmt.Errorf("max_connections must be positive, not %d", cfg.MaxConnections)
+	case cfg.MaxConnectionBytes <= 0:
+		return fmt.Errorf("max_connection_bytes must be positive, not %d", cfg.MaxConnectionBytes)
+	case cfg.MaxHostConnections <= 0:
+		return fmt.Errorf("max_host_connections must be positive, not %d", cfg.MaxHostConnections)
+	case cfg.MaxHostConnectionsPerMinute <= 0:
+		return fmt.Errorf("max_host_connections_per_minute must be positive, not %d", cfg.MaxHostConnectionsPerMinute)
+	}
+	if cfg.Resolver != "" {
+		if _, _, err := net.SplitHostPort(cfg.Resolver); err != nil {
+			return fmt.Errorf("resolver must be host:port: %v", err)
+		}
+	}
+	if cfg.Memcached != "" {
+		if _, err := NewMemcachedStore(cfg.Memcached, cfg.MemcachedEncoding); err != nil {
+			return err
+		}
+	}
+	if cfg.Proxy != "" {
+		if _, err := parseProxyURL(cfg.Proxy); err != nil {
+			return fmt.Errorf("invalid proxy: %v", err)
+		}
+	}
+	for _, fc := range cfg.Feeds {
+		if fc.Name == "" || fc.Source == "" {
+			return fmt.Errorf("each feed needs a name and a source")
+		}
+		if fc.Refresh < 0 {
+			return fmt.Errorf("refresh of feed %s can't be negative", fc.Name)
+		}
+		if _, err := fc.NewFeed(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadConfig reads settings from the YAML or JSON file at path. Settings the
+// file leaves out keep their default values.
+func ReadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if err := cfg.readFile(path); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func (cfg *Config) readFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// YAML is a superset of JSON, so this handles both.
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("couldn't parse checker config %s: %v", path, err)
+	}
+	return nil
+}
+
+// ConfigFromEnv loads settings from the file named by CHECKER_CONFIG, if it's
+// set, and then from the env vars listed in .env.example, which take
+// precedence.
+func ConfigFromEnv() (Config, error) {
+	return ConfigFromEnvWithDefaults(DefaultConfig())
+}
+
+// ConfigFromEnvWithDefaults is like ConfigFromEnv, but settings that aren't
+// configured keep their values in cfg rather than DefaultConfig's.
+func ConfigFromEnvWithDefaults(cfg Config) (Config, error) {
+	if path := os.Getenv("CHECKER_CONFIG"); path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return cfg, err
+		}
+	}
+	env := envReader{}
+	env.duration("CHECKER_TIMEOUT", &cfg.Timeout)
+	env.duration("CHECKER_DEADLINE", &cfg.Deadline)
+	env.int("CHECKER_GREYLIST_RETRIES", &cfg.GreylistRetries)
+	env.duration("CHECKER_GREYLIST_RETRY_DELAY", &cfg.GreylistRetryDelay)
+	env.duration("CHECKER_CACHE_EXPIRY", &cfg.CacheExpiry)
+	env.duration("CHECKER_STALE_WHILE_REVALIDATE", &cfg.StaleWhileRevalidate)
+	env.duration("CHECKER_CACHE_JITTER", &cfg.CacheJitter)
+	env.int("CHECKER_CACHE_MAX_ENTRIES", &cfg.CacheMaxEntries)
+	env.duration("CHECKER_DOMAIN_CACHE_EXPIRY", &cfg.DomainCacheExpiry)
+	if address := os.Getenv("CHECKER_MEMCACHED"); address != "" {
+		cfg.Memcached = address
+	}
+	if encoding := os.Getenv("CHECKER_MEMCACHED_ENCODING"); encoding != "" {
+		cfg.MemcachedEncoding = encoding
+	}
+	env.int("CONNECTION_POOL_SIZE", &cfg.PoolSize)
+	env.duration("CHECKER_FEED_TIMEOUT", &cfg.FeedTimeout)
+	if hostname := os.Getenv("CHECKER_EHLO_HOSTNAME"); hostname != "" {
+		cfg.EHLOHostname = hostname
+	}
+	env.int("CHECKER_SMTP_PORT", &cfg.SMTPPort)
+	if resolver := os.Getenv("CHECKER_RESOLVER"); resolver != "" {
+		cfg.Resolver = resolver
+	}
+	if proxyURL := os.Getenv("CHECKER_PROXY"); proxyURL != "" {
+		cfg.Proxy = proxyURL
+	}
+	env.int("CHECKER_MAX_CONNECTIONS", &cfg.MaxConnections)
+	env.int("CHECKER_MAX_CONNECTION_BYTES", &cfg.MaxConnectionBytes)
+	env.int("CHECKER_MAX_HOST_CONNECTIONS", &cfg.MaxHostConnections)
+	env.int("CHECKER_MAX_HOST_CONNECTIONS_PER_MINUTE", &cfg.MaxHostConnectionsPerMinute)
+	if os.Getenv("CHECKER_FAKE_NETWORK") == "1" {
+		cfg.FakeNetwork = true
+	}
+	if env.err != nil {
+		return cfg, env.err
+	}
+	return cfg, cfg.Validate()
+}
+
+// envReader parses env vars into settings, keeping the first error.
+type envReader struct {
+	err error
+}
+
+func (e *envReader) int(varName string, setting *int) {
+	value := os.Getenv(varName)
+	if value == "" || e.err != nil {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.err = fmt.Errorf("%s must be an integer: %v", varName, err)
+		return
+	}
+	*setting = n
+}
+
+func (e *envReader) duration(varName string, setting *time.Duration) {
+	value := os.Getenv(varName)
+	if value == "" || e.err != nil {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		e.err = fmt.Errorf("%s must be a duration like 10s: %v", varName, err)
+		return
+	}
+	*setting = d
+}
+
+// NewChecker returns a Checker with cfg's settings, caching results in memory,
+// or in memcached if cfg.Memcached is set, if cfg.CacheExpiry is set. Domain
+// results are cached in memory if cfg.DomainCacheExpiry is set.
+func (cfg Config) NewChecker() *Checker {
+	c := &Checker{
+		Timeout:            cfg.Timeout,
+		Deadline:           cfg.Deadline,
+		GreylistRetries:    cfg.GreylistRetries,
+		GreylistRetryDelay: cfg.GreylistRetryDelay,
+		PoolSize:           cfg.PoolSize,
+		FeedTimeout:        cfg.FeedTimeout,
+	}
+	if cfg.CacheExpiry > 0 {
+		c.Cache = MakeBoundedCache(cfg.CacheExpiry, cfg.CacheMaxEntries)
+		c.Cache.StaleWhileRevalidate = cfg.StaleWhileRevalidate
+		c.Cache.Jitter = cfg.CacheJitter
+		if cfg.Memcached != "" {
+			if store, err := NewMemcachedStore(cfg.Memcached, cfg.MemcachedEncoding); err == nil {
+				store.Expiry = cfg.CacheExpiry + cfg.StaleWhileRevalidate
+				c.Cache.ScanStore = store
+			}
+		}
+	}
+	if cfg.DomainCacheExpiry > 0 {
+		c.DomainCache = NewDomainCache(cfg.DomainCacheExpiry, cfg.CacheMaxEntries)
+	}
+	return c
+}
+
+// network holds the process-wide network settings.
+var network = struct {
+	sync.RWMutex
+	ehloHostname string
+	smtpPort     string
+	resolver     string
+	proxy        *url.URL
+	// transport fetches MTA-STS policies through proxy. If nil, the default
+	// transport is used.
+	transport http.RoundTripper
+	// fake, if set, answers every lookup and connection instead.
+	fake *fakeNetwork
+}{smtpPort: "25"}
+
+// Configure applies cfg's process-wide settings: the EHLO hostname, SMTP
+// port, DNS resolver, proxy or fake network, resource limits and reputation
+// feeds. cfg should be valid. It should be called before any checks run,
+// since resource limits can't change once connections have been made.
+func Configure(cfg Config) {
+	network.Lock()
+	network.ehloHostname = cfg.EHLOHostname
+	network.smtpPort = strconv.Itoa(cfg.SMTPPort)
+	network.resolver = cfg.Resolver
+	network.proxy, network.transport, network.fake = nil, nil, nil
+	if cfg.FakeNetwork {
+		network.fake = newFakeNetwork()
+		network.transport = network.fake
+	} else if proxyURL, err := parseProxyURL(cfg.Proxy); err == nil {
+		network.proxy = proxyURL
+		network.transport = newProxyTransport()
+	}
+	network.Unlock()
+	var feeds []Feed
+	for _, fc := range cfg.Feeds {
+		if feed, err := fc.NewFeed(); err == nil {
+			feeds = append(feeds, feed)
+		}
+	}
+	configuredFeeds.Lock()
+	configuredFeeds.feeds = feeds
+	configuredFeeds.Unlock()
+	processLimiterOnce.Do(func() {
+		processLimiter = newProcessLimiter(cfg)
+	})
+}
+
+func smtpPort() string {
+	network.RLock()
+	defer network.RUnlock()
+	return network.smtpPort
+}
+
+func proxyURL() *url.URL {
+	network.RLock()
+	defer network.RUnlock()
+	return network.proxy
+}
+
+func httpTransport() http.RoundTripper {
+	network.RLock()
+	defer network.RUnlock()
+	return network.transport
+}
+
+// newResolver returns a resolver that queries the configured DNS server, or
+// the system's if there isn't one.
+func newResolver() *net.Resolver {
+	network.RLock()
+	address, fake := network.resolver, network.fake
+	network.RUnlock()
+	if fake != nil {
+		return &net.Resolver{PreferGo: true, Dial: fake.dialDNS}
+	}
+	if address == "" {
+		return &net.Resolver{}
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, protocol, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, protocol, address)
+		},
+	}
+}
+
+// nameserverAddress returns the address of the DNS server to send raw queries
+// to: the configured resolver, or the system's first nameserver.
+func nameserverAddress() (string, error) {
+	network.RLock()
+	address := network.resolver
+	network.RUnlock()
+	if address != "" {
+		return address, nil
+	}
+	nameserver, err := systemNameserver()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(nameserver, "53"), nil
+}
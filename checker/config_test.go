@@ -0,0 +1,130 @@
+package checker
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "checker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestReadConfig(t *testing.T) {
+	tests := []string{
+		"timeout: 5s\npool_size: 64\nresolver: 127.0.0.1:53\nehlo_hostname: scanner.example.org\n",
+		`{"timeout": "5s", "pool_size": 64, "resolver": "127.0.0.1:53", "ehlo_hostname": "scanner.example.org"}`,
+	}
+	for _, contents := range tests {
+		path := writeConfig(t, contents)
+		defer os.Remove(path)
+		cfg, err := ReadConfig(path)
+		if err != nil {
+			t.Fatalf("Couldn't read %s: %v", contents, err)
+		}
+		if cfg.Timeout != 5*time.Second || cfg.PoolSize != 64 || cfg.Resolver != "127.0.0.1:53" ||
+			cfg.EHLOHostname != "scanner.example.org" {
+			t.Errorf("Settings weren't read from %s: %+v", contents, cfg)
+		}
+		if cfg.SMTPPort != 25 || cfg.GreylistRetryDelay != time.Minute {
+			t.Errorf("Expected settings missing from %s to keep defaults, got %+v", contents, cfg)
+		}
+	}
+}
+
+func TestReadConfigRejectsInvalidSettings(t *testing.T) {
+	tests := []string{
+		"timeuot: 5s\n",
+		"timeout: five seconds\n",
+		"smtp_port: 70000\n",
+		"resolver: 127.0.0.1\n",
+		"pool_size: 0\n",
+		"cache_expiry: 10m\ncache_jitter: 20m\n",
+		"cache_max_entries: -1\n",
+		"domain_cache_expiry: -1m\n",
+		"memcached: localhost:11211\nmemcached_encoding: xml\n",
+		"feeds:\n- name: bad\n  type: rbl\n  source: bad.txt\n",
+		"feeds:\n- type: list\n  source: list.txt\n",
+	}
+	for _, contents := range tests {
+		path := writeConfig(t, contents)
+		defer os.Remove(path)
+		if _, err := ReadConfig(path); err == nil {
+			t.Errorf("Expected error reading %q", contents)
+		}
+	}
+}
+
+func TestConfigFromEnvOverridesFile(t *testing.T) {
+	path := writeConfig(t, "timeout: 5s\npool_size: 64\nehlo_hostname: scanner.example.org\n")
+	defer os.Remove(path)
+	for _, varName := range []string{"CHECKER_CONFIG", "CONNECTION_POOL_SIZE", "CHECKER_SMTP_PORT", "CHECKER_EHLO_HOSTNAME"} {
+		defer os.Setenv(varName, os.Getenv(varName))
+	}
+	os.Setenv("CHECKER_CONFIG", path)
+	os.Setenv("CHECKER_EHLO_HOSTNAME", "mx-scanner.example.org")
+	os.Setenv("CONNECTION_POOL_SIZE", "8")
+	os.Setenv("CHECKER_SMTP_PORT", "")
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Timeout != 5*time.Second || cfg.PoolSize != 8 || cfg.SMTPPort != 25 || cfg.EHLOHostname != "mx-scanner.example.org" {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+
+	os.Setenv("CONNECTION_POOL_SIZE", "lots")
+	if _, err = ConfigFromEnv(); err == nil {
+		t.Error("Expected error for invalid CONNECTION_POOL_SIZE")
+	}
+}
+
+func TestConfigFromEnvWithDefaults(t *testing.T) {
+	for _, varName := range []string{"CHECKER_CONFIG", "CHECKER_TIMEOUT", "CHECKER_DEADLINE", "CHECKER_EHLO_HOSTNAME"} {
+		defer os.Setenv(varName, os.Getenv(varName))
+		os.Setenv(varName, "")
+	}
+	os.Setenv("CHECKER_TIMEOUT", "4s")
+	os.Setenv("CHECKER_EHLO_HOSTNAME", "scanner.example.org")
+	defaults := DefaultConfig()
+	defaults.Timeout, defaults.Deadline = time.Second, time.Minute
+	cfg, err := ConfigFromEnvWithDefaults(defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Timeout != 4*time.Second || cfg.Deadline != time.Minute {
+		t.Errorf("Expected the configured timeout and the default deadline, got %+v", cfg)
+	}
+}
+
+func TestConfigNewChecker(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Deadline = time.Minute
+	c := cfg.NewChecker()
+	if c.Timeout != cfg.Timeout || c.Deadline != time.Minute || c.poolSize() != defaultPoolSize {
+		t.Errorf("Checker doesn't match config: %+v", c)
+	}
+	if c.Cache == nil || c.Cache.ExpireTime != cfg.CacheExpiry {
+		t.Errorf("Expected checker to cache results for %v", cfg.CacheExpiry)
+	}
+	cfg.CacheExpiry = 0
+	if cfg.NewChecker().Cache != nil {
+		t.Error("Expected no cache when cache_expiry is 0")
+	}
+	if c.DomainCache != nil {
+		t.Error("Expected no domain cache by default")
+	}
+	cfg.DomainCacheExpiry = time.Minute
+	if c = cfg.NewChecker(); c.DomainCache == nil || c.DomainCache.ExpireTime != time.Minute {
+		t.Errorf("Expected checker to cache domain results for a minute")
+	}
+}
@@ -0,0 +1,78 @@
+package checker
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// TLSA certificate usages, selectors and matching types (RFC 6698, RFC 7218).
+const (
+	TLSAUsageDANETA  = 2 // Trust anchor: a CA certificate in the server's chain.
+	TLSAUsageDANEEE  = 3 // End entity: the server's own certificate.
+	TLSASelectorSPKI = 1 // Match the certificate's public key.
+	TLSAMatchSHA256  = 1 // Match a SHA-256 hash.
+)
+
+// TLSARecord is a recommended DANE TLSA record for a mailserver.
+type TLSARecord struct {
+	// Name is the record's owner name, eg. _25._tcp.mx.example.com.
+	Name         string `json:"name"`
+	Usage        int    `json:"usage"`
+	Selector     int    `json:"selector"`
+	MatchingType int    `json:"matching_type"`
+	Data         string `json:"data"`
+	// Record is the record in zone file format.
+	Record string `json:"record"`
+}
+
+// spkiSHA256 returns the hex-encoded SHA-256 hash of a certificate's public
+// key, as used in "x 1 1" TLSA records.
+func spkiSHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// certSPKIHashes returns the public key hashes of the certificate presented
+// over client, and of the certificate that issued it, if the server sent one.
+func certSPKIHashes(client *smtp.Client) (string, string) {
+	state, ok := client.TLSConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		return "", ""
+	}
+	leaf := spkiSHA256(state.PeerCertificates[0])
+	if len(state.PeerCertificates) < 2 {
+		return leaf, ""
+	}
+	return leaf, spkiSHA256(state.PeerCertificates[1])
+}
+
+func newTLSARecord(hostname string, usage int, data string) TLSARecord {
+	name := fmt.Sprintf("_%s._tcp.%s.", smtpPort(), strings.TrimSuffix(hostname, "."))
+	return TLSARecord{
+		Name:         name,
+		Usage:        usage,
+		Selector:     TLSASelectorSPKI,
+		MatchingType: TLSAMatchSHA256,
+		Data:         data,
+		Record:       fmt.Sprintf("%s IN TLSA %d %d %d %s", name, usage, TLSASelectorSPKI, TLSAMatchSHA256, data),
+	}
+}
+
+// GenerateTLSA returns the TLSA records matching the certificate observed in
+// a hostname result: a "3 1 1" record pinning the server's public key, and a
+// "2 1 1" record pinning its issuer's, if the server sent its chain. It
+// returns nil if no certificate was observed.
+func GenerateTLSA(result HostnameResult) []TLSARecord {
+	if result.CertSPKISHA256 == "" {
+		return nil
+	}
+	records := []TLSARecord{newTLSARecord(result.Hostname, TLSAUsageDANEEE, result.CertSPKISHA256)}
+	if result.IssuerSPKISHA256 != "" {
+		records = append(records, newTLSARecord(result.Hostname, TLSAUsageDANETA, result.IssuerSPKISHA256))
+	}
+	return records
+}
@@ -0,0 +1,41 @@
+package checker
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+)
+
+func TestSPKISHA256(t *testing.T) {
+	block, _ := pem.Decode([]byte(certString))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	if hash := spkiSHA256(cert); hash != hex.EncodeToString(sum[:]) || len(hash) != 64 {
+		t.Errorf("Unexpected public key hash %s", hash)
+	}
+}
+
+func TestGenerateTLSA(t *testing.T) {
+	if records := GenerateTLSA(HostnameResult{Hostname: "mx.example.com"}); records != nil {
+		t.Errorf("Expected no records without a certificate, got %v", records)
+	}
+
+	records := GenerateTLSA(HostnameResult{Hostname: "mx.example.com.", CertSPKISHA256: "aa11"})
+	if len(records) != 1 {
+		t.Fatalf("Expected a single record without an issuer, got %v", records)
+	}
+	if records[0].Record != "_25._tcp.mx.example.com. IN TLSA 3 1 1 aa11" {
+		t.Errorf("Unexpected record %s", records[0].Record)
+	}
+
+	records = GenerateTLSA(HostnameResult{Hostname: "mx.example.com", CertSPKISHA256: "aa11", IssuerSPKISHA256: "bb22"})
+	if len(records) != 2 || records[1].Usage != TLSAUsageDANETA || records[1].Data != "bb22" ||
+		records[1].Name != "_25._tcp.mx.example.com." {
+		t.Errorf("Expected end entity and trust anchor records, got %v", records)
+	}
+}
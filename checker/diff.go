@@ -0,0 +1,148 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CheckChange is a check whose status changed between two scans of a domain.
+type CheckChange struct {
+	// Hostname the check ran against, or empty for domain-level checks like
+	// MTA-STS.
+	Hostname string `json:"hostname,omitempty"`
+	// Check is the check's name. Subchecks are joined to their parent's name
+	// with a "/", eg. "mta-sts/mta-sts-policy-file".
+	Check string `json:"check"`
+	// Old and New are nil if the check didn't run in that scan.
+	Old *Status `json:"old"`
+	New *Status `json:"new"`
+	// Messages from the check in the newer scan.
+	Messages []string `json:"messages,omitempty"`
+}
+
+func (c CheckChange) String() string {
+	statusName := func(s *Status) string {
+		if s == nil {
+			return "missing"
+		}
+		return statusText[*s]
+	}
+	name := c.Check
+	if c.Hostname != "" {
+		name = c.Hostname + " " + name
+	}
+	return fmt.Sprintf("%s: %s -> %s", name, statusName(c.Old), statusName(c.New))
+}
+
+// ScanDiff summarizes what changed between two scans of a domain.
+type ScanDiff struct {
+	Domain    string       `json:"domain"`
+	OldStatus DomainStatus `json:"old_status"`
+	NewStatus DomainStatus `json:"new_status"`
+	// MX hostnames that were only checked in the newer or older scan.
+	AddedHostnames   []string      `json:"added_hostnames,omitempty"`
+	RemovedHostnames []string      `json:"removed_hostnames,omitempty"`
+	Changes          []CheckChange `json:"changes"`
+}
+
+// Changed returns true if anything differs between the two scans.
+func (d ScanDiff) Changed() bool {
+	return d.OldStatus != d.NewStatus || len(d.AddedHostnames) > 0 ||
+		len(d.RemovedHostnames) > 0 || len(d.Changes) > 0
+}
+
+func (d ScanDiff) String() string {
+	lines := []string{}
+	for _, hostname := range d.AddedHostnames {
+		lines = append(lines, "added "+hostname)
+	}
+	for _, hostname := range d.RemovedHostnames {
+		lines = append(lines, "removed "+hostname)
+	}
+	for _, change := range d.Changes {
+		lines = append(lines, change.String())
+	}
+	return strings.Join(lines, "; ")
+}
+
+// Diff reports which checks changed status between old and new scans of the
+// same domain. Changes are sorted by hostname, then check name.
+func Diff(old, new DomainResult) ScanDiff {
+	diff := ScanDiff{
+		Domain:    new.Domain,
+		OldStatus: old.Status,
+		NewStatus: new.Status,
+		Changes:   []CheckChange{},
+	}
+	for hostname := range new.HostnameResults {
+		if _, ok := old.HostnameResults[hostname]; !ok {
+			diff.AddedHostnames = append(diff.AddedHostnames, hostname)
+		}
+	}
+	for hostname := range old.HostnameResults {
+		if _, ok := new.HostnameResults[hostname]; !ok {
+			diff.RemovedHostnames = append(diff.RemovedHostnames, hostname)
+		}
+	}
+	sort.Strings(diff.AddedHostnames)
+	sort.Strings(diff.RemovedHostnames)
+
+	oldChecks := flattenDomainChecks(old)
+	for key, newResult := range flattenDomainChecks(new) {
+		oldResult, ok := oldChecks[key]
+		delete(oldChecks, key)
+		if ok && oldResult.Status == newResult.Status {
+			continue
+		}
+		change := CheckChange{Hostname: key.hostname, Check: key.check,
+			New: &newResult.Status, Messages: newResult.Messages}
+		if ok {
+			change.Old = &oldResult.Status
+		}
+		diff.Changes = append(diff.Changes, change)
+	}
+	for key, oldResult := range oldChecks {
+		diff.Changes = append(diff.Changes,
+			CheckChange{Hostname: key.hostname, Check: key.check, Old: &oldResult.Status})
+	}
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		if diff.Changes[i].Hostname != diff.Changes[j].Hostname {
+			return diff.Changes[i].Hostname < diff.Changes[j].Hostname
+		}
+		return diff.Changes[i].Check < diff.Changes[j].Check
+	})
+	return diff
+}
+
+type checkKey struct {
+	hostname string
+	check    string
+}
+
+// flattenDomainChecks maps every check in a domain result, including
+// subchecks, to its result.
+func flattenDomainChecks(d DomainResult) map[checkKey]*Result {
+	checks := make(map[checkKey]*Result)
+	for hostname, h := range d.HostnameResults {
+		if h.Result != nil {
+			flattenChecks(checks, hostname, "", h.Checks)
+		}
+	}
+	if d.MTASTSResult != nil && d.MTASTSResult.Result != nil {
+		flattenChecks(checks, "", "", map[string]*Result{MTASTS: d.MTASTSResult.Result})
+	}
+	flattenChecks(checks, "", "", d.ExtraResults)
+	return checks
+}
+
+func flattenChecks(checks map[checkKey]*Result, hostname string, prefix string, results map[string]*Result) {
+	for name, result := range results {
+		if result == nil {
+			continue
+		}
+		check := prefix + name
+		checks[checkKey{hostname, check}] = result
+		flattenChecks(checks, hostname, check+"/", result.Checks)
+	}
+}
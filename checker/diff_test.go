@@ -0,0 +1,46 @@
+package checker
+
+import (
+	"testing"
+)
+
+func TestDiffUnchanged(t *testing.T) {
+	diff := Diff(NewSampleDomainResult("example.com"), NewSampleDomainResult("example.com"))
+	if diff.Changed() {
+		t.Errorf("Expected identical scans not to differ, got %s", diff)
+	}
+}
+
+func TestDiffChangedChecks(t *testing.T) {
+	old := NewSampleDomainResult("example.com")
+	new := NewSampleDomainResult("example.com")
+	new.Status = DomainFailure
+	new.HostnameResults["mx.example.com"].Checks[Certificate].Failure("Certificate expired")
+	new.MTASTSResult.Checks[MTASTSPolicyFile].Status = Warning
+	delete(new.ExtraResults, PolicyList)
+	new.HostnameResults["mx2.example.com"] = HostnameResult{Hostname: "mx2.example.com"}
+
+	diff := Diff(old, new)
+	if !diff.Changed() || diff.OldStatus != DomainSuccess || diff.NewStatus != DomainFailure {
+		t.Errorf("Expected domain status change, got %+v", diff)
+	}
+	if len(diff.AddedHostnames) != 1 || diff.AddedHostnames[0] != "mx2.example.com" {
+		t.Errorf("Expected mx2.example.com to be added, got %v", diff.AddedHostnames)
+	}
+	expected := []string{
+		"mta-sts/mta-sts-policy-file: Success -> Warning",
+		"policylist: Success -> missing",
+		"mx.example.com certificate: Success -> Failure",
+	}
+	if len(diff.Changes) != len(expected) {
+		t.Fatalf("Expected changes %v, got %s", expected, diff)
+	}
+	for i, change := range diff.Changes {
+		if change.String() != expected[i] {
+			t.Errorf("Expected change %q, got %q", expected[i], change)
+		}
+	}
+	if messages := diff.Changes[2].Messages; len(messages) != 1 || messages[0] != "Failure: Certificate expired" {
+		t.Errorf("Expected newer scan's messages, got %v", messages)
+	}
+}
@@ -0,0 +1,219 @@
+package checker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/idna"
+)
+
+// DNSResult summarizes a domain's mail-related DNS records. It is produced
+// without connecting to any mailservers.
+type DNSResult struct {
+	Domain string `json:"domain"`
+	// MX hostnames, in order of preference.
+	MXs []string `json:"mxs"`
+	// Whether the domain publishes an MTA-STS TXT record.
+	MTASTSRecord bool `json:"mta_sts_record"`
+	// Whether the domain publishes an SMTP TLS Reporting (RFC 8460) TXT record.
+	TLSRPTRecord bool `json:"tls_rpt_record"`
+	// MX hostnames which publish DANE TLSA records for port 25.
+	DANEHostnames []string `json:"dane_hostnames"`
+}
+
+// CheckDomainDNSOnly looks up a domain's MX, MTA-STS, TLS-RPT and TLSA
+// records, without making any SMTP connections. It's much cheaper than
+// CheckDomain, so it's useful for pre-checks and for deciding which domains
+// need a full check.
+func (c *Checker) CheckDomainDNSOnly(domain string) (DNSResult, error) {
+	result := DNSResult{
+		Domain:        domain,
+		MXs:           []string{},
+		DANEHostnames: []string{},
+	}
+	mxs, err := c.lookupMXs(domain)
+	if err != nil {
+		return result, err
+	}
+	for _, mx := range mxs {
+		result.MXs = append(result.MXs, mx.Host)
+		if ok, _ := c.lookupTLSA(mx.Host); ok {
+			result.DANEHostnames = append(result.DANEHostnames, mx.Host)
+		}
+	}
+	result.MTASTSRecord = c.hasTXTRecord("_mta-sts."+domain, "v=STSv1")
+	result.TLSRPTRecord = c.hasTXTRecord("_smtp._tls."+domain, "v=TLSRPTv1")
+	return result, nil
+}
+
+func (c *Checker) hasTXTRecord(name string, prefix string) bool {
+	var records []string
+	var err error
+	if c.lookupTXTOverride != nil {
+		records, err = c.lookupTXTOverride(name)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+		defer cancel()
+		start := time.Now()
+		records, err = newResolver().LookupTXT(ctx, name)
+		dnsSeconds.ObserveSince(start, "txt")
+	}
+	return err == nil && len(filterByPrefix(records, prefix)) > 0
+}
+
+// lookupTLSA returns true if TLSA records exist for SMTP on hostname.
+func (c *Checker) lookupTLSA(hostname string) (bool, error) {
+	// Internationalized hostnames are looked up by their A-labels.
+	ascii, err := idna.ToASCII(strings.TrimSuffix(hostname, "."))
+	if err != nil {
+		return false, err
+	}
+	name := fmt.Sprintf("_%s._tcp.%s", smtpPort(), ascii)
+	if c.lookupTLSAOverride != nil {
+		return c.lookupTLSAOverride(name)
+	}
+	defer dnsSeconds.ObserveSince(time.Now(), "tlsa")
+	return queryTLSA(name, c.timeout())
+}
+
+// typeTLSA is the DNS resource record type for TLSA records (RFC 6698).
+const typeTLSA = dnsmessage.Type(52)
+
+// queryTLSA queries the configured resolver, or the system's first
+// nameserver, for TLSA records, since net.Resolver can't look them up. The
+// query is sent over UDP, and again over TCP if the response is truncated.
+// A name that doesn't exist has no records; other failures are errors.
+func queryTLSA(name string, timeout time.Duration) (bool, error) {
+	qname, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return false, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.StartQuestions()
+	builder.Question(dnsmessage.Question{Name: qname, Type: typeTLSA, Class: dnsmessage.ClassINET})
+	query, err := builder.Finish()
+	if err != nil {
+		return false, err
+	}
+
+	response, err := exchangeDNS("udp", query, timeout)
+	if err != nil {
+		return false, err
+	}
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		return false, err
+	}
+	if header.Truncated {
+		if response, err = exchangeDNS("tcp", query, timeout); err != nil {
+			return false, err
+		}
+		if header, err = parser.Start(response); err != nil {
+			return false, err
+		}
+	}
+	if header.ID != id {
+		return false, fmt.Errorf("mismatched DNS response ID")
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return false, nil
+	default:
+		return false, fmt.Errorf("TLSA lookup for %s failed: %v", name, header.RCode)
+	}
+	if err = parser.SkipAllQuestions(); err != nil {
+		return false, err
+	}
+	for {
+		answer, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if answer.Type == typeTLSA {
+			return true, nil
+		}
+		if err = parser.SkipAnswer(); err != nil {
+			return false, err
+		}
+	}
+}
+
+// exchangeDNS sends query to the nameserver over network, "udp" or "tcp",
+// and returns its response. Over TCP, messages are prefixed with their
+// length (RFC 1035 section 4.2.2).
+func exchangeDNS(network string, query []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := dialNameserver(network, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if network == "udp" {
+		if _, err = conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	prefixed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(prefixed, uint16(len(query)))
+	copy(prefixed[2:], query)
+	if _, err = conn.Write(prefixed); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err = io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err = io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// dialNameserver connects over network to the configured resolver, or the
+// system's first nameserver, or the fake network's if it's in use.
+func dialNameserver(network string, timeout time.Duration) (net.Conn, error) {
+	if fake := fakeNetworkInUse(); fake != nil {
+		return fake.dialDNS(context.Background(), network, "")
+	}
+	nameserver, err := nameserverAddress()
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout(network, nameserver, timeout)
+}
+
+// systemNameserver returns the first nameserver in /etc/resolv.conf.
+func systemNameserver() (string, error) {
+	data, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver configured")
+}
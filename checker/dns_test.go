@@ -0,0 +1,192 @@
+package checker
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var txtLookup = map[string][]string{
+	"_mta-sts.domain.tld":   []string{"v=STSv1; id=20190101"},
+	"_smtp._tls.domain.tld": []string{"v=TLSRPTv1; rua=mailto:tlsrpt@domain.tld"},
+	"_mta-sts.domain":       []string{"v=spf1 -all"},
+	"_smtp._tls.nostarttls": []string{"v=TLSRPTv1;rua=mailto:a@nostarttls"},
+}
+
+var tlsaLookup = map[string]bool{
+	"_25._tcp.mail1.domain.tld": true,
+}
+
+func mockLookupTXT(name string) ([]string, error) {
+	return txtLookup[name], nil
+}
+
+func mockLookupTLSA(name string) (bool, error) {
+	return tlsaLookup[name], nil
+}
+
+func TestCheckDomainDNSOnly(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:   mockLookupMX,
+		lookupTXTOverride:  mockLookupTXT,
+		lookupTLSAOverride: mockLookupTLSA,
+		CheckHostname: func(domain string, hostname string, _ time.Duration) HostnameResult {
+			t.Errorf("unexpected SMTP check of %s", hostname)
+			return HostnameResult{}
+		},
+	}
+	var tests = []struct {
+		domain string
+		want   DNSResult
+	}{
+		{"domain.tld", DNSResult{
+			Domain:        "domain.tld",
+			MXs:           []string{"mail2.domain.tld", "mail1.domain.tld"},
+			MTASTSRecord:  true,
+			TLSRPTRecord:  true,
+			DANEHostnames: []string{"mail1.domain.tld"},
+		}},
+		{"domain", DNSResult{
+			Domain:        "domain",
+			MXs:           []string{"hostname1", "hostname2"},
+			DANEHostnames: []string{},
+		}},
+		{"nostarttls", DNSResult{
+			Domain:        "nostarttls",
+			MXs:           []string{"nostarttls", "noconnection"},
+			TLSRPTRecord:  true,
+			DANEHostnames: []string{},
+		}},
+	}
+	for _, test := range tests {
+		got, err := c.CheckDomainDNSOnly(test.domain)
+		if err != nil {
+			t.Fatalf("CheckDomainDNSOnly(%s) returned error: %v", test.domain, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("CheckDomainDNSOnly(%s) = %+v, want %+v", test.domain, got, test.want)
+		}
+	}
+	if _, err := c.CheckDomainDNSOnly("error"); err == nil {
+		t.Error("expected an error when the MX lookup fails")
+	}
+}
+
+// serveDNS answers DNS queries over UDP and TCP on the same local port with
+// respond, which is told whether the query came over TCP, and configures the
+// checker to send raw queries there.
+func serveDNS(t *testing.T, respond func(query dnsmessage.Message, tcp bool) []byte) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Skipf("couldn't listen on the same port over TCP: %v", err)
+	}
+	answer := func(b []byte, overTCP bool) []byte {
+		var query dnsmessage.Message
+		if err := query.Unpack(b); err != nil {
+			return nil
+		}
+		return respond(query, overTCP)
+	}
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(answer(buf[:n], false), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err == nil {
+					response := answer(query, true)
+					binary.BigEndian.PutUint16(length[:], uint16(len(response)))
+					conn.Write(append(length[:], response...))
+				}
+			}
+			conn.Close()
+		}
+	}()
+	cfg := DefaultConfig()
+	cfg.Resolver = udp.LocalAddr().String()
+	Configure(cfg)
+	t.Cleanup(func() {
+		Configure(DefaultConfig())
+		udp.Close()
+		tcp.Close()
+	})
+}
+
+// dnsResponse packs a response to query with header, and a TLSA record for
+// the queried name if tlsa is set.
+func dnsResponse(query dnsmessage.Message, header dnsmessage.Header, tlsa bool) []byte {
+	header.ID = query.ID
+	header.Response = true
+	response := dnsmessage.Message{Header: header, Questions: query.Questions}
+	packed, _ := response.Pack()
+	if !tlsa {
+		return packed
+	}
+	// dnsmessage can't pack TLSA records, so append one by hand, its owner
+	// name pointing to the question's, and count it.
+	binary.BigEndian.PutUint16(packed[6:], 1)
+	return append(packed, 0xc0, 12, 0, byte(typeTLSA), 0, 1, 0, 0, 0, 60, 0, 4, 3, 1, 1, 0xab)
+}
+
+func TestQueryTLSAOverTCPWhenTruncated(t *testing.T) {
+	serveDNS(t, func(query dnsmessage.Message, tcp bool) []byte {
+		return dnsResponse(query, dnsmessage.Header{Truncated: !tcp}, tcp)
+	})
+	ok, err := queryTLSA("_25._tcp.mx.example.com", time.Second)
+	if err != nil || !ok {
+		t.Errorf("Expected TLSA records to be found over TCP, got %v, %v", ok, err)
+	}
+}
+
+func TestQueryTLSAResponseCodes(t *testing.T) {
+	var rcode int32
+	serveDNS(t, func(query dnsmessage.Message, tcp bool) []byte {
+		return dnsResponse(query, dnsmessage.Header{RCode: dnsmessage.RCode(atomic.LoadInt32(&rcode))}, false)
+	})
+	atomic.StoreInt32(&rcode, int32(dnsmessage.RCodeNameError))
+	if ok, err := queryTLSA("_25._tcp.mx.example.com", time.Second); ok || err != nil {
+		t.Errorf("Expected a nonexistent name to have no TLSA records, got %v, %v", ok, err)
+	}
+	atomic.StoreInt32(&rcode, int32(dnsmessage.RCodeServerFailure))
+	if _, err := queryTLSA("_25._tcp.mx.example.com", time.Second); err == nil {
+		t.Error("Expected a server failure to be an error")
+	}
+}
+
+func TestLookupTLSAInternationalized(t *testing.T) {
+	var looked string
+	c := Checker{lookupTLSAOverride: func(name string) (bool, error) {
+		looked = name
+		return true, nil
+	}}
+	if _, err := c.lookupTLSA("mx.bücher.example."); err != nil {
+		t.Fatal(err)
+	}
+	if looked != "_25._tcp.mx.xn--bcher-kva.example" {
+		t.Errorf("Expected the hostname's A-labels to be looked up, got %s", looked)
+	}
+}
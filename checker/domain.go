@@ -2,11 +2,13 @@ package checker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
 	"time"
 
+	"github.com/EFForg/starttls-backend/tracing"
 	"golang.org/x/net/idna"
 )
 
@@ -31,10 +33,30 @@ const (
 	DomainNoSTARTTLSFailure  DomainStatus = 4
 	DomainCouldNotConnect    DomainStatus = 5
 	DomainBadHostnameFailure DomainStatus = 6
+	DomainTimedOut           DomainStatus = 7
+)
+
+// ErrorClass distinguishes failures that might go away if the domain is
+// checked again from those that won't.
+type ErrorClass string
+
+// Values for DomainResult ErrorClass. Successful results have no ErrorClass.
+const (
+	// TemporaryError is a transient network failure, like a timeout, a
+	// greylisting mailserver or a DNS lookup that failed without a
+	// definitive answer. Checking again later might succeed.
+	TemporaryError ErrorClass = "temporary"
+	// PermanentError is a definitive failure, like an invalid certificate,
+	// a mailserver that doesn't support STARTTLS, or a domain without MX
+	// records.
+	PermanentError ErrorClass = "permanent"
 )
 
 // DomainResult wraps all the results for a particular mail domain.
 type DomainResult struct {
+	// Version of the JSON schema this result was encoded with. See
+	// SchemaVersion.
+	SchemaVersion int `json:"schema_version"`
 	// Domain being checked against.
 	Domain string `json:"domain"`
 	// Message if a failure or error occurs on the domain lookup level.
@@ -48,10 +70,46 @@ type DomainResult struct {
 	PreferredHostnames []string `json:"preferred_hostnames"`
 	// Expected MX hostnames supplied by the caller of CheckDomain.
 	MxHostnames []string `json:"mx_hostnames,omitempty"`
+	// Every MX record found for the domain, in order of preference.
+	MXRecords []MXRecord `json:"mx_records,omitempty"`
+	// MX hostnames which don't impact the Status of this result, mapped to
+	// the reason they were skipped.
+	SkippedHostnames map[string]string `json:"skipped_hostnames,omitempty"`
 	// Result of MTA-STS checks
 	MTASTSResult *MTASTSResult `json:"mta_sts"`
 	// Extra global results
 	ExtraResults map[string]*Result `json:"extra_results,omitempty"`
+	// Preferred hostnames which publish DANE TLSA records.
+	DANEHostnames []string `json:"dane_hostnames,omitempty"`
+	// Letter grade from ScoreDomain, and the reasons for it.
+	Grade        Grade    `json:"grade,omitempty"`
+	GradeReasons []string `json:"grade_reasons,omitempty"`
+	// The rules that decided Status and Grade, in the order they were
+	// evaluated, if the Checker's RecordDecisions was set. See Explain.
+	Decisions []Decision `json:"decisions,omitempty"`
+	// Set if the Checker's Deadline passed before every check finished.
+	// Hostnames that weren't checked in time are marked as TimedOut.
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Whether an unsuccessful Status is temporary or permanent.
+	ErrorClass ErrorClass `json:"error_class,omitempty"`
+	// Advisory annotations on preferred hostnames from the Checker's
+	// reputation feeds. They don't affect Status or Grade.
+	Annotations []Annotation `json:"annotations,omitempty"`
+	// CachedAt is when a result the Checker's DomainCache answered with was
+	// checked. It's zero for results that were just checked.
+	CachedAt time.Time `json:"-"`
+	// Checker describes the checker that performed the check. Results the
+	// DomainCache answered with keep the settings they were checked with.
+	Checker *Settings `json:"-"`
+}
+
+// MXRecord summarizes the result of checks against a single MX record.
+type MXRecord struct {
+	Hostname string `json:"hostname"`
+	Priority uint16 `json:"priority"`
+	Status   Status `json:"status"`
+	// Whether this hostname impacts the Status of the DomainResult.
+	Preferred bool `json:"preferred"`
 }
 
 // Class satisfies raven's Interface interface.
@@ -68,12 +126,13 @@ func (d DomainResult) setStatus(status DomainStatus) DomainResult {
 func lookupMXWithTimeout(domain string, timeout time.Duration) ([]*net.MX, error) {
 	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
 	defer cancel()
-	var r net.Resolver
-	return r.LookupMX(ctx, domain)
+	defer dnsSeconds.ObserveSince(time.Now(), "mx")
+	return newResolver().LookupMX(ctx, domain)
 }
 
-// lookupHostnames retrieves the MX hostnames associated with a domain.
-func (c *Checker) lookupHostnames(domain string) ([]string, error) {
+// lookupMXs retrieves the MX records associated with a domain, with
+// lowercased hostnames.
+func (c *Checker) lookupMXs(domain string) ([]*net.MX, error) {
 	domainASCII, err := idna.ToASCII(domain)
 	if err != nil {
 		return nil, fmt.Errorf("domain name %s couldn't be converted to ASCII", domain)
@@ -86,13 +145,16 @@ func (c *Checker) lookupHostnames(domain string) ([]string, error) {
 		mxs, err = lookupMXWithTimeout(domainASCII, c.timeout())
 	}
 	if err != nil || len(mxs) == 0 {
+		if isTemporaryDNSError(err) {
+			return nil, fmt.Errorf("MX lookup failed: %w", err)
+		}
 		return nil, fmt.Errorf("No MX records found")
 	}
-	hostnames := make([]string, 0)
+	records := make([]*net.MX, 0)
 	for _, mx := range mxs {
-		hostnames = append(hostnames, strings.ToLower(mx.Host))
+		records = append(records, &net.MX{Host: strings.ToLower(mx.Host), Pref: mx.Pref})
 	}
-	return hostnames, nil
+	return records, nil
 }
 
 // CheckDomain performs all associated checks for a particular domain.
@@ -103,53 +165,250 @@ func (c *Checker) lookupHostnames(domain string) ([]string, error) {
 // records with highest priority. This check succeeds only if the hostname
 // checks on the highest priority mailservers succeed.
 //
-//   `domain` is the mail domain to perform the lookup on.
-//   `expectedHostnames` is the list of expected hostnames.
-//     If `expectedHostnames` is nil, we don't validate the DNS lookup.
+//	`domain` is the mail domain to perform the lookup on.
+//	`expectedHostnames` is the list of expected hostnames.
+//	  If `expectedHostnames` is nil, we don't validate the DNS lookup.
+//
+// The result is graded with ScoreDomain, and unsuccessful results are
+// classified as temporary or permanent errors.
 func (c *Checker) CheckDomain(domain string, expectedHostnames []string) DomainResult {
+	return c.CheckDomainContext(context.Background(), domain, expectedHostnames)
+}
+
+// CheckDomainContext performs CheckDomain, tracing the check and its DNS
+// lookups, hostname checks and MTA-STS fetch as children of the span in ctx,
+// if any.
+func (c *Checker) CheckDomainContext(ctx context.Context, domain string, expectedHostnames []string) DomainResult {
+	ctx, span := tracing.Start(ctx, "checker.check_domain", "domain", domain)
+	defer span.End()
+	if c.DomainCache != nil {
+		if result, ok := c.DomainCache.get(domain, expectedHostnames); ok {
+			domainCacheLookups.Inc("hit")
+			span.SetAttributes("cached", "true")
+			return result
+		}
+		domainCacheLookups.Inc("miss")
+	}
+	scansStarted.Inc()
+	var trace *decisions
+	if c.RecordDecisions {
+		trace = &decisions{}
+	}
+	result := c.checkDomain(ctx, domain, expectedHostnames, trace)
+	settings := c.Settings()
+	result.Checker = &settings
+	result.Grade, result.GradeReasons = scoreDomain(result, trace)
+	if trace != nil {
+		result.Decisions = *trace
+	}
+	if result.ErrorClass == "" {
+		result.ErrorClass = result.classifyError()
+	}
+	recordScan(result)
+	result.Annotations = c.annotate(result)
+	span.SetAttributes("status", fmt.Sprint(result.Status), "error_class", string(result.ErrorClass))
+	if c.DomainCache != nil {
+		c.DomainCache.put(domain, expectedHostnames, result)
+	}
+	return result
+}
+
+// isTemporaryDNSError returns true if err is a DNS lookup failure other than
+// the name not existing, eg. a timeout or SERVFAIL.
+func isTemporaryDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && !dnsErr.IsNotFound
+}
+
+// classifyError returns whether the result's Status is a temporary or
+// permanent failure.
+func (d DomainResult) classifyError() ErrorClass {
+	switch d.Status {
+	case DomainSuccess, DomainWarning:
+		return ""
+	case DomainError, DomainCouldNotConnect, DomainTimedOut:
+		return TemporaryError
+	}
+	for _, hostname := range d.PreferredHostnames {
+		if d.HostnameResults[hostname].TemporaryFailure {
+			return TemporaryError
+		}
+	}
+	return PermanentError
+}
+
+// checkDomain checks domain, recording the rules that decide its status to
+// trace.
+func (c *Checker) checkDomain(ctx context.Context, domain string, expectedHostnames []string, trace *decisions) DomainResult {
 	result := DomainResult{
+		SchemaVersion:   SchemaVersion,
 		Domain:          domain,
 		MxHostnames:     expectedHostnames,
 		HostnameResults: make(map[string]HostnameResult),
 		ExtraResults:    make(map[string]*Result),
 	}
+	var deadline time.Time
+	if c.Deadline != 0 {
+		deadline = time.Now().Add(c.Deadline)
+	}
 	// 1. Look up hostnames
 	// 2. Perform and aggregate checks from those hostnames.
 	// 3. Set a summary message.
-	hostnames, err := c.lookupHostnames(domain)
+	_, span := tracing.Start(ctx, "dns.lookup_mx", "domain", domain)
+	mxs, err := c.lookupMXs(domain)
+	span.SetError(err)
+	span.End()
 	if err != nil {
-		return result.setStatus(DomainCouldNotConnect)
+		result.ErrorClass = PermanentError
+		if isTemporaryDNSError(err) {
+			result.ErrorClass = TemporaryError
+		}
+		result = result.setStatus(DomainCouldNotConnect)
+		trace.add(explainMXLookup(result))
+		return result
+	}
+	hostnames := make([]string, 0)
+	for _, mx := range mxs {
+		hostnames = append(hostnames, mx.Host)
 	}
+	result.ExtraResults[MXHygiene] = c.checkMX(hostnames)
 	checkedHostnames := make([]string, 0)
-	for _, hostname := range hostnames {
-		hostnameResult := c.checkHostname(domain, hostname)
-		result.HostnameResults[hostname] = hostnameResult
-		if hostnameResult.couldConnect() {
+	result.SkippedHostnames = make(map[string]string)
+	for _, mx := range mxs {
+		hostname := mx.Host
+		hostnameResult, checked := result.HostnameResults[hostname]
+		if !checked {
+			hostnameResult = c.checkHostnameBefore(ctx, domain, hostname, deadline)
+			result.HostnameResults[hostname] = hostnameResult
+		}
+		record := MXRecord{Hostname: hostname, Priority: mx.Pref, Status: hostnameResult.Status}
+		if checked {
+			result.SkippedHostnames[hostname] = "Duplicate MX record."
+		} else if hostnameResult.TimedOut {
+			result.TimedOut = true
+			result.SkippedHostnames[hostname] = "Timed out before this mailserver could be checked."
+		} else if !hostnameResult.couldConnect() {
+			result.SkippedHostnames[hostname] = "Could not connect; this may be a spam trap or an unused backup MX."
+		} else {
+			record.Preferred = true
 			checkedHostnames = append(checkedHostnames, hostname)
 		}
+		result.MXRecords = append(result.MXRecords, record)
+		trace.add(explainMXRecord(record, result.SkippedHostnames[hostname]))
 	}
 	result.PreferredHostnames = checkedHostnames
+	for _, hostname := range checkedHostnames {
+		trace.add(explainHostname(hostname, result.HostnameResults[hostname]))
+	}
+	if result.TimedOut {
+		// Return what we have so far, rather than starting more checks.
+		return result.explainStatus(trace)
+	}
+	for _, hostname := range checkedHostnames {
+		_, span := tracing.Start(ctx, "dns.lookup_tlsa", "hostname", hostname)
+		ok, err := c.lookupTLSA(hostname)
+		span.SetError(err)
+		span.End()
+		if ok {
+			result.DANEHostnames = append(result.DANEHostnames, hostname)
+		}
+	}
+	_, span = tracing.Start(ctx, "mta_sts.check", "domain", domain)
 	result.MTASTSResult = c.checkMTASTS(domain, result.HostnameResults)
+	if result.MTASTSResult != nil {
+		span.SetAttributes("status", fmt.Sprint(result.MTASTSResult.Status))
+	}
+	span.End()
+	if result.MTASTSResult != nil {
+		result.HostnameResults = withMTASTSPatterns(result.HostnameResults, result.MTASTSResult.MXs)
+	}
+	trace.add(explainMTASTS(result.MTASTSResult))
+
+	return result.explainStatus(trace)
+}
+
+// deriveStatus sets the domain's status from the results of its preferred
+// hostnames, and the expected hostnames supplied to CheckDomain.
+func (d DomainResult) deriveStatus() DomainResult {
+	return d.explainStatus(nil)
+}
 
-	// Derive Domain code from Hostname results.
-	if len(checkedHostnames) == 0 {
+// explainStatus is deriveStatus, recording the rules it evaluates to trace.
+func (d DomainResult) explainStatus(trace *decisions) DomainResult {
+	if len(d.PreferredHostnames) == 0 {
+		if d.TimedOut {
+			// We ran out of time before checking any of those hostnames.
+			trace.add(Decision{Rule: "status.timed_out", Outcome: domainStatusText[DomainTimedOut],
+				Reason: "Timed out before any MX hostname could be checked.", Final: true})
+			return d.setStatus(DomainTimedOut)
+		}
 		// We couldn't connect to any of those hostnames.
-		return result.setStatus(DomainCouldNotConnect)
+		trace.add(Decision{Rule: "status.connect", Outcome: domainStatusText[DomainCouldNotConnect],
+			Reason: "Couldn't connect to any MX hostname.", Final: true})
+		return d.setStatus(DomainCouldNotConnect)
 	}
-	for _, hostname := range checkedHostnames {
-		hostnameResult := result.HostnameResults[hostname]
+	for _, hostname := range d.PreferredHostnames {
+		hostnameResult := d.HostnameResults[hostname]
 		// Any of the connected hostnames don't support STARTTLS.
 		if !hostnameResult.couldSTARTTLS() {
-			return result.setStatus(DomainNoSTARTTLSFailure)
+			trace.add(Decision{Rule: "status.starttls", Subject: hostname, Outcome: domainStatusText[DomainNoSTARTTLSFailure],
+				Reason: "A preferred MX hostname doesn't support STARTTLS.", Final: true})
+			return d.setStatus(DomainNoSTARTTLSFailure)
 		}
 		// Any of the connected hostnames don't have a match?
-		if expectedHostnames != nil && !PolicyMatches(hostname, expectedHostnames) {
-			return result.setStatus(DomainBadHostnameFailure)
+		if d.MxHostnames != nil && !PolicyMatches(hostname, d.MxHostnames) {
+			trace.add(Decision{Rule: "status.expected_hostnames", Subject: hostname, Outcome: domainStatusText[DomainBadHostnameFailure],
+				Reason: fmt.Sprintf("A preferred MX hostname doesn't match the expected hostnames %v.", d.MxHostnames), Final: true})
+			return d.setStatus(DomainBadHostnameFailure)
 		}
-		result = result.setStatus(DomainStatus(hostnameResult.Status))
+		d = d.setStatus(DomainStatus(hostnameResult.Status))
+		trace.add(Decision{Rule: "status.hostname", Subject: hostname, Outcome: domainStatusText[d.Status],
+			Reason: fmt.Sprintf("The hostname's status is %s; the domain takes the worst status of its preferred MX hostnames.",
+				hostnameResult.StatusText())})
 	}
-	// result.setStatus(DomainStatus(result.ExtraResults["mta-sts"].Status))
-	return result
+	if d.TimedOut && d.Status <= DomainWarning {
+		// The hostnames we didn't get to might have failed, and MTA-STS
+		// and DANE weren't checked, so a partial result can't pass.
+		trace.add(Decision{Rule: "status.timed_out", Outcome: domainStatusText[DomainTimedOut],
+			Reason: "Timed out before every MX hostname could be checked, so the domain can't pass on the ones that were.", Final: true})
+		d.Status = DomainTimedOut
+	}
+	// d.setStatus(DomainStatus(d.ExtraResults["mta-sts"].Status))
+	return d
+}
+
+// Rederive recomputes the fields of a stored result that are derived from its
+// hostname results and MTA-STS policy file, using the current code: the
+// domain status, the status of each MX record, the grade, and the policy's
+// mode and MX patterns. Apart from the policy's fields, results whose MX
+// lookup failed, or that are missing results for preferred hostnames, are
+// returned as-is.
+func Rederive(d DomainResult) DomainResult {
+	if d.MTASTSResult != nil && d.MTASTSResult.Policy != "" {
+		mtasts := *d.MTASTSResult
+		policy := getKeyValuePairs(mtasts.Policy, "\n", ":")
+		mtasts.Mode = policy["mode"]
+		mtasts.MXs = strings.Split(policy["mx"], " ")
+		d.MTASTSResult = &mtasts
+	}
+	if len(d.HostnameResults) == 0 {
+		return d
+	}
+	for _, hostname := range d.PreferredHostnames {
+		if d.HostnameResults[hostname].Result == nil {
+			return d
+		}
+	}
+	for i, record := range d.MXRecords {
+		if hostnameResult, ok := d.HostnameResults[record.Hostname]; ok && hostnameResult.Result != nil {
+			d.MXRecords[i].Status = hostnameResult.Status
+		}
+	}
+	d.Status = DomainSuccess
+	d = d.deriveStatus()
+	d.Grade, d.GradeReasons = ScoreDomain(d)
+	d.ErrorClass = d.classifyError()
+	return d
 }
 
 // NewSampleDomainResult returns a sample successful domain result for testing.
@@ -157,12 +416,15 @@ func (c *Checker) CheckDomain(domain string, expectedHostnames []string) DomainR
 func NewSampleDomainResult(domain string) DomainResult {
 	hostname := "mx." + domain
 	return DomainResult{
-		Domain: domain,
-		Status: DomainSuccess,
+		SchemaVersion: SchemaVersion,
+		Domain:        domain,
+		Status:        DomainSuccess,
 		HostnameResults: map[string]HostnameResult{
 			hostname: HostnameResult{
-				Domain:   domain,
-				Hostname: hostname,
+				Domain:      domain,
+				Hostname:    hostname,
+				TLSVersion:  "TLS 1.3",
+				CipherSuite: "TLS_AES_128_GCM_SHA256",
 				Result: &Result{
 					Checks: map[string]*Result{
 						Connectivity: MakeResult(Connectivity),
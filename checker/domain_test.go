@@ -1,10 +1,15 @@
 package checker
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/EFForg/starttls-backend/tracing"
 )
 
 // fake DNS map for "resolving" MX lookups
@@ -48,10 +53,17 @@ func mockCheckMTASTS(domain string, hostnameResults map[string]HostnameResult) *
 	return r
 }
 
+func mockCheckMXHygiene(hostnames []string) *Result {
+	return MakeResult(MXHygiene)
+}
+
 func mockLookupMX(domain string) ([]*net.MX, error) {
 	if domain == "error" {
 		return nil, fmt.Errorf("No MX records found")
 	}
+	if domain == "dnstimeout" {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: domain, IsTimeout: true, IsTemporary: true}
+	}
 	result := []*net.MX{}
 	for _, host := range mxLookup[domain] {
 		result = append(result, &net.MX{Host: host})
@@ -110,11 +122,13 @@ func performTests(t *testing.T, tests []domainTestCase) {
 
 func performTestsWithCacheTimeout(t *testing.T, tests []domainTestCase, cacheExpiry time.Duration) {
 	c := Checker{
-		Timeout:             time.Second,
-		Cache:               MakeSimpleCache(cacheExpiry),
-		lookupMXOverride:    mockLookupMX,
-		CheckHostname:       mockCheckHostname,
-		checkMTASTSOverride: mockCheckMTASTS,
+		Timeout:                time.Second,
+		Cache:                  MakeSimpleCache(cacheExpiry),
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
 	}
 	for _, test := range tests {
 		if test.expectedHostnames == nil {
@@ -191,3 +205,247 @@ func TestHostnameScanExpires(t *testing.T) {
 func TestNewSampleDomainResult(t *testing.T) {
 	NewSampleDomainResult("example.com")
 }
+
+func TestMXRecordsAndSkippedHostnames(t *testing.T) {
+	c := Checker{
+		Cache:                  MakeSimpleCache(time.Hour),
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	result := c.CheckDomain("nostarttls", nil)
+	if len(result.MXRecords) != 2 {
+		t.Fatalf("Expected 2 MX records, got %v", result.MXRecords)
+	}
+	if !result.MXRecords[0].Preferred || result.MXRecords[0].Hostname != "nostarttls" {
+		t.Errorf("Expected nostarttls to be a preferred MX, got %+v", result.MXRecords[0])
+	}
+	if result.MXRecords[1].Preferred {
+		t.Errorf("Expected noconnection not to be a preferred MX, got %+v", result.MXRecords[1])
+	}
+	if _, ok := result.SkippedHostnames["noconnection"]; !ok || len(result.SkippedHostnames) != 1 {
+		t.Errorf("Expected only noconnection to be skipped, got %v", result.SkippedHostnames)
+	}
+}
+
+func TestGreylistRetries(t *testing.T) {
+	attempts := 0
+	c := Checker{
+		GreylistRetries:    2,
+		GreylistRetryDelay: time.Millisecond,
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			attempts++
+			if attempts < 3 {
+				result := mockCheckHostname(domain, "noconnection", timeout)
+				result.TemporaryFailure = true
+				return result
+			}
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+	}
+	result := c.checkHostname("domain", "hostname")
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	if result.Status != Success {
+		t.Errorf("Expected hostname to succeed after retries, got status %d", result.Status)
+	}
+
+	attempts = 0
+	c.GreylistRetries = 0
+	result = c.checkHostname("domain", "hostname")
+	if attempts != 1 || !result.TemporaryFailure {
+		t.Errorf("Expected a single attempt when retries are disabled, got %d", attempts)
+	}
+}
+
+func TestDeadlineReturnsPartialResults(t *testing.T) {
+	c := Checker{
+		Deadline:               50 * time.Millisecond,
+		lookupMXOverride:       mockLookupMX,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			if hostname == "hostname2" {
+				time.Sleep(time.Second)
+			}
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+	}
+	result := c.CheckDomain("domain", nil)
+	if !result.TimedOut || result.Status != DomainTimedOut || result.ErrorClass != TemporaryError {
+		t.Errorf("Expected a partial result not to pass, got timed_out=%v status=%d", result.TimedOut, result.Status)
+	}
+	if result.HostnameResults["hostname1"].Status != Success {
+		t.Errorf("Expected hostname1's result to be kept, got %v", result.HostnameResults["hostname1"])
+	}
+	if result.HostnameResults["hostname1"].TimedOut || !result.HostnameResults["hostname2"].TimedOut {
+		t.Errorf("Expected only hostname2 to be marked as timed out")
+	}
+	if _, ok := result.SkippedHostnames["hostname2"]; !ok || len(result.PreferredHostnames) != 1 {
+		t.Errorf("Expected hostname2 to be skipped, got preferred %v", result.PreferredHostnames)
+	}
+
+	// hostname2's check is still running, so use a copy of the checker.
+	slow := c
+	slow.CheckHostname = func(domain string, hostname string, timeout time.Duration) HostnameResult {
+		time.Sleep(time.Second)
+		return mockCheckHostname(domain, hostname, timeout)
+	}
+	result = slow.CheckDomain("domain", nil)
+	if result.Status != DomainTimedOut {
+		t.Errorf("Expected status %d when no hostname was checked in time, got %d", DomainTimedOut, result.Status)
+	}
+}
+
+func TestDeadlineInterruptsHostnameChecks(t *testing.T) {
+	interrupted := make(chan string, len(mxLookup["domain"]))
+	c := Checker{
+		Deadline:         50 * time.Millisecond,
+		lookupMXOverride: mockLookupMX,
+		HostnameCheck: &HostnameCheck{Name: "hanging",
+			Check: func(ctx context.Context, domain string, hostname string, timeout time.Duration) HostnameResult {
+				<-ctx.Done()
+				interrupted <- hostname
+				return mockCheckHostname(domain, hostname, timeout)
+			}},
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	if result := c.CheckDomain("domain", nil); result.Status != DomainTimedOut {
+		t.Errorf("Expected status %d, got %d", DomainTimedOut, result.Status)
+	}
+	select {
+	case <-interrupted:
+	case <-time.After(time.Second):
+		t.Error("Expected the hostname check to be interrupted once the deadline passed")
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	tests := map[string]ErrorClass{
+		"domain":       "",
+		"error":        PermanentError,
+		"dnstimeout":   TemporaryError,
+		"noconnection": TemporaryError,
+		"nostarttls":   PermanentError,
+	}
+	for domain, expected := range tests {
+		if got := c.CheckDomain(domain, nil).ErrorClass; got != expected {
+			t.Errorf("Expected %s to have error class %q, got %q", domain, expected, got)
+		}
+	}
+}
+
+func TestRederive(t *testing.T) {
+	result := NewSampleDomainResult("example.com")
+	result.MXRecords = []MXRecord{{Hostname: "mx.example.com", Preferred: true}}
+	// A stored result whose hostname failed, but was summarized as a success by
+	// older code.
+	hostnameResult := result.HostnameResults["mx.example.com"]
+	hostnameResult.Checks[Certificate].Failure("Certificate expired")
+	hostnameResult.Status = Failure
+	result.Grade = GradeA
+
+	rederived := Rederive(result)
+	if rederived.Status != DomainFailure {
+		t.Errorf("Expected status to be rederived as %d, got %d", DomainFailure, rederived.Status)
+	}
+	if rederived.MXRecords[0].Status != Failure {
+		t.Errorf("Expected MX record status to be rederived as %d, got %d", Failure, rederived.MXRecords[0].Status)
+	}
+	if rederived.Grade != GradeD {
+		t.Errorf("Expected grade to be rederived as %s, got %s", GradeD, rederived.Grade)
+	}
+
+	couldNotConnect := DomainResult{Domain: "example.com", Status: DomainCouldNotConnect}
+	if Rederive(couldNotConnect).Status != DomainCouldNotConnect {
+		t.Errorf("Expected results without hostnames to be left alone")
+	}
+}
+
+func TestRederiveMTASTSMode(t *testing.T) {
+	result := NewSampleDomainResult("example.com")
+	// A policy file whose mode wasn't parsed by older code.
+	result.MTASTSResult.Policy = "version: STSv1\r\nmode: testing\r\nmx: mx.example.com\r\nmax_age: 86400\r\n"
+	result.MTASTSResult.Mode = ""
+	rederived := Rederive(result)
+	if rederived.MTASTSResult.Mode != "testing" || !reflect.DeepEqual(rederived.MTASTSResult.MXs, []string{"mx.example.com"}) {
+		t.Errorf("Expected the policy to be reparsed, got mode %q and MXs %v",
+			rederived.MTASTSResult.Mode, rederived.MTASTSResult.MXs)
+	}
+	if result.MTASTSResult.Mode != "" {
+		t.Error("Expected the stored result not to be modified")
+	}
+}
+
+// spanRecorder is a tracing.Exporter that keeps spans in memory.
+type spanRecorder struct {
+	sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(s tracing.SpanData) {
+	r.Lock()
+	defer r.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+func TestCheckDomainTracing(t *testing.T) {
+	r := &spanRecorder{}
+	tracing.Configure(r, 1)
+	defer tracing.Configure(nil, 0)
+	c := Checker{
+		Cache:                  MakeSimpleCache(time.Hour),
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	ctx, root := tracing.Start(context.Background(), "request")
+	c.CheckDomainContext(ctx, "domain", nil)
+	root.End()
+
+	r.Lock()
+	defer r.Unlock()
+	counts := make(map[string]int)
+	var domainSpan tracing.SpanData
+	for _, span := range r.spans {
+		counts[span.Name]++
+		if span.Name == "checker.check_domain" {
+			domainSpan = span
+		}
+	}
+	expected := map[string]int{
+		"request":                 1,
+		"checker.check_domain":    1,
+		"dns.lookup_mx":           1,
+		"checker.check_hostname":  2,
+		"cache.put_hostname_scan": 2,
+		"dns.lookup_tlsa":         2,
+		"mta_sts.check":           1,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected spans %v, got %v", expected, counts)
+	}
+	for _, span := range r.spans {
+		if span.TraceID != domainSpan.TraceID {
+			t.Errorf("Expected span %s to be in the request's trace", span.Name)
+		}
+		if span.Name == "dns.lookup_mx" && span.Parent != domainSpan.SpanID {
+			t.Errorf("Expected MX lookup to be a child of the domain check")
+		}
+	}
+}
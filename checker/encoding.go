@@ -0,0 +1,173 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ResultEncoding serializes HostnameResults for remote cache backends, like
+// MemcachedStore. Every field of the result, including its Timestamp, must
+// survive a round trip.
+type ResultEncoding interface {
+	// Name identifies the encoding in stored entries, eg. "json".
+	Name() string
+	Encode(HostnameResult) ([]byte, error)
+	Decode([]byte) (HostnameResult, error)
+}
+
+// Names of the built-in result encodings.
+const (
+	JSONEncoding    = "json"
+	GobEncoding     = "gob"
+	MsgpackEncoding = "msgpack"
+)
+
+// ResultEncodings are the built-in result encodings, by name. JSON is the
+// easiest to inspect; gob and msgpack are more compact.
+var ResultEncodings = map[string]ResultEncoding{
+	JSONEncoding:    jsonEncoding{},
+	GobEncoding:     gobEncoding{},
+	MsgpackEncoding: msgpackEncoding{},
+}
+
+// ResultEncodingByName returns the built-in encoding called name, or JSON if
+// name is empty.
+func ResultEncodingByName(name string) (ResultEncoding, error) {
+	if name == "" {
+		name = JSONEncoding
+	}
+	encoding, ok := ResultEncodings[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown result encoding %q; must be json, gob or msgpack", name)
+	}
+	return encoding, nil
+}
+
+// CacheEntryVersion is the version of the HostnameResult cache entry format.
+// Increment it whenever a field of HostnameResult, or of the types it holds,
+// is renamed, removed or changes meaning, so that entries stored by older
+// versions are treated as cache misses rather than decoded wrongly.
+const CacheEntryVersion = 1
+
+// EncodeCacheEntry encodes result with encoding, behind a header recording
+// CacheEntryVersion and the encoding's name.
+func EncodeCacheEntry(encoding ResultEncoding, result HostnameResult) ([]byte, error) {
+	data, err := encoding.Encode(result)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(cacheEntryHeader(encoding)), data...), nil
+}
+
+// DecodeCacheEntry decodes an entry stored by EncodeCacheEntry. Entries
+// stored with another CacheEntryVersion or encoding are rejected.
+func DecodeCacheEntry(encoding ResultEncoding, entry []byte) (HostnameResult, error) {
+	header := cacheEntryHeader(encoding)
+	if !bytes.HasPrefix(entry, []byte(header)) {
+		stored := entry
+		if i := bytes.IndexByte(stored, '\n'); i >= 0 {
+			stored = stored[:i]
+		}
+		if len(stored) > 32 {
+			stored = stored[:32]
+		}
+		return HostnameResult{}, fmt.Errorf("cache entry was stored as %q, expected %q",
+			stored, strings.TrimSpace(header))
+	}
+	return encoding.Decode(entry[len(header):])
+}
+
+func cacheEntryHeader(encoding ResultEncoding) string {
+	return fmt.Sprintf("v%d %s\n", CacheEntryVersion, encoding.Name())
+}
+
+// FullHostnameResult holds every field of a HostnameResult, for encoding it
+// as JSON. HostnameResult inherits Result's MarshalJSON, which leaves out the
+// HostnameResult's own fields, so the MarshalJSON field hides it. Timestamp
+// isn't normally encoded either.
+type FullHostnameResult struct {
+	HostnameResult
+	Timestamp   time.Time `json:"timestamp"`
+	MarshalJSON struct{}  `json:"-"`
+}
+
+// Full returns h with every field encoded as JSON.
+func (h HostnameResult) Full() FullHostnameResult {
+	return FullHostnameResult{HostnameResult: h, Timestamp: h.Timestamp}
+}
+
+func (f FullHostnameResult) result() HostnameResult {
+	result := f.HostnameResult
+	result.Timestamp = f.Timestamp
+	return result
+}
+
+// EncodeHostnameResults encodes every field of each of a domain's hostname
+// results as JSON, to be decoded by DecodeHostnameResults.
+func EncodeHostnameResults(results map[string]HostnameResult) ([]byte, error) {
+	var full map[string]FullHostnameResult
+	if results != nil {
+		full = make(map[string]FullHostnameResult, len(results))
+	}
+	for hostname, result := range results {
+		full[hostname] = result.Full()
+	}
+	return json.Marshal(full)
+}
+
+// DecodeHostnameResults decodes hostname results encoded by
+// EncodeHostnameResults.
+func DecodeHostnameResults(data []byte) (map[string]HostnameResult, error) {
+	var full map[string]FullHostnameResult
+	if err := json.Unmarshal(data, &full); err != nil {
+		return nil, err
+	}
+	if full == nil {
+		return nil, nil
+	}
+	results := make(map[string]HostnameResult, len(full))
+	for hostname, result := range full {
+		results[hostname] = result.result()
+	}
+	return results, nil
+}
+
+type jsonEncoding struct{}
+
+func (jsonEncoding) Name() string {
+	return JSONEncoding
+}
+
+func (jsonEncoding) Encode(result HostnameResult) ([]byte, error) {
+	return json.Marshal(result.Full())
+}
+
+func (jsonEncoding) Decode(data []byte) (HostnameResult, error) {
+	var stored FullHostnameResult
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return HostnameResult{}, err
+	}
+	return stored.result(), nil
+}
+
+type gobEncoding struct{}
+
+func (gobEncoding) Name() string {
+	return GobEncoding
+}
+
+func (gobEncoding) Encode(result HostnameResult) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(result)
+	return buf.Bytes(), err
+}
+
+func (gobEncoding) Decode(data []byte) (HostnameResult, error) {
+	var result HostnameResult
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&result)
+	return result, err
+}
@@ -0,0 +1,93 @@
+package checker
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultEncodings(t *testing.T) {
+	notAfter := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	result := HostnameResult{
+		Result:       MakeResult("hostname"),
+		Domain:       "example.com",
+		Hostname:     "mx.example.com",
+		Timestamp:    time.Now().Round(0),
+		Extensions:   []string{"PIPELINING", "SIZE 35882577", strings.Repeat("X", 300)},
+		CertNotAfter: &notAfter,
+		Fingerprint:  &Fingerprint{Software: "Postfix"},
+	}
+	result.Checks[STARTTLS] = MakeResult(STARTTLS).Failure("nope")
+	for name, encoding := range ResultEncodings {
+		if encoding.Name() != name {
+			t.Errorf("Expected %s encoding to be called %s, got %s", name, name, encoding.Name())
+		}
+		entry, err := EncodeCacheEntry(encoding, result)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := DecodeCacheEntry(encoding, entry)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !got.Timestamp.Equal(result.Timestamp) || got.Domain != "example.com" || len(got.Extensions) != 3 ||
+			got.Extensions[2] != result.Extensions[2] || got.CertNotAfter == nil || !got.CertNotAfter.Equal(notAfter) ||
+			got.Fingerprint == nil || got.Fingerprint.Software != "Postfix" {
+			t.Errorf("Expected %s encoding to keep every field, got %+v", name, got)
+		}
+		if got.Result == nil || got.Checks[STARTTLS].Status != Failure || len(got.Checks[STARTTLS].Messages) != 1 {
+			t.Errorf("Expected %s encoding to keep checks, got %+v", name, got.Result)
+		}
+	}
+}
+
+func TestEncodeHostnameResults(t *testing.T) {
+	results := map[string]HostnameResult{
+		"mx.example.com": {
+			Result:     MakeResult("hostname"),
+			Hostname:   "mx.example.com",
+			Timestamp:  time.Now().Round(0),
+			TLSVersion: "TLS 1.3",
+			CertSerial: "01ab",
+		},
+	}
+	data, err := EncodeHostnameResults(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeHostnameResults(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := got["mx.example.com"]
+	if result.TLSVersion != "TLS 1.3" || result.CertSerial != "01ab" ||
+		!result.Timestamp.Equal(results["mx.example.com"].Timestamp) || result.Result == nil {
+		t.Errorf("Expected every field of the hostname result to be kept, got %+v", result)
+	}
+}
+
+func TestCacheEntriesAreVersioned(t *testing.T) {
+	entry, err := EncodeCacheEntry(ResultEncodings[JSONEncoding], HostnameResult{Hostname: "mx.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DecodeCacheEntry(ResultEncodings[GobEncoding], entry); err == nil {
+		t.Error("Expected entries stored with another encoding to be rejected")
+	}
+	old := append([]byte("v0 json\n"), entry[len(cacheEntryHeader(ResultEncodings[JSONEncoding])):]...)
+	if _, err = DecodeCacheEntry(ResultEncodings[JSONEncoding], old); err == nil {
+		t.Error("Expected entries stored with an older version to be rejected")
+	}
+}
+
+func TestMsgpackRejectsTruncatedData(t *testing.T) {
+	data, err := msgpackEncoding{}.Encode(HostnameResult{Result: MakeResult("hostname"), Hostname: "mx.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, truncated := range [][]byte{data[:len(data)-1], data[:1], append(data, 0)} {
+		if _, err = (msgpackEncoding{}).Decode(truncated); err == nil {
+			t.Errorf("Expected malformed data to be rejected: %x", truncated)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Decision is a step in deciding a domain's status or grade: a rule that
+// was evaluated, what it was evaluated on, and its outcome.
+type Decision struct {
+	// Rule names the rule, eg. "status.starttls" or "grade.certificate".
+	Rule string `json:"rule"`
+	// Subject is the MX hostname the rule was evaluated on, if any.
+	Subject string `json:"subject,omitempty"`
+	// Outcome is the status or grade the rule gave, or "pass" if it didn't
+	// affect them.
+	Outcome string `json:"outcome"`
+	// Reason explains the outcome from the rule's inputs and thresholds.
+	Reason string `json:"reason"`
+	// Final is set if the rule short-circuited the rules after it.
+	Final bool `json:"final,omitempty"`
+}
+
+// Explanation traces how a domain result's status and grade were decided.
+type Explanation struct {
+	Domain string       `json:"domain"`
+	Status DomainStatus `json:"status"`
+	Grade  Grade        `json:"grade"`
+	// Decisions lists every rule that was evaluated, in order.
+	Decisions []Decision `json:"decisions"`
+	// Changed is set if the current rules decide a different status or
+	// grade than the stored result has, in which case Status and Grade are
+	// the stored ones. Unless Recorded is set, Decisions explain the current
+	// rules'.
+	Changed bool `json:"changed,omitempty"`
+	// Recorded is set if Decisions were recorded when the domain was
+	// checked, rather than re-derived from the stored result.
+	Recorded bool `json:"recorded,omitempty"`
+}
+
+var domainStatusText = map[DomainStatus]string{
+	DomainSuccess:            "success",
+	DomainWarning:            "warning",
+	DomainFailure:            "failure",
+	DomainError:              "error",
+	DomainNoSTARTTLSFailure:  "no_starttls",
+	DomainCouldNotConnect:    "could_not_connect",
+	DomainBadHostnameFailure: "bad_hostname",
+	DomainTimedOut:           "timed_out",
+}
+
+// Text returns a short name for the status, like "no_starttls".
+func (s DomainStatus) Text() string {
+	if text, ok := domainStatusText[s]; ok {
+		return text
+	}
+	return fmt.Sprintf("status_%d", int32(s))
+}
+
+// decisions records Decisions. A nil *decisions records nothing, so rules
+// can be traced without slowing down checks.
+type decisions []Decision
+
+func (t *decisions) add(d Decision) {
+	if t != nil {
+		*t = append(*t, d)
+	}
+}
+
+// Explain traces how the status and grade of r, which may be a stored
+// result, were decided. If r recorded its decisions when it was checked,
+// those are returned; otherwise they're re-derived from its MX records,
+// hostname results and MTA-STS result. Either way, the stored status and
+// grade are compared with the current rules'.
+func Explain(r DomainResult) Explanation {
+	e := Explanation{Domain: r.Domain, Status: r.Status, Grade: r.Grade}
+	trace := &decisions{}
+	if len(r.HostnameResults) == 0 {
+		trace.add(explainMXLookup(r))
+		e.Decisions = *trace
+		e.Recorded = len(r.Decisions) > 0
+		return e
+	}
+	for _, record := range r.MXRecords {
+		trace.add(explainMXRecord(record, r.SkippedHostnames[record.Hostname]))
+	}
+	for _, hostname := range r.PreferredHostnames {
+		trace.add(explainHostname(hostname, r.HostnameResults[hostname]))
+	}
+	trace.add(explainMTASTS(r.MTASTSResult))
+	current := r
+	current.Status = DomainSuccess
+	current = current.explainStatus(trace)
+	grade, _ := scoreDomain(current, trace)
+	e.Decisions = *trace
+	e.Changed = current.Status != r.Status || (r.Grade != "" && grade != r.Grade)
+	if len(r.Decisions) > 0 {
+		e.Decisions = r.Decisions
+		e.Recorded = true
+	}
+	return e
+}
+
+// explainMXLookup describes why a domain whose MX lookup failed has its
+// status.
+func explainMXLookup(r DomainResult) Decision {
+	reason := "The domain has no MX records."
+	if r.ErrorClass == TemporaryError {
+		reason = "The MX lookup failed temporarily."
+	}
+	return Decision{Rule: "status.mx_lookup", Outcome: domainStatusText[r.Status], Reason: reason, Final: true}
+}
+
+// explainMXRecord describes whether an MX record's hostname counts towards
+// the domain's status, given the reason it was skipped, if it was.
+func explainMXRecord(record MXRecord, skipped string) Decision {
+	decision := Decision{Rule: "status.mx_selection", Subject: record.Hostname}
+	if !record.Preferred {
+		decision.Outcome = "skipped"
+		decision.Reason = fmt.Sprintf("Skipped MX hostname (priority %d); its checks don't count towards the domain's status.", record.Priority)
+		if skipped != "" {
+			decision.Reason += " " + skipped
+		}
+		return decision
+	}
+	decision.Outcome = "preferred"
+	decision.Reason = fmt.Sprintf("Preferred MX hostname (priority %d); its checks count towards the domain's status.", record.Priority)
+	return decision
+}
+
+// explainMTASTS describes the domain's MTA-STS policy. It doesn't affect the
+// domain's status, but an enforced policy can raise its grade.
+func explainMTASTS(m *MTASTSResult) Decision {
+	decision := Decision{Rule: "status.mta_sts", Outcome: "pass"}
+	switch {
+	case m == nil || m.Result == nil:
+		decision.Reason = "MTA-STS wasn't checked."
+	case m.Status != Success:
+		decision.Reason = fmt.Sprintf("The MTA-STS policy is invalid (%s), so senders aren't told to require TLS.", strings.ToLower(m.StatusText()))
+		if len(m.Messages) > 0 {
+			decision.Reason += " " + strings.Join(m.Messages, " ")
+		}
+	case m.Mode == "enforce":
+		decision.Reason = "The MTA-STS policy is valid and in enforce mode, so senders are told to require TLS."
+	default:
+		decision.Reason = fmt.Sprintf("The MTA-STS policy is valid but in %q mode, so senders aren't told to require TLS.", m.Mode)
+	}
+	return decision
+}
+
+// explainHostname describes how a hostname's status follows from its checks.
+func explainHostname(hostname string, h HostnameResult) Decision {
+	decision := Decision{Rule: "hostname.checks", Subject: hostname}
+	if h.Result == nil {
+		decision.Outcome = "missing"
+		decision.Reason = "No result was stored for this hostname."
+		return decision
+	}
+	decision.Outcome = strings.ToLower(h.StatusText())
+	names := make([]string, 0, len(h.Checks))
+	for name := range h.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var problems []string
+	for _, name := range names {
+		check := h.Checks[name]
+		if check == nil || check.Status == Success {
+			continue
+		}
+		problem := fmt.Sprintf("%s: %s", name, check.StatusText())
+		if len(check.Messages) > 0 {
+			problem += fmt.Sprintf(" (%s)", strings.Join(check.Messages, " "))
+		}
+		problems = append(problems, problem)
+	}
+	if len(problems) == 0 {
+		decision.Reason = fmt.Sprintf("Every check succeeded: %s.", strings.Join(names, ", "))
+	} else {
+		decision.Reason = "The hostname takes the worst status of its checks. " + strings.Join(problems, "; ")
+	}
+	return decision
+}
@@ -0,0 +1,122 @@
+package checker
+
+import (
+	"testing"
+	"time"
+)
+
+func lastDecision(e Explanation, rule string) (Decision, bool) {
+	for i := len(e.Decisions) - 1; i >= 0; i-- {
+		if e.Decisions[i].Rule == rule {
+			return e.Decisions[i], true
+		}
+	}
+	return Decision{}, false
+}
+
+func TestExplain(t *testing.T) {
+	result := NewSampleDomainResult("example.com")
+	result.Grade, _ = ScoreDomain(result)
+	e := Explain(result)
+	if e.Changed {
+		t.Errorf("Expected sample result to be explained unchanged, got %+v", e)
+	}
+	if d, ok := lastDecision(e, "grade.required_tls"); !ok || d.Outcome != string(result.Grade) {
+		t.Errorf("Expected final grade decision %s, got %+v", result.Grade, e.Decisions)
+	}
+	if d, ok := lastDecision(e, "hostname.checks"); !ok || d.Subject != "mx.example.com" || d.Outcome != "success" {
+		t.Errorf("Expected hostname checks to be explained, got %+v", e.Decisions)
+	}
+}
+
+func TestExplainNoSTARTTLS(t *testing.T) {
+	result := NewSampleDomainResult("example.com")
+	h := result.HostnameResults["mx.example.com"]
+	h.Checks[STARTTLS] = MakeResult(STARTTLS).Failure("Mailserver doesn't support STARTTLS.")
+	h.Status = Failure
+	result.Status = DomainNoSTARTTLSFailure
+	e := Explain(result)
+	d, ok := lastDecision(e, "status.starttls")
+	if !ok || !d.Final || d.Subject != "mx.example.com" {
+		t.Errorf("Expected STARTTLS failure to short-circuit, got %+v", e.Decisions)
+	}
+	if e.Changed {
+		t.Errorf("Expected status to match, got %+v", e)
+	}
+}
+
+func TestExplainChanged(t *testing.T) {
+	result := NewSampleDomainResult("example.com")
+	result.Grade = "F"
+	if e := Explain(result); !e.Changed || e.Grade != "F" {
+		t.Errorf("Expected changed grade to be reported, got %+v", e)
+	}
+}
+
+func TestExplainNoMX(t *testing.T) {
+	e := Explain(DomainResult{Domain: "example.com", Status: DomainError})
+	if len(e.Decisions) != 1 || e.Decisions[0].Rule != "status.mx_lookup" || !e.Decisions[0].Final {
+		t.Errorf("Expected MX lookup to be explained, got %+v", e.Decisions)
+	}
+}
+
+func TestExplainSkippedMX(t *testing.T) {
+	result := NewSampleDomainResult("example.com")
+	result.MXRecords = []MXRecord{
+		{Hostname: "mx.example.com", Priority: 10, Preferred: true},
+		{Hostname: "backup.example.com", Priority: 20},
+	}
+	result.SkippedHostnames = map[string]string{"backup.example.com": "Could not connect."}
+	e := Explain(result)
+	if e.Recorded {
+		t.Errorf("Expected decisions to be re-derived, got %+v", e)
+	}
+	outcomes := make(map[string]string)
+	for _, d := range e.Decisions {
+		if d.Rule == "status.mx_selection" {
+			outcomes[d.Subject] = d.Outcome
+		}
+	}
+	if outcomes["mx.example.com"] != "preferred" || outcomes["backup.example.com"] != "skipped" {
+		t.Errorf("Expected only mx.example.com to be preferred, got %+v", e.Decisions)
+	}
+	if _, ok := lastDecision(e, "status.mta_sts"); !ok {
+		t.Errorf("Expected MTA-STS to be explained, got %+v", e.Decisions)
+	}
+}
+
+func TestCheckRecordsDecisions(t *testing.T) {
+	c := Checker{
+		Cache:                  MakeSimpleCache(time.Hour),
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+		RecordDecisions:        true,
+	}
+	result := c.CheckDomain("nostarttls", nil)
+	e := Explain(result)
+	if !e.Recorded || len(e.Decisions) != len(result.Decisions) {
+		t.Fatalf("Expected the recorded decisions to be explained, got %+v", e)
+	}
+	var selections []Decision
+	for _, d := range result.Decisions {
+		if d.Rule == "status.mx_selection" {
+			selections = append(selections, d)
+		}
+	}
+	if len(selections) != 2 || selections[0].Outcome != "preferred" || selections[1].Outcome != "skipped" {
+		t.Errorf("Expected nostarttls to be preferred and noconnection skipped, got %+v", selections)
+	}
+	if d, ok := lastDecision(e, "status.starttls"); !ok || !d.Final || d.Subject != "nostarttls" {
+		t.Errorf("Expected the STARTTLS failure to be recorded, got %+v", result.Decisions)
+	}
+	if d, ok := lastDecision(e, "grade.starttls"); !ok || d.Outcome != string(GradeF) {
+		t.Errorf("Expected the grade to be recorded, got %+v", result.Decisions)
+	}
+	c.RecordDecisions = false
+	if result := c.CheckDomain("nostarttls", nil); result.Decisions != nil {
+		t.Errorf("Expected no decisions to be recorded, got %+v", result.Decisions)
+	}
+}
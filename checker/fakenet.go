@@ -0,0 +1,378 @@
+package checker
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// The checker can run against a fake network, set with Config.FakeNetwork,
+// for end-to-end tests and for running the backend locally. DNS lookups, SMTP
+// connections and MTA-STS policy fetches are then answered in-process, so
+// nothing leaves the machine, and results only depend on the domain checked.
+//
+// Every domain has a single mailserver, mx.<domain>, which supports STARTTLS
+// with a valid certificate, and an MTA-STS policy in enforce mode, unless one
+// of its labels names a scenario:
+//   nomx          The domain has no MX records.
+//   noconnection  The mailserver refuses connections.
+//   greylist      The mailserver responds with a temporary failure.
+//   nostarttls    The mailserver doesn't support STARTTLS.
+//   badcert       The mailserver's certificate is for another name.
+//   nomtasts      The domain has no MTA-STS policy.
+//   testing       The domain's MTA-STS policy is in testing mode.
+// For instance, nostarttls.example has a mailserver without STARTTLS.
+// Certificates are issued by a CA generated at startup, which the checker
+// trusts while the fake network is in use.
+
+// FakeScenarios are the labels that select how the fake network behaves.
+var FakeScenarios = []string{"nomx", "noconnection", "greylist", "nostarttls", "badcert", "nomtasts", "testing"}
+
+// fakeAddress is where every fake mailserver and web server is.
+var fakeAddress = net.IPv4(192, 0, 2, 1)
+
+type fakeNetwork struct {
+	caDER []byte
+	caKey *ecdsa.PrivateKey
+	ca    *x509.Certificate
+	roots *x509.CertPool
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+
+	// counts of what the fake network served, for benchmarks.
+	counts fakeNetworkCounts
+}
+
+// fakeNetworkCounts counts the SMTP connections, DNS queries and MTA-STS
+// policy fetches the fake network has served. Fields are updated atomically.
+type fakeNetworkCounts struct {
+	SMTPConnections int64
+	DNSQueries      int64
+	PolicyFetches   int64
+}
+
+// snapshot returns the current counts.
+func (c *fakeNetworkCounts) snapshot() fakeNetworkCounts {
+	return fakeNetworkCounts{
+		SMTPConnections: atomic.LoadInt64(&c.SMTPConnections),
+		DNSQueries:      atomic.LoadInt64(&c.DNSQueries),
+		PolicyFetches:   atomic.LoadInt64(&c.PolicyFetches),
+	}
+}
+
+// newFakeNetwork sets up a fake network with a new CA.
+func newFakeNetwork() *fakeNetwork {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		// Only possible if crypto/rand fails.
+		panic(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake network CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return &fakeNetwork{caDER: der, caKey: key, ca: ca, roots: roots, certs: make(map[string]*tls.Certificate)}
+}
+
+// fakeScenario returns the first of name's labels that names a scenario, or
+// "" if none do.
+func fakeScenario(name string) string {
+	for _, label := range strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".") {
+		for _, scenario := range FakeScenarios {
+			if label == scenario {
+				return scenario
+			}
+		}
+	}
+	return ""
+}
+
+// certificate returns a certificate for name, issued by the fake CA.
+func (f *fakeNetwork) certificate(name string) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cert, ok := f.certs[name]; ok {
+		return cert, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(int64(len(f.certs) + 2)),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, f.ca, &key.PublicKey, f.caKey)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{Certificate: [][]byte{der, f.caDER}, PrivateKey: key}
+	f.certs[name] = cert
+	return cert, nil
+}
+
+// dial connects to a fake mailserver.
+func (f *fakeNetwork) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	atomic.AddInt64(&f.counts.SMTPConnections, 1)
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	scenario := fakeScenario(host)
+	if !strings.HasPrefix(host, "mx.") || scenario == "nomx" {
+		return nil, &net.OpError{Op: "dial", Net: network,
+			Err: &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}}
+	}
+	if scenario == "noconnection" || port != smtpPort() {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("connection refused")}
+	}
+	client, server := net.Pipe()
+	go f.serveSMTP(server, host, scenario)
+	return client, nil
+}
+
+// serveSMTP runs a fake mailserver for hostname on conn. It only knows the
+// commands the checker uses.
+func (f *fakeNetwork) serveSMTP(conn net.Conn, hostname string, scenario string) {
+	// conn is replaced by the TLS connection after STARTTLS.
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	if scenario == "greylist" {
+		fmt.Fprintf(conn, "421 4.7.0 %s Greylisted, please try again later\r\n", hostname)
+		return
+	}
+	fmt.Fprintf(conn, "220 %s ESMTP fake\r\n", hostname)
+	reader := bufio.NewReader(conn)
+	secure := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.TrimSpace(line))
+		if i := strings.IndexByte(verb, ' '); i >= 0 {
+			verb = verb[:i]
+		}
+		switch verb {
+		case "EHLO", "HELO":
+			reply := fmt.Sprintf("250-%s\r\n250-PIPELINING\r\n", hostname)
+			if !secure && scenario != "nostarttls" {
+				reply += "250-STARTTLS\r\n"
+			}
+			fmt.Fprintf(conn, "%s250 8BITMIME\r\n", reply)
+		case "STARTTLS":
+			if secure || scenario == "nostarttls" {
+				fmt.Fprintf(conn, "502 5.5.1 STARTTLS not available\r\n")
+				continue
+			}
+			fmt.Fprintf(conn, "220 2.0.0 Ready to start TLS\r\n")
+			name := hostname
+			if scenario == "badcert" {
+				name = "not-" + hostname
+			}
+			tlsConn := tls.Server(conn, &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+					return f.certificate(name)
+				},
+			})
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, reader, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case "NOOP", "RSET", "MAIL", "RCPT":
+			fmt.Fprintf(conn, "250 2.0.0 OK\r\n")
+		case "QUIT":
+			fmt.Fprintf(conn, "221 2.0.0 Bye\r\n")
+			return
+		default:
+			fmt.Fprintf(conn, "502 5.5.2 Command not recognized\r\n")
+		}
+	}
+}
+
+// RoundTrip serves MTA-STS policies, as an http.RoundTripper.
+func (f *fakeNetwork) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&f.counts.PolicyFetches, 1)
+	host := strings.ToLower(req.URL.Hostname())
+	scenario := fakeScenario(host)
+	if !strings.HasPrefix(host, "mta-sts.") || scenario == "nomtasts" || scenario == "nomx" {
+		return nil, &net.OpError{Op: "dial", Net: "tcp",
+			Err: &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}}
+	}
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+	if req.URL.Path != "/.well-known/mta-sts.txt" {
+		resp.StatusCode, resp.Status = http.StatusNotFound, "404 Not Found"
+		resp.Body = ioutil.NopCloser(strings.NewReader(""))
+		return resp, nil
+	}
+	mode := "enforce"
+	if scenario == "testing" {
+		mode = "testing"
+	}
+	domain := strings.TrimPrefix(host, "mta-sts.")
+	body := fmt.Sprintf("version: STSv1\nmode: %s\nmx: mx.%s\nmax_age: 86400\n", mode, domain)
+	resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+	resp.Header.Set("Content-Type", "text/plain")
+	resp.Body = ioutil.NopCloser(strings.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
+}
+
+// answerDNS answers a DNS query about the fake network.
+func (f *fakeNetwork) answerDNS(query []byte) ([]byte, error) {
+	atomic.AddInt64(&f.counts.DNSQueries, 1)
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(strings.TrimSuffix(question.Name.String(), "."))
+	scenario := fakeScenario(name)
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   header.RecursionDesired,
+		RecursionAvailable: true,
+	})
+	builder.StartQuestions()
+	builder.Question(question)
+	builder.StartAnswers()
+	answer := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 300}
+	switch {
+	case question.Type == dnsmessage.TypeMX && !strings.HasPrefix(name, "_") && scenario != "nomx":
+		mx, err := dnsmessage.NewName("mx." + name + ".")
+		if err != nil {
+			return nil, err
+		}
+		err = builder.MXResource(answer, dnsmessage.MXResource{Pref: 10, MX: mx})
+		if err != nil {
+			return nil, err
+		}
+	case question.Type == dnsmessage.TypeA && !strings.HasPrefix(name, "_"):
+		var a dnsmessage.AResource
+		copy(a.A[:], fakeAddress.To4())
+		if err = builder.AResource(answer, a); err != nil {
+			return nil, err
+		}
+	case question.Type == dnsmessage.TypeTXT && strings.HasPrefix(name, "_mta-sts.") &&
+		scenario != "nomtasts" && scenario != "nomx":
+		err = builder.TXTResource(answer, dnsmessage.TXTResource{TXT: []string{"v=STSv1; id=fakenetwork"}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// dialDNS connects to the fake network's nameserver.
+func (f *fakeNetwork) dialDNS(ctx context.Context, network string, address string) (net.Conn, error) {
+	return &fakeDNSConn{network: f}, nil
+}
+
+// fakeDNSConn answers each DNS query written to it with a response to be
+// read from it. It's a net.PacketConn, so that net.Resolver sends queries
+// without the length prefixes used over TCP.
+type fakeDNSConn struct {
+	network *fakeNetwork
+
+	mu        sync.Mutex
+	responses [][]byte
+}
+
+func (c *fakeDNSConn) Write(b []byte) (int, error) {
+	response, err := c.network.answerDNS(b)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responses = append(c.responses, response)
+	return len(b), nil
+}
+
+func (c *fakeDNSConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.responses) == 0 {
+		return 0, io.EOF
+	}
+	response := c.responses[0]
+	c.responses = c.responses[1:]
+	if len(response) > len(b) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(b, response), nil
+}
+
+func (c *fakeDNSConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *fakeDNSConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+func (c *fakeDNSConn) Close() error                     { return nil }
+func (c *fakeDNSConn) LocalAddr() net.Addr              { return &net.UDPAddr{IP: net.IPv4zero} }
+func (c *fakeDNSConn) RemoteAddr() net.Addr             { return &net.UDPAddr{IP: fakeAddress, Port: 53} }
+func (c *fakeDNSConn) SetDeadline(time.Time) error      { return nil }
+func (c *fakeDNSConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *fakeDNSConn) SetWriteDeadline(time.Time) error { return nil }
+
+// fakeNetworkInUse returns the fake network, or nil if the checker is using
+// the real one.
+func fakeNetworkInUse() *fakeNetwork {
+	network.RLock()
+	defer network.RUnlock()
+	return network.fake
+}
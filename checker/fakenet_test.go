@@ -0,0 +1,45 @@
+package checker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeNetwork(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FakeNetwork = true
+	Configure(cfg)
+	defer Configure(DefaultConfig())
+
+	c := Checker{Timeout: 5 * time.Second}
+	tests := []struct {
+		domain string
+		status DomainStatus
+		mode   string
+	}{
+		{"example.com", DomainSuccess, "enforce"},
+		{"testing.example.com", DomainSuccess, "testing"},
+		{"nomtasts.example.com", DomainSuccess, ""},
+		{"nostarttls.example.com", DomainNoSTARTTLSFailure, ""},
+		{"noconnection.example.com", DomainCouldNotConnect, ""},
+		{"nomx.example.com", DomainCouldNotConnect, ""},
+	}
+	for _, test := range tests {
+		result := c.CheckDomain(test.domain, nil)
+		if result.Status != test.status {
+			t.Errorf("Expected %s to have status %d, got %d: %+v", test.domain, test.status, result.Status, result)
+		}
+		if test.status == DomainSuccess && result.MTASTSResult.Mode != test.mode {
+			t.Errorf("Expected %s to have MTA-STS mode %q, got %+v", test.domain, test.mode, result.MTASTSResult)
+		}
+	}
+
+	result := c.CheckDomain("badcert.example.com", nil)
+	if result.HostnameResults["mx.badcert.example.com."].NameMismatch == nil {
+		t.Errorf("Expected badcert.example.com to have an invalid certificate, got %+v", result)
+	}
+	result = c.CheckDomain("greylist.example.com", nil)
+	if !result.HostnameResults["mx.greylist.example.com."].TemporaryFailure {
+		t.Errorf("Expected greylist.example.com to be greylisted, got %+v", result)
+	}
+}
@@ -0,0 +1,339 @@
+package checker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Annotation is advisory information about a mailserver from an external
+// reputation feed. Annotations never affect a result's Status or Grade.
+type Annotation struct {
+	Feed     string `json:"feed"`
+	Hostname string `json:"hostname"`
+	Message  string `json:"message"`
+}
+
+// A Feed annotates hostname results with information from an external
+// source, like a certificate revocation list or a list of hosts known to be
+// compromised. Feeds should cache what they fetch, and must return when ctx
+// is done.
+type Feed interface {
+	Name() string
+	Annotate(ctx context.Context, h HostnameResult) ([]Annotation, error)
+}
+
+const (
+	defaultFeedTimeout = 2 * time.Second
+	defaultFeedRefresh = time.Hour
+	// feedRetryDelay is how long to wait before fetching a feed again after
+	// a fetch fails.
+	feedRetryDelay = time.Minute
+	// maxFeedBytes limits the size of a fetched feed.
+	maxFeedBytes = 64 << 20
+)
+
+// feedClient returns the client feeds are fetched over HTTP with. Feeds are
+// fetched in the background, so its timeout can be longer than the Checker's
+// FeedTimeout. With a fake network, feeds are fetched from it too, so no real
+// connections are made.
+func feedClient() *http.Client {
+	client := &http.Client{Timeout: 30 * time.Second}
+	network.RLock()
+	if network.fake != nil {
+		client.Transport = network.fake
+	}
+	network.RUnlock()
+	return client
+}
+
+// feedSource fetches and parses a feed from a URL or file, and caches it.
+type feedSource struct {
+	source  string
+	refresh time.Duration
+	parse   func([]byte) (interface{}, error)
+
+	mu        sync.Mutex
+	value     interface{}
+	err       error
+	nextFetch time.Time
+	// fetching is closed when the fetch in progress, if any, finishes.
+	fetching chan struct{}
+}
+
+// get returns the parsed feed, fetching it first if it's due for a refresh.
+// If ctx is done before the fetch finishes, the previous version of the feed
+// is returned, if there is one. Fetches carry on in the background either
+// way.
+func (s *feedSource) get(ctx context.Context) (interface{}, error) {
+	s.mu.Lock()
+	if s.value != nil && time.Now().Before(s.nextFetch) {
+		defer s.mu.Unlock()
+		return s.value, nil
+	}
+	if s.fetching == nil && !time.Now().Before(s.nextFetch) {
+		s.fetching = make(chan struct{})
+		go s.fetch(s.fetching)
+	}
+	done := s.fetching
+	s.mu.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.value != nil {
+		return s.value, nil
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return nil, ctx.Err()
+}
+
+func (s *feedSource) fetch(done chan struct{}) {
+	data, err := readFeed(s.source)
+	var value interface{}
+	if err == nil {
+		value, err = s.parse(data)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	if err != nil {
+		// Keep serving the previous version, if any.
+		log.Printf("Couldn't fetch feed %s: %v", s.source, err)
+		s.nextFetch = time.Now().Add(feedRetryDelay)
+	} else {
+		s.value = value
+		s.nextFetch = time.Now().Add(s.refresh)
+	}
+	s.fetching = nil
+	close(done)
+}
+
+// readFeed reads a feed from an http(s) URL, or otherwise a file path.
+func readFeed(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+	resp, err := feedClient().Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxFeedBytes))
+}
+
+// ListFeed annotates mailservers whose hostname, or the SHA-256 hash of whose
+// certificate's public key, appears in a list of known-compromised hosts.
+// The list has one entry per line. Blank lines and lines starting with # are
+// ignored.
+type ListFeed struct {
+	name string
+	src  *feedSource
+}
+
+// NewListFeed returns a ListFeed reading the list from source, a URL or file
+// path, and re-reading it every refresh. If refresh is 0, the list is
+// re-read hourly.
+func NewListFeed(name string, source string, refresh time.Duration) *ListFeed {
+	return &ListFeed{name: name, src: newFeedSource(source, refresh, parseList)}
+}
+
+func newFeedSource(source string, refresh time.Duration, parse func([]byte) (interface{}, error)) *feedSource {
+	if refresh <= 0 {
+		refresh = defaultFeedRefresh
+	}
+	return &feedSource{source: source, refresh: refresh, parse: parse}
+}
+
+func parseList(data []byte) (interface{}, error) {
+	entries := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries[normalizeListEntry(line)] = true
+	}
+	return entries, scanner.Err()
+}
+
+func normalizeListEntry(entry string) string {
+	return strings.TrimSuffix(strings.ToLower(entry), ".")
+}
+
+// Name returns the feed's name.
+func (f *ListFeed) Name() string { return f.name }
+
+// Annotate implements Feed.
+func (f *ListFeed) Annotate(ctx context.Context, h HostnameResult) ([]Annotation, error) {
+	value, err := f.src.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := value.(map[string]bool)
+	var annotations []Annotation
+	if entries[normalizeListEntry(h.Hostname)] {
+		annotations = append(annotations, Annotation{Feed: f.name, Hostname: h.Hostname,
+			Message: fmt.Sprintf("%s is listed as a compromised host", h.Hostname)})
+	}
+	if h.CertSPKISHA256 != "" && entries[h.CertSPKISHA256] {
+		annotations = append(annotations, Annotation{Feed: f.name, Hostname: h.Hostname,
+			Message: "The public key of this mailserver's certificate is listed as compromised"})
+	}
+	return annotations, nil
+}
+
+// CRLFeed annotates mailservers presenting a certificate that's been revoked
+// in a certificate revocation list, in DER or PEM form.
+type CRLFeed struct {
+	name string
+	src  *feedSource
+}
+
+// revocations are the serial numbers revoked by a CRL's issuer.
+type revocations struct {
+	issuer  string
+	serials map[string]time.Time
+}
+
+// NewCRLFeed returns a CRLFeed reading the CRL from source, a URL or file
+// path, and re-reading it every refresh. If refresh is 0, the CRL is
+// re-read hourly.
+func NewCRLFeed(name string, source string, refresh time.Duration) *CRLFeed {
+	return &CRLFeed{name: name, src: newFeedSource(source, refresh, parseCRL)}
+}
+
+func parseCRL(data []byte) (interface{}, error) {
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		return nil, err
+	}
+	var issuer pkix.Name
+	issuer.FillFromRDNSequence(&crl.TBSCertList.Issuer)
+	revoked := revocations{issuer: issuer.String(), serials: make(map[string]time.Time)}
+	for _, cert := range crl.TBSCertList.RevokedCertificates {
+		revoked.serials[fmt.Sprintf("%x", cert.SerialNumber)] = cert.RevocationTime
+	}
+	return revoked, nil
+}
+
+// Name returns the feed's name.
+func (f *CRLFeed) Name() string { return f.name }
+
+// Annotate implements Feed.
+func (f *CRLFeed) Annotate(ctx context.Context, h HostnameResult) ([]Annotation, error) {
+	if h.CertSerial == "" {
+		return nil, nil
+	}
+	value, err := f.src.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	revoked := value.(revocations)
+	if h.CertIssuer != revoked.issuer {
+		return nil, nil
+	}
+	revokedAt, ok := revoked.serials[h.CertSerial]
+	if !ok {
+		return nil, nil
+	}
+	return []Annotation{{Feed: f.name, Hostname: h.Hostname,
+		Message: fmt.Sprintf("This mailserver's certificate was revoked by its issuer on %s",
+			revokedAt.UTC().Format("2006-01-02"))}}, nil
+}
+
+// configuredFeeds are the feeds set up by Configure, used by Checkers that
+// don't set their own.
+var configuredFeeds struct {
+	sync.RWMutex
+	feeds []Feed
+}
+
+func (c *Checker) feeds() []Feed {
+	if c.Feeds != nil {
+		return c.Feeds
+	}
+	configuredFeeds.RLock()
+	defer configuredFeeds.RUnlock()
+	return configuredFeeds.feeds
+}
+
+func (c *Checker) feedTimeout() time.Duration {
+	if c.FeedTimeout > 0 {
+		return c.FeedTimeout
+	}
+	return defaultFeedTimeout
+}
+
+// annotate consults the Checker's feeds about each of the result's preferred
+// hostnames. Feeds that fail, or don't answer within the FeedTimeout, are
+// skipped, so enrichment never holds up or changes the checks themselves.
+func (c *Checker) annotate(result DomainResult) []Annotation {
+	feeds := c.feeds()
+	if len(feeds) == 0 || len(result.PreferredHostnames) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.feedTimeout())
+	defer cancel()
+	type answer struct {
+		feed        string
+		annotations []Annotation
+		err         error
+	}
+	// Buffered so that feeds which answer late don't block forever.
+	answers := make(chan answer, len(feeds)*len(result.PreferredHostnames))
+	for _, hostname := range result.PreferredHostnames {
+		for _, feed := range feeds {
+			go func(feed Feed, h HostnameResult) {
+				annotations, err := feed.Annotate(ctx, h)
+				answers <- answer{feed: feed.Name(), annotations: annotations, err: err}
+			}(feed, result.HostnameResults[hostname])
+		}
+	}
+	var annotations []Annotation
+	for remaining := cap(answers); remaining > 0; remaining-- {
+		select {
+		case a := <-answers:
+			if a.err != nil {
+				feedErrors.Inc(a.feed)
+				log.Printf("Reputation feed %s failed for %s: %v", a.feed, result.Domain, a.err)
+			}
+			annotations = append(annotations, a.annotations...)
+		case <-ctx.Done():
+			log.Printf("Reputation feeds timed out for %s", result.Domain)
+			feedTimeouts.Inc()
+			remaining = 0
+		}
+	}
+	sort.Slice(annotations, func(i, j int) bool {
+		if annotations[i].Hostname != annotations[j].Hostname {
+			return annotations[i].Hostname < annotations[j].Hostname
+		}
+		if annotations[i].Feed != annotations[j].Feed {
+			return annotations[i].Feed < annotations[j].Feed
+		}
+		return annotations[i].Message < annotations[j].Message
+	})
+	return annotations
+}
@@ -0,0 +1,193 @@
+package checker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestListFeed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "# Compromised hosts\nMX.Bad.example.\n\naa11\n")
+	}))
+	defer server.Close()
+	feed := NewListFeed("compromised", server.URL, 0)
+	tests := []struct {
+		result HostnameResult
+		want   int
+	}{
+		{HostnameResult{Hostname: "mx.bad.example"}, 1},
+		{HostnameResult{Hostname: "mx.good.example", CertSPKISHA256: "aa11"}, 1},
+		{HostnameResult{Hostname: "mx.bad.example.", CertSPKISHA256: "aa11"}, 2},
+		{HostnameResult{Hostname: "mx.good.example", CertSPKISHA256: "bb22"}, 0},
+	}
+	for _, test := range tests {
+		annotations, err := feed.Annotate(context.Background(), test.result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(annotations) != test.want {
+			t.Errorf("Expected %d annotations for %+v, got %v", test.want, test.result, annotations)
+		}
+	}
+}
+
+func TestFeedServesStaleListWhenFetchFails(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "mx.bad.example\n")
+	}))
+	defer server.Close()
+	feed := NewListFeed("compromised", server.URL, time.Nanosecond)
+	for i := 0; i < 2; i++ {
+		annotations, err := feed.Annotate(context.Background(), HostnameResult{Hostname: "mx.bad.example"})
+		if err != nil || len(annotations) != 1 {
+			t.Errorf("Expected list to be served after %d fetches, got %v, %v", i+1, annotations, err)
+		}
+	}
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("Expected the list to be re-fetched once, got %d requests", requests)
+	}
+	// Failed fetches aren't retried straight away.
+	feed.Annotate(context.Background(), HostnameResult{Hostname: "mx.bad.example"})
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("Expected failed fetch not to be retried immediately, got %d requests", requests)
+	}
+}
+
+func TestFeedsUseFakeNetwork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the feed not to be fetched from the real network")
+	}))
+	defer server.Close()
+	cfg := DefaultConfig()
+	cfg.FakeNetwork = true
+	Configure(cfg)
+	defer Configure(DefaultConfig())
+	if _, err := readFeed(server.URL); err == nil {
+		t.Error("Expected the fake network not to serve the feed")
+	}
+}
+
+func TestCRLFeed(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCRLSign | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedAt := time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC)
+	crl, err := ca.CreateCRL(rand.Reader, key,
+		[]pkix.RevokedCertificate{{SerialNumber: big.NewInt(0xabc), RevocationTime: revokedAt}},
+		time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(crl)
+	}))
+	defer server.Close()
+	feed := NewCRLFeed("test-ca", server.URL, 0)
+	tests := []struct {
+		result HostnameResult
+		want   int
+	}{
+		{HostnameResult{Hostname: "mx", CertSerial: "abc", CertIssuer: "CN=Test CA"}, 1},
+		{HostnameResult{Hostname: "mx", CertSerial: "abd", CertIssuer: "CN=Test CA"}, 0},
+		{HostnameResult{Hostname: "mx", CertSerial: "abc", CertIssuer: "CN=Other CA"}, 0},
+		{HostnameResult{Hostname: "mx"}, 0},
+	}
+	for _, test := range tests {
+		annotations, err := feed.Annotate(context.Background(), test.result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(annotations) != test.want {
+			t.Errorf("Expected %d annotations for %+v, got %v", test.want, test.result, annotations)
+		}
+	}
+}
+
+// mockFeed annotates every hostname, after delay, or fails.
+type mockFeed struct {
+	name  string
+	delay time.Duration
+	fail  bool
+}
+
+func (f mockFeed) Name() string { return f.name }
+
+func (f mockFeed) Annotate(ctx context.Context, h HostnameResult) ([]Annotation, error) {
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.fail {
+		return nil, errors.New("feed unavailable")
+	}
+	return []Annotation{{Feed: f.name, Hostname: h.Hostname, Message: "listed"}}, nil
+}
+
+func TestFeedFailuresDontAffectChecks(t *testing.T) {
+	c := Checker{
+		Timeout:                time.Second,
+		FeedTimeout:            50 * time.Millisecond,
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+		Feeds: []Feed{
+			mockFeed{name: "fast"},
+			mockFeed{name: "broken", fail: true},
+			mockFeed{name: "slow", delay: time.Minute},
+		},
+	}
+	start := time.Now()
+	result := c.CheckDomain("domain", nil)
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Expected slow feeds to be abandoned, but the check took %v", time.Since(start))
+	}
+	if result.Status != DomainSuccess {
+		t.Errorf("Expected feeds not to affect status, got %d", result.Status)
+	}
+	if len(result.Annotations) != len(result.PreferredHostnames) {
+		t.Fatalf("Expected one annotation per preferred hostname from the fast feed, got %v", result.Annotations)
+	}
+	for _, a := range result.Annotations {
+		if a.Feed != "fast" {
+			t.Errorf("Expected only the fast feed to annotate, got %+v", a)
+		}
+	}
+}
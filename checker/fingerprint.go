@@ -0,0 +1,136 @@
+package checker
+
+import (
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Fingerprint holds lightweight hints about the software a mailserver runs,
+// for counting deployments of each implementation in aggregate stats.
+type Fingerprint struct {
+	// Software is the mail server software named in the server's banner,
+	// with its major and minor version if it gives one, eg. "Exim 4.94" or
+	// "Postfix". Empty if we don't recognize it.
+	Software string `json:"software,omitempty"`
+	// Extensions is the sorted set of extension keywords the server
+	// advertised in response to EHLO, eg. "8BITMIME PIPELINING SIZE
+	// STARTTLS". Implementations tend to advertise different combinations.
+	Extensions string `json:"extensions,omitempty"`
+	// TLS is the TLS version and cipher suite the server chose from the
+	// checker's offer, eg. "TLS 1.2 TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	// which hints at its TLS library and configuration. Empty if it doesn't
+	// support STARTTLS.
+	TLS string `json:"tls,omitempty"`
+}
+
+// knownSoftware matches the banners of common mail server software, checked
+// in order. The first submatch, if any, is the software's version.
+var knownSoftware = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Exim", regexp.MustCompile(`(?i)\bExim\b(?:\s+(\d+\.\d+))?`)},
+	{"Postfix", regexp.MustCompile(`(?i)\bPostfix\b`)},
+	{"Sendmail", regexp.MustCompile(`(?i)\bSendmail\b(?:\s+(\d+\.\d+))?`)},
+	{"Microsoft Exchange", regexp.MustCompile(`(?i)\bMicrosoft ESMTP MAIL Service\b`)},
+	{"OpenSMTPD", regexp.MustCompile(`(?i)\bOpenSMTPD\b`)},
+	{"Haraka", regexp.MustCompile(`(?i)\bHaraka(?:/(\d+\.\d+))?`)},
+	{"Zimbra", regexp.MustCompile(`(?i)\bZimbra\b`)},
+	{"MDaemon", regexp.MustCompile(`(?i)\bMDaemon\b(?:\s+(\d+\.\d+))?`)},
+	{"hMailServer", regexp.MustCompile(`(?i)\bhMailServer\b`)},
+	{"qmail", regexp.MustCompile(`(?i)\bqmail\b`)},
+}
+
+// parseSoftware returns the software, and version, named in banner, as
+// recorded in Fingerprint.Software.
+func parseSoftware(banner string) string {
+	for _, s := range knownSoftware {
+		match := s.pattern.FindStringSubmatch(banner)
+		if match == nil {
+			continue
+		}
+		if len(match) > 1 && match[1] != "" {
+			return s.name + " " + match[1]
+		}
+		return s.name
+	}
+	return ""
+}
+
+// extensionKeywords returns the keywords of extensions, without their
+// parameters, as recorded in Fingerprint.Extensions.
+func extensionKeywords(extensions []string) string {
+	seen := make(map[string]bool)
+	keywords := make([]string, 0, len(extensions))
+	for _, extension := range extensions {
+		fields := strings.Fields(extension)
+		if len(fields) == 0 {
+			continue
+		}
+		keyword := strings.ToUpper(fields[0])
+		if !seen[keyword] {
+			seen[keyword] = true
+			keywords = append(keywords, keyword)
+		}
+	}
+	sort.Strings(keywords)
+	return strings.Join(keywords, " ")
+}
+
+// makeFingerprint returns the fingerprint of a mailserver with banner, which
+// advertised extensions, before STARTTLS.
+func makeFingerprint(banner string, extensions []string) *Fingerprint {
+	return &Fingerprint{
+		Software:   parseSoftware(banner),
+		Extensions: extensionKeywords(extensions),
+	}
+}
+
+// greeting records the first line of the server's greeting, its banner.
+type greeting struct {
+	line     []byte
+	finished bool
+}
+
+func (g *greeting) record(data []byte) {
+	if g.finished {
+		return
+	}
+	if i := strings.IndexByte(string(data), '\n'); i >= 0 {
+		data = data[:i]
+		g.finished = true
+	}
+	if len(g.line)+len(data) > maxBannerLength {
+		data = data[:maxBannerLength-len(g.line)]
+		g.finished = true
+	}
+	g.line = append(g.line, data...)
+}
+
+// maxBannerLength caps the size of a recorded banner.
+const maxBannerLength = 512
+
+// Banner returns the text of the server's banner, without its reply code.
+func (g *greeting) Banner() string {
+	banner := strings.TrimRight(string(g.line), "\r")
+	if len(banner) >= 4 && (banner[3] == ' ' || banner[3] == '-') {
+		banner = banner[4:]
+	}
+	return strings.TrimSpace(banner)
+}
+
+// greetingConn records the server's banner from a connection.
+type greetingConn struct {
+	net.Conn
+	greeting *greeting
+}
+
+func (c *greetingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.greeting.record(b[:n])
+	}
+	return n, err
+}
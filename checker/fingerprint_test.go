@@ -0,0 +1,36 @@
+package checker
+
+import "testing"
+
+func TestParseSoftware(t *testing.T) {
+	tests := map[string]string{
+		"mx.example.com ESMTP Exim 4.94.2 Tue, 13 Oct 2026 10:00:00 +0000": "Exim 4.94",
+		"mx.example.com ESMTP Postfix (Debian/GNU)":                        "Postfix",
+		"mx.example.com ESMTP Sendmail 8.15.2/8.15.2; Tue, 13 Oct 2026":    "Sendmail 8.15",
+		"mx.example.com Microsoft ESMTP MAIL Service ready at Tue":         "Microsoft Exchange",
+		"mx.example.com ESMTP Haraka/2.8.25 ready":                         "Haraka 2.8",
+		"mx.google.com ESMTP a1si123456qkb.1 - gsmtp":                      "",
+	}
+	for banner, software := range tests {
+		if got := parseSoftware(banner); got != software {
+			t.Errorf("Expected software %q for banner %q, got %q", software, banner, got)
+		}
+	}
+}
+
+func TestExtensionKeywords(t *testing.T) {
+	got := extensionKeywords([]string{"SIZE 35882577", "pipelining", "STARTTLS", "8BITMIME", "PIPELINING"})
+	if got != "8BITMIME PIPELINING SIZE STARTTLS" {
+		t.Errorf("Expected sorted, distinct keywords without parameters, got %q", got)
+	}
+}
+
+func TestGreetingBanner(t *testing.T) {
+	g := &greeting{}
+	g.record([]byte("220-mx.example.com ESMTP Exim"))
+	g.record([]byte(" 4.94\r\n220 second line\r\n"))
+	g.record([]byte("250 later\r\n"))
+	if banner := g.Banner(); banner != "mx.example.com ESMTP Exim 4.94" {
+		t.Errorf("Expected first line of greeting without reply code, got %q", banner)
+	}
+}
@@ -0,0 +1,102 @@
+package checker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Grade is a letter grade summarizing how securely mail can be delivered to
+// a domain, from A (best) to F.
+type Grade string
+
+// Possible grades, from best to worst.
+const (
+	GradeA Grade = "A"
+	GradeB Grade = "B"
+	GradeC Grade = "C"
+	GradeD Grade = "D"
+	GradeF Grade = "F"
+)
+
+// NOTE: if you change the grading criteria, remember to fix the documentation in `README.md`.
+
+// ScoreDomain grades a DomainResult, and explains the grade. Only preferred
+// hostnames are considered. A domain gets the first grade whose criteria it
+// meets:
+//
+//	F: We couldn't connect to any mailserver, or a mailserver doesn't
+//	   support STARTTLS.
+//	D: A mailserver presents an invalid certificate.
+//	C: A mailserver doesn't negotiate TLS 1.2 or newer, or still supports
+//	   SSLv3.
+//	B: Every mailserver supports STARTTLS with a valid certificate and
+//	   TLS 1.2+, but senders aren't told to require it.
+//	A: As for B, and the domain has an MTA-STS policy in enforce mode or
+//	   DANE TLSA records for every mailserver.
+func ScoreDomain(r DomainResult) (Grade, []string) {
+	return scoreDomain(r, nil)
+}
+
+// scoreDomain is ScoreDomain, recording the criteria it evaluates to trace.
+func scoreDomain(r DomainResult, trace *decisions) (Grade, []string) {
+	if len(r.PreferredHostnames) == 0 {
+		reason := "Could not connect to any mailserver."
+		if r.TimedOut {
+			reason = "Timed out before any mailserver could be checked."
+		}
+		trace.add(Decision{Rule: "grade.connect", Outcome: string(GradeF), Reason: reason, Final: true})
+		return GradeF, []string{reason}
+	}
+	var noSTARTTLS, badCert, oldVersion []string
+	for _, hostname := range r.PreferredHostnames {
+		hostnameResult, ok := r.HostnameResults[hostname]
+		if !ok || hostnameResult.Result == nil || !hostnameResult.couldSTARTTLS() {
+			noSTARTTLS = append(noSTARTTLS, hostname)
+			continue
+		}
+		if !hostnameResult.subcheckSucceeded(Certificate) {
+			badCert = append(badCert, hostname)
+		}
+		if !hostnameResult.subcheckSucceeded(Version) {
+			oldVersion = append(oldVersion, hostname)
+		}
+	}
+	if len(noSTARTTLS) > 0 {
+		reason := fmt.Sprintf("STARTTLS is not supported by %v.", noSTARTTLS)
+		trace.add(Decision{Rule: "grade.starttls", Outcome: string(GradeF), Reason: reason, Final: true})
+		return GradeF, []string{reason}
+	}
+	trace.add(Decision{Rule: "grade.starttls", Outcome: "pass", Reason: "Every preferred mailserver supports STARTTLS."})
+	if len(badCert) > 0 {
+		reason := fmt.Sprintf("Invalid certificates are presented by %v.", badCert)
+		trace.add(Decision{Rule: "grade.certificate", Outcome: string(GradeD), Reason: reason, Final: true})
+		return GradeD, []string{reason}
+	}
+	trace.add(Decision{Rule: "grade.certificate", Outcome: "pass", Reason: "Every preferred mailserver presents a valid certificate."})
+	if len(oldVersion) > 0 {
+		reason := fmt.Sprintf("TLS 1.2 or newer isn't negotiated, or SSLv3 is supported, by %v.", oldVersion)
+		trace.add(Decision{Rule: "grade.tls_version", Outcome: string(GradeC), Reason: reason, Final: true})
+		return GradeC, []string{reason}
+	}
+	trace.add(Decision{Rule: "grade.tls_version", Outcome: "pass", Reason: "Every preferred mailserver negotiates TLS 1.2 or newer, without SSLv3."})
+	reasons := []string{"All mailservers support STARTTLS with valid certificates and TLS 1.2 or newer."}
+	enforce := r.MTASTSResult != nil && r.MTASTSResult.Result != nil &&
+		r.MTASTSResult.Status == Success && r.MTASTSResult.Mode == "enforce"
+	dane := len(r.DANEHostnames) > 0
+	for _, hostname := range r.PreferredHostnames {
+		dane = dane && containsString(r.DANEHostnames, hostname)
+	}
+	if enforce {
+		reasons = append(reasons, "MTA-STS policy is valid and in enforce mode.")
+	}
+	if dane {
+		reasons = append(reasons, "DANE TLSA records are published for every mailserver.")
+	}
+	if !enforce && !dane {
+		reasons = append(reasons, "Neither an enforced MTA-STS policy nor DANE tells senders to require TLS.")
+		trace.add(Decision{Rule: "grade.required_tls", Outcome: string(GradeB), Reason: reasons[1], Final: true})
+		return GradeB, reasons
+	}
+	trace.add(Decision{Rule: "grade.required_tls", Outcome: string(GradeA), Reason: strings.Join(reasons[1:], " "), Final: true})
+	return GradeA, reasons
+}
@@ -0,0 +1,62 @@
+package checker
+
+import "testing"
+
+func TestScoreDomain(t *testing.T) {
+	withCheck := func(r DomainResult, name string, status Status) DomainResult {
+		hostname := r.PreferredHostnames[0]
+		checks := make(map[string]*Result)
+		for k, v := range r.HostnameResults[hostname].Checks {
+			checks[k] = v
+		}
+		checks[name] = &Result{Name: name, Status: status}
+		hostnameResult := r.HostnameResults[hostname]
+		hostnameResult.Result = &Result{Checks: checks}
+		r.HostnameResults = map[string]HostnameResult{hostname: hostnameResult}
+		return r
+	}
+	testingMode := NewSampleDomainResult("example.com")
+	testingMode.MTASTSResult = &MTASTSResult{Result: &Result{Status: Success}, Mode: "testing"}
+	dane := testingMode
+	dane.DANEHostnames = []string{"mx.example.com"}
+	noConnection := NewSampleDomainResult("example.com")
+	noConnection.PreferredHostnames = nil
+
+	tests := []struct {
+		name   string
+		result DomainResult
+		grade  Grade
+	}{
+		{"enforce", NewSampleDomainResult("example.com"), GradeA},
+		{"dane", dane, GradeA},
+		{"testing", testingMode, GradeB},
+		{"old version", withCheck(NewSampleDomainResult("example.com"), Version, Warning), GradeC},
+		{"sslv3", withCheck(NewSampleDomainResult("example.com"), Version, Failure), GradeC},
+		{"bad certificate", withCheck(NewSampleDomainResult("example.com"), Certificate, Failure), GradeD},
+		{"no starttls", withCheck(NewSampleDomainResult("example.com"), STARTTLS, Failure), GradeF},
+		{"no connection", noConnection, GradeF},
+	}
+	for _, test := range tests {
+		grade, reasons := ScoreDomain(test.result)
+		if grade != test.grade {
+			t.Errorf("%s: expected grade %s, got %s (%v)", test.name, test.grade, grade, reasons)
+		}
+		if len(reasons) == 0 {
+			t.Errorf("%s: expected reasons for grade %s", test.name, grade)
+		}
+	}
+}
+
+func TestCheckDomainIsGraded(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	result := c.CheckDomain("domain", nil)
+	if result.Grade == "" || len(result.GradeReasons) == 0 {
+		t.Errorf("Expected CheckDomain to grade its result, got %q", result.Grade)
+	}
+}
@@ -0,0 +1,148 @@
+package checker
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default per-host limits, which can be overwritten by Config. A full
+// hostname check makes a few connections, so these leave room for a handful
+// of checks of the same mailserver each minute.
+const (
+	defaultMaxHostConnections          = 4
+	defaultMaxHostConnectionsPerMinute = 30
+)
+
+// errHostConnectionLimit is returned when we already have as many connections
+// open to a mailserver as we allow, and none closes before the check's timeout.
+var errHostConnectionLimit = errors.New("checker is at its limit of open connections to this mailserver")
+
+// errHostRateLimit is returned when we've connected to a mailserver as often
+// as we allow in the last minute, and can't wait for the check's timeout.
+var errHostRateLimit = errors.New("checker is at its limit of connections per minute to this mailserver")
+
+// hostLimiter caps the open connections and the connections per minute to
+// each mailserver, so bulk and API scans running at the same time don't get
+// us blocklisted. Mailservers are keyed by the host we dial, which is usually
+// the MX hostname.
+type hostLimiter struct {
+	maxConnections int
+	maxPerMinute   int
+
+	mu        sync.Mutex
+	hosts     map[string]*hostState
+	lastSweep time.Time
+}
+
+type hostState struct {
+	limiter *hostLimiter
+	slots   chan struct{}
+	// Times of dials in the last minute, oldest first.
+	dials []time.Time
+	// users counts the callers that have the state, from looking it up
+	// until they release it or give up, so that it isn't swept while a
+	// caller is waiting for a slot. Guarded by limiter.mu.
+	users int
+}
+
+func newHostLimiter(maxConnections int, maxPerMinute int) *hostLimiter {
+	return &hostLimiter{
+		maxConnections: maxConnections,
+		maxPerMinute:   maxPerMinute,
+		hosts:          make(map[string]*hostState),
+	}
+}
+
+// hostKey returns the mailserver an address like "mx.example.com:25" refers to.
+func hostKey(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// state returns the state of host, which the caller uses until it releases
+// it or gives up with done.
+func (h *hostLimiter) state(host string, now time.Time) *hostState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if now.Sub(h.lastSweep) > time.Minute {
+		h.sweep(now)
+	}
+	s, ok := h.hosts[host]
+	if !ok {
+		s = &hostState{limiter: h, slots: make(chan struct{}, h.maxConnections)}
+		h.hosts[host] = s
+	}
+	s.users++
+	return s
+}
+
+// sweep forgets mailservers that no caller is using and that have no recent
+// dials, so the map doesn't grow for the length of a bulk scan. h.mu must be
+// held.
+func (h *hostLimiter) sweep(now time.Time) {
+	h.lastSweep = now
+	for host, s := range h.hosts {
+		s.prune(now)
+		if s.users == 0 && len(s.dials) == 0 {
+			delete(h.hosts, host)
+		}
+	}
+}
+
+// prune forgets dials more than a minute before now. h.mu must be held.
+func (s *hostState) prune(now time.Time) {
+	i := 0
+	for i < len(s.dials) && now.Sub(s.dials[i]) >= time.Minute {
+		i++
+	}
+	s.dials = s.dials[i:]
+}
+
+// acquire waits until a connection to address is allowed by both per-host
+// limits, giving up at deadline. Each successful acquire must be followed by
+// a release of the returned state.
+func (h *hostLimiter) acquire(address string, deadline time.Time) (*hostState, error) {
+	s := h.state(hostKey(address), time.Now())
+	select {
+	case s.slots <- struct{}{}:
+	case <-time.After(time.Until(deadline)):
+		s.done()
+		return nil, errHostConnectionLimit
+	}
+	for {
+		h.mu.Lock()
+		now := time.Now()
+		s.prune(now)
+		if len(s.dials) < h.maxPerMinute {
+			s.dials = append(s.dials, now)
+			h.mu.Unlock()
+			return s, nil
+		}
+		wait := s.dials[0].Add(time.Minute).Sub(now)
+		h.mu.Unlock()
+		if now.Add(wait).After(deadline) {
+			s.release()
+			return nil, errHostRateLimit
+		}
+		time.Sleep(wait)
+	}
+}
+
+// release frees the connection slot taken by acquire.
+func (s *hostState) release() {
+	<-s.slots
+	s.done()
+}
+
+// done stops using the state, so it can be swept once it's idle.
+func (s *hostState) done() {
+	s.limiter.mu.Lock()
+	s.users--
+	s.limiter.mu.Unlock()
+}
@@ -0,0 +1,119 @@
+package checker
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHostLimiterCapsOpenConnections(t *testing.T) {
+	h := newHostLimiter(1, 100)
+	first, err := h.acquire("mx.example.com:25", time.Now().Add(testTimeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.acquire("MX.example.com.:25", time.Now().Add(testTimeout)); err != errHostConnectionLimit {
+		t.Errorf("Expected second connection to the same host to hit the limit, got %v", err)
+	}
+	other, err := h.acquire("mx.example.net:25", time.Now().Add(testTimeout))
+	if err != nil {
+		t.Errorf("Expected connection to another host to succeed: %v", err)
+	} else {
+		other.release()
+	}
+	first.release()
+	if s, err := h.acquire("mx.example.com:25", time.Now().Add(testTimeout)); err != nil {
+		t.Errorf("Expected connection to succeed after release: %v", err)
+	} else {
+		s.release()
+	}
+}
+
+func TestHostLimiterCapsConnectionsPerMinute(t *testing.T) {
+	h := newHostLimiter(10, 2)
+	for i := 0; i < 2; i++ {
+		s, err := h.acquire("mx.example.com:25", time.Now().Add(testTimeout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.release()
+	}
+	if _, err := h.acquire("mx.example.com:25", time.Now().Add(testTimeout)); err != errHostRateLimit {
+		t.Errorf("Expected third connection in a minute to hit the rate limit, got %v", err)
+	}
+	// The rejected dial shouldn't hold on to a connection slot.
+	if n := len(h.hosts["mx.example.com"].slots); n != 0 {
+		t.Errorf("Expected no open connections, got %d", n)
+	}
+
+	// Once the earlier dials are a minute old, we can connect again.
+	h.mu.Lock()
+	h.hosts["mx.example.com"].dials[0] = time.Now().Add(-time.Minute)
+	h.mu.Unlock()
+	s, err := h.acquire("mx.example.com:25", time.Now().Add(testTimeout))
+	if err != nil {
+		t.Fatalf("Expected connection to succeed after a minute: %v", err)
+	}
+	s.release()
+}
+
+func TestHostLimiterSweepsIdleHosts(t *testing.T) {
+	h := newHostLimiter(1, 1)
+	s, err := h.acquire("mx.example.com:25", time.Now().Add(testTimeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.release()
+	h.mu.Lock()
+	h.sweep(time.Now().Add(2 * time.Minute))
+	h.mu.Unlock()
+	if len(h.hosts) != 0 {
+		t.Errorf("Expected idle host to be forgotten, got %v", h.hosts)
+	}
+}
+
+func TestHostLimiterKeepsHostsInUse(t *testing.T) {
+	h := newHostLimiter(1, 1)
+	// A caller that's looked up the host, but is still waiting for a slot.
+	s := h.state("mx.example.com", time.Now())
+	h.mu.Lock()
+	h.sweep(time.Now().Add(2 * time.Minute))
+	h.mu.Unlock()
+	if h.hosts["mx.example.com"] != s {
+		t.Fatalf("Expected a host in use not to be forgotten, got %v", h.hosts)
+	}
+	s.done()
+	h.mu.Lock()
+	h.sweep(time.Now().Add(2 * time.Minute))
+	h.mu.Unlock()
+	if len(h.hosts) != 0 {
+		t.Errorf("Expected the host to be forgotten once it's idle, got %v", h.hosts)
+	}
+}
+
+func TestLimiterAppliesHostLimits(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	l := newLimiter(10, 1024)
+	l.hosts = newHostLimiter(1, 10)
+	conn, err := l.dial(ln.Addr().String(), testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.dial(ln.Addr().String(), testTimeout); err != errHostConnectionLimit {
+		t.Errorf("Expected second dial to hit the host connection limit, got %v", err)
+	}
+	conn.Close()
+	if l.stats.HostLimitedConnections != 1 || l.stats.OpenConnections != 0 {
+		t.Errorf("Unexpected limiter stats %+v", l.stats)
+	}
+	if conn, err = l.dial(ln.Addr().String(), testTimeout); err != nil {
+		t.Errorf("Expected dial to succeed after the connection closed: %v", err)
+	} else {
+		conn.Close()
+	}
+}
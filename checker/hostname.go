@@ -1,13 +1,19 @@
 package checker
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
+	"io"
 	"net"
 	"net/smtp"
-	"os"
+	"net/textproto"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/EFForg/starttls-backend/tracing"
 )
 
 // HostnameResult wraps the results of a security check against a particular hostname.
@@ -16,6 +22,45 @@ type HostnameResult struct {
 	Domain    string    `json:"domain"`
 	Hostname  string    `json:"hostname"`
 	Timestamp time.Time `json:"-"`
+	// TemporaryFailure is set when the server refused us with a 4xx reply or
+	// dropped the connection immediately, as greylisting servers do.
+	TemporaryFailure bool `json:"temporary_failure,omitempty"`
+	// TimedOut is set when the domain check's deadline passed before this
+	// hostname could be checked.
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Stale is set when this is an expired result from the Checker's cache,
+	// used while a fresh check runs in the background. See
+	// ScanCache.StaleWhileRevalidate.
+	Stale bool `json:"stale,omitempty"`
+	// Extensions lists the SMTP service extensions advertised in response to
+	// EHLO before STARTTLS, eg. "SIZE 35882577" or "PIPELINING".
+	Extensions []string `json:"extensions,omitempty"`
+	// Transcript of the SMTP session up to STARTTLS. Only recorded by
+	// VerboseCheckHostname.
+	Transcript []string `json:"transcript,omitempty"`
+	// Details of the names that were checked, if the certificate doesn't
+	// match the hostname.
+	NameMismatch *NameMismatch `json:"name_mismatch,omitempty"`
+	// When the certificate presented by the mailserver expires.
+	CertNotAfter *time.Time `json:"cert_not_after,omitempty"`
+	// SHA-256 hashes of the public keys of the certificate presented by the
+	// mailserver, and of its issuer, for generating DANE TLSA records.
+	CertSPKISHA256   string `json:"cert_spki_sha256,omitempty"`
+	IssuerSPKISHA256 string `json:"issuer_spki_sha256,omitempty"`
+	// Serial number, in hex, and issuer of the certificate presented by the
+	// mailserver, for checking certificate revocation lists.
+	CertSerial string `json:"cert_serial,omitempty"`
+	CertIssuer string `json:"cert_issuer,omitempty"`
+	// The TLS version and cipher suite negotiated after STARTTLS, eg.
+	// "TLS 1.3" and "TLS_AES_128_GCM_SHA256".
+	TLSVersion  string `json:"tls_version,omitempty"`
+	CipherSuite string `json:"cipher_suite,omitempty"`
+	// Hints about the software the mailserver runs, if we could connect to
+	// it.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+	// Informational results, which don't affect Status. Only checked by
+	// VerboseCheckHostname.
+	InfoResults map[string]*Result `json:"info_results,omitempty"`
 }
 
 func (h HostnameResult) couldConnect() bool {
@@ -59,30 +104,135 @@ func withoutPort(url string) string {
 	return url
 }
 
-// Retrieves this machine's hostname, if specified.
-func getThisHostname() string {
-	hostname := os.Getenv("HOSTNAME")
-	if len(hostname) == 0 {
-		return "localhost"
+// isTemporaryFailure returns true if err indicates that the server asked us
+// to come back later (a 4xx reply) or hung up before greeting us.
+func isTemporaryFailure(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if tpErr, ok := err.(*textproto.Error); ok {
+		return tpErr.Code >= 400 && tpErr.Code < 500
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		// Connection was established, but reset while reading the greeting.
+		return opErr.Op == "read"
 	}
-	return hostname
+	return false
 }
 
 // Performs an SMTP dial with a short timeout.
 // https://github.com/golang/go/issues/16436
 func smtpDialWithTimeout(hostname string, timeout time.Duration) (*smtp.Client, error) {
+	return smtpDialContext(context.Background(), hostname, timeout)
+}
+
+// Performs an SMTP dial like smtpDialWithTimeout, whose connection is closed
+// once ctx is done.
+func smtpDialContext(ctx context.Context, hostname string, timeout time.Duration) (*smtp.Client, error) {
+	return smtpDialRecorded(ctx, hostname, timeout, nil, nil)
+}
+
+// Performs an SMTP dial like smtpDialContext. If t is not nil, the session
+// is recorded to t. If g is not nil, the server's banner is recorded to g.
+func smtpDialRecorded(ctx context.Context, hostname string, timeout time.Duration, t *transcript, g *greeting) (*smtp.Client, error) {
+	if t == nil && g == nil {
+		return smtpDialWrapped(ctx, hostname, timeout, nil)
+	}
+	return smtpDialWrapped(ctx, hostname, timeout, func(conn net.Conn) net.Conn {
+		if t != nil {
+			conn = &transcriptConn{Conn: conn, transcript: t}
+		}
+		if g != nil {
+			conn = &greetingConn{Conn: conn, greeting: g}
+		}
+		return conn
+	})
+}
+
+// Performs an SMTP dial like smtpDialContext. If wrap is not nil, the SMTP
+// session runs over the connection it returns.
+func smtpDialWrapped(ctx context.Context, hostname string, timeout time.Duration, wrap func(net.Conn) net.Conn) (*smtp.Client, error) {
 	if _, _, err := net.SplitHostPort(hostname); err != nil {
-		hostname += ":25"
+		hostname = net.JoinHostPort(hostname, smtpPort())
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	conn, err := net.DialTimeout("tcp", hostname, timeout)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	conn, err := getLimiter().dial(hostname, timeout)
 	if err != nil {
 		return nil, err
 	}
+	conn = closeWhenDone(ctx, conn)
+	if wrap != nil {
+		conn = wrap(conn)
+	}
 	client, err := smtp.NewClient(conn, hostname)
 	if err != nil {
 		return client, err
 	}
-	return client, client.Hello(getThisHostname())
+	if err = client.Hello(EHLOHostname()); err != nil {
+		// Callers don't close the client on error, so don't leak the connection.
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// closeWhenDone returns conn, which is closed once ctx is done, so that
+// checks that are no longer wanted stop waiting for the server.
+func closeWhenDone(ctx context.Context, conn net.Conn) net.Conn {
+	if ctx.Done() == nil {
+		return conn
+	}
+	c := &contextConn{Conn: conn, closed: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-c.closed:
+		}
+	}()
+	return c
+}
+
+// contextConn is a connection returned by closeWhenDone.
+type contextConn struct {
+	net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *contextConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// Retrieves the full list of extensions advertised by the server. net/smtp
+// only exposes lookups of individual extensions, so we repeat the EHLO, which
+// servers must accept (RFC 5321 section 4.1.4).
+func listExtensions(client *smtp.Client) []string {
+	id, err := client.Text.Cmd("EHLO %s", EHLOHostname())
+	if err != nil {
+		return nil
+	}
+	client.Text.StartResponse(id)
+	defer client.Text.EndResponse(id)
+	_, msg, err := client.Text.ReadResponse(250)
+	if err != nil {
+		return nil
+	}
+	// The first line of the response is the server's greeting.
+	lines := strings.Split(msg, "\n")
+	extensions := make([]string, 0)
+	for _, line := range lines[1:] {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			extensions = append(extensions, line)
+		}
+	}
+	return extensions
 }
 
 // Simply tries to StartTLS with the server.
@@ -93,9 +243,11 @@ func checkStartTLS(client *smtp.Client) *Result {
 		return result.Failure("Server does not advertise support for STARTTLS.")
 	}
 	config := tls.Config{InsecureSkipVerify: true}
+	start := time.Now()
 	if err := client.StartTLS(&config); err != nil {
 		return result.Failure("Could not complete a TLS handshake.")
 	}
+	handshakeSeconds.ObserveSince(start)
 	return result.Success()
 }
 
@@ -110,15 +262,20 @@ func defaultValidMX(domain, hostname string) []string {
 	return []string{domain, hostname}
 }
 
-// Validates that a certificate chain is valid for this system roots.
-func verifyCertChain(state tls.ConnectionState) error {
+// Validates that a certificate chain is valid for this system roots at time t.
+func verifyCertChain(state tls.ConnectionState, t time.Time) error {
 	pool := x509.NewCertPool()
 	for _, peerCert := range state.PeerCertificates[1:] {
 		pool.AddCert(peerCert)
 	}
+	roots := certRoots
+	if fake := fakeNetworkInUse(); fake != nil {
+		roots = fake.roots
+	}
 	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
-		Roots:         certRoots,
+		Roots:         roots,
 		Intermediates: pool,
+		CurrentTime:   t,
 	})
 	return err
 }
@@ -131,7 +288,8 @@ func verifyCertChain(state tls.ConnectionState) error {
 var certRoots *x509.CertPool
 
 // Checks that the certificate presented is valid for a particular hostname, unexpired,
-// and chains to a trusted root.
+// and chains to a trusted root. Each of these is reported as a separate
+// sub-check, and their messages are repeated in the certificate result.
 func checkCert(client *smtp.Client, domain, hostname string) *Result {
 	result := MakeResult(Certificate)
 	state, ok := client.TLSConnectionState()
@@ -139,17 +297,22 @@ func checkCert(client *smtp.Client, domain, hostname string) *Result {
 		return result.Error("TLS not initiated properly.")
 	}
 	cert := state.PeerCertificates[0]
+	now := time.Now()
 	// If hostname is an FQDN, it might end with '.'
 	hostname = strings.TrimSuffix(hostname, ".")
-	err := cert.VerifyHostname(withoutPort(hostname))
-	if err != nil {
-		result.Failure("Name in cert doesn't match hostname: %v", err)
+	expired, notYetValid := checkCertExpiry(cert, now)
+	trustedRoot, selfSigned := checkCertTrust(state, now)
+	for _, check := range []*Result{
+		checkCertHostname(cert, withoutPort(hostname)),
+		trustedRoot,
+		selfSigned,
+		expired,
+		notYetValid,
+	} {
+		result.addCheck(check)
+		result.Messages = append(result.Messages, check.Messages...)
 	}
-	err = verifyCertChain(state)
-	if err != nil {
-		return result.Failure("Certificate root is not trusted: %v", err)
-	}
-	return result.Success()
+	return result
 }
 
 func tlsConfigForCipher(ciphers []uint16) tls.Config {
@@ -179,7 +342,7 @@ func checkTLSCipher(hostname string, timeout time.Duration) *Result {
 	return result.Success()
 }
 
-func checkTLSVersion(client *smtp.Client, hostname string, timeout time.Duration) *Result {
+func checkTLSVersion(ctx context.Context, client *smtp.Client, hostname string, timeout time.Duration) *Result {
 	result := MakeResult(Version)
 
 	// Check the TLS version of the existing connection.
@@ -193,7 +356,7 @@ func checkTLSVersion(client *smtp.Client, hostname string, timeout time.Duration
 	}
 
 	// Attempt to connect with an old SSL version.
-	client, err := smtpDialWithTimeout(hostname, timeout)
+	client, err := smtpDialContext(ctx, hostname, timeout)
 	if err != nil {
 		return result.Error("Could not establish connection: %v", err)
 	}
@@ -210,26 +373,131 @@ func checkTLSVersion(client *smtp.Client, hostname string, timeout time.Duration
 	return result.Success()
 }
 
-// checkHostname returns the result of c.CheckHostname or FullCheckHostname,
-// using or updating the Checker's cache.
+// checkHostname returns the result of c's hostname check, using or updating
+// the Checker's cache.
 func (c *Checker) checkHostname(domain string, hostname string) HostnameResult {
-	check := c.CheckHostname
-	if check == nil {
-		// If CheckHostname hasn't been set, default to the full set of checks.
-		check = FullCheckHostname
-	}
+	return c.checkHostnameContext(context.Background(), domain, hostname)
+}
+
+// checkHostnameContext performs checkHostname, tracing cache writes as
+// children of the span in ctx, if any.
+func (c *Checker) checkHostnameContext(ctx context.Context, domain string, hostname string) HostnameResult {
+	check := c.hostnameCheck().Check
 
 	if c.Cache == nil {
-		return check(domain, hostname, c.timeout())
+		return c.checkWithRetries(ctx, check, domain, hostname)
 	}
-	hostnameResult, err := c.Cache.GetHostnameScan(hostname)
+	hostnameResult, stale, err := c.Cache.getHostnameScan(hostname, c.CacheOptions)
 	if err != nil {
-		hostnameResult = check(domain, hostname, c.timeout())
-		c.Cache.PutHostnameScan(hostname, hostnameResult)
+		var shared bool
+		hostnameResult, shared, err = c.Cache.check(ctx, hostname, func() HostnameResult {
+			// Other scans may be waiting on this check, so it isn't
+			// interrupted by this scan's deadline; each scan stops waiting
+			// at its own.
+			checkCtx, cancel := context.WithTimeout(context.Background(), c.hostnameCheckTimeout())
+			defer cancel()
+			result := c.checkWithRetries(checkCtx, check, domain, hostname)
+			// Temporary failures aren't cached, so that greylisted hostnames
+			// can be checked again soon after, and neither are checks that
+			// were interrupted.
+			if checkCtx.Err() != nil {
+				return timedOutHostnameResult(domain, hostname)
+			}
+			if !result.TemporaryFailure {
+				_, span := tracing.Start(ctx, "cache.put_hostname_scan", "hostname", hostname)
+				span.SetError(c.Cache.PutHostnameScan(hostname, result))
+				span.End()
+			}
+			return result
+		})
+		if shared {
+			cacheLookups.Inc("coalesced")
+		} else {
+			cacheLookups.Inc("miss")
+		}
+		if err != nil {
+			// The scan was cancelled while waiting for another's check.
+			return timedOutHostnameResult(domain, hostname)
+		}
+	} else if stale {
+		cacheLookups.Inc("stale")
+		// Refreshes outlive the scan that started them.
+		c.Cache.refresh(hostname, func() HostnameResult {
+			return c.checkWithRetries(context.Background(), check, domain, hostname)
+		})
+		hostnameResult.Stale = true
+	} else {
+		cacheLookups.Inc("hit")
 	}
 	return hostnameResult
 }
 
+// checkHostnameBefore performs checkHostname, but gives up at deadline and
+// returns a timed out result instead, interrupting the check. If deadline is
+// zero, it waits for the check to finish.
+func (c *Checker) checkHostnameBefore(ctx context.Context, domain string, hostname string, deadline time.Time) HostnameResult {
+	ctx, span := tracing.Start(ctx, "checker.check_hostname", "hostname", hostname)
+	defer span.End()
+	if deadline.IsZero() {
+		return c.tracedCheckHostname(ctx, span, domain, hostname)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	done := make(chan HostnameResult, 1)
+	go func() {
+		// Once we've timed out, the check's connections are closed, so it
+		// finishes soon after.
+		done <- c.tracedCheckHostname(ctx, span, domain, hostname)
+	}()
+	select {
+	case result := <-done:
+		return result
+	case <-ctx.Done():
+		span.SetAttributes("timed_out", "true")
+		return timedOutHostnameResult(domain, hostname)
+	}
+}
+
+// tracedCheckHostname performs checkHostnameContext, describing the result on
+// span.
+func (c *Checker) tracedCheckHostname(ctx context.Context, span *tracing.Span, domain string, hostname string) HostnameResult {
+	result := c.checkHostnameContext(ctx, domain, hostname)
+	if result.Result != nil {
+		span.SetAttributes("status", fmt.Sprint(result.Status))
+	}
+	if result.Stale {
+		span.SetAttributes("stale", "true")
+	}
+	return result
+}
+
+func timedOutHostnameResult(domain string, hostname string) HostnameResult {
+	r := HostnameResult{
+		Domain:   domain,
+		Hostname: hostname,
+		Result:   MakeResult("hostnames"),
+		TimedOut: true,
+	}
+	r.addCheck(MakeResult(Connectivity).Error("Timed out before this mailserver could be checked"))
+	return r
+}
+
+// checkWithRetries performs check, and re-attempts it up to c.GreylistRetries
+// times while the hostname reports a temporary failure, until ctx is done.
+func (c *Checker) checkWithRetries(ctx context.Context, check func(context.Context, string, string, time.Duration) HostnameResult,
+	domain string, hostname string) HostnameResult {
+	result := check(ctx, domain, hostname, c.timeout())
+	for attempt := 0; attempt < c.GreylistRetries && result.TemporaryFailure; attempt++ {
+		select {
+		case <-time.After(c.greylistRetryDelay()):
+		case <-ctx.Done():
+			return result
+		}
+		result = check(ctx, domain, hostname, c.timeout())
+	}
+	return result
+}
+
 // NoopCheckHostname returns a fake error result containing `domain` and `hostname`.
 func NoopCheckHostname(domain string, hostname string, _ time.Duration) HostnameResult {
 	r := HostnameResult{
@@ -245,6 +513,18 @@ func NoopCheckHostname(domain string, hostname string, _ time.Duration) Hostname
 // `domain` is the mail domain that this server serves email for.
 // `hostname` is the hostname for this server.
 func FullCheckHostname(domain string, hostname string, timeout time.Duration) HostnameResult {
+	return FullCheckHostnameContext(context.Background(), domain, hostname, timeout)
+}
+
+// FullCheckHostnameContext performs FullCheckHostname, closing its
+// connections once ctx is done.
+func FullCheckHostnameContext(ctx context.Context, domain string, hostname string, timeout time.Duration) HostnameResult {
+	return fullCheckHostname(ctx, domain, hostname, timeout, nil)
+}
+
+// fullCheckHostname performs the checks in FullCheckHostname. If t is not
+// nil, the primary SMTP session is recorded to it.
+func fullCheckHostname(ctx context.Context, domain string, hostname string, timeout time.Duration, t *transcript) HostnameResult {
 	result := HostnameResult{
 		Domain:    domain,
 		Hostname:  hostname,
@@ -254,23 +534,43 @@ func FullCheckHostname(domain string, hostname string, timeout time.Duration) Ho
 
 	// Connect to the SMTP server and use that connection to perform as many checks as possible.
 	connectivityResult := MakeResult(Connectivity)
-	client, err := smtpDialWithTimeout(hostname, timeout)
+	g := &greeting{}
+	client, err := smtpDialRecorded(ctx, hostname, timeout, t, g)
 	if err != nil {
 		result.addCheck(connectivityResult.Error("Could not establish connection: %v", err))
+		result.TemporaryFailure = isTemporaryFailure(err)
 		return result
 	}
 	defer client.Close()
 	result.addCheck(connectivityResult.Success())
+	result.Extensions = listExtensions(client)
+	result.Fingerprint = makeFingerprint(g.Banner(), result.Extensions)
 
 	result.addCheck(checkStartTLS(client))
 	if result.Status != Success {
 		return result
 	}
 	result.addCheck(checkCert(client, domain, hostname))
+	result.NameMismatch = nameMismatch(client, hostname)
+	result.CertNotAfter = certNotAfter(client)
+	result.CertSPKISHA256, result.IssuerSPKISHA256 = certSPKIHashes(client)
+	result.CertSerial, result.CertIssuer = certSerial(client)
+	result.TLSVersion, result.CipherSuite = negotiatedTLS(client)
+	result.Fingerprint.TLS = result.TLSVersion + " " + result.CipherSuite
 	// result.addCheck(checkTLSCipher(hostname))
 
 	// Creates a new connection to check for SSLv2/3 support because we can't call starttls twice.
-	result.addCheck(checkTLSVersion(client, hostname, timeout))
+	result.addCheck(checkTLSVersion(ctx, client, hostname, timeout))
+
+	// Checking session features takes two more connections, so it's only
+	// done for verbose checks.
+	if t != nil {
+		resumption, renegotiation := checkTLSSessionFeatures(ctx, hostname, timeout)
+		result.InfoResults = map[string]*Result{
+			resumption.Name:    resumption,
+			renegotiation.Name: renegotiation,
+		}
+	}
 
 	return result
 }
@@ -19,6 +19,11 @@ import (
 func TestMain(m *testing.M) {
 	certString = createCert(key, "localhost")
 	certStringHostnameMismatch = createCert(key, "you_give_love_a_bad_name")
+	// Every test server is on localhost, so don't limit it like a mailserver.
+	cfg := DefaultConfig()
+	cfg.MaxHostConnections = cfg.MaxConnections
+	cfg.MaxHostConnectionsPerMinute = 1 << 20
+	Configure(cfg)
 	code := m.Run()
 	os.Exit(code)
 }
@@ -127,6 +132,22 @@ func TestNoTLS(t *testing.T) {
 	compareStatuses(t, expected, result)
 }
 
+func TestExtensions(t *testing.T) {
+	ln := smtpListenAndServe(t, &tls.Config{})
+	defer ln.Close()
+
+	result := FullCheckHostname("", ln.Addr().String(), testTimeout)
+	found := false
+	for _, extension := range result.Extensions {
+		if extension == "STARTTLS" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected STARTTLS in advertised extensions, got %v", result.Extensions)
+	}
+}
+
 func TestSelfSigned(t *testing.T) {
 	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
 	if err != nil {
@@ -205,6 +226,20 @@ func TestSuccessWithFakeCA(t *testing.T) {
 		},
 	}
 	compareStatuses(t, expected, result)
+
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if result.CertSPKISHA256 != spkiSHA256(leaf) || result.IssuerSPKISHA256 != "" {
+		t.Errorf("Expected hash of the certificate's key and no issuer, got %q and %q",
+			result.CertSPKISHA256, result.IssuerSPKISHA256)
+	}
+	if result.TLSVersion == "" || result.CipherSuite == "" {
+		t.Errorf("Expected negotiated TLS version and cipher suite, got %q and %q",
+			result.TLSVersion, result.CipherSuite)
+	}
+	if result.Fingerprint == nil || !strings.Contains(result.Fingerprint.Extensions, "STARTTLS") ||
+		result.Fingerprint.TLS != result.TLSVersion+" "+result.CipherSuite {
+		t.Errorf("Expected fingerprint of extensions and negotiated TLS, got %+v", result.Fingerprint)
+	}
 }
 
 // Tests that the checker successfully initiates an SMTP connection with mail
@@ -250,6 +285,32 @@ func ServeDelayedGreeting(ln net.Listener, t *testing.T) {
 	}
 }
 
+// Tests that a 4xx greeting, as sent by greylisting servers, is reported as
+// a temporary failure.
+func TestGreylistedGreeting(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("421 localhost Service not available, try again later\r\n"))
+		conn.Close()
+	}()
+
+	result := FullCheckHostname("", ln.Addr().String(), testTimeout)
+	if !result.TemporaryFailure {
+		t.Errorf("Expected 421 greeting to be a temporary failure")
+	}
+	if result.Status != Error {
+		t.Errorf("Expected hostname check to error, got status %d", result.Status)
+	}
+}
+
 func TestFailureWithBadHostname(t *testing.T) {
 	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
 	if err != nil {
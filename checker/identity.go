@@ -0,0 +1,66 @@
+package checker
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// The checker identifies itself to the servers it connects to, so that their
+// operators can find out who's scanning them and how to opt out.
+// SCANNER_INFO_URL is a page describing our scans, like the one the backend
+// serves at /about-scans.
+
+// ScannerInfoURL returns the URL of the page describing our scans, or "" if
+// SCANNER_INFO_URL isn't set.
+func ScannerInfoURL() string {
+	return os.Getenv("SCANNER_INFO_URL")
+}
+
+// UserAgent returns the User-Agent sent with HTTPS requests made during
+// checks, like fetching MTA-STS policies.
+func UserAgent() string {
+	userAgent := "STARTTLS-Everywhere-Scanner/1.0"
+	if info := ScannerInfoURL(); info != "" {
+		userAgent += " (+" + info + ")"
+	}
+	return userAgent
+}
+
+// ehloFallback warns, once, that ehlo_hostname isn't configured.
+var ehloFallback sync.Once
+
+// EHLOHostname returns the name we greet mailservers with: the configured
+// ehlo_hostname, or else HOSTNAME, or else localhost. Falling back is logged,
+// since containers set HOSTNAME to a name that only they know.
+func EHLOHostname() string {
+	network.RLock()
+	hostname := network.ehloHostname
+	network.RUnlock()
+	if hostname != "" {
+		return hostname
+	}
+	if hostname = os.Getenv("HOSTNAME"); hostname == "" {
+		hostname = "localhost"
+	}
+	ehloFallback.Do(func() {
+		log.Printf("ehlo_hostname isn't configured, so we're greeting mailservers as %s", hostname)
+	})
+	return hostname
+}
+
+// identifiedTransport sets our User-Agent on requests.
+type identifiedTransport struct {
+	base http.RoundTripper
+}
+
+func (t identifiedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", UserAgent())
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
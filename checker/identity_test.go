@@ -0,0 +1,43 @@
+package checker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestScannerIdentity(t *testing.T) {
+	defer os.Setenv("HOSTNAME", os.Getenv("HOSTNAME"))
+	defer os.Setenv("SCANNER_INFO_URL", os.Getenv("SCANNER_INFO_URL"))
+	defer Configure(DefaultConfig())
+	os.Setenv("HOSTNAME", "container-1234")
+	os.Setenv("SCANNER_INFO_URL", "https://scanner.example.org/about-scans")
+	Configure(DefaultConfig())
+	if got := EHLOHostname(); got != "container-1234" {
+		t.Errorf("Expected EHLO hostname to fall back to HOSTNAME, got %s", got)
+	}
+	os.Setenv("HOSTNAME", "")
+	if got := EHLOHostname(); got != "localhost" {
+		t.Errorf("Expected EHLO hostname to default to localhost, got %s", got)
+	}
+	cfg := DefaultConfig()
+	cfg.EHLOHostname = "mx-scanner.example.org"
+	Configure(cfg)
+	if got := EHLOHostname(); got != "mx-scanner.example.org" {
+		t.Errorf("Expected the configured EHLO hostname, got %s", got)
+	}
+
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: identifiedTransport{}}
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "STARTTLS-Everywhere-Scanner/1.0 (+https://scanner.example.org/about-scans)" {
+		t.Errorf("Expected requests to identify the scanner, got User-Agent %q", userAgent)
+	}
+}
@@ -0,0 +1,66 @@
+package checker
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+)
+
+// JSONLinesHandler is a ResultHandler which writes each domain's full result
+// as a line of JSON, optionally gzipped. Call Close once all domains have been
+// handled.
+type JSONLinesHandler struct {
+	mu      sync.Mutex
+	gz      *gzip.Writer
+	encoder *json.Encoder
+	Written int
+	Failed  int
+}
+
+// NewJSONLinesHandler creates a JSONLinesHandler writing to w. If compress is
+// set, the output is gzipped.
+func NewJSONLinesHandler(w io.Writer, compress bool) *JSONLinesHandler {
+	h := &JSONLinesHandler{}
+	if compress {
+		h.gz = gzip.NewWriter(w)
+		w = h.gz
+	}
+	h.encoder = json.NewEncoder(w)
+	return h
+}
+
+// HandleDomain writes a domain result as a line of JSON.
+func (h *JSONLinesHandler) HandleDomain(r DomainResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.encoder.Encode(r); err != nil {
+		log.Printf("Couldn't write result for %s: %v", r.Domain, err)
+		h.Failed++
+		return
+	}
+	h.Written++
+}
+
+// Flush writes any compressed results still buffered, so that they're not
+// lost if the process is interrupted.
+func (h *JSONLinesHandler) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.gz == nil {
+		return nil
+	}
+	return h.gz.Flush()
+}
+
+// Close finishes the gzip stream, if the output is compressed. It doesn't
+// close the underlying writer.
+func (h *JSONLinesHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.gz == nil {
+		return nil
+	}
+	return h.gz.Close()
+}
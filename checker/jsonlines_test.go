@@ -0,0 +1,73 @@
+package checker
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// readJSONLines decodes every DomainResult in r.
+func readJSONLines(t *testing.T, r io.Reader) []DomainResult {
+	var results []DomainResult
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var result DomainResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("Couldn't decode line %q: %v", scanner.Text(), err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func TestJSONLinesHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewJSONLinesHandler(&buf, false)
+	for i := 0; i < 3; i++ {
+		h.HandleDomain(NewSampleDomainResult(fmt.Sprintf("%d.example.com", i)))
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	results := readJSONLines(t, &buf)
+	if len(results) != 3 || h.Written != 3 {
+		t.Fatalf("Expected 3 results, got %d lines and %d written", len(results), h.Written)
+	}
+	if results[1].Domain != "1.example.com" || len(results[1].HostnameResults) != 1 {
+		t.Errorf("Expected full domain result, got %+v", results[1])
+	}
+}
+
+func TestJSONLinesHandlerGzip(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewJSONLinesHandler(&buf, true)
+	h.HandleDomain(NewSampleDomainResult("a.example.com"))
+	// Flushed results can be read before the stream is closed.
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(gz).ReadString('\n')
+	if err != nil || len(line) == 0 {
+		t.Errorf("Expected flushed result to be readable, got %q, %v", line, err)
+	}
+
+	h.HandleDomain(NewSampleDomainResult("b.example.com"))
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gz, err = gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results := readJSONLines(t, gz); len(results) != 2 || results[1].Domain != "b.example.com" {
+		t.Errorf("Expected 2 gzipped results, got %+v", results)
+	}
+}
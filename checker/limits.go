@@ -0,0 +1,184 @@
+package checker
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default resource limits, which can be overwritten by Config.
+const (
+	defaultMaxConnections     = 512
+	defaultMaxConnectionBytes = 1 << 20
+)
+
+// errConnectionLimit is returned when no connection slot frees up before the
+// check's timeout.
+var errConnectionLimit = errors.New("checker is at its limit of open connections")
+
+// errReadLimit is returned when a server sends more data than we're willing
+// to buffer for a single connection.
+var errReadLimit = errors.New("server sent too much data")
+
+// ResourceStats reports the checker's usage of network resources.
+type ResourceStats struct {
+	// Maximum number of simultaneously open connections.
+	MaxConnections int `json:"max_connections"`
+	// Currently open connections.
+	OpenConnections int `json:"open_connections"`
+	// Most connections that have been open at once.
+	PeakConnections int `json:"peak_connections"`
+	// Number of dials that gave up waiting for a free connection slot.
+	RejectedConnections int `json:"rejected_connections"`
+	// Number of connections closed because they exceeded the read limit.
+	TruncatedConnections int `json:"truncated_connections"`
+	// Maximum simultaneous connections, and connections per minute, to a
+	// single mailserver.
+	MaxHostConnections          int `json:"max_host_connections"`
+	MaxHostConnectionsPerMinute int `json:"max_host_connections_per_minute"`
+	// Number of dials that gave up because of the per-mailserver limits.
+	HostLimitedConnections int `json:"host_limited_connections"`
+}
+
+// limiter caps the number of open sockets and the bytes read from each one.
+// It is shared by every Checker in the process, since the API and bulk scans
+// may run at the same time.
+type limiter struct {
+	slots    chan struct{}
+	maxBytes int64
+	// hosts applies per-mailserver limits. If nil, there are none.
+	hosts *hostLimiter
+
+	mu    sync.Mutex
+	stats ResourceStats
+}
+
+var (
+	processLimiter     *limiter
+	processLimiterOnce sync.Once
+)
+
+// getLimiter returns the process-wide limiter. If Configure hasn't set it up,
+// its limits are loaded with ConfigFromEnv.
+func getLimiter() *limiter {
+	processLimiterOnce.Do(func() {
+		cfg, err := ConfigFromEnv()
+		if err != nil {
+			log.Printf("Invalid checker config, using default resource limits: %v", err)
+			cfg = DefaultConfig()
+		}
+		processLimiter = newProcessLimiter(cfg)
+	})
+	return processLimiter
+}
+
+func newProcessLimiter(cfg Config) *limiter {
+	l := newLimiter(cfg.MaxConnections, cfg.MaxConnectionBytes)
+	l.hosts = newHostLimiter(cfg.MaxHostConnections, cfg.MaxHostConnectionsPerMinute)
+	l.stats.MaxHostConnections = cfg.MaxHostConnections
+	l.stats.MaxHostConnectionsPerMinute = cfg.MaxHostConnectionsPerMinute
+	return l
+}
+
+func newLimiter(maxConnections int, maxBytes int) *limiter {
+	return &limiter{
+		slots:    make(chan struct{}, maxConnections),
+		maxBytes: int64(maxBytes),
+		stats:    ResourceStats{MaxConnections: maxConnections},
+	}
+}
+
+// GetResourceStats returns the checker's current network resource usage.
+func GetResourceStats() ResourceStats {
+	l := getLimiter()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// dial opens a TCP connection once a connection slot is available, and the
+// per-mailserver limits allow it. If that doesn't happen within timeout, it
+// returns errConnectionLimit, errHostConnectionLimit or errHostRateLimit.
+func (l *limiter) dial(address string, timeout time.Duration) (net.Conn, error) {
+	start := time.Now()
+	var host *hostState
+	if l.hosts != nil {
+		var err error
+		host, err = l.hosts.acquire(address, start.Add(timeout))
+		if err != nil {
+			l.mu.Lock()
+			l.stats.HostLimitedConnections++
+			l.mu.Unlock()
+			log.Printf("Not connecting to %s: %v", address, err)
+			return nil, err
+		}
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-time.After(timeout - time.Since(start)):
+		if host != nil {
+			host.release()
+		}
+		l.mu.Lock()
+		l.stats.RejectedConnections++
+		l.mu.Unlock()
+		log.Printf("Checker connection limit of %d reached; not connecting to %s", cap(l.slots), address)
+		return nil, errConnectionLimit
+	}
+	l.mu.Lock()
+	l.stats.OpenConnections++
+	if l.stats.OpenConnections > l.stats.PeakConnections {
+		l.stats.PeakConnections = l.stats.OpenConnections
+	}
+	l.mu.Unlock()
+
+	conn, err := dialTimeout(address, timeout-time.Since(start))
+	if err != nil {
+		l.release(host)
+		return nil, err
+	}
+	return &limitedConn{Conn: conn, limiter: l, host: host, remaining: l.maxBytes}, nil
+}
+
+func (l *limiter) release(host *hostState) {
+	l.mu.Lock()
+	l.stats.OpenConnections--
+	l.mu.Unlock()
+	<-l.slots
+	if host != nil {
+		host.release()
+	}
+}
+
+// limitedConn releases its connection slot when closed, and fails reads once
+// the server has sent more than the limiter's maximum bytes.
+type limitedConn struct {
+	net.Conn
+	limiter   *limiter
+	host      *hostState
+	remaining int64
+	closeOnce sync.Once
+}
+
+func (c *limitedConn) Read(b []byte) (int, error) {
+	if c.remaining <= 0 {
+		c.limiter.mu.Lock()
+		c.limiter.stats.TruncatedConnections++
+		c.limiter.mu.Unlock()
+		return 0, errReadLimit
+	}
+	if int64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.Conn.Read(b)
+	c.remaining -= int64(n)
+	return n, err
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { c.limiter.release(c.host) })
+	return err
+}
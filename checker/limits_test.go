@@ -0,0 +1,68 @@
+package checker
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimiterRejectsWhenFull(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	l := newLimiter(1, 1024)
+	conn, err := l.dial(ln.Addr().String(), testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.dial(ln.Addr().String(), testTimeout); err != errConnectionLimit {
+		t.Errorf("Expected second dial to hit the connection limit, got %v", err)
+	}
+	conn.Close()
+	// Closing twice shouldn't free up an extra slot.
+	conn.Close()
+	conn, err = l.dial(ln.Addr().String(), testTimeout)
+	if err != nil {
+		t.Errorf("Expected dial to succeed after slot was released: %v", err)
+	} else {
+		conn.Close()
+	}
+	if l.stats.RejectedConnections != 1 || l.stats.PeakConnections != 1 || l.stats.OpenConnections != 0 {
+		t.Errorf("Unexpected limiter stats %+v", l.stats)
+	}
+}
+
+func TestLimiterCapsReads(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(make([]byte, 4096))
+		time.Sleep(testTimeout)
+	}()
+
+	l := newLimiter(1, 1024)
+	conn, err := l.dial(ln.Addr().String(), testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, err := ioutil.ReadAll(conn)
+	if err != errReadLimit {
+		t.Errorf("Expected read limit error, got %v", err)
+	}
+	if len(data) != 1024 {
+		t.Errorf("Expected to read 1024 bytes, read %d", len(data))
+	}
+}
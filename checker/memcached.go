@@ -0,0 +1,232 @@
+package checker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// memcachedMaxKeyLength is the longest key memcached accepts.
+	memcachedMaxKeyLength = 250
+	// memcachedMaxIdle is the number of idle connections kept for reuse.
+	memcachedMaxIdle = 8
+	// Expiry times longer than this are read by memcached as Unix timestamps
+	// rather than durations.
+	memcachedMaxRelativeExpiry = 30 * 24 * time.Hour
+	defaultMemcachedTimeout    = time.Second
+)
+
+// MemcachedStore is a ScanStore backed by memcached, for deployments that
+// already run it. It speaks memcached's text protocol, and stores each
+// hostname's latest result under Prefix followed by the hostname.
+type MemcachedStore struct {
+	// Address of the memcached server, eg. localhost:11211.
+	Address string
+	// Encoding of stored results. Entries stored with another encoding, or
+	// another CacheEntryVersion, are treated as misses.
+	Encoding ResultEncoding
+	// Prefix is prepended to each key, so that several deployments can share
+	// a server.
+	Prefix string
+	// Expiry tells memcached to evict results this long after they're
+	// stored. 0 for never. Wrap the store in a ScanCache to ignore results
+	// older than its ExpireTime.
+	Expiry time.Duration
+	// Timeout for each request to memcached.
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	idle []*memcachedConn
+}
+
+type memcachedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewMemcachedStore returns a MemcachedStore for the server at address,
+// storing results with the built-in encoding called encoding, or JSON if it's
+// empty.
+func NewMemcachedStore(address string, encoding string) (*MemcachedStore, error) {
+	resultEncoding, err := ResultEncodingByName(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("memcached: %v", err)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("memcached address must be host:port: %v", err)
+	}
+	return &MemcachedStore{
+		Address:  address,
+		Encoding: resultEncoding,
+		Prefix:   "starttls:",
+		Timeout:  defaultMemcachedTimeout,
+	}, nil
+}
+
+// key returns the memcached key for hostname. Keys can't contain whitespace
+// or control characters.
+func (s *MemcachedStore) key(hostname string) (string, error) {
+	key := s.Prefix + hostname
+	if len(key) > memcachedMaxKeyLength || strings.IndexFunc(key, func(r rune) bool { return r <= ' ' || r == 0x7f }) >= 0 {
+		return "", fmt.Errorf("can't store scan for hostname %q in memcached", hostname)
+	}
+	return key, nil
+}
+
+// GetHostnameScan retrieves the latest result for hostname. Returns an error
+// if there isn't one.
+func (s *MemcachedStore) GetHostnameScan(hostname string) (HostnameResult, error) {
+	key, err := s.key(hostname)
+	if err != nil {
+		return HostnameResult{}, err
+	}
+	var data []byte
+	err = s.do(func(conn *memcachedConn) error {
+		if _, err := fmt.Fprintf(conn, "get %s\r\n", key); err != nil {
+			return err
+		}
+		line, err := readMemcachedLine(conn.r)
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return nil
+		}
+		// VALUE <key> <flags> <bytes>
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] != "VALUE" {
+			return fmt.Errorf("unexpected memcached reply %q", line)
+		}
+		size, err := strconv.Atoi(fields[3])
+		if err != nil || size < 0 {
+			return fmt.Errorf("unexpected memcached reply %q", line)
+		}
+		data = make([]byte, size+2)
+		if _, err = io.ReadFull(conn.r, data); err != nil {
+			return err
+		}
+		data = data[:size]
+		if line, err = readMemcachedLine(conn.r); err != nil {
+			return err
+		}
+		if line != "END" {
+			return fmt.Errorf("unexpected memcached reply %q", line)
+		}
+		return nil
+	})
+	if err != nil {
+		return HostnameResult{}, err
+	}
+	if data == nil {
+		return HostnameResult{}, fmt.Errorf("Couldn't find scan for hostname %s", hostname)
+	}
+	return DecodeCacheEntry(s.Encoding, data)
+}
+
+// PutHostnameScan stores result as the latest for hostname.
+func (s *MemcachedStore) PutHostnameScan(hostname string, result HostnameResult) error {
+	key, err := s.key(hostname)
+	if err != nil {
+		return err
+	}
+	data, err := EncodeCacheEntry(s.Encoding, result)
+	if err != nil {
+		return err
+	}
+	return s.do(func(conn *memcachedConn) error {
+		if _, err := fmt.Fprintf(conn, "set %s 0 %d %d\r\n%s\r\n", key, s.expiry(), len(data), data); err != nil {
+			return err
+		}
+		line, err := readMemcachedLine(conn.r)
+		if err != nil {
+			return err
+		}
+		if line != "STORED" {
+			return fmt.Errorf("couldn't store scan for hostname %s in memcached: %s", hostname, line)
+		}
+		return nil
+	})
+}
+
+// expiry returns the expiry time to send with results.
+func (s *MemcachedStore) expiry() int64 {
+	if s.Expiry <= 0 {
+		return 0
+	}
+	if s.Expiry > memcachedMaxRelativeExpiry {
+		return time.Now().Add(s.Expiry).Unix()
+	}
+	// Round up, since 0 means never.
+	return int64((s.Expiry + time.Second - 1) / time.Second)
+}
+
+// do runs request on a connection to the server, reusing an idle one if
+// there is one. Connections are closed after errors, since they may be left
+// partway through a reply.
+func (s *MemcachedStore) do(request func(*memcachedConn) error) error {
+	conn, err := s.conn()
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+	if err = request(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) < memcachedMaxIdle {
+		s.idle = append(s.idle, conn)
+	} else {
+		conn.Close()
+	}
+	return nil
+}
+
+func (s *MemcachedStore) conn() (*memcachedConn, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		conn := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return conn, nil
+	}
+	s.mu.Unlock()
+	conn, err := net.DialTimeout("tcp", s.Address, s.timeout())
+	if err != nil {
+		return nil, err
+	}
+	return &memcachedConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (s *MemcachedStore) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return defaultMemcachedTimeout
+	}
+	return s.Timeout
+}
+
+// Close closes idle connections to the server.
+func (s *MemcachedStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.idle {
+		conn.Close()
+	}
+	s.idle = nil
+	return nil
+}
+
+func readMemcachedLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
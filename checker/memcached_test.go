@@ -0,0 +1,114 @@
+package checker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached serves the get and set commands of memcached's text protocol
+// from a map.
+type fakeMemcached struct {
+	net.Listener
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeMemcached{Listener: listener, data: make(map[string][]byte)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+	return m
+}
+
+func (m *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		m.mu.Lock()
+		switch {
+		case len(fields) == 2 && fields[0] == "get":
+			if value, ok := m.data[fields[1]]; ok {
+				fmt.Fprintf(conn, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(value), value)
+			}
+			fmt.Fprintf(conn, "END\r\n")
+		case len(fields) == 5 && fields[0] == "set":
+			size, _ := strconv.Atoi(fields[4])
+			value := make([]byte, size+2)
+			io.ReadFull(r, value)
+			m.data[fields[1]] = value[:size]
+			fmt.Fprintf(conn, "STORED\r\n")
+		default:
+			fmt.Fprintf(conn, "ERROR\r\n")
+		}
+		m.mu.Unlock()
+	}
+}
+
+func TestMemcachedStore(t *testing.T) {
+	server := newFakeMemcached(t)
+	defer server.Close()
+	for _, encoding := range []string{JSONEncoding, GobEncoding, MsgpackEncoding} {
+		store, err := NewMemcachedStore(server.Addr().String(), encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Prefix = encoding + ":"
+		if _, err = store.GetHostnameScan("mx.example.com"); err == nil {
+			t.Errorf("Expected %s store to start empty", encoding)
+		}
+		result := HostnameResult{
+			Result:     MakeResult("hostname"),
+			Hostname:   "mx.example.com",
+			Timestamp:  time.Now().Round(0),
+			TLSVersion: "TLS 1.3",
+		}
+		result.Checks[STARTTLS] = MakeResult(STARTTLS).Failure("nope")
+		if err = store.PutHostnameScan("mx.example.com", result); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.GetHostnameScan("mx.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Timestamp.Equal(result.Timestamp) || got.TLSVersion != "TLS 1.3" || got.Hostname != "mx.example.com" {
+			t.Errorf("Expected %s store to keep every field, got %+v", encoding, got)
+		}
+		if got.Result == nil || got.Checks[STARTTLS].Status != Failure {
+			t.Errorf("Expected %s store to keep checks, got %+v", encoding, got.Result)
+		}
+		store.Close()
+	}
+}
+
+func TestMemcachedStoreRejectsInvalidKeys(t *testing.T) {
+	store, _ := NewMemcachedStore("127.0.0.1:1", JSONEncoding)
+	if err := store.PutHostnameScan("mx example.com", HostnameResult{}); err == nil {
+		t.Error("Expected hostnames with spaces to be rejected")
+	}
+	if _, err := NewMemcachedStore("127.0.0.1:11211", "xml"); err == nil {
+		t.Error("Expected unknown encodings to be rejected")
+	}
+}
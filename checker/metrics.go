@@ -0,0 +1,66 @@
+package checker
+
+import "github.com/EFForg/starttls-backend/metrics"
+
+// Checker metrics, registered with metrics.Default so the daemon can serve
+// them to Prometheus.
+var (
+	scansStarted = metrics.Default.NewCounter("checker_scans_started_total",
+		"Domain checks started.")
+	scansCompleted = metrics.Default.NewCounter("checker_scans_completed_total",
+		"Domain checks completed, by error class: none, temporary or permanent.", "error_class")
+	checkFailures = metrics.Default.NewCounter("checker_check_failures_total",
+		"Hostname and domain checks that failed or errored, by check name.", "check")
+	handshakeSeconds = metrics.Default.NewHistogram("checker_handshake_seconds",
+		"Time taken to complete STARTTLS handshakes with mailservers.", metrics.DefaultBuckets)
+	dnsSeconds = metrics.Default.NewHistogram("checker_dns_lookup_seconds",
+		"Time taken by DNS lookups, by record type.", metrics.DefaultBuckets, "type")
+	cacheLookups = metrics.Default.NewCounter("checker_cache_lookups_total",
+		"Hostname result cache lookups, by result: hit, stale, miss, or coalesced into a running check.", "result")
+	domainCacheLookups = metrics.Default.NewCounter("checker_domain_cache_lookups_total",
+		"Domain result cache lookups, by result: hit or miss.", "result")
+	cacheEvictions = metrics.Default.NewCounter("checker_cache_evictions_total",
+		"Hostname results evicted from full in-memory caches.")
+	feedErrors = metrics.Default.NewCounter("checker_feed_errors_total",
+		"Reputation feed lookups that failed, by feed.", "feed")
+	feedTimeouts = metrics.Default.NewCounter("checker_feed_timeouts_total",
+		"Domain checks whose reputation feed lookups didn't all finish in time.")
+)
+
+func init() {
+	metrics.Default.NewGaugeFunc("checker_open_connections",
+		"Connections to mailservers currently open.",
+		func() float64 { return float64(GetResourceStats().OpenConnections) })
+}
+
+// recordCheckFailures counts the checks that failed or errored in a result,
+// and in its sub-checks.
+func recordCheckFailures(r *Result) {
+	if r == nil {
+		return
+	}
+	for name, check := range r.Checks {
+		if check.Status == Failure || check.Status == Error {
+			checkFailures.Inc(name)
+		}
+		recordCheckFailures(check)
+	}
+}
+
+// recordScan counts a completed domain check and the checks that failed in it.
+func recordScan(d DomainResult) {
+	class := string(d.ErrorClass)
+	if class == "" {
+		class = "none"
+	}
+	scansCompleted.Inc(class)
+	for _, h := range d.HostnameResults {
+		recordCheckFailures(h.Result)
+	}
+	for _, r := range d.ExtraResults {
+		if r.Status == Failure || r.Status == Error {
+			checkFailures.Inc(r.Name)
+		}
+		recordCheckFailures(r)
+	}
+}
@@ -0,0 +1,34 @@
+package checker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckDomainRecordsMetrics(t *testing.T) {
+	c := Checker{
+		Cache:                  MakeSimpleCache(10 * time.Minute),
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	started := scansStarted.Value()
+	completed := scansCompleted.Value("permanent")
+	failures := checkFailures.Value(STARTTLS)
+	misses, hits := cacheLookups.Value("miss"), cacheLookups.Value("hit")
+
+	c.CheckDomain("nostarttls", nil)
+	c.CheckDomain("nostarttls", nil)
+
+	if scansStarted.Value()-started != 2 || scansCompleted.Value("permanent")-completed != 2 {
+		t.Errorf("Expected 2 scans started and completed with permanent errors")
+	}
+	if checkFailures.Value(STARTTLS)-failures != 2 {
+		t.Errorf("Expected 2 STARTTLS failures, got %v", checkFailures.Value(STARTTLS)-failures)
+	}
+	if cacheLookups.Value("miss")-misses == 0 || cacheLookups.Value("hit")-hits == 0 {
+		t.Errorf("Expected the second check to hit the cache")
+	}
+}
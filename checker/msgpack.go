@@ -0,0 +1,278 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// msgpackEncoding stores results in MessagePack (https://msgpack.org), with
+// the same field names as the JSON encoding. It converts to and from the JSON
+// encoding, so it only needs the subset of MessagePack that JSON values map
+// to: nil, booleans, integers, floats, strings, arrays and maps.
+type msgpackEncoding struct{}
+
+func (msgpackEncoding) Name() string {
+	return MsgpackEncoding
+}
+
+func (msgpackEncoding) Encode(result HostnameResult) ([]byte, error) {
+	data, err := jsonEncoding{}.Encode(result)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err = decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = writeMsgpack(&buf, value)
+	return buf.Bytes(), err
+}
+
+func (msgpackEncoding) Decode(data []byte) (HostnameResult, error) {
+	r := &msgpackReader{data: data}
+	value, err := r.read()
+	if err != nil {
+		return HostnameResult{}, err
+	}
+	if r.pos != len(data) {
+		return HostnameResult{}, fmt.Errorf("msgpack: %d unexpected bytes after value", len(data)-r.pos)
+	}
+	if data, err = json.Marshal(value); err != nil {
+		return HostnameResult{}, err
+	}
+	return jsonEncoding{}.Decode(data)
+}
+
+// writeMsgpack writes a value decoded from JSON, with json.Number for
+// numbers. Map keys are written in order, so encodings are deterministic.
+func writeMsgpack(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeMsgpackHeader(buf, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, element := range v {
+			if err := writeMsgpack(buf, element); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		writeMsgpackHeader(buf, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeMsgpack(buf, key)
+			if err := writeMsgpack(buf, v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: can't encode %T", value)
+	}
+	return nil
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// writeMsgpackHeader writes the type and length of a string, array or map:
+// fix|n if n < fixMax, or the 8-bit (if the type has one), 16-bit or 32-bit
+// form.
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, b8 byte, b16 byte, b32 byte) {
+	switch {
+	case n < fixMax:
+		buf.WriteByte(fix | byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(b8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(b32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// msgpackReader reads values written by writeMsgpack, and the other
+// MessagePack integer and float types, into the values json.Unmarshal
+// produces.
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, fmt.Errorf("msgpack: unexpected end of data")
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *msgpackReader) uint(size int) (uint64, error) {
+	b, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (r *msgpackReader) read() (interface{}, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	t := b[0]
+	switch {
+	case t < 0x80:
+		return int64(t), nil
+	case t >= 0xe0:
+		return int64(int8(t)), nil
+	case t&0xf0 == 0x80:
+		return r.readMap(int(t & 0x0f))
+	case t&0xf0 == 0x90:
+		return r.readArray(int(t & 0x0f))
+	case t&0xe0 == 0xa0:
+		return r.readString(int(t & 0x1f))
+	}
+	switch t {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		n, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := r.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := r.uint(1 << (t - 0xcc))
+		if n > math.MaxInt64 {
+			return float64(n), err
+		}
+		return int64(n), err
+	case 0xd0:
+		n, err := r.uint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := r.uint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := r.uint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := r.uint(8)
+		return int64(n), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.uint(1 << (t - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.readString(int(n))
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.readArray(int(n))
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.readMap(int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", t)
+}
+
+func (r *msgpackReader) readString(n int) (string, error) {
+	b, err := r.next(n)
+	return string(b), err
+}
+
+func (r *msgpackReader) readArray(n int) ([]interface{}, error) {
+	if n > len(r.data)-r.pos {
+		return nil, fmt.Errorf("msgpack: unexpected end of data")
+	}
+	array := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		element, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, element)
+	}
+	return array, nil
+}
+
+func (r *msgpackReader) readMap(n int) (map[string]interface{}, error) {
+	if n > len(r.data)-r.pos {
+		return nil, fmt.Errorf("msgpack: unexpected end of data")
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		s, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: map keys must be strings, got %T", key)
+		}
+		if m[s], err = r.read(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
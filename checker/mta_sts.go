@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -20,6 +19,7 @@ type MTASTSResult struct {
 	Policy string // Text of MTA-STS policy file
 	Mode   string
 	MXs    []string
+	ID     string // Policy ID from the MTA-STS TXT record
 }
 
 // MakeMTASTSResult constructs a base result object and returns its pointer.
@@ -39,11 +39,13 @@ func (m MTASTSResult) MarshalJSON() ([]byte, error) {
 		Policy string   `json:"policy"`
 		Mode   string   `json:"mode"`
 		MXs    []string `json:"mxs"`
+		ID     string   `json:"id,omitempty"`
 	}{
 		FakeResult: FakeResult(*m.Result),
 		Policy:     m.Policy,
 		Mode:       m.Mode,
 		MXs:        m.MXs,
+		ID:         m.ID,
 	})
 }
 
@@ -76,16 +78,23 @@ func getKeyValuePairs(record string, lineDelimiter string,
 	return parsed
 }
 
-func checkMTASTSRecord(domain string, timeout time.Duration) *Result {
+// checkMTASTSRecord checks the MTA-STS TXT record for domain, and returns
+// its policy ID if it's valid.
+func checkMTASTSRecord(domain string, timeout time.Duration) (*Result, string) {
 	result := MakeResult(MTASTSText)
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	var r net.Resolver
-	records, err := r.LookupTXT(ctx, fmt.Sprintf("_mta-sts.%s", domain))
+	start := time.Now()
+	records, err := newResolver().LookupTXT(ctx, fmt.Sprintf("_mta-sts.%s", domain))
+	dnsSeconds.ObserveSince(start, "txt")
 	if err != nil {
-		return result.Failure("Couldn't find an MTA-STS TXT record: %v.", err)
+		return result.Failure("Couldn't find an MTA-STS TXT record: %v.", err), ""
 	}
-	return validateMTASTSRecord(records, result)
+	result = validateMTASTSRecord(records, result)
+	if result.Status != Success {
+		return result, ""
+	}
+	return result, getKeyValuePairs(filterByPrefix(records, "v=STSv1")[0], ";", "=")["id"]
 }
 
 func validateMTASTSRecord(records []string, result *Result) *Result {
@@ -105,7 +114,8 @@ func validateMTASTSRecord(records []string, result *Result) *Result {
 func checkMTASTSPolicyFile(domain string, hostnameResults map[string]HostnameResult, timeout time.Duration) (*Result, string, map[string]string) {
 	result := MakeResult(MTASTSPolicyFile)
 	client := &http.Client{
-		Timeout: timeout,
+		Timeout:   timeout,
+		Transport: identifiedTransport{base: httpTransport()},
 		// Don't follow redirects.
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
@@ -190,7 +200,9 @@ func (c Checker) checkMTASTS(domain string, hostnameResults map[string]HostnameR
 		return c.checkMTASTSOverride(domain, hostnameResults)
 	}
 	result := MakeMTASTSResult()
-	result.addCheck(checkMTASTSRecord(domain, c.timeout()))
+	recordResult, id := checkMTASTSRecord(domain, c.timeout())
+	result.addCheck(recordResult)
+	result.ID = id
 	policyResult, policy, policyMap := checkMTASTSPolicyFile(domain, hostnameResults, c.timeout())
 	result.addCheck(policyResult)
 	result.Policy = policy
@@ -0,0 +1,26 @@
+package checker
+
+// MultiHandler is a ResultHandler which passes each domain result on to every
+// one of its handlers, so a single scan can feed several outputs.
+type MultiHandler []ResultHandler
+
+// HandleDomain passes a domain result on to each handler, in order.
+func (m MultiHandler) HandleDomain(r DomainResult) {
+	for _, handler := range m {
+		handler.HandleDomain(r)
+	}
+}
+
+// Flush flushes every handler that buffers results, like SinkHandler, and
+// returns the first error.
+func (m MultiHandler) Flush() error {
+	var firstErr error
+	for _, handler := range m {
+		if f, ok := handler.(flusher); ok {
+			if err := f.Flush(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,43 @@
+package checker
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// failingSink refuses every insert.
+type failingSink struct{}
+
+func (failingSink) EnsureTable() error          { return nil }
+func (failingSink) Insert(rows []SinkRow) error { return errors.New("sink down") }
+
+func TestMultiHandler(t *testing.T) {
+	totals := &AggregatedScan{}
+	var buf bytes.Buffer
+	lines := NewJSONLinesHandler(&buf, false)
+	sink := &fakeSink{}
+	m := MultiHandler{totals, lines, &SinkHandler{Sink: sink}}
+	m.HandleDomain(NewSampleDomainResult("a.example.com"))
+	m.HandleDomain(NewSampleDomainResult("b.example.com"))
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if totals.Attempted != 2 || lines.Written != 2 || len(sink.batches) != 1 || len(sink.batches[0]) != 2 {
+		t.Errorf("Expected every handler to get both results, got %d, %d and %v",
+			totals.Attempted, lines.Written, sink.batches)
+	}
+}
+
+func TestMultiHandlerFlushesAll(t *testing.T) {
+	failing := &SinkHandler{Sink: failingSink{}}
+	sink := &fakeSink{}
+	m := MultiHandler{failing, &SinkHandler{Sink: sink}}
+	m.HandleDomain(NewSampleDomainResult("a.example.com"))
+	if err := m.Flush(); err == nil {
+		t.Error("Expected error from failing handler")
+	}
+	if len(sink.batches) != 1 {
+		t.Error("Expected later handlers to be flushed despite an earlier error")
+	}
+}
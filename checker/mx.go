@@ -0,0 +1,50 @@
+package checker
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// mxResolver performs the DNS lookups needed to validate MX targets.
+type mxResolver interface {
+	LookupCNAME(context.Context, string) (string, error)
+	LookupHost(context.Context, string) ([]string, error)
+}
+
+// checkMXHygiene flags MX targets that are CNAMEs, IP literals, or that don't
+// resolve to any address. These are reported as warnings, since mail may still
+// be delivered, but they break MX matching against TLS policies.
+func checkMXHygiene(hostnames []string, resolver mxResolver, timeout time.Duration) *Result {
+	result := MakeResult(MXHygiene)
+	for _, hostname := range hostnames {
+		name := strings.TrimSuffix(hostname, ".")
+		if net.ParseIP(name) != nil {
+			result.Warning("MX record %s is an IP address literal. MX records must point to a hostname (RFC 5321 section 5.1); "+
+				"create an A/AAAA record for your mailserver and use that name in your MX record instead.", hostname)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		cname, err := resolver.LookupCNAME(ctx, name)
+		if err == nil && !strings.EqualFold(strings.TrimSuffix(cname, "."), name) {
+			result.Warning("MX record %s is a CNAME for %s. MX records must not point to a CNAME (RFC 2181 section 10.3); "+
+				"update your MX record to point directly to %s.", hostname, cname, strings.TrimSuffix(cname, "."))
+		}
+		addrs, err := resolver.LookupHost(ctx, name)
+		cancel()
+		if err != nil || len(addrs) == 0 {
+			result.Warning("MX record %s doesn't resolve to any IP address; "+
+				"add an A/AAAA record for it or remove it from your MX records.", hostname)
+		}
+	}
+	return result
+}
+
+func (c *Checker) checkMX(hostnames []string) *Result {
+	if c.checkMXHygieneOverride != nil {
+		// Allow the Checker to mock this function.
+		return c.checkMXHygieneOverride(hostnames)
+	}
+	return checkMXHygiene(hostnames, newResolver(), c.timeout())
+}
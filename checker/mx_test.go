@@ -0,0 +1,58 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeResolver resolves CNAMEs and addresses from fixed maps.
+type fakeResolver struct {
+	cnames map[string]string
+	hosts  map[string][]string
+}
+
+func (r fakeResolver) LookupCNAME(_ context.Context, host string) (string, error) {
+	if cname, ok := r.cnames[host]; ok {
+		return cname, nil
+	}
+	return host + ".", nil
+}
+
+func (r fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, fmt.Errorf("no such host")
+}
+
+func TestMXHygiene(t *testing.T) {
+	resolver := fakeResolver{
+		cnames: map[string]string{"alias.example.com": "mx.example.com."},
+		hosts: map[string][]string{
+			"mx.example.com":    []string{"192.0.2.1"},
+			"alias.example.com": []string{"192.0.2.1"},
+		},
+	}
+	var tests = []struct {
+		hostnames []string
+		status    Status
+		messages  int
+	}{
+		{[]string{"mx.example.com."}, Success, 0},
+		{[]string{"alias.example.com."}, Warning, 1},
+		{[]string{"192.0.2.1."}, Warning, 1},
+		{[]string{"missing.example.com."}, Warning, 1},
+		{[]string{"mx.example.com.", "alias.example.com.", "missing.example.com."}, Warning, 2},
+	}
+	for _, test := range tests {
+		result := checkMXHygiene(test.hostnames, resolver, time.Second)
+		if result.Status != test.status {
+			t.Errorf("checkMXHygiene(%v) status = %d, want %d", test.hostnames, result.Status, test.status)
+		}
+		if len(result.Messages) != test.messages {
+			t.Errorf("checkMXHygiene(%v) messages = %v, want %d messages", test.hostnames, result.Messages, test.messages)
+		}
+	}
+}
@@ -84,6 +84,13 @@ func (r *Result) Warning(format string, a ...interface{}) *Result {
 	return r
 }
 
+// Info adds an informational message to this check result without
+// changing its status.
+func (r *Result) Info(format string, a ...interface{}) *Result {
+	r.Messages = append(r.Messages, fmt.Sprintf("Info: "+format, a...))
+	return r
+}
+
 // Success simply sets the status of Result to a Success.
 // Status is set if no other status has been declared on this check.
 func (r *Result) Success() *Result {
@@ -109,26 +116,44 @@ func (r *Result) addCheck(checkResult *Result) {
 
 // IDs for checks that can be run
 const (
-	Connectivity     = "connectivity"
-	STARTTLS         = "starttls"
-	Version          = "version"
-	Certificate      = "certificate"
-	MTASTS           = "mta-sts"
-	MTASTSText       = "mta-sts-text"
-	MTASTSPolicyFile = "mta-sts-policy-file"
-	PolicyList       = "policylist"
+	Connectivity      = "connectivity"
+	STARTTLS          = "starttls"
+	Version           = "version"
+	Certificate       = "certificate"
+	MTASTS            = "mta-sts"
+	MTASTSText        = "mta-sts-text"
+	MTASTSPolicyFile  = "mta-sts-policy-file"
+	PolicyList        = "policylist"
+	SNI               = "sni"
+	MXHygiene         = "mx-hygiene"
+	CertHostname      = "certificate-hostname"
+	CertSelfSigned    = "certificate-self-signed"
+	CertTrustedRoot   = "certificate-trusted-root"
+	CertExpired       = "certificate-expired"
+	CertNotYetValid   = "certificate-not-yet-valid"
+	SessionResumption = "session-resumption"
+	Renegotiation     = "renegotiation"
 )
 
 // Text descriptions of checks that can be run
 var checkNames = map[string]string{
-	Connectivity:     "Server connectivity",
-	STARTTLS:         "Support for inbound STARTTLS",
-	Version:          "Secure version of TLS",
-	Certificate:      "Valid certificate",
-	MTASTS:           "Inbound MTA-STS support",
-	MTASTSText:       "Correct MTA-STS DNS record",
-	MTASTSPolicyFile: "Correct MTA-STS policy file",
-	PolicyList:       "Status on EFF's STARTTLS Everywhere policy list",
+	Connectivity:      "Server connectivity",
+	STARTTLS:          "Support for inbound STARTTLS",
+	Version:           "Secure version of TLS",
+	Certificate:       "Valid certificate",
+	MTASTS:            "Inbound MTA-STS support",
+	MTASTSText:        "Correct MTA-STS DNS record",
+	MTASTSPolicyFile:  "Correct MTA-STS policy file",
+	PolicyList:        "Status on EFF's STARTTLS Everywhere policy list",
+	SNI:               "Consistent certificate with and without SNI",
+	MXHygiene:         "Well-formed MX records",
+	CertHostname:      "Certificate valid for the hostname",
+	CertSelfSigned:    "Certificate not self-signed",
+	CertTrustedRoot:   "Certificate chains to a trusted root",
+	CertExpired:       "Certificate not expired",
+	CertNotYetValid:   "Certificate already valid",
+	SessionResumption: "Support for TLS session resumption",
+	Renegotiation:     "Support for secure TLS renegotiation",
 }
 
 // Description returns the full-text name of a check.
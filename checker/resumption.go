@@ -0,0 +1,175 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// helloSniffer captures the first bytes the server sends after the client
+// starts a TLS handshake, so that the plaintext ServerHello can be inspected.
+type helloSniffer struct {
+	net.Conn
+	mu        sync.Mutex
+	sentHello bool
+	captured  []byte
+}
+
+// Bounds how much of the handshake we keep; the ServerHello comes first.
+const maxSniffedBytes = 16 * 1024
+
+// TLS record and handshake constants from RFC 5246.
+const (
+	recordTypeHandshake        = 22
+	handshakeTypeServerHello   = 2
+	extensionRenegotiationInfo = 0xff01
+)
+
+func (s *helloSniffer) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	if len(b) > 0 && b[0] == recordTypeHandshake {
+		s.sentHello = true
+	}
+	s.mu.Unlock()
+	return s.Conn.Write(b)
+}
+
+func (s *helloSniffer) Read(b []byte) (int, error) {
+	n, err := s.Conn.Read(b)
+	s.mu.Lock()
+	if s.sentHello && len(s.captured) < maxSniffedBytes {
+		s.captured = append(s.captured, b[:n]...)
+	}
+	s.mu.Unlock()
+	return n, err
+}
+
+func (s *helloSniffer) serverHello() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.captured
+}
+
+// serverSupportsRenegotiationInfo reports whether the ServerHello at the start
+// of data contains the renegotiation_info extension (RFC 5746), which servers
+// send when they support secure renegotiation.
+func serverSupportsRenegotiationInfo(data []byte) (bool, error) {
+	malformed := fmt.Errorf("malformed ServerHello")
+	// Record header: type, version, length.
+	if len(data) < 5 || data[0] != recordTypeHandshake {
+		return false, malformed
+	}
+	data = data[5:]
+	// Handshake header: type and 3-byte length.
+	if len(data) < 4 || data[0] != handshakeTypeServerHello {
+		return false, malformed
+	}
+	length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if len(data) < 4+length {
+		return false, malformed
+	}
+	hello := data[4 : 4+length]
+	// Version and random.
+	if len(hello) < 2+32+1 {
+		return false, malformed
+	}
+	hello = hello[2+32:]
+	// Session ID, cipher suite and compression method.
+	sessionIDLength := int(hello[0])
+	if len(hello) < 1+sessionIDLength+3 {
+		return false, malformed
+	}
+	hello = hello[1+sessionIDLength+3:]
+	if len(hello) < 2 {
+		// No extensions.
+		return false, nil
+	}
+	extensionsLength := int(binary.BigEndian.Uint16(hello))
+	extensions := hello[2:]
+	if len(extensions) < extensionsLength {
+		return false, malformed
+	}
+	extensions = extensions[:extensionsLength]
+	for len(extensions) >= 4 {
+		extensionType := binary.BigEndian.Uint16(extensions)
+		extensionLength := int(binary.BigEndian.Uint16(extensions[2:]))
+		if extensionType == extensionRenegotiationInfo {
+			return true, nil
+		}
+		if len(extensions) < 4+extensionLength {
+			return false, malformed
+		}
+		extensions = extensions[4+extensionLength:]
+	}
+	return false, nil
+}
+
+// Performs a STARTTLS handshake using sessions from cache, returning the
+// connection state and any ServerHello bytes sent by the server.
+func resumableHandshake(ctx context.Context, hostname string, timeout time.Duration, cache tls.ClientSessionCache) (tls.ConnectionState, []byte, error) {
+	sniffer := &helloSniffer{}
+	client, err := smtpDialWrapped(ctx, hostname, timeout, func(conn net.Conn) net.Conn {
+		sniffer.Conn = conn
+		return sniffer
+	})
+	if err != nil {
+		return tls.ConnectionState{}, nil, err
+	}
+	defer client.Close()
+	config := tls.Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: cache,
+	}
+	if err = client.StartTLS(&config); err != nil {
+		return tls.ConnectionState{}, nil, err
+	}
+	// TLS 1.3 servers send session tickets after the handshake, so wait for
+	// a reply to make sure we've received them.
+	client.Noop()
+	state, _ := client.TLSConnectionState()
+	return state, sniffer.serverHello(), nil
+}
+
+// Checks whether hostname supports resuming TLS sessions, which saves
+// frequent senders a full handshake on every connection, and whether it
+// supports secure renegotiation. These are informational, and don't affect
+// the hostname's status.
+//
+// Go's TLS client only resumes sessions using session tickets (RFC 5077 and
+// TLS 1.3 PSKs), so servers which only support session ID resumption are
+// reported as not supporting resumption.
+func checkTLSSessionFeatures(ctx context.Context, hostname string, timeout time.Duration) (*Result, *Result) {
+	resumption := MakeResult(SessionResumption)
+	renegotiation := MakeResult(Renegotiation)
+	cache := tls.NewLRUClientSessionCache(1)
+	state, serverHello, err := resumableHandshake(ctx, hostname, timeout, cache)
+	if err != nil {
+		err = fmt.Errorf("Could not complete a TLS handshake: %v", err)
+		return resumption.Error("%v", err), renegotiation.Error("%v", err)
+	}
+
+	if state.Version >= tls.VersionTLS13 {
+		renegotiation.Info("Renegotiation is not used in TLS 1.3.")
+	} else if supported, err := serverSupportsRenegotiationInfo(serverHello); err != nil {
+		renegotiation.Error("Could not parse the server's hello message: %v", err)
+	} else if supported {
+		renegotiation.Info("Server supports secure renegotiation.")
+	} else {
+		renegotiation.Info("Server does not support secure renegotiation (RFC 5746).")
+	}
+
+	state, _, err = resumableHandshake(ctx, hostname, timeout, cache)
+	if err != nil {
+		return resumption.Error("Could not complete a second TLS handshake: %v", err), renegotiation
+	}
+	if state.DidResume {
+		resumption.Info("Server supports TLS session resumption.")
+	} else {
+		resumption.Info("Server does not support TLS session resumption with session tickets.")
+	}
+	return resumption, renegotiation
+}
@@ -0,0 +1,94 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func TestTLSSessionFeatures(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name              string
+		config            *tls.Config
+		wantResumption    string
+		wantRenegotiation string
+	}{
+		{"TLS 1.2", &tls.Config{Certificates: []tls.Certificate{cert}, MaxVersion: tls.VersionTLS12},
+			"Info: Server supports TLS session resumption.",
+			"Info: Server supports secure renegotiation."},
+		{"TLS 1.3", &tls.Config{Certificates: []tls.Certificate{cert}},
+			"Info: Server supports TLS session resumption.",
+			"Info: Renegotiation is not used in TLS 1.3."},
+		{"no tickets", &tls.Config{Certificates: []tls.Certificate{cert}, SessionTicketsDisabled: true},
+			"Info: Server does not support TLS session resumption with session tickets.",
+			"Info: Renegotiation is not used in TLS 1.3."},
+	}
+	for _, test := range tests {
+		ln := smtpListenAndServe(t, test.config)
+		resumption, renegotiation := checkTLSSessionFeatures(context.Background(), ln.Addr().String(), testTimeout)
+		ln.Close()
+		if resumption.Status != Success || len(resumption.Messages) != 1 || resumption.Messages[0] != test.wantResumption {
+			t.Errorf("%s: resumption result = %v, want %s", test.name, resumption.Messages, test.wantResumption)
+		}
+		if renegotiation.Status != Success || len(renegotiation.Messages) != 1 || renegotiation.Messages[0] != test.wantRenegotiation {
+			t.Errorf("%s: renegotiation result = %v, want %s", test.name, renegotiation.Messages, test.wantRenegotiation)
+		}
+	}
+}
+
+func TestTLSSessionFeaturesDontAffectStatus(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{cert}, SessionTicketsDisabled: true})
+	defer ln.Close()
+	if result := FullCheckHostname("", ln.Addr().String(), testTimeout); result.InfoResults != nil {
+		t.Errorf("Expected session features to only be checked by verbose checks, got %v", result.InfoResults)
+	}
+	result := VerboseCheckHostname("", ln.Addr().String(), testTimeout)
+	if _, ok := result.Checks[SessionResumption]; ok {
+		t.Error("session resumption should be reported as an informational result")
+	}
+	if info, ok := result.InfoResults[SessionResumption]; !ok || !strings.Contains(info.Messages[0], "does not support") {
+		t.Errorf("expected informational session resumption result, got %v", result.InfoResults)
+	}
+}
+
+func TestServerSupportsRenegotiationInfo(t *testing.T) {
+	hello := func(extensions ...byte) []byte {
+		body := append([]byte{3, 3}, make([]byte, 32)...)
+		// Empty session ID, cipher suite, compression method.
+		body = append(body, 0, 0xc0, 0x2f, 0)
+		if extensions != nil {
+			body = append(body, 0, byte(len(extensions)))
+			body = append(body, extensions...)
+		}
+		handshake := append([]byte{handshakeTypeServerHello, 0, 0, byte(len(body))}, body...)
+		return append([]byte{recordTypeHandshake, 3, 3, 0, byte(len(handshake))}, handshake...)
+	}
+	var tests = []struct {
+		name    string
+		data    []byte
+		want    bool
+		wantErr bool
+	}{
+		{"renegotiation_info", hello(0xff, 0x01, 0, 1, 0), true, false},
+		{"after other extensions", hello(0, 0x17, 0, 0, 0xff, 0x01, 0, 1, 0), true, false},
+		{"other extensions", hello(0, 0x17, 0, 0), false, false},
+		{"no extensions", hello(), false, false},
+		{"truncated", hello(0xff, 0x01, 0, 1, 0)[:20], false, true},
+		{"alert", []byte{21, 3, 3, 0, 2, 2, 40}, false, true},
+	}
+	for _, test := range tests {
+		got, err := serverSupportsRenegotiationInfo(test.data)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("%s: got %v, %v; want %v, error %v", test.name, got, err, test.want, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// SchemaVersion is the version of the DomainResult JSON schema. Adding an
+// optional field doesn't change it. Increment it whenever a field is renamed,
+// removed or changes meaning, and add an upgrade to schemaUpgrades so that
+// stored results in the previous schema can still be read.
+const SchemaVersion = 1
+
+// schemaUpgrades[i] converts a result decoded from schema version i to
+// version i+1.
+var schemaUpgrades = []func(*DomainResult){
+	upgradeFromV0,
+}
+
+// Version 0 is every result stored before schema versions were introduced.
+// These might not have MX records or a grade, which we can derive from the
+// hostname results.
+func upgradeFromV0(d *DomainResult) {
+	if len(d.MXRecords) == 0 {
+		hostnames := []string{}
+		for hostname := range d.HostnameResults {
+			hostnames = append(hostnames, hostname)
+		}
+		sort.Strings(hostnames)
+		for _, hostname := range hostnames {
+			record := MXRecord{Hostname: hostname, Preferred: containsString(d.PreferredHostnames, hostname)}
+			if result := d.HostnameResults[hostname]; result.Result != nil {
+				record.Status = result.Status
+			}
+			d.MXRecords = append(d.MXRecords, record)
+		}
+	}
+	if d.Grade == "" {
+		d.Grade, d.GradeReasons = ScoreDomain(*d)
+	}
+}
+
+// MarshalJSON marks the result with the current SchemaVersion.
+func (d DomainResult) MarshalJSON() ([]byte, error) {
+	type rawDomainResult DomainResult
+	d.SchemaVersion = SchemaVersion
+	return json.Marshal(rawDomainResult(d))
+}
+
+// UnmarshalJSON decodes a result in any schema version up to SchemaVersion,
+// and upgrades it to the current version.
+func (d *DomainResult) UnmarshalJSON(data []byte) error {
+	type rawDomainResult DomainResult
+	var raw rawDomainResult
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.SchemaVersion < 0 || raw.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("unsupported DomainResult schema version %d, expected at most %d",
+			raw.SchemaVersion, SchemaVersion)
+	}
+	*d = DomainResult(raw)
+	for _, upgrade := range schemaUpgrades[d.SchemaVersion:] {
+		upgrade(d)
+	}
+	d.SchemaVersion = SchemaVersion
+	return nil
+}
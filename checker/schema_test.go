@@ -0,0 +1,61 @@
+package checker
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// A result stored before schema versions were introduced.
+const legacyDomainResult = `{
+	"domain": "example.com",
+	"status": 0,
+	"results": {
+		"mx.example.com": {
+			"domain": "example.com",
+			"hostname": "mx.example.com",
+			"status": 0,
+			"checks": {
+				"connectivity": {"name": "connectivity", "status": 0},
+				"starttls": {"name": "starttls", "status": 0},
+				"certificate": {"name": "certificate", "status": 0},
+				"version": {"name": "version", "status": 0}
+			}
+		}
+	},
+	"preferred_hostnames": ["mx.example.com"],
+	"mta_sts": {"status": 2, "policy": "", "mode": "", "mxs": null}
+}`
+
+func TestUnmarshalLegacyDomainResult(t *testing.T) {
+	var result DomainResult
+	if err := json.Unmarshal([]byte(legacyDomainResult), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.SchemaVersion != SchemaVersion {
+		t.Errorf("Expected legacy result to be upgraded to version %d, got %d", SchemaVersion, result.SchemaVersion)
+	}
+	if len(result.MXRecords) != 1 || !result.MXRecords[0].Preferred || result.MXRecords[0].Hostname != "mx.example.com" {
+		t.Errorf("Expected MX records to be derived from hostname results, got %+v", result.MXRecords)
+	}
+	if result.Grade != GradeB {
+		t.Errorf("Expected legacy result to be graded B, got %q", result.Grade)
+	}
+}
+
+func TestDomainResultSchemaVersion(t *testing.T) {
+	encoded, err := json.Marshal(DomainResult{Domain: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(encoded, &fields)
+	if fields["schema_version"] != float64(SchemaVersion) {
+		t.Errorf("Expected results to be encoded with schema version %d, got %v", SchemaVersion, fields["schema_version"])
+	}
+
+	var result DomainResult
+	err = json.Unmarshal([]byte(`{"schema_version": 1000, "domain": "example.com"}`), &result)
+	if err == nil {
+		t.Error("Expected a result from a newer schema version to be rejected")
+	}
+}
@@ -0,0 +1,111 @@
+package checker
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+)
+
+// BuildVersion identifies the checker's build in the Settings recorded with
+// each scan. Releases set it when linking, with -ldflags "-X
+// github.com/EFForg/starttls-backend/checker.BuildVersion=v1.2.3". If it's
+// empty, the module version in the binary's build info is used.
+var BuildVersion string
+
+// Settings describes the checker that performed a domain check: its build,
+// the settings that affect its results, and the checks it ran, so results
+// from different checkers can be told apart.
+type Settings struct {
+	// Version of the checker's build. See BuildVersion.
+	Version string `json:"version"`
+	// Timeout for each network request, and Deadline for the whole check,
+	// written like "10s". Deadline is empty if there was none.
+	Timeout  string `json:"timeout"`
+	Deadline string `json:"deadline,omitempty"`
+	// GreylistRetries is how many times greylisting mailservers were
+	// re-checked.
+	GreylistRetries int `json:"greylist_retries"`
+	// HostnameCheck names the checks run against each mailserver: "full",
+	// "verbose", "sni", "none", or "custom" for a CheckHostname function.
+	HostnameCheck string `json:"hostname_check"`
+	// Checks are the IDs of every check that was enabled, eg. "starttls".
+	// Checks that are skipped because an earlier one failed are still
+	// listed.
+	Checks []string `json:"checks"`
+}
+
+// buildVersion returns BuildVersion, or the main module's version if it's
+// unset.
+func buildVersion() string {
+	if BuildVersion != "" {
+		return BuildVersion
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// HostnameCheck is a named way of checking each mailserver, so that the
+// Settings recorded with results can say which checks ran.
+type HostnameCheck struct {
+	// Name identifies the check in Settings, eg. "full".
+	Name string
+	// Checks are the IDs of the checks it runs, eg. "starttls".
+	Checks []string
+	// Check checks a single mailserver, closing its connections once the
+	// context is done.
+	Check func(context.Context, string, string, time.Duration) HostnameResult
+}
+
+var fullHostnameChecks = []string{Connectivity, STARTTLS, Certificate, Version}
+
+// The hostname checks a Checker can run.
+var (
+	FullHostnameCheck = &HostnameCheck{Name: "full", Checks: fullHostnameChecks,
+		Check: FullCheckHostnameContext}
+	VerboseHostnameCheck = &HostnameCheck{Name: "verbose",
+		Checks: append(append([]string{}, fullHostnameChecks...), SessionResumption, Renegotiation),
+		Check:  VerboseCheckHostnameContext}
+	SNIHostnameCheck = &HostnameCheck{Name: "sni",
+		Checks: append(append([]string{}, fullHostnameChecks...), SNI),
+		Check:  SNICheckHostnameContext}
+	NoopHostnameCheck = &HostnameCheck{Name: "none", Check: withoutContext(NoopCheckHostname)}
+)
+
+// withoutContext adapts check, which can't be interrupted, to a
+// HostnameCheck's Check.
+func withoutContext(check func(string, string, time.Duration) HostnameResult) func(context.Context, string, string, time.Duration) HostnameResult {
+	return func(_ context.Context, domain string, hostname string, timeout time.Duration) HostnameResult {
+		return check(domain, hostname, timeout)
+	}
+}
+
+// hostnameCheck returns the hostname check c runs. A CheckHostname function
+// takes precedence, and is named "custom", since it can't be told apart
+// from other functions.
+func (c *Checker) hostnameCheck() *HostnameCheck {
+	if c.CheckHostname != nil {
+		return &HostnameCheck{Name: "custom", Check: withoutContext(c.CheckHostname)}
+	}
+	if c.HostnameCheck != nil {
+		return c.HostnameCheck
+	}
+	return FullHostnameCheck
+}
+
+// Settings returns the Settings that c checks domains with.
+func (c *Checker) Settings() Settings {
+	s := Settings{
+		Version:         buildVersion(),
+		Timeout:         c.timeout().String(),
+		GreylistRetries: c.GreylistRetries,
+	}
+	if c.Deadline != 0 {
+		s.Deadline = c.Deadline.String()
+	}
+	check := c.hostnameCheck()
+	s.HostnameCheck = check.Name
+	s.Checks = append(append([]string{}, check.Checks...), MXHygiene, MTASTS)
+	return s
+}
@@ -0,0 +1,38 @@
+package checker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSettings(t *testing.T) {
+	c := Checker{Timeout: 3 * time.Second, Deadline: 30 * time.Second, HostnameCheck: VerboseHostnameCheck}
+	s := c.Settings()
+	if s.Version == "" || s.Timeout != "3s" || s.Deadline != "30s" || s.HostnameCheck != "verbose" {
+		t.Errorf("Expected the checker's settings, got %+v", s)
+	}
+	want := append(append([]string{}, fullHostnameChecks...), SessionResumption, Renegotiation, MXHygiene, MTASTS)
+	if !reflect.DeepEqual(s.Checks, want) {
+		t.Errorf("Expected checks %v, got %v", want, s.Checks)
+	}
+
+	BuildVersion = "v1.2.3"
+	defer func() { BuildVersion = "" }()
+	s = (&Checker{HostnameCheck: NoopHostnameCheck}).Settings()
+	if s.Version != "v1.2.3" || s.Timeout != "10s" || s.Deadline != "" || s.HostnameCheck != "none" {
+		t.Errorf("Expected the default timeout and no hostname checks, got %+v", s)
+	}
+	if !reflect.DeepEqual(s.Checks, []string{MXHygiene, MTASTS}) {
+		t.Errorf("Expected only domain checks, got %v", s.Checks)
+	}
+	s = (&Checker{CheckHostname: func(domain, hostname string, _ time.Duration) HostnameResult {
+		return HostnameResult{}
+	}}).Settings()
+	if s.HostnameCheck != "custom" {
+		t.Errorf("Expected an unknown hostname check to be custom, got %s", s.HostnameCheck)
+	}
+	if s = (&Checker{}).Settings(); s.HostnameCheck != "full" || len(s.Checks) != len(fullHostnameChecks)+2 {
+		t.Errorf("Expected the full hostname check by default, got %+v", s)
+	}
+}
@@ -0,0 +1,139 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// SinkRow is the flattened form of a DomainResult that is exported to an
+// analytics database.
+type SinkRow struct {
+	Domain             string    `json:"domain"`
+	Source             string    `json:"source"`
+	Timestamp          time.Time `json:"timestamp"`
+	Status             int       `json:"status"`
+	MXHostnames        []string  `json:"mx_hostnames"`
+	PreferredHostnames []string  `json:"preferred_hostnames"`
+	MTASTSMode         string    `json:"mta_sts_mode"`
+	// The full DomainResult, encoded as JSON.
+	Result string `json:"result"`
+}
+
+// NewSinkRow flattens a DomainResult, labelled with source, for export.
+func NewSinkRow(r DomainResult, source string, timestamp time.Time) (SinkRow, error) {
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return SinkRow{}, err
+	}
+	row := SinkRow{
+		Domain:             r.Domain,
+		Source:             source,
+		Timestamp:          timestamp.UTC(),
+		Status:             int(r.Status),
+		MXHostnames:        []string{},
+		PreferredHostnames: []string{},
+		Result:             string(encoded),
+	}
+	for _, mx := range r.MXRecords {
+		row.MXHostnames = append(row.MXHostnames, mx.Hostname)
+	}
+	row.PreferredHostnames = append(row.PreferredHostnames, r.PreferredHostnames...)
+	if r.MTASTSResult != nil {
+		row.MTASTSMode = r.MTASTSResult.Mode
+	}
+	return row, nil
+}
+
+// Sink stores exported domain results in an analytics database.
+type Sink interface {
+	// EnsureTable creates the destination table if it doesn't exist.
+	EnsureTable() error
+	// Insert stores a batch of rows.
+	Insert([]SinkRow) error
+}
+
+const defaultSinkBatchSize = 500
+
+// SinkHandler is a ResultHandler which streams results to a Sink in batches.
+// Call Flush once all domains have been handled to insert the final batch.
+type SinkHandler struct {
+	Sink Sink `json:"-"`
+	// Source labels every exported row, eg. TopDomainsSource.
+	Source    string
+	BatchSize int `json:"-"`
+
+	mu       sync.Mutex
+	rows     []SinkRow
+	Exported int
+	Failed   int
+}
+
+// HandleDomain queues a domain result for export, inserting the queued
+// batch once it's full.
+func (h *SinkHandler) HandleDomain(r DomainResult) {
+	row, err := NewSinkRow(r, h.Source, time.Now())
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		log.Printf("Couldn't export result for %s: %v", r.Domain, err)
+		h.Failed++
+		return
+	}
+	h.rows = append(h.rows, row)
+	batchSize := h.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultSinkBatchSize
+	}
+	if len(h.rows) >= batchSize {
+		h.flush()
+	}
+}
+
+// Flush inserts any queued rows.
+func (h *SinkHandler) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.flush()
+}
+
+func (h *SinkHandler) flush() error {
+	if len(h.rows) == 0 {
+		return nil
+	}
+	rows := h.rows
+	h.rows = nil
+	if err := h.Sink.Insert(rows); err != nil {
+		log.Printf("Couldn't export %d results: %v", len(rows), err)
+		h.Failed += len(rows)
+		return err
+	}
+	h.Exported += len(rows)
+	return nil
+}
+
+// SinkFromEnv configures a Sink from environment variables. SINK_TYPE
+// selects "bigquery" or "clickhouse"; see .env.example for the rest.
+func SinkFromEnv() (Sink, error) {
+	switch os.Getenv("SINK_TYPE") {
+	case "bigquery":
+		return &BigQuerySink{
+			Project:     os.Getenv("BIGQUERY_PROJECT"),
+			Dataset:     os.Getenv("BIGQUERY_DATASET"),
+			Table:       os.Getenv("BIGQUERY_TABLE"),
+			AccessToken: os.Getenv("BIGQUERY_ACCESS_TOKEN"),
+		}, nil
+	case "clickhouse":
+		return &ClickHouseSink{
+			URL:      os.Getenv("CLICKHOUSE_URL"),
+			Table:    os.Getenv("CLICKHOUSE_TABLE"),
+			User:     os.Getenv("CLICKHOUSE_USER"),
+			Password: os.Getenv("CLICKHOUSE_PASSWORD"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown SINK_TYPE %q", os.Getenv("SINK_TYPE"))
+	}
+}
@@ -0,0 +1,167 @@
+package checker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSink struct {
+	batches [][]SinkRow
+}
+
+func (s *fakeSink) EnsureTable() error { return nil }
+
+func (s *fakeSink) Insert(rows []SinkRow) error {
+	s.batches = append(s.batches, rows)
+	return nil
+}
+
+func TestSinkHandlerBatches(t *testing.T) {
+	sink := &fakeSink{}
+	h := &SinkHandler{Sink: sink, Source: LocalSource, BatchSize: 2}
+	for i := 0; i < 5; i++ {
+		h.HandleDomain(DomainResult{Domain: fmt.Sprintf("%d.example.com", i)})
+	}
+	if len(sink.batches) != 2 {
+		t.Errorf("expected 2 full batches before flushing, got %d", len(sink.batches))
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.batches) != 3 || len(sink.batches[2]) != 1 {
+		t.Errorf("expected the final partial batch to be inserted on Flush, got %v", sink.batches)
+	}
+	if h.Exported != 5 {
+		t.Errorf("expected 5 exported rows, got %d", h.Exported)
+	}
+	if sink.batches[0][0].Source != LocalSource {
+		t.Errorf("expected rows to be labelled with source %s", LocalSource)
+	}
+}
+
+func TestNewSinkRow(t *testing.T) {
+	result := DomainResult{
+		Domain:             "example.com",
+		Status:             DomainWarning,
+		PreferredHostnames: []string{"mx1.example.com"},
+		MXRecords: []MXRecord{
+			{Hostname: "mx1.example.com"},
+			{Hostname: "mx2.example.com"},
+		},
+		MTASTSResult: MakeMTASTSResult(),
+	}
+	result.MTASTSResult.Mode = "testing"
+	row, err := NewSinkRow(result, TopDomainsSource, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Status != 1 || row.MTASTSMode != "testing" || len(row.MXHostnames) != 2 {
+		t.Errorf("unexpected row %+v", row)
+	}
+	var decoded DomainResult
+	if err = json.Unmarshal([]byte(row.Result), &decoded); err != nil || decoded.Domain != "example.com" {
+		t.Errorf("expected the row to contain the full result, got %s", row.Result)
+	}
+}
+
+func TestClickHouseSink(t *testing.T) {
+	var queries []string
+	var inserted int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		if r.Header.Get("X-ClickHouse-User") != "census" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			inserted++
+		}
+	}))
+	defer ts.Close()
+
+	sink := &ClickHouseSink{URL: ts.URL, Table: "starttls.results", User: "census"}
+	if err := sink.EnsureTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Insert([]SinkRow{{Domain: "a.com"}, {Domain: "b.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(queries[0], "CREATE TABLE IF NOT EXISTS starttls.results") {
+		t.Errorf("unexpected schema query %s", queries[0])
+	}
+	if queries[1] != "INSERT INTO starttls.results FORMAT JSONEachRow" || inserted != 2 {
+		t.Errorf("expected a batched insert of 2 rows, got %s with %d rows", queries[1], inserted)
+	}
+
+	sink.Table = "results; DROP TABLE results"
+	if err := sink.Insert(nil); err == nil {
+		t.Error("expected an invalid table name to be rejected")
+	}
+	sink.Table = "results"
+	sink.User = "someone"
+	if err := sink.Insert([]SinkRow{{Domain: "a.com"}}); err == nil {
+		t.Error("expected an error response to be returned")
+	}
+}
+
+func TestBigQuerySink(t *testing.T) {
+	created := false
+	var inserted []SinkRow
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		tables := "/projects/p/datasets/d/tables"
+		switch {
+		case r.Method == "GET" && r.URL.Path == tables+"/results":
+			if !created {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.Method == "POST" && r.URL.Path == tables:
+			created = true
+		case r.Method == "POST" && r.URL.Path == tables+"/results/insertAll":
+			var request struct {
+				Rows []struct {
+					JSON SinkRow `json:"json"`
+				} `json:"rows"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			for _, row := range request.Rows {
+				if row.JSON.Domain == "bad.com" {
+					fmt.Fprint(w, `{"insertErrors": [{"index": 0, "errors": [{"message": "invalid"}]}]}`)
+					return
+				}
+				inserted = append(inserted, row.JSON)
+			}
+			fmt.Fprint(w, `{}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	sink := &BigQuerySink{Project: "p", Dataset: "d", Table: "results", AccessToken: "token", Endpoint: ts.URL}
+	if err := sink.EnsureTable(); err != nil || !created {
+		t.Fatalf("expected the table to be created, got %v", err)
+	}
+	if err := sink.EnsureTable(); err != nil {
+		t.Errorf("expected an existing table to be left alone, got %v", err)
+	}
+	if err := sink.Insert([]SinkRow{{Domain: "a.com"}, {Domain: "b.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(inserted) != 2 || inserted[1].Domain != "b.com" {
+		t.Errorf("expected 2 rows to be inserted, got %v", inserted)
+	}
+	if err := sink.Insert([]SinkRow{{Domain: "bad.com"}}); err == nil {
+		t.Error("expected insert errors to be returned")
+	}
+}
@@ -0,0 +1,77 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"time"
+)
+
+// SNICheckHostname performs all of the checks in FullCheckHostname, and
+// additionally compares the certificates presented with and without SNI.
+// It can be used as a Checker's CheckHostname.
+func SNICheckHostname(domain string, hostname string, timeout time.Duration) HostnameResult {
+	return SNICheckHostnameContext(context.Background(), domain, hostname, timeout)
+}
+
+// SNICheckHostnameContext performs SNICheckHostname, closing its connections
+// once ctx is done.
+func SNICheckHostnameContext(ctx context.Context, domain string, hostname string, timeout time.Duration) HostnameResult {
+	result := FullCheckHostnameContext(ctx, domain, hostname, timeout)
+	if !result.couldSTARTTLS() {
+		return result
+	}
+	result.addCheck(checkSNI(ctx, hostname, timeout))
+	return result
+}
+
+// Retrieves the leaf certificate presented by hostname after STARTTLS.
+// If serverName is empty, no SNI extension is sent.
+func fetchCertificate(ctx context.Context, hostname string, serverName string, timeout time.Duration) (*x509.Certificate, error) {
+	client, err := smtpDialContext(ctx, hostname, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	config := tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         serverName,
+	}
+	if err = client.StartTLS(&config); err != nil {
+		return nil, err
+	}
+	state, _ := client.TLSConnectionState()
+	return state.PeerCertificates[0], nil
+}
+
+// Performs the TLS handshake both with and without SNI, and reports whether
+// the server presents a different (or invalid) certificate when SNI is absent.
+// Some sending MTAs don't send SNI, so these differences affect deliverability.
+func checkSNI(ctx context.Context, hostname string, timeout time.Duration) *Result {
+	result := MakeResult(SNI)
+	serverName := withoutPort(strings.TrimSuffix(hostname, "."))
+	withSNI, err := fetchCertificate(ctx, hostname, serverName, timeout)
+	if err != nil {
+		return result.Error("Could not complete a TLS handshake with SNI: %v", err)
+	}
+	withoutSNI, err := fetchCertificate(ctx, hostname, "", timeout)
+	if err != nil {
+		return result.Warning("Could not complete a TLS handshake without SNI: %v", err)
+	}
+	if bytes.Equal(withSNI.Raw, withoutSNI.Raw) {
+		return result.Success()
+	}
+	result.Warning("Server presents a different certificate when SNI is not sent (%s with SNI, %s without).",
+		describeCert(withSNI), describeCert(withoutSNI))
+	if withSNI.VerifyHostname(serverName) == nil && withoutSNI.VerifyHostname(serverName) != nil {
+		result.Warning("The certificate presented without SNI is not valid for %s.", serverName)
+	}
+	return result
+}
+
+// Returns a short human-readable description of a certificate.
+func describeCert(cert *x509.Certificate) string {
+	return "[" + strings.Join(certNames(cert), ", ") + "]"
+}
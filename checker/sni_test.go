@@ -0,0 +1,54 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func TestSNISameCertificate(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer ln.Close()
+
+	addrParts := strings.Split(ln.Addr().String(), ":")
+	port := addrParts[len(addrParts)-1]
+	result := checkSNI(context.Background(), "localhost:"+port, testTimeout)
+	if result.Status != Success {
+		t.Errorf("Expected SNI check to succeed, got %v", result.Messages)
+	}
+}
+
+func TestSNIDifferentCertificate(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatched, err := tls.X509KeyPair([]byte(certStringHostnameMismatch), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{
+		GetCertificate: func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if info.ServerName == "localhost" {
+				return &cert, nil
+			}
+			return &mismatched, nil
+		},
+	})
+	defer ln.Close()
+
+	addrParts := strings.Split(ln.Addr().String(), ":")
+	port := addrParts[len(addrParts)-1]
+	result := checkSNI(context.Background(), "localhost:"+port, testTimeout)
+	if result.Status != Warning {
+		t.Errorf("Expected SNI check to warn, got status %d", result.Status)
+	}
+	if len(result.Messages) != 2 {
+		t.Errorf("Expected a certificate difference and a name mismatch, got %v", result.Messages)
+	}
+}
@@ -0,0 +1,187 @@
+package checker
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// DomainSource produces domains to check in bulk.
+type DomainSource interface {
+	// Next returns the next domain to check, or io.EOF when there are no more.
+	Next() (string, error)
+}
+
+// csvSource reads domains from a column of a CSV.
+type csvSource struct {
+	reader *csv.Reader
+	column int
+}
+
+// NewCSVSource creates a DomainSource that reads domains from the
+// zero-indexed column of a CSV.
+func NewCSVSource(reader *csv.Reader, column int) DomainSource {
+	return &csvSource{reader: reader, column: column}
+}
+
+func (s *csvSource) Next() (string, error) {
+	for {
+		data, err := s.reader.Read()
+		if err != nil {
+			return "", err
+		}
+		if len(data) > s.column {
+			return data[s.column], nil
+		}
+	}
+}
+
+// sliceSource produces domains from a slice.
+type sliceSource struct {
+	domains []string
+}
+
+// NewSliceSource creates a DomainSource that produces each of domains.
+func NewSliceSource(domains []string) DomainSource {
+	return &sliceSource{domains: domains}
+}
+
+func (s *sliceSource) Next() (string, error) {
+	if len(s.domains) == 0 {
+		return "", io.EOF
+	}
+	domain := s.domains[0]
+	s.domains = s.domains[1:]
+	return domain, nil
+}
+
+// defaultMaxSeen is how many domains a ZoneFileSource remembers by default.
+const defaultMaxSeen = 100000
+
+// ZoneFileSource reads unique registrable domains from a DNS zone file in
+// master file format (RFC 1035 section 5), such as the TLD zone files
+// distributed through ICANN's CZDS.
+type ZoneFileSource struct {
+	// MaxSeen is how many of the most recently read domains are remembered,
+	// so that their other records are skipped. A domain whose records are
+	// further apart than this in the zone file is returned again. Zone files
+	// group each domain's records, so the default, 100000, skips nearly
+	// every duplicate without holding a whole TLD in memory.
+	MaxSeen int
+
+	scanner *bufio.Scanner
+	origin  string
+	// lastOwner is the owner name of the previous record, which a record
+	// inherits if its owner name is omitted.
+	lastOwner string
+	// seen holds the domains in recent, a ring of the most recently read
+	// domains, whose oldest entry is at next.
+	seen   map[string]bool
+	recent []string
+	next   int
+	// resumeAfter is the last domain emitted by an earlier, interrupted run.
+	resumeAfter string
+}
+
+// NewZoneFileSource creates a ZoneFileSource reading from r. If resumeAfter
+// is not empty, domains are skipped until after resumeAfter is found, so
+// that an interrupted scan can be resumed.
+func NewZoneFileSource(r io.Reader, resumeAfter string) *ZoneFileSource {
+	scanner := bufio.NewScanner(r)
+	// Some zone file lines, eg. DNSSEC records, are long.
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &ZoneFileSource{
+		scanner:     scanner,
+		seen:        make(map[string]bool),
+		resumeAfter: strings.ToLower(strings.TrimSuffix(resumeAfter, ".")),
+	}
+}
+
+// Next returns the next registrable domain in the zone file that hasn't been
+// returned before.
+func (s *ZoneFileSource) Next() (string, error) {
+	for s.scanner.Scan() {
+		domain := s.parseLine(s.scanner.Text())
+		if domain == "" || s.seen[domain] {
+			continue
+		}
+		s.remember(domain)
+		if s.resumeAfter != "" {
+			if domain == s.resumeAfter {
+				s.resumeAfter = ""
+			}
+			continue
+		}
+		return domain, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+// remember adds domain to the recently read domains, forgetting the oldest
+// one if there are MaxSeen of them.
+func (s *ZoneFileSource) remember(domain string) {
+	maxSeen := s.MaxSeen
+	if maxSeen <= 0 {
+		maxSeen = defaultMaxSeen
+	}
+	if len(s.recent) < maxSeen {
+		s.recent = append(s.recent, domain)
+	} else {
+		delete(s.seen, s.recent[s.next])
+		s.recent[s.next] = domain
+		s.next = (s.next + 1) % len(s.recent)
+	}
+	s.seen[domain] = true
+}
+
+// parseLine returns the registrable domain that owns the record on line,
+// or "" if the line doesn't contain a record with a registrable owner.
+func (s *ZoneFileSource) parseLine(line string) string {
+	if i := strings.Index(line, ";"); i >= 0 {
+		line = line[:i]
+	}
+	if strings.TrimSpace(line) == "" {
+		return ""
+	}
+	fields := strings.Fields(line)
+	if strings.HasPrefix(fields[0], "$") {
+		if strings.ToUpper(fields[0]) == "$ORIGIN" && len(fields) > 1 {
+			s.origin = strings.ToLower(strings.TrimSuffix(fields[1], "."))
+		}
+		return ""
+	}
+	owner := s.lastOwner
+	// Lines starting with whitespace inherit the previous owner name.
+	if line[0] != ' ' && line[0] != '\t' {
+		owner = s.absoluteName(fields[0])
+		s.lastOwner = owner
+	}
+	if owner == "" {
+		return ""
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(owner)
+	if err != nil {
+		return ""
+	}
+	return domain
+}
+
+func (s *ZoneFileSource) absoluteName(name string) string {
+	name = strings.ToLower(name)
+	if name == "@" {
+		return s.origin
+	}
+	if strings.HasSuffix(name, ".") {
+		return strings.TrimSuffix(name, ".")
+	}
+	if s.origin == "" {
+		return name
+	}
+	return name + "." + s.origin
+}
@@ -0,0 +1,64 @@
+package checker
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testZone = `$ORIGIN com.
+$TTL 86400
+; comment line
+@ IN SOA a.gtld-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 86400
+example NS ns1.example.com.
+example NS ns2.example.com.
+	NS ns3.example.com.
+ns1.example A 192.0.2.1
+Other.com. 86400 IN NS ns1.example.com.
+sub.third 3600 NS ns.third.com. ; trailing comment
+`
+
+func readAll(t *testing.T, source DomainSource) []string {
+	domains := []string{}
+	for {
+		domain, err := source.Next()
+		if err == io.EOF {
+			return domains
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		domains = append(domains, domain)
+	}
+}
+
+func TestZoneFileSource(t *testing.T) {
+	got := readAll(t, NewZoneFileSource(strings.NewReader(testZone), ""))
+	want := []string{"example.com", "other.com", "third.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected domains %v, got %v", want, got)
+	}
+}
+
+func TestZoneFileSourceResume(t *testing.T) {
+	got := readAll(t, NewZoneFileSource(strings.NewReader(testZone), "example.com."))
+	want := []string{"other.com", "third.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected domains %v, got %v", want, got)
+	}
+}
+
+func TestZoneFileSourceForgetsOldDomains(t *testing.T) {
+	zone := "a.com. NS ns.a.com.\nb.com. NS ns.b.com.\nc.com. NS ns.c.com.\nb.com. A 192.0.2.1\na.com. A 192.0.2.1\n"
+	source := NewZoneFileSource(strings.NewReader(zone), "")
+	source.MaxSeen = 2
+	got := readAll(t, source)
+	want := []string{"a.com", "b.com", "c.com", "a.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected domains %v, got %v", want, got)
+	}
+	if len(source.seen) != 2 {
+		t.Errorf("Expected only 2 domains to be remembered, got %v", source.seen)
+	}
+}
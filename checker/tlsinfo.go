@@ -0,0 +1,63 @@
+package checker
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionSSL30: "SSL 3.0",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// tlsVersionName returns a readable name for a TLS version, eg. "TLS 1.2".
+func tlsVersionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
+
+// negotiatedTLS returns the TLS version and cipher suite negotiated over
+// client, or empty strings if it hasn't started TLS.
+func negotiatedTLS(client *smtp.Client) (string, string) {
+	state, ok := client.TLSConnectionState()
+	if !ok {
+		return "", ""
+	}
+	return tlsVersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)
+}
+
+// cipherFamilies maps fragments of cipher suite names to the bulk cipher and
+// mode they use, checked in order.
+var cipherFamilies = []struct {
+	fragment string
+	family   string
+}{
+	{"CHACHA20_POLY1305", "CHACHA20-POLY1305"},
+	{"AES_128_GCM", "AES-GCM"},
+	{"AES_256_GCM", "AES-GCM"},
+	{"AES_128_CCM", "AES-CCM"},
+	{"AES_128_CBC", "AES-CBC"},
+	{"AES_256_CBC", "AES-CBC"},
+	{"3DES_EDE_CBC", "3DES-CBC"},
+	{"RC4_128", "RC4"},
+}
+
+// CipherFamily groups a cipher suite, as named in HostnameResult.CipherSuite,
+// by its bulk cipher and mode, eg. "AES-GCM" for both
+// TLS_AES_128_GCM_SHA256 and TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384. Suites
+// we don't recognize are their own family.
+func CipherFamily(suite string) string {
+	for _, f := range cipherFamilies {
+		if strings.Contains(suite, f.fragment) {
+			return f.family
+		}
+	}
+	return suite
+}
@@ -0,0 +1,18 @@
+package checker
+
+import "testing"
+
+func TestCipherFamily(t *testing.T) {
+	tests := map[string]string{
+		"TLS_CHACHA20_POLY1305_SHA256":         "CHACHA20-POLY1305",
+		"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA": "AES-CBC",
+		"TLS_RSA_WITH_3DES_EDE_CBC_SHA":        "3DES-CBC",
+		"TLS_ECDHE_RSA_WITH_RC4_128_SHA":       "RC4",
+		"0x1234":                               "0x1234",
+	}
+	for suite, family := range tests {
+		if got := CipherFamily(suite); got != family {
+			t.Errorf("Expected %s in family %s, got %s", suite, family, got)
+		}
+	}
+}
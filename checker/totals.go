@@ -1,16 +1,19 @@
 package checker
 
 import (
+	"context"
 	"encoding/csv"
 	"io"
 	"log"
-	"os"
-	"strconv"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
 // AggregatedScan compiles aggregated stats across domains.
-// Implements ResultHandler.
+// Implements ResultHandler, and is safe for concurrent use.
 type AggregatedScan struct {
 	Time              time.Time
 	Source            string
@@ -20,6 +23,70 @@ type AggregatedScan struct {
 	MTASTSTestingList []string
 	MTASTSEnforce     int
 	MTASTSEnforceList []string
+	// Domains whose MX lookup failed with a temporary error, so we don't
+	// know whether they receive email. They aren't counted in WithMXs.
+	TemporaryErrors    int
+	TemporaryErrorList []string
+	// Domains that failed because their mailservers refused us with a
+	// temporary failure, as greylisting servers do. Those in GreylistedList
+	// are waiting for RetryGreylisted, and aren't counted in WithMXs until
+	// they're retried.
+	Greylisted     int
+	GreylistedList []string
+	// GreylistedThenPassed counts the greylisted domains that passed when
+	// they were retried. They're also counted in the other totals, as usual.
+	GreylistedThenPassed int
+	// Domains with MXs whose every MX hostname supports STARTTLS.
+	WithSTARTTLS int
+	// Domains with MXs whose every MX hostname presented a valid
+	// certificate.
+	WithValidCertificates int
+	// FailureReasons counts the checks that failed on any of a domain's MX
+	// hostnames, by check name, eg. "certificate". Each domain is counted
+	// at most once per check.
+	FailureReasons map[string]int
+	// TLSVersions and CipherFamilies count the TLS versions and cipher
+	// families (see CipherFamily) negotiated with each distinct MX hostname
+	// that supports STARTTLS.
+	TLSVersions    map[string]int
+	CipherFamilies map[string]int
+	// Software counts each distinct MX hostname we could connect to by the
+	// software named in its banner (see Fingerprint), or "unknown", and
+	// SoftwareWithoutSTARTTLS counts those that don't support STARTTLS.
+	Software                map[string]int
+	SoftwareWithoutSTARTTLS map[string]int
+	// ExtensionSets and TLSFingerprints count the same hostnames by the
+	// extensions they advertise, and the TLS version and cipher suite they
+	// chose (see Fingerprint).
+	ExtensionSets   map[string]int
+	TLSFingerprints map[string]int
+
+	// mu points to the *sync.Mutex guarding the scan, which is set up the
+	// first time it's locked, so that the zero value is ready to use.
+	mu            unsafe.Pointer
+	seenHostnames map[string]bool
+	// lastGreylisted is when the latest domain was added to GreylistedList.
+	lastGreylisted time.Time
+	// greylistRetries are the greylisted domains being retried.
+	greylistRetries map[string]bool
+}
+
+// mutex returns the scan's lock, setting it up if this is its first use.
+// Concurrent first uses agree on one lock without locking any others.
+func (a *AggregatedScan) mutex() *sync.Mutex {
+	if mu := atomic.LoadPointer(&a.mu); mu != nil {
+		return (*sync.Mutex)(mu)
+	}
+	atomic.CompareAndSwapPointer(&a.mu, nil, unsafe.Pointer(&sync.Mutex{}))
+	return (*sync.Mutex)(atomic.LoadPointer(&a.mu))
+}
+
+func (a *AggregatedScan) lock() {
+	a.mutex().Lock()
+}
+
+func (a *AggregatedScan) unlock() {
+	a.mutex().Unlock()
 }
 
 const (
@@ -45,6 +112,8 @@ func (a AggregatedScan) PercentMTASTS() float64 {
 
 // HandleDomain adds the result of a single domain scan to aggregated stats.
 func (a *AggregatedScan) HandleDomain(r DomainResult) {
+	a.lock()
+	defer a.unlock()
 	a.Attempted++
 	// Show progress.
 	if a.Attempted%1000 == 0 {
@@ -54,9 +123,24 @@ func (a *AggregatedScan) HandleDomain(r DomainResult) {
 	}
 
 	if len(r.HostnameResults) == 0 {
+		if r.ErrorClass == TemporaryError {
+			a.TemporaryErrors++
+			a.TemporaryErrorList = append(a.TemporaryErrorList, r.Domain)
+			return
+		}
 		// No MX records - assume this isn't an email domain.
 		return
 	}
+	if a.greylistRetries[r.Domain] {
+		if r.Status == DomainSuccess {
+			a.GreylistedThenPassed++
+		}
+	} else if r.greylisted() {
+		a.Greylisted++
+		a.GreylistedList = append(a.GreylistedList, r.Domain)
+		a.lastGreylisted = time.Now()
+		return
+	}
 	a.WithMXs++
 	if r.MTASTSResult != nil {
 		switch r.MTASTSResult.Mode {
@@ -68,6 +152,158 @@ func (a *AggregatedScan) HandleDomain(r DomainResult) {
 			a.MTASTSTestingList = append(a.MTASTSTestingList, r.Domain)
 		}
 	}
+	if allHostnamesPass(r, STARTTLS) {
+		a.WithSTARTTLS++
+	}
+	if allHostnamesPass(r, Certificate) {
+		a.WithValidCertificates++
+	}
+	for _, name := range failedChecks(r) {
+		if a.FailureReasons == nil {
+			a.FailureReasons = make(map[string]int)
+		}
+		a.FailureReasons[name]++
+	}
+	a.countHostnames(r)
+}
+
+// countHostnames adds the TLS versions and ciphers negotiated with a
+// domain's MX hostnames, and their fingerprints, to the distributions,
+// counting each hostname once, since many domains share the same
+// mailservers.
+func (a *AggregatedScan) countHostnames(r DomainResult) {
+	for hostname, h := range r.HostnameResults {
+		if (h.TLSVersion == "" && h.Fingerprint == nil) || a.seenHostnames[hostname] {
+			continue
+		}
+		if a.seenHostnames == nil {
+			a.seenHostnames = make(map[string]bool)
+		}
+		a.seenHostnames[hostname] = true
+		if h.TLSVersion != "" {
+			a.countTLS(h)
+		}
+		if h.Fingerprint != nil {
+			a.countFingerprint(h)
+		}
+	}
+}
+
+func (a *AggregatedScan) countTLS(h HostnameResult) {
+	if a.TLSVersions == nil {
+		a.TLSVersions = make(map[string]int)
+		a.CipherFamilies = make(map[string]int)
+	}
+	a.TLSVersions[h.TLSVersion]++
+	a.CipherFamilies[CipherFamily(h.CipherSuite)]++
+}
+
+func (a *AggregatedScan) countFingerprint(h HostnameResult) {
+	if a.Software == nil {
+		a.Software = make(map[string]int)
+		a.SoftwareWithoutSTARTTLS = make(map[string]int)
+		a.ExtensionSets = make(map[string]int)
+		a.TLSFingerprints = make(map[string]int)
+	}
+	software := h.Fingerprint.Software
+	if software == "" {
+		software = "unknown"
+	}
+	a.Software[software]++
+	if !h.couldSTARTTLS() {
+		a.SoftwareWithoutSTARTTLS[software]++
+	}
+	a.ExtensionSets[h.Fingerprint.Extensions]++
+	if h.Fingerprint.TLS != "" {
+		a.TLSFingerprints[h.Fingerprint.TLS]++
+	}
+}
+
+// allHostnamesPass returns true if check succeeded on every one of a domain's
+// MX hostnames.
+func allHostnamesPass(r DomainResult, check string) bool {
+	for _, h := range r.HostnameResults {
+		if h.Result == nil {
+			return false
+		}
+		result, ok := h.Checks[check]
+		if !ok || result.Status != Success {
+			return false
+		}
+	}
+	return len(r.HostnameResults) > 0
+}
+
+// failedChecks returns the names of the checks that failed or errored on any
+// of a domain's MX hostnames, sorted.
+func failedChecks(r DomainResult) []string {
+	failed := make(map[string]bool)
+	for _, h := range r.HostnameResults {
+		if h.Result == nil {
+			continue
+		}
+		for name, result := range h.Checks {
+			if result.Status == Failure || result.Status == Error {
+				failed[name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(failed))
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RetryTemporaryErrors checks the domains in a.TemporaryErrorList again,
+// adding their new results to a in place of the temporary errors.
+func (c *Checker) RetryTemporaryErrors(a *AggregatedScan) {
+	a.lock()
+	retries := a.TemporaryErrorList
+	a.Attempted -= len(retries)
+	a.TemporaryErrors = 0
+	a.TemporaryErrorList = nil
+	a.unlock()
+	c.CheckSource(NewSliceSource(retries), a)
+}
+
+// greylisted returns true if r failed temporarily because a mailserver
+// refused us with a temporary failure.
+func (r DomainResult) greylisted() bool {
+	if r.ErrorClass != TemporaryError {
+		return false
+	}
+	for _, h := range r.HostnameResults {
+		if h.TemporaryFailure {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryGreylisted checks the domains in a.GreylistedList again, once delay
+// has passed since the last of them was greylisted, adding their new results
+// to a. Domains that pass are counted in a.GreylistedThenPassed; those that
+// are still refused are counted as failures.
+func (c *Checker) RetryGreylisted(a *AggregatedScan, delay time.Duration) {
+	a.lock()
+	retries := a.GreylistedList
+	a.Attempted -= len(retries)
+	a.GreylistedList = nil
+	if a.greylistRetries == nil {
+		a.greylistRetries = make(map[string]bool)
+	}
+	for _, domain := range retries {
+		a.greylistRetries[domain] = true
+	}
+	wait := time.Until(a.lastGreylisted.Add(delay))
+	a.unlock()
+	if len(retries) == 0 {
+		return
+	}
+	time.Sleep(wait)
+	c.CheckSource(NewSliceSource(retries), a)
 }
 
 // ResultHandler processes domain results.
@@ -79,28 +315,101 @@ type ResultHandler interface {
 const defaultPoolSize = 16
 
 // CheckCSV runs the checker on a csv of domains, processing the results according
-// to resultHandler.
+// to resultHandler. If c.Checkpoint is set, progress is recorded there, so
+// that the scan can be resumed with ResumeCSV if it's interrupted.
 func (c *Checker) CheckCSV(domains *csv.Reader, resultHandler ResultHandler, domainColumn int) {
-	poolSize, err := strconv.Atoi(os.Getenv("CONNECTION_POOL_SIZE"))
-	if err != nil || poolSize <= 0 {
-		poolSize = defaultPoolSize
+	var progress *checkpointer
+	if c.Checkpoint != nil {
+		progress = c.newCheckpointer(resultHandler, Checkpoint{})
 	}
-	work := make(chan string)
+	c.checkDomains(NewCSVSource(domains, domainColumn), resultHandler, func(domain string) DomainResult {
+		return c.CheckDomain(domain, nil)
+	}, progress)
+}
+
+// CheckSource runs the checker on every domain from source, processing the
+// results according to resultHandler.
+func (c *Checker) CheckSource(domains DomainSource, resultHandler ResultHandler) {
+	c.checkDomains(domains, resultHandler, func(domain string) DomainResult {
+		return c.CheckDomain(domain, nil)
+	}, nil)
+}
+
+// CheckDomains checks each domain received from domains in a pool of
+// workers, and sends its result on the returned channel, so that callers can
+// feed domains from any source and consume results as they finish. Results
+// aren't in the order domains were received. The returned channel is closed
+// once domains is closed and every check has finished, or once ctx is done.
+func (c *Checker) CheckDomains(ctx context.Context, domains <-chan string) <-chan DomainResult {
+	poolSize := c.poolSize()
 	results := make(chan DomainResult)
+	var wg sync.WaitGroup
+	wg.Add(poolSize)
+	for i := 0; i < poolSize; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				var domain string
+				var ok bool
+				select {
+				case domain, ok = <-domains:
+					if !ok {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+				result := c.CheckDomainContext(ctx, domain, nil)
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// indexedDomain is a domain, and its position in a DomainSource.
+type indexedDomain struct {
+	index  int
+	domain string
+}
+
+type indexedResult struct {
+	indexedDomain
+	result DomainResult
+}
+
+// checkDomains runs check on every domain from source in a pool of workers,
+// processing the results according to resultHandler. If progress is not nil,
+// it's told about each handled domain.
+func (c *Checker) checkDomains(domains DomainSource, resultHandler ResultHandler, check func(string) DomainResult,
+	progress *checkpointer) {
+	poolSize := c.poolSize()
+	work := make(chan indexedDomain)
+	results := make(chan indexedResult)
 
 	go func() {
-		for {
-			data, err := domains.Read()
+		index := 0
+		if progress != nil {
+			index = progress.checkpoint.Checked
+		}
+		for ; ; index++ {
+			domain, err := domains.Next()
 			if err != nil {
 				if err != io.EOF {
-					log.Println("Error reading CSV")
+					log.Println("Error reading domains")
 					log.Fatal(err)
 				}
 				break
 			}
-			if len(data) > 0 {
-				work <- data[domainColumn]
-			}
+			work <- indexedDomain{index: index, domain: domain}
 		}
 		close(work)
 	}()
@@ -108,8 +417,8 @@ func (c *Checker) CheckCSV(domains *csv.Reader, resultHandler ResultHandler, dom
 	done := make(chan struct{})
 	for i := 0; i < poolSize; i++ {
 		go func() {
-			for domain := range work {
-				results <- c.CheckDomain(domain, nil)
+			for d := range work {
+				results <- indexedResult{indexedDomain: d, result: check(d.domain)}
 			}
 			done <- struct{}{}
 		}()
@@ -124,6 +433,12 @@ func (c *Checker) CheckCSV(domains *csv.Reader, resultHandler ResultHandler, dom
 	}()
 
 	for r := range results {
-		resultHandler.HandleDomain(r)
+		resultHandler.HandleDomain(r.result)
+		if progress != nil {
+			progress.done(r.index, r.domain)
+		}
+	}
+	if progress != nil {
+		progress.finish()
 	}
 }
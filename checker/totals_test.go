@@ -1,8 +1,11 @@
 package checker
 
 import (
+	"context"
 	"encoding/csv"
+	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -12,10 +15,12 @@ func TestCheckCSV(t *testing.T) {
 	reader := csv.NewReader(strings.NewReader(in))
 
 	c := Checker{
-		Cache:               MakeSimpleCache(10 * time.Minute),
-		lookupMXOverride:    mockLookupMX,
-		CheckHostname:       mockCheckHostname,
-		checkMTASTSOverride: mockCheckMTASTS,
+		Cache:                  MakeSimpleCache(10 * time.Minute),
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
 	}
 	totals := AggregatedScan{}
 	c.CheckCSV(reader, &totals, 0)
@@ -30,3 +35,198 @@ func TestCheckCSV(t *testing.T) {
 		t.Errorf("Expected 5 domains in MTA-STS testing mode, got %d", len(totals.MTASTSTestingList))
 	}
 }
+
+func TestCheckDomains(t *testing.T) {
+	c := Checker{
+		Cache:                  MakeSimpleCache(10 * time.Minute),
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	domains := make(chan string)
+	results := c.CheckDomains(context.Background(), domains)
+	go func() {
+		for _, domain := range []string{"empty", "domain", "nostarttls"} {
+			domains <- domain
+		}
+		close(domains)
+	}()
+	checked := make(map[string]bool)
+	for result := range results {
+		checked[result.Domain] = true
+	}
+	if len(checked) != 3 || !checked["empty"] || !checked["domain"] || !checked["nostarttls"] {
+		t.Errorf("Expected a result for each domain, got %v", checked)
+	}
+}
+
+func TestCheckDomainsCancel(t *testing.T) {
+	c := Checker{
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	// domains is never closed; cancelling ctx should still close results.
+	results := c.CheckDomains(ctx, make(chan string))
+	cancel()
+	select {
+	case _, ok := <-results:
+		if ok {
+			t.Errorf("Expected no results")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected results to be closed when ctx is cancelled")
+	}
+}
+
+func TestRetryTemporaryErrors(t *testing.T) {
+	lookups := 0
+	c := Checker{
+		lookupMXOverride: func(domain string) ([]*net.MX, error) {
+			lookups++
+			if lookups == 1 {
+				return mockLookupMX("dnstimeout")
+			}
+			return mockLookupMX(domain)
+		},
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	totals := AggregatedScan{}
+	c.CheckSource(NewSliceSource([]string{"domain"}), &totals)
+	if totals.WithMXs != 0 || totals.TemporaryErrors != 1 {
+		t.Fatalf("Expected a temporary error, got %+v", totals)
+	}
+	c.RetryTemporaryErrors(&totals)
+	if totals.Attempted != 1 || totals.WithMXs != 1 || totals.TemporaryErrors != 0 {
+		t.Errorf("Expected retry to replace the temporary error, got %+v", totals)
+	}
+}
+
+func TestRetryGreylisted(t *testing.T) {
+	greylisting := true
+	c := Checker{
+		Cache:            MakeSimpleCache(10 * time.Minute),
+		lookupMXOverride: mockLookupMX,
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			if greylisting {
+				result := mockCheckHostname(domain, "noconnection", timeout)
+				result.TemporaryFailure = true
+				return result
+			}
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	totals := AggregatedScan{}
+	c.CheckSource(NewSliceSource([]string{"domain"}), &totals)
+	if totals.WithMXs != 0 || totals.Greylisted != 1 || len(totals.GreylistedList) != 1 {
+		t.Fatalf("Expected domain to be greylisted, got %+v", totals)
+	}
+	// The temporary failure shouldn't have been cached.
+	greylisting = false
+	c.RetryGreylisted(&totals, 0)
+	if totals.Attempted != 1 || totals.WithMXs != 1 || totals.GreylistedThenPassed != 1 {
+		t.Errorf("Expected greylisted domain to pass when retried, got %+v", totals)
+	}
+	if totals.Greylisted != 1 || len(totals.GreylistedList) != 0 {
+		t.Errorf("Expected greylisted domain to be reported once, got %+v", totals)
+	}
+}
+
+func TestAggregatedScanMetrics(t *testing.T) {
+	in := "empty\ndomain\ndomain.tld\nnoconnection\nnoconnection2\nnostarttls\n"
+	c := Checker{
+		Cache:                  MakeSimpleCache(10 * time.Minute),
+		lookupMXOverride:       mockLookupMX,
+		CheckHostname:          mockCheckHostname,
+		checkMTASTSOverride:    mockCheckMTASTS,
+		checkMXHygieneOverride: mockCheckMXHygiene,
+		lookupTLSAOverride:     mockLookupTLSA,
+	}
+	totals := AggregatedScan{}
+	c.CheckCSV(csv.NewReader(strings.NewReader(in)), &totals, 0)
+	if totals.WithSTARTTLS != 2 || totals.WithValidCertificates != 2 {
+		t.Errorf("Expected 2 domains with STARTTLS and valid certificates, got %d and %d",
+			totals.WithSTARTTLS, totals.WithValidCertificates)
+	}
+	if totals.FailureReasons[Connectivity] != 3 || totals.FailureReasons[STARTTLS] != 2 {
+		t.Errorf("Expected connectivity and STARTTLS failures, got %v", totals.FailureReasons)
+	}
+}
+
+func TestAggregatedScanConcurrentUse(t *testing.T) {
+	totals := AggregatedScan{}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			totals.HandleDomain(NewSampleDomainResult("example.com"))
+		}()
+	}
+	wg.Wait()
+	if totals.Attempted != 50 || totals.WithMXs != 50 || totals.WithSTARTTLS != 50 {
+		t.Errorf("Expected 50 domains with STARTTLS, got %+v", totals)
+	}
+}
+
+func TestAggregatedScanTLSDistribution(t *testing.T) {
+	totals := AggregatedScan{}
+	shared := NewSampleDomainResult("example.com")
+	totals.HandleDomain(shared)
+	totals.HandleDomain(shared)
+	other := NewSampleDomainResult("example.org")
+	h := other.HostnameResults["mx.example.org"]
+	h.TLSVersion = "TLS 1.2"
+	h.CipherSuite = "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"
+	other.HostnameResults["mx.example.org"] = h
+	totals.HandleDomain(other)
+
+	if totals.TLSVersions["TLS 1.3"] != 1 || totals.TLSVersions["TLS 1.2"] != 1 {
+		t.Errorf("Expected each distinct hostname's version to be counted once, got %v", totals.TLSVersions)
+	}
+	if totals.CipherFamilies["AES-GCM"] != 2 {
+		t.Errorf("Expected both suites in the AES-GCM family, got %v", totals.CipherFamilies)
+	}
+}
+
+func TestAggregatedScanSoftware(t *testing.T) {
+	totals := AggregatedScan{}
+	for _, domain := range []string{"example.com", "example.org", "example.net"} {
+		r := NewSampleDomainResult(domain)
+		h := r.HostnameResults["mx."+domain]
+		h.Fingerprint = &Fingerprint{Software: "Exim 4.94", Extensions: "PIPELINING STARTTLS", TLS: "TLS 1.3 TLS_AES_128_GCM_SHA256"}
+		if domain == "example.net" {
+			h.Result = MakeResult("hostnames")
+			h.addCheck(MakeResult(Connectivity).Success())
+			h.addCheck(MakeResult(STARTTLS).Failure("Server does not advertise support for STARTTLS."))
+			h.Fingerprint = &Fingerprint{Extensions: "PIPELINING"}
+		}
+		r.HostnameResults["mx."+domain] = h
+		totals.HandleDomain(r)
+		totals.HandleDomain(r)
+	}
+
+	if totals.Software["Exim 4.94"] != 2 || totals.Software["unknown"] != 1 {
+		t.Errorf("Expected each distinct hostname's software to be counted once, got %v", totals.Software)
+	}
+	if len(totals.SoftwareWithoutSTARTTLS) != 1 || totals.SoftwareWithoutSTARTTLS["unknown"] != 1 {
+		t.Errorf("Expected only the hostname without STARTTLS to be counted, got %v", totals.SoftwareWithoutSTARTTLS)
+	}
+	if totals.ExtensionSets["PIPELINING STARTTLS"] != 2 || totals.ExtensionSets["PIPELINING"] != 1 {
+		t.Errorf("Expected extension sets to be counted, got %v", totals.ExtensionSets)
+	}
+	if totals.TLSFingerprints["TLS 1.3 TLS_AES_128_GCM_SHA256"] != 2 || len(totals.TLSFingerprints) != 1 {
+		t.Errorf("Expected TLS fingerprints of hostnames with STARTTLS, got %v", totals.TLSFingerprints)
+	}
+}
@@ -0,0 +1,111 @@
+package checker
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTranscriptLines caps the size of a recorded transcript.
+const maxTranscriptLines = 100
+
+// transcript records the plaintext part of an SMTP session, up to and
+// including the server's reply to STARTTLS.
+type transcript struct {
+	mu       sync.Mutex
+	lines    []string
+	sentTLS  bool
+	finished bool
+}
+
+// Matches IP address literals, which servers often echo back to us.
+var ipLiteral = regexp.MustCompile(`\[?(\d{1,3}\.){3}\d{1,3}\]?|\[?IPv6:[0-9a-fA-F:]+\]?`)
+
+// redact removes data that identifies the checker from a transcript line:
+// the hostname we introduce ourselves with, and our IP address.
+func redact(line string) string {
+	if ours := EHLOHostname(); ours != "" {
+		line = strings.Replace(line, ours, "[redacted]", -1)
+	}
+	return ipLiteral.ReplaceAllString(line, "[redacted]")
+}
+
+func (t *transcript) record(prefix string, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.finished {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\r\n"), "\n") {
+		if len(t.lines) >= maxTranscriptLines {
+			t.finished = true
+			return
+		}
+		t.lines = append(t.lines, prefix+redact(strings.TrimRight(line, "\r")))
+	}
+	if prefix == "C: " && strings.HasPrefix(strings.ToUpper(string(data)), "STARTTLS") {
+		t.sentTLS = true
+	} else if prefix == "S: " && t.sentTLS {
+		// Anything after the reply to STARTTLS is encrypted.
+		t.lines = append(t.lines, "[TLS handshake]")
+		t.finished = true
+	}
+}
+
+// Lines returns the recorded transcript.
+func (t *transcript) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.lines...)
+}
+
+// transcriptConn records data sent and received over a connection.
+type transcriptConn struct {
+	net.Conn
+	transcript *transcript
+}
+
+func (c *transcriptConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.transcript.record("S: ", b[:n])
+	}
+	return n, err
+}
+
+func (c *transcriptConn) Write(b []byte) (int, error) {
+	c.transcript.record("C: ", b)
+	return c.Conn.Write(b)
+}
+
+// VerboseCheckHostname performs all of the checks in FullCheckHostname, and
+// additionally records the server's banner, EHLO response and the STARTTLS
+// exchange in the result's Transcript. Identifying information about the
+// checker is redacted.
+func VerboseCheckHostname(domain string, hostname string, timeout time.Duration) HostnameResult {
+	return VerboseCheckHostnameContext(context.Background(), domain, hostname, timeout)
+}
+
+// VerboseCheckHostnameContext performs VerboseCheckHostname, closing its
+// connections once ctx is done.
+func VerboseCheckHostnameContext(ctx context.Context, domain string, hostname string, timeout time.Duration) HostnameResult {
+	t := &transcript{}
+	result := fullCheckHostname(ctx, domain, hostname, timeout, t)
+	result.Transcript = t.Lines()
+	return result
+}
+
+// WithoutTranscripts returns a copy of the DomainResult with the transcripts
+// removed from each hostname result.
+func (d DomainResult) WithoutTranscripts() DomainResult {
+	results := make(map[string]HostnameResult)
+	for hostname, result := range d.HostnameResults {
+		result.Transcript = nil
+		results[hostname] = result
+	}
+	d.HostnameResults = results
+	return d
+}
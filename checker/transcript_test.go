@@ -0,0 +1,44 @@
+package checker
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func TestVerboseCheckHostname(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer ln.Close()
+
+	result := VerboseCheckHostname("", ln.Addr().String(), testTimeout)
+	if len(result.Transcript) == 0 {
+		t.Fatal("Expected a transcript to be recorded")
+	}
+	if !strings.HasPrefix(result.Transcript[0], "S: 220") {
+		t.Errorf("Expected transcript to start with the server banner, got %s", result.Transcript[0])
+	}
+	last := result.Transcript[len(result.Transcript)-1]
+	if last != "[TLS handshake]" {
+		t.Errorf("Expected transcript to end at the TLS handshake, got %s", last)
+	}
+	for _, line := range result.Transcript {
+		if strings.Contains(line, "127.0.0.1") || strings.Contains(line, "EHLO localhost") {
+			t.Errorf("Expected checker's address and hostname to be redacted, got %s", line)
+		}
+	}
+
+	if result := FullCheckHostname("", ln.Addr().String(), testTimeout); result.Transcript != nil {
+		t.Errorf("Expected no transcript without verbose mode")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	got := redact("250-example.com Hello localhost [192.0.2.1]")
+	if got != "250-example.com Hello [redacted] [redacted]" {
+		t.Errorf("Unexpected redaction: %s", got)
+	}
+}
@@ -0,0 +1,241 @@
+// Package cli gives the backend's commands (starttls-check, backfill, replay)
+// the same flags, logging, signal handling, error output and exit codes, so
+// operators can script around them.
+//
+// Every command accepts:
+//
+//	-json   Write logs and the final error to stderr as lines of JSON.
+//	-quiet  Don't log progress; only the final error, if any, is written.
+//
+// and exits with one of the Exit codes below.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Exit codes shared by every command.
+const (
+	ExitOK = 0
+	// ExitFailure means the command failed while running.
+	ExitFailure = 1
+	// ExitUsage means the command was called with invalid flags or arguments.
+	// The flag package exits with it too.
+	ExitUsage = 2
+	// ExitConfig means the command's config file or environment is invalid,
+	// or a service it needs (like the database) couldn't be reached.
+	ExitConfig = 3
+	// ExitInterrupted means the command was stopped by SIGINT or SIGTERM
+	// before it finished.
+	ExitInterrupted = 130
+)
+
+// Error is an error that exits a command with Code.
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// UsageError returns an error that exits with ExitUsage, and prints the
+// command's usage.
+func UsageError(format string, a ...interface{}) error {
+	return &Error{Code: ExitUsage, Err: fmt.Errorf(format, a...)}
+}
+
+// ConfigError wraps err, if not nil, to exit with ExitConfig.
+func ConfigError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: ExitConfig, Err: err}
+}
+
+// Command sets up and runs a command.
+type Command struct {
+	Name  string
+	JSON  bool
+	Quiet bool
+	// Stderr receives logs and errors. Defaults to os.Stderr.
+	Stderr io.Writer
+
+	// exit is os.Exit, except in tests.
+	exit func(int)
+}
+
+// New creates a Command, and adds the common flags to flag.CommandLine. Call
+// it before flag.Parse.
+func New(name string) *Command {
+	c := &Command{Name: name}
+	c.RegisterFlags(flag.CommandLine)
+	return c
+}
+
+// RegisterFlags adds the common flags to fs.
+func (c *Command) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.JSON, "json", false, "Write logs and errors to stderr as lines of JSON")
+	fs.BoolVar(&c.Quiet, "quiet", false, "Only write errors to stderr, not progress logs")
+}
+
+func (c *Command) stderr() io.Writer {
+	if c.Stderr == nil {
+		return os.Stderr
+	}
+	return c.Stderr
+}
+
+// SetupLogging points the standard logger at stderr, formatted as the
+// common flags ask.
+func (c *Command) SetupLogging() {
+	switch {
+	case c.Quiet:
+		log.SetOutput(ioutil.Discard)
+	case c.JSON:
+		log.SetFlags(0)
+		log.SetOutput(&jsonLogWriter{command: c.Name, w: c.stderr()})
+	default:
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(c.stderr())
+	}
+}
+
+// Run sets up logging, and calls run with a context that is cancelled on
+// SIGINT or SIGTERM. A second signal exits straight away. If run fails, the
+// error is written to stderr and the process exits with the error's code;
+// if it succeeds, Run returns.
+func (c *Command) Run(run func(ctx context.Context) error) {
+	if code := c.Execute(run); code != ExitOK {
+		c.Exit(code)
+	}
+}
+
+// Execute is like Run, but returns the exit code instead of exiting.
+func (c *Command) Execute(run func(ctx context.Context) error) int {
+	c.SetupLogging()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("Received %s; stopping. Send it again to exit immediately", sig)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-signals:
+			c.Fail(&Error{Code: ExitInterrupted, Err: errors.New("interrupted")})
+		case <-done:
+		}
+	}()
+	err := run(ctx)
+	return c.report(err)
+}
+
+// Fail writes err to stderr and exits with its code. Use it where an error
+// can't be returned from the function passed to Run.
+func (c *Command) Fail(err error) {
+	c.Exit(c.report(err))
+}
+
+// Exit exits the process with code.
+func (c *Command) Exit(code int) {
+	if c.exit != nil {
+		c.exit(code)
+		return
+	}
+	os.Exit(code)
+}
+
+// Code returns the exit code for err.
+func Code(err error) int {
+	var e *Error
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	}
+	return ExitFailure
+}
+
+// errorOutput is the JSON written for errors with -json.
+type errorOutput struct {
+	Command string `json:"command"`
+	Error   string `json:"error"`
+	Code    int    `json:"code"`
+}
+
+var reportMu sync.Mutex
+
+// report writes err, if any, to stderr and returns its exit code.
+func (c *Command) report(err error) int {
+	code := Code(err)
+	if err == nil {
+		return code
+	}
+	if code == ExitInterrupted && errors.Is(err, context.Canceled) {
+		err = errors.New("interrupted")
+	}
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	if c.JSON {
+		json.NewEncoder(c.stderr()).Encode(errorOutput{Command: c.Name, Error: err.Error(), Code: code})
+		return code
+	}
+	fmt.Fprintf(c.stderr(), "%s: %v\n", c.Name, err)
+	if code == ExitUsage {
+		flag.CommandLine.SetOutput(c.stderr())
+		flag.Usage()
+	}
+	return code
+}
+
+// jsonLogWriter writes each line logged as a JSON object.
+type jsonLogWriter struct {
+	command string
+	w       io.Writer
+}
+
+type logOutput struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Message string    `json:"message"`
+}
+
+func (j *jsonLogWriter) Write(p []byte) (int, error) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	err := json.NewEncoder(j.w).Encode(logOutput{
+		Time:    time.Now().UTC(),
+		Command: j.command,
+		Message: strings.TrimSuffix(string(p), "\n"),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
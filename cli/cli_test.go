@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{nil, ExitOK},
+		{errors.New("failed"), ExitFailure},
+		{UsageError("bad flag %s", "x"), ExitUsage},
+		{ConfigError(errors.New("no database")), ExitConfig},
+		{fmt.Errorf("wrapped: %w", ConfigError(errors.New("no database"))), ExitConfig},
+		{fmt.Errorf("stopped: %w", context.Canceled), ExitInterrupted},
+	}
+	for _, test := range tests {
+		if code := Code(test.err); code != test.code {
+			t.Errorf("Expected code %d for %v, got %d", test.code, test.err, code)
+		}
+	}
+	if ConfigError(nil) != nil {
+		t.Error("Expected ConfigError(nil) to be nil")
+	}
+}
+
+func TestExecuteJSON(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	var stderr bytes.Buffer
+	c := &Command{Name: "test", JSON: true, Stderr: &stderr}
+	code := c.Execute(func(ctx context.Context) error {
+		log.Printf("progress")
+		return ConfigError(errors.New("no database"))
+	})
+	if code != ExitConfig {
+		t.Errorf("Expected ExitConfig, got %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a log line and an error, got %q", stderr.String())
+	}
+	var logged logOutput
+	if err := json.Unmarshal([]byte(lines[0]), &logged); err != nil || logged.Message != "progress" || logged.Command != "test" {
+		t.Errorf("Expected JSON log line, got %q", lines[0])
+	}
+	var output errorOutput
+	if err := json.Unmarshal([]byte(lines[1]), &output); err != nil {
+		t.Fatal(err)
+	}
+	if output != (errorOutput{Command: "test", Error: "no database", Code: ExitConfig}) {
+		t.Errorf("Unexpected error output %+v", output)
+	}
+}
+
+func TestRunExits(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	var stderr bytes.Buffer
+	exited := -1
+	c := &Command{Name: "test", Quiet: true, Stderr: &stderr, exit: func(code int) { exited = code }}
+	c.Run(func(ctx context.Context) error {
+		log.Printf("progress")
+		return nil
+	})
+	if exited != -1 || stderr.Len() != 0 {
+		t.Errorf("Expected quiet success not to exit or write, got %d %q", exited, stderr.String())
+	}
+	c.Run(func(ctx context.Context) error { return context.Canceled })
+	if exited != ExitInterrupted || stderr.String() != "test: interrupted\n" {
+		t.Errorf("Expected interrupted exit, got %d %q", exited, stderr.String())
+	}
+}
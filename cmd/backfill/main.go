@@ -0,0 +1,117 @@
+// Command backfill re-derives the summary fields of every stored scan (domain
+// status, MX record statuses, grade, MTA-STS mode and schema version) from its
+// raw results, using the current checker code. Run it after changing how
+// those fields are derived, so historical scans reflect the change without
+// re-scanning any domains.
+//
+// The database is configured with the same environment variables as the
+// backend.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/cli"
+	"github.com/EFForg/starttls-backend/db"
+	"github.com/EFForg/starttls-backend/models"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+// scanStore is the subset of db.SQLDatabase used to backfill scans.
+type scanStore interface {
+	CountScans() (int, error)
+	GetScanBatch(int64, int) ([]int64, []models.Scan, error)
+	UpdateScans([]int64, []models.Scan) error
+}
+
+// progress counts the scans backfilled so far.
+type progress struct {
+	Total   int
+	Done    int
+	Changed int
+}
+
+// backfill walks through every stored scan in batches of batchSize, and
+// rewrites each with checker.Rederive, a batch at a time. With dryRun, scans are only counted.
+// report is called after every batch. Stops between batches once ctx is done.
+func backfill(ctx context.Context, store scanStore, batchSize int, dryRun bool, report func(progress)) (progress, error) {
+	var p progress
+	var err error
+	if p.Total, err = store.CountScans(); err != nil {
+		return p, err
+	}
+	var lastID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return p, err
+		}
+		ids, scans, err := store.GetScanBatch(lastID, batchSize)
+		if err != nil {
+			return p, err
+		}
+		if len(scans) == 0 {
+			return p, nil
+		}
+		for i, scan := range scans {
+			rederived := checker.Rederive(scan.Data)
+			if rederived.Status != scan.Data.Status || rederived.Grade != scan.Data.Grade ||
+				mtastsMode(rederived) != mtastsMode(scan.Data) {
+				p.Changed++
+			}
+			scans[i].Data = rederived
+		}
+		if !dryRun {
+			// Always rewrite, so that scans stored in older schema
+			// versions are upgraded too.
+			if err := store.UpdateScans(ids, scans); err != nil {
+				return p, err
+			}
+		}
+		p.Done += len(scans)
+		lastID = ids[len(ids)-1]
+		report(p)
+	}
+}
+
+// mtastsMode returns the mode of d's MTA-STS policy, or "" if it wasn't
+// checked.
+func mtastsMode(d checker.DomainResult) string {
+	if d.MTASTSResult == nil {
+		return ""
+	}
+	return d.MTASTSResult.Mode
+}
+
+func main() {
+	cmd := cli.New("backfill")
+	batchSize := flag.Int("batch-size", 500, "Number of scans to read and rewrite at a time")
+	dryRun := flag.Bool("dry-run", false, "Count the scans whose status, grade or MTA-STS mode would change, without rewriting any")
+	flag.Parse()
+
+	cmd.Run(func(ctx context.Context) error {
+		if *batchSize < 1 || *batchSize > db.MaxScanBatch {
+			return cli.UsageError("batch-size must be between 1 and %d", db.MaxScanBatch)
+		}
+		cfg, err := db.LoadEnvironmentVariables()
+		if err != nil {
+			return cli.ConfigError(err)
+		}
+		database, err := db.InitSQLDatabase(cfg)
+		if err != nil {
+			return cli.ConfigError(err)
+		}
+		p, err := backfill(ctx, database, *batchSize, *dryRun, func(p progress) {
+			log.Printf("Backfilled %d/%d scans, %d with a new status or grade", p.Done, p.Total, p.Changed)
+		})
+		if err != nil {
+			return fmt.Errorf("backfill stopped after %d/%d scans: %w", p.Done, p.Total, err)
+		}
+		log.Printf("Done: backfilled %d scans, %d with a new status or grade", p.Done, p.Changed)
+		return nil
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+type mockScanStore struct {
+	scans   []models.Scan
+	updated map[int64]models.Scan
+}
+
+func (m *mockScanStore) CountScans() (int, error) { return len(m.scans), nil }
+
+// IDs are 1 + the scan's index.
+func (m *mockScanStore) GetScanBatch(afterID int64, limit int) ([]int64, []models.Scan, error) {
+	ids := []int64{}
+	scans := []models.Scan{}
+	for i := int(afterID); i < len(m.scans) && len(scans) < limit; i++ {
+		ids = append(ids, int64(i+1))
+		scans = append(scans, m.scans[i])
+	}
+	return ids, scans, nil
+}
+
+func (m *mockScanStore) UpdateScans(ids []int64, scans []models.Scan) error {
+	for i, id := range ids {
+		m.updated[id] = scans[i]
+	}
+	return nil
+}
+
+func TestBackfill(t *testing.T) {
+	store := &mockScanStore{updated: make(map[int64]models.Scan)}
+	for _, domain := range []string{"a.com", "b.com", "c.com"} {
+		store.scans = append(store.scans, models.Scan{Domain: domain, Data: checker.NewSampleDomainResult(domain)})
+	}
+	// Grade was never set on these scans.
+	store.scans[1].Data.Grade, _ = checker.ScoreDomain(store.scans[1].Data)
+	batches := 0
+	p, err := backfill(context.Background(), store, 2, false, func(progress) { batches++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Total != 3 || p.Done != 3 || p.Changed != 2 || batches != 2 {
+		t.Errorf("Expected 3 scans in 2 batches with 2 changed, got %+v in %d batches", p, batches)
+	}
+	if len(store.updated) != 3 || store.updated[3].Data.Grade == "" {
+		t.Errorf("Expected every scan to be rewritten with a grade, got %v", store.updated)
+	}
+}
+
+func TestBackfillDryRun(t *testing.T) {
+	store := &mockScanStore{updated: make(map[int64]models.Scan),
+		scans: []models.Scan{{Domain: "a.com", Data: checker.NewSampleDomainResult("a.com")}}}
+	p, err := backfill(context.Background(), store, 10, true, func(progress) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Done != 1 || p.Changed != 1 || len(store.updated) != 0 {
+		t.Errorf("Expected dry run to count changes without rewriting, got %+v and %v", p, store.updated)
+	}
+}
@@ -0,0 +1,218 @@
+// Command replay sends requests captured from production (see
+// api.TrafficCapture) to another instance of the backend, and compares its
+// responses with the ones production gave. Run the target with
+// MOCK_NETWORK=1, so that replayed scans and queue requests only reach the
+// fake network, and not real mailservers or inboxes.
+//
+//	replay -target https://staging.example.com -key <staging API key> capture.jsonl
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EFForg/starttls-backend/api"
+	"github.com/EFForg/starttls-backend/cli"
+)
+
+// options control how captured requests are replayed.
+type options struct {
+	Target string
+	// Speed scales the gaps between requests: 1 replays them as they were
+	// captured, 2 twice as fast. With 0, requests are sent without gaps.
+	Speed float64
+	// Key replaces the API keys of captured requests that had one.
+	Key         string
+	Concurrency int
+}
+
+// pathReport summarizes the replayed requests to a single path.
+type pathReport struct {
+	Path     string
+	Requests int
+	// SameStatus counts responses with the same status code as in
+	// production, and Errors requests that got no response at all.
+	SameStatus int
+	Errors     int
+	// Total response times in production, and on the target.
+	Captured time.Duration
+	Replayed time.Duration
+}
+
+func readCapture(r io.Reader) ([]api.CapturedRequest, error) {
+	var requests []api.CapturedRequest
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var captured api.CapturedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &captured); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		requests = append(requests, captured)
+	}
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].Time.Before(requests[j].Time) })
+	return requests, scanner.Err()
+}
+
+// newRequest rebuilds a captured request, addressed to target.
+func newRequest(captured api.CapturedRequest, opts options) (*http.Request, error) {
+	url := strings.TrimSuffix(opts.Target, "/") + captured.Path
+	if len(captured.Query) > 0 {
+		url += "?" + captured.Query.Encode()
+	}
+	var body io.Reader
+	if len(captured.Form) > 0 {
+		body = strings.NewReader(captured.Form.Encode())
+	}
+	req, err := http.NewRequest(captured.Method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if captured.Accept != "" {
+		req.Header.Set("Accept", captured.Accept)
+	}
+	if captured.Key != "" && opts.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Key)
+	}
+	return req, nil
+}
+
+// replay sends each captured request to the target, keeping their original
+// pacing scaled by opts.Speed, and reports on the responses by path. Once ctx
+// is done, no more requests are sent.
+func replay(ctx context.Context, requests []api.CapturedRequest, opts options, client *http.Client) []pathReport {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	reports := make(map[string]*pathReport)
+	slots := make(chan struct{}, opts.Concurrency)
+	start := time.Now()
+replaying:
+	for _, captured := range requests {
+		var wait <-chan time.Time
+		if opts.Speed > 0 {
+			offset := time.Duration(float64(captured.Time.Sub(requests[0].Time)) / opts.Speed)
+			wait = time.After(time.Until(start.Add(offset)))
+		} else {
+			wait = time.After(0)
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			break replaying
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break replaying
+		}
+		wg.Add(1)
+		go func(captured api.CapturedRequest) {
+			defer func() { <-slots; wg.Done() }()
+			status, took, err := send(captured, opts, client)
+			mu.Lock()
+			defer mu.Unlock()
+			report, ok := reports[captured.Path]
+			if !ok {
+				report = &pathReport{Path: captured.Path}
+				reports[captured.Path] = report
+			}
+			report.Requests++
+			report.Captured += time.Duration(captured.DurationMillis) * time.Millisecond
+			report.Replayed += took
+			if err != nil {
+				log.Printf("%s %s: %v", captured.Method, captured.Path, err)
+				report.Errors++
+			} else if status == captured.Status {
+				report.SameStatus++
+			}
+		}(captured)
+	}
+	wg.Wait()
+	sorted := make([]pathReport, 0, len(reports))
+	for _, report := range reports {
+		sorted = append(sorted, *report)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	return sorted
+}
+
+func send(captured api.CapturedRequest, opts options, client *http.Client) (int, time.Duration, error) {
+	req, err := newRequest(captured, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, time.Since(start), nil
+}
+
+func mean(total time.Duration, n int) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	return (total / time.Duration(n)).Round(time.Millisecond)
+}
+
+func main() {
+	cmd := cli.New("replay")
+	var opts options
+	flag.StringVar(&opts.Target, "target", "", "Base URL of the instance to replay requests against")
+	flag.Float64Var(&opts.Speed, "speed", 1, "Replay speed relative to the capture; 0 sends requests back to back")
+	flag.StringVar(&opts.Key, "key", "", "API key to send in place of captured API keys")
+	flag.IntVar(&opts.Concurrency, "concurrency", 16, "Maximum requests in flight")
+	timeout := flag.Duration("timeout", time.Minute, "Timeout for each request")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: replay -target <url> [flags] <capture file>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cmd.Run(func(ctx context.Context) error {
+		if opts.Target == "" || flag.NArg() != 1 {
+			return cli.UsageError("a target and a capture file are required")
+		}
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			return err
+		}
+		requests, err := readCapture(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		log.Printf("Replaying %d requests against %s", len(requests), opts.Target)
+		reports := replay(ctx, requests, opts, &http.Client{Timeout: *timeout})
+		fmt.Printf("%-28s %8s %8s %8s %12s %12s\n", "PATH", "REQUESTS", "SAME", "ERRORS", "CAPTURED", "REPLAYED")
+		for _, r := range reports {
+			fmt.Printf("%-28s %8d %8d %8d %12s %12s\n", r.Path, r.Requests, r.SameStatus, r.Errors,
+				mean(r.Captured, r.Requests), mean(r.Replayed, r.Requests))
+		}
+		return ctx.Err()
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/api"
+)
+
+func TestReadCapture(t *testing.T) {
+	capture := `{"time":"2020-01-01T00:00:02Z","method":"GET","path":"/api/stats","status":200}
+
+{"time":"2020-01-01T00:00:01Z","method":"POST","path":"/api/scan","form":{"domain":["a.com"]},"status":200}
+`
+	requests, err := readCapture(strings.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0].Path != "/api/scan" {
+		t.Errorf("Expected requests sorted by time, got %+v", requests)
+	}
+	if _, err = readCapture(strings.NewReader("not json\n")); err == nil {
+		t.Error("Expected error reading malformed capture")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var mu sync.Mutex
+	var received []*http.Request
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		received = append(received, r)
+		mu.Unlock()
+		if r.URL.Path == "/api/queue" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer target.Close()
+
+	now := time.Now()
+	requests := []api.CapturedRequest{
+		{Time: now, Method: "POST", Path: "/api/scan", Form: url.Values{"domain": {"a.com"}}, Key: "key-1", Status: 200},
+		{Time: now.Add(time.Second), Method: "GET", Path: "/api/scan", Query: url.Values{"domain": {"a.com"}}, Status: 200},
+		{Time: now.Add(2 * time.Second), Method: "POST", Path: "/api/queue", Status: 200},
+	}
+	start := time.Now()
+	reports := replay(context.Background(), requests, options{Target: target.URL, Speed: 10, Key: "staging", Concurrency: 2}, http.DefaultClient)
+	if took := time.Since(start); took < 150*time.Millisecond {
+		t.Errorf("Expected requests to be paced, took %s", took)
+	}
+	if len(reports) != 2 || reports[0].Path != "/api/queue" || reports[1].Path != "/api/scan" {
+		t.Fatalf("Expected reports for each path, got %+v", reports)
+	}
+	if reports[0].SameStatus != 0 || reports[1].Requests != 2 || reports[1].SameStatus != 2 {
+		t.Errorf("Unexpected reports %+v", reports)
+	}
+	for _, r := range received {
+		if r.Method == "POST" && r.URL.Path == "/api/scan" {
+			if r.FormValue("domain") != "a.com" || r.Header.Get("Authorization") != "Bearer staging" {
+				t.Errorf("Expected form and staging key to be sent, got %v %v", r.Form, r.Header)
+			}
+		}
+		if r.URL.Path == "/api/queue" && r.Header.Get("Authorization") != "" {
+			t.Error("Expected anonymous request to be replayed without a key")
+		}
+	}
+}
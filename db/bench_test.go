@@ -0,0 +1,121 @@
+package db_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// Benchmarks compare storing scans one at a time against storing them in
+// batches, as bulk scans do. Like the other tests here, they need the test
+// database. Run them with:
+//   go test -run XXX -bench Scan ./db
+
+// benchmarkBatchSize is the batch size bulk scans are stored in by default.
+const benchmarkBatchSize = 500
+
+func benchmarkScans(n int) []models.Scan {
+	scans := make([]models.Scan, n)
+	for i := range scans {
+		domain := fmt.Sprintf("bench%d.example.com", i)
+		scans[i] = models.Scan{
+			Domain:    domain,
+			Data:      checker.NewSampleDomainResult(domain),
+			Timestamp: time.Now(),
+			Version:   models.ScanVersion,
+			Source:    models.SourceCensus,
+			Profile:   models.ProfileFull,
+		}
+	}
+	return scans
+}
+
+// reportScansPerSecond reports the rate scans were stored at since start.
+func reportScansPerSecond(b *testing.B, start time.Time, scans int) {
+	b.ReportMetric(float64(scans)/time.Since(start).Seconds(), "scans/s")
+}
+
+func BenchmarkPutScan(b *testing.B) {
+	database.ClearTables()
+	scans := benchmarkScans(benchmarkBatchSize)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		for _, scan := range scans {
+			if err := database.PutScan(scan); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	reportScansPerSecond(b, start, b.N*len(scans))
+}
+
+func BenchmarkPutScans(b *testing.B) {
+	database.ClearTables()
+	scans := benchmarkScans(benchmarkBatchSize)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := database.PutScans(scans); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportScansPerSecond(b, start, b.N*len(scans))
+}
+
+func BenchmarkUpdateScan(b *testing.B) {
+	ids, scans := storedBenchmarkScans(b)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		for j, scan := range scans {
+			if err := database.UpdateScan(ids[j], scan); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	reportScansPerSecond(b, start, b.N*len(scans))
+}
+
+func BenchmarkUpdateScans(b *testing.B) {
+	ids, scans := storedBenchmarkScans(b)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := database.UpdateScans(ids, scans); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportScansPerSecond(b, start, b.N*len(scans))
+}
+
+func BenchmarkGetLatestScan(b *testing.B) {
+	database.ClearTables()
+	scans := benchmarkScans(benchmarkBatchSize)
+	if err := database.PutScans(scans); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := database.GetLatestScan(scans[i%len(scans)].Domain); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// storedBenchmarkScans stores a batch of scans, and reads them back with
+// their IDs.
+func storedBenchmarkScans(b *testing.B) ([]int64, []models.Scan) {
+	database.ClearTables()
+	if err := database.PutScans(benchmarkScans(benchmarkBatchSize)); err != nil {
+		b.Fatal(err)
+	}
+	ids, scans, err := database.GetScanBatch(0, benchmarkBatchSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return ids, scans
+}
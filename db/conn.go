@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// timeoutConn is a database connection pool whose queries, and transactions,
+// time out after timeout, unless it's zero. Its queries are recorded in the
+// database metrics.
+type timeoutConn struct {
+	*sql.DB
+	timeout time.Duration
+}
+
+// deadline returns the context to run a query in, and the function that
+// releases it once the query's results have been read.
+func (c timeoutConn) deadline() (context.Context, context.CancelFunc) {
+	if c.timeout == 0 {
+		return context.Background(), func() {}
+	}
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// Exec executes a query that doesn't return rows.
+func (c timeoutConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := c.deadline()
+	defer cancel()
+	start := time.Now()
+	result, err := c.ExecContext(ctx, query, args...)
+	observeQuery(query, start, err)
+	return result, err
+}
+
+// Query executes a query that returns rows. Its deadline is released when
+// the rows are closed.
+func (c timeoutConn) Query(query string, args ...interface{}) (*timeoutRows, error) {
+	ctx, cancel := c.deadline()
+	start := time.Now()
+	rows, err := c.QueryContext(ctx, query, args...)
+	observeQuery(query, start, err)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &timeoutRows{Rows: rows, cancel: cancel}, nil
+}
+
+// QueryRow executes a query that returns at most one row. Its deadline is
+// released when the row is scanned.
+func (c timeoutConn) QueryRow(query string, args ...interface{}) *timeoutRow {
+	ctx, cancel := c.deadline()
+	start := time.Now()
+	row := c.QueryRowContext(ctx, query, args...)
+	observeQuery(query, start, row.Err())
+	return &timeoutRow{Row: row, cancel: cancel}
+}
+
+// Begin starts a transaction, which is rolled back if it isn't committed
+// before the timeout. Its deadline is released when it's committed or
+// rolled back.
+func (c timeoutConn) Begin() (*timeoutTx, error) {
+	ctx, cancel := c.deadline()
+	start := time.Now()
+	tx, err := c.BeginTx(ctx, nil)
+	observeQuery("BEGIN", start, err)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &timeoutTx{Tx: tx, cancel: cancel}, nil
+}
+
+// timeoutRows are the rows returned by a query, which release its deadline
+// when they're closed.
+type timeoutRows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+// Close closes the rows, and releases the query's deadline.
+func (r *timeoutRows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+// timeoutRow is the row returned by a query, which releases its deadline
+// when it's scanned.
+type timeoutRow struct {
+	*sql.Row
+	cancel context.CancelFunc
+}
+
+// Scan copies the row's columns into dest, and releases the query's
+// deadline.
+func (r *timeoutRow) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return r.Row.Scan(dest...)
+}
+
+// timeoutTx is a transaction, whose queries share the deadline it was begun
+// with. Its queries are recorded in the database metrics.
+type timeoutTx struct {
+	*sql.Tx
+	cancel context.CancelFunc
+}
+
+// Exec executes a query that doesn't return rows within the transaction.
+func (tx *timeoutTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := tx.Tx.Exec(query, args...)
+	observeQuery(query, start, err)
+	return result, err
+}
+
+// execPrepared executes stmt, prepared from query, within the transaction.
+func (tx *timeoutTx) execPrepared(stmt *sql.Stmt, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := tx.Stmt(stmt).Exec(args...)
+	observeQuery(query, start, err)
+	return result, err
+}
+
+// Query executes a query that returns rows within the transaction.
+func (tx *timeoutTx) Query(query string, args ...interface{}) (*timeoutRows, error) {
+	start := time.Now()
+	rows, err := tx.Tx.Query(query, args...)
+	observeQuery(query, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &timeoutRows{Rows: rows, cancel: func() {}}, nil
+}
+
+// QueryRow executes a query that returns at most one row within the
+// transaction.
+func (tx *timeoutTx) QueryRow(query string, args ...interface{}) *timeoutRow {
+	start := time.Now()
+	row := tx.Tx.QueryRow(query, args...)
+	observeQuery(query, start, row.Err())
+	return &timeoutRow{Row: row, cancel: func() {}}
+}
+
+// Commit commits the transaction, and releases its deadline.
+func (tx *timeoutTx) Commit() error {
+	defer tx.cancel()
+	start := time.Now()
+	err := tx.Tx.Commit()
+	observeQuery("COMMIT", start, err)
+	return err
+}
+
+// Rollback rolls the transaction back, and releases its deadline. Rolling
+// back a transaction that's already been committed isn't recorded, since
+// transactions are rolled back in case they fail before committing.
+func (tx *timeoutTx) Rollback() error {
+	defer tx.cancel()
+	start := time.Now()
+	err := tx.Tx.Rollback()
+	if err != sql.ErrTxDone {
+		observeQuery("ROLLBACK", start, err)
+	}
+	return err
+}
@@ -2,12 +2,17 @@ package db
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/EFForg/starttls-backend/checker"
 	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/policy"
+	"github.com/EFForg/starttls-backend/report"
 	"github.com/EFForg/starttls-backend/stats"
+	"github.com/EFForg/starttls-backend/validator"
 )
 
 // Database interface: These are the things that the Database should be able to do.
@@ -17,22 +22,41 @@ type Database interface {
 	PutScan(models.Scan) error
 	// Retrieves most recent scandata for domain
 	GetLatestScan(string) (models.Scan, error)
+	// Retrieves the most recent scan of each of several domains, by domain.
+	GetLatestScansOf([]string) (map[string]models.Scan, error)
 	// Retrieves all scandata for domain
 	GetAllScans(string) ([]models.Scan, error)
+	// Retrieves up to n of the most recent scans for domain, most recent first.
+	GetLatestScans(string, int) ([]models.Scan, error)
+	// Retrieves the most recent full scan of domain from a trusted source.
+	GetLatestTrustedScan(string) (models.Scan, error)
+	// Retrieves up to n of the most recent full scans of domain from trusted
+	// sources, most recent first.
+	GetLatestTrustedScans(string, int) ([]models.Scan, error)
+	// Retrieves the most recent scan of domain performed at or before a time.
+	GetScanAt(string, time.Time) (models.Scan, error)
+	// Retrieves the most recent scan that checked an MX hostname.
+	GetLatestScanWithHostname(string) (models.Scan, error)
 	// Gets the token for a domain
 	GetTokenByDomain(string) (string, error)
 	// Creates a token in the db
 	PutToken(string) (models.Token, error)
 	// Uses a token in the db
 	UseToken(string) (string, error)
-	// Adds a bounce or complaint notification to the email blacklist.
+	// Generates a new validation token for a domain to resend, unless it was
+	// resent limit times in the last period, in which case it returns when it
+	// can next be resent. The submission's token works until it expires.
+	ResendToken(domain string, period time.Duration, limit int) (models.Token, time.Time, error)
+	// Adds a bounce, complaint or unsubscribe to the email blacklist.
 	PutBlacklistedEmail(email string, reason string, timestamp string) error
-	// Returns true if we've blacklisted an email.
+	// Returns true if we've blacklisted an email, ignoring case.
 	IsBlacklistedEmail(string) (bool, error)
 	// Retrieves a hostname scan for a particular hostname
 	GetHostnameScan(string) (checker.HostnameResult, error)
 	// Enters a hostname scan.
 	PutHostnameScan(string, checker.HostnameResult) error
+	// Retrieves the hostname scans made since a given time, oldest first.
+	GetHostnameScansSince(time.Time) ([]checker.HostnameResult, error)
 	// Writes an aggregated scan to the database
 	PutAggregatedScan(checker.AggregatedScan) error
 	// Caches stats for the 14 days preceding time.Time
@@ -45,8 +69,124 @@ type Database interface {
 	GetDomain(string, models.DomainState) (models.Domain, error)
 	// Retrieves all domains in a particular state.
 	GetDomains(models.DomainState) ([]models.Domain, error)
-	SetStatus(string, models.DomainState) error
-	RemoveDomain(string, models.DomainState) (models.Domain, error)
+	// Retrieves a page of the domains in a particular state.
+	GetDomainPage(models.DomainState, models.DomainPage) (models.DomainList, error)
+	// Retrieves the domains whose names match a search, sorted by name, in
+	// any of the given states, or in any state if none are given.
+	SearchDomains(models.DomainSearch, ...models.DomainState) ([]models.Domain, error)
+	// Moves a domain to a new state, recording the change in the audit log.
+	SetStatus(string, models.DomainState, models.StateChange) error
+	// Inserts new domains straight into their states, recording each in the
+	// audit log. If any can't be inserted, none are.
+	ImportDomains([]models.Domain, models.StateChange) error
+	// Updates a domain's policy fields, unless it's changed since it was last
+	// updated.
+	UpdateDomain(models.Domain, time.Time) (models.Domain, error)
+	// Records the MTA-STS policy mode of a queued or listed MTA-STS domain.
+	SetMTASTSMode(domain string, mode string) (bool, error)
+	// Removes a domain in a particular state, recording the removal in the
+	// audit log.
+	RemoveDomain(string, models.DomainState, models.StateChange) (models.Domain, error)
+	// Creates a token for verifying an email address before issuing API keys.
+	PutAPIKeyToken(string) (string, error)
+	// Uses an API key email verification token, returning the email address.
+	UseAPIKeyToken(string) (string, error)
+	// Creates a token for verifying an email address before exporting the
+	// data stored about it.
+	PutDataExportToken(string) (string, error)
+	// Uses a data export verification token, returning the email address.
+	UseDataExportToken(string) (string, error)
+	// Retrieves everything stored about a contact email address.
+	GetDataExport(string) (models.DataExport, error)
+	// Creates a token for verifying an email address before erasing the
+	// data stored about it.
+	PutDataErasureToken(string) (string, error)
+	// Uses a data erasure verification token, returning the email address.
+	UseDataErasureToken(string) (string, error)
+	// Erases a contact email address, removing its unconfirmed submissions
+	// and anonymizing its confirmed ones, on behalf of an actor.
+	EraseEmail(string, string) (models.Erasure, error)
+	// Creates a token for verifying that a domain's owner wants it removed
+	// from the list.
+	PutRemovalToken(string) (string, error)
+	// Uses a removal verification token, returning the domain.
+	UseRemovalToken(string) (string, error)
+	// Stores a new API key under its hash.
+	PutAPIKey(models.APIKey, string) (models.APIKey, error)
+	// Retrieves the API keys owned by an email address.
+	GetAPIKeys(string) ([]models.APIKey, error)
+	// Retrieves an unrevoked API key by its hash, and records its use.
+	UseAPIKey(string) (models.APIKey, error)
+	// Replaces an owner's API key hash.
+	RotateAPIKey(int64, string, string) (models.APIKey, error)
+	// Revokes an owner's API key.
+	RevokeAPIKey(int64, string) (models.APIKey, error)
+	// Records a scan against an API key's daily quota, returning the scans
+	// made with it today.
+	UseAPIKeyScan(models.APIKey) (int64, error)
+	// Retrieves an API key's daily usage since a given time.
+	GetAPIKeyUsage(int64, time.Time) ([]models.APIKeyUsage, error)
+	// Totals the use of every API key since a given time.
+	GetAPIUsage(time.Time) (models.APIUsage, error)
+	// Sets an API key's daily scan quota.
+	SetAPIKeyQuota(int64, int64) (models.APIKey, error)
+	// Stores the evidence for a domain's promotion attempt.
+	PutPromotion(models.Promotion) (models.Promotion, error)
+	// Retrieves a domain's promotion attempts, most recent first.
+	GetPromotions(string) ([]models.Promotion, error)
+	// Holds a domain's submission for review, replacing any earlier decision.
+	PutModeration(models.Moderation) error
+	// Retrieves the submissions awaiting review, oldest first.
+	GetPendingModerations() ([]models.Moderation, error)
+	// Records a reviewer's decision on a pending submission.
+	DecideModeration(domain string, decision string, reviewer string, note string) (models.Moderation, error)
+	// Blocks queue submissions matching a domain or email pattern, replacing
+	// the reason for an existing block.
+	PutSubmissionBlock(models.SubmissionBlock) (models.SubmissionBlock, error)
+	// Retrieves every submission block, oldest first.
+	GetSubmissionBlocks() ([]models.SubmissionBlock, error)
+	// Removes a submission block by its ID.
+	RemoveSubmissionBlock(int64) (models.SubmissionBlock, error)
+	// Registers a webhook to notify of a domain's state changes, which
+	// SetStatus queues deliveries to.
+	PutWebhook(models.Webhook) (models.Webhook, error)
+	// Retrieves a webhook by its ID, including its secret.
+	GetWebhook(int64) (models.Webhook, error)
+	// Retrieves the webhooks registered for a domain, without their secrets.
+	GetWebhooks(string) ([]models.Webhook, error)
+	// Removes a webhook and its deliveries by its ID.
+	RemoveWebhook(int64) (models.Webhook, error)
+	// Retrieves up to n of a webhook's deliveries, most recent first.
+	GetWebhookDeliveries(int64, int) ([]models.WebhookDelivery, error)
+	// Retrieves up to n pending webhook deliveries due by a given time,
+	// oldest first.
+	GetDueWebhookDeliveries(time.Time, int) ([]models.WebhookDelivery, error)
+	// Records the outcome of an attempt to deliver a webhook delivery.
+	UpdateWebhookDelivery(models.WebhookDelivery) error
+	// Appends an entry to the audit log.
+	PutAuditEntry(models.AuditEntry) (models.AuditEntry, error)
+	// Retrieves the audit log entries about a subject, most recent first.
+	GetAuditLog(string) ([]models.AuditEntry, error)
+	// Retrieves the audit log entries since a given time, oldest first.
+	GetAuditLogSince(time.Time) ([]models.AuditEntry, error)
+	// Takes or renews a named lease for a holder, unless another holder's
+	// lease hasn't expired.
+	AcquireLease(name string, holder string, ttl time.Duration) (bool, error)
+	// Stores a new version of the published policy list.
+	PutPublication(policy.Publication) error
+	// Retrieves the latest version of the published policy list.
+	GetPublication() (policy.Publication, error)
+	// Counts the scans performed since a given time, by source and status.
+	GetScanCounts(time.Time) ([]models.ScanCount, error)
+	// Stores an operations report.
+	PutReport(report.Report) (report.Report, error)
+	// Retrieves the most recent operations report of a period, or of any
+	// period if it's empty.
+	GetLatestReport(report.Period) (report.Report, error)
+	// Stores the summary of a validator's latest run.
+	PutValidatorRun(string, validator.RunSummary) error
+	// Retrieves the summary of a validator's latest run.
+	GetValidatorRun(string) (validator.RunSummary, error)
 	ClearTables() error
 }
 
@@ -60,6 +200,17 @@ type Config struct {
 	DbTokenTable  string
 	DbScanTable   string
 	DbDomainTable string
+	// MaxOpenConns limits the connections open to the database. Zero means
+	// no limit.
+	MaxOpenConns int
+	// MaxIdleConns is the most connections kept open while they're idle.
+	MaxIdleConns int
+	// ConnMaxLifetime is how long a connection is reused for before it's
+	// closed. Zero means forever.
+	ConnMaxLifetime time.Duration
+	// QueryTimeout bounds each query, including waiting for a connection and
+	// reading its rows, and each transaction. Zero means no timeout.
+	QueryTimeout time.Duration
 }
 
 // Default configuration values. Can be overwritten by env vars of the same name.
@@ -73,6 +224,11 @@ var configDefaults = map[string]string{
 	"DB_TOKEN_TABLE":  "tokens",
 	"DB_DOMAIN_TABLE": "domains",
 	"DB_SCAN_TABLE":   "scans",
+
+	"DB_MAX_OPEN_CONNS":    "25",
+	"DB_MAX_IDLE_CONNS":    "5",
+	"DB_CONN_MAX_LIFETIME": "30m",
+	"DB_QUERY_TIMEOUT":     "30s",
 }
 
 func getEnvOrDefault(varName string) string {
@@ -96,9 +252,38 @@ func LoadEnvironmentVariables() (Config, error) {
 		DbUsername:    getEnvOrDefault("DB_USERNAME"),
 		DbPass:        getEnvOrDefault("DB_PASSWORD"),
 	}
+	var err error
+	if config.MaxOpenConns, err = envCount("DB_MAX_OPEN_CONNS"); err != nil {
+		return config, err
+	}
+	if config.MaxIdleConns, err = envCount("DB_MAX_IDLE_CONNS"); err != nil {
+		return config, err
+	}
+	if config.ConnMaxLifetime, err = envDuration("DB_CONN_MAX_LIFETIME"); err != nil {
+		return config, err
+	}
+	if config.QueryTimeout, err = envDuration("DB_QUERY_TIMEOUT"); err != nil {
+		return config, err
+	}
 	if flag.Lookup("test.v") != nil {
 		// Avoid accidentally wiping the default db during tests.
 		config.DbName = getEnvOrDefault("TEST_DB_NAME")
 	}
 	return config, nil
 }
+
+func envCount(varName string) (int, error) {
+	n, err := strconv.Atoi(getEnvOrDefault(varName))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", varName)
+	}
+	return n, nil
+}
+
+func envDuration(varName string) (time.Duration, error) {
+	d, err := time.ParseDuration(getEnvOrDefault(varName))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative duration like 30s", varName)
+	}
+	return d, nil
+}
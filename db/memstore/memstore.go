@@ -0,0 +1,1826 @@
+// Package memstore provides an in-memory implementation of db.Database, for
+// tests and for embedding the API without a Postgres database.
+package memstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/db"
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/policy"
+	"github.com/EFForg/starttls-backend/report"
+	"github.com/EFForg/starttls-backend/stats"
+	"github.com/EFForg/starttls-backend/validator"
+)
+
+// Store is a db.Database kept in memory. It behaves like db.SQLDatabase:
+// lookups that find nothing return sql.ErrNoRows, and timestamps are stored
+// to the second, in UTC. Stored values are copied, so changing them after
+// they're stored or retrieved doesn't change the store. It's safe for
+// concurrent use. Nothing is persisted.
+type Store struct {
+	mu sync.Mutex
+
+	domains      map[domainKey]models.Domain
+	tokens       map[string]models.Token
+	resends      map[string]resendCount  // Validation token resends, by domain.
+	submitted    map[string]models.Token // Submissions' tokens replaced by resends, by domain.
+	scans        []scanRow
+	hostScans    []hostnameScanRow
+	aggregated   []checker.AggregatedScan
+	blacklist    map[string][]models.EmailBounce
+	apiKeyTokens map[string]emailToken
+	exportTokens map[string]emailToken
+	eraseTokens  map[string]emailToken
+	removals     map[string]models.Token // Removal tokens, by domain.
+	apiKeys      []apiKeyRow
+	apiKeyUsage  map[usageKey]*models.APIKeyUsage
+	promotions   []promotionRow
+	moderation   map[string]models.Moderation
+	blocks       []models.SubmissionBlock
+	webhooks     []models.Webhook
+	deliveries   []models.WebhookDelivery
+	audit        []models.AuditEntry
+	leases       map[string]lease
+	publications []policy.Publication
+	reports      []reportRow
+	runs         map[string]validator.RunSummary
+
+	lastID int64
+}
+
+var _ db.Database = (*Store)(nil)
+
+type domainKey struct {
+	name  string
+	state models.DomainState
+}
+
+type scanRow struct {
+	id              int64
+	scan            models.Scan
+	data            []byte
+	hostnameResults []byte
+	mtastsMode      string
+}
+
+type hostnameScanRow struct {
+	id        int64
+	hostname  string
+	timestamp time.Time
+	status    checker.Status
+	checks    []byte
+}
+
+type resendCount struct {
+	count int
+	since time.Time
+}
+
+type emailToken struct {
+	email   string
+	token   string
+	expires time.Time
+	used    bool
+}
+
+type apiKeyRow struct {
+	key  models.APIKey
+	hash string
+}
+
+type usageKey struct {
+	id  int64
+	day string
+}
+
+type promotionRow struct {
+	id       int64
+	domain   string
+	time     time.Time
+	evidence []byte
+}
+
+type lease struct {
+	holder  string
+	expires time.Time
+}
+
+type reportRow struct {
+	id     int64
+	period report.Period
+	end    time.Time
+	data   []byte
+}
+
+// New returns an empty Store.
+func New() *Store {
+	s := &Store{}
+	s.clear()
+	return s
+}
+
+func (s *Store) clear() {
+	s.domains = make(map[domainKey]models.Domain)
+	s.tokens = make(map[string]models.Token)
+	s.resends = make(map[string]resendCount)
+	s.submitted = make(map[string]models.Token)
+	s.scans = nil
+	s.hostScans = nil
+	s.aggregated = nil
+	s.blacklist = make(map[string][]models.EmailBounce)
+	s.apiKeyTokens = make(map[string]emailToken)
+	s.exportTokens = make(map[string]emailToken)
+	s.eraseTokens = make(map[string]emailToken)
+	s.removals = make(map[string]models.Token)
+	s.apiKeys = nil
+	s.apiKeyUsage = make(map[usageKey]*models.APIKeyUsage)
+	s.promotions = nil
+	s.moderation = make(map[string]models.Moderation)
+	s.blocks = nil
+	s.webhooks = nil
+	s.deliveries = nil
+	s.audit = nil
+	s.leases = make(map[string]lease)
+	s.publications = nil
+	s.reports = nil
+	s.runs = make(map[string]validator.RunSummary)
+}
+
+// nextID returns a new row ID. IDs are unique across the store, so they
+// increase within each table like a Postgres serial column.
+func (s *Store) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+// sqlTime rounds t the way db.SQLDatabase stores timestamps.
+func sqlTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+func day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func randToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// TOKENS
+
+// PutToken generates a validation token for a domain, replacing any earlier
+// one.
+func (s *Store) PutToken(domain string) (models.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := models.Token{
+		Domain:  domain,
+		Token:   randToken(),
+		Expires: time.Now().Add(72 * time.Hour),
+	}
+	s.tokens[domain] = token
+	delete(s.submitted, domain)
+	return token, nil
+}
+
+// UseToken marks an unused validation token as used, and returns its domain.
+// The token from the domain's submission can be used until it expires, even
+// after resends have replaced it.
+func (s *Store) UseToken(tokenStr string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for domain, token := range s.tokens {
+		submitted, ok := s.submitted[domain]
+		fromSubmission := ok && submitted.Token == tokenStr && submitted.Expires.After(time.Now())
+		if (token.Token == tokenStr || fromSubmission) && !token.Used {
+			token.Used = true
+			s.tokens[domain] = token
+			return domain, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+// ResendToken generates a new validation token for a domain to resend,
+// unless its token was already resent limit times in the last period, in
+// which case it returns when it can next be resent. The token from the
+// domain's submission keeps working until it expires.
+func (s *Store) ResendToken(domain string, period time.Duration, limit int) (models.Token, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	resends := s.resends[domain]
+	if resends.since.IsZero() || now.Sub(resends.since) >= period {
+		resends = resendCount{since: sqlTime(now)}
+	}
+	if resends.count >= limit {
+		return models.Token{}, resends.since.Add(period), nil
+	}
+	if submitted, ok := s.submitted[domain]; !ok || !submitted.Expires.After(now) {
+		delete(s.submitted, domain)
+		if current, ok := s.tokens[domain]; ok && !current.Used {
+			s.submitted[domain] = current
+		}
+	}
+	token := models.Token{Domain: domain, Token: randToken(), Expires: now.Add(72 * time.Hour)}
+	s.tokens[domain] = token
+	resends.count++
+	s.resends[domain] = resends
+	return token, time.Time{}, nil
+}
+
+// GetTokenByDomain gets the validation token for a domain.
+func (s *Store) GetTokenByDomain(domain string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[domain]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return token.Token, nil
+}
+
+// SCANS
+
+func newScanRow(id int64, scan models.Scan) (scanRow, error) {
+	data, err := json.Marshal(scan.Data)
+	if err != nil {
+		return scanRow{}, err
+	}
+	hostnameResults, err := checker.EncodeHostnameResults(scan.Data.HostnameResults)
+	if err != nil {
+		return scanRow{}, err
+	}
+	mtastsMode := ""
+	if scan.Data.MTASTSResult != nil {
+		mtastsMode = scan.Data.MTASTSResult.Mode
+	}
+	scan.Data = checker.DomainResult{}
+	scan.Timestamp = sqlTime(scan.Timestamp)
+	return scanRow{id: id, scan: scan, data: data, hostnameResults: hostnameResults, mtastsMode: mtastsMode}, nil
+}
+
+// result decodes the stored scan.
+func (r scanRow) result() (models.Scan, error) {
+	scan := r.scan
+	if err := json.Unmarshal(r.data, &scan.Data); err != nil {
+		return scan, err
+	}
+	results, err := checker.DecodeHostnameResults(r.hostnameResults)
+	scan.Data.HostnameResults = results
+	return scan, err
+}
+
+// PutScan stores a scan of a domain.
+func (s *Store) PutScan(scan models.Scan) error {
+	return s.PutScans([]models.Scan{scan})
+}
+
+// PutScans stores many scans at once. If any of them can't be stored, none
+// are.
+func (s *Store) PutScans(scans []models.Scan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := make([]scanRow, 0, len(scans))
+	for _, scan := range scans {
+		row, err := newScanRow(0, scan)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	for _, row := range rows {
+		row.id = s.nextID()
+		s.scans = append(s.scans, row)
+	}
+	return nil
+}
+
+// CountScans returns the number of stored scans.
+func (s *Store) CountScans() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.scans), nil
+}
+
+// GetScanBatch retrieves up to limit stored scans with IDs greater than
+// afterID, in order of ID, with their IDs.
+func (s *Store) GetScanBatch(afterID int64, limit int) ([]int64, []models.Scan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []int64{}
+	scans := []models.Scan{}
+	for _, row := range s.scans {
+		if len(scans) == limit {
+			break
+		}
+		if row.id <= afterID {
+			continue
+		}
+		scan, err := row.result()
+		if err != nil {
+			return nil, nil, fmt.Errorf("scan %d: %v", row.id, err)
+		}
+		ids = append(ids, row.id)
+		scans = append(scans, scan)
+	}
+	return ids, scans, nil
+}
+
+// UpdateScan rewrites the data of the stored scan with ID id.
+func (s *Store) UpdateScan(id int64, scan models.Scan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, row := range s.scans {
+		if row.id != id {
+			continue
+		}
+		updated, err := newScanRow(id, scan)
+		if err != nil {
+			return err
+		}
+		row.data, row.hostnameResults, row.mtastsMode = updated.data, updated.hostnameResults, updated.mtastsMode
+		s.scans[i] = row
+	}
+	return nil
+}
+
+// UpdateScans rewrites the data of many stored scans at once. ids holds the
+// ID of each scan.
+func (s *Store) UpdateScans(ids []int64, scans []models.Scan) error {
+	if len(ids) != len(scans) {
+		return fmt.Errorf("got %d IDs for %d scans", len(ids), len(scans))
+	}
+	for i, scan := range scans {
+		if err := s.UpdateScan(ids[i], scan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latestScans returns the stored scans matching include, most recent first.
+func (s *Store) latestScans(include func(scanRow) bool) []scanRow {
+	rows := []scanRow{}
+	for _, row := range s.scans {
+		if include(row) {
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].scan.Timestamp.After(rows[j].scan.Timestamp)
+	})
+	return rows
+}
+
+func (s *Store) latestScan(include func(scanRow) bool) (models.Scan, error) {
+	rows := s.latestScans(include)
+	if len(rows) == 0 {
+		return models.Scan{}, sql.ErrNoRows
+	}
+	return rows[0].result()
+}
+
+func results(rows []scanRow) ([]models.Scan, error) {
+	scans := []models.Scan{}
+	for _, row := range rows {
+		scan, err := row.result()
+		if err != nil {
+			return nil, err
+		}
+		scans = append(scans, scan)
+	}
+	return scans, nil
+}
+
+// GetLatestScan retrieves the most recent scan of a domain.
+func (s *Store) GetLatestScan(domain string) (models.Scan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latestScan(func(row scanRow) bool { return row.scan.Domain == domain })
+}
+
+// GetLatestTrustedScan retrieves the most recent trusted scan of a domain.
+func (s *Store) GetLatestTrustedScan(domain string) (models.Scan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latestScan(func(row scanRow) bool { return row.scan.Domain == domain && row.scan.Trusted() })
+}
+
+// GetLatestScanWithHostname retrieves the most recent scan that checked a
+// particular MX hostname, of any domain. The hostname matches with or without
+// a trailing dot.
+func (s *Store) GetLatestScanWithHostname(hostname string) (models.Scan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hostname = strings.TrimSuffix(hostname, ".")
+	return s.latestScan(func(row scanRow) bool {
+		var data struct {
+			Results map[string]json.RawMessage `json:"results"`
+		}
+		if err := json.Unmarshal(row.data, &data); err != nil {
+			return false
+		}
+		_, ok := data.Results[hostname]
+		_, okFQDN := data.Results[hostname+"."]
+		return ok || okFQDN
+	})
+}
+
+// GetAllScans retrieves every scan of a domain, in the order they were
+// stored.
+func (s *Store) GetAllScans(domain string) ([]models.Scan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := []scanRow{}
+	for _, row := range s.scans {
+		if row.scan.Domain == domain {
+			rows = append(rows, row)
+		}
+	}
+	return results(rows)
+}
+
+// GetLatestScansOf retrieves the most recent scan of each of domains that has
+// been scanned, by domain.
+func (s *Store) GetLatestScansOf(domains []string) (map[string]models.Scan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scans := map[string]models.Scan{}
+	for _, domain := range domains {
+		scan, err := s.latestScan(func(row scanRow) bool { return row.scan.Domain == domain })
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scans[domain] = scan
+	}
+	return scans, nil
+}
+
+// GetLatestScans retrieves up to n of the most recent scans of a domain, most
+// recent first.
+func (s *Store) GetLatestScans(domain string, n int) ([]models.Scan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := s.latestScans(func(row scanRow) bool { return row.scan.Domain == domain })
+	if len(rows) > n {
+		rows = rows[:n]
+	}
+	return results(rows)
+}
+
+// GetLatestTrustedScans retrieves up to n of the most recent trusted scans of
+// a domain, most recent first.
+func (s *Store) GetLatestTrustedScans(domain string, n int) ([]models.Scan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := s.latestScans(func(row scanRow) bool { return row.scan.Domain == domain && row.scan.Trusted() })
+	if len(rows) > n {
+		rows = rows[:n]
+	}
+	return results(rows)
+}
+
+// GetScanAt retrieves the most recent scan of a domain at or before t.
+func (s *Store) GetScanAt(domain string, t time.Time) (models.Scan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t = sqlTime(t)
+	return s.latestScan(func(row scanRow) bool {
+		return row.scan.Domain == domain && !row.scan.Timestamp.After(t)
+	})
+}
+
+// GetScanCounts counts the scans performed since a time, by source and
+// status.
+func (s *Store) GetScanCounts(since time.Time) ([]models.ScanCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since = sqlTime(since)
+	type group struct {
+		source models.ScanSource
+		status checker.DomainStatus
+	}
+	counts := make(map[group]int)
+	for _, row := range s.scans {
+		if row.scan.Timestamp.Before(since) {
+			continue
+		}
+		var data struct {
+			Status checker.DomainStatus `json:"status"`
+		}
+		if err := json.Unmarshal(row.data, &data); err != nil {
+			return nil, err
+		}
+		counts[group{row.scan.Source, data.Status}]++
+	}
+	result := []models.ScanCount{}
+	for g, count := range counts {
+		result = append(result, models.ScanCount{Source: g.source, Status: g.status, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Source != result[j].Source {
+			return result[i].Source < result[j].Source
+		}
+		return result[i].Status < result[j].Status
+	})
+	return result, nil
+}
+
+// STATS
+
+// PutAggregatedScan stores an aggregated scan, unless one from the same
+// source and time is already stored.
+func (s *Store) PutAggregatedScan(a checker.AggregatedScan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putAggregatedScan(a)
+	return nil
+}
+
+func (s *Store) putAggregatedScan(a checker.AggregatedScan) {
+	for _, stored := range s.aggregated {
+		if stored.Source == a.Source && stored.Time.Equal(a.Time) {
+			return
+		}
+	}
+	s.aggregated = append(s.aggregated, checker.AggregatedScan{
+		Time:                    a.Time,
+		Source:                  a.Source,
+		Attempted:               a.Attempted,
+		WithMXs:                 a.WithMXs,
+		MTASTSTesting:           a.MTASTSTesting,
+		MTASTSEnforce:           a.MTASTSEnforce,
+		Software:                a.Software,
+		SoftwareWithoutSTARTTLS: a.SoftwareWithoutSTARTTLS,
+		ExtensionSets:           a.ExtensionSets,
+		TLSFingerprints:         a.TLSFingerprints,
+	})
+}
+
+// PutLocalStats aggregates the most recent scan of each domain scanned in
+// the 14 days preceding date, and stores the result.
+func (s *Store) PutLocalStats(date time.Time) (checker.AggregatedScan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := date.Add(-14 * 24 * time.Hour)
+	a := checker.AggregatedScan{
+		Source: checker.LocalSource,
+		Time:   date,
+	}
+	latest := make(map[string]scanRow)
+	for _, row := range s.latestScans(func(row scanRow) bool {
+		return !row.scan.Timestamp.Before(start) && !row.scan.Timestamp.After(date)
+	}) {
+		if _, ok := latest[row.scan.Domain]; !ok {
+			latest[row.scan.Domain] = row
+		}
+	}
+	for _, row := range latest {
+		a.WithMXs++
+		switch row.mtastsMode {
+		case "testing":
+			a.MTASTSTesting++
+		case "enforce":
+			a.MTASTSEnforce++
+		}
+	}
+	s.putAggregatedScan(a)
+	return a, nil
+}
+
+// GetStats retrieves the aggregated scans from a source, oldest first.
+func (s *Store) GetStats(source string) (stats.Series, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	series := stats.Series{}
+	for _, a := range s.aggregated {
+		if a.Source == source {
+			series = append(series, a)
+		}
+	}
+	sort.SliceStable(series, func(i, j int) bool { return series[i].Time.Before(series[j].Time) })
+	return series, nil
+}
+
+// HOSTNAME SCANS
+
+// PutHostnameScan stores the status and checks of a hostname scan, made now.
+func (s *Store) PutHostnameScan(hostname string, result checker.HostnameResult) error {
+	checks, err := json.Marshal(result.Checks)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hostScans = append(s.hostScans, hostnameScanRow{
+		id:        s.nextID(),
+		hostname:  hostname,
+		timestamp: time.Now().UTC(),
+		status:    result.Status,
+		checks:    checks,
+	})
+	return nil
+}
+
+func (r hostnameScanRow) result() (checker.HostnameResult, error) {
+	result := checker.HostnameResult{
+		Hostname:  r.hostname,
+		Result:    &checker.Result{Status: r.status},
+		Timestamp: r.timestamp,
+	}
+	err := json.Unmarshal(r.checks, &result.Checks)
+	return result, err
+}
+
+// GetHostnameScan retrieves the most recent scan of a hostname.
+func (s *Store) GetHostnameScan(hostname string) (checker.HostnameResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest *hostnameScanRow
+	for i, row := range s.hostScans {
+		if row.hostname == hostname && (latest == nil || !row.timestamp.Before(latest.timestamp)) {
+			latest = &s.hostScans[i]
+		}
+	}
+	if latest == nil {
+		return checker.HostnameResult{Hostname: hostname, Result: &checker.Result{}}, sql.ErrNoRows
+	}
+	return latest.result()
+}
+
+// GetHostnameScansSince retrieves the hostname scans made after a time,
+// oldest first.
+func (s *Store) GetHostnameScansSince(since time.Time) ([]checker.HostnameResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since = sqlTime(since)
+	results := []checker.HostnameResult{}
+	for _, row := range s.hostScans {
+		if !row.timestamp.After(since) {
+			continue
+		}
+		result, err := row.result()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// DOMAINS
+
+func copyDomain(d models.Domain) models.Domain {
+	d.MXs = append([]string{}, d.MXs...)
+	return d
+}
+
+// PutDomain stores a domain as StateUnconfirmed. If the domain is already
+// unconfirmed, its fields are updated.
+func (s *Store) PutDomain(domain models.Domain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := domainKey{domain.Name, models.StateUnconfirmed}
+	stored, ok := s.domains[key]
+	if !ok {
+		s.domains[key] = models.Domain{
+			Name:        domain.Name,
+			Email:       domain.Email,
+			MXs:         append([]string{}, domain.MXs...),
+			State:       models.StateUnconfirmed,
+			LastUpdated: time.Now(),
+			QueueWeeks:  domain.QueueWeeks,
+			MTASTS:      domain.MTASTS,
+			MTASTSMode:  domain.MTASTSMode,
+		}
+		return nil
+	}
+	updated := copyDomain(stored)
+	updated.Email = domain.Email
+	updated.MXs = append([]string{}, domain.MXs...)
+	updated.QueueWeeks = domain.QueueWeeks
+	updated.MTASTSMode = domain.MTASTSMode
+	s.updateDomain(stored, updated)
+	return nil
+}
+
+// updateDomain replaces the stored domain old, setting its last update time
+// if it changed.
+func (s *Store) updateDomain(old models.Domain, updated models.Domain) models.Domain {
+	delete(s.domains, domainKey{old.Name, old.State})
+	oldJSON, _ := json.Marshal(old)
+	updatedJSON, _ := json.Marshal(updated)
+	if string(oldJSON) != string(updatedJSON) {
+		updated.LastUpdated = time.Now()
+	}
+	s.domains[domainKey{updated.Name, updated.State}] = updated
+	return copyDomain(updated)
+}
+
+// GetDomain retrieves a domain in a particular state.
+func (s *Store) GetDomain(domain string, state models.DomainState) (models.Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.domains[domainKey{domain, state}]
+	if !ok {
+		return models.Domain{}, sql.ErrNoRows
+	}
+	return copyDomain(stored), nil
+}
+
+func (s *Store) domainsWhere(include func(models.Domain) bool) []models.Domain {
+	domains := []models.Domain{}
+	for _, d := range s.domains {
+		if include(d) {
+			domains = append(domains, copyDomain(d))
+		}
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].Name != domains[j].Name {
+			return domains[i].Name < domains[j].Name
+		}
+		return domains[i].State < domains[j].State
+	})
+	return domains
+}
+
+// GetDomains retrieves the domains in a particular state, in order of name.
+func (s *Store) GetDomains(state models.DomainState) ([]models.Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.domainsWhere(func(d models.Domain) bool { return d.State == state }), nil
+}
+
+// GetDomainPage retrieves a page of the domains in a particular state.
+func (s *Store) GetDomainPage(state models.DomainState, page models.DomainPage) (models.DomainList, error) {
+	list := models.DomainList{Domains: []models.Domain{}}
+	lastUpdated, name, err := page.Cursor()
+	if err != nil {
+		return list, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byLastUpdated := page.Sort == models.SortByLastUpdated
+	domains := s.domainsWhere(func(d models.Domain) bool {
+		if d.State != state {
+			return false
+		}
+		if page.After == "" {
+			return true
+		}
+		if byLastUpdated {
+			updated := d.LastUpdated.UTC()
+			return updated.Before(lastUpdated) || (updated.Equal(lastUpdated) && d.Name < name)
+		}
+		return d.Name > name
+	})
+	if byLastUpdated {
+		sort.SliceStable(domains, func(i, j int) bool {
+			if !domains[i].LastUpdated.Equal(domains[j].LastUpdated) {
+				return domains[i].LastUpdated.After(domains[j].LastUpdated)
+			}
+			return domains[i].Name > domains[j].Name
+		})
+	}
+	if page.Limit > 0 && len(domains) > page.Limit {
+		domains = domains[:page.Limit]
+		list.Next = page.NextCursor(domains[page.Limit-1])
+	}
+	list.Domains = domains
+	return list, nil
+}
+
+// SearchDomains retrieves the domains whose names match search, sorted by
+// name, in any of states, or in any state if none are given.
+func (s *Store) SearchDomains(search models.DomainSearch, states ...models.DomainState) ([]models.Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	domains := s.domainsWhere(func(d models.Domain) bool {
+		if !search.Matches(d.Name) {
+			return false
+		}
+		if len(states) == 0 {
+			return true
+		}
+		for _, state := range states {
+			if d.State == state {
+				return true
+			}
+		}
+		return false
+	})
+	if search.Limit > 0 && len(domains) > search.Limit {
+		domains = domains[:search.Limit]
+	}
+	return domains, nil
+}
+
+// GetMTASTSDomains retrieves the domains which wish their policy to be
+// queued with their MTA-STS policy.
+func (s *Store) GetMTASTSDomains() ([]models.Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.domainsWhere(func(d models.Domain) bool { return d.MTASTS }), nil
+}
+
+// SetStatus moves every entry for a domain to state, and records the change
+// in the audit log. Like the domains table's primary key, this fails if it
+// would leave two entries for the domain in the same state.
+func (s *Store) SetStatus(domain string, state models.DomainState, change models.StateChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var testingStart, removalRequested time.Time
+	switch state {
+	case models.StateTesting:
+		testingStart = time.Now()
+	case models.StatePendingRemoval:
+		removalRequested = time.Now()
+	}
+	var matching []models.Domain
+	for _, d := range s.domains {
+		if d.Name == domain {
+			matching = append(matching, d)
+		}
+	}
+	if len(matching) > 1 {
+		return fmt.Errorf("domain %s has %d entries, which can't all be %s", domain, len(matching), state)
+	}
+	for _, d := range matching {
+		updated := copyDomain(d)
+		updated.State = state
+		updated.TestingStart = testingStart
+		updated.RemovalRequested = removalRequested
+		s.updateDomain(d, updated)
+		if d.State != state {
+			s.putAuditEntry(change.AuditEntry(domain, d.State, state))
+			s.queueWebhookDeliveries(domain, d.State, state, change)
+		}
+	}
+	return nil
+}
+
+// ImportDomains inserts new domains straight into their states, and records
+// each in the audit log as moving from unconfirmed. If any of them is
+// already stored in its state, none are inserted.
+func (s *Store) ImportDomains(domains []models.Domain, change models.StateChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, domain := range domains {
+		if _, ok := s.domains[domainKey{domain.Name, domain.State}]; ok {
+			return fmt.Errorf("domain %s is already %s", domain.Name, domain.State)
+		}
+	}
+	for _, domain := range domains {
+		imported := copyDomain(domain)
+		imported.LastUpdated = time.Now()
+		if imported.State == models.StateTesting {
+			imported.TestingStart = imported.LastUpdated
+		}
+		s.domains[domainKey{domain.Name, domain.State}] = imported
+		s.putAuditEntry(change.AuditEntry(domain.Name, models.StateUnconfirmed, domain.State))
+	}
+	return nil
+}
+
+// UpdateDomain sets the MXs, queue weeks and MTA-STS setting of the domain
+// in domain.State, if it hasn't been updated since lastUpdated. Returns the
+// updated domain, or sql.ErrNoRows if it's been updated or removed since.
+func (s *Store) UpdateDomain(domain models.Domain, lastUpdated time.Time) (models.Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.domains[domainKey{domain.Name, domain.State}]
+	if !ok || !stored.LastUpdated.Equal(lastUpdated) {
+		return models.Domain{}, sql.ErrNoRows
+	}
+	updated := copyDomain(stored)
+	updated.MXs = append([]string{}, domain.MXs...)
+	updated.QueueWeeks = domain.QueueWeeks
+	updated.MTASTS = domain.MTASTS
+	return s.updateDomain(stored, updated), nil
+}
+
+// SetMTASTSMode records the mode of the MTA-STS policy of a domain that's
+// queued or on the list via MTA-STS. Returns true if the mode changed.
+func (s *Store) SetMTASTSMode(domain string, mode string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, state := range []models.DomainState{models.StateTesting, models.StateEnforce} {
+		stored, ok := s.domains[domainKey{domain, state}]
+		if !ok || !stored.MTASTS || stored.MTASTSMode == mode {
+			continue
+		}
+		updated := copyDomain(stored)
+		updated.MTASTSMode = mode
+		s.updateDomain(stored, updated)
+		changed = true
+	}
+	return changed, nil
+}
+
+// RemoveDomain removes a domain in a particular state, and returns it. Its
+// removal is recorded in the audit log and delivered to its webhooks.
+func (s *Store) RemoveDomain(domain string, state models.DomainState, change models.StateChange) (models.Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := domainKey{domain, state}
+	stored, ok := s.domains[key]
+	if !ok {
+		return models.Domain{}, sql.ErrNoRows
+	}
+	delete(s.domains, key)
+	s.putAuditEntry(change.AuditEntry(domain, state, ""))
+	s.queueWebhookDeliveries(domain, state, "", change)
+	return stored, nil
+}
+
+// DomainsToValidate [interface Validator] retrieves the names of the
+// domains whose policies should be validated.
+func (s *Store) DomainsToValidate() ([]string, error) {
+	domains, _ := s.GetDomains(models.StateTesting)
+	names := []string{}
+	for _, d := range domains {
+		names = append(names, d.Name)
+	}
+	return names, nil
+}
+
+// HostnamesForDomain [interface Validator] retrieves the hostname policy for
+// a particular domain.
+func (s *Store) HostnamesForDomain(domain string) ([]string, error) {
+	d, err := s.GetDomain(domain, models.StateEnforce)
+	if err != nil {
+		d, err = s.GetDomain(domain, models.StateTesting)
+	}
+	if err != nil {
+		return []string{}, err
+	}
+	return d.MXs, nil
+}
+
+// EMAIL BLACKLIST
+
+// PutBlacklistedEmail adds a bounce, complaint or unsubscribe to the email
+// blacklist.
+func (s *Store) PutBlacklistedEmail(email string, reason string, timestamp string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Like a TIMESTAMP column, this takes RFC 3339 times. Unlike one, it
+	// leaves invalid times unset instead of refusing them.
+	at, _ := time.Parse(time.RFC3339, timestamp)
+	s.blacklist[email] = append(s.blacklist[email], models.EmailBounce{Reason: reason, Timestamp: sqlTime(at)})
+	return nil
+}
+
+// IsBlacklistedEmail returns true iff we've blacklisted the email address,
+// ignoring case.
+func (s *Store) IsBlacklistedEmail(email string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for address := range s.blacklist {
+		if strings.EqualFold(address, email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// API KEYS
+
+// PutAPIKeyToken generates a token for verifying an email address before
+// issuing it an API key, replacing any earlier one.
+func (s *Store) PutAPIKeyToken(email string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := emailToken{email: email, token: randToken(), expires: sqlTime(time.Now().Add(72 * time.Hour))}
+	s.apiKeyTokens[email] = token
+	return token.token, nil
+}
+
+// UseAPIKeyToken marks an unexpired email verification token as used, and
+// returns the email address it was generated for.
+func (s *Store) UseAPIKeyToken(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for email, t := range s.apiKeyTokens {
+		if t.token == token && !t.used && t.expires.After(now) {
+			t.used = true
+			s.apiKeyTokens[email] = t
+			return email, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+// PutDataExportToken generates a token for verifying an email address
+// before exporting the data stored about it, replacing any earlier one.
+func (s *Store) PutDataExportToken(email string) (string, error) {
+	return s.putEmailToken(models.PurposeDataExport, email)
+}
+
+// UseDataExportToken marks an unexpired data export verification token as
+// used, and returns the email address it was generated for.
+func (s *Store) UseDataExportToken(token string) (string, error) {
+	return s.useEmailToken(models.PurposeDataExport, token)
+}
+
+// PutDataErasureToken generates a token for verifying an email address
+// before erasing the data stored about it, replacing any earlier one.
+func (s *Store) PutDataErasureToken(email string) (string, error) {
+	return s.putEmailToken(models.PurposeDataErasure, email)
+}
+
+// UseDataErasureToken marks an unexpired data erasure verification token as
+// used, and returns the email address it was generated for.
+func (s *Store) UseDataErasureToken(token string) (string, error) {
+	return s.useEmailToken(models.PurposeDataErasure, token)
+}
+
+// emailTokens returns the tokens sent to verify email addresses for purpose.
+// The caller must hold s.mu.
+func (s *Store) emailTokens(purpose string) map[string]emailToken {
+	if purpose == models.PurposeDataErasure {
+		return s.eraseTokens
+	}
+	return s.exportTokens
+}
+
+// putEmailToken generates a token for verifying email for purpose,
+// replacing any earlier one.
+func (s *Store) putEmailToken(purpose string, email string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := emailToken{email: email, token: randToken(), expires: sqlTime(time.Now().Add(72 * time.Hour))}
+	s.emailTokens(purpose)[email] = token
+	return token.token, nil
+}
+
+// useEmailToken marks an unexpired token for purpose as used, and returns
+// the email address it was generated for.
+func (s *Store) useEmailToken(purpose string, token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := s.emailTokens(purpose)
+	now := time.Now()
+	for email, t := range tokens {
+		if t.token == token && !t.used && t.expires.After(now) {
+			t.used = true
+			tokens[email] = t
+			return email, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+// EraseEmail erases a contact email address on behalf of actor. Domains
+// submitted with it as their contact are removed, along with their
+// validation tokens and moderation, unless they were confirmed, in which case
+// their contact is cleared. The address's API keys are revoked and disowned,
+// and its verification tokens deleted, and it's redacted from the audit log.
+// The bounces and complaints received for it are kept, so that it's never
+// emailed again. Each removal, and the erasure, is recorded in the audit log.
+func (s *Store) EraseEmail(email string, actor string) (models.Erasure, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	erasure := models.Erasure{Removed: []string{}, Anonymized: []string{}}
+	submitted := s.domainsWhere(func(d models.Domain) bool {
+		return strings.EqualFold(d.Email, email)
+	})
+	change := models.StateChange{Actor: actor, Reason: "contact erased on request"}
+	for _, d := range submitted {
+		key := domainKey{d.Name, d.State}
+		if d.State.Confirmed() {
+			stored := s.domains[key]
+			stored.Email = ""
+			s.domains[key] = stored
+			erasure.Anonymized = append(erasure.Anonymized, d.Name)
+			continue
+		}
+		delete(s.domains, key)
+		delete(s.tokens, d.Name)
+		delete(s.resends, d.Name)
+		delete(s.submitted, d.Name)
+		delete(s.moderation, d.Name)
+		s.putAuditEntry(change.AuditEntry(d.Name, d.State, ""))
+		erasure.Removed = append(erasure.Removed, d.Name)
+	}
+	for i, row := range s.apiKeys {
+		if row.key.Email == email {
+			s.apiKeys[i].key.Revoked = true
+			s.apiKeys[i].key.Email = ""
+			erasure.RevokedKeys++
+		}
+	}
+	delete(s.apiKeyTokens, email)
+	delete(s.exportTokens, email)
+	delete(s.eraseTokens, email)
+	address := regexp.MustCompile("(?i)" + regexp.QuoteMeta(email))
+	for i, e := range s.audit {
+		if address.MatchString(e.Actor) || address.MatchString(e.Subject) || address.MatchString(e.Details) {
+			s.audit[i].Actor = address.ReplaceAllLiteralString(e.Actor, models.ErasedAddress)
+			s.audit[i].Subject = address.ReplaceAllLiteralString(e.Subject, models.ErasedAddress)
+			s.audit[i].Details = address.ReplaceAllLiteralString(e.Details, models.ErasedAddress)
+			erasure.RedactedAuditEntries++
+		}
+	}
+	s.putAuditEntry(erasure.AuditEntry(actor))
+	return erasure, nil
+}
+
+// PutRemovalToken generates a token for verifying that a domain's owner
+// wants it removed from the list, replacing any earlier one.
+func (s *Store) PutRemovalToken(domain string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := models.Token{Domain: domain, Token: randToken(), Expires: sqlTime(time.Now().Add(72 * time.Hour))}
+	s.removals[domain] = token
+	return token.Token, nil
+}
+
+// UseRemovalToken marks an unexpired removal verification token as used, and
+// returns the domain it was generated for.
+func (s *Store) UseRemovalToken(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for domain, t := range s.removals {
+		if t.Token == token && !t.Used && t.Expires.After(now) {
+			t.Used = true
+			s.removals[domain] = t
+			return domain, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+// GetDataExport retrieves everything stored about a contact email address.
+// Domains' contact addresses are matched regardless of case.
+func (s *Store) GetDataExport(email string) (models.DataExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	export := models.NewDataExport(email)
+	export.Domains = s.domainsWhere(func(d models.Domain) bool {
+		return strings.EqualFold(d.Email, email)
+	})
+	for i, d := range export.Domains {
+		name := d.Name
+		if i > 0 && export.Domains[i-1].Name == name {
+			continue
+		}
+		if t, ok := s.tokens[name]; ok {
+			t.Token = ""
+			export.ValidationTokens = append(export.ValidationTokens, t)
+		}
+		if m, ok := s.moderation[name]; ok {
+			m.Reasons = append([]string(nil), m.Reasons...)
+			export.Moderation = append(export.Moderation, m)
+		}
+	}
+	for _, row := range s.apiKeys {
+		if row.key.Email == email {
+			export.APIKeys = append(export.APIKeys, copyAPIKey(row.key))
+		}
+	}
+	if t, ok := s.apiKeyTokens[email]; ok {
+		export.EmailTokens = append(export.EmailTokens,
+			models.EmailToken{Purpose: models.PurposeAPIKeys, Expires: t.expires, Used: t.used})
+	}
+	if t, ok := s.exportTokens[email]; ok {
+		export.EmailTokens = append(export.EmailTokens,
+			models.EmailToken{Purpose: models.PurposeDataExport, Expires: t.expires, Used: t.used})
+	}
+	if t, ok := s.eraseTokens[email]; ok {
+		export.EmailTokens = append(export.EmailTokens,
+			models.EmailToken{Purpose: models.PurposeDataErasure, Expires: t.expires, Used: t.used})
+	}
+	for address, bounces := range s.blacklist {
+		if strings.EqualFold(address, email) {
+			export.Bounces = append(export.Bounces, bounces...)
+		}
+	}
+	sort.SliceStable(export.Bounces, func(i, j int) bool {
+		return export.Bounces[i].Timestamp.Before(export.Bounces[j].Timestamp)
+	})
+	return export, nil
+}
+
+func copyAPIKey(key models.APIKey) models.APIKey {
+	key.Scopes = append([]string(nil), key.Scopes...)
+	return key
+}
+
+// apiKey returns the index of the API key with ID id that matches include,
+// or -1.
+func (s *Store) apiKey(id int64, include func(apiKeyRow) bool) int {
+	for i, row := range s.apiKeys {
+		if row.key.ID == id && include(row) {
+			return i
+		}
+	}
+	return -1
+}
+
+// PutAPIKey stores a new API key under its hash, and returns it with its ID
+// and creation time set.
+func (s *Store) PutAPIKey(key models.APIKey, hash string) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range s.apiKeys {
+		if row.hash == hash {
+			return models.APIKey{}, errors.New("an API key with this hash already exists")
+		}
+	}
+	stored := models.APIKey{
+		ID:      s.nextID(),
+		Email:   key.Email,
+		Name:    key.Name,
+		Scopes:  append([]string(nil), key.Scopes...),
+		Created: time.Now().UTC(),
+	}
+	s.apiKeys = append(s.apiKeys, apiKeyRow{key: stored, hash: hash})
+	return copyAPIKey(stored), nil
+}
+
+// GetAPIKeys retrieves the API keys owned by email, oldest first.
+func (s *Store) GetAPIKeys(email string) ([]models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []models.APIKey{}
+	for _, row := range s.apiKeys {
+		if row.key.Email == email {
+			keys = append(keys, copyAPIKey(row.key))
+		}
+	}
+	return keys, nil
+}
+
+// UseAPIKey retrieves the unrevoked API key with the given hash, and records
+// a request made with it.
+func (s *Store) UseAPIKey(hash string) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for i, row := range s.apiKeys {
+		if row.hash != hash || row.key.Revoked {
+			continue
+		}
+		row.key.Requests++
+		row.key.LastUsed = sqlTime(now)
+		s.apiKeys[i] = row
+		s.usage(row.key.ID, now).Requests++
+		return copyAPIKey(row.key), nil
+	}
+	return models.APIKey{}, sql.ErrNoRows
+}
+
+// usage returns the usage of an API key on the day of t.
+func (s *Store) usage(id int64, t time.Time) *models.APIKeyUsage {
+	key := usageKey{id, day(t)}
+	u, ok := s.apiKeyUsage[key]
+	if !ok {
+		d, _ := time.Parse("2006-01-02", key.day)
+		u = &models.APIKeyUsage{Day: d}
+		s.apiKeyUsage[key] = u
+	}
+	return u
+}
+
+// UseAPIKeyScan records a scan made with an API key, and returns the number
+// of scans made with it today. If the key's quota has been used up, the scan
+// isn't recorded and sql.ErrNoRows is returned.
+func (s *Store) UseAPIKeyScan(key models.APIKey) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.usage(key.ID, time.Now())
+	if key.ScanQuota != 0 && u.Scans >= key.ScanQuota && u.Scans > 0 {
+		return 0, sql.ErrNoRows
+	}
+	u.Scans++
+	return u.Scans, nil
+}
+
+// GetAPIKeyUsage retrieves an API key's usage on each day since a given
+// time, ordered by day.
+func (s *Store) GetAPIKeyUsage(id int64, since time.Time) ([]models.APIKeyUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := []models.APIKeyUsage{}
+	for key, u := range s.apiKeyUsage {
+		if key.id == id && key.day >= day(since) {
+			usage = append(usage, *u)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Day.Before(usage[j].Day) })
+	return usage, nil
+}
+
+// GetAPIUsage totals the use of every API key since a given time.
+func (s *Store) GetAPIUsage(since time.Time) (models.APIUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var usage models.APIUsage
+	keys := make(map[int64]bool)
+	for key, u := range s.apiKeyUsage {
+		if key.day < day(since) {
+			continue
+		}
+		keys[key.id] = true
+		usage.Requests += u.Requests
+		usage.Scans += u.Scans
+	}
+	usage.Keys = len(keys)
+	return usage, nil
+}
+
+// SetAPIKeyQuota sets an API key's daily scan quota. Zero means unlimited.
+func (s *Store) SetAPIKeyQuota(id int64, quota int64) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.apiKey(id, func(apiKeyRow) bool { return true })
+	if i < 0 {
+		return models.APIKey{}, sql.ErrNoRows
+	}
+	s.apiKeys[i].key.ScanQuota = quota
+	return copyAPIKey(s.apiKeys[i].key), nil
+}
+
+// RotateAPIKey replaces the hash of an unrevoked API key owned by email.
+func (s *Store) RotateAPIKey(id int64, email string, hash string) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.apiKey(id, func(row apiKeyRow) bool { return row.key.Email == email && !row.key.Revoked })
+	if i < 0 {
+		return models.APIKey{}, sql.ErrNoRows
+	}
+	s.apiKeys[i].hash = hash
+	return copyAPIKey(s.apiKeys[i].key), nil
+}
+
+// RevokeAPIKey revokes an API key owned by email.
+func (s *Store) RevokeAPIKey(id int64, email string) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.apiKey(id, func(row apiKeyRow) bool { return row.key.Email == email })
+	if i < 0 {
+		return models.APIKey{}, sql.ErrNoRows
+	}
+	s.apiKeys[i].key.Revoked = true
+	return copyAPIKey(s.apiKeys[i].key), nil
+}
+
+// PROMOTIONS
+
+// PutPromotion stores the evidence for a domain's promotion attempt, and
+// returns it with its ID set.
+func (s *Store) PutPromotion(p models.Promotion) (models.Promotion, error) {
+	evidence, err := json.Marshal(p)
+	if err != nil {
+		return p, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.ID = s.nextID()
+	s.promotions = append(s.promotions, promotionRow{
+		id: p.ID, domain: p.Domain, time: sqlTime(p.Time), evidence: evidence,
+	})
+	return p, nil
+}
+
+// GetPromotions retrieves a domain's promotion attempts, most recent first.
+func (s *Store) GetPromotions(domain string) ([]models.Promotion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := []promotionRow{}
+	for _, row := range s.promotions {
+		if row.domain == domain {
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].time.After(rows[j].time) })
+	promotions := []models.Promotion{}
+	for _, row := range rows {
+		var p models.Promotion
+		if err := json.Unmarshal(row.evidence, &p); err != nil {
+			return promotions, err
+		}
+		p.ID = row.id
+		promotions = append(promotions, p)
+	}
+	return promotions, nil
+}
+
+// MODERATION
+
+func copyModeration(m models.Moderation) models.Moderation {
+	m.Reasons = append([]string(nil), m.Reasons...)
+	return m
+}
+
+// PutModeration holds a domain's submission for review, replacing any earlier
+// decision on it.
+func (s *Store) PutModeration(m models.Moderation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.moderation[m.Domain] = models.Moderation{
+		Domain:   m.Domain,
+		Reasons:  append([]string(nil), m.Reasons...),
+		Flagged:  sqlTime(m.Flagged),
+		Decision: models.DecisionPending,
+	}
+	return nil
+}
+
+// GetPendingModerations retrieves the submissions awaiting review, oldest
+// first.
+func (s *Store) GetPendingModerations() ([]models.Moderation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := []models.Moderation{}
+	for _, m := range s.moderation {
+		if m.Decision == models.DecisionPending {
+			pending = append(pending, copyModeration(m))
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if !pending[i].Flagged.Equal(pending[j].Flagged) {
+			return pending[i].Flagged.Before(pending[j].Flagged)
+		}
+		return pending[i].Domain < pending[j].Domain
+	})
+	return pending, nil
+}
+
+// DecideModeration records a reviewer's decision on a pending submission.
+// Returns sql.ErrNoRows if the domain has no submission awaiting review.
+func (s *Store) DecideModeration(domain string, decision string, reviewer string, note string) (models.Moderation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.moderation[domain]
+	if !ok || m.Decision != models.DecisionPending {
+		return models.Moderation{}, sql.ErrNoRows
+	}
+	m.Decision, m.Reviewer, m.Note = decision, reviewer, note
+	m.Decided = sqlTime(time.Now())
+	s.moderation[domain] = m
+	return copyModeration(m), nil
+}
+
+// SUBMISSION BLOCKLIST
+
+// PutSubmissionBlock blocks queue submissions matching b, replacing the
+// reason for an existing block of the same pattern.
+func (s *Store) PutSubmissionBlock(b models.SubmissionBlock) (models.SubmissionBlock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.blocks {
+		if existing.Kind == b.Kind && existing.Pattern == b.Pattern {
+			s.blocks[i].Reason = b.Reason
+			return s.blocks[i], nil
+		}
+	}
+	stored := models.SubmissionBlock{
+		ID:      s.nextID(),
+		Kind:    b.Kind,
+		Pattern: b.Pattern,
+		Reason:  b.Reason,
+		Created: sqlTime(time.Now()),
+	}
+	s.blocks = append(s.blocks, stored)
+	return stored, nil
+}
+
+// GetSubmissionBlocks retrieves every submission block, oldest first.
+func (s *Store) GetSubmissionBlocks() ([]models.SubmissionBlock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.SubmissionBlock{}, s.blocks...), nil
+}
+
+// RemoveSubmissionBlock removes the submission block with the given ID.
+func (s *Store) RemoveSubmissionBlock(id int64) (models.SubmissionBlock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, b := range s.blocks {
+		if b.ID == id {
+			s.blocks = append(s.blocks[:i], s.blocks[i+1:]...)
+			return b, nil
+		}
+	}
+	return models.SubmissionBlock{}, sql.ErrNoRows
+}
+
+// WEBHOOKS
+
+// PutWebhook registers a webhook, and returns it with its ID set.
+func (s *Store) PutWebhook(w models.Webhook) (models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.webhooks {
+		if existing.Domain == w.Domain && existing.URL == w.URL {
+			return models.Webhook{}, fmt.Errorf("webhook %s is already registered for %s", w.URL, w.Domain)
+		}
+	}
+	w.ID = s.nextID()
+	w.Created = sqlTime(time.Now())
+	s.webhooks = append(s.webhooks, w)
+	return w, nil
+}
+
+// GetWebhook retrieves a webhook, including its secret.
+func (s *Store) GetWebhook(id int64) (models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.webhooks {
+		if w.ID == id {
+			return w, nil
+		}
+	}
+	return models.Webhook{}, sql.ErrNoRows
+}
+
+// GetWebhooks retrieves the webhooks registered for a domain, oldest first,
+// without their secrets.
+func (s *Store) GetWebhooks(domain string) ([]models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks := []models.Webhook{}
+	for _, w := range s.webhooks {
+		if w.Domain == domain {
+			w.Secret = ""
+			hooks = append(hooks, w)
+		}
+	}
+	return hooks, nil
+}
+
+// RemoveWebhook removes a webhook and its deliveries.
+func (s *Store) RemoveWebhook(id int64) (models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range s.webhooks {
+		if w.ID != id {
+			continue
+		}
+		s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+		kept := s.deliveries[:0]
+		for _, d := range s.deliveries {
+			if d.WebhookID != id {
+				kept = append(kept, d)
+			}
+		}
+		s.deliveries = kept
+		w.Secret = ""
+		return w, nil
+	}
+	return models.Webhook{}, sql.ErrNoRows
+}
+
+// queueWebhookDeliveries queues the delivery of domain's change of state to
+// each of its webhooks. The caller must hold s.mu.
+func (s *Store) queueWebhookDeliveries(domain string, from models.DomainState, to models.DomainState, change models.StateChange) {
+	now := sqlTime(time.Now())
+	for _, w := range s.webhooks {
+		if w.Domain == domain {
+			d := models.NewWebhookDelivery(w, from, to, change, now)
+			d.ID = s.nextID()
+			s.deliveries = append(s.deliveries, d)
+		}
+	}
+}
+
+// GetWebhookDeliveries retrieves up to limit of a webhook's deliveries, most
+// recent first.
+func (s *Store) GetWebhookDeliveries(webhookID int64, limit int) ([]models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := []models.WebhookDelivery{}
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if s.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, s.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+// GetDueWebhookDeliveries retrieves up to limit pending deliveries due to be
+// attempted by a given time, oldest first.
+func (s *Store) GetDueWebhookDeliveries(now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now = sqlTime(now)
+	deliveries := []models.WebhookDelivery{}
+	for _, d := range s.deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if d.Status == models.DeliveryPending && !d.NextAttempt.After(now) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt to deliver d.
+func (s *Store) UpdateWebhookDelivery(d models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, existing := range s.deliveries {
+		if existing.ID == d.ID {
+			existing.Status = d.Status
+			existing.Attempts = d.Attempts
+			existing.NextAttempt = sqlTime(d.NextAttempt)
+			existing.ResponseCode = d.ResponseCode
+			existing.Error = d.Error
+			s.deliveries[i] = existing
+			return nil
+		}
+	}
+	return nil
+}
+
+// AUDIT LOG
+
+// PutAuditEntry appends an entry to the audit log, and returns it with its ID
+// set. If the entry's time isn't set, it's the current time.
+func (s *Store) PutAuditEntry(e models.AuditEntry) (models.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putAuditEntry(e), nil
+}
+
+// putAuditEntry appends an entry to the audit log. The caller must hold s.mu.
+func (s *Store) putAuditEntry(e models.AuditEntry) models.AuditEntry {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.ID = s.nextID()
+	stored := e
+	stored.Time = sqlTime(e.Time)
+	s.audit = append(s.audit, stored)
+	return e
+}
+
+// GetAuditLog retrieves the audit log entries about a subject, most recent
+// first.
+func (s *Store) GetAuditLog(subject string) ([]models.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := []models.AuditEntry{}
+	for _, e := range s.audit {
+		if e.Subject == subject {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.After(entries[j].Time)
+		}
+		return entries[i].ID > entries[j].ID
+	})
+	return entries, nil
+}
+
+// GetAuditLogSince retrieves the audit log entries since a time, oldest
+// first.
+func (s *Store) GetAuditLogSince(since time.Time) ([]models.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since = sqlTime(since)
+	entries := []models.AuditEntry{}
+	for _, e := range s.audit {
+		if !e.Time.Before(since) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.Before(entries[j].Time)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// LIST PUBLICATION
+
+// AcquireLease takes or renews the named lease for holder, until ttl from
+// now. Returns false if another holder's lease hasn't expired.
+func (s *Store) AcquireLease(name string, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := sqlTime(time.Now())
+	current, ok := s.leases[name]
+	if ok && current.holder != holder && !current.expires.Before(now) {
+		return false, nil
+	}
+	s.leases[name] = lease{holder: holder, expires: now.Add(ttl)}
+	return true, nil
+}
+
+// PutPublication stores a new version of the published policy list.
+func (s *Store) PutPublication(p policy.Publication) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Body = append([]byte(nil), p.Body...)
+	p.Published = sqlTime(p.Published)
+	s.publications = append(s.publications, p)
+	return nil
+}
+
+// GetPublication retrieves the latest version of the published policy list,
+// or sql.ErrNoRows if none has been published.
+func (s *Store) GetPublication() (policy.Publication, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.publications) == 0 {
+		return policy.Publication{}, sql.ErrNoRows
+	}
+	p := s.publications[len(s.publications)-1]
+	p.Body = append([]byte(nil), p.Body...)
+	return p, nil
+}
+
+// OPERATIONS REPORTS
+
+// PutReport stores an operations report, and returns it with its ID set.
+func (s *Store) PutReport(r report.Report) (report.Report, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ID = s.nextID()
+	s.reports = append(s.reports, reportRow{id: r.ID, period: r.Period, end: sqlTime(r.End), data: data})
+	return r, nil
+}
+
+// GetLatestReport retrieves the operations report for the most recent
+// period of a kind, or of any kind if period is empty.
+func (s *Store) GetLatestReport(period report.Period) (report.Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest *reportRow
+	for i, row := range s.reports {
+		if period != "" && row.period != period {
+			continue
+		}
+		if latest == nil || !row.end.Before(latest.end) {
+			latest = &s.reports[i]
+		}
+	}
+	var r report.Report
+	if latest == nil {
+		return r, sql.ErrNoRows
+	}
+	err := json.Unmarshal(latest.data, &r)
+	r.ID = latest.id
+	return r, err
+}
+
+// PutValidatorRun stores the summary of a validator's latest run, replacing
+// the previous one.
+func (s *Store) PutValidatorRun(name string, summary validator.RunSummary) error {
+	summary.Prune = append([]validator.PruneSuggestion(nil), summary.Prune...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[name] = summary
+	return nil
+}
+
+// GetValidatorRun retrieves the summary of a validator's latest run.
+func (s *Store) GetValidatorRun(name string) (validator.RunSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary, ok := s.runs[name]
+	if !ok {
+		return summary, sql.ErrNoRows
+	}
+	return summary, nil
+}
+
+// ClearTables empties the store.
+func (s *Store) ClearTables() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clear()
+	return nil
+}
@@ -0,0 +1,572 @@
+package memstore_test
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/db/memstore"
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/report"
+	"github.com/EFForg/starttls-backend/validator"
+)
+
+func TestDomains(t *testing.T) {
+	store := memstore.New()
+	domain := models.Domain{Name: "example.com", Email: "me@example.com", MXs: []string{"mx.example.com"}}
+	if err := store.PutDomain(domain); err != nil {
+		t.Fatal(err)
+	}
+	domain.MXs[0] = "changed.example.com"
+	stored, err := store.GetDomain("example.com", models.StateUnconfirmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.MXs[0] != "mx.example.com" {
+		t.Errorf("Changing a stored domain changed the store: %v", stored.MXs)
+	}
+	if _, err = store.GetDomain("example.com", models.StateTesting); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a domain in another state, got %v", err)
+	}
+
+	if err = store.SetStatus("example.com", models.StateTesting, models.StateChange{}); err != nil {
+		t.Fatal(err)
+	}
+	queued, err := store.GetDomain("example.com", models.StateTesting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued.TestingStart.IsZero() {
+		t.Error("Queuing a domain should set its testing start")
+	}
+	names, err := store.DomainsToValidate()
+	if err != nil || len(names) != 1 || names[0] != "example.com" {
+		t.Errorf("Expected example.com to be validated, got %v, %v", names, err)
+	}
+
+	queued.MXs = []string{"mx2.example.com"}
+	updated, err := store.UpdateDomain(queued, queued.LastUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.LastUpdated.After(queued.LastUpdated) {
+		t.Error("Updating a domain should set its last update time")
+	}
+	if _, err = store.UpdateDomain(queued, queued.LastUpdated); err != sql.ErrNoRows {
+		t.Errorf("Expected a stale update to return sql.ErrNoRows, got %v", err)
+	}
+	hostnames, err := store.HostnamesForDomain("example.com")
+	if err != nil || len(hostnames) != 1 || hostnames[0] != "mx2.example.com" {
+		t.Errorf("Expected updated hostnames, got %v, %v", hostnames, err)
+	}
+
+	if _, err = store.RemoveDomain("example.com", models.StateTesting, models.StateChange{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.RemoveDomain("example.com", models.StateTesting, models.StateChange{}); err != sql.ErrNoRows {
+		t.Errorf("Expected removing a missing domain to return sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	store := memstore.New()
+	token, err := store.PutToken("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain, err := store.UseToken(token.Token)
+	if err != nil || domain != "example.com" {
+		t.Errorf("Expected token for example.com, got %s, %v", domain, err)
+	}
+	if _, err = store.UseToken(token.Token); err != sql.ErrNoRows {
+		t.Errorf("Expected reusing a token to return sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestResendToken(t *testing.T) {
+	store := memstore.New()
+	submitted, _ := store.PutToken("example.com")
+	first, _, err := store.ResendToken("example.com", time.Hour, 2)
+	if err != nil || first.Token == "" || first.Token == submitted.Token {
+		t.Fatalf("Expected a new token, got %v, %v", first, err)
+	}
+	second, retryAt, err := store.ResendToken("example.com", time.Hour, 2)
+	if err != nil || !retryAt.IsZero() || second.Token == first.Token {
+		t.Fatalf("Expected another new token, got %v, %v, %v", second, retryAt, err)
+	}
+	if _, retryAt, err = store.ResendToken("example.com", time.Hour, 2); err != nil || retryAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("Expected resends to be limited for an hour, got %v, %v", retryAt, err)
+	}
+	if _, err = store.UseToken(first.Token); err != sql.ErrNoRows {
+		t.Errorf("Expected an earlier resend's token to stop working, got %v", err)
+	}
+	if domain, err := store.UseToken(submitted.Token); err != nil || domain != "example.com" {
+		t.Errorf("Expected the submission's token to keep working, got %s, %v", domain, err)
+	}
+	if _, err = store.UseToken(second.Token); err != sql.ErrNoRows {
+		t.Errorf("Expected the domain's tokens to be used up, got %v", err)
+	}
+}
+
+func TestRemovalTokens(t *testing.T) {
+	store := memstore.New()
+	old, _ := store.PutRemovalToken("example.com")
+	token, err := store.PutRemovalToken("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.UseRemovalToken(old); err != sql.ErrNoRows {
+		t.Errorf("Expected a replaced token to return sql.ErrNoRows, got %v", err)
+	}
+	domain, err := store.UseRemovalToken(token)
+	if err != nil || domain != "example.com" {
+		t.Errorf("Expected token for example.com, got %s, %v", domain, err)
+	}
+	if _, err = store.UseRemovalToken(token); err != sql.ErrNoRows {
+		t.Errorf("Expected reusing a token to return sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestScans(t *testing.T) {
+	store := memstore.New()
+	now := time.Now()
+	for i, status := range []checker.DomainStatus{checker.DomainSuccess, checker.DomainFailure, checker.DomainSuccess} {
+		scan := models.Scan{
+			Domain:    "example.com",
+			Data:      checker.DomainResult{Domain: "example.com", Status: status},
+			Timestamp: now.Add(time.Duration(i-2) * time.Hour),
+			Source:    models.SourceAPI,
+		}
+		if err := store.PutScan(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	latest, err := store.GetLatestScan("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !latest.Timestamp.Equal(now.UTC().Truncate(time.Second)) {
+		t.Errorf("Expected the latest scan, got one from %v", latest.Timestamp)
+	}
+	at, err := store.GetScanAt("example.com", now.Add(-90*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at.Data.Status != checker.DomainSuccess || !at.Timestamp.Before(now.Add(-90*time.Minute)) {
+		t.Errorf("Expected the first scan, got %v", at)
+	}
+	scans, err := store.GetLatestScans("example.com", 2)
+	if err != nil || len(scans) != 2 || scans[1].Data.Status != checker.DomainFailure {
+		t.Errorf("Expected the two most recent scans, got %v, %v", scans, err)
+	}
+	byDomain, err := store.GetLatestScansOf([]string{"example.com", "missing.com"})
+	if err != nil || len(byDomain) != 1 || !byDomain["example.com"].Timestamp.Equal(latest.Timestamp) {
+		t.Errorf("Expected just the latest scan of example.com, got %v, %v", byDomain, err)
+	}
+	counts, err := store.GetScanCounts(now.Add(-3 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 || counts[0].Count != 2 || counts[1].Count != 1 {
+		t.Errorf("Expected two successful scans and a failed one, got %v", counts)
+	}
+	if _, err = store.GetLatestScan("missing.com"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for an unscanned domain, got %v", err)
+	}
+	census := models.Scan{Domain: "example.com", Timestamp: now.Add(time.Minute), Source: models.SourceCensus}
+	if err = store.PutScan(census); err != nil {
+		t.Fatal(err)
+	}
+	trusted, err := store.GetLatestTrustedScan("example.com")
+	if err != nil || !trusted.Timestamp.Equal(latest.Timestamp) {
+		t.Errorf("Expected the latest trusted scan to skip the census scan, got %v, %v", trusted, err)
+	}
+	if scans, err = store.GetLatestTrustedScans("example.com", 2); err != nil || len(scans) != 2 || scans[0].Source != models.SourceAPI {
+		t.Errorf("Expected the two most recent trusted scans, got %v, %v", scans, err)
+	}
+}
+
+func TestAPIKeyScanQuota(t *testing.T) {
+	store := memstore.New()
+	key, err := store.PutAPIKey(models.APIKey{Email: "me@example.com", Name: "ci"}, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.PutAPIKey(models.APIKey{Email: "me@example.com"}, "hash"); err == nil {
+		t.Error("Expected storing a duplicate key hash to fail")
+	}
+	key, err = store.SetAPIKeyQuota(key.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 2; i++ {
+		scans, err := store.UseAPIKeyScan(key)
+		if err != nil || scans != i {
+			t.Errorf("Expected scan %d to be allowed, got %d, %v", i, scans, err)
+		}
+	}
+	if _, err = store.UseAPIKeyScan(key); err != sql.ErrNoRows {
+		t.Errorf("Expected a scan over quota to return sql.ErrNoRows, got %v", err)
+	}
+	if _, err = store.UseAPIKey("hash"); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := store.GetAPIUsage(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Keys != 1 || usage.Requests != 1 || usage.Scans != 2 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+	if _, err = store.RevokeAPIKey(key.ID, "someone@example.com"); err != sql.ErrNoRows {
+		t.Errorf("Expected revoking another owner's key to return sql.ErrNoRows, got %v", err)
+	}
+	if _, err = store.RevokeAPIKey(key.ID, "me@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.UseAPIKey("hash"); err != sql.ErrNoRows {
+		t.Errorf("Expected a revoked key to return sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestAcquireLease(t *testing.T) {
+	store := memstore.New()
+	if ok, _ := store.AcquireLease("publish", "a", time.Minute); !ok {
+		t.Error("Expected a free lease to be acquired")
+	}
+	if ok, _ := store.AcquireLease("publish", "b", time.Minute); ok {
+		t.Error("Expected another holder's lease not to be acquired")
+	}
+	if ok, _ := store.AcquireLease("publish", "a", time.Minute); !ok {
+		t.Error("Expected a lease to be renewed by its holder")
+	}
+	if ok, _ := store.AcquireLease("publish", "a", -time.Hour); !ok {
+		t.Error("Expected a lease to be renewed by its holder")
+	}
+	if ok, _ := store.AcquireLease("publish", "b", time.Minute); !ok {
+		t.Error("Expected an expired lease to be acquired")
+	}
+}
+
+func TestReportsByPeriod(t *testing.T) {
+	store := memstore.New()
+	end := time.Now()
+	weekly, _ := store.PutReport(report.Report{Period: report.Weekly, End: end})
+	monthly, _ := store.PutReport(report.Report{Period: report.Monthly, End: end.Add(-time.Hour)})
+	if latest, err := store.GetLatestReport(""); err != nil || latest.ID != weekly.ID {
+		t.Errorf("Expected the weekly report to be the latest, got %+v, %v", latest, err)
+	}
+	if latest, err := store.GetLatestReport(report.Monthly); err != nil || latest.ID != monthly.ID {
+		t.Errorf("Expected the monthly report, got %+v, %v", latest, err)
+	}
+	store.PutValidatorRun("list", validator.RunSummary{Time: end, Attempted: 2})
+	if run, err := store.GetValidatorRun("list"); err != nil || run.Attempted != 2 {
+		t.Errorf("Expected the stored run, got %+v, %v", run, err)
+	}
+	if _, err := store.GetValidatorRun("queued"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a validator that hasn't run, got %v", err)
+	}
+}
+
+func TestAggregatedScanFingerprints(t *testing.T) {
+	store := memstore.New()
+	a := checker.AggregatedScan{Time: time.Now(), Source: checker.TopDomainsSource, WithMXs: 3,
+		Software: map[string]int{"Exim 4.94": 2, "unknown": 1}}
+	if err := store.PutAggregatedScan(a); err != nil {
+		t.Fatal(err)
+	}
+	series, err := store.GetStats(checker.TopDomainsSource)
+	if err != nil || len(series) != 1 || series[0].Software["Exim 4.94"] != 2 {
+		t.Errorf("Expected fingerprint counts to be stored, got %+v (%v)", series, err)
+	}
+}
+
+func TestClearTables(t *testing.T) {
+	store := memstore.New()
+	store.PutDomain(models.Domain{Name: "example.com"})
+	store.PutBlacklistedEmail("me@example.com", "bounce", "")
+	if err := store.ClearTables(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetDomain("example.com", models.StateUnconfirmed); err != sql.ErrNoRows {
+		t.Errorf("Expected cleared domain to be gone, got %v", err)
+	}
+	if blacklisted, _ := store.IsBlacklistedEmail("me@example.com"); blacklisted {
+		t.Error("Expected cleared blacklist to be empty")
+	}
+}
+
+func TestBlacklistIgnoresCase(t *testing.T) {
+	store := memstore.New()
+	store.PutBlacklistedEmail("Me@Example.com", models.SuppressUnsubscribe, "")
+	if blacklisted, _ := store.IsBlacklistedEmail("me@example.com"); !blacklisted {
+		t.Error("Expected the address to be blacklisted regardless of case")
+	}
+}
+
+func TestSubmissionBlocks(t *testing.T) {
+	store := memstore.New()
+	b, err := store.PutSubmissionBlock(models.SubmissionBlock{Kind: models.BlockDomain, Pattern: "junk.example", Reason: "spam"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := store.PutSubmissionBlock(models.SubmissionBlock{Kind: models.BlockDomain, Pattern: "junk.example", Reason: "more spam"})
+	if err != nil || again.ID != b.ID || again.Reason != "more spam" {
+		t.Errorf("Expected blocking the same pattern to replace its reason, got %+v, %v", again, err)
+	}
+	store.PutSubmissionBlock(models.SubmissionBlock{Kind: models.BlockEmail, Pattern: "*@mailinator.com"})
+	blocks, err := store.GetSubmissionBlocks()
+	if err != nil || len(blocks) != 2 || blocks[0].ID != b.ID {
+		t.Errorf("Expected both blocks, oldest first, got %+v, %v", blocks, err)
+	}
+	if removed, err := store.RemoveSubmissionBlock(b.ID); err != nil || removed.Pattern != "junk.example" {
+		t.Errorf("Expected the block to be removed, got %+v, %v", removed, err)
+	}
+	if _, err := store.RemoveSubmissionBlock(b.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected removing a missing block to fail with sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	store := memstore.New()
+	hook, err := store.PutWebhook(models.Webhook{Domain: "example.com", URL: "https://hooks.example.com/", Owner: "me@example.com", Secret: "whsec_test"})
+	if err != nil || hook.ID == 0 || hook.Secret != "whsec_test" {
+		t.Fatalf("Expected the webhook to be registered, got %+v, %v", hook, err)
+	}
+	if _, err = store.PutWebhook(models.Webhook{Domain: "example.com", URL: "https://hooks.example.com/", Secret: "x"}); err == nil {
+		t.Error("Expected registering the same URL twice for a domain to fail")
+	}
+	hooks, err := store.GetWebhooks("example.com")
+	if err != nil || len(hooks) != 1 || hooks[0].ID != hook.ID || hooks[0].Secret != "" {
+		t.Errorf("Expected the domain's webhook, without its secret, got %+v, %v", hooks, err)
+	}
+	if stored, err := store.GetWebhook(hook.ID); err != nil || stored.Secret != "whsec_test" {
+		t.Errorf("Expected the webhook with its secret, got %+v, %v", stored, err)
+	}
+
+	store.PutDomain(models.Domain{Name: "example.com", Email: "me@example.com"})
+	if err = store.SetStatus("example.com", models.StateTesting, models.StateChange{Actor: models.ActorToken, Reason: "validated"}); err != nil {
+		t.Fatal(err)
+	}
+	due, err := store.GetDueWebhookDeliveries(time.Now().Add(time.Second), 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("Expected the state change to queue a delivery, got %+v, %v", due, err)
+	}
+	delivery := due[0]
+	if delivery.WebhookID != hook.ID || delivery.From != models.StateUnconfirmed || delivery.To != models.StateTesting ||
+		delivery.Reason != "validated" || delivery.Status != models.DeliveryPending {
+		t.Errorf("Expected a pending delivery of the state change, got %+v", delivery)
+	}
+	delivery.Attempts, delivery.ResponseCode, delivery.Error = 1, 503, "responded with 503"
+	delivery.NextAttempt = time.Now().Add(time.Hour)
+	if err = store.UpdateWebhookDelivery(delivery); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ = store.GetDueWebhookDeliveries(time.Now().Add(time.Second), 10); len(due) != 0 {
+		t.Errorf("Expected the retry not to be due yet, got %+v", due)
+	}
+	deliveries, err := store.GetWebhookDeliveries(hook.ID, 10)
+	if err != nil || len(deliveries) != 1 || deliveries[0].Attempts != 1 || deliveries[0].ResponseCode != 503 {
+		t.Errorf("Expected the delivery's attempt to be recorded, got %+v, %v", deliveries, err)
+	}
+
+	if _, err = store.RemoveDomain("example.com", models.StateTesting, models.StateChange{Actor: "owner"}); err != nil {
+		t.Fatal(err)
+	}
+	due, err = store.GetDueWebhookDeliveries(time.Now().Add(time.Second), 10)
+	if err != nil || len(due) != 1 || due[0].From != models.StateTesting || due[0].To != "" {
+		t.Errorf("Expected the removal to queue a delivery, got %+v, %v", due, err)
+	}
+
+	if removed, err := store.RemoveWebhook(hook.ID); err != nil || removed.ID != hook.ID {
+		t.Errorf("Expected the webhook to be removed, got %+v, %v", removed, err)
+	}
+	if deliveries, _ = store.GetWebhookDeliveries(hook.ID, 10); len(deliveries) != 0 {
+		t.Errorf("Expected the webhook's deliveries to be removed with it, got %+v", deliveries)
+	}
+	if _, err = store.RemoveWebhook(hook.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected removing a missing webhook to fail with sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestScansKeepFullHostnameResults(t *testing.T) {
+	store := memstore.New()
+	data := checker.NewSampleDomainResult("example.com")
+	result := data.HostnameResults["mx.example.com"]
+	result.CertSerial = "01ab"
+	data.HostnameResults["mx.example.com"] = result
+	if err := store.PutScan(models.Scan{Domain: "example.com", Data: data, Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	scan, err := store.GetLatestScan("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := scan.Data.HostnameResults["mx.example.com"]
+	if got.TLSVersion != "TLS 1.3" || got.CertSerial != "01ab" || got.Result == nil {
+		t.Errorf("Expected every field of the hostname result to be stored, got %+v", got)
+	}
+}
+
+func TestGetDomainPage(t *testing.T) {
+	store := memstore.New()
+	for _, name := range []string{"c.com", "a.com", "b.com"} {
+		store.PutDomain(models.Domain{Name: name})
+		store.SetStatus(name, models.StateTesting, models.StateChange{})
+	}
+	store.PutDomain(models.Domain{Name: "unconfirmed.com"})
+
+	for _, sortBy := range []string{models.SortByName, models.SortByLastUpdated} {
+		names := []string{}
+		page := models.DomainPage{Limit: 2, Sort: sortBy}
+		for {
+			list, err := store.GetDomainPage(models.StateTesting, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range list.Domains {
+				names = append(names, d.Name)
+			}
+			if list.Next == "" {
+				break
+			}
+			page.After = list.Next
+		}
+		expected := "a.com b.com c.com"
+		if sortBy == models.SortByLastUpdated {
+			expected = "b.com a.com c.com"
+		}
+		if strings.Join(names, " ") != expected {
+			t.Errorf("Expected queued domains sorted by %s to be %s, got %v", sortBy, expected, names)
+		}
+	}
+}
+
+func TestSearchDomains(t *testing.T) {
+	store := memstore.New()
+	for _, name := range []string{"mail.example.com", "gmail.com", "example.org"} {
+		store.PutDomain(models.Domain{Name: name})
+	}
+	store.SetStatus("gmail.com", models.StateTesting, models.StateChange{})
+
+	domains, err := store.SearchDomains(models.DomainSearch{Query: "mail", Match: models.MatchSubstring})
+	if err != nil || len(domains) != 2 || domains[0].Name != "gmail.com" {
+		t.Errorf("Expected both mail domains in order of name, got %v, %v", domains, err)
+	}
+	domains, _ = store.SearchDomains(models.DomainSearch{Query: "mail"})
+	if len(domains) != 1 || domains[0].Name != "mail.example.com" {
+		t.Errorf("Expected only names starting with mail, got %v", domains)
+	}
+	domains, _ = store.SearchDomains(models.DomainSearch{Query: "mail", Match: models.MatchSubstring}, models.StateTesting)
+	if len(domains) != 1 || domains[0].Name != "gmail.com" {
+		t.Errorf("Expected only the queued domain, got %v", domains)
+	}
+	domains, _ = store.SearchDomains(models.DomainSearch{Query: "example", Match: models.MatchSubstring, Limit: 1})
+	if len(domains) != 1 || domains[0].Name != "example.org" {
+		t.Errorf("Expected the first match by name, got %v", domains)
+	}
+}
+
+func TestStateChangesAreAudited(t *testing.T) {
+	store := memstore.New()
+	store.PutDomain(models.Domain{Name: "example.com"})
+	store.SetStatus("example.com", models.StateTesting, models.StateChange{Actor: models.ActorToken})
+	store.SetStatus("example.com", models.StateTesting, models.StateChange{Actor: "admin"})
+	store.RemoveDomain("example.com", models.StateTesting, models.StateChange{Actor: "admin", Reason: "spam"})
+	entries, err := store.GetAuditLog("example.com")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected only actual changes in the audit log, got %v, %v", entries, err)
+	}
+	if entries[0].Details != "queued removed: spam" || entries[1].Actor != models.ActorToken ||
+		entries[1].Details != "unvalidated to queued" {
+		t.Errorf("Expected the state changes, most recent first, got %v", entries)
+	}
+}
+
+func TestImportDomains(t *testing.T) {
+	store := memstore.New()
+	store.PutDomain(models.Domain{Name: "taken.com"})
+	store.SetStatus("taken.com", models.StateEnforce, models.StateChange{})
+	change := models.StateChange{Actor: "alice", Reason: "imported"}
+	err := store.ImportDomains([]models.Domain{
+		{Name: "new.com", State: models.StateTesting},
+		{Name: "taken.com", State: models.StateEnforce},
+	}, change)
+	if err == nil {
+		t.Error("Expected an import of a stored domain to fail")
+	}
+	if _, err = store.GetDomain("new.com", models.StateTesting); err == nil {
+		t.Error("Expected nothing to be imported when one domain can't be")
+	}
+	if err = store.ImportDomains([]models.Domain{{Name: "new.com", State: models.StateTesting}}, change); err != nil {
+		t.Fatal(err)
+	}
+	domain, err := store.GetDomain("new.com", models.StateTesting)
+	if err != nil || domain.TestingStart.IsZero() {
+		t.Errorf("Expected new.com to be queued, got %+v, %v", domain, err)
+	}
+	entries, _ := store.GetAuditLog("new.com")
+	if len(entries) != 1 || entries[0].Actor != "alice" || entries[0].Details != "unvalidated to queued: imported" {
+		t.Errorf("Expected the import to be audited, got %v", entries)
+	}
+}
+
+func TestGetDataExport(t *testing.T) {
+	store := memstore.New()
+	store.PutDomain(models.Domain{Name: "example.com", Email: "Me@example.com"})
+	store.PutDomain(models.Domain{Name: "other.com", Email: "other@example.com"})
+	store.PutToken("example.com")
+	store.PutAPIKey(models.APIKey{Email: "me@example.com", Name: "ci"}, "hash")
+	store.PutAPIKeyToken("me@example.com")
+	store.PutBlacklistedEmail("me@example.com", "complaint", "2017-07-21T18:47:13.498Z")
+
+	export, err := store.GetDataExport("me@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Domains) != 1 || export.Domains[0].Name != "example.com" ||
+		len(export.ValidationTokens) != 1 || export.ValidationTokens[0].Token != "" {
+		t.Errorf("Expected the address's domain and its token's metadata, got %+v", export)
+	}
+	if len(export.APIKeys) != 1 || len(export.EmailTokens) != 1 || export.EmailTokens[0].Purpose != models.PurposeAPIKeys {
+		t.Errorf("Expected the address's API key and verification token, got %+v", export)
+	}
+	if len(export.Bounces) != 1 || export.Bounces[0].Timestamp.Year() != 2017 {
+		t.Errorf("Expected the address's complaint, got %+v", export.Bounces)
+	}
+}
+
+func TestEraseEmail(t *testing.T) {
+	store := memstore.New()
+	store.PutDomain(models.Domain{Name: "pending.com", Email: "me@example.com"})
+	store.PutToken("pending.com")
+	store.PutDomain(models.Domain{Name: "added.com", Email: "ME@example.com"})
+	store.SetStatus("added.com", models.StateEnforce, models.StateChange{})
+	store.PutAPIKey(models.APIKey{Email: "me@example.com"}, "hash")
+	store.PutDataErasureToken("me@example.com")
+
+	erasure, err := store.EraseEmail("me@example.com", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(erasure.Removed) != 1 || erasure.Removed[0] != "pending.com" ||
+		len(erasure.Anonymized) != 1 || erasure.Anonymized[0] != "added.com" || erasure.RevokedKeys != 1 {
+		t.Errorf("Unexpected erasure %+v", erasure)
+	}
+	if _, err = store.GetTokenByDomain("pending.com"); err != sql.ErrNoRows {
+		t.Errorf("Expected removed submission's token to be deleted, got %v", err)
+	}
+	if domain, _ := store.GetDomain("added.com", models.StateEnforce); domain.Email != "" {
+		t.Errorf("Expected listed domain to lose its contact, got %s", domain.Email)
+	}
+	export, _ := store.GetDataExport("me@example.com")
+	if len(export.Domains) != 0 || len(export.APIKeys) != 0 || len(export.EmailTokens) != 0 {
+		t.Errorf("Expected nothing left about the address, got %+v", export)
+	}
+	entries, _ := store.GetAuditLogSince(time.Now().Add(-time.Minute))
+	if last := entries[len(entries)-1]; last.Action != models.ActionErasure || strings.Contains(last.Details, "me@example.com") {
+		t.Errorf("Expected the erasure in the audit log, without the address, got %+v", last)
+	}
+}
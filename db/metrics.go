@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EFForg/starttls-backend/metrics"
+)
+
+// Database metrics, registered with metrics.Default so the daemon can serve
+// them to Prometheus.
+var (
+	querySeconds = metrics.Default.NewHistogram("db_query_seconds",
+		"Time taken by database queries, by statement: its verb and table, eg. \"select scans\".",
+		metrics.DefaultBuckets, "statement")
+	queryErrors = metrics.Default.NewCounter("db_query_errors_total",
+		"Database queries that failed, by statement.", "statement")
+)
+
+// pools are the connection pools whose stats are reported.
+var pools struct {
+	sync.Mutex
+	all []*sql.DB
+}
+
+// pingTimeout bounds the ping behind the db_up metric.
+const pingTimeout = time.Second
+
+func init() {
+	metrics.Default.NewGaugeFunc("db_up",
+		"1 if every database connection pool answers a ping, else 0.",
+		func() float64 {
+			up := 1.0
+			eachPool(func(pool *sql.DB) {
+				ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+				defer cancel()
+				if pool.PingContext(ctx) != nil {
+					up = 0
+				}
+			})
+			return up
+		})
+	poolGauge := func(name string, help string, value func(sql.DBStats) float64) {
+		metrics.Default.NewGaugeFunc(name, help, sumPools(value))
+	}
+	poolGauge("db_open_connections", "Connections open to the database.",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	poolGauge("db_in_use_connections", "Database connections running a query.",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	poolGauge("db_idle_connections", "Idle database connections.",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	metrics.Default.NewCounterFunc("db_connection_waits_total",
+		"Queries that waited for a free database connection.",
+		sumPools(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	metrics.Default.NewCounterFunc("db_connection_wait_seconds_total",
+		"Time spent waiting for a free database connection.",
+		sumPools(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+}
+
+// watchPool reports the stats of pool in the database metrics.
+func watchPool(pool *sql.DB) {
+	pools.Lock()
+	defer pools.Unlock()
+	pools.all = append(pools.all, pool)
+}
+
+// unwatchPool stops reporting the stats of pool, once it's closed.
+func unwatchPool(pool *sql.DB) {
+	pools.Lock()
+	defer pools.Unlock()
+	for i, watched := range pools.all {
+		if watched == pool {
+			pools.all = append(pools.all[:i], pools.all[i+1:]...)
+			return
+		}
+	}
+}
+
+func eachPool(f func(*sql.DB)) {
+	pools.Lock()
+	all := append([]*sql.DB{}, pools.all...)
+	pools.Unlock()
+	for _, pool := range all {
+		f(pool)
+	}
+}
+
+// sumPools returns a metric value totalling a stat across every pool.
+func sumPools(stat func(sql.DBStats) float64) func() float64 {
+	return func() float64 {
+		total := 0.0
+		eachPool(func(pool *sql.DB) { total += stat(pool.Stats()) })
+		return total
+	}
+}
+
+// statementLabel labels the metrics of a query with its verb and the first
+// table it names, so the label has few enough values to be recorded.
+func statementLabel(query string) string {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "unknown"
+	}
+	verb := strings.ToLower(words[0])
+	after := map[string]string{"select": "from", "delete": "from", "insert": "into", "update": "update",
+		"truncate": "truncate", "alter": "table"}[verb]
+	if after == "" {
+		return verb
+	}
+	for i, word := range words[:len(words)-1] {
+		if strings.ToLower(word) == after {
+			table := strings.ToLower(words[i+1])
+			if end := strings.IndexAny(table, "();,"); end >= 0 {
+				table = table[:end]
+			}
+			if table != "" {
+				return verb + " " + table
+			}
+		}
+	}
+	return verb
+}
+
+// observeQuery records how long a query took, and whether it failed.
+// Finding no rows isn't a failure.
+func observeQuery(query string, start time.Time, err error) {
+	label := statementLabel(query)
+	querySeconds.ObserveSince(start, label)
+	if err != nil && err != sql.ErrNoRows {
+		queryErrors.Inc(label)
+	}
+}
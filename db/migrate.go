@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema changes ship as numbered SQL files in db/migrations, named like
+// 0002_add_reports.sql, which are embedded in the binary. Migrate applies
+// those that haven't been applied yet, in order, each in its own
+// transaction, and records them in the schema_migrations table. Applied
+// migrations must never be edited; add a new one instead.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a schema change.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrationLock is the key of the Postgres advisory lock held while
+// migrating, so that servers starting at once don't apply the same
+// migration twice.
+const migrationLock = 5432001
+
+// Migrations returns every embedded migration, ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations := []Migration{}
+	versions := make(map[int]string)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		parts := strings.SplitN(name, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version <= 0 || len(parts) != 2 {
+			return nil, fmt.Errorf("migration %s should be named like 0001_description.sql", entry.Name())
+		}
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, entry.Name())
+		}
+		versions[version] = entry.Name()
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: parts[1], SQL: string(data)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// SchemaVersion returns the version of the latest migration applied to the
+// database, or 0 if none have been.
+func (db *SQLDatabase) SchemaVersion() (int, error) {
+	if err := db.createMigrationsTable(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := db.conn.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+func (db *SQLDatabase) createMigrationsTable() error {
+	_, err := db.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version     INTEGER NOT NULL PRIMARY KEY,
+			name        TEXT NOT NULL,
+			applied     TIMESTAMP NOT NULL
+		)`)
+	return err
+}
+
+// Migrate applies the migrations that haven't been applied to the database
+// yet, and returns them. It stops at the first migration that fails, which
+// is rolled back.
+func (db *SQLDatabase) Migrate() ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err = db.createMigrationsTable(); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	// Advisory locks belong to a session, so hold one connection throughout.
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLock)
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return done, err
+		}
+		if _, err = tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return done, fmt.Errorf("migration %04d_%s failed: %v", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec("INSERT INTO schema_migrations(version, name, applied) VALUES($1, $2, $3)",
+			m.Version, m.Name, time.Now().UTC().Format(sqlTimeFormat))
+		if err != nil {
+			tx.Rollback()
+			return done, err
+		}
+		if err = tx.Commit(); err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
@@ -0,0 +1,5 @@
+-- Every field of each hostname result of a scan, like its certificate and
+-- negotiated TLS version, which scandata leaves out. Empty for scans stored
+-- before this column was added.
+
+ALTER TABLE scans ADD COLUMN IF NOT EXISTS hostname_results TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- Indexes for listing the domains in a state a page at a time, by name or
+-- most recently updated first.
+
+CREATE INDEX IF NOT EXISTS domains_status_domain ON domains (status, domain);
+
+CREATE INDEX IF NOT EXISTS domains_status_last_updated ON domains (status, last_updated DESC, domain DESC);
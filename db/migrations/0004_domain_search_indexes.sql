@@ -0,0 +1,17 @@
+-- Indexes for searching domains by name. LIKE can only use an index for
+-- prefix searches with the text_pattern_ops operator class, unless the
+-- database's collation is C.
+
+CREATE INDEX IF NOT EXISTS domains_domain_pattern ON domains (domain text_pattern_ops);
+
+-- Substring searches need a trigram index. pg_trgm ships with PostgreSQL but
+-- may not be installable by the server's role, in which case substring
+-- searches scan the table instead.
+DO $$
+BEGIN
+    CREATE EXTENSION IF NOT EXISTS pg_trgm;
+    CREATE INDEX IF NOT EXISTS domains_domain_trigram ON domains USING gin (domain gin_trgm_ops);
+EXCEPTION WHEN insufficient_privilege OR undefined_file THEN
+    RAISE NOTICE 'pg_trgm is unavailable, so substring domain searches will not be indexed';
+END
+$$;
@@ -0,0 +1,16 @@
+-- The audit log is append-only, so that it can be relied on to explain how
+-- a domain came to be in its state. Tests empty it with TRUNCATE, which
+-- doesn't fire row triggers.
+
+CREATE OR REPLACE FUNCTION refuse_audit_log_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'the audit log is append-only';
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE
+    ON audit_log FOR EACH ROW EXECUTE PROCEDURE
+    refuse_audit_log_change();
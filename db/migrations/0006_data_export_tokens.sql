@@ -0,0 +1,10 @@
+-- Tokens sent to verify an email address before exporting the data stored
+-- about it, like api_key_tokens.
+
+CREATE TABLE IF NOT EXISTS data_export_tokens
+(
+    email       TEXT NOT NULL PRIMARY KEY,
+    token       VARCHAR(255) NOT NULL,
+    expires     TIMESTAMP NOT NULL,
+    used        BOOLEAN DEFAULT FALSE
+);
@@ -0,0 +1,4 @@
+-- Addresses on the email suppression list (blacklisted_emails) are matched
+-- ignoring case, since mail providers don't preserve the case we sent to.
+
+CREATE INDEX IF NOT EXISTS blacklisted_emails_lower_email ON blacklisted_emails (LOWER(email));
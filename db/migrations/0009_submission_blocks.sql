@@ -0,0 +1,11 @@
+-- Domains and contact email patterns whose queue submissions are refused.
+
+CREATE TABLE IF NOT EXISTS submission_blocks
+(
+    id          SERIAL PRIMARY KEY,
+    kind        TEXT NOT NULL,
+    pattern     TEXT NOT NULL,
+    reason      TEXT NOT NULL DEFAULT '',
+    created     TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (kind, pattern)
+);
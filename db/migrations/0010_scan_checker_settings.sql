@@ -0,0 +1,4 @@
+-- The build version and settings of the checker that performed each scan,
+-- encoded as JSON. Empty for scans stored before this column was added.
+
+ALTER TABLE scans ADD COLUMN IF NOT EXISTS checker TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,36 @@
+-- Callback URLs notified when a domain changes state, and the deliveries of
+-- those notifications. Deliveries are queued in the same transaction as the
+-- state change, and sent by the webhook dispatcher.
+
+CREATE TABLE IF NOT EXISTS webhooks
+(
+    id          SERIAL PRIMARY KEY,
+    domain      TEXT NOT NULL,
+    url         TEXT NOT NULL,
+    owner       TEXT NOT NULL DEFAULT '',
+    secret      TEXT NOT NULL,
+    created     TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (domain, url)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id            SERIAL PRIMARY KEY,
+    webhook_id    INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    domain        TEXT NOT NULL,
+    from_state    TEXT NOT NULL,
+    to_state      TEXT NOT NULL,
+    reason        TEXT NOT NULL DEFAULT '',
+    timestamp     TIMESTAMP NOT NULL,
+    status        TEXT NOT NULL,
+    attempts      INTEGER NOT NULL DEFAULT 0,
+    next_attempt  TIMESTAMP NOT NULL,
+    response_code INTEGER NOT NULL DEFAULT 0,
+    error         TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending
+    ON webhook_deliveries (next_attempt) WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook
+    ON webhook_deliveries (webhook_id, id);
@@ -0,0 +1,10 @@
+-- Tokens sent to a domain's validation address to verify that its owner
+-- wants it removed from the list, like tokens for validating submissions.
+
+CREATE TABLE IF NOT EXISTS removal_tokens
+(
+    domain      TEXT NOT NULL PRIMARY KEY,
+    token       VARCHAR(255) NOT NULL,
+    expires     TIMESTAMP NOT NULL,
+    used        BOOLEAN DEFAULT FALSE
+);
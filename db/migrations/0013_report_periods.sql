@@ -0,0 +1,11 @@
+-- Operations reports are weekly or monthly, and each validator's latest run
+-- is kept so that reports generated by any server can describe it.
+
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS period TEXT NOT NULL DEFAULT 'weekly';
+
+CREATE TABLE IF NOT EXISTS validator_runs
+(
+    name        TEXT NOT NULL PRIMARY KEY,
+    time        TIMESTAMP NOT NULL,
+    data        TEXT NOT NULL
+);
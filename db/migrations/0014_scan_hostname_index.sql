@@ -0,0 +1,4 @@
+-- Index for finding the latest scan that checked an MX hostname, for
+-- generating its DANE records, without reading every scan.
+
+CREATE INDEX IF NOT EXISTS scans_results_hostnames ON scans USING gin ((scandata::jsonb->'results'));
@@ -0,0 +1,15 @@
+-- The audit log stays append-only, except that erasing an email address
+-- redacts it from the entries that mention it. EraseEmail allows this for
+-- its own transaction with SET LOCAL starttls.redact_audit_log, and only the
+-- actor, subject and details of an entry can be changed.
+
+CREATE OR REPLACE FUNCTION refuse_audit_log_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND current_setting('starttls.redact_audit_log', true) = 'on'
+        AND NEW.id = OLD.id AND NEW.timestamp = OLD.timestamp AND NEW.action = OLD.action THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'the audit log is append-only';
+END;
+$$ language 'plpgsql';
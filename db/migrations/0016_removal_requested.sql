@@ -0,0 +1,7 @@
+-- A domain's removal grace period starts when its owner confirmed the
+-- removal, rather than whenever its row was last updated.
+
+ALTER TABLE domains ADD COLUMN IF NOT EXISTS removal_requested TIMESTAMP;
+
+UPDATE domains SET removal_requested = last_updated
+    WHERE status = 'pending-removal' AND removal_requested IS NULL;
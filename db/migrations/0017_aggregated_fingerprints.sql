@@ -0,0 +1,4 @@
+-- Census stats count mailservers by their fingerprints, so changes in each
+-- implementation's deployments can be compared between census runs.
+
+ALTER TABLE aggregated_scans ADD COLUMN IF NOT EXISTS fingerprints TEXT NOT NULL DEFAULT '';
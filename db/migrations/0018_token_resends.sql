@@ -0,0 +1,5 @@
+-- Validation email resends are counted on the domain's token, so the limit
+-- holds across restarts and between API servers.
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS resends INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS resends_since TIMESTAMP;
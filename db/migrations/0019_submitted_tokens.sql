@@ -0,0 +1,6 @@
+-- Resending a validation email generates a new token, but the token emailed
+-- when the domain was submitted keeps working until it expires, so that
+-- anyone asking for a resend can't break the submitter's link.
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS submitted_token VARCHAR(255);
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS submitted_expires TIMESTAMP;
@@ -7,15 +7,19 @@ import (
 	"log"
 	"math/rand"
 	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/EFForg/starttls-backend/checker"
 	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/policy"
+	"github.com/EFForg/starttls-backend/report"
 	"github.com/EFForg/starttls-backend/stats"
+	"github.com/EFForg/starttls-backend/validator"
 
 	// Imports postgresql driver for database/sql
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // Format string for Sql timestamps.
@@ -23,8 +27,9 @@ const sqlTimeFormat = "2006-01-02 15:04:05"
 
 // SQLDatabase is a Database interface backed by postgresql.
 type SQLDatabase struct {
-	cfg  Config  // Configuration to define the DB connection.
-	conn *sql.DB // The database connection.
+	cfg   Config      // Configuration to define the DB connection.
+	conn  timeoutConn // The database connection pool.
+	stmts *statements // Statements prepared on conn.
 }
 
 func getConnectionString(cfg Config) string {
@@ -42,11 +47,16 @@ func getConnectionString(cfg Config) string {
 func InitSQLDatabase(cfg Config) (*SQLDatabase, error) {
 	connectionString := getConnectionString(cfg)
 	log.Printf("Connecting to Postgres DB ... \n")
-	conn, err := sql.Open("postgres", connectionString)
+	pool, err := sql.Open("postgres", connectionString)
 	if err != nil {
 		return nil, err
 	}
-	return &SQLDatabase{cfg: cfg, conn: conn}, nil
+	pool.SetMaxOpenConns(cfg.MaxOpenConns)
+	pool.SetMaxIdleConns(cfg.MaxIdleConns)
+	pool.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	watchPool(pool)
+	conn := timeoutConn{DB: pool, timeout: cfg.QueryTimeout}
+	return &SQLDatabase{cfg: cfg, conn: conn, stmts: newStatements(conn)}, nil
 }
 
 // TOKEN DB FUNCTIONS
@@ -59,11 +69,14 @@ func randToken() string {
 }
 
 // UseToken sets the `used` flag on a particular email validation token to
-// true, and returns the domain that was associated with the token.
+// true, and returns the domain that was associated with the token. The token
+// from the domain's submission can be used until it expires, even after
+// resends have replaced it.
 func (db *SQLDatabase) UseToken(tokenStr string) (string, error) {
 	var domain string
-	err := db.conn.QueryRow("UPDATE tokens SET used=TRUE WHERE token=$1 AND used=FALSE RETURNING domain",
-		tokenStr).Scan(&domain)
+	err := db.conn.QueryRow(`UPDATE tokens SET used=TRUE
+		WHERE (token=$1 OR (submitted_token=$1 AND submitted_expires > $2)) AND used=FALSE RETURNING domain`,
+		tokenStr, time.Now().UTC().Format(sqlTimeFormat)).Scan(&domain)
 	return domain, err
 }
 
@@ -87,7 +100,7 @@ func (db *SQLDatabase) PutToken(domain string) (models.Token, error) {
 		Used:    false,
 	}
 	_, err := db.conn.Exec("INSERT INTO tokens(domain, token, expires) VALUES($1, $2, $3) "+
-		"ON CONFLICT (domain) DO UPDATE SET token=$2, expires=$3, used=FALSE",
+		"ON CONFLICT (domain) DO UPDATE SET token=$2, expires=$3, used=FALSE, submitted_token=NULL, submitted_expires=NULL",
 		domain, token.Token, token.Expires.UTC().Format(sqlTimeFormat))
 	if err != nil {
 		return models.Token{}, err
@@ -95,15 +108,170 @@ func (db *SQLDatabase) PutToken(domain string) (models.Token, error) {
 	return token, nil
 }
 
+// ResendToken generates a new validation token for a domain to resend,
+// unless its token was already resent limit times in the last period, in
+// which case it returns when it can next be resent. The token from the
+// domain's submission keeps working until it expires, so resends can't
+// break the submitter's link; tokens from earlier resends stop working.
+func (db *SQLDatabase) ResendToken(domain string, period time.Duration, limit int) (models.Token, time.Time, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return models.Token{}, time.Time{}, err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	var current models.Token
+	var resends int
+	var since, submittedExpires sql.NullTime
+	var submitted sql.NullString
+	err = tx.QueryRow(`SELECT token, expires, used, resends, resends_since, submitted_token, submitted_expires
+		FROM tokens WHERE domain=$1 FOR UPDATE`, domain).Scan(
+		&current.Token, &current.Expires, &current.Used, &resends, &since, &submitted, &submittedExpires)
+	if err != nil && err != sql.ErrNoRows {
+		return models.Token{}, time.Time{}, err
+	}
+	if !since.Valid || now.Sub(since.Time) >= period {
+		resends, since.Time = 0, now
+	}
+	if resends >= limit {
+		return models.Token{}, since.Time.Add(period), nil
+	}
+	if !submitted.Valid || !submittedExpires.Time.After(now) {
+		// The token being replaced is the submission's.
+		submitted = sql.NullString{String: current.Token, Valid: current.Token != "" && !current.Used}
+		submittedExpires = sql.NullTime{Time: current.Expires.UTC(), Valid: submitted.Valid}
+	}
+	token := models.Token{Domain: domain, Token: randToken(), Expires: now.Add(72 * time.Hour)}
+	var submittedExpiresStr sql.NullString
+	if submittedExpires.Valid {
+		submittedExpiresStr = sql.NullString{String: submittedExpires.Time.Format(sqlTimeFormat), Valid: true}
+	}
+	_, err = tx.Exec(`INSERT INTO tokens(domain, token, expires, used, resends, resends_since, submitted_token, submitted_expires)
+		VALUES($1, $2, $3, FALSE, $4, $5, $6, $7)
+		ON CONFLICT (domain) DO UPDATE SET token=$2, expires=$3, used=FALSE, resends=$4, resends_since=$5,
+		submitted_token=$6, submitted_expires=$7`,
+		domain, token.Token, token.Expires.UTC().Format(sqlTimeFormat), resends+1, since.Time.UTC().Format(sqlTimeFormat),
+		submitted, submittedExpiresStr)
+	if err != nil {
+		return models.Token{}, time.Time{}, err
+	}
+	return token, time.Time{}, tx.Commit()
+}
+
 // SCAN DB FUNCTIONS
 
+// scanInsert inserts scans into every column of the scans table that's
+// written when a scan is stored.
+const scanInsert = "INSERT INTO scans(domain, scandata, hostname_results, timestamp, version, mta_sts_mode, source, profile, grade, checker) VALUES"
+
+// scanColumnCount is the number of columns scanInsert inserts for each scan.
+const scanColumnCount = 10
+
+// MaxScanBatch is the most scans PutScans inserts, or UpdateScans updates, in
+// a single statement. Postgres accepts at most 65535 parameters per statement.
+const MaxScanBatch = 1000
+
 // PutScan inserts a new scan for a particular domain into the database.
 func (db *SQLDatabase) PutScan(scan models.Scan) error {
+	args, err := scanInsertArgs(scan)
+	if err != nil {
+		return err
+	}
+	_, err = db.execPrepared(scanInsertQuery(1), args...)
+	return err
+}
+
+// PutScans inserts many scans in a single transaction, up to MaxScanBatch of
+// them per statement. If any of them can't be stored, none are.
+func (db *SQLDatabase) PutScans(scans []models.Scan) error {
+	if len(scans) == 0 {
+		return nil
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for start := 0; start < len(scans); start += MaxScanBatch {
+		batch := scans[start:]
+		if len(batch) > MaxScanBatch {
+			batch = batch[:MaxScanBatch]
+		}
+		args := make([]interface{}, 0, len(batch)*scanColumnCount)
+		for _, scan := range batch {
+			scanArgs, err := scanInsertArgs(scan)
+			if err != nil {
+				return err
+			}
+			args = append(args, scanArgs...)
+		}
+		// Bulk scans are stored in batches of the same size, so their
+		// statement is worth preparing.
+		query := scanInsertQuery(len(batch))
+		stmt, err := db.stmts.prepare(query)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.execPrepared(stmt, query, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// scanInsertQuery returns the statement inserting n scans.
+func scanInsertQuery(n int) string {
+	var query strings.Builder
+	query.WriteString(scanInsert)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			query.WriteString(",")
+		}
+		query.WriteString(" (")
+		for column := 1; column <= scanColumnCount; column++ {
+			if column > 1 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", i*scanColumnCount+column)
+		}
+		query.WriteString(")")
+	}
+	return query.String()
+}
+
+// scanInsertArgs returns the values scanInsert stores for a scan. Grades are
+// also kept in a column, so scans can be queried by grade.
+func scanInsertArgs(scan models.Scan) ([]interface{}, error) {
+	scandata, hostnameResults, mtastsMode, err := scanColumns(scan)
+	if err != nil {
+		return nil, err
+	}
+	settings := ""
+	if scan.Checker != nil {
+		encoded, err := json.Marshal(scan.Checker)
+		if err != nil {
+			return nil, err
+		}
+		settings = string(encoded)
+	}
+	return []interface{}{scan.Domain, scandata, hostnameResults, scan.Timestamp.UTC().Format(sqlTimeFormat), scan.Version,
+		mtastsMode, scan.Source, scan.Profile, string(scan.Data.Grade), settings}, nil
+}
+
+// scanColumns returns the serialized scan data, every field of its hostname
+// results, and the MTA-STS mode column, for storing a scan.
+func scanColumns(scan models.Scan) (string, string, string, error) {
 	// Serialize scanData.Data for insertion into SQLdb!
 	// @TODO marshall scan adds extra fields - need a custom obj for this
 	byteArray, err := json.Marshal(scan.Data)
 	if err != nil {
-		return err
+		return "", "", "", err
+	}
+	// scandata only keeps the checks of each hostname result, so the rest of
+	// them, like certificates, are stored separately.
+	hostnameResults, err := checker.EncodeHostnameResults(scan.Data.HostnameResults)
+	if err != nil {
+		return "", "", "", err
 	}
 	// Extract MTA-STS Mode to column for querying by mode, eg. adoption stats.
 	// Note, this will include MTA-STS configurations that serve a parse-able
@@ -112,8 +280,127 @@ func (db *SQLDatabase) PutScan(scan models.Scan) error {
 	if scan.Data.MTASTSResult != nil {
 		mtastsMode = scan.Data.MTASTSResult.Mode
 	}
-	_, err = db.conn.Exec("INSERT INTO scans(domain, scandata, timestamp, version, mta_sts_mode) VALUES($1, $2, $3, $4, $5)",
-		scan.Domain, string(byteArray), scan.Timestamp.UTC().Format(sqlTimeFormat), scan.Version, mtastsMode)
+	return string(byteArray), string(hostnameResults), mtastsMode, nil
+}
+
+// decodeScanData decodes the scandata and hostname_results columns of a
+// stored scan into data. Scans stored before hostname_results was added
+// only have the checks of each hostname.
+func decodeScanData(rawScanData []byte, rawHostnameResults []byte, data *checker.DomainResult) error {
+	if err := json.Unmarshal(rawScanData, data); err != nil {
+		return err
+	}
+	if len(rawHostnameResults) == 0 {
+		return nil
+	}
+	results, err := checker.DecodeHostnameResults(rawHostnameResults)
+	if err != nil {
+		return err
+	}
+	data.HostnameResults = results
+	return nil
+}
+
+// decodeCheckerSettings decodes the checker column of a stored scan. Scans
+// stored before it was added don't record their checker.
+func decodeCheckerSettings(raw []byte) (*checker.Settings, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	settings := &checker.Settings{}
+	if err := json.Unmarshal(raw, settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// CountScans returns the number of stored scans.
+func (db *SQLDatabase) CountScans() (int, error) {
+	var count int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM scans").Scan(&count)
+	return count, err
+}
+
+// GetScanBatch retrieves up to limit stored scans with row IDs greater than
+// afterID, in order of ID, for walking through every stored scan. Returns the
+// scans with their IDs.
+func (db *SQLDatabase) GetScanBatch(afterID int64, limit int) ([]int64, []models.Scan, error) {
+	rows, err := db.conn.Query(
+		"SELECT id, "+storedScanColumns+" FROM scans "+
+			"WHERE id > $1 ORDER BY id LIMIT $2", afterID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	scans := []models.Scan{}
+	for rows.Next() {
+		var id int64
+		var scan models.Scan
+		var rawScanData, rawHostnameResults, rawSettings []byte
+		if err := rows.Scan(&id, &scan.Domain, &rawScanData, &rawHostnameResults, &scan.Timestamp, &scan.Version,
+			&scan.Source, &scan.Profile, &rawSettings); err != nil {
+			return nil, nil, err
+		}
+		if err := decodeScanData(rawScanData, rawHostnameResults, &scan.Data); err != nil {
+			return nil, nil, fmt.Errorf("scan %d: %v", id, err)
+		}
+		var err error
+		if scan.Checker, err = decodeCheckerSettings(rawSettings); err != nil {
+			return nil, nil, fmt.Errorf("scan %d: %v", id, err)
+		}
+		ids = append(ids, id)
+		scans = append(scans, scan)
+	}
+	return ids, scans, rows.Err()
+}
+
+// UpdateScan rewrites the data of the stored scan with row ID id, and the
+// columns derived from it.
+func (db *SQLDatabase) UpdateScan(id int64, scan models.Scan) error {
+	scandata, hostnameResults, mtastsMode, err := scanColumns(scan)
+	if err != nil {
+		return err
+	}
+	_, err = db.execPrepared("UPDATE scans SET scandata=$2, hostname_results=$3, mta_sts_mode=$4, grade=$5 WHERE id=$1",
+		id, scandata, hostnameResults, mtastsMode, string(scan.Data.Grade))
+	return err
+}
+
+// scanUpdateColumnCount is the number of values UpdateScans sets for each
+// scan, including its ID.
+const scanUpdateColumnCount = 5
+
+// UpdateScans rewrites the data of many stored scans, and the columns derived
+// from it, in a single statement. ids holds the row ID of each scan.
+func (db *SQLDatabase) UpdateScans(ids []int64, scans []models.Scan) error {
+	if len(ids) != len(scans) {
+		return fmt.Errorf("got %d IDs for %d scans", len(ids), len(scans))
+	}
+	if len(scans) == 0 {
+		return nil
+	}
+	if len(scans) > MaxScanBatch {
+		return fmt.Errorf("no more than %d scans can be updated at once", MaxScanBatch)
+	}
+	var values strings.Builder
+	args := make([]interface{}, 0, len(scans)*scanUpdateColumnCount)
+	for i, scan := range scans {
+		scandata, hostnameResults, mtastsMode, err := scanColumns(scan)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			values.WriteString(", ")
+		}
+		n := i * scanUpdateColumnCount
+		fmt.Fprintf(&values, "($%d::INTEGER, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+		args = append(args, ids[i], scandata, hostnameResults, mtastsMode, string(scan.Data.Grade))
+	}
+	_, err := db.execPrepared(`UPDATE scans SET scandata=v.scandata, hostname_results=v.hostname_results,
+		mta_sts_mode=v.mta_sts_mode, grade=v.grade
+		FROM (VALUES `+values.String()+`) AS v(id, scandata, hostname_results, mta_sts_mode, grade)
+		WHERE scans.id=v.id`, args...)
 	return err
 }
 
@@ -122,7 +409,7 @@ func (db *SQLDatabase) PutScan(scan models.Scan) error {
 func (db *SQLDatabase) GetStats(source string) (stats.Series, error) {
 	series := stats.Series{}
 	rows, err := db.conn.Query(
-		`SELECT time, source, with_mxs, mta_sts_testing, mta_sts_enforce
+		`SELECT time, source, with_mxs, mta_sts_testing, mta_sts_enforce, fingerprints
 		FROM aggregated_scans
 		WHERE source=$1
 		ORDER BY time`, source)
@@ -132,9 +419,20 @@ func (db *SQLDatabase) GetStats(source string) (stats.Series, error) {
 	defer rows.Close()
 	for rows.Next() {
 		var a checker.AggregatedScan
-		if err := rows.Scan(&a.Time, &a.Source, &a.WithMXs, &a.MTASTSTesting, &a.MTASTSEnforce); err != nil {
+		var rawFingerprints []byte
+		if err := rows.Scan(&a.Time, &a.Source, &a.WithMXs, &a.MTASTSTesting, &a.MTASTSEnforce, &rawFingerprints); err != nil {
 			return series, err
 		}
+		if len(rawFingerprints) > 0 {
+			var fingerprints aggregatedFingerprints
+			if err := json.Unmarshal(rawFingerprints, &fingerprints); err != nil {
+				return series, err
+			}
+			a.Software = fingerprints.Software
+			a.SoftwareWithoutSTARTTLS = fingerprints.SoftwareWithoutSTARTTLS
+			a.ExtensionSets = fingerprints.ExtensionSets
+			a.TLSFingerprints = fingerprints.TLSFingerprints
+		}
 		series = append(series, a)
 	}
 	return series, nil
@@ -173,44 +471,157 @@ func (db *SQLDatabase) PutLocalStats(date time.Time) (checker.AggregatedScan, er
 	return a, err
 }
 
+// GetScanCounts counts the scans performed since a time, by source and
+// status.
+func (db *SQLDatabase) GetScanCounts(since time.Time) ([]models.ScanCount, error) {
+	rows, err := db.conn.Query(`SELECT source, COALESCE((scandata::json->>'status')::integer, 0), COUNT(*)
+		FROM scans WHERE timestamp >= $1 GROUP BY 1, 2 ORDER BY 1, 2`,
+		since.UTC().Format(sqlTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := []models.ScanCount{}
+	for rows.Next() {
+		var c models.ScanCount
+		if err := rows.Scan(&c.Source, &c.Status, &c.Count); err != nil {
+			return counts, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// storedScanColumns are the columns readScan reads.
+const storedScanColumns = "domain, scandata, hostname_results, timestamp, version, source, profile, checker"
+
 const mostRecentQuery = `
-SELECT domain, scandata, timestamp, version FROM scans
+SELECT ` + storedScanColumns + ` FROM scans
     WHERE timestamp = (SELECT MAX(timestamp) FROM scans WHERE domain=$1)
 `
 
-// GetLatestScan retrieves the most recent scan performed on a particular email
-// domain.
-func (db SQLDatabase) GetLatestScan(domain string) (models.Scan, error) {
-	var rawScanData []byte
+// trustedScans selects the scans that can decide whether a domain may be
+// queued, as models.Scan.Trusted does: full scans requested through the API
+// or performed by the validator, or recorded before either was tracked.
+const trustedScans = "COALESCE(source, '') IN ('', 'api', 'validator') AND COALESCE(profile, '') IN ('', 'full')"
+
+const mostRecentTrustedQuery = `
+SELECT ` + storedScanColumns + ` FROM scans
+    WHERE domain=$1 AND ` + trustedScans + ` ORDER BY timestamp DESC LIMIT 1
+`
+
+// readScan reads a scan selected with storedScanColumns.
+func readScan(row interface{ Scan(...interface{}) error }) (models.Scan, error) {
+	var rawScanData, rawHostnameResults, rawSettings []byte
 	result := models.Scan{}
-	err := db.conn.QueryRow(mostRecentQuery, domain).Scan(
-		&result.Domain, &rawScanData, &result.Timestamp, &result.Version)
+	err := row.Scan(&result.Domain, &rawScanData, &rawHostnameResults,
+		&result.Timestamp, &result.Version, &result.Source, &result.Profile, &rawSettings)
 	if err != nil {
 		return result, err
 	}
-	err = json.Unmarshal(rawScanData, &result.Data)
+	if err = decodeScanData(rawScanData, rawHostnameResults, &result.Data); err != nil {
+		return result, err
+	}
+	result.Checker, err = decodeCheckerSettings(rawSettings)
 	return result, err
 }
 
+// GetLatestScan retrieves the most recent scan performed on a particular email
+// domain.
+func (db SQLDatabase) GetLatestScan(domain string) (models.Scan, error) {
+	return readScan(db.queryRowPrepared(mostRecentQuery, domain))
+}
+
+// GetLatestTrustedScan retrieves the most recent trusted scan of a domain:
+// a full scan requested through the API or performed by the validator.
+func (db SQLDatabase) GetLatestTrustedScan(domain string) (models.Scan, error) {
+	return readScan(db.queryRowPrepared(mostRecentTrustedQuery, domain))
+}
+
+// GetLatestScansOf retrieves the most recent scan of each of domains that has
+// been scanned, by domain.
+func (db SQLDatabase) GetLatestScansOf(domains []string) (map[string]models.Scan, error) {
+	rows, err := db.conn.Query(
+		"SELECT DISTINCT ON (domain) "+storedScanColumns+" FROM scans "+
+			"WHERE domain = ANY($1) ORDER BY domain, timestamp DESC", pq.Array(domains))
+	if err != nil {
+		return nil, err
+	}
+	scans, err := scanRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	byDomain := make(map[string]models.Scan, len(scans))
+	for _, scan := range scans {
+		byDomain[scan.Domain] = scan
+	}
+	return byDomain, nil
+}
+
+// GetLatestScanWithHostname retrieves the most recent scan that checked a
+// particular MX hostname, of any domain. The hostname matches with or without
+// a trailing dot, since MX records have one.
+func (db SQLDatabase) GetLatestScanWithHostname(hostname string) (models.Scan, error) {
+	hostname = strings.TrimSuffix(hostname, ".")
+	return readScan(db.conn.QueryRow(
+		"SELECT "+storedScanColumns+" FROM scans "+
+			"WHERE (scandata::jsonb->'results') ?| $1 ORDER BY timestamp DESC LIMIT 1",
+		pq.Array([]string{hostname, hostname + "."})))
+}
+
 // GetAllScans retrieves all the scans performed for a particular domain.
 func (db SQLDatabase) GetAllScans(domain string) ([]models.Scan, error) {
 	rows, err := db.conn.Query(
-		"SELECT domain, scandata, timestamp, version FROM scans WHERE domain=$1", domain)
+		"SELECT "+storedScanColumns+" FROM scans WHERE domain=$1", domain)
+	if err != nil {
+		return nil, err
+	}
+	return scanRows(rows)
+}
+
+// GetLatestScans retrieves up to n of the most recent scans performed for a
+// particular domain, most recent first.
+func (db SQLDatabase) GetLatestScans(domain string, n int) ([]models.Scan, error) {
+	rows, err := db.conn.Query(
+		"SELECT "+storedScanColumns+" FROM scans "+
+			"WHERE domain=$1 ORDER BY timestamp DESC LIMIT $2", domain, n)
 	if err != nil {
 		return nil, err
 	}
+	return scanRows(rows)
+}
+
+// GetLatestTrustedScans retrieves up to n of the most recent trusted scans of
+// a domain, most recent first.
+func (db SQLDatabase) GetLatestTrustedScans(domain string, n int) ([]models.Scan, error) {
+	rows, err := db.conn.Query(
+		"SELECT "+storedScanColumns+" FROM scans "+
+			"WHERE domain=$1 AND "+trustedScans+" ORDER BY timestamp DESC LIMIT $2", domain, n)
+	if err != nil {
+		return nil, err
+	}
+	return scanRows(rows)
+}
+
+// GetScanAt retrieves the most recent scan performed for a particular domain
+// at or before t.
+func (db SQLDatabase) GetScanAt(domain string, t time.Time) (models.Scan, error) {
+	return readScan(db.conn.QueryRow(
+		"SELECT "+storedScanColumns+" FROM scans "+
+			"WHERE domain=$1 AND timestamp <= $2 ORDER BY timestamp DESC LIMIT 1", domain, t.UTC().Format(sqlTimeFormat)))
+}
+
+func scanRows(rows *timeoutRows) ([]models.Scan, error) {
 	defer rows.Close()
 	scans := []models.Scan{}
 	for rows.Next() {
-		var scan models.Scan
-		var rawScanData []byte
-		if err := rows.Scan(&scan.Domain, &rawScanData, &scan.Timestamp, &scan.Version); err != nil {
+		scan, err := readScan(rows)
+		if err != nil {
 			return nil, err
 		}
-		err = json.Unmarshal(rawScanData, &scan.Data)
 		scans = append(scans, scan)
 	}
-	return scans, nil
+	return scans, rows.Err()
 }
 
 // =============== models.DomainStore impl ===============
@@ -220,11 +631,11 @@ func (db SQLDatabase) GetAllScans(domain string) ([]models.Scan, error) {
 // If there is already a domain in the database with StateUnconfirmed, performs
 // an update of the fields.
 func (db *SQLDatabase) PutDomain(domain models.Domain) error {
-	_, err := db.conn.Exec("INSERT INTO domains(domain, email, data, status, queue_weeks, mta_sts) "+
-		"VALUES($1, $2, $3, $4, $5, $6) "+
-		"ON CONFLICT ON CONSTRAINT domains_pkey DO UPDATE SET email=$2, data=$3, queue_weeks=$5",
+	_, err := db.conn.Exec("INSERT INTO domains(domain, email, data, status, queue_weeks, mta_sts, mta_sts_mode) "+
+		"VALUES($1, $2, $3, $4, $5, $6, $7) "+
+		"ON CONFLICT ON CONSTRAINT domains_pkey DO UPDATE SET email=$2, data=$3, queue_weeks=$5, mta_sts_mode=$7",
 		domain.Name, domain.Email, strings.Join(domain.MXs[:], ","),
-		models.StateUnconfirmed, domain.QueueWeeks, domain.MTASTS)
+		models.StateUnconfirmed, domain.QueueWeeks, domain.MTASTS, domain.MTASTSMode)
 	return err
 }
 
@@ -240,40 +651,616 @@ func (db SQLDatabase) GetDomains(state models.DomainState) ([]models.Domain, err
 	return db.queryDomainsWhere("status=$1", state)
 }
 
+// GetDomainPage retrieves a page of the domains in a particular state.
+func (db SQLDatabase) GetDomainPage(state models.DomainState, page models.DomainPage) (models.DomainList, error) {
+	list := models.DomainList{Domains: []models.Domain{}}
+	lastUpdated, name, err := page.Cursor()
+	if err != nil {
+		return list, err
+	}
+	condition := "status=$1"
+	args := []interface{}{state}
+	if page.Sort == models.SortByLastUpdated {
+		if page.After != "" {
+			condition += " AND (last_updated, domain) < ($2, $3)"
+			args = append(args, lastUpdated.Format("2006-01-02 15:04:05.999999"), name)
+		}
+		condition += " ORDER BY last_updated DESC, domain DESC"
+	} else {
+		if page.After != "" {
+			condition += " AND domain > $2"
+			args = append(args, name)
+		}
+		condition += " ORDER BY domain"
+	}
+	if page.Limit > 0 {
+		// Fetch one more to know whether there's a next page.
+		condition += fmt.Sprintf(" LIMIT %d", page.Limit+1)
+	}
+	list.Domains, err = db.queryDomainsWhere(condition, args...)
+	if err != nil {
+		return list, err
+	}
+	if page.Limit > 0 && len(list.Domains) > page.Limit {
+		list.Domains = list.Domains[:page.Limit]
+		list.Next = page.NextCursor(list.Domains[page.Limit-1])
+	}
+	return list, nil
+}
+
+// SearchDomains retrieves the domains whose names match search, sorted by
+// name, in any of states, or in any state if none are given.
+func (db SQLDatabase) SearchDomains(search models.DomainSearch, states ...models.DomainState) ([]models.Domain, error) {
+	condition := `domain LIKE $1 ESCAPE '\'`
+	args := []interface{}{search.Pattern()}
+	if len(states) > 0 {
+		placeholders := make([]string, len(states))
+		for i, state := range states {
+			args = append(args, state)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		condition += fmt.Sprintf(" AND status IN (%s)", strings.Join(placeholders, ", "))
+	}
+	condition += " ORDER BY domain, status"
+	if search.Limit > 0 {
+		condition += fmt.Sprintf(" LIMIT %d", search.Limit)
+	}
+	return db.queryDomainsWhere(condition, args...)
+}
+
 // GetMTASTSDomains retrieves domains which wish their policy to be queued with their MTASTS.
 func (db SQLDatabase) GetMTASTSDomains() ([]models.Domain, error) {
 	return db.queryDomainsWhere("mta_sts=TRUE")
 }
 
-// SetStatus sets the status of a particular domain object to |state|.
-func (db SQLDatabase) SetStatus(domain string, state models.DomainState) error {
-	var testingStart time.Time
-	if state == models.StateTesting {
+// SetStatus sets the status of a particular domain object to |state|, and
+// records the change in the audit log, in the same transaction.
+func (db SQLDatabase) SetStatus(domain string, state models.DomainState, change models.StateChange) error {
+	var testingStart, removalRequested time.Time
+	switch state {
+	case models.StateTesting:
 		testingStart = time.Now()
+	case models.StatePendingRemoval:
+		removalRequested = time.Now()
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT status FROM domains WHERE domain=$1 FOR UPDATE", domain)
+	if err != nil {
+		return err
+	}
+	var previous []models.DomainState
+	for rows.Next() {
+		var from models.DomainState
+		if err = rows.Scan(&from); err != nil {
+			rows.Close()
+			return err
+		}
+		previous = append(previous, from)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE domains SET status = $1, testing_start = $2, removal_requested = $3 WHERE domain=$4",
+		state, testingStart, removalRequested, domain)
+	if err != nil {
+		return err
+	}
+	for _, from := range previous {
+		if from == state {
+			continue
+		}
+		if _, err = putAuditEntry(tx, change.AuditEntry(domain, from, state)); err != nil {
+			return err
+		}
+		if err = queueWebhookDeliveries(tx, domain, from, state, change); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ImportDomains inserts new domains straight into their states in a single
+// transaction, and records each in the audit log as moving from unconfirmed,
+// as if it had been submitted. If any of them can't be inserted, eg. because
+// it's already stored, none are.
+func (db SQLDatabase) ImportDomains(domains []models.Domain, change models.StateChange) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, domain := range domains {
+		var testingStart time.Time
+		if domain.State == models.StateTesting {
+			testingStart = time.Now()
+		}
+		_, err = tx.Exec("INSERT INTO domains(domain, email, data, status, queue_weeks, mta_sts, mta_sts_mode, testing_start) "+
+			"VALUES($1, $2, $3, $4, $5, $6, $7, $8)",
+			domain.Name, domain.Email, strings.Join(domain.MXs, ","), domain.State,
+			domain.QueueWeeks, domain.MTASTS, domain.MTASTSMode, testingStart)
+		if err != nil {
+			return err
+		}
+		if _, err = putAuditEntry(tx, change.AuditEntry(domain.Name, models.StateUnconfirmed, domain.State)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateDomain sets the MXs, queue weeks and MTA-STS setting of the domain
+// in domain.State, if it hasn't been updated since lastUpdated. Returns the
+// updated domain, or sql.ErrNoRows if it's been updated or removed since.
+func (db SQLDatabase) UpdateDomain(domain models.Domain, lastUpdated time.Time) (models.Domain, error) {
+	return db.queryDomain("UPDATE domains SET data=$1, queue_weeks=$2, mta_sts=$3 "+
+		"WHERE domain=$4 AND status=$5 AND last_updated=$6 RETURNING %s",
+		strings.Join(domain.MXs, ","), domain.QueueWeeks, domain.MTASTS, domain.Name, domain.State, lastUpdated)
+}
+
+// SetMTASTSMode [interface Validator] records the mode of the MTA-STS policy
+// of a domain that's queued or on the list via MTA-STS. Returns true if the
+// mode changed.
+func (db SQLDatabase) SetMTASTSMode(domain string, mode string) (bool, error) {
+	result, err := db.conn.Exec("UPDATE domains SET mta_sts_mode=$2 "+
+		"WHERE domain=$1 AND mta_sts=TRUE AND status IN ($3, $4) AND mta_sts_mode IS DISTINCT FROM $2",
+		domain, mode, models.StateTesting, models.StateEnforce)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	return updated > 0, err
+}
+
+// RemoveDomain removes a particular domain and returns it, and records the
+// removal in the audit log and queues its webhook deliveries, in the same
+// transaction.
+func (db SQLDatabase) RemoveDomain(domain string, state models.DomainState, change models.StateChange) (models.Domain, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return models.Domain{}, err
+	}
+	defer tx.Rollback()
+	removed, err := scanDomain(tx.QueryRow(fmt.Sprintf(
+		"DELETE FROM domains WHERE domain=$1 AND status=$2 RETURNING %s", domainColumns), domain, state))
+	if err != nil {
+		return removed, err
+	}
+	if _, err = putAuditEntry(tx, change.AuditEntry(domain, state, "")); err != nil {
+		return removed, err
+	}
+	if err = queueWebhookDeliveries(tx, domain, state, "", change); err != nil {
+		return removed, err
+	}
+	return removed, tx.Commit()
+}
+
+// PutPromotion stores the evidence for a domain's promotion attempt, and
+// returns it with its ID set.
+func (db SQLDatabase) PutPromotion(p models.Promotion) (models.Promotion, error) {
+	evidence, err := json.Marshal(p)
+	if err != nil {
+		return p, err
+	}
+	err = db.conn.QueryRow(`INSERT INTO promotions(domain, timestamp, promoted, evidence)
+		VALUES($1, $2, $3, $4) RETURNING id`,
+		p.Domain, p.Time.UTC().Format(sqlTimeFormat), p.Promoted, string(evidence)).Scan(&p.ID)
+	return p, err
+}
+
+// GetPromotions retrieves a domain's promotion attempts, most recent first.
+func (db SQLDatabase) GetPromotions(domain string) ([]models.Promotion, error) {
+	rows, err := db.conn.Query(`SELECT id, evidence FROM promotions
+		WHERE domain=$1 ORDER BY timestamp DESC`, domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	promotions := []models.Promotion{}
+	for rows.Next() {
+		var p models.Promotion
+		var id int64
+		var evidence []byte
+		if err := rows.Scan(&id, &evidence); err != nil {
+			return promotions, err
+		}
+		if err := json.Unmarshal(evidence, &p); err != nil {
+			return promotions, err
+		}
+		p.ID = id
+		promotions = append(promotions, p)
+	}
+	return promotions, rows.Err()
+}
+
+// MODERATION DB FUNCTIONS
+
+const moderationColumns = "domain, reasons, flagged, decision, reviewer, note, decided"
+
+func scanModeration(row interface{ Scan(...interface{}) error }) (models.Moderation, error) {
+	var m models.Moderation
+	var reasons []byte
+	var decided *time.Time
+	err := row.Scan(&m.Domain, &reasons, &m.Flagged, &m.Decision, &m.Reviewer, &m.Note, &decided)
+	if err != nil {
+		return m, err
+	}
+	if decided != nil {
+		m.Decided = *decided
+	}
+	return m, json.Unmarshal(reasons, &m.Reasons)
+}
+
+// PutModeration holds a domain's submission for review, replacing any earlier
+// decision on it.
+func (db SQLDatabase) PutModeration(m models.Moderation) error {
+	reasons, err := json.Marshal(m.Reasons)
+	if err != nil {
+		return err
+	}
+	_, err = db.conn.Exec(`INSERT INTO moderation(domain, reasons, flagged) VALUES($1, $2, $3)
+		ON CONFLICT (domain) DO UPDATE SET reasons=$2, flagged=$3,
+		decision='', reviewer='', note='', decided=NULL`,
+		m.Domain, string(reasons), m.Flagged.UTC().Format(sqlTimeFormat))
+	return err
+}
+
+// GetPendingModerations retrieves the submissions awaiting review, oldest
+// first.
+func (db SQLDatabase) GetPendingModerations() ([]models.Moderation, error) {
+	rows, err := db.conn.Query(`SELECT ` + moderationColumns + ` FROM moderation
+		WHERE decision='' ORDER BY flagged`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pending := []models.Moderation{}
+	for rows.Next() {
+		m, err := scanModeration(rows)
+		if err != nil {
+			return pending, err
+		}
+		pending = append(pending, m)
 	}
-	_, err := db.conn.Exec("UPDATE domains SET status = $1, testing_start = $2 WHERE domain=$3",
-		state, testingStart, domain)
+	return pending, rows.Err()
+}
+
+// DecideModeration records a reviewer's decision on a pending submission.
+// Returns sql.ErrNoRows if the domain has no submission awaiting review.
+func (db SQLDatabase) DecideModeration(domain string, decision string, reviewer string, note string) (models.Moderation, error) {
+	return scanModeration(db.conn.QueryRow(`UPDATE moderation
+		SET decision=$2, reviewer=$3, note=$4, decided=$5
+		WHERE domain=$1 AND decision='' RETURNING `+moderationColumns,
+		domain, decision, reviewer, note, time.Now().UTC().Format(sqlTimeFormat)))
+}
+
+// SUBMISSION BLOCKLIST DB FUNCTIONS
+
+const submissionBlockColumns = "id, kind, pattern, reason, created"
+
+func scanSubmissionBlock(row interface{ Scan(...interface{}) error }) (models.SubmissionBlock, error) {
+	var b models.SubmissionBlock
+	err := row.Scan(&b.ID, &b.Kind, &b.Pattern, &b.Reason, &b.Created)
+	return b, err
+}
+
+// PutSubmissionBlock blocks queue submissions matching b, replacing the
+// reason for an existing block of the same pattern.
+func (db SQLDatabase) PutSubmissionBlock(b models.SubmissionBlock) (models.SubmissionBlock, error) {
+	return scanSubmissionBlock(db.conn.QueryRow(`INSERT INTO submission_blocks(kind, pattern, reason)
+		VALUES($1, $2, $3) ON CONFLICT (kind, pattern) DO UPDATE SET reason=$3
+		RETURNING `+submissionBlockColumns, b.Kind, b.Pattern, b.Reason))
+}
+
+// GetSubmissionBlocks retrieves every submission block, oldest first.
+func (db SQLDatabase) GetSubmissionBlocks() ([]models.SubmissionBlock, error) {
+	rows, err := db.conn.Query("SELECT " + submissionBlockColumns + " FROM submission_blocks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	blocks := []models.SubmissionBlock{}
+	for rows.Next() {
+		b, err := scanSubmissionBlock(rows)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// RemoveSubmissionBlock removes the submission block with the given ID.
+// Returns sql.ErrNoRows if there isn't one.
+func (db SQLDatabase) RemoveSubmissionBlock(id int64) (models.SubmissionBlock, error) {
+	return scanSubmissionBlock(db.conn.QueryRow(
+		"DELETE FROM submission_blocks WHERE id=$1 RETURNING "+submissionBlockColumns, id))
+}
+
+// WEBHOOK DB FUNCTIONS
+
+const webhookColumns = "id, domain, url, owner, created"
+
+func scanWebhook(row interface{ Scan(...interface{}) error }, secret *string) (models.Webhook, error) {
+	var w models.Webhook
+	dest := []interface{}{&w.ID, &w.Domain, &w.URL, &w.Owner, &w.Created}
+	if secret != nil {
+		dest = append(dest, secret)
+	}
+	err := row.Scan(dest...)
+	return w, err
+}
+
+// PutWebhook registers a webhook, and returns it with its ID set.
+func (db SQLDatabase) PutWebhook(w models.Webhook) (models.Webhook, error) {
+	stored, err := scanWebhook(db.conn.QueryRow(`INSERT INTO webhooks(domain, url, owner, secret)
+		VALUES($1, $2, $3, $4) RETURNING `+webhookColumns, w.Domain, w.URL, w.Owner, w.Secret), nil)
+	stored.Secret = w.Secret
+	return stored, err
+}
+
+// GetWebhook retrieves a webhook, including its secret.
+func (db SQLDatabase) GetWebhook(id int64) (models.Webhook, error) {
+	var secret string
+	w, err := scanWebhook(db.conn.QueryRow(
+		"SELECT "+webhookColumns+", secret FROM webhooks WHERE id=$1", id), &secret)
+	w.Secret = secret
+	return w, err
+}
+
+// GetWebhooks retrieves the webhooks registered for a domain, oldest first,
+// without their secrets.
+func (db SQLDatabase) GetWebhooks(domain string) ([]models.Webhook, error) {
+	rows, err := db.conn.Query("SELECT "+webhookColumns+" FROM webhooks WHERE domain=$1 ORDER BY id", domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []models.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows, nil)
+		if err != nil {
+			return hooks, err
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+// RemoveWebhook removes a webhook and its deliveries. Returns sql.ErrNoRows
+// if there isn't one.
+func (db SQLDatabase) RemoveWebhook(id int64) (models.Webhook, error) {
+	return scanWebhook(db.conn.QueryRow("DELETE FROM webhooks WHERE id=$1 RETURNING "+webhookColumns, id), nil)
+}
+
+const webhookDeliveryColumns = "id, webhook_id, domain, from_state, to_state, reason, timestamp, status, attempts, next_attempt, response_code, error"
+
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := row.Scan(&d.ID, &d.WebhookID, &d.Domain, &d.From, &d.To, &d.Reason, &d.Time, &d.Status,
+		&d.Attempts, &d.NextAttempt, &d.ResponseCode, &d.Error)
+	return d, err
+}
+
+func scanWebhookDeliveries(rows *timeoutRows, err error) ([]models.WebhookDelivery, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// queueWebhookDeliveries queues the delivery of domain's change of state to
+// each of its webhooks, in the transaction making the change.
+func queueWebhookDeliveries(tx *timeoutTx, domain string, from models.DomainState, to models.DomainState, change models.StateChange) error {
+	now := time.Now().UTC().Format(sqlTimeFormat)
+	_, err := tx.Exec(`INSERT INTO webhook_deliveries(webhook_id, domain, from_state, to_state, reason,
+		timestamp, status, next_attempt) SELECT id, domain, $2, $3, $4, $5, $6, $5 FROM webhooks WHERE domain=$1`,
+		domain, from, to, change.Reason, now, models.DeliveryPending)
 	return err
 }
 
-// RemoveDomain removes a particular domain and returns it.
-func (db SQLDatabase) RemoveDomain(domain string, state models.DomainState) (models.Domain, error) {
-	return db.queryDomain("DELETE FROM domains WHERE domain=$1 AND status=$2 RETURNING %s")
+// GetWebhookDeliveries retrieves up to limit of a webhook's deliveries, most
+// recent first.
+func (db SQLDatabase) GetWebhookDeliveries(webhookID int64, limit int) ([]models.WebhookDelivery, error) {
+	return scanWebhookDeliveries(db.conn.Query("SELECT "+webhookDeliveryColumns+
+		" FROM webhook_deliveries WHERE webhook_id=$1 ORDER BY id DESC LIMIT $2", webhookID, limit))
+}
+
+// GetDueWebhookDeliveries retrieves up to limit pending deliveries due to be
+// attempted by a given time, oldest first.
+func (db SQLDatabase) GetDueWebhookDeliveries(now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	return scanWebhookDeliveries(db.conn.Query("SELECT "+webhookDeliveryColumns+
+		" FROM webhook_deliveries WHERE status=$1 AND next_attempt <= $2 ORDER BY id LIMIT $3",
+		models.DeliveryPending, now.UTC().Format(sqlTimeFormat), limit))
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt to deliver d.
+func (db SQLDatabase) UpdateWebhookDelivery(d models.WebhookDelivery) error {
+	_, err := db.conn.Exec(`UPDATE webhook_deliveries SET status=$2, attempts=$3, next_attempt=$4,
+		response_code=$5, error=$6 WHERE id=$1`, d.ID, d.Status, d.Attempts,
+		d.NextAttempt.UTC().Format(sqlTimeFormat), d.ResponseCode, d.Error)
+	return err
+}
+
+// AUDIT LOG DB FUNCTIONS
+
+// PutAuditEntry appends an entry to the audit log, and returns it with its ID
+// set. If the entry's time isn't set, it's the current time.
+func (db SQLDatabase) PutAuditEntry(e models.AuditEntry) (models.AuditEntry, error) {
+	return putAuditEntry(db.conn, e)
+}
+
+// putAuditEntry appends an entry to the audit log through q, which may be a
+// transaction making the change that the entry records.
+func putAuditEntry(q interface {
+	QueryRow(string, ...interface{}) *timeoutRow
+}, e models.AuditEntry) (models.AuditEntry, error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	err := q.QueryRow(`INSERT INTO audit_log(timestamp, actor, action, subject, details)
+		VALUES($1, $2, $3, $4, $5) RETURNING id`,
+		e.Time.UTC().Format(sqlTimeFormat), e.Actor, e.Action, e.Subject, e.Details).Scan(&e.ID)
+	return e, err
+}
+
+// GetAuditLog retrieves the audit log entries about a subject, most recent
+// first.
+func (db SQLDatabase) GetAuditLog(subject string) ([]models.AuditEntry, error) {
+	rows, err := db.conn.Query(`SELECT id, timestamp, actor, action, subject, details
+		FROM audit_log WHERE subject=$1 ORDER BY timestamp DESC, id DESC`, subject)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.Action, &e.Subject, &e.Details); err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetAuditLogSince retrieves the audit log entries since a time, oldest
+// first.
+func (db SQLDatabase) GetAuditLogSince(since time.Time) ([]models.AuditEntry, error) {
+	rows, err := db.conn.Query(`SELECT id, timestamp, actor, action, subject, details
+		FROM audit_log WHERE timestamp >= $1 ORDER BY timestamp, id`, since.UTC().Format(sqlTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.Action, &e.Subject, &e.Details); err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// LIST PUBLICATION DB FUNCTIONS
+
+// AcquireLease takes or renews the named lease for holder, until ttl from
+// now. Returns false if another holder's lease hasn't expired.
+func (db SQLDatabase) AcquireLease(name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	var current string
+	err := db.conn.QueryRow(`INSERT INTO leases(name, holder, expires) VALUES($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET holder=$2, expires=$3
+		WHERE leases.holder=$2 OR leases.expires < $4 RETURNING holder`,
+		name, holder, now.Add(ttl).Format(sqlTimeFormat), now.Format(sqlTimeFormat)).Scan(&current)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// PutPublication stores a new version of the published policy list.
+func (db SQLDatabase) PutPublication(p policy.Publication) error {
+	_, err := db.conn.Exec(`INSERT INTO list_publications(sha256, body, published) VALUES($1, $2, $3)`,
+		p.SHA256, string(p.Body), p.Published.UTC().Format(sqlTimeFormat))
+	return err
+}
+
+// GetPublication retrieves the latest version of the published policy list,
+// or sql.ErrNoRows if none has been published.
+func (db SQLDatabase) GetPublication() (policy.Publication, error) {
+	var p policy.Publication
+	var body string
+	err := db.conn.QueryRow(`SELECT sha256, body, published FROM list_publications
+		ORDER BY id DESC LIMIT 1`).Scan(&p.SHA256, &body, &p.Published)
+	p.Body = []byte(body)
+	return p, err
+}
+
+// OPERATIONS REPORT DB FUNCTIONS
+
+// PutReport stores an operations report, and returns it with its ID set.
+func (db SQLDatabase) PutReport(r report.Report) (report.Report, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+	err = db.conn.QueryRow(`INSERT INTO reports(period, period_start, period_end, data) VALUES($1, $2, $3, $4)
+		RETURNING id`, string(r.Period),
+		r.Start.UTC().Format(sqlTimeFormat), r.End.UTC().Format(sqlTimeFormat), string(data)).Scan(&r.ID)
+	return r, err
+}
+
+// GetLatestReport retrieves the operations report for the most recent period
+// of a kind, or of any kind if period is empty.
+func (db SQLDatabase) GetLatestReport(period report.Period) (report.Report, error) {
+	var r report.Report
+	var data string
+	err := db.conn.QueryRow(`SELECT id, data FROM reports WHERE $1 = '' OR period = $1
+		ORDER BY period_end DESC, id DESC LIMIT 1`, string(period)).Scan(&r.ID, &data)
+	if err != nil {
+		return r, err
+	}
+	id := r.ID
+	err = json.Unmarshal([]byte(data), &r)
+	r.ID = id
+	return r, err
+}
+
+// PutValidatorRun stores the summary of a validator's latest run, replacing
+// the previous one.
+func (db SQLDatabase) PutValidatorRun(name string, summary validator.RunSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	_, err = db.conn.Exec(`INSERT INTO validator_runs(name, time, data) VALUES($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET time=$2, data=$3`,
+		name, summary.Time.UTC().Format(sqlTimeFormat), string(data))
+	return err
+}
+
+// GetValidatorRun retrieves the summary of a validator's latest run.
+func (db SQLDatabase) GetValidatorRun(name string) (validator.RunSummary, error) {
+	var summary validator.RunSummary
+	var data string
+	err := db.conn.QueryRow(`SELECT data FROM validator_runs WHERE name=$1`, name).Scan(&data)
+	if err != nil {
+		return summary, err
+	}
+	err = json.Unmarshal([]byte(data), &summary)
+	return summary, err
 }
 
 // EMAIL BLACKLIST DB FUNCTIONS
 
-// PutBlacklistedEmail adds a bounce or complaint notification to the email blacklist.
+// PutBlacklistedEmail adds a bounce, complaint or unsubscribe to the email blacklist.
 func (db SQLDatabase) PutBlacklistedEmail(email string, reason string, timestamp string) error {
 	_, err := db.conn.Exec("INSERT INTO blacklisted_emails(email, reason, timestamp) VALUES($1, $2, $3)",
 		email, reason, timestamp)
 	return err
 }
 
-// IsBlacklistedEmail returns true iff we've blacklisted the passed email address for sending.
+// IsBlacklistedEmail returns true iff we've blacklisted the passed email address
+// for sending, ignoring case.
 func (db SQLDatabase) IsBlacklistedEmail(email string) (bool, error) {
 	var count int
-	row := db.conn.QueryRow("SELECT COUNT(*) FROM blacklisted_emails WHERE email=$1", email)
+	row := db.conn.QueryRow("SELECT COUNT(*) FROM blacklisted_emails WHERE LOWER(email)=LOWER($1)", email)
 	err := row.Scan(&count)
 	if err != nil {
 		return false, err
@@ -300,16 +1287,40 @@ func (db SQLDatabase) ClearTables() error {
 		fmt.Sprintf("DELETE FROM %s", "hostname_scans"),
 		fmt.Sprintf("DELETE FROM %s", "blacklisted_emails"),
 		fmt.Sprintf("DELETE FROM %s", "aggregated_scans"),
+		fmt.Sprintf("DELETE FROM %s", "promotions"),
+		fmt.Sprintf("DELETE FROM %s", "moderation"),
+		fmt.Sprintf("TRUNCATE %s", "audit_log"),
+		fmt.Sprintf("DELETE FROM %s", "leases"),
+		fmt.Sprintf("DELETE FROM %s", "list_publications"),
+		fmt.Sprintf("DELETE FROM %s", "api_key_usage"),
+		fmt.Sprintf("DELETE FROM %s", "reports"),
+		fmt.Sprintf("DELETE FROM %s", "validator_runs"),
+		fmt.Sprintf("DELETE FROM %s", "api_keys"),
+		fmt.Sprintf("DELETE FROM %s", "api_key_tokens"),
+		fmt.Sprintf("DELETE FROM %s", "data_export_tokens"),
+		fmt.Sprintf("DELETE FROM %s", "data_erasure_tokens"),
+		fmt.Sprintf("DELETE FROM %s", "removal_tokens"),
+		fmt.Sprintf("DELETE FROM %s", "submission_blocks"),
+		fmt.Sprintf("DELETE FROM %s", "webhooks"),
 		fmt.Sprintf("ALTER SEQUENCE %s_id_seq RESTART WITH 1", db.cfg.DbScanTable),
 	})
 }
 
+// domainColumns are the columns of the domains table read by scanDomain.
+const domainColumns = "domain, email, data, status, last_updated, queue_weeks, mta_sts, mta_sts_mode, testing_start, removal_requested"
+
 func (db SQLDatabase) queryDomain(sqlQuery string, args ...interface{}) (models.Domain, error) {
-	query := fmt.Sprintf(sqlQuery, "domain, email, data, status, last_updated, queue_weeks")
+	return scanDomain(db.conn.QueryRow(fmt.Sprintf(sqlQuery, domainColumns), args...))
+}
+
+func scanDomain(row interface{ Scan(...interface{}) error }) (models.Domain, error) {
 	data := models.Domain{}
 	var rawMXs string
-	err := db.conn.QueryRow(query, args...).Scan(
-		&data.Name, &data.Email, &rawMXs, &data.State, &data.LastUpdated, &data.QueueWeeks)
+	var testingStart, removalRequested sql.NullTime
+	err := row.Scan(
+		&data.Name, &data.Email, &rawMXs, &data.State, &data.LastUpdated, &data.QueueWeeks, &data.MTASTS, &data.MTASTSMode, &testingStart, &removalRequested)
+	data.TestingStart = testingStart.Time
+	data.RemovalRequested = removalRequested.Time
 	data.MXs = strings.Split(rawMXs, ",")
 	if len(rawMXs) == 0 {
 		data.MXs = []string{}
@@ -318,7 +1329,7 @@ func (db SQLDatabase) queryDomain(sqlQuery string, args ...interface{}) (models.
 }
 
 func (db SQLDatabase) queryDomainsWhere(condition string, args ...interface{}) ([]models.Domain, error) {
-	query := fmt.Sprintf("SELECT domain, email, data, status, last_updated, queue_weeks FROM domains WHERE %s", condition)
+	query := fmt.Sprintf("SELECT %s FROM domains WHERE %s", domainColumns, condition)
 	rows, err := db.conn.Query(query, args...)
 	if err != nil {
 		return nil, err
@@ -328,9 +1339,12 @@ func (db SQLDatabase) queryDomainsWhere(condition string, args ...interface{}) (
 	for rows.Next() {
 		var domain models.Domain
 		var rawMXs string
-		if err := rows.Scan(&domain.Name, &domain.Email, &rawMXs, &domain.State, &domain.LastUpdated, &domain.QueueWeeks); err != nil {
+		var testingStart, removalRequested sql.NullTime
+		if err := rows.Scan(&domain.Name, &domain.Email, &rawMXs, &domain.State, &domain.LastUpdated, &domain.QueueWeeks, &domain.MTASTS, &domain.MTASTSMode, &testingStart, &removalRequested); err != nil {
 			return nil, err
 		}
+		domain.TestingStart = testingStart.Time
+		domain.RemovalRequested = removalRequested.Time
 		domain.MXs = strings.Split(rawMXs, ",")
 		domains = append(domains, domain)
 	}
@@ -371,7 +1385,7 @@ func (db *SQLDatabase) GetHostnameScan(hostname string) (checker.HostnameResult,
 		Result:   &checker.Result{},
 	}
 	var rawScanData []byte
-	err := db.conn.QueryRow(`SELECT timestamp, status, scandata FROM hostname_scans
+	err := db.queryRowPrepared(`SELECT timestamp, status, scandata FROM hostname_scans
                     WHERE hostname=$1 AND
                     timestamp=(SELECT MAX(timestamp) FROM hostname_scans WHERE hostname=$1)`,
 		hostname).Scan(&result.Timestamp, &result.Status, &rawScanData)
@@ -382,23 +1396,443 @@ func (db *SQLDatabase) GetHostnameScan(hostname string) (checker.HostnameResult,
 	return result, err
 }
 
+// GetHostnameScansSince retrieves the hostname scans made since a given
+// time, oldest first.
+func (db *SQLDatabase) GetHostnameScansSince(since time.Time) ([]checker.HostnameResult, error) {
+	rows, err := db.conn.Query(`SELECT hostname, timestamp, status, scandata FROM hostname_scans
+                    WHERE timestamp > $1 ORDER BY timestamp ASC, id ASC`,
+		since.UTC().Format(sqlTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []checker.HostnameResult{}
+	for rows.Next() {
+		result := checker.HostnameResult{Result: &checker.Result{}}
+		var rawScanData []byte
+		if err := rows.Scan(&result.Hostname, &result.Timestamp, &result.Status, &rawScanData); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(rawScanData, &result.Checks); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
 // PutHostnameScan puts this scan into the database.
 func (db *SQLDatabase) PutHostnameScan(hostname string, result checker.HostnameResult) error {
 	data, err := json.Marshal(result.Checks)
 	if err != nil {
 		return err
 	}
-	_, err = db.conn.Exec(`INSERT INTO hostname_scans(hostname, status, scandata)
+	_, err = db.execPrepared(`INSERT INTO hostname_scans(hostname, status, scandata)
                                 VALUES($1, $2, $3)`, hostname, result.Status, string(data))
 	return err
 }
 
+// aggregatedFingerprints are the fingerprint counts of an AggregatedScan,
+// stored in the fingerprints column.
+type aggregatedFingerprints struct {
+	Software                map[string]int `json:"software,omitempty"`
+	SoftwareWithoutSTARTTLS map[string]int `json:"software_without_starttls,omitempty"`
+	ExtensionSets           map[string]int `json:"extension_sets,omitempty"`
+	TLSFingerprints         map[string]int `json:"tls_fingerprints,omitempty"`
+}
+
 // PutAggregatedScan writes and AggregatedScan to the db.
 func (db *SQLDatabase) PutAggregatedScan(a checker.AggregatedScan) error {
+	// Only census runs that checked mailservers count fingerprints.
+	fingerprints := ""
+	if a.Software != nil {
+		encoded, err := json.Marshal(aggregatedFingerprints{
+			Software:                a.Software,
+			SoftwareWithoutSTARTTLS: a.SoftwareWithoutSTARTTLS,
+			ExtensionSets:           a.ExtensionSets,
+			TLSFingerprints:         a.TLSFingerprints,
+		})
+		if err != nil {
+			return err
+		}
+		fingerprints = string(encoded)
+	}
 	_, err := db.conn.Exec(`INSERT INTO
-		aggregated_scans(time, source, attempted, with_mxs, mta_sts_testing, mta_sts_enforce)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		aggregated_scans(time, source, attempted, with_mxs, mta_sts_testing, mta_sts_enforce, fingerprints)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (time,source) DO NOTHING`,
-		a.Time, a.Source, a.Attempted, a.WithMXs, a.MTASTSTesting, a.MTASTSEnforce)
+		a.Time, a.Source, a.Attempted, a.WithMXs, a.MTASTSTesting, a.MTASTSEnforce, fingerprints)
 	return err
 }
+
+// API KEY DB FUNCTIONS
+
+// PutAPIKeyToken generates and inserts a token for verifying an email address
+// before issuing it an API key, and returns the token.
+func (db *SQLDatabase) PutAPIKeyToken(email string) (string, error) {
+	token := randToken()
+	expires := time.Now().Add(time.Duration(time.Hour * 72))
+	_, err := db.conn.Exec("INSERT INTO api_key_tokens(email, token, expires) VALUES($1, $2, $3) "+
+		"ON CONFLICT (email) DO UPDATE SET token=$2, expires=$3, used=FALSE",
+		email, token, expires.UTC().Format(sqlTimeFormat))
+	return token, err
+}
+
+// UseAPIKeyToken marks an unexpired email verification token as used, and
+// returns the email address it was generated for.
+func (db *SQLDatabase) UseAPIKeyToken(token string) (string, error) {
+	var email string
+	err := db.conn.QueryRow(`UPDATE api_key_tokens SET used=TRUE
+		WHERE token=$1 AND used=FALSE AND expires > $2 RETURNING email`,
+		token, time.Now().UTC().Format(sqlTimeFormat)).Scan(&email)
+	return email, err
+}
+
+// PutDataExportToken generates and inserts a token for verifying an email
+// address before exporting the data stored about it, and returns the token.
+func (db *SQLDatabase) PutDataExportToken(email string) (string, error) {
+	token := randToken()
+	expires := time.Now().Add(time.Duration(time.Hour * 72))
+	_, err := db.conn.Exec("INSERT INTO data_export_tokens(email, token, expires) VALUES($1, $2, $3) "+
+		"ON CONFLICT (email) DO UPDATE SET token=$2, expires=$3, used=FALSE",
+		email, token, expires.UTC().Format(sqlTimeFormat))
+	return token, err
+}
+
+// UseDataExportToken marks an unexpired data export verification token as
+// used, and returns the email address it was generated for.
+func (db *SQLDatabase) UseDataExportToken(token string) (string, error) {
+	var email string
+	err := db.conn.QueryRow(`UPDATE data_export_tokens SET used=TRUE
+		WHERE token=$1 AND used=FALSE AND expires > $2 RETURNING email`,
+		token, time.Now().UTC().Format(sqlTimeFormat)).Scan(&email)
+	return email, err
+}
+
+// GetDataExport retrieves everything stored about a contact email address.
+// Domains' contact addresses are matched regardless of case.
+func (db *SQLDatabase) GetDataExport(email string) (models.DataExport, error) {
+	export := models.NewDataExport(email)
+	var err error
+	if export.Domains, err = db.queryDomainsWhere("LOWER(email)=LOWER($1) ORDER BY domain, status", email); err != nil {
+		return export, err
+	}
+	const submitted = "domain IN (SELECT domain FROM domains WHERE LOWER(email)=LOWER($1))"
+	rows, err := db.conn.Query("SELECT domain, expires, used FROM tokens WHERE "+submitted+" ORDER BY domain", email)
+	if err != nil {
+		return export, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.Token
+		if err = rows.Scan(&t.Domain, &t.Expires, &t.Used); err != nil {
+			return export, err
+		}
+		export.ValidationTokens = append(export.ValidationTokens, t)
+	}
+	rows, err = db.conn.Query("SELECT "+moderationColumns+" FROM moderation WHERE "+submitted+" ORDER BY domain", email)
+	if err != nil {
+		return export, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanModeration(rows)
+		if err != nil {
+			return export, err
+		}
+		export.Moderation = append(export.Moderation, m)
+	}
+	if export.APIKeys, err = db.GetAPIKeys(email); err != nil {
+		return export, err
+	}
+	rows, err = db.conn.Query(`SELECT $2::TEXT, expires, used FROM api_key_tokens WHERE email=$1
+		UNION ALL SELECT $3::TEXT, expires, used FROM data_export_tokens WHERE email=$1
+		UNION ALL SELECT $4::TEXT, expires, used FROM data_erasure_tokens WHERE email=$1`,
+		email, models.PurposeAPIKeys, models.PurposeDataExport, models.PurposeDataErasure)
+	if err != nil {
+		return export, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.EmailToken
+		if err = rows.Scan(&t.Purpose, &t.Expires, &t.Used); err != nil {
+			return export, err
+		}
+		export.EmailTokens = append(export.EmailTokens, t)
+	}
+	rows, err = db.conn.Query(`SELECT reason, timestamp FROM blacklisted_emails
+		WHERE LOWER(email)=LOWER($1) ORDER BY timestamp, id`, email)
+	if err != nil {
+		return export, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b models.EmailBounce
+		var timestamp sql.NullTime
+		if err = rows.Scan(&b.Reason, &timestamp); err != nil {
+			return export, err
+		}
+		b.Timestamp = timestamp.Time
+		export.Bounces = append(export.Bounces, b)
+	}
+	return export, rows.Err()
+}
+
+// PutDataErasureToken generates and inserts a token for verifying an email
+// address before erasing the data stored about it, and returns the token.
+func (db *SQLDatabase) PutDataErasureToken(email string) (string, error) {
+	token := randToken()
+	expires := time.Now().Add(time.Duration(time.Hour * 72))
+	_, err := db.conn.Exec("INSERT INTO data_erasure_tokens(email, token, expires) VALUES($1, $2, $3) "+
+		"ON CONFLICT (email) DO UPDATE SET token=$2, expires=$3, used=FALSE",
+		email, token, expires.UTC().Format(sqlTimeFormat))
+	return token, err
+}
+
+// UseDataErasureToken marks an unexpired data erasure verification token as
+// used, and returns the email address it was generated for.
+func (db *SQLDatabase) UseDataErasureToken(token string) (string, error) {
+	var email string
+	err := db.conn.QueryRow(`UPDATE data_erasure_tokens SET used=TRUE
+		WHERE token=$1 AND used=FALSE AND expires > $2 RETURNING email`,
+		token, time.Now().UTC().Format(sqlTimeFormat)).Scan(&email)
+	return email, err
+}
+
+// EraseEmail erases a contact email address on behalf of actor, in a single
+// transaction. Domains submitted with it as their contact are removed, along
+// with their validation tokens and moderation, unless they were confirmed,
+// in which case their contact is cleared. The address's API keys are revoked
+// and disowned, and its verification tokens deleted, and it's redacted from
+// the audit log. The bounces and complaints received for it are kept, so that
+// it's never emailed again. Each removal, and the erasure, is recorded in the
+// audit log.
+func (db *SQLDatabase) EraseEmail(email string, actor string) (models.Erasure, error) {
+	erasure := models.Erasure{Removed: []string{}, Anonymized: []string{}}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return erasure, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT domain, status FROM domains
+		WHERE LOWER(email)=LOWER($1) ORDER BY domain, status FOR UPDATE`, email)
+	if err != nil {
+		return erasure, err
+	}
+	var submitted []models.Domain
+	for rows.Next() {
+		var d models.Domain
+		if err = rows.Scan(&d.Name, &d.State); err != nil {
+			rows.Close()
+			return erasure, err
+		}
+		submitted = append(submitted, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return erasure, err
+	}
+	change := models.StateChange{Actor: actor, Reason: "contact erased on request"}
+	for _, d := range submitted {
+		if d.State.Confirmed() {
+			if _, err = tx.Exec("UPDATE domains SET email='' WHERE domain=$1 AND status=$2", d.Name, d.State); err != nil {
+				return erasure, err
+			}
+			erasure.Anonymized = append(erasure.Anonymized, d.Name)
+			continue
+		}
+		if _, err = tx.Exec("DELETE FROM domains WHERE domain=$1 AND status=$2", d.Name, d.State); err != nil {
+			return erasure, err
+		}
+		if _, err = tx.Exec("DELETE FROM tokens WHERE domain=$1", d.Name); err != nil {
+			return erasure, err
+		}
+		if _, err = tx.Exec("DELETE FROM moderation WHERE domain=$1", d.Name); err != nil {
+			return erasure, err
+		}
+		if _, err = putAuditEntry(tx, change.AuditEntry(d.Name, d.State, "")); err != nil {
+			return erasure, err
+		}
+		erasure.Removed = append(erasure.Removed, d.Name)
+	}
+	result, err := tx.Exec("UPDATE api_keys SET revoked=TRUE, email='' WHERE email=$1", email)
+	if err != nil {
+		return erasure, err
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return erasure, err
+	}
+	erasure.RevokedKeys = int(revoked)
+	for _, table := range []string{"api_key_tokens", "data_export_tokens", "data_erasure_tokens"} {
+		if _, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE email=$1", table), email); err != nil {
+			return erasure, err
+		}
+	}
+	if _, err = tx.Exec("SET LOCAL starttls.redact_audit_log = 'on'"); err != nil {
+		return erasure, err
+	}
+	pattern := regexp.QuoteMeta(email)
+	result, err = tx.Exec(`UPDATE audit_log SET actor=regexp_replace(actor, $1, $2, 'gi'),
+		subject=regexp_replace(subject, $1, $2, 'gi'), details=regexp_replace(details, $1, $2, 'gi')
+		WHERE actor ~* $1 OR subject ~* $1 OR details ~* $1`, pattern, models.ErasedAddress)
+	if err != nil {
+		return erasure, err
+	}
+	redacted, err := result.RowsAffected()
+	if err != nil {
+		return erasure, err
+	}
+	erasure.RedactedAuditEntries = int(redacted)
+	if _, err = putAuditEntry(tx, erasure.AuditEntry(actor)); err != nil {
+		return erasure, err
+	}
+	return erasure, tx.Commit()
+}
+
+// PutRemovalToken generates and inserts a token for verifying that a
+// domain's owner wants it removed from the list, and returns the token.
+func (db *SQLDatabase) PutRemovalToken(domain string) (string, error) {
+	token := randToken()
+	expires := time.Now().Add(time.Duration(time.Hour * 72))
+	_, err := db.conn.Exec("INSERT INTO removal_tokens(domain, token, expires) VALUES($1, $2, $3) "+
+		"ON CONFLICT (domain) DO UPDATE SET token=$2, expires=$3, used=FALSE",
+		domain, token, expires.UTC().Format(sqlTimeFormat))
+	return token, err
+}
+
+// UseRemovalToken marks an unexpired removal verification token as used, and
+// returns the domain it was generated for.
+func (db *SQLDatabase) UseRemovalToken(token string) (string, error) {
+	var domain string
+	err := db.conn.QueryRow(`UPDATE removal_tokens SET used=TRUE
+		WHERE token=$1 AND used=FALSE AND expires > $2 RETURNING domain`,
+		token, time.Now().UTC().Format(sqlTimeFormat)).Scan(&domain)
+	return domain, err
+}
+
+const apiKeyColumns = "id, email, name, scopes, created, last_used, requests, revoked, scan_quota"
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var lastUsed *time.Time
+	err := row.Scan(&key.ID, &key.Email, &key.Name, &scopes, &key.Created, &lastUsed,
+		&key.Requests, &key.Revoked, &key.ScanQuota)
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsed != nil {
+		key.LastUsed = *lastUsed
+	}
+	return key, err
+}
+
+// PutAPIKey stores a new API key under its hash, and returns it with its ID
+// and creation time set.
+func (db *SQLDatabase) PutAPIKey(key models.APIKey, hash string) (models.APIKey, error) {
+	row := db.conn.QueryRow(`INSERT INTO api_keys(email, name, scopes, key_hash)
+		VALUES($1, $2, $3, $4) RETURNING `+apiKeyColumns,
+		key.Email, key.Name, strings.Join(key.Scopes, ","), hash)
+	return scanAPIKey(row)
+}
+
+// GetAPIKeys retrieves all of the API keys owned by email.
+func (db *SQLDatabase) GetAPIKeys(email string) ([]models.APIKey, error) {
+	rows, err := db.conn.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE email=$1 ORDER BY id", email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// UseAPIKey retrieves the unrevoked API key with the given hash, and records
+// a request made with it.
+func (db *SQLDatabase) UseAPIKey(hash string) (models.APIKey, error) {
+	now := time.Now().UTC()
+	row := db.conn.QueryRow(`UPDATE api_keys SET requests=requests+1, last_used=$2
+		WHERE key_hash=$1 AND revoked=FALSE RETURNING `+apiKeyColumns,
+		hash, now.Format(sqlTimeFormat))
+	key, err := scanAPIKey(row)
+	if err != nil {
+		return key, err
+	}
+	_, err = db.conn.Exec(`INSERT INTO api_key_usage(key_id, day, requests) VALUES($1, $2, 1)
+		ON CONFLICT (key_id, day) DO UPDATE SET requests=api_key_usage.requests+1`,
+		key.ID, now.Format("2006-01-02"))
+	return key, err
+}
+
+// UseAPIKeyScan records a scan made with an API key, and returns the number
+// of scans made with it today. If the key's quota has been used up, the scan
+// isn't recorded and sql.ErrNoRows is returned.
+func (db *SQLDatabase) UseAPIKeyScan(key models.APIKey) (int64, error) {
+	var scans int64
+	err := db.conn.QueryRow(`INSERT INTO api_key_usage(key_id, day, scans) VALUES($1, $2, 1)
+		ON CONFLICT (key_id, day) DO UPDATE SET scans=api_key_usage.scans+1
+		WHERE $3 = 0 OR api_key_usage.scans < $3 RETURNING scans`,
+		key.ID, time.Now().UTC().Format("2006-01-02"), key.ScanQuota).Scan(&scans)
+	return scans, err
+}
+
+// GetAPIKeyUsage retrieves an API key's usage on each day since a given
+// time, ordered by day.
+func (db *SQLDatabase) GetAPIKeyUsage(id int64, since time.Time) ([]models.APIKeyUsage, error) {
+	rows, err := db.conn.Query(`SELECT day, requests, scans FROM api_key_usage
+		WHERE key_id=$1 AND day >= $2 ORDER BY day`,
+		id, since.UTC().Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	usage := []models.APIKeyUsage{}
+	for rows.Next() {
+		var day models.APIKeyUsage
+		if err := rows.Scan(&day.Day, &day.Requests, &day.Scans); err != nil {
+			return usage, err
+		}
+		usage = append(usage, day)
+	}
+	return usage, rows.Err()
+}
+
+// GetAPIUsage totals the use of every API key since a given time.
+func (db *SQLDatabase) GetAPIUsage(since time.Time) (models.APIUsage, error) {
+	var usage models.APIUsage
+	err := db.conn.QueryRow(`SELECT COUNT(DISTINCT key_id), COALESCE(SUM(requests), 0), COALESCE(SUM(scans), 0)
+		FROM api_key_usage WHERE day >= $1`,
+		since.UTC().Format("2006-01-02")).Scan(&usage.Keys, &usage.Requests, &usage.Scans)
+	return usage, err
+}
+
+// SetAPIKeyQuota sets an API key's daily scan quota. Zero means unlimited.
+func (db *SQLDatabase) SetAPIKeyQuota(id int64, quota int64) (models.APIKey, error) {
+	row := db.conn.QueryRow(`UPDATE api_keys SET scan_quota=$2
+		WHERE id=$1 RETURNING `+apiKeyColumns, id, quota)
+	return scanAPIKey(row)
+}
+
+// RotateAPIKey replaces the hash of an unrevoked API key owned by email.
+func (db *SQLDatabase) RotateAPIKey(id int64, email string, hash string) (models.APIKey, error) {
+	row := db.conn.QueryRow(`UPDATE api_keys SET key_hash=$3
+		WHERE id=$1 AND email=$2 AND revoked=FALSE RETURNING `+apiKeyColumns,
+		id, email, hash)
+	return scanAPIKey(row)
+}
+
+// RevokeAPIKey revokes an API key owned by email.
+func (db *SQLDatabase) RevokeAPIKey(id int64, email string) (models.APIKey, error) {
+	row := db.conn.QueryRow(`UPDATE api_keys SET revoked=TRUE
+		WHERE id=$1 AND email=$2 RETURNING `+apiKeyColumns,
+		id, email)
+	return scanAPIKey(row)
+}
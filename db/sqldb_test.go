@@ -1,15 +1,21 @@
 package db_test
 
 import (
+	"database/sql"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/EFForg/starttls-backend/checker"
 	"github.com/EFForg/starttls-backend/db"
+	"github.com/EFForg/starttls-backend/metrics"
 	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/policy"
+	"github.com/EFForg/starttls-backend/report"
+	"github.com/EFForg/starttls-backend/validator"
 	"github.com/joho/godotenv"
 )
 
@@ -34,6 +40,9 @@ func initTestDb() *db.SQLDatabase {
 func TestMain(m *testing.M) {
 	godotenv.Overload("../.env.test")
 	database = initTestDb()
+	if _, err := database.Migrate(); err != nil {
+		log.Fatal(err)
+	}
 	code := m.Run()
 	err := database.ClearTables()
 	if err != nil {
@@ -69,6 +78,117 @@ func TestPutScan(t *testing.T) {
 	}
 }
 
+func TestPutScanKeepsCheckerSettings(t *testing.T) {
+	database.ClearTables()
+	settings := checker.Settings{Version: "v1.2.3", Timeout: "3s", HostnameCheck: "full", Checks: []string{checker.STARTTLS}}
+	if err := database.PutScan(models.Scan{Domain: "old.com", Timestamp: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.PutScan(models.Scan{Domain: "new.com", Timestamp: time.Now(), Checker: &settings}); err != nil {
+		t.Fatal(err)
+	}
+	scan, err := database.GetLatestScan("new.com")
+	if err != nil || scan.Checker == nil || scan.Checker.Version != "v1.2.3" || len(scan.Checker.Checks) != 1 {
+		t.Errorf("Expected the checker's settings to be stored, got %+v, %v", scan.Checker, err)
+	}
+	if scan, err = database.GetLatestScan("old.com"); err != nil || scan.Checker != nil {
+		t.Errorf("Expected no settings for a scan without them, got %+v, %v", scan.Checker, err)
+	}
+}
+
+func TestPutScanKeepsFullHostnameResults(t *testing.T) {
+	database.ClearTables()
+	data := checker.NewSampleDomainResult("full.com")
+	result := data.HostnameResults["mx.full.com"]
+	result.CertSerial = "01ab"
+	data.HostnameResults["mx.full.com"] = result
+	if err := database.PutScan(models.Scan{Domain: "full.com", Data: data, Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	scan, err := database.GetLatestScan("full.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := scan.Data.HostnameResults["mx.full.com"]
+	if got.TLSVersion != "TLS 1.3" || got.CertSerial != "01ab" || got.Result == nil {
+		t.Errorf("Expected every field of the hostname result to be stored, got %+v", got)
+	}
+}
+
+func TestPutScans(t *testing.T) {
+	database.ClearTables()
+	scans := []models.Scan{}
+	for _, domain := range []string{"a.com", "b.com", "c.com"} {
+		scans = append(scans, models.Scan{
+			Domain:    domain,
+			Data:      checker.NewSampleDomainResult(domain),
+			Timestamp: time.Now(),
+			Version:   models.ScanVersion,
+			Source:    models.SourceCensus,
+			Profile:   models.ProfileFull,
+		})
+	}
+	if err := database.PutScans(scans); err != nil {
+		t.Fatalf("PutScans failed: %v", err)
+	}
+	for _, expected := range scans {
+		scan, err := database.GetLatestScan(expected.Domain)
+		if err != nil {
+			t.Fatalf("GetLatestScan failed: %v", err)
+		}
+		if scan.Source != models.SourceCensus || scan.Data.Domain != expected.Domain {
+			t.Errorf("Expected %v and %v to be the same", expected, scan)
+		}
+	}
+	if err := database.PutScans(nil); err != nil {
+		t.Errorf("Expected no error storing no scans, got %v", err)
+	}
+}
+
+func TestPutScansSplitsLargeBatches(t *testing.T) {
+	database.ClearTables()
+	scans := make([]models.Scan, db.MaxScanBatch+1)
+	for i := range scans {
+		scans[i] = models.Scan{Domain: "example.com", Data: checker.DomainResult{Domain: "example.com"}, Timestamp: time.Now()}
+	}
+	if err := database.PutScans(scans); err != nil {
+		t.Fatalf("PutScans failed: %v", err)
+	}
+	if count, err := database.CountScans(); err != nil || count != len(scans) {
+		t.Errorf("Expected %d scans, got %d (%v)", len(scans), count, err)
+	}
+}
+
+func TestGetLatestScanWithHostname(t *testing.T) {
+	database.ClearTables()
+	for _, domain := range []string{"a.com", "b.com"} {
+		database.PutScan(models.Scan{
+			Domain:    domain,
+			Data:      checker.NewSampleDomainResult(domain),
+			Timestamp: time.Now(),
+		})
+	}
+	for _, hostname := range []string{"mx.b.com", "mx.b.com."} {
+		scan, err := database.GetLatestScanWithHostname(hostname)
+		if err != nil {
+			t.Fatalf("GetLatestScanWithHostname(%s) failed: %v", hostname, err)
+		}
+		if scan.Domain != "b.com" {
+			t.Errorf("Expected scan of b.com for %s, got %s", hostname, scan.Domain)
+		}
+	}
+	fqdn := checker.NewSampleDomainResult("c.com")
+	fqdn.HostnameResults["mx.c.com."] = fqdn.HostnameResults["mx.c.com"]
+	delete(fqdn.HostnameResults, "mx.c.com")
+	database.PutScan(models.Scan{Domain: "c.com", Data: fqdn, Timestamp: time.Now()})
+	if scan, err := database.GetLatestScanWithHostname("mx.c.com"); err != nil || scan.Domain != "c.com" {
+		t.Errorf("Expected the scan of c.com's fully qualified MX, got %v, %v", scan, err)
+	}
+	if _, err := database.GetLatestScanWithHostname("mx.d.com"); err != sql.ErrNoRows {
+		t.Errorf("Expected no scan of mx.d.com, got %v", err)
+	}
+}
+
 func TestGetLatestScan(t *testing.T) {
 	database.ClearTables()
 	// Add two dummy objects
@@ -137,6 +257,159 @@ func TestGetAllScans(t *testing.T) {
 	}
 }
 
+func TestGetLatestScans(t *testing.T) {
+	database.ClearTables()
+	for i, message := range []string{"first", "second", "third"} {
+		err := database.PutScan(models.Scan{
+			Domain:    "dummy.com",
+			Data:      checker.DomainResult{Domain: "dummy.com", Message: message},
+			Timestamp: time.Now().Add(time.Duration(i) * time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("PutScan failed: %v\n", err)
+		}
+	}
+	scans, err := database.GetLatestScans("dummy.com", 2)
+	if err != nil {
+		t.Fatalf("GetLatestScans failed: %v\n", err)
+	}
+	if len(scans) != 2 || scans[0].Data.Message != "third" || scans[1].Data.Message != "second" {
+		t.Errorf("Expected the two most recent scans, most recent first, got %v", scans)
+	}
+}
+
+func TestGetLatestTrustedScans(t *testing.T) {
+	database.ClearTables()
+	scans := []models.Scan{
+		{Source: models.SourceValidator, Profile: models.ProfileFull},
+		{Source: models.SourceAPI, Profile: models.ProfileFull},
+		{Source: models.SourceAPI, Profile: models.ProfileQuick},
+		{Source: models.SourceCensus, Profile: models.ProfileFull},
+	}
+	for i, scan := range scans {
+		scan.Domain = "dummy.com"
+		scan.Data = checker.DomainResult{Domain: "dummy.com", Message: strconv.Itoa(i)}
+		scan.Timestamp = time.Now().Add(time.Duration(i) * time.Hour)
+		if err := database.PutScan(scan); err != nil {
+			t.Fatalf("PutScan failed: %v\n", err)
+		}
+	}
+	scan, err := database.GetLatestTrustedScan("dummy.com")
+	if err != nil || scan.Data.Message != "1" {
+		t.Errorf("Expected the latest full API scan, got %v, %v", scan, err)
+	}
+	trusted, err := database.GetLatestTrustedScans("dummy.com", 3)
+	if err != nil || len(trusted) != 2 || trusted[0].Data.Message != "1" || trusted[1].Data.Message != "0" {
+		t.Errorf("Expected the full API and validator scans, most recent first, got %v, %v", trusted, err)
+	}
+}
+
+func TestGetLatestScansOf(t *testing.T) {
+	database.ClearTables()
+	for i, domain := range []string{"a.com", "a.com", "b.com", "c.com"} {
+		err := database.PutScan(models.Scan{
+			Domain:    domain,
+			Data:      checker.DomainResult{Domain: domain, Message: strconv.Itoa(i)},
+			Timestamp: time.Now().Add(time.Duration(i) * time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("PutScan failed: %v\n", err)
+		}
+	}
+	scans, err := database.GetLatestScansOf([]string{"a.com", "b.com", "unscanned.com"})
+	if err != nil {
+		t.Fatalf("GetLatestScansOf failed: %v\n", err)
+	}
+	if len(scans) != 2 || scans["a.com"].Data.Message != "1" || scans["b.com"].Data.Message != "2" {
+		t.Errorf("Expected the latest scans of a.com and b.com, got %v", scans)
+	}
+}
+
+func TestGetScanAt(t *testing.T) {
+	database.ClearTables()
+	start := time.Now().Add(-time.Hour)
+	for i, message := range []string{"first", "second"} {
+		err := database.PutScan(models.Scan{
+			Domain:    "dummy.com",
+			Data:      checker.DomainResult{Domain: "dummy.com", Message: message},
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("PutScan failed: %v\n", err)
+		}
+	}
+	scan, err := database.GetScanAt("dummy.com", start.Add(30*time.Second))
+	if err != nil || scan.Data.Message != "first" {
+		t.Errorf("Expected the scan before the given time, got %v (%v)", scan, err)
+	}
+	if _, err = database.GetScanAt("dummy.com", start.Add(-time.Minute)); err != sql.ErrNoRows {
+		t.Errorf("Expected no scan before the first, got %v", err)
+	}
+}
+
+func TestGetScanBatchAndUpdateScan(t *testing.T) {
+	database.ClearTables()
+	for _, domain := range []string{"a.com", "b.com", "c.com"} {
+		err := database.PutScan(models.Scan{
+			Domain:    domain,
+			Data:      checker.DomainResult{Domain: domain},
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("PutScan failed: %v\n", err)
+		}
+	}
+	count, err := database.CountScans()
+	if err != nil || count != 3 {
+		t.Errorf("Expected 3 scans, got %d (%v)", count, err)
+	}
+	ids, scans, err := database.GetScanBatch(0, 2)
+	if err != nil {
+		t.Fatalf("GetScanBatch failed: %v\n", err)
+	}
+	if len(scans) != 2 || scans[0].Domain != "a.com" || scans[1].Domain != "b.com" {
+		t.Errorf("Expected the first two scans, got %v", scans)
+	}
+	scans[1].Data.Message = "updated"
+	scans[1].Data.Grade = checker.GradeB
+	if err = database.UpdateScan(ids[1], scans[1]); err != nil {
+		t.Fatalf("UpdateScan failed: %v\n", err)
+	}
+	ids, scans, err = database.GetScanBatch(ids[0], 2)
+	if err != nil {
+		t.Fatalf("GetScanBatch failed: %v\n", err)
+	}
+	if len(scans) != 2 || scans[0].Data.Message != "updated" || scans[1].Domain != "c.com" {
+		t.Errorf("Expected the updated scan and the last scan, got %v", scans)
+	}
+}
+
+func TestUpdateScans(t *testing.T) {
+	database.ClearTables()
+	for _, domain := range []string{"a.com", "b.com"} {
+		database.PutScan(models.Scan{Domain: domain, Data: checker.DomainResult{Domain: domain}, Timestamp: time.Now()})
+	}
+	ids, scans, err := database.GetScanBatch(0, 2)
+	if err != nil {
+		t.Fatalf("GetScanBatch failed: %v\n", err)
+	}
+	for i := range scans {
+		scans[i].Data.Grade = checker.GradeB
+	}
+	if err = database.UpdateScans(ids, scans); err != nil {
+		t.Fatalf("UpdateScans failed: %v\n", err)
+	}
+	_, scans, _ = database.GetScanBatch(0, 2)
+	for _, scan := range scans {
+		if scan.Data.Grade != checker.GradeB {
+			t.Errorf("Expected every scan to be updated, got %v", scan)
+		}
+	}
+	if err = database.UpdateScans(ids[:1], scans); err == nil {
+		t.Error("Expected mismatched IDs and scans to be refused")
+	}
+}
+
 func TestPutGetDomain(t *testing.T) {
 	database.ClearTables()
 	data := models.Domain{
@@ -177,8 +450,83 @@ func TestUpsertDomain(t *testing.T) {
 	}
 }
 
+func TestUpdateDomain(t *testing.T) {
+	database.ClearTables()
+	database.PutDomain(models.Domain{Name: "testing.com", MXs: []string{"mx1.testing.com"}, Email: "admin@testing.com", QueueWeeks: 4})
+	domain, err := database.GetDomain("testing.com", models.StateUnconfirmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastUpdated := domain.LastUpdated
+	domain.MXs = []string{"mx2.testing.com"}
+	domain.QueueWeeks = 6
+	updated, err := database.UpdateDomain(domain, lastUpdated)
+	if err != nil {
+		t.Fatalf("UpdateDomain failed: %v", err)
+	}
+	if strings.Join(updated.MXs, ",") != "mx2.testing.com" || updated.QueueWeeks != 6 || updated.Email != "admin@testing.com" {
+		t.Errorf("Expected domain to be updated, got %+v", updated)
+	}
+	if !updated.LastUpdated.After(lastUpdated) {
+		t.Errorf("Expected last updated time to advance")
+	}
+	domain.QueueWeeks = 8
+	if _, err := database.UpdateDomain(domain, lastUpdated); err != sql.ErrNoRows {
+		t.Errorf("Expected stale update to fail with sql.ErrNoRows, got %v", err)
+	}
+}
+
 func TestDomainSetStatus(t *testing.T) {
-	// TODO
+	database.ClearTables()
+	database.PutDomain(models.Domain{Name: "testing.com", Email: "admin@testing.com", QueueWeeks: 4})
+	if err := database.SetStatus("testing.com", models.StateTesting, models.StateChange{}); err != nil {
+		t.Fatal(err)
+	}
+	domain, err := database.GetDomain("testing.com", models.StateTesting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domain.TestingStart.IsZero() || time.Since(domain.TestingStart) > time.Hour {
+		t.Errorf("Expected testing start to be recorded, got %v", domain.TestingStart)
+	}
+	if err = database.SetStatus("testing.com", models.StatePendingRemoval, models.StateChange{}); err != nil {
+		t.Fatal(err)
+	}
+	if domain, err = database.GetDomain("testing.com", models.StatePendingRemoval); err != nil {
+		t.Fatal(err)
+	}
+	if domain.RemovalRequested.IsZero() || time.Since(domain.RemovalRequested) > time.Hour {
+		t.Errorf("Expected the removal request to be recorded, got %v", domain.RemovalRequested)
+	}
+}
+
+func TestImportDomains(t *testing.T) {
+	database.ClearTables()
+	database.PutDomain(models.Domain{Name: "taken.com"})
+	database.SetStatus("taken.com", models.StateEnforce, models.StateChange{})
+	change := models.StateChange{Actor: "alice", Reason: "imported"}
+	err := database.ImportDomains([]models.Domain{
+		{Name: "new.com", MXs: []string{"mx.new.com"}, State: models.StateTesting},
+		{Name: "taken.com", MXs: []string{"mx.taken.com"}, State: models.StateEnforce},
+	}, change)
+	if err == nil {
+		t.Error("Expected an import of a stored domain to fail")
+	}
+	if _, err = database.GetDomain("new.com", models.StateTesting); err == nil {
+		t.Error("Expected nothing to be imported when one domain can't be")
+	}
+	err = database.ImportDomains([]models.Domain{{Name: "new.com", MXs: []string{"mx.new.com"}, State: models.StateTesting}}, change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain, err := database.GetDomain("new.com", models.StateTesting)
+	if err != nil || domain.TestingStart.IsZero() || domain.MXs[0] != "mx.new.com" {
+		t.Errorf("Expected new.com to be queued, got %+v, %v", domain, err)
+	}
+	entries, _ := database.GetAuditLog("new.com")
+	if len(entries) != 1 || entries[0].Actor != "alice" || entries[0].Details != "unvalidated to queued: imported" {
+		t.Errorf("Expected the import to be audited, got %v", entries)
+	}
 }
 
 func TestPutUseToken(t *testing.T) {
@@ -212,6 +560,55 @@ func TestPutTokenTwice(t *testing.T) {
 	}
 }
 
+func TestResendToken(t *testing.T) {
+	database.ClearTables()
+	submitted, _ := database.PutToken("testing.com")
+	first, _, err := database.ResendToken("testing.com", time.Hour, 2)
+	if err != nil || first.Token == "" || first.Token == submitted.Token {
+		t.Fatalf("Expected a new token, got %v, %v", first, err)
+	}
+	second, retryAt, err := database.ResendToken("testing.com", time.Hour, 2)
+	if err != nil || !retryAt.IsZero() || second.Token == first.Token {
+		t.Fatalf("Expected another new token, got %v, %v, %v", second, retryAt, err)
+	}
+	if _, retryAt, err = database.ResendToken("testing.com", time.Hour, 2); err != nil || retryAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("Expected resends to be limited for an hour, got %v, %v", retryAt, err)
+	}
+	if _, err = database.UseToken(first.Token); err != sql.ErrNoRows {
+		t.Errorf("Expected an earlier resend's token to stop working, got %v", err)
+	}
+	if domain, err := database.UseToken(submitted.Token); err != nil || domain != "testing.com" {
+		t.Errorf("Expected the submission's token to keep working, got %s, %v", domain, err)
+	}
+	if _, err = database.UseToken(second.Token); err != sql.ErrNoRows {
+		t.Errorf("Expected the domain's tokens to be used up, got %v", err)
+	}
+	// Resubmitting the domain doesn't reset its resends.
+	database.PutToken("testing.com")
+	if _, retryAt, _ = database.ResendToken("testing.com", time.Hour, 2); retryAt.IsZero() {
+		t.Error("Expected resends to stay limited after the domain was resubmitted")
+	}
+}
+
+func TestPutUseRemovalToken(t *testing.T) {
+	database.ClearTables()
+	old, _ := database.PutRemovalToken("testing.com")
+	token, err := database.PutRemovalToken("testing.com")
+	if err != nil {
+		t.Fatalf("PutRemovalToken failed: %v\n", err)
+	}
+	if _, err = database.UseRemovalToken(old); err != sql.ErrNoRows {
+		t.Errorf("UseRemovalToken should not have succeeded with old token, got %v\n", err)
+	}
+	domain, err := database.UseRemovalToken(token)
+	if err != nil || domain != "testing.com" {
+		t.Errorf("UseRemovalToken returned %s, %v; want testing.com\n", domain, err)
+	}
+	if _, err = database.UseRemovalToken(token); err != sql.ErrNoRows {
+		t.Errorf("UseRemovalToken should not have succeeded twice, got %v\n", err)
+	}
+}
+
 func TestLastUpdatedFieldUpdates(t *testing.T) {
 	database.ClearTables()
 	data := models.Domain{
@@ -247,6 +644,62 @@ func TestLastUpdatedFieldDoesntUpdate(t *testing.T) {
 	}
 }
 
+func TestGetDomainPage(t *testing.T) {
+	database.ClearTables()
+	for _, name := range []string{"c.com", "a.com", "b.com"} {
+		database.PutDomain(models.Domain{Name: name})
+		database.SetStatus(name, models.StateTesting, models.StateChange{})
+	}
+	database.PutDomain(models.Domain{Name: "unconfirmed.com"})
+
+	for _, sortBy := range []string{models.SortByName, models.SortByLastUpdated} {
+		names := []string{}
+		page := models.DomainPage{Limit: 2, Sort: sortBy}
+		for {
+			list, err := database.GetDomainPage(models.StateTesting, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range list.Domains {
+				names = append(names, d.Name)
+			}
+			if list.Next == "" {
+				break
+			}
+			page.After = list.Next
+		}
+		expected := "a.com b.com c.com"
+		if sortBy == models.SortByLastUpdated {
+			expected = "b.com a.com c.com"
+		}
+		if strings.Join(names, " ") != expected {
+			t.Errorf("Expected queued domains sorted by %s to be %s, got %v", sortBy, expected, names)
+		}
+	}
+}
+
+func TestSearchDomains(t *testing.T) {
+	database.ClearTables()
+	for _, name := range []string{"mail.example.com", "gmail.com", "g_mail.com"} {
+		database.PutDomain(models.Domain{Name: name})
+	}
+	database.SetStatus("gmail.com", models.StateTesting, models.StateChange{})
+
+	domains, err := database.SearchDomains(models.DomainSearch{Query: "mail", Match: models.MatchSubstring})
+	if err != nil || len(domains) != 3 || domains[0].Name != "g_mail.com" {
+		t.Errorf("Expected every mail domain in order of name, got %v, %v", domains, err)
+	}
+	domains, _ = database.SearchDomains(models.DomainSearch{Query: "G_"})
+	if len(domains) != 1 || domains[0].Name != "g_mail.com" {
+		t.Errorf("Expected _ to match only itself, got %v", domains)
+	}
+	domains, _ = database.SearchDomains(models.DomainSearch{Query: "mail", Match: models.MatchSubstring, Limit: 1},
+		models.StateTesting, models.StateEnforce)
+	if len(domains) != 1 || domains[0].Name != "gmail.com" {
+		t.Errorf("Expected only the queued domain, got %v", domains)
+	}
+}
+
 func TestDomainsToValidate(t *testing.T) {
 	database.ClearTables()
 	queuedMap := map[string]bool{
@@ -274,8 +727,8 @@ func TestHostnamesForDomain(t *testing.T) {
 	database.ClearTables()
 	database.PutDomain(models.Domain{Name: "x", MXs: []string{"x.com", "y.org"}})
 	database.PutDomain(models.Domain{Name: "y"})
-	database.SetStatus("x", models.StateTesting)
-	database.SetStatus("y", models.StateTesting)
+	database.SetStatus("x", models.StateTesting, models.StateChange{})
+	database.SetStatus("y", models.StateTesting, models.StateChange{})
 	result, err := database.HostnamesForDomain("x")
 	if err != nil {
 		t.Fatalf("HostnamesForDomain failed: %v\n", err)
@@ -292,6 +745,30 @@ func TestHostnamesForDomain(t *testing.T) {
 	}
 }
 
+func TestSetMTASTSMode(t *testing.T) {
+	database.ClearTables()
+	database.PutDomain(models.Domain{Name: "sts.com", MTASTS: true, MTASTSMode: "testing"})
+	database.PutDomain(models.Domain{Name: "mxs.com", MXs: []string{"mx.mxs.com"}})
+	database.SetStatus("sts.com", models.StateTesting, models.StateChange{})
+	database.SetStatus("mxs.com", models.StateTesting, models.StateChange{})
+	for _, expected := range []bool{true, false} {
+		changed, err := database.SetMTASTSMode("sts.com", "enforce")
+		if err != nil {
+			t.Fatalf("SetMTASTSMode failed: %v", err)
+		}
+		if changed != expected {
+			t.Errorf("Expected changed to be %t", expected)
+		}
+	}
+	domain, _ := database.GetDomain("sts.com", models.StateTesting)
+	if domain.MTASTSMode != "enforce" {
+		t.Errorf("Expected mode to be enforce, got %s", domain.MTASTSMode)
+	}
+	if changed, _ := database.SetMTASTSMode("mxs.com", "enforce"); changed {
+		t.Errorf("Expected domain not admitted via MTA-STS to be left alone")
+	}
+}
+
 func TestPutAndIsBlacklistedEmail(t *testing.T) {
 	database.ClearTables()
 
@@ -309,6 +786,9 @@ func TestPutAndIsBlacklistedEmail(t *testing.T) {
 	if !blacklisted {
 		t.Errorf("fail@example.com should be blacklisted, but wasn't")
 	}
+	if blacklisted, _ = database.IsBlacklistedEmail("Fail@Example.com"); !blacklisted {
+		t.Errorf("Fail@Example.com should be blacklisted regardless of case, but wasn't")
+	}
 
 	// Check that an un-added email address is not blacklisted.
 	blacklisted, err = database.IsBlacklistedEmail("good@example.com")
@@ -344,6 +824,27 @@ func TestGetHostnameScan(t *testing.T) {
 	}
 }
 
+func TestGetHostnameScansSince(t *testing.T) {
+	database.ClearTables()
+	for _, hostname := range []string{"first", "second"} {
+		database.PutHostnameScan(hostname, checker.HostnameResult{
+			Hostname: hostname,
+			Result:   &checker.Result{Status: 1},
+		})
+	}
+	results, err := database.GetHostnameScansSince(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetHostnameScansSince failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Hostname != "first" || results[1].Hostname != "second" {
+		t.Errorf("Expected both scans, oldest first, got %v", results)
+	}
+	results, err = database.GetHostnameScansSince(time.Now().Add(time.Hour))
+	if err != nil || len(results) != 0 {
+		t.Errorf("Expected no scans from the future, got %v, %v", results, err)
+	}
+}
+
 func dateMustParse(date string, t *testing.T) time.Time {
 	const shortForm = "2006-Jan-02"
 	parsed, err := time.Parse(shortForm, date)
@@ -367,12 +868,14 @@ func TestGetStats(t *testing.T) {
 			MTASTSEnforce: 1,
 		},
 		checker.AggregatedScan{
-			Time:          may2,
-			Source:        checker.TopDomainsSource,
-			Attempted:     10,
-			WithMXs:       8,
-			MTASTSTesting: 1,
-			MTASTSEnforce: 3,
+			Time:                    may2,
+			Source:                  checker.TopDomainsSource,
+			Attempted:               10,
+			WithMXs:                 8,
+			MTASTSTesting:           1,
+			MTASTSEnforce:           3,
+			Software:                map[string]int{"Exim 4.94": 2, "unknown": 1},
+			SoftwareWithoutSTARTTLS: map[string]int{"Exim 4.94": 1},
 		},
 	}
 	for _, a := range data {
@@ -388,6 +891,10 @@ func TestGetStats(t *testing.T) {
 	if result[0].TotalMTASTS() != 3 || result[1].TotalMTASTS() != 4 {
 		t.Errorf("Incorrect MTA-STS stats, got %v", result)
 	}
+	if result[0].Software != nil || result[1].Software["Exim 4.94"] != 2 ||
+		result[1].SoftwareWithoutSTARTTLS["Exim 4.94"] != 1 {
+		t.Errorf("Expected fingerprint counts to be stored, got %+v and %+v", result[0], result[1])
+	}
 }
 
 func TestPutLocalStats(t *testing.T) {
@@ -494,3 +1001,343 @@ func TestGetMTASTSDomains(t *testing.T) {
 		}
 	}
 }
+
+func TestAcquireLease(t *testing.T) {
+	database.ClearTables()
+	if ok, err := database.AcquireLease("publisher", "us", time.Hour); !ok || err != nil {
+		t.Fatalf("Expected to acquire free lease, got %v, %v", ok, err)
+	}
+	if ok, err := database.AcquireLease("publisher", "us", time.Hour); !ok || err != nil {
+		t.Errorf("Expected holder to renew its lease, got %v, %v", ok, err)
+	}
+	if ok, err := database.AcquireLease("publisher", "eu", time.Hour); ok || err != nil {
+		t.Errorf("Expected held lease not to be acquired, got %v, %v", ok, err)
+	}
+	database.AcquireLease("expired", "us", -time.Hour)
+	if ok, err := database.AcquireLease("expired", "eu", time.Hour); !ok || err != nil {
+		t.Errorf("Expected expired lease to be acquired, got %v, %v", ok, err)
+	}
+}
+
+func TestPublications(t *testing.T) {
+	database.ClearTables()
+	if _, err := database.GetPublication(); err != sql.ErrNoRows {
+		t.Errorf("Expected ErrNoRows before anything's published, got %v", err)
+	}
+	for _, version := range []string{"1", "2"} {
+		err := database.PutPublication(policy.Publication{SHA256: version, Body: []byte(version), Published: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pub, err := database.GetPublication()
+	if err != nil || pub.SHA256 != "2" || string(pub.Body) != "2" {
+		t.Errorf("Expected latest publication, got %v, %v", pub, err)
+	}
+}
+
+func TestStateChangesAreAudited(t *testing.T) {
+	database.ClearTables()
+	database.PutDomain(models.Domain{Name: "audit.com"})
+	if err := database.SetStatus("audit.com", models.StateTesting, models.StateChange{Actor: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.RemoveDomain("audit.com", models.StateTesting, models.StateChange{Actor: "admin", Reason: "spam"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := database.GetAuditLog("audit.com")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected both state changes in the audit log, got %v, %v", entries, err)
+	}
+	if entries[0].Actor != "admin" || entries[0].Details != "queued removed: spam" ||
+		entries[1].Actor != "token" || entries[1].Details != "unvalidated to queued" {
+		t.Errorf("Expected the state changes, most recent first, got %v", entries)
+	}
+	if _, err = database.RemoveDomain("audit.com", models.StateTesting, models.StateChange{}); err != sql.ErrNoRows {
+		t.Errorf("Expected removing a missing domain to return sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestGetDataExport(t *testing.T) {
+	database.ClearTables()
+	database.PutDomain(models.Domain{Name: "example.com", Email: "Me@example.com"})
+	database.PutDomain(models.Domain{Name: "other.com", Email: "other@example.com"})
+	database.PutToken("example.com")
+	database.PutAPIKey(models.APIKey{Email: "me@example.com", Name: "ci"}, "hash")
+	database.PutDataExportToken("me@example.com")
+	database.PutBlacklistedEmail("me@example.com", "complaint", "2017-07-21T18:47:13.498Z")
+
+	export, err := database.GetDataExport("me@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Domains) != 1 || export.Domains[0].Name != "example.com" ||
+		len(export.ValidationTokens) != 1 || export.ValidationTokens[0].Token != "" {
+		t.Errorf("Expected the address's domain and its token's metadata, got %+v", export)
+	}
+	if len(export.APIKeys) != 1 || len(export.EmailTokens) != 1 || export.EmailTokens[0].Purpose != models.PurposeDataExport {
+		t.Errorf("Expected the address's API key and verification token, got %+v", export)
+	}
+	if len(export.Bounces) != 1 || export.Bounces[0].Reason != "complaint" {
+		t.Errorf("Expected the address's complaint, got %+v", export.Bounces)
+	}
+}
+
+func TestEraseEmail(t *testing.T) {
+	database.ClearTables()
+	database.PutDomain(models.Domain{Name: "pending.com", Email: "me@example.com"})
+	database.PutToken("pending.com")
+	database.PutDomain(models.Domain{Name: "added.com", Email: "ME@example.com"})
+	database.SetStatus("added.com", models.StateEnforce, models.StateChange{})
+	key, _ := database.PutAPIKey(models.APIKey{Email: "me@example.com"}, "hash")
+	database.PutDataErasureToken("me@example.com")
+	database.PutAuditEntry(models.AuditEntry{Actor: key.Actor(), Action: "webhook.register", Subject: "added.com"})
+	database.PutAuditEntry(models.AuditEntry{Actor: "admin", Action: "key.issue", Subject: "Me@example.com"})
+
+	erasure, err := database.EraseEmail("me@example.com", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(erasure.Removed) != 1 || erasure.Removed[0] != "pending.com" ||
+		len(erasure.Anonymized) != 1 || erasure.Anonymized[0] != "added.com" ||
+		erasure.RevokedKeys != 1 || erasure.RedactedAuditEntries != 2 {
+		t.Errorf("Unexpected erasure %+v", erasure)
+	}
+	entries, err := database.GetAuditLog("added.com")
+	if err != nil || len(entries) == 0 || strings.Contains(entries[len(entries)-1].Actor, "example.com") {
+		t.Errorf("Expected the address to be redacted from the audit log, got %v, %v", entries, err)
+	}
+	if _, err = database.PutAuditEntry(models.AuditEntry{Actor: "admin", Action: "domain.patch", Subject: "added.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if domain, _ := database.GetDomain("added.com", models.StateEnforce); domain.Email != "" {
+		t.Errorf("Expected listed domain to lose its contact, got %s", domain.Email)
+	}
+	export, err := database.GetDataExport("me@example.com")
+	if err != nil || len(export.Domains) != 0 || len(export.APIKeys) != 0 || len(export.EmailTokens) != 0 {
+		t.Errorf("Expected nothing left about the address, got %+v, %v", export, err)
+	}
+}
+
+func TestReportQueries(t *testing.T) {
+	database.ClearTables()
+	now := time.Now()
+	scans := []models.Scan{
+		{Domain: "a.com", Timestamp: now, Source: models.SourceAPI,
+			Data: checker.DomainResult{Domain: "a.com", Status: checker.DomainNoSTARTTLSFailure}},
+		{Domain: "b.com", Timestamp: now, Source: models.SourceAPI,
+			Data: checker.DomainResult{Domain: "b.com", Status: checker.DomainNoSTARTTLSFailure}},
+		{Domain: "c.com", Timestamp: now.Add(-30 * 24 * time.Hour), Source: models.SourceAPI,
+			Data: checker.DomainResult{Domain: "c.com", Status: checker.DomainNoSTARTTLSFailure}},
+	}
+	for _, scan := range scans {
+		if err := database.PutScan(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts, err := database.GetScanCounts(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts[0] != (models.ScanCount{Source: models.SourceAPI, Status: checker.DomainNoSTARTTLSFailure, Count: 2}) {
+		t.Errorf("Expected two recent failed API scans, got %v", counts)
+	}
+	database.PutAuditEntry(models.AuditEntry{Time: now.Add(-30 * 24 * time.Hour), Actor: "admin", Action: "domain.patch", Subject: "c.com"})
+	database.PutAuditEntry(models.AuditEntry{Actor: "admin", Action: "moderation.reject", Subject: "a.com"})
+	entries, err := database.GetAuditLogSince(now.Add(-time.Hour))
+	if err != nil || len(entries) != 1 || entries[0].Action != "moderation.reject" {
+		t.Errorf("Expected recent audit entry, got %v, %v", entries, err)
+	}
+	usage, err := database.GetAPIUsage(now.Add(-time.Hour))
+	if err != nil || usage != (models.APIUsage{}) {
+		t.Errorf("Expected no API usage, got %v, %v", usage, err)
+	}
+	if _, err := database.GetLatestReport(""); err != sql.ErrNoRows {
+		t.Errorf("Expected ErrNoRows before any report, got %v", err)
+	}
+	stored, err := database.PutReport(report.Report{Period: report.Weekly, Start: now.Add(-time.Hour), End: now,
+		Markdown: "# Report"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := database.GetLatestReport("")
+	if err != nil || latest.ID != stored.ID || latest.Markdown != "# Report" {
+		t.Errorf("Expected stored report, got %v, %v", latest, err)
+	}
+	if _, err := database.GetLatestReport(report.Monthly); err != sql.ErrNoRows {
+		t.Errorf("Expected ErrNoRows before any monthly report, got %v", err)
+	}
+	if latest, err = database.GetLatestReport(report.Weekly); err != nil || latest.ID != stored.ID {
+		t.Errorf("Expected stored weekly report, got %v, %v", latest, err)
+	}
+}
+
+func TestValidatorRuns(t *testing.T) {
+	database.ClearTables()
+	if _, err := database.GetValidatorRun("list"); err != sql.ErrNoRows {
+		t.Errorf("Expected ErrNoRows before any run, got %v", err)
+	}
+	summary := validator.RunSummary{Time: time.Now().UTC().Truncate(time.Second), Attempted: 3, Failed: 1}
+	database.PutValidatorRun("list", validator.RunSummary{Attempted: 1})
+	if err := database.PutValidatorRun("list", summary); err != nil {
+		t.Fatal(err)
+	}
+	run, err := database.GetValidatorRun("list")
+	if err != nil || !run.Time.Equal(summary.Time) || run.Attempted != 3 || run.Failed != 1 {
+		t.Errorf("Expected the latest run, got %+v, %v", run, err)
+	}
+}
+
+func TestSubmissionBlocks(t *testing.T) {
+	database.ClearTables()
+	b, err := database.PutSubmissionBlock(models.SubmissionBlock{Kind: models.BlockDomain, Pattern: "junk.example", Reason: "spam"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := database.PutSubmissionBlock(models.SubmissionBlock{Kind: models.BlockDomain, Pattern: "junk.example", Reason: "more spam"})
+	if err != nil || again.ID != b.ID || again.Reason != "more spam" {
+		t.Errorf("Expected blocking the same pattern to replace its reason, got %+v, %v", again, err)
+	}
+	database.PutSubmissionBlock(models.SubmissionBlock{Kind: models.BlockEmail, Pattern: "*@mailinator.com"})
+	blocks, err := database.GetSubmissionBlocks()
+	if err != nil || len(blocks) != 2 || blocks[0].ID != b.ID {
+		t.Errorf("Expected both blocks, oldest first, got %+v, %v", blocks, err)
+	}
+	if removed, err := database.RemoveSubmissionBlock(b.ID); err != nil || removed.Pattern != "junk.example" {
+		t.Errorf("Expected the block to be removed, got %+v, %v", removed, err)
+	}
+	if _, err := database.RemoveSubmissionBlock(b.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected removing a missing block to fail with sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	database.ClearTables()
+	hook, err := database.PutWebhook(models.Webhook{Domain: "example.com", URL: "https://hooks.example.com/", Owner: "me@example.com", Secret: "whsec_test"})
+	if err != nil || hook.ID == 0 || hook.Secret != "whsec_test" {
+		t.Fatalf("Expected the webhook to be registered, got %+v, %v", hook, err)
+	}
+	if _, err = database.PutWebhook(models.Webhook{Domain: "example.com", URL: "https://hooks.example.com/", Secret: "x"}); err == nil {
+		t.Error("Expected registering the same URL twice for a domain to fail")
+	}
+	hooks, err := database.GetWebhooks("example.com")
+	if err != nil || len(hooks) != 1 || hooks[0].ID != hook.ID || hooks[0].Secret != "" {
+		t.Errorf("Expected the domain's webhook, without its secret, got %+v, %v", hooks, err)
+	}
+	if stored, err := database.GetWebhook(hook.ID); err != nil || stored.Secret != "whsec_test" {
+		t.Errorf("Expected the webhook with its secret, got %+v, %v", stored, err)
+	}
+
+	database.PutDomain(models.Domain{Name: "example.com", Email: "me@example.com"})
+	if err = database.SetStatus("example.com", models.StateTesting, models.StateChange{Actor: models.ActorToken, Reason: "validated"}); err != nil {
+		t.Fatal(err)
+	}
+	due, err := database.GetDueWebhookDeliveries(time.Now().Add(time.Second), 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("Expected the state change to queue a delivery, got %+v, %v", due, err)
+	}
+	delivery := due[0]
+	if delivery.WebhookID != hook.ID || delivery.From != models.StateUnconfirmed || delivery.To != models.StateTesting ||
+		delivery.Reason != "validated" || delivery.Status != models.DeliveryPending {
+		t.Errorf("Expected a pending delivery of the state change, got %+v", delivery)
+	}
+	delivery.Attempts, delivery.ResponseCode, delivery.Error = 1, 503, "responded with 503"
+	delivery.NextAttempt = time.Now().Add(time.Hour)
+	if err = database.UpdateWebhookDelivery(delivery); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ = database.GetDueWebhookDeliveries(time.Now().Add(time.Second), 10); len(due) != 0 {
+		t.Errorf("Expected the retry not to be due yet, got %+v", due)
+	}
+	deliveries, err := database.GetWebhookDeliveries(hook.ID, 10)
+	if err != nil || len(deliveries) != 1 || deliveries[0].Attempts != 1 || deliveries[0].ResponseCode != 503 {
+		t.Errorf("Expected the delivery's attempt to be recorded, got %+v, %v", deliveries, err)
+	}
+
+	if _, err = database.RemoveDomain("example.com", models.StateTesting, models.StateChange{Actor: "owner"}); err != nil {
+		t.Fatal(err)
+	}
+	due, err = database.GetDueWebhookDeliveries(time.Now().Add(time.Second), 10)
+	if err != nil || len(due) != 1 || due[0].From != models.StateTesting || due[0].To != "" {
+		t.Errorf("Expected the removal to queue a delivery, got %+v, %v", due, err)
+	}
+
+	if removed, err := database.RemoveWebhook(hook.ID); err != nil || removed.ID != hook.ID {
+		t.Errorf("Expected the webhook to be removed, got %+v, %v", removed, err)
+	}
+	if deliveries, _ = database.GetWebhookDeliveries(hook.ID, 10); len(deliveries) != 0 {
+		t.Errorf("Expected the webhook's deliveries to be removed with it, got %+v", deliveries)
+	}
+	if _, err = database.RemoveWebhook(hook.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected removing a missing webhook to fail with sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestLoadPoolSettings(t *testing.T) {
+	cfg, err := db.LoadEnvironmentVariables()
+	if err != nil || cfg.MaxOpenConns != 25 || cfg.QueryTimeout != 30*time.Second {
+		t.Errorf("Expected the default pool settings, got %+v, %v", cfg, err)
+	}
+	os.Setenv("DB_QUERY_TIMEOUT", "5s")
+	defer os.Unsetenv("DB_QUERY_TIMEOUT")
+	if cfg, err = db.LoadEnvironmentVariables(); err != nil || cfg.QueryTimeout != 5*time.Second {
+		t.Errorf("Expected DB_QUERY_TIMEOUT to set the query timeout, got %v, %v", cfg.QueryTimeout, err)
+	}
+	os.Setenv("DB_MAX_OPEN_CONNS", "many")
+	defer os.Unsetenv("DB_MAX_OPEN_CONNS")
+	if _, err = db.LoadEnvironmentVariables(); err == nil {
+		t.Error("Expected an invalid DB_MAX_OPEN_CONNS to be refused")
+	}
+}
+
+func TestQueryMetrics(t *testing.T) {
+	database.ClearTables()
+	if _, err := database.GetLatestScan("missing.com"); err != sql.ErrNoRows {
+		t.Fatalf("Expected no scan, got %v", err)
+	}
+	if err := database.PutScan(models.Scan{Domain: "dummy.com", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	database.PutDomain(models.Domain{Name: "dummy.com", Email: "me@dummy.com"})
+	if err := database.SetStatus("dummy.com", models.StateTesting, models.StateChange{}); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	metrics.Default.Write(&out)
+	for _, want := range []string{
+		`db_query_seconds_count{statement="insert scans"}`,
+		`db_query_seconds_count{statement="select scans"}`,
+		// Queries within transactions are recorded too.
+		`db_query_seconds_count{statement="update domains"}`,
+		`db_query_seconds_count{statement="commit"}`,
+		"db_up 1\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected the metrics to include %s", want)
+		}
+	}
+	if strings.Contains(out.String(), `db_query_errors_total{statement="select scans"}`) {
+		t.Error("Expected finding no scan not to count as an error")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	migrations, err := db.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 || m.Name == "" || m.SQL == "" {
+			t.Errorf("Expected migrations to be numbered from 1 without gaps, got %d_%s", m.Version, m.Name)
+		}
+	}
+	// TestMain already migrated the database.
+	applied, err := database.Migrate()
+	if err != nil || len(applied) != 0 {
+		t.Errorf("Expected no migrations to be applied twice, got %v, %v", applied, err)
+	}
+	version, err := database.SchemaVersion()
+	if err != nil || version != migrations[len(migrations)-1].Version {
+		t.Errorf("Expected schema to be at the latest version, got %d, %v", version, err)
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// statements caches prepared statements by their query, so that queries run
+// for every scan are only parsed and planned once per connection. Statements
+// are prepared the first time they're used, since the tables they read may
+// not exist until the database is migrated.
+type statements struct {
+	conn timeoutConn
+
+	mu       sync.Mutex
+	prepared map[string]*sql.Stmt
+}
+
+func newStatements(conn timeoutConn) *statements {
+	return &statements{conn: conn, prepared: make(map[string]*sql.Stmt)}
+}
+
+// prepare returns the prepared statement for query, preparing it if it
+// hasn't been yet. It's safe for concurrent use.
+func (s *statements) prepare(query string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stmt, ok := s.prepared[query]; ok {
+		return stmt, nil
+	}
+	ctx, cancel := s.conn.deadline()
+	defer cancel()
+	stmt, err := s.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s.prepared[query] = stmt
+	return stmt, nil
+}
+
+// close closes every prepared statement.
+func (s *statements) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var firstErr error
+	for query, stmt := range s.prepared {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.prepared, query)
+	}
+	return firstErr
+}
+
+// execPrepared executes query as a prepared statement.
+func (db SQLDatabase) execPrepared(query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := db.stmts.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.conn.deadline()
+	defer cancel()
+	start := time.Now()
+	result, err := stmt.ExecContext(ctx, args...)
+	observeQuery(query, start, err)
+	return result, err
+}
+
+// queryRowPrepared runs query as a prepared statement, returning at most one
+// row. If the statement can't be prepared, the query is run directly, so that
+// the row reports the error.
+func (db SQLDatabase) queryRowPrepared(query string, args ...interface{}) *timeoutRow {
+	stmt, err := db.stmts.prepare(query)
+	if err != nil {
+		return db.conn.QueryRow(query, args...)
+	}
+	ctx, cancel := db.conn.deadline()
+	start := time.Now()
+	row := stmt.QueryRowContext(ctx, args...)
+	observeQuery(query, start, row.Err())
+	return &timeoutRow{Row: row, cancel: cancel}
+}
+
+// Close closes the database's prepared statements and connections.
+func (db *SQLDatabase) Close() error {
+	err := db.stmts.close()
+	unwatchPool(db.conn.DB)
+	if closeErr := db.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -1,16 +1,27 @@
 package email
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/EFForg/starttls-backend/alerts"
+	"github.com/EFForg/starttls-backend/checker"
 	"github.com/EFForg/starttls-backend/db"
 	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/report"
+	"github.com/EFForg/starttls-backend/slo"
 	"github.com/EFForg/starttls-backend/util"
+	"github.com/EFForg/starttls-backend/views"
 )
 
 type blacklistStore interface {
@@ -28,7 +39,11 @@ type Config struct {
 	port               string
 	sender             string
 	website            string // Needed to generate email template text.
+	alertAddress       string // Optional; where monitoring alerts are sent.
+	reportAddress      string // Optional; where operations reports are sent.
+	unsubscribeSecret  []byte // Optional; signs notifications' opt-out links.
 	database           blacklistStore
+	views              *views.Views
 }
 
 // MakeConfigFromEnv initializes our email config object with
@@ -43,7 +58,11 @@ func MakeConfigFromEnv(database db.Database) (Config, error) {
 		port:               util.RequireEnv("SMTP_PORT", &varErrs),
 		sender:             util.RequireEnv("SMTP_FROM_ADDRESS", &varErrs),
 		website:            util.RequireEnv("FRONTEND_WEBSITE_LINK", &varErrs),
+		alertAddress:       os.Getenv("ALERT_EMAIL"),
+		reportAddress:      os.Getenv("REPORT_EMAIL"),
+		unsubscribeSecret:  []byte(os.Getenv("UNSUBSCRIBE_SECRET")),
 		database:           database,
+		views:              views.New(os.Getenv("VIEWS_DIR")),
 	}
 	if len(varErrs) > 0 {
 		return c, varErrs
@@ -73,25 +92,300 @@ func MakeConfigFromEnv(database db.Database) (Config, error) {
 	return c, nil
 }
 
+// MakeLoggingConfig returns a config that logs emails instead of sending
+// them, eg. for end-to-end tests. Like any other config, it won't email
+// blacklisted addresses.
+func MakeLoggingConfig(database db.Database) Config {
+	return Config{
+		sender:            "starttls-everywhere@localhost",
+		website:           os.Getenv("FRONTEND_WEBSITE_LINK"),
+		alertAddress:      os.Getenv("ALERT_EMAIL"),
+		reportAddress:     os.Getenv("REPORT_EMAIL"),
+		unsubscribeSecret: []byte(os.Getenv("UNSUBSCRIBE_SECRET")),
+		database:          database,
+		views:             views.New(os.Getenv("VIEWS_DIR")),
+	}
+}
+
 // ValidationAddress Returns default validation address for this domain submission.
 func ValidationAddress(domain *models.Domain) string {
 	return fmt.Sprintf("postmaster@%s", domain.Name)
 }
 
-func validationEmailText(domain string, contactEmail string, hostnames []string, token string, website string) string {
-	return fmt.Sprintf(validationEmailTemplate,
-		domain, strings.Join(hostnames[:], ", "), website, token, contactEmail)
+// ContactAddress returns the address notifications about this domain are
+// sent to: the contact email it was submitted with, or else its validation
+// address.
+func ContactAddress(domain *models.Domain) string {
+	if domain.Email != "" {
+		return domain.Email
+	}
+	return ValidationAddress(domain)
+}
+
+// renderText renders the email template views/email/<name>.txt.tmpl.
+func (c Config) renderText(name string, data interface{}) (string, error) {
+	v := c.views
+	if v == nil {
+		v = views.New("")
+	}
+	tmpl, err := v.Text(name)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = tmpl.Execute(&b, data)
+	return b.String(), err
+}
+
+func (c Config) validationEmailText(domain string, contactEmail string, hostnames []string, token string) (string, error) {
+	return c.renderText("validation", validationEmailData{
+		Domain:       domain,
+		Hostnames:    strings.Join(hostnames[:], ", "),
+		ContactEmail: contactEmail,
+		Website:      c.website,
+		Token:        token,
+	})
 }
 
 // SendValidation sends a validation e-mail for the domain outlined by domainInfo.
 // The validation link is generated using a token.
 func (c Config) SendValidation(domain *models.Domain, token string) error {
-	emailContent := validationEmailText(domain.Name, domain.Email, domain.MXs, token,
-		c.website)
+	emailContent, err := c.validationEmailText(domain.Name, domain.Email, domain.MXs, token)
+	if err != nil {
+		return err
+	}
 	return c.sendEmail(validationEmailSubject, emailContent, ValidationAddress(domain))
 }
 
+// SendAPIKeyVerification sends a token for verifying address before
+// issuing it an API key.
+func (c Config) SendAPIKeyVerification(address string, token string) error {
+	emailContent, err := c.renderText("api_key_verification",
+		apiKeyVerificationData{Token: token, Website: c.website})
+	if err != nil {
+		return err
+	}
+	return c.sendEmail(apiKeyVerificationSubject, emailContent, address)
+}
+
+// SendDataExportVerification sends a token for verifying address before
+// exporting the data stored about it.
+func (c Config) SendDataExportVerification(address string, token string) error {
+	emailContent, err := c.renderText("data_export_verification",
+		dataExportVerificationData{Token: token, Website: c.website})
+	if err != nil {
+		return err
+	}
+	return c.sendEmail(dataExportVerificationSubject, emailContent, address)
+}
+
+// SendDataErasureVerification sends a token for verifying address before
+// erasing the data stored about it.
+func (c Config) SendDataErasureVerification(address string, token string) error {
+	emailContent, err := c.renderText("data_erasure_verification",
+		dataErasureVerificationData{Token: token, Website: c.website})
+	if err != nil {
+		return err
+	}
+	return c.sendEmail(dataErasureVerificationSubject, emailContent, address)
+}
+
+// SendSubmissionRejected tells the domain's validation address that a
+// reviewer rejected its flagged submission, with the reviewer's note.
+func (c Config) SendSubmissionRejected(domain *models.Domain, note string) error {
+	emailContent, err := c.renderText("submission_rejected",
+		submissionRejectedData{Domain: domain.Name, Note: note, Website: c.website})
+	if err != nil {
+		return err
+	}
+	return c.sendEmail(submissionRejectedSubject, emailContent, ValidationAddress(domain))
+}
+
+// SendPromotionConfirmation asks the domain's validation address to confirm
+// that the domain is ready to be promoted to enforce, with the query strings
+// of the links to confirm the promotion, or hold it back, until expires.
+func (c Config) SendPromotionConfirmation(domain *models.Domain, confirm string, hold string, expires time.Time) error {
+	emailContent, err := c.renderText("promotion_confirmation", promotionConfirmationData{
+		Domain:    domain.Name,
+		Hostnames: strings.Join(domain.MXs, ", "),
+		Website:   c.website,
+		Confirm:   confirm,
+		Hold:      hold,
+		Expires:   expires.UTC().Format(time.RFC1123),
+	})
+	if err != nil {
+		return err
+	}
+	return c.sendEmail(promotionConfirmationSubject, emailContent, ValidationAddress(domain))
+}
+
+// SendPruneSuggestion suggests that the domain's validation address prunes
+// MX patterns that no longer match any of its mailservers from its policy.
+func (c Config) SendPruneSuggestion(domain *models.Domain, patterns []string) error {
+	emailContent, err := c.renderText("prune_suggestion",
+		pruneSuggestionData{Domain: domain.Name, Patterns: patterns, Website: c.website})
+	if err != nil {
+		return err
+	}
+	return c.sendEmail(pruneSuggestionSubject, emailContent, ValidationAddress(domain))
+}
+
+// SendRemovalVerification sends the domain's validation address a token for
+// confirming that it should be removed from the list, after grace.
+func (c Config) SendRemovalVerification(domain *models.Domain, token string, grace time.Duration) error {
+	emailContent, err := c.renderText("removal_verification", removalVerificationData{
+		Domain:    domain.Name,
+		Token:     token,
+		Website:   c.website,
+		GraceDays: int(grace.Hours() / 24),
+	})
+	if err != nil {
+		return err
+	}
+	return c.sendEmail(removalVerificationSubject, emailContent, ValidationAddress(domain))
+}
+
+// Notifies returns true if notifications can be sent to domains' contacts,
+// which needs UNSUBSCRIBE_SECRET to sign their opt-out links.
+func (c Config) Notifies() bool {
+	return len(c.unsubscribeSecret) > 0
+}
+
+// notification returns the parts common to every notification about domain.
+func (c Config) notification(domain *models.Domain) (notificationData, error) {
+	if !c.Notifies() {
+		return notificationData{}, fmt.Errorf("UNSUBSCRIBE_SECRET isn't set, so notifications can't be opted out of")
+	}
+	link := models.UnsubscribeLink(c.unsubscribeSecret, ContactAddress(domain))
+	return notificationData{Domain: domain.Name, Website: c.website,
+		Unsubscribe: fmt.Sprintf("%s/unsubscribe?%s", c.website, link)}, nil
+}
+
+// sendNotification emails the notification rendered from
+// views/email/<name>.txt.tmpl to domain's contact, with a List-Unsubscribe
+// header so mail clients can offer to opt out of them.
+func (c Config) sendNotification(domain *models.Domain, subject string, name string, n notificationData, data interface{}) error {
+	emailContent, err := c.renderText(name, data)
+	if err != nil {
+		return err
+	}
+	headers := fmt.Sprintf("List-Unsubscribe: <%s>\n", n.Unsubscribe)
+	return c.send(subject, headers, emailContent, ContactAddress(domain))
+}
+
+// SendListAdded tells the domain's contact that it was added to the list.
+func (c Config) SendListAdded(domain *models.Domain) error {
+	n, err := c.notification(domain)
+	if err != nil {
+		return err
+	}
+	return c.sendNotification(domain, listAddedSubject, "list_added", n,
+		listAddedData{notificationData: n, Hostnames: strings.Join(domain.MXs, ", ")})
+}
+
+// SendValidationFailure tells the domain's contact that its mailservers
+// failed validation against its policy, and why.
+func (c Config) SendValidationFailure(domain *models.Domain, result checker.DomainResult) error {
+	n, err := c.notification(domain)
+	if err != nil {
+		return err
+	}
+	return c.sendNotification(domain, validationFailureSubject, "validation_failure", n,
+		validationFailureData{notificationData: n, Listed: domain.State == models.StateEnforce,
+			Problems: failureProblems(result)})
+}
+
+// SendRemovalWarning warns the domain's contact that it may be removed from
+// the list, since its mailservers have failed validation runs in a row.
+func (c Config) SendRemovalWarning(domain *models.Domain, runs int, result checker.DomainResult) error {
+	n, err := c.notification(domain)
+	if err != nil {
+		return err
+	}
+	return c.sendNotification(domain, removalWarningSubject, "removal_warning", n,
+		removalWarningData{notificationData: n, Runs: runs, Problems: failureProblems(result)})
+}
+
+// failureProblems describes the failed checks of each of result's
+// mailservers.
+func failureProblems(result checker.DomainResult) []string {
+	var problems []string
+	for _, hostname := range result.PreferredHostnames {
+		h := result.HostnameResults[hostname]
+		if h.Result == nil {
+			continue
+		}
+		names := make([]string, 0, len(h.Checks))
+		for name := range h.Checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			check := h.Checks[name]
+			if check == nil || check.Status == checker.Success {
+				continue
+			}
+			problem := fmt.Sprintf("%s: %s %s", hostname, name, strings.ToLower(check.StatusText()))
+			if len(check.Messages) > 0 {
+				problem += fmt.Sprintf(" (%s)", strings.Join(check.Messages, " "))
+			}
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) == 0 && result.Message != "" {
+		problems = append(problems, result.Message)
+	}
+	if len(problems) == 0 {
+		problems = append(problems, "None of its mailservers could be checked.")
+	}
+	return problems
+}
+
+// SendAlert emails a monitoring alert to ALERT_EMAIL, if it's configured.
+func (c Config) SendAlert(a alerts.Alert) error {
+	if c.alertAddress == "" {
+		return nil
+	}
+	return c.sendEmail("[STARTTLS Everywhere alert] "+a.Rule.Name, a.Message, c.alertAddress)
+}
+
+// SendReport emails an operations report to REPORT_EMAIL, or ALERT_EMAIL if
+// that isn't set, as Markdown text with an HTML alternative.
+func (c Config) SendReport(r report.Report) error {
+	address := c.reportAddress
+	if address == "" {
+		address = c.alertAddress
+	}
+	if address == "" {
+		return nil
+	}
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", r.Markdown},
+		{"text/html; charset=utf-8", r.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write([]byte(part.content)); err != nil {
+			return err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return err
+	}
+	headers := fmt.Sprintf("MIME-Version: 1.0\nContent-Type: multipart/alternative; boundary=%s\n", parts.Boundary())
+	return c.send(r.Subject(), headers, body.String(), address)
+}
+
 func (c Config) sendEmail(subject string, body string, address string) error {
+	return c.send(subject, "", body, address)
+}
+
+// send emails body to address, with extra headers (each ending in a newline).
+func (c Config) send(subject string, headers string, body string, address string) error {
 	blacklisted, err := c.database.IsBlacklistedEmail(address)
 	if err != nil {
 		return err
@@ -99,16 +393,19 @@ func (c Config) sendEmail(subject string, body string, address string) error {
 	if blacklisted {
 		return fmt.Errorf("address %s is blacklisted", address)
 	}
-	message := fmt.Sprintf("From: %s\nTo: %s\nSubject: %s\n\n%s",
-		c.sender, address, subject, body)
+	message := fmt.Sprintf("From: %s\nTo: %s\nSubject: %s\n%s\n%s",
+		c.sender, address, subject, headers, body)
 	if c.submissionHostname == "" {
 		log.Println("Warning: email host not configured, not sending email")
 		log.Println(message)
 		return nil
 	}
-	return smtp.SendMail(fmt.Sprintf("%s:%s", c.submissionHostname, c.port),
+	start := time.Now()
+	err = smtp.SendMail(fmt.Sprintf("%s:%s", c.submissionHostname, c.port),
 		c.auth,
 		c.sender, []string{address}, []byte(message))
+	slo.Record(slo.EmailDelivery, err == nil, time.Since(start))
+	return err
 }
 
 // Recipients lists the email addresses that have triggered a bounce or complaint.
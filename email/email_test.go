@@ -5,6 +5,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
 	"github.com/EFForg/starttls-backend/util"
 )
 
@@ -28,12 +30,93 @@ func newMockStore() *mockBlacklistStore {
 }
 
 func TestValidationEmailText(t *testing.T) {
-	content := validationEmailText("example.com", "contact@example.com", []string{"mx.example.com, .mx.example.com"}, "abcd", "https://fake.starttls-everywhere.website")
+	c := Config{website: "https://fake.starttls-everywhere.website"}
+	content, err := c.validationEmailText("example.com", "contact@example.com", []string{"mx.example.com, .mx.example.com"}, "abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !strings.Contains(content, "https://fake.starttls-everywhere.website/validate?abcd") {
 		t.Errorf("E-mail formatted incorrectly.")
 	}
 }
 
+func TestSubmissionRejectedText(t *testing.T) {
+	c := Config{website: "https://fake.starttls-everywhere.website"}
+	content, err := c.renderText("submission_rejected",
+		submissionRejectedData{Domain: "example.com", Note: "not your domain", Website: c.website})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content, "*example.com*") || !strings.Contains(content, "Our reviewer's note: not your domain") {
+		t.Errorf("E-mail formatted incorrectly: %s", content)
+	}
+}
+
+func TestPruneSuggestionText(t *testing.T) {
+	c := Config{website: "https://fake.starttls-everywhere.website"}
+	content, err := c.renderText("prune_suggestion",
+		pruneSuggestionData{Domain: "example.com", Patterns: []string{".old.example.com", "mx2.example.com"}, Website: c.website})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content, " .old.example.com\n mx2.example.com\n") {
+		t.Errorf("E-mail formatted incorrectly: %s", content)
+	}
+}
+
+func TestRemovalVerificationText(t *testing.T) {
+	c := Config{website: "https://fake.starttls-everywhere.website"}
+	content, err := c.renderText("removal_verification",
+		removalVerificationData{Domain: "example.com", Token: "abcd", Website: c.website, GraceDays: 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content, "https://fake.starttls-everywhere.website/remove?abcd") ||
+		!strings.Contains(content, "grace period of 14 days") {
+		t.Errorf("E-mail formatted incorrectly: %s", content)
+	}
+}
+
+func TestNotificationText(t *testing.T) {
+	c := Config{website: "https://fake.starttls-everywhere.website", unsubscribeSecret: []byte("secret")}
+	domain := &models.Domain{Name: "example.com", Email: "contact@example.com", State: models.StateEnforce}
+	n, err := c.notification(domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(n.Unsubscribe, "https://fake.starttls-everywhere.website/unsubscribe?email=contact%40example.com&signature=") {
+		t.Errorf("Expected an opt-out link for the contact address, got %s", n.Unsubscribe)
+	}
+	result := checker.DomainResult{Domain: "example.com", PreferredHostnames: []string{"mx.example.com"},
+		HostnameResults: map[string]checker.HostnameResult{"mx.example.com": {Result: &checker.Result{
+			Checks: map[string]*checker.Result{
+				"connectivity": {Status: checker.Success},
+				"starttls":     {Status: checker.Failure, Messages: []string{"STARTTLS isn't supported."}},
+			}}}}}
+	content, err := c.renderText("validation_failure", validationFailureData{notificationData: n, Listed: true,
+		Problems: failureProblems(result)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content, " mx.example.com: starttls failure (STARTTLS isn't supported.)\n") ||
+		strings.Contains(content, "connectivity") || !strings.Contains(content, "remove example.com") ||
+		!strings.Contains(content, n.Unsubscribe) {
+		t.Errorf("E-mail formatted incorrectly: %s", content)
+	}
+	if _, err = (Config{}).notification(domain); err == nil {
+		t.Error("Expected notifications to need UNSUBSCRIBE_SECRET")
+	}
+}
+
+func TestContactAddress(t *testing.T) {
+	if got := ContactAddress(&models.Domain{Name: "example.com", Email: "contact@example.com"}); got != "contact@example.com" {
+		t.Errorf("Expected the contact email, got %s", got)
+	}
+	if got := ContactAddress(&models.Domain{Name: "example.com"}); got != "postmaster@example.com" {
+		t.Errorf("Expected the validation address without a contact email, got %s", got)
+	}
+}
+
 func shouldPanic(t *testing.T, message string) {
 	if r := recover(); r == nil {
 		t.Errorf(message)
@@ -81,3 +164,16 @@ func TestSendEmailToBlacklistedAddressFails(t *testing.T) {
 		t.Error("attempting to send mail to blacklisted address should fail")
 	}
 }
+
+func TestLoggingConfigSendsNothing(t *testing.T) {
+	c := MakeLoggingConfig(nil)
+	store := newMockStore()
+	store.PutBlacklistedEmail("fail@example.com", "bounce", "2017-07-21T18:47:13.498Z")
+	c.database = store
+	if err := c.sendEmail("Subject", "Body", "ok@example.com"); err != nil {
+		t.Errorf("Expected the email to be logged, got %v", err)
+	}
+	if err := c.sendEmail("Subject", "Body", "fail@example.com"); err == nil {
+		t.Error("Expected the email to a blacklisted address to be refused")
+	}
+}
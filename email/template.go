@@ -1,20 +1,116 @@
 package email
 
+// Email bodies are rendered from the templates in views/email.
+
 const validationEmailSubject = "Email validation for STARTTLS Policy List submission"
-const validationEmailTemplate = `
-Hey there!
 
-It looks like you requested *%[1]s* to be added to the STARTTLS Policy List, with hostnames %[2]s and contact email %[5]s. If this was you, visit
+// validationEmailData fills in views/email/validation.txt.tmpl.
+type validationEmailData struct {
+	Domain       string
+	Hostnames    string
+	ContactEmail string
+	Website      string
+	Token        string
+}
+
+const apiKeyVerificationSubject = "Email verification for STARTTLS Everywhere API keys"
+
+// apiKeyVerificationData fills in views/email/api_key_verification.txt.tmpl.
+type apiKeyVerificationData struct {
+	Token   string
+	Website string
+}
+
+const dataExportVerificationSubject = "Email verification for your STARTTLS Everywhere data"
+
+// dataExportVerificationData fills in
+// views/email/data_export_verification.txt.tmpl.
+type dataExportVerificationData struct {
+	Token   string
+	Website string
+}
+
+const dataErasureVerificationSubject = "Confirm erasing your STARTTLS Everywhere data"
+
+// dataErasureVerificationData fills in
+// views/email/data_erasure_verification.txt.tmpl.
+type dataErasureVerificationData struct {
+	Token   string
+	Website string
+}
+
+const submissionRejectedSubject = "Your STARTTLS Policy List submission"
+
+// submissionRejectedData fills in views/email/submission_rejected.txt.tmpl.
+type submissionRejectedData struct {
+	Domain  string
+	Note    string
+	Website string
+}
+
+const promotionConfirmationSubject = "Please confirm your domain is ready for the STARTTLS Policy List"
+
+// promotionConfirmationData fills in
+// views/email/promotion_confirmation.txt.tmpl.
+type promotionConfirmationData struct {
+	Domain    string
+	Hostnames string
+	Website   string
+	Confirm   string
+	Hold      string
+	Expires   string
+}
+
+const pruneSuggestionSubject = "Your domain's STARTTLS policy has MX patterns that are no longer in use"
+
+// pruneSuggestionData fills in views/email/prune_suggestion.txt.tmpl.
+type pruneSuggestionData struct {
+	Domain   string
+	Patterns []string
+	Website  string
+}
+
+// notificationData fills in the parts common to the notifications we send
+// domains' contacts, which can always be opted out of.
+type notificationData struct {
+	Domain      string
+	Website     string
+	Unsubscribe string
+}
+
+const listAddedSubject = "Your domain is on the STARTTLS Policy List"
+
+// listAddedData fills in views/email/list_added.txt.tmpl.
+type listAddedData struct {
+	notificationData
+	Hostnames string
+}
 
- %[3]s/validate?%[4]s
+const validationFailureSubject = "Your domain failed its STARTTLS Policy List checks"
 
-to confirm! If this wasn't you, please let us know at starttls-policy@eff.org.
+// validationFailureData fills in views/email/validation_failure.txt.tmpl.
+type validationFailureData struct {
+	notificationData
+	Listed   bool
+	Problems []string
+}
 
-Once you confirm your email address, your domain will be queued for addition some time in the next couple of weeks. We will continue to run validation checks (%[3]s/policy-list#add) against your email server until then. *%[1]s* will be added to the STARTTLS Policy List as long as it has continued to pass our tests!
+const removalWarningSubject = "Your domain may be removed from the STARTTLS Policy List"
 
-Remember to read our guidelines (%[3]s/policy-list) about the requirements your mailserver must meet, and continue to meet, in order to stay on the list. If your mailserver ceases to meet these requirements at any point and is at risk of facing deliverability issues, we will notify you through this email address.
+// removalWarningData fills in views/email/removal_warning.txt.tmpl.
+type removalWarningData struct {
+	notificationData
+	Runs     int
+	Problems []string
+}
 
-We also recommend signing up for the STARTTLS Everywhere mailing list at https://lists.eff.org/mailman/listinfo/starttls-everywhere in order to stay up to date on new features, changes to policies, and updates to the project. (This is a low-volume mailing list.)
+const removalVerificationSubject = "Confirm removing your domain from the STARTTLS Policy List"
 
-Thanks for helping us secure email for everyone :)
-`
+// removalVerificationData fills in
+// views/email/removal_verification.txt.tmpl.
+type removalVerificationData struct {
+	Domain    string
+	Token     string
+	Website   string
+	GraceDays int
+}
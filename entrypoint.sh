@@ -1,8 +1,6 @@
 #!/bin/sh
 
-if [ "$DB_MIGRATE" = "true" ]; then
-    # Perform ouststanding DB migrations
-    PGPASSWORD=$DB_PASSWORD psql -h $DB_HOST -U $DB_USERNAME $DB_NAME -f ./db/scripts/init_tables.sql
-fi
+# The server applies outstanding DB migrations itself on startup when
+# DB_MIGRATE is true, or run `starttls-backend migrate` to apply them alone.
 
 exec "$@"
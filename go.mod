@@ -1,6 +1,6 @@
 module github.com/EFForg/starttls-backend
 
-go 1.11
+go 1.16
 
 require (
 	github.com/certifi/gocertifi v0.0.0-20190506164543-d2eda7129713 // indirect
@@ -11,8 +11,9 @@ require (
 	github.com/lib/pq v1.1.1
 	github.com/mhale/smtpd v0.0.0-20181125220505-3c4c908952b8
 	github.com/pkg/errors v0.8.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.2.2 // indirect
 	github.com/ulule/limiter v2.2.2+incompatible
-	golang.org/x/net v0.0.0-20190611141213-3f473d35a33a
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
+	google.golang.org/grpc v1.46.2
+	google.golang.org/protobuf v1.27.1
+	gopkg.in/yaml.v3 v3.0.1
 )
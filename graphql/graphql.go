@@ -0,0 +1,529 @@
+// Package graphql executes read-only GraphQL queries against a schema of Go
+// resolvers. It supports the query language's operations, variables,
+// aliases, fragments and the @skip and @include directives, but not
+// mutations, subscriptions or introspection beyond __typename.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Schema is the types a query can select fields of, starting with Query.
+type Schema struct {
+	Query *Object
+	// MaxDepth limits how deeply queries can nest selection sets, and
+	// MaxCost limits the total Cost of the fields they select, with list
+	// fields' selections counted once for each item they may return. Zero
+	// means no limit.
+	MaxDepth int
+	MaxCost  int
+}
+
+// Object is a GraphQL object type.
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field is a field of an Object.
+type Field struct {
+	// Type is the Object the field's value, or each value in its list, is,
+	// or nil if it's a scalar.
+	Type *Object
+	// Args are the names of the arguments the field accepts, mapped to
+	// whether they're required.
+	Args map[string]bool
+	// Resolve returns the field's value on source, the value of the object
+	// it's a field of. Objects, and lists of them, are passed on as the
+	// source of their own fields, and scalars are encoded as JSON. A nil
+	// value, or nil pointer, is null.
+	Resolve func(ctx context.Context, source interface{}, args Args) (interface{}, error)
+	// Cost is what resolving the field once counts against the schema's
+	// MaxCost. Fields cost 1 if it's zero.
+	Cost int
+	// ListSize returns, for a list field, the most items it resolves to with
+	// args, which its selections' cost is multiplied by.
+	ListSize func(args Args) int
+}
+
+// Args are the arguments given to a field, with variables replaced by their
+// values.
+type Args map[string]interface{}
+
+// String returns the string argument name, or "" if it wasn't given.
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+// Int returns the integer argument name, or def if it wasn't given.
+func (a Args) Int(name string, def int) (int, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return v, nil
+	case float64:
+		// Integers in variables are decoded from JSON as floats.
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %s must be an integer", name)
+}
+
+// Strings returns the list of strings argument name. A single string is
+// treated as a list of one, as GraphQL's input coercion does.
+func (a Args) Strings(name string) ([]string, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		strings := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("argument %s must be a list of strings", name)
+			}
+			strings = append(strings, s)
+		}
+		return strings, nil
+	}
+	return nil, fmt.Errorf("argument %s must be a list of strings", name)
+}
+
+// Request is a GraphQL request, as sent in the body of a POST request.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Error is an error executing a request. Path is the response keys and list
+// indexes of the field that failed, if any.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Response is the result of executing a request. Data is nil if the request
+// couldn't be executed at all.
+type Response struct {
+	Data   *OrderedMap `json:"data,omitempty"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// OrderedMap is a JSON object that keeps its keys in the order they were
+// set, so responses have fields in the order they were selected.
+type OrderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *OrderedMap {
+	return &OrderedMap{values: map[string]interface{}{}}
+}
+
+// Set sets key to value.
+func (m *OrderedMap) Set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Get returns the value set at key.
+func (m *OrderedMap) Get(key string) interface{} {
+	return m.values[key]
+}
+
+// MarshalJSON encodes the map with its keys in order.
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		encodedKey, _ := json.Marshal(key)
+		b.Write(encodedKey)
+		b.WriteByte(':')
+		value, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// requestError is a response that failed before execution began.
+func requestError(format string, a ...interface{}) Response {
+	return Response{Errors: []Error{{Message: fmt.Sprintf(format, a...)}}}
+}
+
+// Execute parses, validates and executes req against schema. If the request
+// is invalid, the response has errors and no data.
+func (s *Schema) Execute(ctx context.Context, req Request) Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return requestError("syntax error: %v", err)
+	}
+	var op *operation
+	for _, candidate := range doc.operations {
+		if req.OperationName == "" && len(doc.operations) > 1 {
+			return requestError("operationName is required with more than one operation")
+		}
+		if req.OperationName == "" || candidate.name == req.OperationName {
+			op = candidate
+			break
+		}
+	}
+	if op == nil {
+		return requestError("unknown operation %s", req.OperationName)
+	}
+	e := &executor{ctx: ctx, doc: doc, variables: map[string]interface{}{}}
+	for _, def := range op.variables {
+		value, given := req.Variables[def.name]
+		if !given && def.hasDefault {
+			value, given = def.defaults, true
+		}
+		if def.nonNull && value == nil {
+			return requestError("variable $%s is required", def.name)
+		}
+		if given {
+			e.variables[def.name] = value
+		}
+		e.declared = append(e.declared, def.name)
+	}
+	if err := doc.checkFragmentCycles(); err != nil {
+		return requestError("%v", err)
+	}
+	if _, err := e.validate(s, s.Query, op.selections, 1); err != nil {
+		return requestError("%v", err)
+	}
+	data := newOrderedMap()
+	e.executeSelections(s.Query, nil, op.selections, nil, data)
+	return Response{Data: data, Errors: e.errors}
+}
+
+type executor struct {
+	ctx       context.Context
+	doc       *document
+	variables map[string]interface{}
+	declared  []string
+	errors    []Error
+}
+
+// collectedField is every selection of a response key.
+type collectedField struct {
+	key        string
+	field      *selection
+	selections []*selection
+}
+
+// collectFields returns the fields selected on object by selections, with
+// fragments expanded and fields selected more than once merged. A fragment
+// spread more than once is only expanded the first time. If all is set,
+// fields skipped by directives are included, for validation.
+func (e *executor) collectFields(object *Object, selections []*selection, all bool) ([]*collectedField, error) {
+	var fields []*collectedField
+	byKey := map[string]*collectedField{}
+	visited := map[string]bool{}
+	var collect func(selections []*selection) error
+	collect = func(selections []*selection) error {
+		for _, s := range selections {
+			included, err := e.included(s.directives)
+			if err != nil {
+				return err
+			}
+			if !included && !all {
+				continue
+			}
+			switch {
+			case s.spread != "":
+				f, ok := e.doc.fragments[s.spread]
+				if !ok {
+					return fmt.Errorf("unknown fragment %s", s.spread)
+				}
+				if f.typeCondition != object.Name {
+					return fmt.Errorf("fragment %s on %s can't be spread on %s", f.name, f.typeCondition, object.Name)
+				}
+				if visited[f.name] {
+					continue
+				}
+				visited[f.name] = true
+				if err := collect(f.selections); err != nil {
+					return err
+				}
+			case s.inline:
+				if s.typeCondition != "" && s.typeCondition != object.Name {
+					return fmt.Errorf("fragment on %s can't be spread on %s", s.typeCondition, object.Name)
+				}
+				if err := collect(s.selections); err != nil {
+					return err
+				}
+			default:
+				key := s.responseKey()
+				if existing, ok := byKey[key]; ok {
+					if existing.field.name != s.name {
+						return fmt.Errorf("%s selects both %s and %s", key, existing.field.name, s.name)
+					}
+					existing.selections = append(existing.selections, s.selections...)
+					continue
+				}
+				field := &collectedField{key: key, field: s, selections: s.selections}
+				byKey[key] = field
+				fields = append(fields, field)
+			}
+		}
+		return nil
+	}
+	return fields, collect(selections)
+}
+
+// checkFragmentCycles checks that no fragment spreads itself, directly or
+// through other fragments.
+func (d *document) checkFragmentCycles() error {
+	done := map[string]bool{}
+	var check func(selections []*selection, spreading map[string]bool) error
+	check = func(selections []*selection, spreading map[string]bool) error {
+		for _, s := range selections {
+			if s.spread != "" {
+				f, ok := d.fragments[s.spread]
+				if !ok || done[f.name] {
+					continue
+				}
+				if spreading[f.name] {
+					return fmt.Errorf("fragment %s spreads itself", f.name)
+				}
+				spreading[f.name] = true
+				if err := check(f.selections, spreading); err != nil {
+					return err
+				}
+				delete(spreading, f.name)
+				done[f.name] = true
+			}
+			if err := check(s.selections, spreading); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for name, f := range d.fragments {
+		if done[name] {
+			continue
+		}
+		if err := check(f.selections, map[string]bool{name: true}); err != nil {
+			return err
+		}
+		done[name] = true
+	}
+	return nil
+}
+
+// included applies the @skip and @include directives.
+func (e *executor) included(directives []directive) (bool, error) {
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			return false, fmt.Errorf("unknown directive @%s", d.name)
+		}
+		for _, arg := range d.args {
+			if err := e.checkVariables(arg.value); err != nil {
+				return false, err
+			}
+		}
+		condition, ok := e.arguments(d.args)["if"].(bool)
+		if !ok {
+			return false, fmt.Errorf("@%s requires a boolean if argument", d.name)
+		}
+		if condition == (d.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// validate checks that every field selected on object exists and is given
+// the arguments it needs, and that the selections are within the schema's
+// limits, before anything is resolved. It returns the selections' cost.
+func (e *executor) validate(schema *Schema, object *Object, selections []*selection, depth int) (int, error) {
+	if schema.MaxDepth > 0 && depth > schema.MaxDepth {
+		return 0, fmt.Errorf("queries can be nested at most %d deep", schema.MaxDepth)
+	}
+	fields, err := e.collectFields(object, selections, true)
+	if err != nil {
+		return 0, err
+	}
+	cost := 0
+	for _, f := range fields {
+		if f.field.name == "__typename" {
+			if len(f.selections) > 0 {
+				return 0, fmt.Errorf("__typename can't have a selection set")
+			}
+			continue
+		}
+		field, ok := object.Fields[f.field.name]
+		if !ok {
+			return 0, fmt.Errorf("%s has no field %s", object.Name, f.field.name)
+		}
+		given := map[string]bool{}
+		for _, arg := range f.field.args {
+			if _, ok := field.Args[arg.name]; !ok {
+				return 0, fmt.Errorf("%s.%s has no argument %s", object.Name, f.field.name, arg.name)
+			}
+			if err := e.checkVariables(arg.value); err != nil {
+				return 0, err
+			}
+			given[arg.name] = true
+		}
+		for name, required := range field.Args {
+			if required && !given[name] {
+				return 0, fmt.Errorf("%s.%s requires argument %s", object.Name, f.field.name, name)
+			}
+		}
+		if field.Type == nil && len(f.selections) > 0 {
+			return 0, fmt.Errorf("%s.%s is a scalar, so it can't have a selection set", object.Name, f.field.name)
+		}
+		if field.Type != nil && len(f.selections) == 0 {
+			return 0, fmt.Errorf("%s.%s is a %s, so it needs a selection set", object.Name, f.field.name, field.Type.Name)
+		}
+		fieldCost := field.Cost
+		if fieldCost == 0 {
+			fieldCost = 1
+		}
+		if field.Type != nil {
+			selectionsCost, err := e.validate(schema, field.Type, f.selections, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			if field.ListSize != nil {
+				if size := field.ListSize(e.arguments(f.field.args)); size > 1 {
+					selectionsCost *= size
+				}
+			}
+			fieldCost += selectionsCost
+		}
+		cost += fieldCost
+		if schema.MaxCost > 0 && cost > schema.MaxCost {
+			return 0, fmt.Errorf("query costs more than %d", schema.MaxCost)
+		}
+	}
+	return cost, nil
+}
+
+// checkVariables checks that the variables value refers to are declared.
+func (e *executor) checkVariables(value interface{}) error {
+	switch v := value.(type) {
+	case variable:
+		for _, name := range e.declared {
+			if name == string(v) {
+				return nil
+			}
+		}
+		return fmt.Errorf("variable $%s isn't declared", v)
+	case []interface{}:
+		for _, item := range v {
+			if err := e.checkVariables(item); err != nil {
+				return err
+			}
+		}
+	case objectValue:
+		for _, field := range v {
+			if err := e.checkVariables(field.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// arguments returns args with variables replaced by their values.
+func (e *executor) arguments(args []argument) Args {
+	values := Args{}
+	for _, arg := range args {
+		values[arg.name] = e.resolveValue(arg.value)
+	}
+	return values
+}
+
+func (e *executor) resolveValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case variable:
+		return e.variables[string(v)]
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.resolveValue(item)
+		}
+		return list
+	case objectValue:
+		object := map[string]interface{}{}
+		for _, field := range v {
+			object[field.name] = e.resolveValue(field.value)
+		}
+		return object
+	}
+	return value
+}
+
+// executeSelections resolves the fields selected on source, an object, into
+// result.
+func (e *executor) executeSelections(object *Object, source interface{}, selections []*selection, path []interface{}, result *OrderedMap) {
+	fields, err := e.collectFields(object, selections, false)
+	if err != nil {
+		e.fail(path, err)
+		return
+	}
+	for _, f := range fields {
+		fieldPath := append(append([]interface{}{}, path...), f.key)
+		if f.field.name == "__typename" {
+			result.Set(f.key, object.Name)
+			continue
+		}
+		field := object.Fields[f.field.name]
+		value, err := field.Resolve(e.ctx, source, e.arguments(f.field.args))
+		if err != nil {
+			e.fail(fieldPath, err)
+			result.Set(f.key, nil)
+			continue
+		}
+		result.Set(f.key, e.complete(field, value, f.selections, fieldPath))
+	}
+}
+
+// complete converts a resolved value to its JSON result.
+func (e *executor) complete(field *Field, value interface{}, selections []*selection, path []interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || ((v.Kind() == reflect.Ptr || v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.IsNil()) {
+		return nil
+	}
+	if field.Type == nil {
+		if t, ok := value.(time.Time); ok {
+			return t.UTC().Format(time.RFC3339)
+		}
+		return value
+	}
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = e.complete(field, v.Index(i).Interface(), selections, append(append([]interface{}{}, path...), i))
+		}
+		return list
+	}
+	result := newOrderedMap()
+	e.executeSelections(field.Type, value, selections, path, result)
+	return result
+}
+
+func (e *executor) fail(path []interface{}, err error) {
+	e.errors = append(e.errors, Error{Message: err.Error(), Path: path})
+}
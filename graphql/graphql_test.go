@@ -0,0 +1,169 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type author struct {
+	Name  string
+	Books []book
+}
+
+type book struct {
+	Title string
+	Year  int
+}
+
+func testSchema() *Schema {
+	bookType := &Object{Name: "Book", Fields: map[string]*Field{
+		"title": {Resolve: func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+			return source.(book).Title, nil
+		}},
+		"year": {Resolve: func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+			return source.(book).Year, nil
+		}},
+	}}
+	authorType := &Object{Name: "Author", Fields: map[string]*Field{
+		"name": {Resolve: func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+			return source.(*author).Name, nil
+		}},
+		"books": {Type: bookType, Args: map[string]bool{"limit": false},
+			Resolve: func(_ context.Context, source interface{}, args Args) (interface{}, error) {
+				books := source.(*author).Books
+				limit, err := args.Int("limit", len(books))
+				if err != nil {
+					return nil, err
+				}
+				if limit < len(books) {
+					books = books[:limit]
+				}
+				return books, nil
+			}},
+	}}
+	authors := map[string]*author{"le guin": {Name: "Ursula K. Le Guin",
+		Books: []book{{"A Wizard of Earthsea", 1968}, {"The Dispossessed", 1974}}}}
+	return &Schema{Query: &Object{Name: "Query", Fields: map[string]*Field{
+		"author": {Type: authorType, Args: map[string]bool{"name": true},
+			Resolve: func(_ context.Context, _ interface{}, args Args) (interface{}, error) {
+				if args.String("name") == "broken" {
+					return nil, errors.New("broken")
+				}
+				return authors[args.String("name")], nil
+			}},
+	}}}
+}
+
+func execute(t *testing.T, req Request) string {
+	resp := testSchema().Execute(context.Background(), req)
+	b, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		req      Request
+		expected string
+	}{
+		{Request{Query: `{ author(name: "le guin") { name } }`},
+			`{"data":{"author":{"name":"Ursula K. Le Guin"}}}`},
+		{Request{Query: `# comment
+			query Books($name: String!, $limit: Int = 1) {
+				author(name: $name) { __typename, books(limit: $limit) { title year } }
+			}`, Variables: map[string]interface{}{"name": "le guin"}},
+			`{"data":{"author":{"__typename":"Author","books":[{"title":"A Wizard of Earthsea","year":1968}]}}}`},
+		{Request{Query: `{ a: author(name: "le guin") { ...Name } b: author(name: "nobody") { ...Name } }
+			fragment Name on Author { name }`},
+			`{"data":{"a":{"name":"Ursula K. Le Guin"},"b":null}}`},
+		{Request{Query: `query($all: Boolean!) { author(name: "le guin") {
+				books { title } ... on Author { books @include(if: $all) { year } } } }`,
+			Variables: map[string]interface{}{"all": true}},
+			`{"data":{"author":{"books":[{"title":"A Wizard of Earthsea","year":1968},{"title":"The Dispossessed","year":1974}]}}}`},
+		{Request{Query: `{ author(name: "le guin") { name @skip(if: true) books(limit: 1.5) { title } } }`},
+			`{"data":{"author":{"books":null}},"errors":[{"message":"argument limit must be an integer","path":["author","books"]}]}`},
+		{Request{Query: `{ ok: author(name: "le guin") { name } broken: author(name: "broken") { name } }`},
+			`{"data":{"ok":{"name":"Ursula K. Le Guin"},"broken":null},"errors":[{"message":"broken","path":["broken"]}]}`},
+		{Request{Query: `query A { author(name: "le guin") { name } } query B { author(name: "nobody") { name } }`,
+			OperationName: "B"},
+			`{"data":{"author":null}}`},
+		{Request{Query: `{ author(name: "le guin") { ...Name ...Name ... on Author { ...Name } } }
+			fragment Name on Author { name }`},
+			`{"data":{"author":{"name":"Ursula K. Le Guin"}}}`},
+	}
+	for _, test := range tests {
+		if got := execute(t, test.req); got != test.expected {
+			t.Errorf("Expected %s to respond %s, got %s", test.req.Query, test.expected, got)
+		}
+	}
+}
+
+func TestExecuteInvalid(t *testing.T) {
+	tests := []struct {
+		req   Request
+		error string
+	}{
+		{Request{Query: `{ author(name: "le guin") { name }`}, "unexpected end of document"},
+		{Request{Query: `mutation { author }`}, "mutations aren't supported"},
+		{Request{Query: `{ author(name: "le guin") { age } }`}, "Author has no field age"},
+		{Request{Query: `{ author { name } }`}, "requires argument name"},
+		{Request{Query: `{ author(name: "x", born: 1929) { name } }`}, "has no argument born"},
+		{Request{Query: `{ author(name: "x") }`}, "needs a selection set"},
+		{Request{Query: `{ author(name: "x") { name { first } } }`}, "can't have a selection set"},
+		{Request{Query: `{ author(name: $name) { name } }`}, "variable $name isn't declared"},
+		{Request{Query: `query($name: String!) { author(name: $name) { name } }`}, "variable $name is required"},
+		{Request{Query: `{ author(name: "x") { ...A } } fragment A on Author { ...A }`}, "spreads itself"},
+		{Request{Query: `{ author(name: "x") { name } } fragment A on Author { books { ...B } } fragment B on Book { ...A }`}, "spreads itself"},
+		{Request{Query: `{ author(name: "x") { ...B } } fragment B on Book { title }`}, "can't be spread on Author"},
+		{Request{Query: `{ author(name: "x") { name name: books { title } } }`}, "selects both"},
+		{Request{Query: `query A { author(name: "x") { name } } query B { author(name: "x") { name } }`}, "operationName is required"},
+		{Request{Query: `{ author(name: """x""") { name } }`}, "block strings"},
+	}
+	for _, test := range tests {
+		resp := testSchema().Execute(context.Background(), test.req)
+		if resp.Data != nil || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, test.error) {
+			t.Errorf("Expected %s to fail with %q, got %+v", test.req.Query, test.error, resp)
+		}
+	}
+}
+
+func TestExecuteLimits(t *testing.T) {
+	schema := testSchema()
+	schema.MaxDepth = 3
+	schema.MaxCost = 10
+	schema.Query.Fields["author"].Type.Fields["books"].ListSize = func(args Args) int {
+		limit, _ := args.Int("limit", 5)
+		return limit
+	}
+	tests := []struct {
+		query string
+		error string
+	}{
+		{`{ author(name: "le guin") { books { title } } }`, ""},
+		{`{ author(name: "le guin") { books { title year } } }`, "costs more than 10"},
+		{`{ author(name: "le guin") { books(limit: 2) { title year } } }`, ""},
+		{`{ a: author(name: "le guin") { name } b: author(name: "le guin") { name }
+			c: author(name: "le guin") { name } d: author(name: "le guin") { name }
+			e: author(name: "le guin") { name } f: author(name: "le guin") { name } }`, "costs more than 10"},
+		{`{ author(name: "le guin") { books { ... on Book { title } } } }`, ""},
+	}
+	for _, test := range tests {
+		resp := schema.Execute(context.Background(), Request{Query: test.query})
+		if test.error == "" && (resp.Data == nil || len(resp.Errors) > 0) {
+			t.Errorf("Expected %s to succeed, got %+v", test.query, resp)
+		}
+		if test.error != "" && (resp.Data != nil || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, test.error)) {
+			t.Errorf("Expected %s to fail with %q, got %+v", test.query, test.error, resp)
+		}
+	}
+	schema.MaxDepth = 1
+	resp := schema.Execute(context.Background(), Request{Query: `{ author(name: "le guin") { name } }`})
+	if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "nested at most 1 deep") {
+		t.Errorf("Expected a nesting error, got %+v", resp)
+	}
+}
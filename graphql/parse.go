@@ -0,0 +1,518 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// document is a parsed GraphQL request document.
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	name       string
+	variables  []variableDefinition
+	selections []*selection
+}
+
+type variableDefinition struct {
+	name     string
+	nonNull  bool
+	defaults interface{}
+	// hasDefault is set if the definition has a default value, which may be
+	// null.
+	hasDefault bool
+}
+
+type fragment struct {
+	name          string
+	typeCondition string
+	selections    []*selection
+}
+
+// selection is a field, a fragment spread, or an inline fragment.
+type selection struct {
+	alias      string
+	name       string
+	args       []argument
+	directives []directive
+	selections []*selection
+	// spread names the fragment spread here, if any.
+	spread string
+	// inline is set for inline fragments, which apply to typeCondition, or
+	// every type if it's empty.
+	inline        bool
+	typeCondition string
+}
+
+// responseKey is the key a field's result is set at.
+func (s *selection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type argument struct {
+	name  string
+	value interface{}
+}
+
+type directive struct {
+	name string
+	args []argument
+}
+
+// variable is a reference to a variable in a value.
+type variable string
+
+// objectValue is an input object literal.
+type objectValue []argument
+
+const (
+	tokenEOF = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  int
+	value string
+	pos   int
+}
+
+// lex splits a GraphQL document into tokens. Commas, whitespace and comments
+// are ignored.
+func lex(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(source) && source[i] != '\n' && source[i] != '\r' {
+				i++
+			}
+		case strings.IndexByte("!$&()=:@[]{}|", c) >= 0:
+			tokens = append(tokens, token{tokenPunctuator, string(c), i})
+			i++
+		case strings.HasPrefix(source[i:], "..."):
+			tokens = append(tokens, token{tokenPunctuator, "...", i})
+			i += 3
+		case c == '_' || isLetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || isLetter(source[i]) || isDigit(source[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenName, source[start:i], start})
+		case c == '-' || isDigit(c):
+			start := i
+			kind := tokenInt
+			if c == '-' {
+				i++
+			}
+			for i < len(source) && (isDigit(source[i]) || strings.IndexByte(".eE+-", source[i]) >= 0) {
+				if strings.IndexByte(".eE", source[i]) >= 0 {
+					kind = tokenFloat
+				}
+				i++
+			}
+			tokens = append(tokens, token{kind, source[start:i], start})
+		case c == '"':
+			if strings.HasPrefix(source[i:], `"""`) {
+				return nil, fmt.Errorf("block strings aren't supported, at %d", i)
+			}
+			start := i
+			for i++; i < len(source) && source[i] != '"'; i++ {
+				if source[i] == '\\' {
+					i++
+				} else if source[i] == '\n' || source[i] == '\r' {
+					break
+				}
+			}
+			if i >= len(source) || source[i] != '"' {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			// GraphQL strings escape characters the same way JSON does.
+			var value string
+			if err := json.Unmarshal([]byte(source[start:i]), &value); err != nil {
+				return nil, fmt.Errorf("invalid string at %d", start)
+			}
+			tokens = append(tokens, token{tokenString, value, start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(source)}), nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse parses a GraphQL request document. Only queries are supported.
+func parse(source string) (*document, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	doc := &document{fragments: map[string]*fragment{}}
+	for p.peek().kind != tokenEOF {
+		switch {
+		case p.peekValue("{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{selections: selections})
+		case p.peekValue("query"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.peekValue("mutation"), p.peekValue("subscription"):
+			return nil, fmt.Errorf("%ss aren't supported; this API is read-only", p.peek().value)
+		case p.peekValue("fragment"):
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[f.name]; ok {
+				return nil, fmt.Errorf("fragment %s is defined more than once", f.name)
+			}
+			doc.fragments[f.name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document has no operations")
+	}
+	return doc, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekValue(value string) bool {
+	t := p.peek()
+	return (t.kind == tokenPunctuator || t.kind == tokenName) && t.value == value
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of document")
+	}
+	return fmt.Errorf("unexpected %q at %d", t.value, t.pos)
+}
+
+// expect consumes the punctuator or keyword value.
+func (p *parser) expect(value string) error {
+	if !p.peekValue(value) {
+		return p.unexpected()
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) name() (string, error) {
+	if p.peek().kind != tokenName {
+		return "", p.unexpected()
+	}
+	return p.next().value, nil
+}
+
+func (p *parser) operation() (*operation, error) {
+	p.next()
+	op := &operation{}
+	if p.peek().kind == tokenName {
+		op.name = p.next().value
+	}
+	if p.peekValue("(") {
+		p.next()
+		for !p.peekValue(")") {
+			def, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, def)
+		}
+		p.next()
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	var err error
+	op.selections, err = p.selectionSet()
+	return op, err
+}
+
+func (p *parser) variableDefinition() (variableDefinition, error) {
+	var def variableDefinition
+	if err := p.expect("$"); err != nil {
+		return def, err
+	}
+	var err error
+	if def.name, err = p.name(); err != nil {
+		return def, err
+	}
+	if err = p.expect(":"); err != nil {
+		return def, err
+	}
+	if def.nonNull, err = p.typeReference(); err != nil {
+		return def, err
+	}
+	if p.peekValue("=") {
+		p.next()
+		def.hasDefault = true
+		if def.defaults, err = p.value(true); err != nil {
+			return def, err
+		}
+	}
+	return def, nil
+}
+
+// typeReference skips a type like [String!]!, and returns whether it's
+// non-null.
+func (p *parser) typeReference() (bool, error) {
+	if p.peekValue("[") {
+		p.next()
+		if _, err := p.typeReference(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	if p.peekValue("!") {
+		p.next()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	p.next()
+	f := &fragment{}
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.name == "on" {
+		return nil, fmt.Errorf("fragments can't be named \"on\"")
+	}
+	if err = p.expect("on"); err != nil {
+		return nil, err
+	}
+	if f.typeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if _, err = p.directives(); err != nil {
+		return nil, err
+	}
+	f.selections, err = p.selectionSet()
+	return f, err
+}
+
+func (p *parser) selectionSet() ([]*selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []*selection
+	for !p.peekValue("}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, s)
+	}
+	p.next()
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("empty selection set")
+	}
+	return selections, nil
+}
+
+func (p *parser) selection() (*selection, error) {
+	s := &selection{}
+	var err error
+	if p.peekValue("...") {
+		p.next()
+		if p.peek().kind == tokenName && !p.peekValue("on") {
+			s.spread = p.next().value
+			s.directives, err = p.directives()
+			return s, err
+		}
+		s.inline = true
+		if p.peekValue("on") {
+			p.next()
+			if s.typeCondition, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if s.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		s.selections, err = p.selectionSet()
+		return s, err
+	}
+	if s.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.peekValue(":") {
+		p.next()
+		s.alias = s.name
+		if s.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if s.args, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	if s.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peekValue("{") {
+		s.selections, err = p.selectionSet()
+	}
+	return s, err
+}
+
+func (p *parser) arguments() ([]argument, error) {
+	if !p.peekValue("(") {
+		return nil, nil
+	}
+	p.next()
+	var args []argument
+	for !p.peekValue(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.value(false)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, argument{name, value})
+	}
+	p.next()
+	return args, nil
+}
+
+func (p *parser) directives() ([]directive, error) {
+	var directives []directive
+	for p.peekValue("@") {
+		p.next()
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, directive{name, args})
+	}
+	return directives, nil
+}
+
+// value parses a value. Constant values, like variables' defaults, can't
+// refer to variables. Enum values are parsed as strings.
+func (p *parser) value(constant bool) (interface{}, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenInt:
+		p.next()
+		return strconv.Atoi(t.value)
+	case tokenFloat:
+		p.next()
+		return strconv.ParseFloat(t.value, 64)
+	case tokenString:
+		p.next()
+		return t.value, nil
+	case tokenName:
+		p.next()
+		switch t.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t.value, nil
+	}
+	switch {
+	case p.peekValue("$") && !constant:
+		p.next()
+		name, err := p.name()
+		return variable(name), err
+	case p.peekValue("["):
+		p.next()
+		list := []interface{}{}
+		for !p.peekValue("]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		p.next()
+		return list, nil
+	case p.peekValue("{"):
+		p.next()
+		var object objectValue
+		for !p.peekValue("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, argument{name, value})
+		}
+		p.next()
+		return object, nil
+	}
+	return nil, p.unexpected()
+}
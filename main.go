@@ -2,24 +2,38 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/EFForg/starttls-backend/alerts"
 	"github.com/EFForg/starttls-backend/api"
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/checker/checkerpb"
 	"github.com/EFForg/starttls-backend/db"
 	"github.com/EFForg/starttls-backend/email"
+	"github.com/EFForg/starttls-backend/metrics"
+	"github.com/EFForg/starttls-backend/models"
 	"github.com/EFForg/starttls-backend/policy"
+	"github.com/EFForg/starttls-backend/promotion"
+	"github.com/EFForg/starttls-backend/report"
 	"github.com/EFForg/starttls-backend/stats"
+	"github.com/EFForg/starttls-backend/tracing"
 	"github.com/EFForg/starttls-backend/util"
 	"github.com/EFForg/starttls-backend/validator"
+	"github.com/EFForg/starttls-backend/webhooks"
 
 	"github.com/getsentry/raven-go"
 	_ "github.com/joho/godotenv/autoload"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // ServePublicEndpoints serves all public HTTP endpoints.
@@ -46,6 +60,7 @@ func ServePublicEndpoints(a *api.API, cfg *db.Config) {
 		if err := server.Shutdown(context.Background()); err != nil {
 			log.Printf("HTTP server Shutdown: %v", err)
 		}
+		tracing.Flush()
 		close(exited)
 	}()
 
@@ -53,6 +68,212 @@ func ServePublicEndpoints(a *api.API, cfg *db.Config) {
 	<-exited
 }
 
+// ServeMetrics serves the checker's Prometheus metrics at /metrics on port.
+// It's kept off the public port so that operators can restrict who scrapes it.
+func ServeMetrics(port string) {
+	portString, err := util.ValidPort(port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	log.Fatal(http.ListenAndServe(portString, mux))
+}
+
+// ServeGRPC serves the API's gRPC Scanner service on port. Scans are
+// served over TLS with the certificate and key in certFile and keyFile, or,
+// if they aren't set, only to clients on the same host.
+func ServeGRPC(a *api.API, port string, certFile string, keyFile string) {
+	portString, err := util.ValidPort(port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var options []grpc.ServerOption
+	if certFile != "" || keyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, grpc.Creds(creds))
+	} else {
+		log.Println("GRPC_TLS_CERT and GRPC_TLS_KEY aren't set, so gRPC scans are only served on localhost")
+		portString = "localhost" + portString
+	}
+	listener, err := net.Listen("tcp", portString)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := grpc.NewServer(options...)
+	checkerpb.RegisterScannerServer(server, a.ScanServer())
+	log.Fatal(server.Serve(listener))
+}
+
+// warmScanCache keeps up to maxEntries hostname results in memory in front of
+// database, starting with those scanned in the last period, so a restarted
+// server doesn't re-check mailservers that were just checked.
+func warmScanCache(database db.Database, period time.Duration, maxEntries int) *checker.MemoryCachedStore {
+	store := checker.NewMemoryCachedStore(database, maxEntries)
+	results, err := database.GetHostnameScansSince(time.Now().Add(-period))
+	if err != nil {
+		log.Printf("Could not warm hostname scan cache: %v", err)
+		return store
+	}
+	store.Warm(results)
+	log.Printf("[Warmed hostname scan cache with %d scans]", len(results))
+	return store
+}
+
+// suggestPruning emails the owners of listed domains MX patterns the list
+// validator suggests pruning from their policies, and records the suggestion
+// in the audit log.
+func suggestPruning(database db.Database, emailConfig email.Config) func(string, string, []validator.PruneSuggestion) {
+	return func(name string, domain string, suggestions []validator.PruneSuggestion) {
+		patterns := make([]string, 0, len(suggestions))
+		for _, s := range suggestions {
+			patterns = append(patterns, s.Pattern)
+		}
+		log.Printf("[%s validator] suggesting %s prunes %v from its policy", name, domain, patterns)
+		if _, err := database.PutAuditEntry(models.AuditEntry{Actor: "validator", Action: "policy.prune_suggested",
+			Subject: domain, Details: strings.Join(patterns, ", ")}); err != nil {
+			log.Printf("Couldn't record pruning suggestion for %s in the audit log: %v", domain, err)
+		}
+		d, err := database.GetDomain(domain, models.StateEnforce)
+		if err != nil {
+			// Only domains queued through this server have owners to email.
+			return
+		}
+		if err = emailConfig.SendPruneSuggestion(&d, patterns); err != nil {
+			log.Printf("Couldn't email pruning suggestion to %s: %v", domain, err)
+		}
+	}
+}
+
+// notifyFailures emails the contacts of domains queued through this server
+// when the validator first finds their mailservers failing, and warns the
+// contacts of listed domains before they're removed from the list if the
+// failures go on for warnAfter runs in a row.
+func notifyFailures(database db.Database, emailConfig email.Config, warnAfter int) func(string, string, int, checker.DomainResult) {
+	return func(name string, domain string, runs int, result checker.DomainResult) {
+		if runs != 1 && runs != warnAfter {
+			return
+		}
+		d, err := models.GetDomain(database, domain)
+		if err != nil || (d.State != models.StateTesting && d.State != models.StateEnforce) {
+			// Only domains queued through this server have contacts to email.
+			return
+		}
+		if runs == warnAfter && d.State == models.StateEnforce {
+			log.Printf("[%s validator] warning %s's contact it may be removed from the list", name, domain)
+			err = emailConfig.SendRemovalWarning(&d, runs, result)
+		} else if runs == 1 {
+			err = emailConfig.SendValidationFailure(&d, result)
+		}
+		if err != nil {
+			log.Printf("Couldn't email validation failure to the contact of %s: %v", domain, err)
+		}
+	}
+}
+
+// removerLease is the name of the lease held by the instance that removes
+// domains once their removal grace period is over.
+const removerLease = "domain-remover"
+
+// removeDueRegularly removes the domains whose removal grace period is over
+// every hour, from whichever instance holds the remover lease.
+func removeDueRegularly(database db.Database, holder string, grace time.Duration) {
+	for range time.Tick(time.Hour) {
+		ok, err := database.AcquireLease(removerLease, holder, 2*time.Hour)
+		if err != nil {
+			log.Printf("Couldn't acquire the %s lease: %v", removerLease, err)
+		}
+		if !ok {
+			continue
+		}
+		removed, err := models.RemoveDue(database, grace, time.Now())
+		if len(removed) > 0 {
+			log.Printf("[Removed %s from the list after their grace period]", strings.Join(removed, ", "))
+		}
+		if err != nil {
+			log.Printf("Couldn't remove domains pending removal: %v", err)
+		}
+	}
+}
+
+// migrate applies the database's outstanding schema migrations, and logs
+// them.
+func migrate(database *db.SQLDatabase) {
+	applied, err := database.Migrate()
+	for _, m := range applied {
+		log.Printf("[Applied migration %04d_%s]", m.Version, m.Name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	version, err := database.SchemaVersion()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("[Database schema is at version %d]", version)
+}
+
+// checkSchema exits if the database hasn't had every migration applied, so
+// that a server started against a new or outdated database without
+// DB_MIGRATE fails straight away, rather than on its first query.
+func checkSchema(database *db.SQLDatabase) {
+	migrations, err := db.Migrations()
+	if err != nil {
+		log.Fatal(err)
+	}
+	version, err := database.SchemaVersion()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if latest := migrations[len(migrations)-1].Version; version < latest {
+		log.Fatalf("Database schema is at version %d, but this server needs version %d. "+
+			"Run `starttls-backend migrate`, or set DB_MIGRATE=true to migrate on startup.", version, latest)
+	}
+}
+
+// makePolicyList returns the policy list to serve, without the domains
+// pending removal, and with the MTA-STS modes the validators have tracked for
+// domains on the list via MTA-STS. If LIST_REGION is set, the list is published through db
+// by whichever region holds the publisher lease, and that region alerts on
+// LIST_REGIONS serving different bytes.
+func makePolicyList(database db.Database, emailConfig email.Config) *policy.UpdatedList {
+	withdrawn := func() ([]string, error) { return models.PendingRemovals(database) }
+	modes := func() (map[string]string, error) { return models.ListedMTASTSModes(database) }
+	region := os.Getenv("LIST_REGION")
+	if region == "" {
+		return policy.MakeUpdatedList(withdrawn, modes)
+	}
+	regions, err := policy.ParseRegions(os.Getenv("LIST_REGIONS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	publisher := policy.Publisher{
+		Store:     database,
+		Holder:    fmt.Sprintf("%s/%s/%d", region, hostname, os.Getpid()),
+		Regions:   regions,
+		Withdrawn: withdrawn,
+		Modes:     modes,
+		OnDivergence: func(d policy.Divergence) {
+			alert := alerts.Alert{Rule: alerts.Rule{Name: "list-divergence"}, Time: time.Now(), Message: d.String()}
+			for _, notifier := range []alerts.Notifier{alerts.SentryNotifier{}, alerts.NotifierFunc(emailConfig.SendAlert)} {
+				if err := notifier.Notify(alert); err != nil {
+					log.Printf("Could not send list divergence alert: %v", err)
+				}
+			}
+		},
+	}
+	if grace := os.Getenv("LIST_DIVERGENCE_GRACE"); grace != "" {
+		if publisher.Grace, err = time.ParseDuration(grace); err != nil {
+			log.Fatalf("LIST_DIVERGENCE_GRACE must be a duration like 2h: %v", err)
+		}
+	}
+	return policy.MakePublishedList(&publisher)
+}
+
 // Loads a map of domains (effectively a set for fast lookup) to blacklist.
 // if `DOMAIN_BLACKLIST` is not set, returns an empty map.
 func loadDontScan() map[string]bool {
@@ -74,6 +295,13 @@ func loadDontScan() map[string]bool {
 	return domainset
 }
 
+// Names of the validators, as reported in their metrics and in operations
+// reports.
+const (
+	listValidator   = "Live policy list"
+	queuedValidator = "Testing domains"
+)
+
 func main() {
 	raven.SetDSN(os.Getenv("SENTRY_URL"))
 
@@ -85,27 +313,194 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	emailConfig, err := email.MakeConfigFromEnv(db)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(db)
+		return
+	}
+	if os.Getenv("DB_MIGRATE") == "true" {
+		migrate(db)
+	} else {
+		checkSchema(db)
+	}
+	checkerConfig, err := checker.ConfigFromEnvWithDefaults(api.DefaultCheckerConfig())
 	if err != nil {
-		log.Printf("couldn't connect to mailserver: %v", err)
-		log.Println("======NOT SENDING EMAIL======")
+		log.Fatal(err)
+	}
+	if os.Getenv("MOCK_NETWORK") == "1" {
+		// Staging instances replaying captured traffic run everything on the
+		// fake network, so nothing reaches real mailservers or people.
+		checkerConfig.FakeNetwork = true
+	}
+	checker.Configure(checkerConfig)
+	if exporter, err := tracing.ConfigureFromEnv(); err != nil {
+		log.Fatal(err)
+	} else if exporter != nil {
+		log.Printf("[Exporting traces to %s]", exporter.Endpoint)
+	}
+	var emailConfig email.Config
+	var list *policy.UpdatedList
+	if checkerConfig.FakeNetwork {
+		log.Println("======FAKE NETWORK: checking in-process fake mailservers and logging emails and webhook deliveries======")
+		emailConfig = email.MakeLoggingConfig(db)
+		list = policy.MakeFakeList()
+	} else {
+		if emailConfig, err = email.MakeConfigFromEnv(db); err != nil {
+			log.Printf("couldn't connect to mailserver: %v", err)
+			log.Println("======NOT SENDING EMAIL======")
+		}
+		list = makePolicyList(db, emailConfig)
 	}
-	list := policy.MakeUpdatedList()
 	a := api.API{
-		Database: db,
-		List:     list,
-		DontScan: loadDontScan(),
-		Emailer:  emailConfig,
+		Database:        db,
+		CheckerConfig:   &checkerConfig,
+		List:            list,
+		DontScan:        loadDontScan(),
+		Emailer:         emailConfig,
+		ChallengeSecret: []byte(os.Getenv("MX_CHALLENGE_SECRET")),
+		PromotionSecret: []byte(os.Getenv("PROMOTION_CONFIRMATION_SECRET")),
+		// Notifications are signed and sent with the same secret.
+		UnsubscribeSecret: []byte(os.Getenv("UNSUBSCRIBE_SECRET")),
+	}
+	if grace := os.Getenv("REMOVAL_GRACE_PERIOD"); grace != "" {
+		if a.RemovalGrace, err = time.ParseDuration(grace); err != nil || a.RemovalGrace <= 0 {
+			log.Fatalf("REMOVAL_GRACE_PERIOD must be a positive duration like 336h: %s", grace)
+		}
+	}
+	a.ParseTemplates(os.Getenv("VIEWS_DIR"))
+	if a.Scans, err = api.ScanSchedulerFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if a.Capture, err = api.TrafficCaptureFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if a.Firehose, err = api.FirehoseFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if a.Captcha, err = api.CaptchaFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if a.OIDC, err = api.OIDCFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if a.MXCoverage, err = models.CoveragePolicyFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if a.QueuePolicy, err = models.QueuePolicyFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if value := os.Getenv("SCAN_CACHE_WARMUP"); value != "" {
+		period, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("SCAN_CACHE_WARMUP must be a duration like 1h: %v", err)
+		}
+		a.ScanStore = warmScanCache(db, period, checkerConfig.CacheMaxEntries)
+	}
+	a.ScanCache = a.NewScanCache()
+	if checkerConfig.DomainCacheExpiry > 0 {
+		a.DomainCache = checker.NewDomainCache(checkerConfig.DomainCacheExpiry, checkerConfig.CacheMaxEntries)
+	}
+	if checkerConfig.FakeNetwork {
+		a.FakeNetwork()
+	}
+	if vantagesPath := os.Getenv("PROMOTION_VANTAGES"); vantagesPath != "" {
+		vantages, err := promotion.LoadVantages(vantagesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		a.Promoter = &promotion.Verifier{
+			Vantages:    append([]promotion.Vantage{promotion.LocalVantage{}}, vantages...),
+			QueuePolicy: a.QueuePolicy,
+			// Owners confirm promotions if we can sign their links.
+			RequireConfirmation: len(a.PromotionSecret) > 0,
+		}
+		if checkerConfig.FakeNetwork {
+			// Remote vantages would scan the real network.
+			a.Promoter.Vantages = []promotion.Vantage{promotion.LocalVantage{}}
+			a.Promoter.MinVantages = 1
+		}
+	}
+	warnAfter := 7
+	if value := os.Getenv("REMOVAL_WARNING_AFTER"); value != "" {
+		if warnAfter, err = strconv.Atoi(value); err != nil || warnAfter < 1 {
+			log.Fatalf("REMOVAL_WARNING_AFTER must be a positive number of validation runs: %s", value)
+		}
 	}
-	a.ParseTemplates("views")
 	if os.Getenv("VALIDATE_LIST") == "1" {
 		log.Println("[Starting list validator]")
-		go validator.ValidateRegularly("Live policy list", list, 24*time.Hour)
+		v := validator.Validator{Name: listValidator, Store: list, Modes: db, Runs: db, Interval: 24 * time.Hour}
+		if emailConfig.Notifies() {
+			v.OnFailing = notifyFailures(db, emailConfig, warnAfter)
+		}
+		if value := os.Getenv("PRUNE_MX_AFTER"); value != "" {
+			if v.PruneAfter, err = strconv.Atoi(value); err != nil || v.PruneAfter < 1 {
+				log.Fatalf("PRUNE_MX_AFTER must be a positive number of validation runs: %s", value)
+			}
+			v.OnPrune = suggestPruning(db, emailConfig)
+		}
+		go v.Run()
 	}
 	if os.Getenv("VALIDATE_QUEUED") == "1" {
 		log.Println("[Starting queued validator]")
-		go validator.ValidateRegularly("Testing domains", db, 24*time.Hour)
+		v := validator.Validator{Name: queuedValidator, Store: db, Modes: db, Runs: db, Interval: 24 * time.Hour}
+		if emailConfig.Notifies() {
+			v.OnFailing = notifyFailures(db, emailConfig, warnAfter)
+		}
+		go v.Run()
 	}
 	go stats.UpdateRegularly(db, time.Hour)
+	a.Reports = &report.Generator{
+		Store:      db,
+		Validators: []string{listValidator, queuedValidator},
+		Send:       emailConfig.SendReport,
+	}
+	for _, period := range []report.Period{report.Weekly, report.Monthly} {
+		if os.Getenv(strings.ToUpper(string(period))+"_REPORT") != "1" {
+			continue
+		}
+		log.Printf("[Starting %s operations report]", period)
+		hostname, _ := os.Hostname()
+		generator := *a.Reports
+		generator.Period = period
+		go generator.GenerateRegularly(db, fmt.Sprintf("%s/%d", hostname, os.Getpid()))
+	}
+	if os.Getenv("REMOVE_DOMAINS") == "1" {
+		log.Println("[Starting domain remover]")
+		hostname, _ := os.Hostname()
+		grace := a.RemovalGrace
+		if grace == 0 {
+			grace = models.DefaultRemovalGrace
+		}
+		go removeDueRegularly(db, fmt.Sprintf("%s/%d", hostname, os.Getpid()), grace)
+	}
+	if os.Getenv("DELIVER_WEBHOOKS") == "1" {
+		log.Println("[Starting webhook dispatcher]")
+		hostname, _ := os.Hostname()
+		dispatcher := webhooks.Dispatcher{Store: db, Holder: fmt.Sprintf("%s/%d", hostname, os.Getpid())}
+		if checkerConfig.FakeNetwork {
+			dispatcher.Client = webhooks.NewLoggingClient()
+		}
+		go dispatcher.DeliverRegularly(30 * time.Second)
+	}
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		log.Println("[Starting gRPC scan service]")
+		go ServeGRPC(&a, grpcPort, os.Getenv("GRPC_TLS_CERT"), os.Getenv("GRPC_TLS_KEY"))
+	}
+	if metricsPort := os.Getenv("METRICS_PORT"); metricsPort != "" {
+		log.Println("[Serving Prometheus metrics]")
+		go ServeMetrics(metricsPort)
+	}
+	if rulesPath := os.Getenv("ALERT_RULES"); rulesPath != "" {
+		rules, err := alerts.LoadRules(rulesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("[Starting alert rules]")
+		engine := alerts.Engine{
+			Rules:     rules,
+			Store:     db,
+			Notifiers: []alerts.Notifier{alerts.SentryNotifier{}, alerts.NotifierFunc(emailConfig.SendAlert)},
+		}
+		go alerts.EvaluateRegularly(&engine, time.Hour)
+	}
 	ServePublicEndpoints(&a, &cfg)
 }
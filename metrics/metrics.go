@@ -0,0 +1,275 @@
+// Package metrics keeps counters and histograms in memory, and serves them in
+// the Prometheus text exposition format, so that operators can scrape them.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are histogram bucket upper bounds, in seconds, suited to
+// network latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Registry holds a set of metrics, and serves them over HTTP. Safe for
+// concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Default is the registry that packages register their metrics with.
+var Default = NewRegistry()
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic(fmt.Sprintf("metric %s registered twice", m.name()))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the Prometheus text format, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+	buf := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	return buf.Flush()
+}
+
+// ServeHTTP serves the registry's metrics to a Prometheus scraper.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// series are the values of a metric, by label values joined with labelSep.
+type series struct {
+	mu     sync.Mutex
+	labels []string
+	values map[string]interface{}
+}
+
+const labelSep = "\xff"
+
+func (s *series) key(labelValues []string) string {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(s.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, labelSep)
+}
+
+// sortedKeys returns the series' keys in order. Must be called with s.mu held.
+func (s *series) sortedKeys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// format renders label pairs, with extra appended, as {a="1",b="2"}.
+func (s *series) format(key string, extra ...string) string {
+	var pairs []string
+	if len(s.labels) > 0 {
+		for i, value := range strings.Split(key, labelSep) {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", s.labels[i], value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing count, optionally split by labels.
+type Counter struct {
+	metricName string
+	help       string
+	series
+}
+
+// NewCounter registers a counter with r. Values must be given for each of
+// labels whenever it's incremented.
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{metricName: name, help: help,
+		series: series{labels: labels, values: make(map[string]interface{})}}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter for the given label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current, _ := c.values[key].(float64)
+	c.values[key] = current + v
+}
+
+// Value returns the counter's value for the given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, _ := c.values[key].(float64)
+	return v
+}
+
+func (c *Counter) name() string { return c.metricName }
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.metricName, c.help, c.metricName)
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.format(key), formatFloat(c.values[key].(float64)))
+	}
+}
+
+// Histogram counts observations, eg. latencies, in buckets.
+type Histogram struct {
+	metricName string
+	help       string
+	buckets    []float64
+	series
+}
+
+type histogramValue struct {
+	counts []uint64 // Per bucket, not cumulative.
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with r, with the given bucket upper
+// bounds in increasing order. Values must be given for each of labels
+// whenever a value is observed.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{metricName: name, help: help, buckets: buckets,
+		series: series{labels: labels, values: make(map[string]interface{})}}
+	r.register(h)
+	return h
+}
+
+// Observe adds a value to the histogram for the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	value, ok := h.values[key].(*histogramValue)
+	if !ok {
+		value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			value.counts[i]++
+			break
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+// ObserveSince observes the seconds elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Count returns the number of values observed for the given label values.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if value, ok := h.values[key].(*histogramValue); ok {
+		return value.count
+	}
+	return 0
+}
+
+func (h *Histogram) name() string { return h.metricName }
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.metricName, h.help, h.metricName)
+	for _, key := range h.sortedKeys() {
+		value := h.values[key].(*histogramValue)
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += value.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.format(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.format(key, "le", "+Inf"), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.format(key), formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.format(key), value.count)
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when metrics are served.
+type GaugeFunc struct {
+	metricName string
+	help       string
+	value      func() float64
+	metricType string
+}
+
+// NewGaugeFunc registers a gauge with r, reporting whatever value returns.
+func (r *Registry) NewGaugeFunc(name string, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, value: value, metricType: "gauge"}
+	r.register(g)
+	return g
+}
+
+// NewCounterFunc registers a counter with r, reporting whatever value
+// returns, for totals kept elsewhere. value must never decrease.
+func (r *Registry) NewCounterFunc(name string, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, value: value, metricType: "counter"}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", g.metricName, g.help, g.metricName, g.metricType,
+		g.metricName, formatFloat(g.value()))
+}
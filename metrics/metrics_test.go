@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests handled.", "code")
+	c.Inc("200")
+	c.Inc("200")
+	c.Add(3, "500")
+	if c.Value("200") != 2 || c.Value("500") != 3 || c.Value("404") != 0 {
+		t.Errorf("Unexpected counter values %v", c.values)
+	}
+	var b strings.Builder
+	r.Write(&b)
+	expected := `# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{code="200"} 2
+requests_total{code="500"} 3
+`
+	if b.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+	if h.Count() != 3 {
+		t.Errorf("Expected 3 observations, got %d", h.Count())
+	}
+	var b strings.Builder
+	r.Write(&b)
+	for _, line := range []string{
+		`latency_seconds_bucket{le="0.1"} 1`,
+		`latency_seconds_bucket{le="1"} 2`,
+		`latency_seconds_bucket{le="+Inf"} 3`,
+		`latency_seconds_sum 2.55`,
+		`latency_seconds_count 3`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("Expected %q in\n%s", line, b.String())
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("open_connections", "Open connections.", func() float64 { return 4 })
+	r.NewCounterFunc("waits_total", "Waits for a connection.", func() float64 { return 2 })
+	r.NewCounter("a_total", "Sorted first.")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Expected Prometheus content type, got %s", w.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(body, "# HELP a_total") || !strings.Contains(body, "open_connections 4\n") ||
+		!strings.Contains(body, "# TYPE waits_total counter\nwaits_total 2\n") {
+		t.Errorf("Unexpected metrics output:\n%s", body)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a metric twice to panic")
+		}
+	}()
+	r := NewRegistry()
+	r.NewCounter("a_total", "")
+	r.NewCounter("a_total", "")
+}
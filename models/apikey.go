@@ -0,0 +1,137 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scopes that can be granted to an API key.
+const (
+	ScopeScan  = "scan"  // Requesting scans.
+	ScopeQueue = "queue" // Queueing domains for the policy list.
+	ScopeKeys  = "keys"  // Managing the owner's own API keys.
+)
+
+// Scopes lists every API key scope that users can grant their own keys.
+var Scopes = []string{ScopeScan, ScopeQueue, ScopeKeys}
+
+// Scopes granting access to the admin endpoints, which only maintainers can
+// grant. A key with ScopeAdminWrite can also do anything ScopeListRead allows.
+const (
+	ScopeListRead   = "list-read"   // Reading domains, reports and history.
+	ScopeAdminWrite = "admin-write" // Changing domains, blocks and keys.
+)
+
+// AdminScopes lists every scope that only maintainers can grant.
+var AdminScopes = []string{ScopeListRead, ScopeAdminWrite}
+
+// APIKey is a key issued to a registered, email-verified user.
+type APIKey struct {
+	ID       int64     `json:"id"`
+	Email    string    `json:"email"`
+	Name     string    `json:"name"`
+	Scopes   []string  `json:"scopes"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+	Requests int64     `json:"requests"`
+	Revoked  bool      `json:"revoked"`
+	// ScanQuota is the number of scans the key can request per day, set by
+	// maintainers. Zero means unlimited.
+	ScanQuota int64 `json:"scan_quota"`
+	// Key is the secret itself. We only store its hash, so it's only set in
+	// the response when a key is created or rotated.
+	Key string `json:"key,omitempty"`
+}
+
+// APIKeyUsage counts the requests and scans made with an API key on a single
+// day, in UTC.
+type APIKeyUsage struct {
+	Day      time.Time `json:"day"`
+	Requests int64     `json:"requests"`
+	Scans    int64     `json:"scans"`
+}
+
+// APIUsage totals the use of every API key over a period.
+type APIUsage struct {
+	// Keys is the number of keys used.
+	Keys     int   `json:"keys"`
+	Requests int64 `json:"requests"`
+	Scans    int64 `json:"scans"`
+}
+
+// ScansRemaining returns how many more scans the key can request today,
+// given the scans already made, or -1 if it has no quota.
+func (k APIKey) ScansRemaining(scansToday int64) int64 {
+	if k.ScanQuota <= 0 {
+		return -1
+	}
+	if scansToday >= k.ScanQuota {
+		return 0
+	}
+	return k.ScanQuota - scansToday
+}
+
+// HasScope returns true if the key was granted scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Actor identifies the key's owner in the audit log.
+func (k APIKey) Actor() string {
+	return fmt.Sprintf("%s (key %d)", k.Email, k.ID)
+}
+
+// ParseScopes splits and validates a list of scopes, which may each be
+// comma-separated. Only the Scopes users can grant are valid.
+func ParseScopes(values []string) ([]string, error) {
+	return parseScopes(values, Scopes)
+}
+
+// ParseAdminScopes splits and validates a list of scopes like ParseScopes,
+// also accepting AdminScopes.
+func ParseAdminScopes(values []string) ([]string, error) {
+	return parseScopes(values, append(append([]string{}, Scopes...), AdminScopes...))
+}
+
+func parseScopes(values []string, valid []string) ([]string, error) {
+	scopes := []string{}
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, scope := range strings.Split(value, ",") {
+			scope = strings.ToLower(strings.TrimSpace(scope))
+			if scope == "" || seen[scope] {
+				continue
+			}
+			known := false
+			for _, s := range valid {
+				known = known || s == scope
+			}
+			if !known {
+				return nil, fmt.Errorf("unknown scope %s; valid scopes are %s", scope, strings.Join(valid, ", "))
+			}
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// NewAPIKeySecret generates a new random API key.
+func NewAPIKeySecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return fmt.Sprintf("stk_%x", b)
+}
+
+// HashAPIKey returns the hash under which an API key is stored.
+func HashAPIKey(secret string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(secret)))
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	var tests = []struct {
+		values  []string
+		want    string
+		wantErr bool
+	}{
+		{[]string{"scan"}, "scan", false},
+		{[]string{"scan,queue", "Keys"}, "scan,queue,keys", false},
+		{[]string{"scan, scan", ""}, "scan", false},
+		{[]string{}, "", false},
+		{[]string{"scan,admin"}, "", true},
+	}
+	for _, test := range tests {
+		scopes, err := ParseScopes(test.values)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseScopes(%v) returned error %v", test.values, err)
+			continue
+		}
+		if got := strings.Join(scopes, ","); !test.wantErr && got != test.want {
+			t.Errorf("ParseScopes(%v) = %s, want %s", test.values, got, test.want)
+		}
+	}
+}
+
+func TestParseAdminScopes(t *testing.T) {
+	if _, err := ParseScopes([]string{ScopeListRead}); err == nil {
+		t.Error("Expected users not to be able to grant admin scopes")
+	}
+	scopes, err := ParseAdminScopes([]string{"scan,List-Read", "admin-write"})
+	if got := strings.Join(scopes, ","); err != nil || got != "scan,list-read,admin-write" {
+		t.Errorf("ParseAdminScopes = %s, %v, want scan,list-read,admin-write", got, err)
+	}
+}
+
+func TestAPIKeySecrets(t *testing.T) {
+	a, b := NewAPIKeySecret(), NewAPIKeySecret()
+	if a == b || !strings.HasPrefix(a, "stk_") {
+		t.Errorf("expected unique prefixed secrets, got %s and %s", a, b)
+	}
+	if HashAPIKey(a) == HashAPIKey(b) || HashAPIKey(a) != HashAPIKey(a) {
+		t.Error("expected hashes to be deterministic and distinct")
+	}
+	key := APIKey{Scopes: []string{ScopeScan}}
+	if !key.HasScope(ScopeScan) || key.HasScope(ScopeKeys) {
+		t.Error("HasScope doesn't match the key's scopes")
+	}
+}
+
+func TestScansRemaining(t *testing.T) {
+	if remaining := (APIKey{}).ScansRemaining(10); remaining != -1 {
+		t.Errorf("expected keys without a quota to be unlimited, got %d", remaining)
+	}
+	key := APIKey{ScanQuota: 5}
+	if remaining := key.ScansRemaining(2); remaining != 3 {
+		t.Errorf("expected 3 scans remaining, got %d", remaining)
+	}
+	if remaining := key.ScansRemaining(7); remaining != 0 {
+		t.Errorf("expected no scans remaining, got %d", remaining)
+	}
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/util"
+)
+
+// Kinds of submission block.
+const (
+	// BlockDomain blocks a domain and its subdomains.
+	BlockDomain = "domain"
+	// BlockEmail blocks contact email addresses matching a glob pattern,
+	// eg. "*@mailinator.com".
+	BlockEmail = "email"
+)
+
+// SubmissionBlock refuses queue submissions for domains or contact email
+// addresses that have been used for junk submissions.
+type SubmissionBlock struct {
+	ID      int64     `json:"id"`
+	Kind    string    `json:"kind"`
+	Pattern string    `json:"pattern"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Validate normalizes the block's pattern, and returns an error if it isn't
+// valid for the block's kind.
+func (b *SubmissionBlock) Validate() error {
+	b.Pattern = strings.ToLower(strings.TrimSpace(b.Pattern))
+	switch b.Kind {
+	case BlockDomain:
+		if !util.ValidDomainName(b.Pattern) {
+			return fmt.Errorf("%q is not a valid domain", b.Pattern)
+		}
+	case BlockEmail:
+		if _, err := path.Match(b.Pattern, ""); err != nil || !strings.Contains(b.Pattern, "@") {
+			return fmt.Errorf("%q is not a valid email pattern", b.Pattern)
+		}
+	default:
+		return fmt.Errorf("kind must be %s or %s, not %q", BlockDomain, BlockEmail, b.Kind)
+	}
+	return nil
+}
+
+// Blocks returns true if the block refuses submissions of the domain.
+func (b SubmissionBlock) Blocks(d Domain) bool {
+	switch b.Kind {
+	case BlockDomain:
+		name := strings.ToLower(d.Name)
+		return name == b.Pattern || strings.HasSuffix(name, "."+b.Pattern)
+	case BlockEmail:
+		matched, _ := path.Match(b.Pattern, strings.ToLower(d.Email))
+		return matched
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package models
+
+import "testing"
+
+func TestSubmissionBlockValidate(t *testing.T) {
+	valid := SubmissionBlock{Kind: BlockDomain, Pattern: " Junk.Example "}
+	if err := valid.Validate(); err != nil || valid.Pattern != "junk.example" {
+		t.Errorf("Expected a normalized domain pattern, got %q, %v", valid.Pattern, err)
+	}
+	for _, b := range []SubmissionBlock{
+		{Kind: BlockDomain, Pattern: "localhost"},
+		{Kind: BlockEmail, Pattern: "mailinator.com"},
+		{Kind: BlockEmail, Pattern: "[@mailinator.com"},
+		{Kind: "ip", Pattern: "192.0.2.1"},
+	} {
+		if err := b.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", b)
+		}
+	}
+}
+
+func TestSubmissionBlockBlocks(t *testing.T) {
+	domain := SubmissionBlock{Kind: BlockDomain, Pattern: "junk.example"}
+	email := SubmissionBlock{Kind: BlockEmail, Pattern: "*@mailinator.com"}
+	for _, test := range []struct {
+		block   SubmissionBlock
+		domain  Domain
+		blocked bool
+	}{
+		{domain, Domain{Name: "junk.example"}, true},
+		{domain, Domain{Name: "Mail.Junk.example"}, true},
+		{domain, Domain{Name: "notjunk.example"}, false},
+		{email, Domain{Name: "example.com", Email: "Someone@Mailinator.com"}, true},
+		{email, Domain{Name: "example.com", Email: "someone@example.com"}, false},
+	} {
+		if got := test.block.Blocks(test.domain); got != test.blocked {
+			t.Errorf("Expected %+v blocking %+v to be %v", test.block, test.domain, test.blocked)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// A hosting provider can vouch for its customers' domains by proving that it
+// controls their MX hostnames. For each MX hostname, it publishes a TXT record
+// at MXChallengeName(hostname) containing the challenge we issued it. The
+// challenge is signed with our secret and bound to the provider's account,
+// so we don't need to store it, and it can't be reused by anyone else.
+
+// MXChallengeName returns the name of the TXT record proving control of mx.
+func MXChallengeName(mx string) string {
+	return "_starttls-challenge." + normalizeHostname(mx)
+}
+
+// MXChallenge returns the TXT record value that owner must publish to prove
+// control of mx.
+func MXChallenge(secret []byte, owner string, mx string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.ToLower(owner) + "\n" + normalizeHostname(mx)))
+	return "starttls-challenge=" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HasMXChallenge returns true if records, the TXT records found at
+// MXChallengeName(mx), include owner's challenge for mx.
+func HasMXChallenge(records []string, secret []byte, owner string, mx string) bool {
+	expected := MXChallenge(secret, owner, mx)
+	for _, record := range records {
+		if hmac.Equal([]byte(strings.TrimSpace(record)), []byte(expected)) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHostname(hostname string) string {
+	return strings.ToLower(strings.TrimSuffix(hostname, "."))
+}
@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+func TestMXChallenge(t *testing.T) {
+	secret := []byte("secret")
+	challenge := MXChallenge(secret, "provider@example.com", "mx.example.com")
+	if MXChallenge(secret, "Provider@example.com", "MX.example.com.") != challenge {
+		t.Error("Expected challenge to ignore case and trailing dots")
+	}
+	for _, other := range []string{
+		MXChallenge(secret, "someone@example.com", "mx.example.com"),
+		MXChallenge(secret, "provider@example.com", "mx2.example.com"),
+		MXChallenge([]byte("other"), "provider@example.com", "mx.example.com"),
+	} {
+		if other == challenge {
+			t.Error("Expected challenges to differ by owner, hostname and secret")
+		}
+	}
+	if MXChallengeName("MX.example.com.") != "_starttls-challenge.mx.example.com" {
+		t.Errorf("Unexpected challenge name %s", MXChallengeName("MX.example.com."))
+	}
+}
+
+func TestHasMXChallenge(t *testing.T) {
+	secret := []byte("secret")
+	records := []string{"v=spf1 -all", " " + MXChallenge(secret, "provider@example.com", "mx.example.com")}
+	if !HasMXChallenge(records, secret, "provider@example.com", "mx.example.com") {
+		t.Error("Expected challenge to be found")
+	}
+	if HasMXChallenge(records, secret, "someone@example.com", "mx.example.com") {
+		t.Error("Expected another owner's challenge not to be found")
+	}
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+// CoveragePolicy is how much of a domain's mail must be covered by the MX
+// patterns it's submitted with before it can be queued.
+type CoveragePolicy struct {
+	// MinPercent of the preferred hostnames observed in the domain's recent
+	// scans must match a submitted MX pattern. Zero means 100.
+	MinPercent float64
+	// Scans is how many of the domain's most recent scans to take preferred
+	// hostnames from. Zero means 1, the latest scan only.
+	Scans int
+}
+
+func (p CoveragePolicy) minPercent() float64 {
+	if p.MinPercent <= 0 {
+		return 100
+	}
+	return p.MinPercent
+}
+
+func (p CoveragePolicy) scans() int {
+	if p.Scans <= 0 {
+		return 1
+	}
+	return p.Scans
+}
+
+// CoveragePolicyFromEnv reads a CoveragePolicy from MX_COVERAGE_MIN_PERCENT
+// and MX_COVERAGE_SCANS. Unset variables keep their defaults.
+func CoveragePolicyFromEnv() (CoveragePolicy, error) {
+	var p CoveragePolicy
+	var err error
+	if value := os.Getenv("MX_COVERAGE_MIN_PERCENT"); value != "" {
+		p.MinPercent, err = strconv.ParseFloat(value, 64)
+		if err != nil || p.MinPercent <= 0 || p.MinPercent > 100 {
+			return p, fmt.Errorf("MX_COVERAGE_MIN_PERCENT must be a percentage above 0, got %q", value)
+		}
+	}
+	if value := os.Getenv("MX_COVERAGE_SCANS"); value != "" {
+		p.Scans, err = strconv.Atoi(value)
+		if err != nil || p.Scans < 1 {
+			return p, fmt.Errorf("MX_COVERAGE_SCANS must be a positive integer, got %q", value)
+		}
+	}
+	return p, nil
+}
+
+// MXCoverage describes how much of a domain's observed mailservers its
+// submitted MX patterns cover.
+type MXCoverage struct {
+	// Scans the observed hostnames were taken from.
+	Scans           int      `json:"scans"`
+	Observed        []string `json:"observed"`
+	Covered         []string `json:"covered"`
+	Uncovered       []string `json:"uncovered"`
+	Percent         float64  `json:"percent"`
+	RequiredPercent float64  `json:"required_percent"`
+}
+
+// Sufficient returns true if the coverage meets the policy it was computed
+// under.
+func (c MXCoverage) Sufficient() bool {
+	return c.Percent >= c.RequiredPercent
+}
+
+// Partial returns true if some of the observed hostnames aren't covered.
+// Coverage that wasn't computed, like that of MTA-STS submissions, isn't
+// partial.
+func (c MXCoverage) Partial() bool {
+	return len(c.Uncovered) > 0
+}
+
+// Shortfall describes which observed hostnames aren't covered, for
+// submissions that don't meet the policy.
+func (c MXCoverage) Shortfall(mxs []string) string {
+	return fmt.Sprintf("Hostnames %v do not match policy %v: it covers %.0f%% of the mailservers seen in "+
+		"your domain's last %d scan(s), and %.0f%% is required", c.Uncovered, mxs, c.Percent, c.Scans, c.RequiredPercent)
+}
+
+// ComputeMXCoverage checks the preferred hostnames observed in scans, most
+// recent first, against the MX patterns mxs, under policy.
+func ComputeMXCoverage(scans []Scan, mxs []string, policy CoveragePolicy) MXCoverage {
+	if len(scans) > policy.scans() {
+		scans = scans[:policy.scans()]
+	}
+	coverage := MXCoverage{
+		Scans:           len(scans),
+		Observed:        []string{},
+		Covered:         []string{},
+		Uncovered:       []string{},
+		RequiredPercent: policy.minPercent(),
+	}
+	seen := make(map[string]bool)
+	for _, scan := range scans {
+		for _, hostname := range scan.Data.PreferredHostnames {
+			if !seen[hostname] {
+				seen[hostname] = true
+				coverage.Observed = append(coverage.Observed, hostname)
+			}
+		}
+	}
+	sort.Strings(coverage.Observed)
+	for _, hostname := range coverage.Observed {
+		if checker.PolicyMatches(hostname, mxs) {
+			coverage.Covered = append(coverage.Covered, hostname)
+		} else {
+			coverage.Uncovered = append(coverage.Uncovered, hostname)
+		}
+	}
+	coverage.Percent = 100
+	if len(coverage.Observed) > 0 {
+		coverage.Percent = 100 * float64(len(coverage.Covered)) / float64(len(coverage.Observed))
+	}
+	return coverage
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+func scanWithHostnames(hostnames ...string) Scan {
+	return Scan{Data: checker.DomainResult{PreferredHostnames: hostnames}}
+}
+
+// mockScanHistory returns its scans as the domain's most recent, most recent
+// first.
+type mockScanHistory []Scan
+
+func (m mockScanHistory) GetLatestTrustedScan(string) (Scan, error) { return m[0], nil }
+
+func (m mockScanHistory) GetLatestTrustedScans(_ string, n int) ([]Scan, error) {
+	if n > len(m) {
+		n = len(m)
+	}
+	return m[:n], nil
+}
+
+func TestComputeMXCoverage(t *testing.T) {
+	scans := []Scan{
+		scanWithHostnames("mx1.example.com", "mx2.example.com"),
+		scanWithHostnames("mx1.example.com", "backup.example.net"),
+	}
+	coverage := ComputeMXCoverage(scans, []string{".example.com"}, CoveragePolicy{})
+	if coverage.Scans != 1 || coverage.Percent != 100 || !coverage.Sufficient() || coverage.Partial() {
+		t.Errorf("Expected the latest scan to be fully covered, got %+v", coverage)
+	}
+	coverage = ComputeMXCoverage(scans, []string{".example.com"}, CoveragePolicy{Scans: 2})
+	if coverage.Scans != 2 || len(coverage.Observed) != 3 || coverage.Sufficient() || !coverage.Partial() {
+		t.Errorf("Expected partial coverage of the last two scans, got %+v", coverage)
+	}
+	if len(coverage.Uncovered) != 1 || coverage.Uncovered[0] != "backup.example.net" {
+		t.Errorf("Expected backup.example.net to be uncovered, got %v", coverage.Uncovered)
+	}
+	coverage = ComputeMXCoverage(scans, []string{".example.com"}, CoveragePolicy{Scans: 2, MinPercent: 60})
+	if !coverage.Sufficient() {
+		t.Errorf("Expected 2 of 3 hostnames to meet a 60%% requirement, got %+v", coverage)
+	}
+	if (MXCoverage{}).Partial() {
+		t.Error("Expected coverage that wasn't computed not to be partial")
+	}
+}
+
+func TestIsQueueableCoverage(t *testing.T) {
+	history := mockScanHistory{
+		scanWithHostnames("mx1.example.com"),
+		scanWithHostnames("mx1.example.com", "backup.example.net"),
+	}
+	d := Domain{Name: "example.com", MXs: []string{".example.com"}}
+	domains := mockDomainStore{}
+	ok, msg, _, coverage := d.IsQueueable(&domains, history, mockList{}, CoveragePolicy{Scans: 2})
+	if ok || !strings.Contains(msg, "backup.example.net") || coverage.Percent != 50 {
+		t.Errorf("Expected shortfall on backup.example.net, got %v %s %+v", ok, msg, coverage)
+	}
+	ok, msg, _, _ = d.IsQueueable(&domains, history, mockList{}, CoveragePolicy{Scans: 2, MinPercent: 50})
+	if !ok {
+		t.Errorf("Expected 50%% coverage to be queueable, got %s", msg)
+	}
+}
+
+func TestCoveragePolicyFromEnv(t *testing.T) {
+	os.Setenv("MX_COVERAGE_MIN_PERCENT", "80")
+	os.Setenv("MX_COVERAGE_SCANS", "3")
+	defer os.Unsetenv("MX_COVERAGE_MIN_PERCENT")
+	defer os.Unsetenv("MX_COVERAGE_SCANS")
+	p, err := CoveragePolicyFromEnv()
+	if err != nil || p.MinPercent != 80 || p.Scans != 3 {
+		t.Errorf("Expected policy from env, got %+v (%v)", p, err)
+	}
+	os.Setenv("MX_COVERAGE_MIN_PERCENT", "120")
+	if _, err := CoveragePolicyFromEnv(); err == nil {
+		t.Error("Expected percentage above 100 to be rejected")
+	}
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/EFForg/starttls-backend/checker"
@@ -20,10 +21,13 @@ type Domain struct {
 	Email        string      `json:"-"`      // Contact e-mail for Domain
 	MXs          []string    `json:"mxs"`    // MXs that are valid for this domain
 	MTASTS       bool        `json:"mta_sts"`
+	MTASTSMode   string      `json:"mta_sts_mode,omitempty"` // Last seen mode of the domain's MTA-STS policy
 	State        DomainState `json:"state"`
 	LastUpdated  time.Time   `json:"last_updated"`
 	TestingStart time.Time   `json:"-"`
 	QueueWeeks   int         `json:"queue_weeks"`
+	// When its owner confirmed its removal, if it's pending removal.
+	RemovalRequested time.Time `json:"-"`
 }
 
 // domainStore is a simple interface for fetching and adding domain objects.
@@ -31,8 +35,8 @@ type domainStore interface {
 	PutDomain(Domain) error
 	GetDomain(string, DomainState) (Domain, error)
 	GetDomains(DomainState) ([]Domain, error)
-	SetStatus(string, DomainState) error
-	RemoveDomain(string, DomainState) (Domain, error)
+	SetStatus(string, DomainState, StateChange) error
+	RemoveDomain(string, DomainState, StateChange) (Domain, error)
 }
 
 // DomainState represents the state of a single domain.
@@ -42,47 +46,124 @@ type DomainState string
 const (
 	StateUnknown     = "unknown"     // Domain was never submitted, so we don't know.
 	StateUnconfirmed = "unvalidated" // Administrator has not yet confirmed their intention to add the domain.
+	StateFlagged     = "flagged"     // Held for review by a maintainer before we send the validation email.
 	StateTesting     = "queued"      // Queued for addition at next addition date pending continued validation
 	StateFailed      = "failed"      // Requested to be queued, but failed verification.
+	StateHeld        = "held"        // Held back from the list after its owner reported a problem before promotion.
 	StateEnforce     = "added"       // On the list.
+	// Its owner confirmed it should be removed from the list, which happens
+	// once its removal grace period is over.
+	StatePendingRemoval = "pending-removal"
 )
 
+// Actors of the state changes the server makes on its own.
+const (
+	ActorToken      = "token"      // The domain's contact email was validated.
+	ActorProvider   = "provider"   // A provider vouched for the domain.
+	ActorModeration = "moderation" // The submission was flagged for review.
+	ActorPromotion  = "promotion"  // The domain passed promotion verification.
+	ActorRemoval    = "removal"    // The domain's removal grace period ended.
+)
+
+// ActionStateChange is the audit log action recording a domain's state change.
+const ActionStateChange = "domain.state"
+
+// StateChange says who or what changed the state of a domain, and why. Every
+// change is recorded in the audit log, with the domain's old and new states.
+type StateChange struct {
+	// Actor is one of the actors above, "admin", "owner" for the domain's
+	// owner, or a reviewer's name.
+	Actor  string
+	Reason string
+}
+
+// AuditEntry returns the audit log entry recording the change of domain from
+// one state to another. If to is empty, the domain was removed.
+func (c StateChange) AuditEntry(domain string, from DomainState, to DomainState) AuditEntry {
+	details := fmt.Sprintf("%s to %s", from, to)
+	if to == "" {
+		details = fmt.Sprintf("%s removed", from)
+	}
+	if c.Reason != "" {
+		details += ": " + c.Reason
+	}
+	return AuditEntry{Actor: c.Actor, Action: ActionStateChange, Subject: domain, Details: details}
+}
+
+// ParseStateChange returns the states a domain changed from and to in an
+// audit log entry written by StateChange.AuditEntry. ok is false if entry
+// isn't a state change. If the domain was removed, to is empty.
+func ParseStateChange(entry AuditEntry) (from DomainState, to DomainState, ok bool) {
+	if entry.Action != ActionStateChange {
+		return "", "", false
+	}
+	details := strings.SplitN(entry.Details, ": ", 2)[0]
+	if removed := strings.TrimSuffix(details, " removed"); removed != details {
+		return DomainState(removed), "", true
+	}
+	states := strings.SplitN(details, " to ", 2)
+	if len(states) != 2 {
+		return "", "", false
+	}
+	return DomainState(states[0]), DomainState(states[1]), true
+}
+
 type policyList interface {
 	HasDomain(string) bool
 }
 
 // IsQueueable returns true if a domain can be submitted for validation and
 // queueing to the STARTTLS Everywhere Policy List.
-// A successful scan should already have been submitted for this domain,
-// and it should not already be on the policy list.
-// Returns (queuability, error message, and most recent scan)
-func (d *Domain) IsQueueable(domains domainStore, scans scanStore, list policyList) (bool, string, Scan) {
-	scan, err := scans.GetLatestScan(d.Name)
+// A successful trusted scan should already have been submitted for this domain,
+// and it should not already be on the policy list. Unless the domain supports
+// MTA-STS, its MX patterns must cover the preferred hostnames seen in its
+// recent scans, as required by coverage.
+// Returns (queuability, error message, most recent scan, and MX coverage)
+func (d *Domain) IsQueueable(domains domainStore, scans scanStore, list policyList, coverage CoveragePolicy) (bool, string, Scan, MXCoverage) {
+	var mxCoverage MXCoverage
+	scan, err := scans.GetLatestTrustedScan(d.Name)
 	if err != nil {
 		return false, "We haven't scanned this domain yet. " +
 			"Please use the STARTTLS checker to scan your domain's " +
-			"STARTTLS configuration so we can validate your submission", scan
+			"STARTTLS configuration so we can validate your submission", scan, mxCoverage
 	}
-	if scan.Data.Status != 0 {
-		return false, "Domain hasn't passed our STARTTLS security checks", scan
+	if !scan.Trusted() {
+		return false, "We haven't run a full scan of this domain recently. " +
+			"Please use the STARTTLS checker to scan your domain's " +
+			"STARTTLS configuration so we can validate your submission", scan, mxCoverage
+	}
+	// A scan that timed out only checked some mailservers, even if it was
+	// stored as a success before timed out scans were made to fail.
+	if scan.Data.Status != 0 || scan.Data.TimedOut {
+		if scan.Data.ErrorClass == checker.TemporaryError || scan.Data.Status == 0 {
+			return false, "We couldn't finish checking your domain's mailservers, which may be " +
+				"a temporary network problem. Please scan your domain again in a few minutes", scan, mxCoverage
+		}
+		return false, "Domain hasn't passed our STARTTLS security checks", scan, mxCoverage
 	}
 	if list.HasDomain(d.Name) {
-		return false, "Domain is already on the policy list!", scan
+		return false, "Domain is already on the policy list!", scan, mxCoverage
 	}
 	if _, err := domains.GetDomain(d.Name, StateEnforce); err == nil {
-		return false, "Domain is already on the policy list!", scan
+		return false, "Domain is already on the policy list!", scan, mxCoverage
 	}
-	// Domains without submitted MTA-STS support must match provided mx patterns.
+	// Domains without submitted MTA-STS support must match provided mx patterns,
+	// as far as the coverage policy requires.
 	if !d.MTASTS {
-		for _, hostname := range scan.Data.PreferredHostnames {
-			if !checker.PolicyMatches(hostname, d.MXs) {
-				return false, fmt.Sprintf("Hostnames %v do not match policy %v", scan.Data.PreferredHostnames, d.MXs), scan
+		recent := []Scan{scan}
+		if n := coverage.scans(); n > 1 {
+			if latest, err := scans.GetLatestTrustedScans(d.Name, n); err == nil && len(latest) > 0 {
+				recent = latest
 			}
 		}
+		mxCoverage = ComputeMXCoverage(recent, d.MXs, coverage)
+		if !mxCoverage.Sufficient() {
+			return false, mxCoverage.Shortfall(d.MXs), scan, mxCoverage
+		}
 	} else if !scan.SupportsMTASTS() {
-		return false, "Domain does not correctly implement MTA-STS.", scan
+		return false, "Domain does not correctly implement MTA-STS.", scan, mxCoverage
 	}
-	return true, "", scan
+	return true, "", scan, mxCoverage
 }
 
 // PopulateFromScan updates a Domain's fields based on a scan of that domain.
@@ -95,20 +176,43 @@ func (d *Domain) PopulateFromScan(scan Scan) {
 		if len(d.MXs) == 0 {
 			d.MXs = scan.Data.MTASTSResult.MXs
 		}
+		d.MTASTSMode = scan.Data.MTASTSResult.Mode
+	}
+}
+
+// ListedMTASTSModes returns the last seen MTA-STS mode of each domain on the
+// list via MTA-STS, which its list entry should have.
+func ListedMTASTSModes(store domainStore) (map[string]string, error) {
+	listed, err := store.GetDomains(StateEnforce)
+	if err != nil {
+		return nil, err
+	}
+	modes := make(map[string]string)
+	for _, d := range listed {
+		if d.MTASTS && (d.MTASTSMode == "testing" || d.MTASTSMode == "enforce") {
+			modes[d.Name] = d.MTASTSMode
+		}
 	}
+	return modes, nil
 }
 
 // InitializeWithToken adds this domain to the given DomainStore and initializes a validation token
 // for the addition. The newly generated Token is returned.
-func (d *Domain) InitializeWithToken(store domainStore, tokens tokenStore) (string, error) {
+func (d *Domain) InitializeWithToken(store domainStore, tokens tokenStore) (Token, error) {
 	if err := store.PutDomain(*d); err != nil {
-		return "", err
+		return Token{}, err
 	}
-	token, err := tokens.PutToken(d.Name)
-	if err != nil {
-		return "", err
+	return tokens.PutToken(d.Name)
+}
+
+// InitializeVouched adds this domain to the given DomainStore in testing,
+// skipping email validation. It's for domains whose MX hostnames a provider
+// has proven control of.
+func (d *Domain) InitializeVouched(store domainStore) error {
+	if err := store.PutDomain(*d); err != nil {
+		return err
 	}
-	return token.Token, nil
+	return confirm(store, d.Name, StateChange{Actor: ActorProvider, Reason: "vouched for by a provider"})
 }
 
 // PolicyListCheck checks the policy list status of this particular domain.
@@ -131,6 +235,12 @@ func (d *Domain) PolicyListCheck(store domainStore, list policyList) *checker.Re
 	if domain.State == StateUnconfirmed {
 		return result.Failure("The policy addition request for %s is waiting on email validation", d.Name)
 	}
+	if domain.State == StateFlagged {
+		return result.Failure("The policy addition request for %s is waiting on review by our team", d.Name)
+	}
+	if domain.State == StatePendingRemoval {
+		return result.Failure("Domain %s is being removed from the policy list at its owner's request.", d.Name)
+	}
 	return result.Failure("Domain %s is not on the policy list.", d.Name)
 }
 
@@ -143,9 +253,10 @@ func (d Domain) AsyncPolicyListCheck(store domainStore, list policyList) <-chan
 }
 
 // GetDomain retrieves Domain with the most "important" state.
-// At any given time, there can only be one domain that's either StateEnforce
-// or StateTesting. If that domain exists in the store, return that one.
-// Otherwise, look for a Domain policy in the unconfirmed state.
+// At any given time, there can only be one domain that's either StateEnforce,
+// StateTesting, StateHeld or StatePendingRemoval. If that domain exists in the
+// store, return that one. Otherwise, look for a Domain policy in the
+// unconfirmed or flagged state.
 func GetDomain(store domainStore, name string) (Domain, error) {
 	domain, err := store.GetDomain(name, StateEnforce)
 	if err == nil {
@@ -155,9 +266,21 @@ func GetDomain(store domainStore, name string) (Domain, error) {
 	if err == nil {
 		return domain, nil
 	}
+	domain, err = store.GetDomain(name, StateHeld)
+	if err == nil {
+		return domain, nil
+	}
+	domain, err = store.GetDomain(name, StatePendingRemoval)
+	if err == nil {
+		return domain, nil
+	}
 	domain, err = store.GetDomain(name, StateUnconfirmed)
 	if err == nil {
 		return domain, nil
 	}
+	domain, err = store.GetDomain(name, StateFlagged)
+	if err == nil {
+		return domain, nil
+	}
 	return store.GetDomain(name, StateFailed)
 }
@@ -19,7 +19,7 @@ func (m *mockDomainStore) PutDomain(d Domain) error {
 	return m.err
 }
 
-func (m *mockDomainStore) SetStatus(d string, status DomainState) error {
+func (m *mockDomainStore) SetStatus(d string, status DomainState, _ StateChange) error {
 	m.domain.State = status
 	return m.err
 }
@@ -36,7 +36,7 @@ func (m *mockDomainStore) GetDomains(_ DomainState) ([]Domain, error) {
 	return m.domains, m.err
 }
 
-func (m *mockDomainStore) RemoveDomain(d string, state DomainState) (Domain, error) {
+func (m *mockDomainStore) RemoveDomain(d string, state DomainState, _ StateChange) (Domain, error) {
 	domain := m.domain
 	if state != domain.State {
 		return m.domain, errors.New("")
@@ -55,7 +55,11 @@ type mockScanStore struct {
 	err  error
 }
 
-func (m mockScanStore) GetLatestScan(string) (Scan, error) { return m.scan, m.err }
+func (m mockScanStore) GetLatestTrustedScan(string) (Scan, error) { return m.scan, m.err }
+
+func (m mockScanStore) GetLatestTrustedScans(string, int) ([]Scan, error) {
+	return []Scan{m.scan}, m.err
+}
 
 func TestIsQueueable(t *testing.T) {
 	// With supplied hostnames
@@ -73,6 +77,15 @@ func TestIsQueueable(t *testing.T) {
 	failedScan := Scan{
 		Data: checker.DomainResult{Status: checker.DomainFailure},
 	}
+	temporaryFailedScan := Scan{
+		Data: checker.DomainResult{Status: checker.DomainCouldNotConnect, ErrorClass: checker.TemporaryError},
+	}
+	timedOutScan := goodScan
+	timedOutScan.Data.TimedOut = true
+	censusScan := goodScan
+	censusScan.Source = SourceCensus
+	quickScan := goodScan
+	quickScan.Profile = ProfileQuick
 	wrongMXsScan := Scan{
 		Data: checker.DomainResult{
 			PreferredHostnames: []string{"mx1.nomatch.example.com"},
@@ -99,16 +112,28 @@ func TestIsQueueable(t *testing.T) {
 		{name: "Domain with failing scan should not be queueable",
 			scan: failedScan, scanErr: nil, onList: false,
 			ok: false, msg: "hasn't passed"},
+		{name: "Domain with temporarily failing scan should be asked to rescan",
+			scan: temporaryFailedScan, scanErr: nil, onList: false,
+			ok: false, msg: "scan your domain again"},
+		{name: "Domain whose scan timed out should be asked to rescan",
+			scan: timedOutScan, scanErr: nil, onList: false,
+			ok: false, msg: "scan your domain again"},
 		{name: "Domain without scan should not be queueable",
 			scan: goodScan, scanErr: errors.New(""), onList: false,
 			ok: false, msg: "haven't scanned"},
+		{name: "Domain with only a census scan should not be queueable",
+			scan: censusScan, scanErr: nil, onList: false,
+			ok: false, msg: "full scan"},
+		{name: "Domain with only a quick scan should not be queueable",
+			scan: quickScan, scanErr: nil, onList: false,
+			ok: false, msg: "full scan"},
 		{name: "Domain with mismatched hostnames should not be queueable",
 			scan: wrongMXsScan, scanErr: nil, onList: false,
 			ok: false, msg: "do not match policy"},
 	}
 	for _, tc := range testCases {
 		domainStore := mockDomainStore{domain: Domain{State: tc.state}}
-		ok, msg, _ := d.IsQueueable(&domainStore, mockScanStore{tc.scan, tc.scanErr}, mockList{tc.onList})
+		ok, msg, _, _ := d.IsQueueable(&domainStore, mockScanStore{tc.scan, tc.scanErr}, mockList{tc.onList}, CoveragePolicy{})
 		if ok != tc.ok {
 			t.Error(tc.name)
 		}
@@ -123,7 +148,7 @@ func TestIsQueueable(t *testing.T) {
 		MTASTS: true,
 	}
 	domainStore := mockDomainStore{err: errors.New("")}
-	ok, msg, _ := d.IsQueueable(&domainStore, mockScanStore{goodScan, nil}, mockList{false})
+	ok, msg, _, _ := d.IsQueueable(&domainStore, mockScanStore{goodScan, nil}, mockList{false}, CoveragePolicy{})
 	if !ok {
 		t.Error("Unadded domain with passing scan should be queueable, got " + msg)
 	}
@@ -136,7 +161,7 @@ func TestIsQueueable(t *testing.T) {
 			},
 		},
 	}
-	ok, msg, _ = d.IsQueueable(&domainStore, mockScanStore{noMTASTSScan, nil}, mockList{false})
+	ok, msg, _, _ = d.IsQueueable(&domainStore, mockScanStore{noMTASTSScan, nil}, mockList{false}, CoveragePolicy{})
 	if ok || !strings.Contains(msg, "MTA-STS") {
 		t.Error("Domain without MTA-STS or hostnames should not be queueable, got " + msg)
 	}
@@ -154,12 +179,16 @@ func TestPopulateFromScan(t *testing.T) {
 		},
 	}
 	s.Data.MTASTSResult.MXs = []string{"mx1.example.com", "mx2.example.com"}
+	s.Data.MTASTSResult.Mode = "testing"
 	d.PopulateFromScan(s)
 	for i, mx := range s.Data.MTASTSResult.MXs {
 		if mx != d.MXs[i] {
 			t.Errorf("Expected MXs to match scan, got %s", d.MXs)
 		}
 	}
+	if d.MTASTSMode != "testing" {
+		t.Errorf("Expected MTA-STS mode to match scan, got %s", d.MTASTSMode)
+	}
 }
 
 func TestPolicyCheck(t *testing.T) {
@@ -209,3 +238,53 @@ func TestInitializeWithToken(t *testing.T) {
 		t.Error("Token should have been set for domain")
 	}
 }
+
+func TestInitializeVouched(t *testing.T) {
+	store := mockDomainStore{}
+	domainObj := Domain{Name: "example.com", State: StateUnconfirmed}
+	if err := domainObj.InitializeVouched(&store); err != nil {
+		t.Fatal(err)
+	}
+	if store.domain.State != StateTesting {
+		t.Errorf("Expected vouched domain to skip validation, got state %s", store.domain.State)
+	}
+	if err := domainObj.InitializeVouched(&mockDomainStore{err: errors.New("")}); err == nil {
+		t.Error("Expected InitializeVouched to forward error message from DB")
+	}
+}
+
+func TestStateChangeAuditEntry(t *testing.T) {
+	change := StateChange{Actor: "alice", Reason: "not your domain"}
+	entry := change.AuditEntry("example.com", StateFlagged, StateFailed)
+	if entry.Actor != "alice" || entry.Action != ActionStateChange || entry.Subject != "example.com" ||
+		entry.Details != "flagged to failed: not your domain" {
+		t.Errorf("Unexpected audit entry %+v", entry)
+	}
+	if from, to, ok := ParseStateChange(entry); !ok || from != StateFlagged || to != StateFailed {
+		t.Errorf("Expected the entry to parse as flagged to failed, got %s to %s", from, to)
+	}
+	if entry = (StateChange{}).AuditEntry("example.com", StateEnforce, ""); entry.Details != "added removed" {
+		t.Errorf("Expected removal, got %s", entry.Details)
+	}
+	if from, to, ok := ParseStateChange(entry); !ok || from != StateEnforce || to != "" {
+		t.Errorf("Expected the entry to parse as a removal, got %s to %q", from, to)
+	}
+	if _, _, ok := ParseStateChange(AuditEntry{Action: "moderation.flag"}); ok {
+		t.Error("Expected other actions not to parse as state changes")
+	}
+}
+
+func TestListedMTASTSModes(t *testing.T) {
+	store := &mockDomainStore{domains: []Domain{
+		{Name: "enforce.com", State: StateEnforce, MTASTS: true, MTASTSMode: "enforce"},
+		{Name: "mxs.com", State: StateEnforce, MTASTSMode: "testing"},
+		{Name: "unknown.com", State: StateEnforce, MTASTS: true},
+	}}
+	modes, err := ListedMTASTSModes(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modes) != 1 || modes["enforce.com"] != "enforce" {
+		t.Errorf("Expected only enforce.com's mode, got %v", modes)
+	}
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PurposeDataErasure is the purpose of tokens sent to verify an email address
+// before erasing the data stored about it.
+const PurposeDataErasure = "data_erasure"
+
+// ActionErasure is the audit log action recording an erasure.
+const ActionErasure = "privacy.erase"
+
+// ErasedAddress replaces an erased email address in the audit log entries
+// that mentioned it.
+const ErasedAddress = "[erased]"
+
+// Confirmed returns true if a domain in the state has had its submission
+// confirmed, ie. it's queued, held back, on the list or being removed from
+// it.
+func (s DomainState) Confirmed() bool {
+	return s == StateTesting || s == StateHeld || s == StateEnforce || s == StatePendingRemoval
+}
+
+// Erasure summarizes the data erased about a contact email address.
+type Erasure struct {
+	// Removed are the domains whose submissions were deleted, since they
+	// were never confirmed, or failed.
+	Removed []string `json:"removed"`
+	// Anonymized are the domains that were confirmed with the address as
+	// their contact. They stay queued or on the list, without a contact.
+	Anonymized []string `json:"anonymized"`
+	// RevokedKeys is the number of the address's API keys that were revoked.
+	RevokedKeys int `json:"revoked_keys"`
+	// RedactedAuditEntries is the number of audit log entries the address
+	// was redacted from.
+	RedactedAuditEntries int `json:"redacted_audit_entries"`
+}
+
+// AuditEntry returns the audit log entry recording the erasure. It doesn't
+// mention the address, since that would keep it around.
+func (e Erasure) AuditEntry(actor string) AuditEntry {
+	var details []string
+	if len(e.Removed) > 0 {
+		details = append(details, fmt.Sprintf("removed %s", strings.Join(e.Removed, ", ")))
+	}
+	if len(e.Anonymized) > 0 {
+		details = append(details, fmt.Sprintf("anonymized %s", strings.Join(e.Anonymized, ", ")))
+	}
+	details = append(details, fmt.Sprintf("revoked %d API keys", e.RevokedKeys),
+		fmt.Sprintf("redacted %d audit log entries", e.RedactedAuditEntries))
+	return AuditEntry{Actor: actor, Action: ActionErasure, Details: strings.Join(details, "; ")}
+}
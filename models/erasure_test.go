@@ -0,0 +1,15 @@
+package models
+
+import "testing"
+
+func TestErasureAuditEntry(t *testing.T) {
+	erasure := Erasure{Removed: []string{"a.com", "b.com"}, Anonymized: []string{"c.com"}, RevokedKeys: 1, RedactedAuditEntries: 2}
+	entry := erasure.AuditEntry("owner")
+	if entry.Action != ActionErasure || entry.Subject != "" ||
+		entry.Details != "removed a.com, b.com; anonymized c.com; revoked 1 API keys; redacted 2 audit log entries" {
+		t.Errorf("Unexpected audit entry %+v", entry)
+	}
+	if !DomainState(StateEnforce).Confirmed() || DomainState(StateFailed).Confirmed() {
+		t.Error("Expected only queued, held and added domains to be confirmed")
+	}
+}
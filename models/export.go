@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Purposes of the tokens sent to verify an email address.
+const (
+	PurposeAPIKeys    = "api_keys"    // Issuing the address an API key.
+	PurposeDataExport = "data_export" // Exporting the data stored about it.
+)
+
+// DataExport is everything stored about a contact email address, for
+// answering data subject access requests.
+type DataExport struct {
+	Email     string    `json:"email"`
+	Generated time.Time `json:"generated"`
+	// Domains are submitted with the address as their contact, in every
+	// state they're in.
+	Domains []Domain `json:"domains"`
+	// ValidationTokens are the tokens sent to validate the domains. Their
+	// secrets are left out.
+	ValidationTokens []Token `json:"validation_tokens"`
+	// Moderation is the review of any of the domains that were flagged.
+	Moderation []Moderation `json:"moderation"`
+	APIKeys    []APIKey     `json:"api_keys"`
+	// EmailTokens are the tokens sent to verify the address itself.
+	EmailTokens []EmailToken `json:"email_tokens"`
+	// Bounces are the bounce and complaint notifications received for the
+	// address, which stop us emailing it.
+	Bounces []EmailBounce `json:"bounces"`
+}
+
+// EmailToken records a token sent to verify an email address, without its
+// secret.
+type EmailToken struct {
+	Purpose string    `json:"purpose"`
+	Expires time.Time `json:"expires"`
+	Used    bool      `json:"used"`
+}
+
+// EmailBounce records a bounce or complaint notification for an email
+// address.
+type EmailBounce struct {
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewDataExport returns an empty export for email, generated now.
+func NewDataExport(email string) DataExport {
+	return DataExport{
+		Email:            email,
+		Generated:        time.Now().UTC(),
+		Domains:          []Domain{},
+		ValidationTokens: []Token{},
+		Moderation:       []Moderation{},
+		APIKeys:          []APIKey{},
+		EmailTokens:      []EmailToken{},
+		Bounces:          []EmailBounce{},
+	}
+}
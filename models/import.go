@@ -0,0 +1,132 @@
+package models
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/EFForg/starttls-backend/util"
+	"golang.org/x/net/idna"
+)
+
+// ImportRow is a domain to import straight into the queue or onto the list,
+// eg. when migrating existing policy list entries.
+type ImportRow struct {
+	Domain string      `json:"domain"`
+	MXs    []string    `json:"mxs"`
+	State  DomainState `json:"state"`
+	// Email is the domain's contact address. Defaults to its postmaster.
+	Email      string `json:"email,omitempty"`
+	MTASTS     bool   `json:"mta_sts"`
+	QueueWeeks int    `json:"queue_weeks,omitempty"`
+}
+
+// ImportError reports why a row of an import can't be imported. Rows are
+// numbered from 1, not counting a CSV header.
+type ImportError struct {
+	Row    int    `json:"row"`
+	Domain string `json:"domain"`
+	Error  string `json:"error"`
+}
+
+// ImportResult lists the domains imported, or the rows that stopped the
+// import.
+type ImportResult struct {
+	Imported []string      `json:"imported"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// importColumns are the columns of an import CSV, in the order ImportRow
+// declares them. The domain, mxs and state columns are required.
+var importColumns = []string{"domain", "mxs", "state", "email", "mta_sts", "queue_weeks"}
+
+// ParseImportCSV reads the rows of an import CSV. Its header names the
+// columns, in any order. MX hostnames are separated by spaces.
+func ParseImportCSV(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range importColumns[:3] {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	rows := []ImportRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := ImportRow{
+			Domain: field(record, "domain"),
+			MXs:    strings.Fields(field(record, "mxs")),
+			State:  DomainState(field(record, "state")),
+			Email:  field(record, "email"),
+		}
+		if value := field(record, "mta_sts"); value != "" {
+			if row.MTASTS, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("row %d: mta_sts must be true or false, not %q", len(rows)+1, value)
+			}
+		}
+		if value := field(record, "queue_weeks"); value != "" {
+			if row.QueueWeeks, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("row %d: queue_weeks must be a number, not %q", len(rows)+1, value)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// ToDomain validates the row, and returns the domain to import. The
+// domain's State is the state to import it in.
+func (row ImportRow) ToDomain() (Domain, error) {
+	name, err := idna.ToASCII(strings.ToLower(strings.TrimSpace(row.Domain)))
+	if err != nil || !util.ValidDomainName(name) {
+		return Domain{}, fmt.Errorf("%q is not a valid domain", row.Domain)
+	}
+	if row.State != StateTesting && row.State != StateEnforce {
+		return Domain{}, fmt.Errorf("state must be %s or %s, not %q", StateTesting, StateEnforce, row.State)
+	}
+	if !row.MTASTS && len(row.MXs) == 0 {
+		return Domain{}, fmt.Errorf("no MX hostnames supplied for %s", name)
+	}
+	domain := Domain{
+		Name:       name,
+		Email:      row.Email,
+		MTASTS:     row.MTASTS,
+		State:      row.State,
+		QueueWeeks: row.QueueWeeks,
+	}
+	if domain.Email == "" {
+		domain.Email = "postmaster@" + name
+	} else if parsed, err := mail.ParseAddress(domain.Email); err != nil || parsed.Address != domain.Email {
+		return Domain{}, fmt.Errorf("%q is not a valid email address", row.Email)
+	}
+	for _, mx := range row.MXs {
+		mx = strings.ToLower(mx)
+		if !util.ValidDomainName(strings.TrimPrefix(mx, ".")) {
+			return Domain{}, fmt.Errorf("hostname %s is invalid", mx)
+		}
+		domain.MXs = append(domain.MXs, mx)
+	}
+	return domain, nil
+}
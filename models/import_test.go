@@ -0,0 +1,43 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	rows, err := ParseImportCSV(strings.NewReader("state,domain,mxs,mta_sts\n" +
+		"added,example.com,mx1.example.com .example.net,\n" +
+		"queued,mta-sts.example.com,,true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Domain != "example.com" || len(rows[0].MXs) != 2 || rows[0].State != StateEnforce {
+		t.Errorf("Expected the first row's domain, MXs and state, got %+v", rows)
+	}
+	if !rows[1].MTASTS || len(rows[1].MXs) != 0 {
+		t.Errorf("Expected an MTA-STS domain without MXs, got %+v", rows[1])
+	}
+	if _, err = ParseImportCSV(strings.NewReader("domain,mxs\nexample.com,mx.example.com\n")); err == nil {
+		t.Error("Expected a CSV without a state column to be refused")
+	}
+}
+
+func TestImportRowToDomain(t *testing.T) {
+	domain, err := ImportRow{Domain: "Example.com", MXs: []string{"MX.example.com"}, State: StateTesting}.ToDomain()
+	if err != nil || domain.Name != "example.com" || domain.MXs[0] != "mx.example.com" || domain.Email != "postmaster@example.com" {
+		t.Errorf("Expected a normalized domain, got %+v, %v", domain, err)
+	}
+	for _, row := range []ImportRow{
+		{Domain: "localhost", MXs: []string{"mx.example.com"}, State: StateTesting},
+		{Domain: "example.com", MXs: []string{"mx.example.com"}, State: StateFailed},
+		{Domain: "example.com", State: StateEnforce},
+		{Domain: "example.com", MXs: []string{"not a hostname"}, State: StateEnforce},
+		{Domain: "example.com", MXs: []string{"mx.example.com"}, State: StateEnforce, Email: "not an address"},
+		{Domain: "example.com", MXs: []string{"mx.example.com"}, State: StateEnforce, Email: "Admin <admin@example.com>"},
+	} {
+		if _, err := row.ToDomain(); err == nil {
+			t.Errorf("Expected %+v to be invalid", row)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Moderation decisions for flagged submissions.
+const (
+	DecisionPending  = ""
+	DecisionApproved = "approved"
+	DecisionRejected = "rejected"
+)
+
+// Moderation records why a domain's submission was held for review, and what
+// the reviewer decided.
+type Moderation struct {
+	Domain  string    `json:"domain"`
+	Reasons []string  `json:"reasons"`
+	Flagged time.Time `json:"flagged"`
+	// Decision is DecisionPending until a reviewer approves or rejects the
+	// submission.
+	Decision string    `json:"decision"`
+	Reviewer string    `json:"reviewer,omitempty"`
+	Note     string    `json:"note,omitempty"`
+	Decided  time.Time `json:"decided"`
+}
+
+// AuditEntry records an action taken by an administrator, or by the server
+// on its own.
+type AuditEntry struct {
+	ID     int64     `json:"id"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	// Subject is what the action was taken on, eg. a domain.
+	Subject string `json:"subject"`
+	Details string `json:"details,omitempty"`
+}
+
+// ModerationFlags returns the reasons a submission should be reviewed by an
+// administrator before we email its validation link, or nil if it's fine to
+// send. Submissions are flagged when they come close to a domain on the
+// no-scan list without matching it exactly.
+func (d *Domain) ModerationFlags(dontScan map[string]bool) []string {
+	denied := make([]string, 0, len(dontScan))
+	for name := range dontScan {
+		denied = append(denied, strings.ToLower(name))
+	}
+	sort.Strings(denied)
+	var reasons []string
+	name := strings.ToLower(d.Name)
+	for _, deny := range denied {
+		if strings.HasSuffix(name, "."+deny) {
+			reasons = append(reasons, fmt.Sprintf("%s is a subdomain of %s, which is on the no-scan list", name, deny))
+		} else if name != deny && editDistance(name, deny) == 1 {
+			reasons = append(reasons, fmt.Sprintf("%s is one character away from %s, which is on the no-scan list", name, deny))
+		}
+		// MXs and contact addresses under the submitted domain are already
+		// covered by the checks above.
+		for _, mx := range d.MXs {
+			host := strings.TrimPrefix(strings.ToLower(mx), ".")
+			if !underDomain(host, name) && underDomain(host, deny) {
+				reasons = append(reasons, fmt.Sprintf("MX hostname %s is under %s, which is on the no-scan list", mx, deny))
+			}
+		}
+		at := strings.LastIndex(d.Email, "@")
+		if at < 0 {
+			continue
+		}
+		emailDomain := strings.ToLower(d.Email[at+1:])
+		if !underDomain(emailDomain, name) && underDomain(emailDomain, deny) {
+			reasons = append(reasons, fmt.Sprintf("contact email %s is under %s, which is on the no-scan list", d.Email, deny))
+		}
+	}
+	return reasons
+}
+
+// InitializeFlagged adds this domain to the given DomainStore, held for
+// review. No validation token is issued until it's approved.
+func (d *Domain) InitializeFlagged(store domainStore) error {
+	if err := store.PutDomain(*d); err != nil {
+		return err
+	}
+	return store.SetStatus(d.Name, StateFlagged, StateChange{Actor: ActorModeration, Reason: "held for review"})
+}
+
+// underDomain returns true if name is domain or one of its subdomains.
+func underDomain(name string, domain string) bool {
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestModerationFlags(t *testing.T) {
+	dontScan := map[string]bool{"dontscan.com": true}
+	var tests = []struct {
+		domain  Domain
+		flagged string
+	}{
+		{Domain{Name: "example.com", MXs: []string{"mx.example.com"}, Email: "admin@example.com"}, ""},
+		{Domain{Name: "dontscan.com", MXs: []string{"mx.dontscan.com"}}, ""},
+		{Domain{Name: "mail.dontscan.com", MXs: []string{"mx.mail.dontscan.com"}}, "subdomain of dontscan.com"},
+		{Domain{Name: "dontscam.com"}, "one character away from dontscan.com"},
+		{Domain{Name: "example.com", MXs: []string{".dontscan.com"}}, "MX hostname .dontscan.com"},
+		{Domain{Name: "example.com", Email: "admin@mail.dontscan.com"}, "contact email admin@mail.dontscan.com"},
+	}
+	for _, test := range tests {
+		reasons := test.domain.ModerationFlags(dontScan)
+		if test.flagged == "" {
+			if len(reasons) > 0 {
+				t.Errorf("Expected %s not to be flagged, got %v", test.domain.Name, reasons)
+			}
+			continue
+		}
+		if len(reasons) != 1 || !strings.Contains(reasons[0], test.flagged) {
+			t.Errorf("Expected %s to be flagged with %q, got %v", test.domain.Name, test.flagged, reasons)
+		}
+	}
+}
+
+func TestInitializeFlagged(t *testing.T) {
+	store := &mockDomainStore{}
+	domain := Domain{Name: "example.com"}
+	if err := domain.InitializeFlagged(store); err != nil {
+		t.Fatal(err)
+	}
+	if store.domain.State != StateFlagged {
+		t.Errorf("Expected domain to be flagged, got %s", store.domain.State)
+	}
+}
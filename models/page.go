@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Orders in which pages of domains can be listed.
+const (
+	SortByName        = "name"         // By name, alphabetically.
+	SortByLastUpdated = "last_updated" // Most recently updated first.
+)
+
+// DomainPage selects a page of the domains in a state, so they can be listed
+// without loading every one of them at once. Pages are keyset-paginated: each
+// page starts after the last domain of the previous page, so domains added or
+// updated while paging don't shift later pages.
+type DomainPage struct {
+	// Limit is the most domains to return. Zero or less means no limit.
+	Limit int
+	// Sort is SortByName, the default, or SortByLastUpdated.
+	Sort string
+	// After is the Next cursor of the previous page, or empty for the first
+	// page.
+	After string
+}
+
+// Validate returns an error if the page's sort order or cursor is invalid.
+func (p DomainPage) Validate() error {
+	if p.Sort != "" && p.Sort != SortByName && p.Sort != SortByLastUpdated {
+		return fmt.Errorf("sort must be %s or %s", SortByName, SortByLastUpdated)
+	}
+	_, _, err := p.Cursor()
+	return err
+}
+
+// Cursor decodes After into the last updated time and name of the last
+// domain of the previous page. The time is zero when sorting by name.
+func (p DomainPage) Cursor() (time.Time, string, error) {
+	if p.After == "" {
+		return time.Time{}, "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(p.After)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid page cursor")
+	}
+	parts := strings.SplitN(string(raw), " ", 2)
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) != 2 {
+		return time.Time{}, "", fmt.Errorf("invalid page cursor")
+	}
+	if nanos == 0 {
+		return time.Time{}, parts[1], nil
+	}
+	return time.Unix(0, nanos).UTC(), parts[1], nil
+}
+
+// NextCursor returns the cursor for the page after one ending with last.
+func (p DomainPage) NextCursor(last Domain) string {
+	var nanos int64
+	if p.Sort == SortByLastUpdated {
+		nanos = last.LastUpdated.UnixNano()
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d %s", nanos, last.Name)))
+}
+
+// DomainList is a page of domains.
+type DomainList struct {
+	Domains []Domain `json:"domains"`
+	// Next is the cursor for the next page, or empty if this is the last.
+	Next string `json:"next,omitempty"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDomainPageCursor(t *testing.T) {
+	updated := time.Date(2019, time.March, 1, 2, 3, 4, 5000, time.UTC)
+	page := DomainPage{Sort: SortByLastUpdated}
+	page.After = page.NextCursor(Domain{Name: "example.com", LastUpdated: updated})
+	lastUpdated, name, err := page.Cursor()
+	if err != nil || !lastUpdated.Equal(updated) || name != "example.com" {
+		t.Errorf("Expected cursor to round trip, got %v %s %v", lastUpdated, name, err)
+	}
+
+	page = DomainPage{}
+	page.After = page.NextCursor(Domain{Name: "example.com", LastUpdated: updated})
+	if lastUpdated, name, _ := page.Cursor(); !lastUpdated.IsZero() || name != "example.com" {
+		t.Errorf("Expected name cursor without a time, got %v %s", lastUpdated, name)
+	}
+
+	for _, invalid := range []DomainPage{{After: "!!"}, {After: "bm9wZQ"}, {Sort: "email"}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", invalid)
+		}
+	}
+}
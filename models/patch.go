@@ -0,0 +1,82 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/EFForg/starttls-backend/util"
+)
+
+// Bounds on the number of weeks domains in DefaultQueueClass can be queued
+// for.
+const (
+	MinQueueWeeks = 4
+	MaxQueueWeeks = 51
+)
+
+// MergePatch applies a JSON merge patch (RFC 7396) to the fields of d that
+// administrators may correct: "mxs", "queue_weeks" and "mta_sts". Patching
+// any other field is an error, as is a patch that leaves the domain invalid,
+// including queue weeks out of the bounds policy sets for the domain.
+// Returns the patched domain and a description of each field that changed.
+func (d Domain) MergePatch(patch []byte, policy QueuePolicy) (Domain, []string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil || fields == nil {
+		return d, nil, fmt.Errorf("patch must be a JSON object")
+	}
+	patched := d
+	patched.MXs = append([]string{}, d.MXs...)
+	for key, value := range fields {
+		if bytes.Equal(value, []byte("null")) {
+			if key != "mxs" {
+				return d, nil, fmt.Errorf("%s can't be removed", key)
+			}
+			patched.MXs = []string{}
+			continue
+		}
+		var err error
+		switch key {
+		case "mxs":
+			err = json.Unmarshal(value, &patched.MXs)
+		case "queue_weeks":
+			err = json.Unmarshal(value, &patched.QueueWeeks)
+		case "mta_sts":
+			err = json.Unmarshal(value, &patched.MTASTS)
+		default:
+			return d, nil, fmt.Errorf("%s can't be patched", key)
+		}
+		if err != nil {
+			return d, nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+	for i, mx := range patched.MXs {
+		patched.MXs[i] = strings.ToLower(mx)
+		if !util.ValidDomainName(strings.TrimPrefix(patched.MXs[i], ".")) {
+			return d, nil, fmt.Errorf("hostname %s is invalid", mx)
+		}
+	}
+	if !patched.MTASTS && len(patched.MXs) == 0 {
+		return d, nil, fmt.Errorf("domains not using MTA-STS need at least one MX hostname")
+	}
+	if err := policy.CheckWeeks(patched); err != nil {
+		return d, nil, err
+	}
+	return patched, d.changes(patched), nil
+}
+
+// changes describes the patchable fields that differ between d and patched.
+func (d Domain) changes(patched Domain) []string {
+	changes := []string{}
+	if strings.Join(d.MXs, ",") != strings.Join(patched.MXs, ",") {
+		changes = append(changes, fmt.Sprintf("mxs: %v -> %v", d.MXs, patched.MXs))
+	}
+	if d.QueueWeeks != patched.QueueWeeks {
+		changes = append(changes, fmt.Sprintf("queue_weeks: %d -> %d", d.QueueWeeks, patched.QueueWeeks))
+	}
+	if d.MTASTS != patched.MTASTS {
+		changes = append(changes, fmt.Sprintf("mta_sts: %t -> %t", d.MTASTS, patched.MTASTS))
+	}
+	return changes
+}
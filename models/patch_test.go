@@ -0,0 +1,55 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	d := Domain{Name: "example.com", MXs: []string{"mx1.example.com"}, QueueWeeks: 4}
+	patched, changes, err := d.MergePatch([]byte(`{"mxs": ["MX1.example.com", ".example.net"], "queue_weeks": 6}`), QueuePolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(patched.MXs, []string{"mx1.example.com", ".example.net"}) || patched.QueueWeeks != 6 {
+		t.Errorf("Expected MXs and queue weeks to be patched, got %+v", patched)
+	}
+	if len(changes) != 2 {
+		t.Errorf("Expected two changes, got %v", changes)
+	}
+	if !reflect.DeepEqual(d.MXs, []string{"mx1.example.com"}) {
+		t.Errorf("Expected original domain to be unchanged, got %v", d.MXs)
+	}
+
+	patched, changes, err = d.MergePatch([]byte(`{"mta_sts": true, "mxs": null}`), QueuePolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !patched.MTASTS || len(patched.MXs) != 0 || len(changes) != 2 {
+		t.Errorf("Expected domain to switch to MTA-STS, got %+v (%v)", patched, changes)
+	}
+
+	_, changes, err = d.MergePatch([]byte(`{}`), QueuePolicy{})
+	if err != nil || len(changes) != 0 {
+		t.Errorf("Expected empty patch to change nothing, got %v (%v)", changes, err)
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	d := Domain{Name: "example.com", MXs: []string{"mx1.example.com"}, QueueWeeks: 4}
+	for _, patch := range []string{
+		`[]`,
+		`null`,
+		`{"email": "attacker@example.net"}`,
+		`{"state": "added"}`,
+		`{"queue_weeks": null}`,
+		`{"queue_weeks": 2}`,
+		`{"queue_weeks": "6"}`,
+		`{"mxs": null}`,
+		`{"mxs": ["not a hostname"]}`,
+	} {
+		if _, _, err := d.MergePatch([]byte(patch), QueuePolicy{}); err == nil {
+			t.Errorf("Expected patch %s to be rejected", patch)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+// Promotion records the final verification of a queued domain before it's
+// promoted from testing to enforce on the policy list. The evidence is kept
+// whether or not the domain passed.
+type Promotion struct {
+	ID       int64     `json:"id"`
+	Domain   string    `json:"domain"`
+	Time     time.Time `json:"time"`
+	Promoted bool      `json:"promoted"`
+	// Scans of the domain from each vantage point, by vantage point name.
+	Scans map[string]checker.DomainResult `json:"scans"`
+	// Vantage points that couldn't scan the domain, mapped to the error.
+	ScanErrors map[string]string `json:"scan_errors,omitempty"`
+	// Reasons the domain failed verification.
+	Failures []string `json:"failures,omitempty"`
+	// AwaitingConfirmation is set if the domain passed verification, but
+	// won't be promoted until its owner confirms it's ready.
+	AwaitingConfirmation bool `json:"awaiting_confirmation,omitempty"`
+	// Confirmed is set if the domain was promoted once its owner confirmed
+	// the promotion awaiting confirmation.
+	Confirmed bool `json:"confirmed,omitempty"`
+}
+
+// Owners asked to confirm a promotion are emailed a link to confirm it, and
+// a link to report a problem, which holds the domain back from the list. Like
+// MX challenges, the links are signed with our secret, and bound to the
+// promotion attempt and an expiry time, so we don't need to store them.
+
+// Actions an owner can take on a promotion awaiting confirmation.
+const (
+	PromotionConfirm = "confirm"
+	PromotionHold    = "hold"
+)
+
+// PromotionLinkTTL is how long the links in confirmation emails work for.
+const PromotionLinkTTL = 14 * 24 * time.Hour
+
+// PromotionLink returns the query string of a link that lets the owner of
+// p's domain take action on it until expires.
+func PromotionLink(secret []byte, p Promotion, action string, expires time.Time) string {
+	values := url.Values{
+		"domain":  {p.Domain},
+		"id":      {strconv.FormatInt(p.ID, 10)},
+		"action":  {action},
+		"expires": {strconv.FormatInt(expires.Unix(), 10)},
+	}
+	values.Set("signature", promotionSignature(secret, p.Domain, p.ID, action, expires.Unix()))
+	return values.Encode()
+}
+
+// CheckPromotionLink returns an error unless signature authorizes action on
+// the promotion of domain with id, and expires, in Unix time, is after now.
+func CheckPromotionLink(secret []byte, domain string, id int64, action string, expires int64, signature string, now time.Time) error {
+	expected := promotionSignature(secret, domain, id, action, expires)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("this link isn't valid")
+	}
+	if now.After(time.Unix(expires, 0)) {
+		return fmt.Errorf("this link has expired")
+	}
+	return nil
+}
+
+func promotionSignature(secret []byte, domain string, id int64, action string, expires int64) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%d\n%s\n%d", strings.ToLower(domain), id, action, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
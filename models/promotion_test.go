@@ -0,0 +1,38 @@
+package models
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPromotionLink(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	p := Promotion{ID: 3, Domain: "example.com"}
+	values, err := url.ParseQuery(PromotionLink(secret, p, PromotionConfirm, now.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires, _ := strconv.ParseInt(values.Get("expires"), 10, 64)
+	check := func(secret []byte, domain string, id int64, action string, now time.Time) error {
+		return CheckPromotionLink(secret, domain, id, action, expires, values.Get("signature"), now)
+	}
+	if err := check(secret, "Example.com", 3, PromotionConfirm, now); err != nil {
+		t.Errorf("Expected link to be valid, got %v", err)
+	}
+	if err := check(secret, "example.com", 3, PromotionConfirm, now.Add(2*time.Hour)); err == nil {
+		t.Error("Expected link to expire")
+	}
+	for _, err := range []error{
+		check([]byte("other"), "example.com", 3, PromotionConfirm, now),
+		check(secret, "example.net", 3, PromotionConfirm, now),
+		check(secret, "example.com", 4, PromotionConfirm, now),
+		check(secret, "example.com", 3, PromotionHold, now),
+	} {
+		if err == nil {
+			t.Error("Expected link to be bound to the secret, domain, promotion and action")
+		}
+	}
+}
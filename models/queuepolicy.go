@@ -0,0 +1,139 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultQueueWeeks is how many weeks domains are queued for, unless their
+// submission says otherwise.
+const DefaultQueueWeeks = 4
+
+// QueueClass bounds how many weeks a class of domains can be queued in
+// testing for before they're promoted to enforce.
+type QueueClass struct {
+	Name string `json:"name"`
+	// Domains in the class. Names starting with "." match any subdomain.
+	Domains  []string `json:"domains"`
+	MinWeeks int      `json:"min_weeks"`
+	MaxWeeks int      `json:"max_weeks"`
+}
+
+// DefaultQueueClass is the class of domains that no QueuePolicy class lists.
+var DefaultQueueClass = QueueClass{Name: "default", MinWeeks: MinQueueWeeks, MaxWeeks: MaxQueueWeeks}
+
+// QueuePolicy is the organization's policy on how long domains are queued
+// for, by class. For instance, large providers may be allowed a shorter
+// testing window once they've been verified as partners, while every other
+// domain waits the default. The zero QueuePolicy puts every domain in
+// DefaultQueueClass.
+type QueuePolicy struct {
+	// Classes are matched in order; the first that lists a domain applies.
+	Classes []QueueClass `json:"classes"`
+	// Default, if set, replaces DefaultQueueClass.
+	Default *QueueClass `json:"default,omitempty"`
+}
+
+// Class returns the class of the domain name.
+func (p QueuePolicy) Class(name string) QueueClass {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, class := range p.Classes {
+		for _, pattern := range class.Domains {
+			pattern = strings.ToLower(pattern)
+			if name == pattern || (strings.HasPrefix(pattern, ".") && strings.HasSuffix(name, pattern)) {
+				return class
+			}
+		}
+	}
+	if p.Default != nil {
+		return *p.Default
+	}
+	return DefaultQueueClass
+}
+
+// DefaultWeeks returns how many weeks domains of the class are queued for if
+// their submission doesn't say: DefaultQueueWeeks, within the class's bounds.
+func (c QueueClass) DefaultWeeks() int {
+	if DefaultQueueWeeks < c.MinWeeks {
+		return c.MinWeeks
+	}
+	if DefaultQueueWeeks > c.MaxWeeks {
+		return c.MaxWeeks
+	}
+	return DefaultQueueWeeks
+}
+
+// CheckWeeks returns an error if d's QueueWeeks are out of its class's bounds.
+func (p QueuePolicy) CheckWeeks(d Domain) error {
+	class := p.Class(d.Name)
+	if d.QueueWeeks < class.MinWeeks || d.QueueWeeks > class.MaxWeeks {
+		return fmt.Errorf("queue_weeks must be between %d and %d for %s domains, was %d",
+			class.MinWeeks, class.MaxWeeks, class.Name, d.QueueWeeks)
+	}
+	return nil
+}
+
+// TestingWeeks returns how many weeks d must spend in testing before it can
+// be promoted: its QueueWeeks, but at least its class's MinWeeks, in case the
+// policy changed after it was queued.
+func (p QueuePolicy) TestingWeeks(d Domain) int {
+	if min := p.Class(d.Name).MinWeeks; d.QueueWeeks < min {
+		return min
+	}
+	return d.QueueWeeks
+}
+
+// PromotableAt returns when d, queued in testing, can be promoted.
+func (p QueuePolicy) PromotableAt(d Domain) time.Time {
+	return d.TestingStart.Add(time.Duration(p.TestingWeeks(d)) * 7 * 24 * time.Hour)
+}
+
+func (c QueueClass) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("queue classes need a name")
+	}
+	if c.MinWeeks < 1 || c.MaxWeeks < c.MinWeeks || c.MaxWeeks > 52 {
+		return fmt.Errorf("queue class %s must have 1 <= min_weeks <= max_weeks <= 52, got %d and %d",
+			c.Name, c.MinWeeks, c.MaxWeeks)
+	}
+	return nil
+}
+
+// LoadQueuePolicy reads a QueuePolicy from the JSON file at path.
+func LoadQueuePolicy(path string) (QueuePolicy, error) {
+	var p QueuePolicy
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	if err = json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("couldn't read queue policy %s: %v", path, err)
+	}
+	for _, class := range p.Classes {
+		if err := class.validate(); err != nil {
+			return p, err
+		}
+		if len(class.Domains) == 0 {
+			return p, fmt.Errorf("queue class %s doesn't list any domains", class.Name)
+		}
+	}
+	if p.Default != nil {
+		if err := p.Default.validate(); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// QueuePolicyFromEnv reads a QueuePolicy from the file named by
+// QUEUE_POLICY_FILE. If it's unset, every domain is in DefaultQueueClass.
+func QueuePolicyFromEnv() (QueuePolicy, error) {
+	if path := os.Getenv("QUEUE_POLICY_FILE"); path != "" {
+		return LoadQueuePolicy(path)
+	}
+	return QueuePolicy{}, nil
+}
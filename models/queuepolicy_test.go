@@ -0,0 +1,83 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+var testQueuePolicy = QueuePolicy{Classes: []QueueClass{
+	{Name: "partner", Domains: []string{"example.com", ".example.net"}, MinWeeks: 1, MaxWeeks: 8},
+}}
+
+func TestQueuePolicyClass(t *testing.T) {
+	tests := map[string]string{
+		"example.com":      "partner",
+		"EXAMPLE.com.":     "partner",
+		"mail.example.net": "partner",
+		"example.net":      "default",
+		"sub.example.com":  "default",
+		"example.org":      "default",
+	}
+	for name, class := range tests {
+		if got := testQueuePolicy.Class(name).Name; got != class {
+			t.Errorf("Expected %s to be a %s domain, got %s", name, class, got)
+		}
+	}
+}
+
+func TestQueuePolicyCheckWeeks(t *testing.T) {
+	if err := testQueuePolicy.CheckWeeks(Domain{Name: "example.com", QueueWeeks: 1}); err != nil {
+		t.Errorf("Expected partner to be queued for a week: %v", err)
+	}
+	if err := testQueuePolicy.CheckWeeks(Domain{Name: "example.com", QueueWeeks: 10}); err == nil {
+		t.Error("Expected partner to be held to its class's maximum")
+	}
+	if err := testQueuePolicy.CheckWeeks(Domain{Name: "example.org", QueueWeeks: 1}); err == nil {
+		t.Error("Expected unknown domain to wait the default minimum")
+	}
+	if weeks := testQueuePolicy.Class("example.org").DefaultWeeks(); weeks != DefaultQueueWeeks {
+		t.Errorf("Expected default weeks %d, got %d", DefaultQueueWeeks, weeks)
+	}
+	short := QueueClass{Name: "short", MinWeeks: 1, MaxWeeks: 2}
+	if weeks := short.DefaultWeeks(); weeks != 2 {
+		t.Errorf("Expected default weeks within the class's bounds, got %d", weeks)
+	}
+}
+
+func TestQueuePolicyPromotableAt(t *testing.T) {
+	start := time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)
+	d := Domain{Name: "example.org", QueueWeeks: 6, TestingStart: start}
+	if at := testQueuePolicy.PromotableAt(d); !at.Equal(start.Add(6 * 7 * 24 * time.Hour)) {
+		t.Errorf("Expected domain to be promotable after its queue weeks, got %v", at)
+	}
+	// Domains queued for less than their class now allows wait its minimum.
+	d.QueueWeeks = 1
+	if weeks := testQueuePolicy.TestingWeeks(d); weeks != MinQueueWeeks {
+		t.Errorf("Expected %d weeks, got %d", MinQueueWeeks, weeks)
+	}
+}
+
+func TestLoadQueuePolicy(t *testing.T) {
+	for contents, valid := range map[string]bool{
+		`{"classes": [{"name": "partner", "domains": ["example.com"], "min_weeks": 1, "max_weeks": 8}]}`: true,
+		`{"default": {"name": "default", "min_weeks": 6, "max_weeks": 12}}`:                              true,
+		`{"classes": [{"name": "partner", "domains": ["example.com"], "min_weeks": 0, "max_weeks": 8}]}`: false,
+		`{"classes": [{"name": "partner", "domains": ["example.com"], "min_weeks": 8, "max_weeks": 4}]}`: false,
+		`{"classes": [{"name": "partner", "min_weeks": 1, "max_weeks": 8}]}`:                             false,
+		`{"classes": [`: false,
+	} {
+		f, err := ioutil.TempFile("", "queue-policy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(contents)
+		f.Close()
+		_, err = LoadQueuePolicy(f.Name())
+		os.Remove(f.Name())
+		if valid != (err == nil) {
+			t.Errorf("Expected %s to be valid: %v, got %v", contents, valid, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// DefaultRemovalGrace is how long a domain stays pending removal before it's
+// removed, so that it can be dropped from the list and its mirrors first.
+const DefaultRemovalGrace = 14 * 24 * time.Hour
+
+// RemovalDue returns when a domain that's pending removal will be removed,
+// after grace. The grace period starts when its owner confirmed its removal.
+func (d Domain) RemovalDue(grace time.Duration) time.Time {
+	return d.RemovalRequested.Add(grace)
+}
+
+// PendingRemovals returns the names of the domains pending removal, which are
+// left out of the policy list while they wait for their grace period to end.
+func PendingRemovals(store domainStore) ([]string, error) {
+	pending, err := store.GetDomains(StatePendingRemoval)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, d := range pending {
+		names = append(names, d.Name)
+	}
+	return names, nil
+}
+
+// RemoveDue removes the domains whose removal grace period was over by now,
+// and returns their names.
+func RemoveDue(store domainStore, grace time.Duration, now time.Time) ([]string, error) {
+	pending, err := store.GetDomains(StatePendingRemoval)
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, d := range pending {
+		if d.RemovalDue(grace).After(now) {
+			continue
+		}
+		if _, err = store.RemoveDomain(d.Name, StatePendingRemoval,
+			StateChange{Actor: ActorRemoval, Reason: "removal grace period ended"}); err != nil {
+			return removed, err
+		}
+		removed = append(removed, d.Name)
+	}
+	return removed, nil
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+type mockRemovalStore struct {
+	mockDomainStore
+	removed []string
+}
+
+func (m *mockRemovalStore) RemoveDomain(d string, state DomainState, _ StateChange) (Domain, error) {
+	m.removed = append(m.removed, d)
+	return Domain{Name: d, State: state}, nil
+}
+
+func TestRemoveDue(t *testing.T) {
+	now := time.Now()
+	store := &mockRemovalStore{mockDomainStore: mockDomainStore{domains: []Domain{
+		{Name: "due.com", State: StatePendingRemoval, RemovalRequested: now.Add(-DefaultRemovalGrace)},
+		{Name: "pending.com", State: StatePendingRemoval, RemovalRequested: now.Add(-time.Hour), LastUpdated: now.Add(-DefaultRemovalGrace)},
+	}}}
+	removed, err := RemoveDue(store, DefaultRemovalGrace, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "due.com" || len(store.removed) != 1 {
+		t.Errorf("Expected only due.com to be removed, got %v", removed)
+	}
+}
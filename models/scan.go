@@ -9,16 +9,63 @@ import (
 // ScanVersion is the version of the Scan API that the binary is currently using.
 const ScanVersion = 1
 
+// ScanSource identifies what triggered a scan.
+type ScanSource string
+
+// Possible values for ScanSource
+const (
+	SourceAPI       ScanSource = "api"       // Interactive scan requested through the API.
+	SourceValidator ScanSource = "validator" // Regular validation of queued or listed domains.
+	SourceCensus    ScanSource = "census"    // Bulk scan of many domains, eg. the top million.
+	SourceReplay    ScanSource = "replay"    // Scan replayed from recorded traffic.
+)
+
+// Trusted returns true if scans from this source can be used to decide
+// whether a domain may be queued for the policy list: those requested
+// through the API or performed by the validator. Scans recorded before
+// sources were tracked, with no source, were all API scans.
+func (s ScanSource) Trusted() bool {
+	switch s {
+	case "", SourceAPI, SourceValidator:
+		return true
+	}
+	return false
+}
+
+// ScanProfile identifies which checks were performed in a scan.
+type ScanProfile string
+
+// Possible values for ScanProfile
+const (
+	ProfileFull  ScanProfile = "full"  // All hostname and domain checks.
+	ProfileQuick ScanProfile = "quick" // A subset of checks, eg. skipping hostname checks.
+)
+
+// Full returns true if every check was performed in scans with this
+// profile. Scans recorded before profiles were tracked, with no profile,
+// were all full.
+func (p ScanProfile) Full() bool {
+	return p == "" || p == ProfileFull
+}
+
 // Scan stores the result of a scan of a domain
 type Scan struct {
 	Domain    string               `json:"domain"`    // Input domain
 	Data      checker.DomainResult `json:"scandata"`  // Scan results from starttls-checker
 	Timestamp time.Time            `json:"timestamp"` // Time at which this scan was conducted
 	Version   uint32               `json:"version"`   // Version counter
+	Source    ScanSource           `json:"source"`    // What triggered this scan
+	Profile   ScanProfile          `json:"profile"`   // Which checks were performed
+	// Checker that performed this scan, and its settings. Nil for scans
+	// stored before they were recorded.
+	Checker *checker.Settings `json:"checker,omitempty"`
 }
 
+// scanStore reads the trusted scans that decide whether a domain may be
+// queued. See Scan.Trusted.
 type scanStore interface {
-	GetLatestScan(string) (Scan, error)
+	GetLatestTrustedScan(string) (Scan, error)
+	GetLatestTrustedScans(string, int) ([]Scan, error)
 }
 
 // CanAddToPolicyList returns true if the domain owner should be prompted to
@@ -31,6 +78,13 @@ func (s Scan) CanAddToPolicyList() bool {
 	return false
 }
 
+// Trusted returns true if this scan can be used to decide whether a domain
+// may be queued for the policy list: it must be a full scan from a trusted
+// source.
+func (s Scan) Trusted() bool {
+	return s.Source.Trusted() && s.Profile.Full()
+}
+
 // SupportsMTASTS returns true if the Scan's MTA-STS check passed.
 func (s Scan) SupportsMTASTS() bool {
 	if s.Data.MTASTSResult == nil {
@@ -38,3 +92,10 @@ func (s Scan) SupportsMTASTS() bool {
 	}
 	return s.Data.MTASTSResult.Status == checker.Success || s.Data.MTASTSResult.Status == checker.Warning
 }
+
+// ScanCount is the number of scans from a source that ended with a status.
+type ScanCount struct {
+	Source ScanSource           `json:"source"`
+	Status checker.DomainStatus `json:"status"`
+	Count  int                  `json:"count"`
+}
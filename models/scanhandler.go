@@ -0,0 +1,92 @@
+package models
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+const defaultScanBatchSize = 500
+
+// ScanBatchStore stores many scans at once.
+type ScanBatchStore interface {
+	PutScans([]Scan) error
+}
+
+// ScanHandler is a checker.ResultHandler which stores bulk scan results as
+// Scans, inserted in batches, so they can be read through the API like any
+// other scan. Call Flush once all domains have been handled to insert the
+// final batch.
+type ScanHandler struct {
+	Store ScanBatchStore `json:"-"`
+	// Source labels every stored scan. Defaults to SourceCensus.
+	Source ScanSource
+	// Profile records which checks were run. Defaults to ProfileFull.
+	Profile ScanProfile
+	// Checker records the checker the domains were checked with, if set.
+	// Otherwise, the settings each result was checked with are recorded.
+	Checker   *checker.Settings
+	BatchSize int `json:"-"`
+
+	mu     sync.Mutex
+	scans  []Scan
+	Stored int
+	Failed int
+}
+
+// HandleDomain queues a domain result to be stored, inserting the queued
+// batch once it's full.
+func (h *ScanHandler) HandleDomain(r checker.DomainResult) {
+	scan := Scan{
+		Domain:    r.Domain,
+		Data:      r,
+		Timestamp: time.Now(),
+		Version:   ScanVersion,
+		Source:    h.Source,
+		Profile:   h.Profile,
+		Checker:   h.Checker,
+	}
+	if scan.Checker == nil {
+		scan.Checker = r.Checker
+	}
+	if scan.Source == "" {
+		scan.Source = SourceCensus
+	}
+	if scan.Profile == "" {
+		scan.Profile = ProfileFull
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.scans = append(h.scans, scan)
+	batchSize := h.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultScanBatchSize
+	}
+	if len(h.scans) >= batchSize {
+		h.flush()
+	}
+}
+
+// Flush inserts any queued scans.
+func (h *ScanHandler) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.flush()
+}
+
+func (h *ScanHandler) flush() error {
+	if len(h.scans) == 0 {
+		return nil
+	}
+	scans := h.scans
+	h.scans = nil
+	if err := h.Store.PutScans(scans); err != nil {
+		log.Printf("Couldn't store %d scans: %v", len(scans), err)
+		h.Failed += len(scans)
+		return err
+	}
+	h.Stored += len(scans)
+	return nil
+}
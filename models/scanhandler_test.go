@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+type fakeScanBatchStore struct {
+	batches [][]Scan
+	err     error
+}
+
+func (s *fakeScanBatchStore) PutScans(scans []Scan) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, scans)
+	return nil
+}
+
+func TestScanHandlerBatches(t *testing.T) {
+	store := &fakeScanBatchStore{}
+	h := &ScanHandler{Store: store, BatchSize: 2}
+	for i := 0; i < 5; i++ {
+		h.HandleDomain(checker.NewSampleDomainResult(fmt.Sprintf("%d.example.com", i)))
+	}
+	if len(store.batches) != 2 {
+		t.Errorf("Expected 2 full batches before flushing, got %d", len(store.batches))
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.batches) != 3 || len(store.batches[2]) != 1 || h.Stored != 5 {
+		t.Errorf("Expected the final partial batch to be stored on Flush, got %v", store.batches)
+	}
+	scan := store.batches[0][1]
+	if scan.Domain != "1.example.com" || scan.Source != SourceCensus || scan.Profile != ProfileFull ||
+		scan.Version != ScanVersion || scan.Timestamp.IsZero() {
+		t.Errorf("Unexpected scan %+v", scan)
+	}
+	// Census scans aren't used to decide whether domains can be queued.
+	if scan.Trusted() {
+		t.Error("Expected bulk scans not to be trusted")
+	}
+}
+
+func TestScanHandlerLabels(t *testing.T) {
+	store := &fakeScanBatchStore{}
+	settings := &checker.Settings{Version: "v1.2.3"}
+	h := &ScanHandler{Store: store, Source: "adoption-2020", Profile: ProfileQuick, Checker: settings}
+	h.HandleDomain(checker.NewSampleDomainResult("example.com"))
+	h.Flush()
+	if scan := store.batches[0][0]; scan.Source != "adoption-2020" || scan.Profile != ProfileQuick || scan.Checker != settings {
+		t.Errorf("Expected scan to be labelled, got %+v", scan)
+	}
+}
+
+func TestScanHandlerFailure(t *testing.T) {
+	h := &ScanHandler{Store: &fakeScanBatchStore{err: errors.New("db down")}}
+	h.HandleDomain(checker.NewSampleDomainResult("example.com"))
+	if err := h.Flush(); err == nil || h.Failed != 1 || h.Stored != 0 {
+		t.Errorf("Expected failed batch to be counted, got %v, %+v", err, h)
+	}
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ways a domain search can match domain names.
+const (
+	MatchPrefix    = "prefix"    // Names starting with the query.
+	MatchSubstring = "substring" // Names containing the query anywhere.
+)
+
+// DomainSearch finds domains by name, like for autocompleting a domain
+// being typed in.
+type DomainSearch struct {
+	// Query is the part of the name to look for. Case doesn't matter.
+	Query string
+	// Match is MatchPrefix, the default, or MatchSubstring.
+	Match string
+	// Limit is the most domains to return. Zero or less means no limit.
+	Limit int
+}
+
+// Validate returns an error if the search has no query or an unknown match.
+func (s DomainSearch) Validate() error {
+	if strings.TrimSpace(s.Query) == "" {
+		return fmt.Errorf("search query can't be empty")
+	}
+	if s.Match != "" && s.Match != MatchPrefix && s.Match != MatchSubstring {
+		return fmt.Errorf("match must be %s or %s", MatchPrefix, MatchSubstring)
+	}
+	return nil
+}
+
+// Matches returns true if the domain name matches the search.
+func (s DomainSearch) Matches(name string) bool {
+	name = strings.ToLower(name)
+	query := strings.ToLower(strings.TrimSpace(s.Query))
+	if s.Match == MatchSubstring {
+		return strings.Contains(name, query)
+	}
+	return strings.HasPrefix(name, query)
+}
+
+// likeEscaper escapes the characters with special meanings in LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Pattern returns a SQL LIKE pattern, escaped with backslashes, matching the
+// same names as the search.
+func (s DomainSearch) Pattern() string {
+	pattern := likeEscaper.Replace(strings.ToLower(strings.TrimSpace(s.Query))) + "%"
+	if s.Match == MatchSubstring {
+		pattern = "%" + pattern
+	}
+	return pattern
+}
@@ -0,0 +1,27 @@
+package models
+
+import "testing"
+
+func TestDomainSearch(t *testing.T) {
+	prefix := DomainSearch{Query: "Mail"}
+	if !prefix.Matches("mail.example.com") || prefix.Matches("gmail.com") {
+		t.Error("Expected prefix search to match names starting with the query")
+	}
+	substring := DomainSearch{Query: "mail", Match: MatchSubstring}
+	if !substring.Matches("gmail.com") || substring.Matches("example.com") {
+		t.Error("Expected substring search to match names containing the query")
+	}
+
+	if pattern := (DomainSearch{Query: "a_b%"}).Pattern(); pattern != `a\_b\%%` {
+		t.Errorf("Expected LIKE characters to be escaped, got %s", pattern)
+	}
+	if pattern := substring.Pattern(); pattern != "%mail%" {
+		t.Errorf("Expected substring pattern, got %s", pattern)
+	}
+
+	for _, invalid := range []DomainSearch{{Query: " "}, {Query: "mail", Match: "suffix"}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", invalid)
+		}
+	}
+}
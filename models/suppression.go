@@ -0,0 +1,54 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Reasons an email address is on the suppression list, so we never email it
+// again. They match the notification types AWS SES reports, lowercased.
+const (
+	SuppressBounce      = "bounce"
+	SuppressComplaint   = "complaint"
+	SuppressUnsubscribe = "unsubscribe"
+)
+
+// ParseSuppressionReason returns the suppression reason named by reason,
+// ignoring case.
+func ParseSuppressionReason(reason string) (string, error) {
+	switch reason = strings.ToLower(reason); reason {
+	case SuppressBounce, SuppressComplaint, SuppressUnsubscribe:
+		return reason, nil
+	}
+	return "", fmt.Errorf("reason must be one of %s, %s or %s, not %q",
+		SuppressBounce, SuppressComplaint, SuppressUnsubscribe, reason)
+}
+
+// UnsubscribeLink returns the query string of a link that lets address opt
+// out of our emails by adding itself to the suppression list. It doesn't
+// expire, so that old notifications can still be opted out of.
+func UnsubscribeLink(secret []byte, address string) string {
+	values := url.Values{"email": {address}}
+	values.Set("signature", unsubscribeSignature(secret, address))
+	return values.Encode()
+}
+
+// CheckUnsubscribeLink returns an error unless signature authorizes address
+// to opt out of our emails.
+func CheckUnsubscribeLink(secret []byte, address string, signature string) error {
+	expected := unsubscribeSignature(secret, address)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("this link isn't valid")
+	}
+	return nil
+}
+
+func unsubscribeSignature(secret []byte, address string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "unsubscribe\n%s", strings.ToLower(address))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
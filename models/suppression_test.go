@@ -0,0 +1,43 @@
+package models
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseSuppressionReason(t *testing.T) {
+	for given, want := range map[string]string{
+		"Bounce":      SuppressBounce,
+		"complaint":   SuppressComplaint,
+		"UNSUBSCRIBE": SuppressUnsubscribe,
+	} {
+		if got, err := ParseSuppressionReason(given); err != nil || got != want {
+			t.Errorf("ParseSuppressionReason(%q) = %q, %v; want %q", given, got, err, want)
+		}
+	}
+	if _, err := ParseSuppressionReason("delivery"); err == nil {
+		t.Error("Expected an unknown reason to be refused")
+	}
+}
+
+func TestUnsubscribeLink(t *testing.T) {
+	secret := []byte("secret")
+	values, err := url.ParseQuery(UnsubscribeLink(secret, "Contact@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values.Get("email") != "Contact@example.com" {
+		t.Errorf("Expected the link to name the address, got %v", values)
+	}
+	if err := CheckUnsubscribeLink(secret, "contact@example.com", values.Get("signature")); err != nil {
+		t.Errorf("Expected link to be valid, got %v", err)
+	}
+	for _, err := range []error{
+		CheckUnsubscribeLink([]byte("other"), "contact@example.com", values.Get("signature")),
+		CheckUnsubscribeLink(secret, "someone@example.com", values.Get("signature")),
+	} {
+		if err == nil {
+			t.Error("Expected link to be bound to the secret and address")
+		}
+	}
+}
@@ -27,13 +27,20 @@ func (t *Token) Redeem(store domainStore, tokens tokenStore) (ret string, userEr
 	if err != nil {
 		return domain, nil, err
 	}
-	domainOnList, err := GetDomain(store, domainData.Name)
+	return domain, nil, confirm(store, domainData.Name,
+		StateChange{Actor: ActorToken, Reason: "contact email validated"})
+}
+
+// confirm moves a domain's unconfirmed submission into testing, replacing any
+// earlier submission.
+func confirm(store domainStore, name string, change StateChange) error {
+	domainOnList, err := GetDomain(store, name)
 	if err != nil {
-		return domain, nil, err
+		return err
 	}
 	if domainOnList.State != StateUnconfirmed {
-		store.RemoveDomain(domainData.Name, domainOnList.State)
+		store.RemoveDomain(name, domainOnList.State,
+			StateChange{Actor: change.Actor, Reason: "replaced by a new submission"})
 	}
-	err = store.SetStatus(domainData.Name, StateTesting)
-	return domain, nil, err
+	return store.SetStatus(name, StateTesting, change)
 }
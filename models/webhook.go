@@ -0,0 +1,67 @@
+package models
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// MaxWebhooksPerDomain is the most webhooks that can be registered for a
+// single domain.
+const MaxWebhooksPerDomain = 5
+
+// Webhook is a callback URL that's notified whenever a domain changes state.
+type Webhook struct {
+	ID     int64  `json:"id"`
+	Domain string `json:"domain"`
+	URL    string `json:"url"`
+	// Owner is who registered the webhook: the domain's contact, identified
+	// by their API key, or a maintainer.
+	Owner   string    `json:"owner"`
+	Created time.Time `json:"created"`
+	// Secret signs the webhook's deliveries. It's only set in the response
+	// when the webhook is registered.
+	Secret string `json:"secret,omitempty"`
+}
+
+// Statuses of a webhook delivery.
+const (
+	DeliveryPending   = "pending"   // Not yet delivered, and will be retried.
+	DeliveryDelivered = "delivered" // The callback responded with a 2xx status.
+	DeliveryFailed    = "failed"    // Every attempt failed, and we gave up.
+)
+
+// WebhookDelivery is a notification of a domain's state change to one of its
+// webhooks, and the outcome of the latest attempt to deliver it.
+type WebhookDelivery struct {
+	ID        int64       `json:"id"`
+	WebhookID int64       `json:"webhook_id"`
+	Domain    string      `json:"domain"`
+	From      DomainState `json:"from"`
+	To        DomainState `json:"to"`
+	Reason    string      `json:"reason,omitempty"`
+	// Time is when the domain changed state.
+	Time     time.Time `json:"time"`
+	Status   string    `json:"status"`
+	Attempts int       `json:"attempts"`
+	// NextAttempt is when a pending delivery will next be attempted.
+	NextAttempt time.Time `json:"next_attempt"`
+	// ResponseCode is the HTTP status the callback responded to the latest
+	// attempt with, or zero if it couldn't be reached.
+	ResponseCode int    `json:"response_code,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// NewWebhookDelivery returns the pending delivery to hook of the change of its
+// domain from one state to another.
+func NewWebhookDelivery(hook Webhook, from DomainState, to DomainState, change StateChange, now time.Time) WebhookDelivery {
+	return WebhookDelivery{WebhookID: hook.ID, Domain: hook.Domain, From: from, To: to,
+		Reason: change.Reason, Time: now, Status: DeliveryPending, NextAttempt: now}
+}
+
+// NewWebhookSecret generates a new random webhook signing secret.
+func NewWebhookSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return fmt.Sprintf("whsec_%x", b)
+}
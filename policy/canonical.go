@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// CanonicalTimeFormat is the format of timestamps in canonical JSON: UTC, to
+// the second.
+const CanonicalTimeFormat = "2006-01-02T15:04:05Z"
+
+// MarshalCanonical encodes the list as canonical JSON, so that its encoding
+// only changes when its contents do, eg. for signing the list or diffing
+// mirrors. Object keys are sorted, MXs are sorted, timestamps use
+// CanonicalTimeFormat, empty policy fields are omitted, there's no
+// insignificant whitespace or HTML escaping, and the output ends with a
+// newline.
+func (l List) MarshalCanonical() ([]byte, error) {
+	doc := map[string]interface{}{
+		"timestamp":      l.Timestamp.UTC().Format(CanonicalTimeFormat),
+		"expires":        l.Expires.UTC().Format(CanonicalTimeFormat),
+		"version":        l.Version,
+		"author":         l.Author,
+		"policy-aliases": canonicalPolicies(l.PolicyAliases),
+		"policies":       canonicalPolicies(l.Policies),
+	}
+	// Maps are always encoded with sorted keys.
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func canonicalPolicies(policies map[string]TLSPolicy) map[string]map[string]interface{} {
+	canonical := make(map[string]map[string]interface{})
+	for name, policy := range policies {
+		canonical[name] = policy.canonical()
+	}
+	return canonical
+}
+
+func (p TLSPolicy) canonical() map[string]interface{} {
+	canonical := make(map[string]interface{})
+	if p.PolicyAlias != "" {
+		canonical["policy-alias"] = p.PolicyAlias
+	}
+	if p.Mode != "" {
+		canonical["mode"] = p.Mode
+	}
+	if len(p.MXs) > 0 {
+		mxs := append([]string{}, p.MXs...)
+		sort.Strings(mxs)
+		canonical["mxs"] = mxs
+	}
+	return canonical
+}
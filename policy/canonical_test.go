@@ -0,0 +1,39 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMarshalCanonical(t *testing.T) {
+	list := List{
+		Timestamp: time.Date(2019, time.March, 1, 12, 30, 15, 123456789, time.FixedZone("PST", -8*60*60)),
+		Expires:   time.Date(2019, time.March, 15, 0, 0, 0, 0, time.UTC),
+		Version:   "0.1",
+		Author:    "Electronic Frontier Foundation <https://eff.org>",
+		PolicyAliases: map[string]TLSPolicy{
+			"gmail": {Mode: "enforce", MXs: []string{".mail.google.com", ".gmail-smtp-in.l.google.com"}},
+		},
+		Policies: map[string]TLSPolicy{
+			"gmail.com": {PolicyAlias: "gmail"},
+			"eff.org":   {Mode: "testing", MXs: []string{"mx2.eff.org", "mx1.eff.org"}},
+		},
+	}
+	want := `{"author":"Electronic Frontier Foundation <https://eff.org>","expires":"2019-03-15T00:00:00Z",` +
+		`"policies":{"eff.org":{"mode":"testing","mxs":["mx1.eff.org","mx2.eff.org"]},"gmail.com":{"policy-alias":"gmail"}},` +
+		`"policy-aliases":{"gmail":{"mode":"enforce","mxs":[".gmail-smtp-in.l.google.com",".mail.google.com"]}},` +
+		`"timestamp":"2019-03-01T20:30:15Z","version":"0.1"}` + "\n"
+	got, err := list.MarshalCanonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("Expected canonical list\n%s\ngot\n%s", want, got)
+	}
+
+	// Reordering MXs shouldn't change the encoding.
+	list.Policies["eff.org"] = TLSPolicy{Mode: "testing", MXs: []string{"mx1.eff.org", "mx2.eff.org"}}
+	if again, _ := list.MarshalCanonical(); string(again) != want {
+		t.Errorf("Expected canonical encoding to be stable, got\n%s", again)
+	}
+}
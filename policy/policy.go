@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/EFForg/starttls-backend/slo"
 )
 
 // policyURL is the default URL from which to fetch the policy JSON.
@@ -35,6 +37,13 @@ func (l *List) Add(domain string, policy TLSPolicy) {
 	l.Policies[domain] = policy
 }
 
+// Remove removes domains' policies from the list.
+func (l *List) Remove(domains ...string) {
+	for _, domain := range domains {
+		delete(l.Policies, domain)
+	}
+}
+
 // get retrieves the TLSPolicy for a domain, and resolves
 // aliases if they exist.
 func (l *List) get(domain string) (TLSPolicy, error) {
@@ -51,6 +60,11 @@ func (l *List) get(domain string) (TLSPolicy, error) {
 	return policy, nil
 }
 
+// Get returns the TLSPolicy for a domain, resolving aliases.
+func (l *List) Get(domain string) (TLSPolicy, error) {
+	return l.get(domain)
+}
+
 // UpdatedList wraps a list that is updated from a remote
 // policyURL every hour. Safe for concurrent calls to `Get`.
 type UpdatedList struct {
@@ -123,6 +137,73 @@ func (p TLSPolicy) clone() TLSPolicy {
 // fetchListFn returns a new policy list. It can be used to update UpdatedList
 type fetchListFn func() (List, error)
 
+// WithdrawnFn returns the domains whose owners have withdrawn them from the
+// list, which are left out of the list even while upstream still has them.
+type WithdrawnFn func() ([]string, error)
+
+// withoutWithdrawn returns a fetchListFn that fetches the list and removes
+// the withdrawn domains from it. If they can't be retrieved, the list isn't
+// updated.
+func withoutWithdrawn(fetch fetchListFn, withdrawn WithdrawnFn) fetchListFn {
+	if withdrawn == nil {
+		return fetch
+	}
+	return func() (List, error) {
+		list, err := fetch()
+		if err != nil {
+			return list, err
+		}
+		domains, err := withdrawn()
+		if err != nil {
+			return List{}, fmt.Errorf("couldn't retrieve withdrawn domains: %v", err)
+		}
+		// Copy the policies, so the fetched list isn't changed.
+		policies := make(map[string]TLSPolicy, len(list.Policies))
+		for domain, policy := range list.Policies {
+			policies[domain] = policy
+		}
+		list.Policies = policies
+		list.Remove(domains...)
+		return list, nil
+	}
+}
+
+// ModesFn returns the modes of the policies of domains admitted via MTA-STS,
+// as last seen by the validators, which replace the modes of their entries
+// so they follow owners who move from testing to enforce.
+type ModesFn func() (map[string]string, error)
+
+// withModes returns a fetchListFn that fetches the list and sets the modes
+// modes returns on their domains' entries. Aliased entries and domains that
+// aren't on the list are left alone. If the modes can't be retrieved, the
+// list isn't updated.
+func withModes(fetch fetchListFn, modes ModesFn) fetchListFn {
+	if modes == nil {
+		return fetch
+	}
+	return func() (List, error) {
+		list, err := fetch()
+		if err != nil {
+			return list, err
+		}
+		tracked, err := modes()
+		if err != nil {
+			return List{}, fmt.Errorf("couldn't retrieve MTA-STS modes: %v", err)
+		}
+		// Copy the policies, so the fetched list isn't changed.
+		policies := make(map[string]TLSPolicy, len(list.Policies))
+		for domain, policy := range list.Policies {
+			if mode, ok := tracked[domain]; ok && policy.PolicyAlias == "" {
+				policy = policy.clone()
+				policy.Mode = mode
+			}
+			policies[domain] = policy
+		}
+		list.Policies = policies
+		return list, nil
+	}
+}
+
 // Retrieve and parse List from policyURL
 func fetchListHTTP() (List, error) {
 	resp, err := http.Get(policyURL)
@@ -141,7 +222,9 @@ func fetchListHTTP() (List, error) {
 
 // Get a new policy list and safely assign it the UpdatedList
 func (l *UpdatedList) update(fetch fetchListFn) {
+	start := time.Now()
 	newList, err := fetch()
+	slo.Record(slo.ListPublication, err == nil, time.Since(start))
 	if err != nil {
 		log.Printf("Error updating policy list: %s\n", err)
 	} else {
@@ -167,7 +250,32 @@ func makeUpdatedList(fetch fetchListFn, updateFrequency time.Duration) *UpdatedL
 	return &l
 }
 
-// MakeUpdatedList wraps makeUpdatedList to use FetchListHTTP by default to update policy list
-func MakeUpdatedList() *UpdatedList {
-	return makeUpdatedList(fetchListHTTP, time.Hour)
+// MakeUpdatedList wraps makeUpdatedList to use FetchListHTTP by default to
+// update policy list, leaving out the domains withdrawn returns, and setting
+// the modes modes returns.
+func MakeUpdatedList(withdrawn WithdrawnFn, modes ModesFn) *UpdatedList {
+	return makeUpdatedList(withModes(withoutWithdrawn(fetchListHTTP, withdrawn), modes), time.Hour)
+}
+
+// MakeFakeList returns a fixed policy list for domains on the checker's fake
+// network (see checker.FakeScenarios), instead of fetching the real one, so
+// that list validation can run without network access.
+func MakeFakeList() *UpdatedList {
+	return makeUpdatedList(fetchFakeList, time.Hour)
+}
+
+func fetchFakeList() (List, error) {
+	now := time.Now()
+	list := List{
+		Timestamp:     now,
+		Expires:       now.Add(7 * 24 * time.Hour),
+		Version:       "fake",
+		Author:        "Fake network",
+		PolicyAliases: make(map[string]TLSPolicy),
+		Policies:      make(map[string]TLSPolicy),
+	}
+	for _, domain := range []string{"example.com", "testing.example.com", "nostarttls.example.com"} {
+		list.Add(domain, TLSPolicy{Mode: "enforce", MXs: []string{"mx." + domain}})
+	}
+	return list, nil
 }
@@ -58,6 +58,41 @@ func TestFailedListUpdate(t *testing.T) {
 	}
 }
 
+func TestWithdrawnDomainsLeftOut(t *testing.T) {
+	withdrawn := func() ([]string, error) { return []string{"eff.org"}, nil }
+	list := makeUpdatedList(withoutWithdrawn(mockFetchHTTP, withdrawn), time.Hour)
+	if list.HasDomain("eff.org") {
+		t.Error("Expected a withdrawn domain to be left out of the list")
+	}
+	if _, ok := mockList.Policies["eff.org"]; !ok {
+		t.Error("Expected the fetched list not to be changed")
+	}
+	failing := func() ([]string, error) { return nil, fmt.Errorf("something went wrong") }
+	if _, err := withoutWithdrawn(mockFetchHTTP, failing)(); err == nil {
+		t.Error("Expected the list not to be updated if the withdrawn domains can't be retrieved")
+	}
+}
+
+func TestTrackedModesSet(t *testing.T) {
+	modes := func() (map[string]string, error) {
+		return map[string]string{"eff.org": "enforce", "example.com": "enforce"}, nil
+	}
+	list := makeUpdatedList(withModes(mockFetchHTTP, modes), time.Hour)
+	if policy, err := list.Get("eff.org"); err != nil || policy.Mode != "enforce" {
+		t.Errorf("Expected eff.org's entry to be in enforce mode, got %+v", policy)
+	}
+	if list.HasDomain("example.com") {
+		t.Error("Expected domains that aren't on the list not to be added")
+	}
+	if mockList.Policies["eff.org"].Mode != "testing" {
+		t.Error("Expected the fetched list not to be changed")
+	}
+	failing := func() (map[string]string, error) { return nil, fmt.Errorf("something went wrong") }
+	if _, err := withModes(mockFetchHTTP, failing)(); err == nil {
+		t.Error("Expected the list not to be updated if the modes can't be retrieved")
+	}
+}
+
 func TestListUpdate(t *testing.T) {
 	var updatedList = List{Policies: map[string]TLSPolicy{}}
 	list := makeUpdatedList(func() (List, error) { return updatedList, nil }, time.Second)
@@ -111,6 +146,14 @@ func TestHostnamesForDomain(t *testing.T) {
 	}
 }
 
+func TestFakeList(t *testing.T) {
+	list := MakeFakeList()
+	hostnames, err := list.HostnamesForDomain("nostarttls.example.com")
+	if err != nil || len(hostnames) != 1 || hostnames[0] != "mx.nostarttls.example.com" {
+		t.Errorf("Expected fake list to include fake domains, got %v (%v)", hostnames, err)
+	}
+}
+
 func TestCloneDoesntChangeOriginal(t *testing.T) {
 	var updatedList = List{
 		Version: "3",
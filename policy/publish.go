@@ -0,0 +1,288 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// publisherLease is the name of the lease held by the region that publishes
+// the list.
+const publisherLease = "list-publisher"
+
+// Defaults for Publisher settings.
+const (
+	defaultLeaseTTL        = 2 * time.Hour
+	defaultDivergenceGrace = 2 * time.Hour
+)
+
+// Publication is a version of the policy list, as canonical JSON, published
+// for every region to serve.
+type Publication struct {
+	// SHA256 is the hex SHA-256 hash of Body.
+	SHA256    string    `json:"sha256"`
+	Body      []byte    `json:"-"`
+	Published time.Time `json:"published"`
+}
+
+// NewPublication encodes l as canonical JSON for publication.
+func NewPublication(l List) (Publication, error) {
+	body, err := l.MarshalCanonical()
+	if err != nil {
+		return Publication{}, err
+	}
+	return Publication{SHA256: hashBytes(body), Body: body, Published: time.Now()}, nil
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// PublicationStore is storage shared by every region.
+type PublicationStore interface {
+	// AcquireLease takes or renews the named lease for holder, until ttl from
+	// now. Returns false if another holder's lease hasn't expired.
+	AcquireLease(name string, holder string, ttl time.Duration) (bool, error)
+	// PutPublication stores a new version of the list.
+	PutPublication(Publication) error
+	// GetPublication retrieves the latest version of the list, or
+	// sql.ErrNoRows if none has been published.
+	GetPublication() (Publication, error)
+}
+
+// Region is a deployment serving the policy list.
+type Region struct {
+	Name string
+	// URL the region serves the canonical list from, eg.
+	// https://region.example.org/api/list?canonical=true
+	URL string
+}
+
+// ParseRegions parses a comma-separated list of name=url pairs.
+func ParseRegions(s string) ([]Region, error) {
+	regions := []Region{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("region %q must look like name=url", pair)
+		}
+		regions = append(regions, Region{Name: parts[0], URL: parts[1]})
+	}
+	return regions, nil
+}
+
+// Divergence reports a region serving different list bytes from the
+// published version.
+type Divergence struct {
+	Region   Region
+	Expected string
+	// Served is the SHA-256 hash of what the region served, or empty if it
+	// couldn't be fetched.
+	Served string
+	Err    error
+	// Since is when the region was first seen to diverge.
+	Since time.Time
+}
+
+func (d Divergence) String() string {
+	if d.Err != nil {
+		return fmt.Sprintf("region %s (%s) hasn't served policy list %s since %s: %v",
+			d.Region.Name, d.Region.URL, d.Expected, d.Since.Format(time.RFC3339), d.Err)
+	}
+	return fmt.Sprintf("region %s (%s) has served policy list %s instead of %s since %s",
+		d.Region.Name, d.Region.URL, d.Served, d.Expected, d.Since.Format(time.RFC3339))
+}
+
+// Publisher coordinates publication of the policy list across regions. The
+// region holding the publisher lease fetches the list and publishes it to
+// the shared store, and every region serves the published version, so that
+// they all serve the same bytes. The leader then checks what each region
+// serves, and reports regions that diverge.
+type Publisher struct {
+	Store PublicationStore
+	// Holder uniquely identifies this instance in leader election.
+	Holder string
+	// Regions whose served lists the leader verifies.
+	Regions []Region
+	// LeaseTTL is how long the leader holds its lease without renewing it.
+	// It should be longer than the list's update frequency. Defaults to 2
+	// hours.
+	LeaseTTL time.Duration
+	// Grace is how long a region may serve a different list before it's
+	// reported, so regions have time to pick up new versions. Defaults to
+	// 2 hours.
+	Grace time.Duration
+	// OnDivergence is called once when a region has diverged for longer than
+	// Grace, until it serves the published list again.
+	OnDivergence func(Divergence)
+	// Withdrawn returns the domains left out of the published list, if set.
+	Withdrawn WithdrawnFn
+	// Modes returns the modes set on entries of the published list, if set.
+	Modes ModesFn
+
+	// fetch retrieves the list from upstream. Defaults to fetchListHTTP.
+	fetch  fetchListFn
+	client *http.Client
+
+	mu       sync.Mutex
+	diverged map[string]Divergence
+	reported map[string]bool
+}
+
+func (p *Publisher) leaseTTL() time.Duration {
+	if p.LeaseTTL > 0 {
+		return p.LeaseTTL
+	}
+	return defaultLeaseTTL
+}
+
+func (p *Publisher) grace() time.Duration {
+	if p.Grace > 0 {
+		return p.Grace
+	}
+	return defaultDivergenceGrace
+}
+
+func (p *Publisher) fetchUpstream() (List, error) {
+	fetch := p.fetch
+	if fetch == nil {
+		fetch = fetchListHTTP
+	}
+	return withModes(withoutWithdrawn(fetch, p.Withdrawn), p.Modes)()
+}
+
+func (p *Publisher) httpClient() *http.Client {
+	if p.client != nil {
+		return p.client
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// fetchList is a fetchListFn returning the published list. If this instance
+// is the leader, it publishes the upstream list first, and verifies the
+// regions in the background.
+func (p *Publisher) fetchList() (List, error) {
+	leader, err := p.Store.AcquireLease(publisherLease, p.Holder, p.leaseTTL())
+	if err != nil {
+		log.Printf("Couldn't acquire list publisher lease: %v", err)
+	}
+	if leader {
+		if err := p.publish(); err != nil {
+			log.Printf("Couldn't publish policy list: %v", err)
+		}
+	}
+	pub, err := p.Store.GetPublication()
+	if err == sql.ErrNoRows {
+		// Nothing's been published yet; serve upstream in the meantime.
+		return p.fetchUpstream()
+	}
+	if err != nil {
+		return List{}, err
+	}
+	if leader {
+		go p.Verify(pub)
+	}
+	var list List
+	err = json.Unmarshal(pub.Body, &list)
+	return list, err
+}
+
+// publish stores the upstream list, if it's changed since it was last
+// published.
+func (p *Publisher) publish() error {
+	list, err := p.fetchUpstream()
+	if err != nil {
+		return err
+	}
+	pub, err := NewPublication(list)
+	if err != nil {
+		return err
+	}
+	latest, err := p.Store.GetPublication()
+	if err == nil && latest.SHA256 == pub.SHA256 {
+		return nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	log.Printf("Publishing policy list %s", pub.SHA256)
+	return p.Store.PutPublication(pub)
+}
+
+// served returns the SHA-256 hash of the list a region serves.
+func (p *Publisher) served(region Region) (string, error) {
+	resp, err := p.httpClient().Get(region.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return hashBytes(body), nil
+}
+
+// Verify checks that every region serves pub byte-for-byte, and returns the
+// regions that have diverged for longer than the Publisher's Grace.
+// OnDivergence is called for regions that have newly passed it.
+func (p *Publisher) Verify(pub Publication) []Divergence {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.diverged == nil {
+		p.diverged = make(map[string]Divergence)
+		p.reported = make(map[string]bool)
+	}
+	now := time.Now()
+	divergences := []Divergence{}
+	for _, region := range p.Regions {
+		served, err := p.served(region)
+		if err == nil && served == pub.SHA256 {
+			if p.reported[region.Name] {
+				log.Printf("Region %s is serving policy list %s again", region.Name, pub.SHA256)
+			}
+			delete(p.diverged, region.Name)
+			delete(p.reported, region.Name)
+			continue
+		}
+		d := Divergence{Region: region, Expected: pub.SHA256, Served: served, Err: err, Since: now}
+		if previous, ok := p.diverged[region.Name]; ok {
+			d.Since = previous.Since
+		}
+		p.diverged[region.Name] = d
+		if now.Sub(d.Since) < p.grace() {
+			continue
+		}
+		divergences = append(divergences, d)
+		if !p.reported[region.Name] {
+			p.reported[region.Name] = true
+			log.Printf("Policy list divergence: %s", d)
+			if p.OnDivergence != nil {
+				p.OnDivergence(d)
+			}
+		}
+	}
+	return divergences
+}
+
+// MakePublishedList returns an UpdatedList serving the list published by p,
+// updated every hour.
+func MakePublishedList(p *Publisher) *UpdatedList {
+	return makeUpdatedList(p.fetchList, time.Hour)
+}
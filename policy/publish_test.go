@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockPublicationStore is a PublicationStore shared by mock regions.
+type mockPublicationStore struct {
+	mu           sync.Mutex
+	holder       string
+	expires      time.Time
+	publications []Publication
+}
+
+func (s *mockPublicationStore) AcquireLease(name string, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.holder != holder && time.Now().Before(s.expires) {
+		return false, nil
+	}
+	s.holder, s.expires = holder, time.Now().Add(ttl)
+	return true, nil
+}
+
+func (s *mockPublicationStore) PutPublication(p Publication) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publications = append(s.publications, p)
+	return nil
+}
+
+func (s *mockPublicationStore) GetPublication() (Publication, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.publications) == 0 {
+		return Publication{}, sql.ErrNoRows
+	}
+	return s.publications[len(s.publications)-1], nil
+}
+
+func TestOnlyLeaderPublishes(t *testing.T) {
+	store := &mockPublicationStore{}
+	fetches := 0
+	fetch := func() (List, error) {
+		fetches++
+		return mockList, nil
+	}
+	leader := Publisher{Store: store, Holder: "us", fetch: fetch}
+	follower := Publisher{Store: store, Holder: "eu", fetch: fetch}
+	for i := 0; i < 2; i++ {
+		for _, p := range []*Publisher{&leader, &follower} {
+			list, err := p.fetchList()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(list.Policies, mockList.Policies) {
+				t.Errorf("Expected %s to serve the published list, got %v", p.Holder, list)
+			}
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("Expected only the leader to fetch upstream, got %d fetches", fetches)
+	}
+	if len(store.publications) != 1 {
+		t.Errorf("Expected an unchanged list to be published once, got %d publications", len(store.publications))
+	}
+}
+
+func TestWithdrawnDomainsArentPublished(t *testing.T) {
+	store := &mockPublicationStore{}
+	withdrawn := func() ([]string, error) { return []string{"eff.org"}, nil }
+	leader := Publisher{Store: store, Holder: "us", fetch: mockFetchHTTP, Withdrawn: withdrawn}
+	follower := Publisher{Store: store, Holder: "eu", fetch: mockFetchHTTP}
+	leader.fetchList()
+	list, err := follower.fetchList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := list.Policies["eff.org"]; ok {
+		t.Errorf("Expected a withdrawn domain to be left out of the published list, got %v", list)
+	}
+}
+
+func TestFollowerServesUpstreamBeforeFirstPublication(t *testing.T) {
+	store := &mockPublicationStore{holder: "us", expires: time.Now().Add(time.Hour)}
+	follower := Publisher{Store: store, Holder: "eu", fetch: mockFetchHTTP}
+	list, err := follower.fetchList()
+	if err != nil || !reflect.DeepEqual(list.Policies, mockList.Policies) {
+		t.Errorf("Expected follower to serve upstream list, got %v, %v", list, err)
+	}
+	if len(store.publications) != 0 {
+		t.Errorf("Expected follower not to publish")
+	}
+}
+
+func TestVerifyReportsDivergence(t *testing.T) {
+	pub, err := NewPublication(mockList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servedBody := pub.Body
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/good" {
+			w.Write(pub.Body)
+			return
+		}
+		w.Write(servedBody)
+	}))
+	defer server.Close()
+	var reported []Divergence
+	p := Publisher{
+		Regions: []Region{
+			{Name: "good", URL: server.URL + "/good"},
+			{Name: "stale", URL: server.URL + "/stale"},
+		},
+		Grace:        time.Nanosecond,
+		OnDivergence: func(d Divergence) { reported = append(reported, d) },
+	}
+	if divergences := p.Verify(pub); len(divergences) != 0 {
+		t.Errorf("Expected no divergences, got %v", divergences)
+	}
+
+	mu.Lock()
+	servedBody = []byte(`{"policies":{}}`)
+	mu.Unlock()
+	// Divergences aren't reported until they've lasted longer than Grace.
+	if divergences := p.Verify(pub); len(divergences) != 0 {
+		t.Errorf("Expected new divergence to be within grace period, got %v", divergences)
+	}
+	time.Sleep(time.Millisecond)
+	for i := 0; i < 2; i++ {
+		divergences := p.Verify(pub)
+		if len(divergences) != 1 || divergences[0].Region.Name != "stale" {
+			t.Fatalf("Expected stale region to diverge, got %v", divergences)
+		}
+	}
+	if len(reported) != 1 || reported[0].Served != hashBytes(servedBody) || reported[0].Expected != pub.SHA256 {
+		t.Errorf("Expected divergence to be reported once, got %v", reported)
+	}
+
+	mu.Lock()
+	servedBody = pub.Body
+	mu.Unlock()
+	if divergences := p.Verify(pub); len(divergences) != 0 {
+		t.Errorf("Expected divergence to resolve, got %v", divergences)
+	}
+}
+
+func TestParseRegions(t *testing.T) {
+	regions, err := ParseRegions("us=https://us.example/api/list?canonical=true, eu=https://eu.example/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Region{
+		{Name: "us", URL: "https://us.example/api/list?canonical=true"},
+		{Name: "eu", URL: "https://eu.example/list"},
+	}
+	if !reflect.DeepEqual(regions, expected) {
+		t.Errorf("Expected %v, got %v", expected, regions)
+	}
+	if _, err := ParseRegions("https://us.example/list"); err == nil {
+		t.Error("Expected error parsing region without a name")
+	}
+}
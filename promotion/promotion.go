@@ -0,0 +1,289 @@
+// Package promotion performs the final verification of a queued domain before
+// it's promoted from testing to enforce on the policy list.
+package promotion
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// Vantage is somewhere a domain can be scanned from.
+type Vantage interface {
+	Name() string
+	Scan(domain string) (checker.DomainResult, error)
+}
+
+// LocalVantage scans domains from this server, always performing a full,
+// uncached scan.
+type LocalVantage struct{}
+
+// Name returns "local".
+func (LocalVantage) Name() string {
+	return "local"
+}
+
+// Scan performs a full scan of domain.
+func (LocalVantage) Scan(domain string) (checker.DomainResult, error) {
+	c := checker.Checker{}
+	return c.CheckDomain(domain, nil), nil
+}
+
+// RemoteVantage scans domains using another deployment of this backend, with
+// an API key with the "scan" scope. Scans are requested with verbose set, so
+// they're never served from the remote's cache.
+type RemoteVantage struct {
+	Label  string `json:"name"`
+	URL    string `json:"url"`
+	APIKey string `json:"api_key"`
+}
+
+// Name returns the vantage point's label.
+func (v RemoteVantage) Name() string {
+	return v.Label
+}
+
+var remoteClient = &http.Client{Timeout: 2 * time.Minute}
+
+// Scan requests a scan of domain from the remote deployment.
+func (v RemoteVantage) Scan(domain string) (checker.DomainResult, error) {
+	data := url.Values{"domain": {domain}, "verbose": {"true"}}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(v.URL, "/")+"/api/scan", strings.NewReader(data.Encode()))
+	if err != nil {
+		return checker.DomainResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if v.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+v.APIKey)
+	}
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return checker.DomainResult{}, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Message  string      `json:"message"`
+		Response models.Scan `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return checker.DomainResult{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return checker.DomainResult{}, fmt.Errorf("scan failed with %d: %s", resp.StatusCode, body.Message)
+	}
+	return body.Response.Data, nil
+}
+
+// LoadVantages reads a JSON array of remote vantage points from path.
+func LoadVantages(path string) ([]Vantage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var remotes []RemoteVantage
+	if err = json.Unmarshal(data, &remotes); err != nil {
+		return nil, err
+	}
+	vantages := []Vantage{}
+	for _, remote := range remotes {
+		if remote.Label == "" || remote.URL == "" {
+			return nil, fmt.Errorf("vantage points need a name and url, got %+v", remote)
+		}
+		vantages = append(vantages, remote)
+	}
+	return vantages, nil
+}
+
+// Defaults for Verifier.
+const (
+	DefaultMinVantages = 2
+	DefaultMinCertDays = 14
+)
+
+// Verifier checks that a domain is still correctly configured, from every
+// vantage point, before it's promoted.
+type Verifier struct {
+	Vantages []Vantage
+	// MinVantages is the number of vantage points which must successfully
+	// scan the domain. Defaults to DefaultMinVantages.
+	MinVantages int
+	// MinCertDays is how many days every mailserver's certificate must
+	// remain valid for. Defaults to DefaultMinCertDays.
+	MinCertDays int
+	// QueuePolicy decides how many weeks domains must spend in testing
+	// before they can be promoted.
+	QueuePolicy models.QueuePolicy
+	// RequireConfirmation leaves domains that pass verification in testing,
+	// awaiting their owners' confirmation, instead of promoting them.
+	RequireConfirmation bool
+	// now overrides time.Now in tests.
+	now func() time.Time
+}
+
+func (v *Verifier) minVantages() int {
+	if v.MinVantages <= 0 {
+		return DefaultMinVantages
+	}
+	return v.MinVantages
+}
+
+func (v *Verifier) minCertDays() int {
+	if v.MinCertDays <= 0 {
+		return DefaultMinCertDays
+	}
+	return v.MinCertDays
+}
+
+func (v *Verifier) timeNow() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+// Verify scans the domain from each vantage point, and returns the evidence.
+// The domain passes if it has been in testing for as long as QueuePolicy
+// requires, enough vantage points scanned it successfully, every scan is
+// consistent with its policy, the vantage points agree on its MTA-STS policy,
+// and no certificate expires within MinCertDays. Domains whose testing start
+// wasn't recorded aren't held back by QueuePolicy.
+func (v *Verifier) Verify(domain models.Domain) models.Promotion {
+	p := models.Promotion{
+		Domain:     domain.Name,
+		Time:       v.timeNow(),
+		Scans:      make(map[string]checker.DomainResult),
+		ScanErrors: make(map[string]string),
+	}
+	fail := func(format string, a ...interface{}) {
+		p.Failures = append(p.Failures, fmt.Sprintf(format, a...))
+	}
+	if !domain.TestingStart.IsZero() {
+		if ready := v.QueuePolicy.PromotableAt(domain); p.Time.Before(ready) {
+			fail("The domain must be queued for %d weeks (as a %s domain), until %s.",
+				v.QueuePolicy.TestingWeeks(domain), v.QueuePolicy.Class(domain.Name).Name,
+				ready.UTC().Format(time.RFC1123))
+		}
+	}
+	for _, vantage := range v.Vantages {
+		result, err := vantage.Scan(domain.Name)
+		if err != nil {
+			p.ScanErrors[vantage.Name()] = err.Error()
+			continue
+		}
+		p.Scans[vantage.Name()] = result
+	}
+	if len(p.Scans) < v.minVantages() {
+		fail("Only %d vantage points scanned the domain successfully, but %d are required.",
+			len(p.Scans), v.minVantages())
+	}
+	policies := make(map[string]string)
+	certDeadline := p.Time.Add(time.Duration(v.minCertDays()) * 24 * time.Hour)
+	for _, name := range sortedNames(p.Scans) {
+		result := p.Scans[name]
+		if result.Status != checker.DomainSuccess {
+			fail("%s: scan didn't succeed (status %d).", name, result.Status)
+		}
+		for _, hostname := range result.PreferredHostnames {
+			if !domain.MTASTS && !checker.PolicyMatches(hostname, domain.MXs) {
+				fail("%s: %s doesn't match the domain's MX patterns %v.", name, hostname, domain.MXs)
+			}
+			notAfter := result.HostnameResults[hostname].CertNotAfter
+			if notAfter == nil {
+				fail("%s: couldn't determine when %s's certificate expires.", name, hostname)
+			} else if notAfter.Before(certDeadline) {
+				fail("%s: %s's certificate expires on %s, within %d days.", name, hostname,
+					notAfter.UTC().Format(time.RFC1123), v.minCertDays())
+			}
+		}
+		if domain.MTASTS {
+			mtasts := result.MTASTSResult
+			if mtasts == nil || mtasts.Result == nil || mtasts.Status != checker.Success {
+				fail("%s: MTA-STS policy isn't valid.", name)
+				continue
+			}
+			mxs := append([]string{}, mtasts.MXs...)
+			sort.Strings(mxs)
+			policies[name] = fmt.Sprintf("id=%s mode=%s mx=%v", mtasts.ID, mtasts.Mode, mxs)
+		}
+	}
+	if distinct := distinctValues(policies); len(distinct) > 1 {
+		fail("Vantage points saw different MTA-STS policies: %v.", policies)
+	}
+	p.Promoted = len(p.Failures) == 0
+	return p
+}
+
+// Store records promotions, and updates the states of promoted domains.
+type Store interface {
+	GetDomain(string, models.DomainState) (models.Domain, error)
+	SetStatus(string, models.DomainState, models.StateChange) error
+	PutPromotion(models.Promotion) (models.Promotion, error)
+}
+
+// Promote verifies a domain that's queued in testing, records the evidence,
+// and moves the domain to enforce if it passed, unless RequireConfirmation is
+// set, in which case the recorded promotion awaits confirmation instead.
+func (v *Verifier) Promote(store Store, name string) (models.Promotion, error) {
+	domain, err := store.GetDomain(name, models.StateTesting)
+	if err != nil {
+		return models.Promotion{}, fmt.Errorf("%s isn't queued for the policy list: %v", name, err)
+	}
+	p := v.Verify(domain)
+	if p.Promoted && v.RequireConfirmation {
+		p.Promoted = false
+		p.AwaitingConfirmation = true
+	}
+	return record(store, p, models.StateChange{Actor: models.ActorPromotion, Reason: "passed verification"})
+}
+
+// Confirm verifies a domain whose owner confirmed its promotion again, since
+// its configuration may have changed while the promotion awaited
+// confirmation. The fresh evidence is recorded, and the domain is moved to
+// enforce if it still passes.
+func (v *Verifier) Confirm(store Store, name string) (models.Promotion, error) {
+	domain, err := store.GetDomain(name, models.StateTesting)
+	if err != nil {
+		return models.Promotion{}, fmt.Errorf("%s isn't queued for the policy list: %v", name, err)
+	}
+	p := v.Verify(domain)
+	p.Confirmed = p.Promoted
+	return record(store, p, models.StateChange{Actor: "owner", Reason: "promotion confirmed"})
+}
+
+// record stores p, and moves its domain to enforce with change if it was
+// promoted.
+func record(store Store, p models.Promotion, change models.StateChange) (models.Promotion, error) {
+	p, err := store.PutPromotion(p)
+	if err != nil {
+		return p, err
+	}
+	if p.Promoted {
+		err = store.SetStatus(p.Domain, models.StateEnforce, change)
+	}
+	return p, err
+}
+
+func sortedNames(scans map[string]checker.DomainResult) []string {
+	names := []string{}
+	for name := range scans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func distinctValues(m map[string]string) map[string]bool {
+	distinct := make(map[string]bool)
+	for _, value := range m {
+		distinct[value] = true
+	}
+	return distinct
+}
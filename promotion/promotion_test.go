@@ -0,0 +1,177 @@
+package promotion
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+)
+
+type mockVantage struct {
+	name   string
+	result checker.DomainResult
+	err    error
+}
+
+func (v mockVantage) Name() string { return v.name }
+
+func (v mockVantage) Scan(domain string) (checker.DomainResult, error) {
+	return v.result, v.err
+}
+
+var testNow = time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+// sampleResult returns a successful result whose certificate expires after
+// certDays, with the given MTA-STS policy ID.
+func sampleResult(certDays int, id string) checker.DomainResult {
+	result := checker.NewSampleDomainResult("example.com")
+	notAfter := testNow.Add(time.Duration(certDays) * 24 * time.Hour)
+	hostnameResult := result.HostnameResults["mx.example.com"]
+	hostnameResult.CertNotAfter = &notAfter
+	result.HostnameResults = map[string]checker.HostnameResult{"mx.example.com": hostnameResult}
+	mtasts := *result.MTASTSResult
+	mtasts.ID = id
+	result.MTASTSResult = &mtasts
+	return result
+}
+
+func TestVerify(t *testing.T) {
+	domain := models.Domain{Name: "example.com", MXs: []string{".example.com"}, MTASTS: true}
+	tests := []struct {
+		name     string
+		vantages []Vantage
+		passes   bool
+		failure  string
+	}{
+		{"consistent", []Vantage{
+			mockVantage{name: "a", result: sampleResult(90, "1")},
+			mockVantage{name: "b", result: sampleResult(90, "1")},
+		}, true, ""},
+		{"one vantage", []Vantage{
+			mockVantage{name: "a", result: sampleResult(90, "1")},
+			mockVantage{name: "b", err: errors.New("timeout")},
+		}, false, "vantage points"},
+		{"expiring certificate", []Vantage{
+			mockVantage{name: "a", result: sampleResult(90, "1")},
+			mockVantage{name: "b", result: sampleResult(3, "1")},
+		}, false, "certificate expires"},
+		{"inconsistent policy", []Vantage{
+			mockVantage{name: "a", result: sampleResult(90, "1")},
+			mockVantage{name: "b", result: sampleResult(90, "2")},
+		}, false, "different MTA-STS policies"},
+	}
+	for _, test := range tests {
+		v := Verifier{Vantages: test.vantages, now: func() time.Time { return testNow }}
+		p := v.Verify(domain)
+		if p.Promoted != test.passes {
+			t.Errorf("%s: expected passing to be %v, got failures %v", test.name, test.passes, p.Failures)
+		}
+		if test.failure != "" && !strings.Contains(strings.Join(p.Failures, " "), test.failure) {
+			t.Errorf("%s: expected a failure about %q, got %v", test.name, test.failure, p.Failures)
+		}
+	}
+}
+
+type mockStore struct {
+	domain     models.Domain
+	state      models.DomainState
+	promotions []models.Promotion
+}
+
+func (s *mockStore) GetDomain(name string, state models.DomainState) (models.Domain, error) {
+	if name != s.domain.Name || state != s.state {
+		return models.Domain{}, errors.New("not found")
+	}
+	return s.domain, nil
+}
+
+func (s *mockStore) SetStatus(name string, state models.DomainState, _ models.StateChange) error {
+	s.state = state
+	return nil
+}
+
+func (s *mockStore) PutPromotion(p models.Promotion) (models.Promotion, error) {
+	s.promotions = append(s.promotions, p)
+	return p, nil
+}
+
+func TestPromote(t *testing.T) {
+	store := &mockStore{
+		domain: models.Domain{Name: "example.com", MTASTS: true},
+		state:  models.StateTesting,
+	}
+	failing := Verifier{Vantages: []Vantage{mockVantage{name: "a", result: sampleResult(90, "1")}},
+		now: func() time.Time { return testNow }}
+	p, err := failing.Promote(store, "example.com")
+	if err != nil || p.Promoted || store.state != models.StateTesting || len(store.promotions) != 1 {
+		t.Errorf("Expected failed verification to be recorded without promoting, got %+v", p)
+	}
+
+	passing := Verifier{MinVantages: 1, Vantages: failing.Vantages, now: failing.now}
+	p, err = passing.Promote(store, "example.com")
+	if err != nil || !p.Promoted || store.state != models.StateEnforce || len(store.promotions) != 2 {
+		t.Errorf("Expected domain to be promoted, got %+v", p)
+	}
+
+	if _, err = passing.Promote(store, "example.com"); err == nil {
+		t.Error("Expected a domain that isn't queued not to be promoted")
+	}
+}
+
+func TestPromoteRequiresConfirmation(t *testing.T) {
+	store := &mockStore{
+		domain: models.Domain{Name: "example.com", MTASTS: true},
+		state:  models.StateTesting,
+	}
+	v := Verifier{MinVantages: 1, RequireConfirmation: true, now: func() time.Time { return testNow },
+		Vantages: []Vantage{mockVantage{name: "a", result: sampleResult(90, "1")}}}
+	p, err := v.Promote(store, "example.com")
+	if err != nil || p.Promoted || !p.AwaitingConfirmation || store.state != models.StateTesting {
+		t.Errorf("Expected passing domain to await confirmation in testing, got %+v", p)
+	}
+}
+
+func TestConfirmVerifiesAgain(t *testing.T) {
+	store := &mockStore{
+		domain: models.Domain{Name: "example.com", MTASTS: true},
+		state:  models.StateTesting,
+	}
+	expiring := Verifier{MinVantages: 1, now: func() time.Time { return testNow },
+		Vantages: []Vantage{mockVantage{name: "a", result: sampleResult(3, "1")}}}
+	p, err := expiring.Confirm(store, "example.com")
+	if err != nil || p.Promoted || p.Confirmed || store.state != models.StateTesting || len(store.promotions) != 1 {
+		t.Errorf("Expected confirmed domain that no longer passes to stay in testing, got %+v", p)
+	}
+
+	passing := Verifier{MinVantages: 1, now: expiring.now,
+		Vantages: []Vantage{mockVantage{name: "a", result: sampleResult(90, "1")}}}
+	p, err = passing.Confirm(store, "example.com")
+	if err != nil || !p.Promoted || !p.Confirmed || store.state != models.StateEnforce || len(store.promotions) != 2 {
+		t.Errorf("Expected confirmed domain to be promoted, got %+v", p)
+	}
+}
+
+func TestVerifyQueueWeeks(t *testing.T) {
+	vantages := []Vantage{mockVantage{name: "a", result: sampleResult(90, "1")}}
+	policy := models.QueuePolicy{Classes: []models.QueueClass{
+		{Name: "partner", Domains: []string{"example.com"}, MinWeeks: 1, MaxWeeks: 8},
+	}}
+	v := Verifier{MinVantages: 1, Vantages: vantages, QueuePolicy: policy, now: func() time.Time { return testNow }}
+	domain := models.Domain{Name: "example.com", MTASTS: true, QueueWeeks: 2,
+		TestingStart: testNow.Add(-10 * 24 * time.Hour)}
+	if p := v.Verify(domain); p.Promoted || !strings.Contains(strings.Join(p.Failures, " "), "2 weeks") {
+		t.Errorf("Expected domain queued for 2 weeks not to be promoted after 10 days, got %v", p.Failures)
+	}
+	domain.TestingStart = testNow.Add(-15 * 24 * time.Hour)
+	if p := v.Verify(domain); !p.Promoted {
+		t.Errorf("Expected domain to be promoted once its queue weeks passed, got %v", p.Failures)
+	}
+	// Other domains wait the default minimum, however long they were queued for.
+	domain.Name = "example.org"
+	if p := v.Verify(domain); p.Promoted {
+		t.Errorf("Expected default class minimum to apply")
+	}
+}
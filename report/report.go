@@ -0,0 +1,348 @@
+// Package report compiles the weekly and monthly operations reports for
+// maintainers: how the policy list grew, how the validators are doing, why
+// scans failed, what maintainers did about abuse, and how the API was used.
+// Reports are stored, rendered as Markdown and HTML, and emailed.
+package report
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/validator"
+	raven "github.com/getsentry/raven-go"
+)
+
+// Period is how much time a report covers.
+type Period string
+
+// Report periods. Monthly reports cover a calendar month, in UTC.
+const (
+	Weekly  Period = "weekly"
+	Monthly Period = "monthly"
+)
+
+// Start returns when the report for the period ending at end starts.
+func (p Period) Start(end time.Time) time.Time {
+	if p == Monthly {
+		return end.AddDate(0, -1, 0)
+	}
+	return end.Add(-7 * 24 * time.Hour)
+}
+
+// due returns the end of the latest period that has finished by now.
+func (p Period) due(now time.Time) time.Time {
+	if p == Monthly {
+		now = now.UTC()
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return now
+}
+
+// Lease is the name of the lease held by the server generating the period's
+// report, so that only one of several servers does.
+func (p Period) Lease() string {
+	return string(p) + "-report"
+}
+
+// Store is where reports' statistics are read from, and reports are kept.
+type Store interface {
+	GetDomains(models.DomainState) ([]models.Domain, error)
+	// GetScanCounts counts the scans performed since a time, by source and
+	// status.
+	GetScanCounts(time.Time) ([]models.ScanCount, error)
+	// GetAuditLogSince retrieves the audit log entries since a time.
+	GetAuditLogSince(time.Time) ([]models.AuditEntry, error)
+	// GetAPIUsage totals the use of API keys since a time.
+	GetAPIUsage(time.Time) (models.APIUsage, error)
+	// GetValidatorRun retrieves the summary of a validator's most recent
+	// run, or sql.ErrNoRows if it hasn't finished one.
+	GetValidatorRun(name string) (validator.RunSummary, error)
+	PutReport(Report) (Report, error)
+	// GetLatestReport retrieves the report for the most recent period of
+	// a kind, or of any kind if it's empty.
+	GetLatestReport(Period) (Report, error)
+}
+
+// ListGrowth counts the domains in each state of the policy list, and those
+// that entered the list or queue during the report's period.
+type ListGrowth struct {
+	Listed      int      `json:"listed"`
+	Queued      int      `json:"queued"`
+	Pending     int      `json:"pending"`
+	Failed      int      `json:"failed"`
+	NewlyListed []string `json:"newly_listed"`
+	NewlyQueued []string `json:"newly_queued"`
+}
+
+// ValidatorHealth summarizes a validator's most recent run.
+type ValidatorHealth struct {
+	Name string `json:"name"`
+	// Ran is false if the validator has never finished a run.
+	Ran         bool      `json:"ran"`
+	Time        time.Time `json:"time"`
+	Attempted   int       `json:"attempted"`
+	Failed      int       `json:"failed"`
+	FailureRate float64   `json:"failure_rate"`
+}
+
+// Count is the number of times something happened.
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Report is a single period's operations report.
+type Report struct {
+	ID         int64             `json:"id"`
+	Period     Period            `json:"period"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	List       ListGrowth        `json:"list"`
+	Validators []ValidatorHealth `json:"validators"`
+	// Prune lists the MX patterns the validators suggest pruning from
+	// listed policies, because they no longer match any live mailserver.
+	Prune []validator.PruneSuggestion `json:"prune,omitempty"`
+	// FailureCodes counts the scans that didn't succeed by status, most
+	// common first.
+	FailureCodes []Count `json:"failure_codes"`
+	// Actions counts audit log entries, like moderation decisions, by action.
+	Actions []Count `json:"actions"`
+	// Scans counts the scans performed by source, eg. api or validator.
+	Scans    []Count         `json:"scans"`
+	APIUsage models.APIUsage `json:"api_usage"`
+	Markdown string          `json:"markdown"`
+	HTML     string          `json:"html"`
+}
+
+// Generator compiles reports.
+type Generator struct {
+	Store Store
+	// Period is how much time each report covers. Defaults to Weekly.
+	Period Period
+	// Validators are the names of the validators whose health is reported.
+	Validators []string
+	// Send delivers rendered reports, eg. by email. Optional.
+	Send func(Report) error
+}
+
+// Generate compiles, renders and stores the report for the period ending at
+// end, and sends it.
+func (g *Generator) Generate(end time.Time) (Report, error) {
+	r, err := g.compile(end)
+	if err != nil {
+		return r, err
+	}
+	if err = r.render(); err != nil {
+		return r, err
+	}
+	if r, err = g.Store.PutReport(r); err != nil {
+		return r, err
+	}
+	if g.Send != nil {
+		err = g.Send(r)
+	}
+	return r, err
+}
+
+func (g *Generator) period() Period {
+	if g.Period == "" {
+		return Weekly
+	}
+	return g.Period
+}
+
+func (g *Generator) compile(end time.Time) (Report, error) {
+	period := g.period()
+	r := Report{Period: period, Start: period.Start(end), End: end}
+	entries, err := g.Store.GetAuditLogSince(r.Start)
+	if err != nil {
+		return r, err
+	}
+	// Entries logged after the end of the period belong to the next report.
+	for i, entry := range entries {
+		if entry.Time.After(end) {
+			entries = entries[:i]
+			break
+		}
+	}
+	if r.List, err = g.listGrowth(entries); err != nil {
+		return r, err
+	}
+	for _, name := range g.Validators {
+		health := ValidatorHealth{Name: name}
+		run, err := g.Store.GetValidatorRun(name)
+		if err == nil {
+			health = ValidatorHealth{Name: name, Ran: true, Time: run.Time, Attempted: run.Attempted,
+				Failed: run.Failed, FailureRate: run.FailureRate()}
+			r.Prune = append(r.Prune, run.Prune...)
+		} else if err != sql.ErrNoRows {
+			return r, err
+		}
+		r.Validators = append(r.Validators, health)
+	}
+	scanCounts, err := g.Store.GetScanCounts(r.Start)
+	if err != nil {
+		return r, err
+	}
+	failures := make(map[string]int)
+	sources := make(map[string]int)
+	for _, c := range scanCounts {
+		sources[string(c.Source)] += c.Count
+		if c.Status != 0 {
+			failures[c.Status.Text()] += c.Count
+		}
+	}
+	r.FailureCodes = sortedCounts(failures)
+	r.Scans = sortedCounts(sources)
+	actions := make(map[string]int)
+	for _, entry := range entries {
+		actions[entry.Action]++
+	}
+	r.Actions = sortedCounts(actions)
+	r.APIUsage, err = g.Store.GetAPIUsage(r.Start)
+	return r, err
+}
+
+// listGrowth counts the domains in each state, and lists the domains whose
+// state changes in entries put them on the list or in the queue.
+func (g *Generator) listGrowth(entries []models.AuditEntry) (ListGrowth, error) {
+	var growth ListGrowth
+	listed, err := g.Store.GetDomains(models.StateEnforce)
+	if err != nil {
+		return growth, err
+	}
+	queued, err := g.Store.GetDomains(models.StateTesting)
+	if err != nil {
+		return growth, err
+	}
+	growth.Listed, growth.Queued = len(listed), len(queued)
+	newlyListed := make(map[string]bool)
+	newlyQueued := make(map[string]bool)
+	for _, entry := range entries {
+		_, to, ok := models.ParseStateChange(entry)
+		if !ok {
+			continue
+		}
+		switch to {
+		case models.StateEnforce:
+			newlyListed[entry.Subject] = true
+		case models.StateTesting:
+			newlyQueued[entry.Subject] = true
+		}
+	}
+	growth.NewlyListed = sortedNames(newlyListed)
+	growth.NewlyQueued = sortedNames(newlyQueued)
+	for _, state := range []models.DomainState{models.StateUnconfirmed, models.StateFlagged} {
+		pending, err := g.Store.GetDomains(state)
+		if err != nil {
+			return growth, err
+		}
+		growth.Pending += len(pending)
+	}
+	failed, err := g.Store.GetDomains(models.StateFailed)
+	growth.Failed = len(failed)
+	return growth, err
+}
+
+func sortedNames(m map[string]bool) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedCounts lists counts, most common first.
+func sortedCounts(m map[string]int) []Count {
+	counts := []Count{}
+	for name, count := range m {
+		counts = append(counts, Count{Name: name, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts
+}
+
+// Subject is the subject line for emails of the report.
+func (r Report) Subject() string {
+	if r.Period == Monthly {
+		return "[STARTTLS Everywhere] Operations report for " + r.Start.UTC().Format("January 2006")
+	}
+	return "[STARTTLS Everywhere] Operations report for the week ending " + r.End.UTC().Format("2006-01-02")
+}
+
+var templateFuncs = map[string]interface{}{
+	"date":    func(t time.Time) string { return t.UTC().Format("2006-01-02") },
+	"time":    func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+	"join":    strings.Join,
+	"percent": func(f float64) string { return fmt.Sprintf("%.1f%%", f) },
+}
+
+func (r *Report) render() error {
+	var md bytes.Buffer
+	if err := markdownTemplate.Execute(&md, r); err != nil {
+		return err
+	}
+	r.Markdown = md.String()
+	var html bytes.Buffer
+	if err := htmlTemplate.Execute(&html, r); err != nil {
+		return err
+	}
+	r.HTML = html.String()
+	return nil
+}
+
+// Leaser grants leases. See db.Database.
+type Leaser interface {
+	AcquireLease(name string, holder string, ttl time.Duration) (bool, error)
+}
+
+// GenerateRegularly checks every hour whether a period has finished since
+// the latest stored report, and if so, generates its report while holding
+// the period's lease, so that only one of several servers does. Errors are
+// logged.
+func (g *Generator) GenerateRegularly(leases Leaser, holder string) {
+	for range time.Tick(time.Hour) {
+		if err := g.generateDue(leases, holder, time.Now()); err != nil {
+			log.Printf("Couldn't generate %s operations report: %v", g.period(), err)
+			raven.CaptureError(err, nil)
+		}
+	}
+}
+
+// generateDue generates the report for the latest period that has finished
+// by now, unless it's been generated already or another server is doing so.
+func (g *Generator) generateDue(leases Leaser, holder string, now time.Time) error {
+	period := g.period()
+	end := period.due(now)
+	latest, err := g.Store.GetLatestReport(period)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && period.Start(end).Before(latest.End) {
+		return nil
+	}
+	ok, err := leases.AcquireLease(period.Lease(), holder, time.Hour)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = g.Generate(end)
+	return err
+}
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("report").Funcs(templateFuncs).Parse(htmlSource))
+
+var markdownTemplate = template.Must(template.New("report").Funcs(templateFuncs).Parse(markdownSource))
@@ -0,0 +1,211 @@
+package report
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/validator"
+)
+
+type mockStore struct {
+	domains map[models.DomainState][]models.Domain
+	audit   []models.AuditEntry
+	runs    map[string]validator.RunSummary
+	reports []Report
+}
+
+func (m *mockStore) GetDomains(state models.DomainState) ([]models.Domain, error) {
+	return m.domains[state], nil
+}
+
+func (m *mockStore) GetScanCounts(time.Time) ([]models.ScanCount, error) {
+	return []models.ScanCount{
+		{Source: models.SourceAPI, Status: checker.DomainSuccess, Count: 10},
+		{Source: models.SourceAPI, Status: checker.DomainNoSTARTTLSFailure, Count: 3},
+		{Source: models.SourceValidator, Status: checker.DomainNoSTARTTLSFailure, Count: 2},
+		{Source: models.SourceValidator, Status: checker.DomainCouldNotConnect, Count: 1},
+	}, nil
+}
+
+func (m *mockStore) GetAuditLogSince(since time.Time) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	for _, entry := range m.audit {
+		if !entry.Time.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *mockStore) GetValidatorRun(name string) (validator.RunSummary, error) {
+	run, ok := m.runs[name]
+	if !ok {
+		return run, sql.ErrNoRows
+	}
+	return run, nil
+}
+
+func (m *mockStore) GetAPIUsage(time.Time) (models.APIUsage, error) {
+	return models.APIUsage{Keys: 2, Requests: 40, Scans: 13}, nil
+}
+
+func (m *mockStore) PutReport(r Report) (Report, error) {
+	r.ID = int64(len(m.reports) + 1)
+	m.reports = append(m.reports, r)
+	return r, nil
+}
+
+func (m *mockStore) GetLatestReport(period Period) (Report, error) {
+	for i := len(m.reports) - 1; i >= 0; i-- {
+		if period == "" || m.reports[i].Period == period {
+			return m.reports[i], nil
+		}
+	}
+	return Report{}, sql.ErrNoRows
+}
+
+type mockLeaser struct{ acquired int }
+
+func (m *mockLeaser) AcquireLease(string, string, time.Duration) (bool, error) {
+	m.acquired++
+	return true, nil
+}
+
+func stateChange(domain string, from models.DomainState, to models.DomainState, at time.Time) models.AuditEntry {
+	entry := models.StateChange{Actor: "admin"}.AuditEntry(domain, from, to)
+	entry.Time = at
+	return entry
+}
+
+func TestGenerate(t *testing.T) {
+	end := time.Date(2019, time.March, 8, 0, 0, 0, 0, time.UTC)
+	store := &mockStore{domains: map[models.DomainState][]models.Domain{
+		models.StateEnforce: {
+			// old.example.com was updated recently, but listed long ago.
+			{Name: "old.example.com", LastUpdated: end.Add(-time.Hour)},
+			{Name: "new.example.com", LastUpdated: end.Add(-time.Hour)},
+		},
+		models.StateTesting:     {{Name: "queued.example.com", TestingStart: end.Add(-24 * time.Hour)}},
+		models.StateUnconfirmed: {{Name: "a.example.com"}},
+		models.StateFlagged:     {{Name: "b.example.com"}},
+	}, audit: []models.AuditEntry{
+		stateChange("old.example.com", models.StateTesting, models.StateEnforce, end.Add(-30*24*time.Hour)),
+		stateChange("new.example.com", models.StateTesting, models.StateEnforce, end.Add(-time.Hour)),
+		stateChange("queued.example.com", models.StateUnconfirmed, models.StateTesting, end.Add(-24*time.Hour)),
+		{Action: "moderation.flag", Time: end.Add(-time.Hour)},
+		{Action: "moderation.reject", Time: end.Add(-time.Hour)},
+		{Action: "moderation.flag", Time: end.Add(-time.Hour)},
+	}}
+	var sent []Report
+	g := Generator{Store: store, Validators: []string{"Report test validator"},
+		Send: func(r Report) error { sent = append(sent, r); return nil }}
+	r, err := g.Generate(end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != 1 || len(store.reports) != 1 || len(sent) != 1 {
+		t.Errorf("Expected report to be stored and sent once")
+	}
+	if r.Period != Weekly || !r.Start.Equal(end.Add(-7*24*time.Hour)) {
+		t.Errorf("Expected report to start a week before %v, got %v", end, r.Start)
+	}
+	list := r.List
+	if list.Listed != 2 || list.Queued != 1 || list.Pending != 2 || list.Failed != 0 {
+		t.Errorf("Unexpected list counts %+v", list)
+	}
+	if strings.Join(list.NewlyListed, ",") != "new.example.com" || strings.Join(list.NewlyQueued, ",") != "queued.example.com" {
+		t.Errorf("Unexpected list growth %+v", list)
+	}
+	if len(r.Validators) != 1 || r.Validators[0].Ran {
+		t.Errorf("Expected validator that hasn't run, got %+v", r.Validators)
+	}
+	expectCounts(t, "failure codes", r.FailureCodes, []Count{{"no_starttls", 5}, {"could_not_connect", 1}})
+	expectCounts(t, "actions", r.Actions, []Count{{"domain.state", 2}, {"moderation.flag", 2}, {"moderation.reject", 1}})
+	expectCounts(t, "scans", r.Scans, []Count{{"api", 13}, {"validator", 3}})
+	for _, rendered := range []string{r.Markdown, r.HTML} {
+		for _, want := range []string{"2019-03-01 to 2019-03-08", "new.example.com", "no_starttls: 5",
+			"Report test validator: hasn't run", "2 API keys made 40 requests and 13 scans"} {
+			if !strings.Contains(rendered, want) {
+				t.Errorf("Expected rendered report to contain %q:\n%s", want, rendered)
+			}
+		}
+	}
+}
+
+func TestValidatorHealth(t *testing.T) {
+	ran := time.Date(2019, time.March, 7, 0, 0, 0, 0, time.UTC)
+	store := &mockStore{runs: map[string]validator.RunSummary{
+		"list": {Time: ran, Attempted: 10, Failed: 1},
+	}}
+	g := Generator{Store: store, Validators: []string{"list", "queued"}}
+	r, err := g.Generate(ran.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Validators) != 2 || !r.Validators[0].Ran || r.Validators[0].Failed != 1 ||
+		r.Validators[0].FailureRate != 10 || r.Validators[1].Ran {
+		t.Errorf("Expected the stored validator runs to be reported, got %+v", r.Validators)
+	}
+}
+
+func TestGenerateDueMonthly(t *testing.T) {
+	store := &mockStore{}
+	leases := &mockLeaser{}
+	g := Generator{Store: store, Period: Monthly}
+	now := time.Date(2019, time.April, 3, 12, 0, 0, 0, time.UTC)
+	if err := g.generateDue(leases, "test", now); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.reports) != 1 {
+		t.Fatalf("Expected March's report to be generated, got %d reports", len(store.reports))
+	}
+	r := store.reports[0]
+	if r.Period != Monthly || !r.Start.Equal(time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)) ||
+		!r.End.Equal(time.Date(2019, time.April, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the report to cover March, got %v to %v", r.Start, r.End)
+	}
+	if !strings.Contains(r.Subject(), "March 2019") {
+		t.Errorf("Expected the subject to name the month, got %q", r.Subject())
+	}
+	g.generateDue(leases, "test", now.Add(24*time.Hour))
+	if len(store.reports) != 1 || leases.acquired != 1 {
+		t.Errorf("Expected March's report to be generated once, got %d reports", len(store.reports))
+	}
+	g.generateDue(leases, "test", time.Date(2019, time.May, 1, 1, 0, 0, 0, time.UTC))
+	if len(store.reports) != 2 {
+		t.Errorf("Expected April's report to be generated, got %d reports", len(store.reports))
+	}
+}
+
+func expectCounts(t *testing.T, name string, got []Count, want []Count) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("Expected %s %v, got %v", name, want, got)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %s %v, got %v", name, want, got)
+			return
+		}
+	}
+}
+
+func TestRenderPruneSuggestions(t *testing.T) {
+	since := time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)
+	r := Report{Prune: []validator.PruneSuggestion{
+		{Domain: "example.com", Pattern: ".old.example.com", Cycles: 14, Since: since},
+	}}
+	if err := r.render(); err != nil {
+		t.Fatal(err)
+	}
+	for _, rendered := range []string{r.Markdown, r.HTML} {
+		if !strings.Contains(rendered, "example.com: .old.example.com (for 14 runs, since 2019-03-01)") {
+			t.Errorf("Expected rendered report to list the pattern to prune:\n%s", rendered)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package report
+
+const markdownSource = `# Operations report: {{date .Start}} to {{date .End}}
+
+## Policy list
+
+| State | Domains |
+|---|---|
+| Listed | {{.List.Listed}} |
+| Queued | {{.List.Queued}} |
+| Awaiting confirmation or review | {{.List.Pending}} |
+| Failed | {{.List.Failed}} |
+{{if .List.NewlyListed}}
+Newly listed: {{join .List.NewlyListed ", "}}
+{{end}}{{if .List.NewlyQueued}}
+Newly queued: {{join .List.NewlyQueued ", "}}
+{{end}}
+## Validators
+{{range .Validators}}
+* {{.Name}}: {{if .Ran}}{{.Failed}} of {{.Attempted}} domains failed ({{percent .FailureRate}}) on {{time .Time}}{{else}}hasn't run{{end}}{{else}}
+No validators are running.{{end}}
+{{if .Prune}}
+MX patterns to prune, which haven't matched a live mailserver:
+{{range .Prune}}
+* {{.Domain}}: {{.Pattern}} (for {{.Cycles}} runs, since {{date .Since}}){{end}}
+{{end}}
+## Top failure codes
+{{range .FailureCodes}}
+* {{.Name}}: {{.Count}}{{else}}
+No scans failed.{{end}}
+
+## Maintainer actions
+{{range .Actions}}
+* {{.Name}}: {{.Count}}{{else}}
+No actions were logged.{{end}}
+
+## API usage
+
+{{.APIUsage.Keys}} API keys made {{.APIUsage.Requests}} requests and {{.APIUsage.Scans}} scans.
+{{range .Scans}}
+* {{.Name}} scans: {{.Count}}{{end}}
+`
+
+const htmlSource = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Operations report: {{date .Start}} to {{date .End}}</title></head>
+<body>
+<h1>Operations report: {{date .Start}} to {{date .End}}</h1>
+
+<h2>Policy list</h2>
+<table>
+<tr><th>State</th><th>Domains</th></tr>
+<tr><td>Listed</td><td>{{.List.Listed}}</td></tr>
+<tr><td>Queued</td><td>{{.List.Queued}}</td></tr>
+<tr><td>Awaiting confirmation or review</td><td>{{.List.Pending}}</td></tr>
+<tr><td>Failed</td><td>{{.List.Failed}}</td></tr>
+</table>
+{{if .List.NewlyListed}}<p>Newly listed: {{join .List.NewlyListed ", "}}</p>{{end}}
+{{if .List.NewlyQueued}}<p>Newly queued: {{join .List.NewlyQueued ", "}}</p>{{end}}
+
+<h2>Validators</h2>
+<ul>
+{{range .Validators}}<li>{{.Name}}: {{if .Ran}}{{.Failed}} of {{.Attempted}} domains failed ({{percent .FailureRate}}) on {{time .Time}}{{else}}hasn't run{{end}}</li>
+{{else}}<li>No validators are running.</li>
+{{end}}</ul>
+{{if .Prune}}<p>MX patterns to prune, which haven't matched a live mailserver:</p>
+<ul>
+{{range .Prune}}<li>{{.Domain}}: {{.Pattern}} (for {{.Cycles}} runs, since {{date .Since}})</li>
+{{end}}</ul>{{end}}
+
+<h2>Top failure codes</h2>
+<ul>
+{{range .FailureCodes}}<li>{{.Name}}: {{.Count}}</li>
+{{else}}<li>No scans failed.</li>
+{{end}}</ul>
+
+<h2>Maintainer actions</h2>
+<ul>
+{{range .Actions}}<li>{{.Name}}: {{.Count}}</li>
+{{else}}<li>No actions were logged.</li>
+{{end}}</ul>
+
+<h2>API usage</h2>
+<p>{{.APIUsage.Keys}} API keys made {{.APIUsage.Requests}} requests and {{.APIUsage.Scans}} scans.</p>
+<ul>
+{{range .Scans}}<li>{{.Name}} scans: {{.Count}}</li>
+{{end}}</ul>
+</body>
+</html>
+`
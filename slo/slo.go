@@ -0,0 +1,208 @@
+package slo
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations whose reliability we track.
+const (
+	Scan            = "scan"             // Domain scans requested via the API.
+	EmailDelivery   = "email_delivery"   // Outgoing email submissions.
+	ListPublication = "list_publication" // Fetches of the published policy list.
+)
+
+// Objective is a service level objective for a single operation.
+type Objective struct {
+	Operation string `json:"operation"`
+	// Fraction of operations that should succeed within LatencyTarget.
+	Target float64 `json:"target"`
+	// Operations slower than this count against the error budget.
+	LatencyTarget time.Duration `json:"latency_target"`
+	// Rolling window over which the objective is measured.
+	Window time.Duration `json:"window"`
+}
+
+// Summary reports how an operation is performing against its objective.
+type Summary struct {
+	Objective
+	Total       int     `json:"total"`
+	Failures    int     `json:"failures"`
+	Slow        int     `json:"slow"`
+	SuccessRate float64 `json:"success_rate"`
+	// Fraction of the error budget left in the window. Negative when exhausted.
+	BudgetRemaining float64 `json:"budget_remaining"`
+	// Rate at which the error budget is being consumed; 1 means the budget
+	// will be exactly used up by the end of the window.
+	BurnRate float64 `json:"burn_rate"`
+}
+
+// windowBuckets is how many buckets each objective's window is divided into.
+// Outcomes are counted in buckets rather than kept one by one, so a busy
+// operation takes no more memory than a quiet one.
+const windowBuckets = 168
+
+// bucket counts the outcomes recorded in a slice of an objective's window.
+type bucket struct {
+	// start is when the slice began; a bucket whose start is a window or
+	// more ago is reused for a new slice.
+	start    time.Time
+	total    int
+	failures int
+	slow     int
+}
+
+// Tracker records operation outcomes and summarizes them against objectives.
+// Outcomes are counted in a ring of buckets per objective, each covering
+// 1/168th of its window (an hour of a week-long window), so summaries cover
+// the window to within a bucket. Safe for concurrent use.
+type Tracker struct {
+	mu         sync.Mutex
+	objectives map[string]Objective
+	buckets    map[string][]bucket
+}
+
+// NewTracker creates a Tracker for the given objectives.
+func NewTracker(objectives ...Objective) *Tracker {
+	t := Tracker{
+		objectives: make(map[string]Objective),
+		buckets:    make(map[string][]bucket),
+	}
+	for _, o := range objectives {
+		t.objectives[o.Operation] = o
+		t.buckets[o.Operation] = make([]bucket, windowBuckets)
+	}
+	return &t
+}
+
+// bucketWidth returns the length of the slice of o's window each bucket
+// covers.
+func bucketWidth(o Objective) time.Duration {
+	width := o.Window / windowBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return width
+}
+
+// Record adds the outcome of a single operation. Outcomes for operations
+// without an objective are ignored.
+func (t *Tracker) Record(operation string, success bool, latency time.Duration) {
+	t.record(operation, success, latency, time.Now())
+}
+
+func (t *Tracker) record(operation string, success bool, latency time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o, ok := t.objectives[operation]
+	if !ok {
+		return
+	}
+	width := bucketWidth(o)
+	slot := now.UnixNano() / int64(width)
+	b := &t.buckets[operation][slot%windowBuckets]
+	if start := time.Unix(0, slot*int64(width)); !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	b.total++
+	if !success {
+		b.failures++
+	} else if o.LatencyTarget != 0 && latency > o.LatencyTarget {
+		b.slow++
+	}
+}
+
+// Summaries returns a Summary for every objective.
+func (t *Tracker) Summaries() []Summary {
+	return t.summaries(time.Now())
+}
+
+func (t *Tracker) summaries(now time.Time) []Summary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	operations := []string{}
+	for operation := range t.objectives {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	summaries := []Summary{}
+	for _, operation := range operations {
+		summaries = append(summaries, summarize(t.objectives[operation], t.buckets[operation], now))
+	}
+	return summaries
+}
+
+func summarize(o Objective, buckets []bucket, now time.Time) Summary {
+	s := Summary{Objective: o, SuccessRate: 1, BudgetRemaining: 1}
+	for _, b := range buckets {
+		if b.total == 0 || now.Sub(b.start) >= o.Window {
+			continue
+		}
+		s.Total += b.total
+		s.Failures += b.failures
+		s.Slow += b.slow
+	}
+	if s.Total == 0 {
+		return s
+	}
+	bad := float64(s.Failures + s.Slow)
+	s.SuccessRate = 1 - bad/float64(s.Total)
+	budget := 1 - o.Target
+	if budget <= 0 {
+		if bad > 0 {
+			s.BudgetRemaining = -1
+		}
+		return s
+	}
+	s.BurnRate = (bad / float64(s.Total)) / budget
+	s.BudgetRemaining = 1 - s.BurnRate
+	return s
+}
+
+// Default objectives. Targets can be overwritten by the env vars
+// SLO_<OPERATION>_TARGET and SLO_<OPERATION>_LATENCY, eg. SLO_SCAN_TARGET=0.95.
+var defaultObjectives = []Objective{
+	{Operation: Scan, Target: 0.99, LatencyTarget: 30 * time.Second, Window: 7 * 24 * time.Hour},
+	{Operation: EmailDelivery, Target: 0.99, LatencyTarget: 10 * time.Second, Window: 7 * 24 * time.Hour},
+	{Operation: ListPublication, Target: 0.95, Window: 7 * 24 * time.Hour},
+}
+
+// objectivesFromEnv returns the default objectives, with targets overridden
+// by environment variables where set.
+func objectivesFromEnv() []Objective {
+	objectives := []Objective{}
+	for _, o := range defaultObjectives {
+		prefix := "SLO_" + strings.ToUpper(o.Operation)
+		if target, err := strconv.ParseFloat(os.Getenv(prefix+"_TARGET"), 64); err == nil {
+			o.Target = target
+		}
+		if latency, err := time.ParseDuration(os.Getenv(prefix + "_LATENCY")); err == nil {
+			o.LatencyTarget = latency
+		}
+		objectives = append(objectives, o)
+	}
+	return objectives
+}
+
+var (
+	defaultTracker     *Tracker
+	defaultTrackerOnce sync.Once
+)
+
+// Default returns the process-wide Tracker, configured with the default
+// objectives on first use.
+func Default() *Tracker {
+	defaultTrackerOnce.Do(func() {
+		defaultTracker = NewTracker(objectivesFromEnv()...)
+	})
+	return defaultTracker
+}
+
+// Record adds the outcome of an operation to the Default tracker.
+func Record(operation string, success bool, latency time.Duration) {
+	Default().Record(operation, success, latency)
+}
@@ -0,0 +1,69 @@
+package slo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummaries(t *testing.T) {
+	tracker := NewTracker(Objective{
+		Operation:     Scan,
+		Target:        0.9,
+		LatencyTarget: time.Second,
+		Window:        time.Hour,
+	})
+	for i := 0; i < 8; i++ {
+		tracker.Record(Scan, true, time.Millisecond)
+	}
+	tracker.Record(Scan, false, time.Millisecond)
+	tracker.Record(Scan, true, time.Minute)
+	// Operations without an objective are ignored.
+	tracker.Record(EmailDelivery, false, time.Millisecond)
+
+	summaries := tracker.Summaries()
+	if len(summaries) != 1 {
+		t.Fatalf("Expected 1 summary, got %d", len(summaries))
+	}
+	s := summaries[0]
+	if s.Total != 10 || s.Failures != 1 || s.Slow != 1 {
+		t.Errorf("Expected 10 total, 1 failure and 1 slow operation, got %+v", s)
+	}
+	if s.SuccessRate < 0.79 || s.SuccessRate > 0.81 {
+		t.Errorf("Expected success rate of 0.8, got %f", s.SuccessRate)
+	}
+	if s.BurnRate < 1.99 || s.BurnRate > 2.01 {
+		t.Errorf("Expected burn rate of 2, got %f", s.BurnRate)
+	}
+	if s.BudgetRemaining > -0.99 {
+		t.Errorf("Expected exhausted error budget, got %f", s.BudgetRemaining)
+	}
+}
+
+func TestEmptySummary(t *testing.T) {
+	tracker := NewTracker(Objective{Operation: Scan, Target: 0.99, Window: time.Hour})
+	s := tracker.Summaries()[0]
+	if s.SuccessRate != 1 || s.BudgetRemaining != 1 || s.BurnRate != 0 {
+		t.Errorf("Expected untouched error budget, got %+v", s)
+	}
+}
+
+func TestOldOutcomesExpire(t *testing.T) {
+	tracker := NewTracker(Objective{Operation: Scan, Target: 0.9, Window: time.Hour})
+	start := time.Now()
+	tracker.record(Scan, false, time.Millisecond, start)
+	tracker.record(Scan, true, time.Millisecond, start.Add(30*time.Minute))
+	if s := tracker.summaries(start.Add(30 * time.Minute))[0]; s.Total != 2 || s.Failures != 1 {
+		t.Errorf("Expected both outcomes within the window, got %+v", s)
+	}
+	later := start.Add(80 * time.Minute)
+	if s := tracker.summaries(later)[0]; s.Total != 1 || s.Failures != 0 {
+		t.Errorf("Expected the failure to have left the window, got %+v", s)
+	}
+	// Recording many outcomes reuses the same buckets.
+	for i := 0; i < 10000; i++ {
+		tracker.record(Scan, true, time.Millisecond, later.Add(time.Duration(i)*time.Second))
+	}
+	if n := len(tracker.buckets[Scan]); n != windowBuckets {
+		t.Errorf("Expected %d buckets, got %d", windowBuckets, n)
+	}
+}
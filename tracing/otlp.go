@@ -0,0 +1,225 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Batching settings for OTLPExporter.
+const (
+	maxQueuedSpans = 2048
+	maxBatchSpans  = 512
+	batchInterval  = 5 * time.Second
+)
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP over
+// HTTP, with JSON encoding. Spans are batched and sent in the background;
+// if the collector falls behind, spans are dropped rather than slowing down
+// the operations being traced.
+type OTLPExporter struct {
+	// Endpoint is the collector's base URL, eg. http://localhost:4318.
+	// Spans are posted to its /v1/traces path.
+	Endpoint string
+	// ServiceName identifies this service in the tracing backend.
+	ServiceName string
+	Client      *http.Client
+
+	startOnce sync.Once
+	queue     chan SpanData
+	flush     chan chan struct{}
+}
+
+// Export queues a span to be sent.
+func (e *OTLPExporter) Export(span SpanData) {
+	e.startOnce.Do(e.start)
+	select {
+	case e.queue <- span:
+	default:
+		// The queue's full; drop the span.
+	}
+}
+
+// Flush sends the spans queued so far, and waits for them to be sent.
+func (e *OTLPExporter) Flush() {
+	e.startOnce.Do(e.start)
+	done := make(chan struct{})
+	e.flush <- done
+	<-done
+}
+
+func (e *OTLPExporter) start() {
+	e.queue = make(chan SpanData, maxQueuedSpans)
+	e.flush = make(chan chan struct{})
+	go e.run()
+}
+
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	batch := []SpanData{}
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			log.Printf("Couldn't export %d spans: %v", len(batch), err)
+		}
+		batch = []SpanData{}
+	}
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= maxBatchSpans {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flush:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			close(done)
+		}
+	}
+}
+
+func (e *OTLPExporter) send(batch []SpanData) error {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return err
+	}
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(strings.TrimSuffix(e.Endpoint, "/")+"/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector responded with HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The OTLP/JSON encoding of spans. IDs are hex-encoded, and 64-bit
+// timestamps are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// OTLP status codes.
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+func (e *OTLPExporter) encode(batch []SpanData) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if s.Parent != (SpanID{}) {
+			span.ParentSpanID = s.Parent.String()
+		}
+		for _, a := range s.Attributes {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: a.Key, Value: otlpValue{StringValue: a.Value}})
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+		}
+		spans = append(spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: e.ServiceName}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/EFForg/starttls-backend/tracing"},
+			Spans: spans,
+		}},
+	}}}
+}
+
+// ConfigureFromEnv turns on tracing if OTEL_EXPORTER_OTLP_ENDPOINT is set,
+// exporting to that collector as OTEL_SERVICE_NAME (default
+// starttls-backend), and sampling the fraction of new traces in
+// OTEL_TRACES_SAMPLER_ARG (default 1). Returns the exporter, or nil if
+// tracing is off.
+func ConfigureFromEnv() (*OTLPExporter, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return nil, nil
+	}
+	ratio := 1.0
+	if value := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); value != "" {
+		var err error
+		ratio, err = strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("OTEL_TRACES_SAMPLER_ARG must be a ratio between 0 and 1, got %q", value)
+		}
+	}
+	name := os.Getenv("OTEL_SERVICE_NAME")
+	if name == "" {
+		name = "starttls-backend"
+	}
+	exporter := &OTLPExporter{Endpoint: endpoint, ServiceName: name}
+	Configure(exporter, ratio)
+	return exporter, nil
+}
@@ -0,0 +1,352 @@
+// Package tracing records spans around slow operations, like DNS lookups,
+// mailserver checks and database writes, and exports them to an
+// OpenTelemetry collector, so that slow scans can be diagnosed in a tracing
+// backend. Trace context is propagated to and from HTTP requests with W3C
+// traceparent headers.
+//
+// Tracing is off until an Exporter is configured, and spans cost next to
+// nothing while it is: Start returns a nil *Span, whose methods do nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds, as in OpenTelemetry.
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// TraceID and SpanID identify traces and spans, as in W3C Trace Context.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// spanContext is what's propagated from a span to its children.
+type spanContext struct {
+	traceID TraceID
+	spanID  SpanID
+	sampled bool
+}
+
+// Span is an operation being traced. A nil *Span is a span that isn't
+// recorded, so callers needn't check whether tracing is on.
+type Span struct {
+	mu         sync.Mutex
+	ctx        spanContext
+	parent     SpanID
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes []Attribute
+	err        string
+	ended      bool
+	tracer     *tracer
+}
+
+// Attribute is a key/value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// tracer holds the exporter and sampling settings.
+type tracer struct {
+	exporter Exporter
+	// sampleRatio is the fraction of new traces that are recorded. Traces
+	// started by an incoming request follow its sampling decision.
+	sampleRatio float64
+}
+
+var current struct {
+	sync.RWMutex
+	tracer *tracer
+}
+
+// Configure starts exporting spans with exporter, sampling sampleRatio of
+// the traces that don't come with a sampling decision. A nil exporter turns
+// tracing off.
+func Configure(exporter Exporter, sampleRatio float64) {
+	current.Lock()
+	defer current.Unlock()
+	if exporter == nil {
+		current.tracer = nil
+		return
+	}
+	current.tracer = &tracer{exporter: exporter, sampleRatio: sampleRatio}
+}
+
+func currentTracer() *tracer {
+	current.RLock()
+	defer current.RUnlock()
+	return current.tracer
+}
+
+type contextKey struct{}
+
+// remoteKey holds a span context extracted from an incoming request.
+type remoteKey struct{}
+
+func contextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the span in ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(contextKey{}).(*Span)
+	return s
+}
+
+// Start starts a span named name as a child of the span in ctx, if any,
+// with attributes given as alternating keys and values. The returned
+// context carries the new span, for starting its children. End must be
+// called on the span.
+func Start(ctx context.Context, name string, attributes ...string) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal, attributes...)
+}
+
+// StartKind starts a span of the given kind. See Start.
+func StartKind(ctx context.Context, name string, kind int, attributes ...string) (context.Context, *Span) {
+	t := currentTracer()
+	if t == nil {
+		return ctx, nil
+	}
+	var parent spanContext
+	hasParent := false
+	if p := FromContext(ctx); p != nil {
+		parent, hasParent = p.ctx, true
+	} else if remote, ok := ctx.Value(remoteKey{}).(spanContext); ok {
+		parent, hasParent = remote, true
+	}
+	s := &Span{name: name, kind: kind, start: time.Now(), tracer: t}
+	if hasParent {
+		s.ctx = spanContext{traceID: parent.traceID, sampled: parent.sampled}
+		s.parent = parent.spanID
+	} else {
+		rand.Read(s.ctx.traceID[:])
+		s.ctx.sampled = sampleTrace(s.ctx.traceID, t.sampleRatio)
+	}
+	rand.Read(s.ctx.spanID[:])
+	s.SetAttributes(attributes...)
+	if !s.ctx.sampled {
+		// Still carried in ctx, so its children aren't sampled either and
+		// its context is propagated downstream.
+		s.tracer = nil
+	}
+	return contextWithSpan(ctx, s), s
+}
+
+// sampleTrace decides whether to record a new trace, from its ID, so the
+// decision is consistent for a trace.
+func sampleTrace(id TraceID, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	// The last 8 bytes of a trace ID are random.
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/float64(1<<53) < ratio
+}
+
+// SetAttributes adds attributes to the span, given as alternating keys and
+// values.
+func (s *Span) SetAttributes(attributes ...string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(attributes); i += 2 {
+		s.attributes = append(s.attributes, Attribute{Key: attributes[i], Value: attributes[i+1]})
+	}
+}
+
+// SetError marks the span as failed with err, if it isn't nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End finishes the span and queues it for export. Calling End more than
+// once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	t := s.tracer
+	s.mu.Unlock()
+	if t != nil {
+		t.exporter.Export(s.data())
+	}
+}
+
+// TraceID returns the ID of the span's trace, or an empty string for a nil
+// span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.ctx.traceID.String()
+}
+
+// SpanData is a finished span, as passed to an Exporter.
+type SpanData struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Parent     SpanID
+	Name       string
+	Kind       int
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	// Error is set if the operation failed.
+	Error string
+}
+
+func (s *Span) data() SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SpanData{
+		TraceID:    s.ctx.traceID,
+		SpanID:     s.ctx.spanID,
+		Parent:     s.parent,
+		Name:       s.name,
+		Kind:       s.kind,
+		Start:      s.start,
+		End:        s.end,
+		Attributes: append([]Attribute{}, s.attributes...),
+		Error:      s.err,
+	}
+}
+
+// Exporter sends finished spans to a tracing backend. Export must not block.
+type Exporter interface {
+	Export(SpanData)
+}
+
+// Flush waits for the configured exporter to send the spans it's queued, if
+// it queues them, eg. before the process exits.
+func Flush() {
+	t := currentTracer()
+	if t == nil {
+		return
+	}
+	if flusher, ok := t.exporter.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+}
+
+// traceparent formats a span context as a W3C traceparent header.
+func (c spanContext) traceparent() string {
+	flags := "00"
+	if c.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", c.traceID, c.spanID, flags)
+}
+
+// parseTraceparent parses a W3C traceparent header.
+func parseTraceparent(header string) (spanContext, bool) {
+	var c spanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return c, false
+	}
+	if _, err := hex.Decode(c.traceID[:], []byte(parts[1])); err != nil || c.traceID == (TraceID{}) {
+		return c, false
+	}
+	if _, err := hex.Decode(c.spanID[:], []byte(parts[2])); err != nil || c.spanID == (SpanID{}) {
+		return c, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return c, false
+	}
+	c.sampled = flags[0]&1 == 1
+	return c, true
+}
+
+// Extract returns ctx with the trace context from an incoming request's
+// traceparent header, if it has a valid one, so spans started from it join
+// the caller's trace.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if c, ok := parseTraceparent(header.Get("traceparent")); ok {
+		return context.WithValue(ctx, remoteKey{}, c)
+	}
+	return ctx
+}
+
+// Inject sets the traceparent header of an outgoing request to the trace
+// context of the span in ctx, if any.
+func Inject(ctx context.Context, header http.Header) {
+	if s := FromContext(ctx); s != nil {
+		header.Set("traceparent", s.ctx.traceparent())
+	}
+}
+
+// statusRecorder records the status code a handler responds with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Middleware traces each request to handler in a server span, joining the
+// caller's trace if the request has a traceparent header. Handlers can start
+// child spans from the request's context.
+func Middleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if currentTracer() == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		ctx, span := StartKind(Extract(r.Context(), r.Header), r.Method+" "+r.URL.Path, KindServer,
+			"http.method", r.Method, "http.target", r.URL.Path)
+		defer span.End()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r.WithContext(ctx))
+		span.SetAttributes("http.status_code", fmt.Sprint(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("HTTP %d", recorder.status))
+		}
+	})
+}
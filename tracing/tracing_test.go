@@ -0,0 +1,171 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recorder is an Exporter that keeps spans in memory.
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(s SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+func (r *recorder) byName() map[string]SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := make(map[string]SpanData)
+	for _, s := range r.spans {
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+func record(t *testing.T, ratio float64) *recorder {
+	r := &recorder{}
+	Configure(r, ratio)
+	t.Cleanup(func() { Configure(nil, 0) })
+	return r
+}
+
+func TestSpansAreNoopsWhenOff(t *testing.T) {
+	ctx, span := Start(context.Background(), "operation")
+	if span != nil || FromContext(ctx) != nil {
+		t.Errorf("Expected no span while tracing is off")
+	}
+	span.SetAttributes("key", "value")
+	span.SetError(errors.New("failed"))
+	span.End()
+}
+
+func TestChildSpans(t *testing.T) {
+	r := record(t, 1)
+	ctx, parent := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child", "hostname", "mx.example.com")
+	child.SetError(errors.New("timed out"))
+	child.End()
+	parent.End()
+	parent.End()
+
+	if len(r.spans) != 2 {
+		t.Fatalf("Expected each span to be exported once, got %v", r.spans)
+	}
+	spans := r.byName()
+	if spans["child"].TraceID != spans["parent"].TraceID || spans["child"].Parent != spans["parent"].SpanID {
+		t.Errorf("Expected child to be in its parent's trace, got %+v and %+v", spans["child"], spans["parent"])
+	}
+	if spans["parent"].Parent != (SpanID{}) {
+		t.Errorf("Expected parent to be a root span")
+	}
+	if spans["child"].Error != "timed out" || spans["child"].Attributes[0] != (Attribute{"hostname", "mx.example.com"}) {
+		t.Errorf("Expected child's error and attributes to be recorded, got %+v", spans["child"])
+	}
+}
+
+func TestUnsampledTracesAreNotExported(t *testing.T) {
+	r := record(t, 0)
+	ctx, parent := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child")
+	child.End()
+	parent.End()
+	if len(r.spans) != 0 {
+		t.Errorf("Expected no spans to be exported, got %v", r.spans)
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r := record(t, 0)
+	ctx, span := Start(Extract(context.Background(), header), "handler")
+	span.End()
+	if len(r.spans) != 1 {
+		t.Fatalf("Expected caller's sampling decision to be followed, got %v", r.spans)
+	}
+	if r.spans[0].TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || r.spans[0].Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected span to join the caller's trace, got %+v", r.spans[0])
+	}
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + r.spans[0].SpanID.String() + "-01"
+	if outgoing.Get("traceparent") != expected {
+		t.Errorf("Expected traceparent %s, got %s", expected, outgoing.Get("traceparent"))
+	}
+
+	for _, invalid := range []string{"", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-xyz-00f067aa0ba902b7-01"} {
+		if _, ok := parseTraceparent(invalid); ok {
+			t.Errorf("Expected traceparent %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	r := record(t, 1)
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, span := Start(req.Context(), "db.put_scan")
+		span.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/scan", nil))
+	spans := r.byName()
+	server, ok := spans["POST /api/scan"]
+	if !ok || server.Kind != KindServer || server.Error == "" {
+		t.Fatalf("Expected a failed server span for the request, got %v", r.spans)
+	}
+	if spans["db.put_scan"].Parent != server.SpanID {
+		t.Errorf("Expected handler's spans to be children of the request's span")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var received otlpRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" {
+			t.Errorf("Expected spans to be posted to /v1/traces, got %s", req.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer collector.Close()
+	exporter := &OTLPExporter{Endpoint: collector.URL, ServiceName: "test"}
+	Configure(exporter, 1)
+	defer Configure(nil, 0)
+	ctx, parent := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child")
+	child.SetError(errors.New("failed"))
+	child.End()
+	parent.End()
+	Flush()
+
+	if len(received.ResourceSpans) != 1 || len(received.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Expected one batch of spans, got %+v", received)
+	}
+	if name := received.ResourceSpans[0].Resource.Attributes[0].Value.StringValue; name != "test" {
+		t.Errorf("Expected service name test, got %s", name)
+	}
+	spans := received.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Name != "child" || spans[0].ParentSpanID != spans[1].SpanID {
+		t.Fatalf("Expected child and parent spans, got %+v", spans)
+	}
+	if spans[0].Status.Code != otlpStatusError || spans[1].Status.Code != otlpStatusOK {
+		t.Errorf("Expected child to fail and parent to succeed, got %+v", spans)
+	}
+	if len(spans[1].TraceID) != 32 || len(spans[1].SpanID) != 16 || spans[1].ParentSpanID != "" {
+		t.Errorf("Expected hex IDs, got %+v", spans[1])
+	}
+}
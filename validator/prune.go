@@ -0,0 +1,91 @@
+package validator
+
+import (
+	"sort"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+// PruneSuggestion suggests removing an MX pattern from a domain's policy,
+// because it hasn't matched any of the domain's live mailservers for Cycles
+// validation runs in a row.
+type PruneSuggestion struct {
+	Domain  string `json:"domain"`
+	Pattern string `json:"pattern"`
+	Cycles  int    `json:"cycles"`
+	// Since is the time of the first run in which the pattern didn't match.
+	Since time.Time `json:"since"`
+}
+
+type pruneCallback func(string, string, []PruneSuggestion)
+
+// trackPatterns counts the consecutive runs in which each of domain's MX
+// patterns matched none of the mailservers that result could connect to,
+// and returns the patterns that haven't matched for PruneAfter runs. Runs in
+// which the domain couldn't be scanned properly aren't counted either way,
+// since they say nothing about which patterns are still in use.
+func (v *Validator) trackPatterns(domain string, patterns []string, result checker.DomainResult, now time.Time) []PruneSuggestion {
+	if v.PruneAfter <= 0 {
+		return nil
+	}
+	if v.unmatched == nil {
+		v.unmatched = make(map[string]map[string]PruneSuggestion)
+	}
+	previous := v.unmatched[domain]
+	var newlyStale []PruneSuggestion
+	if result.ErrorClass != checker.TemporaryError && !result.TimedOut && len(result.PreferredHostnames) > 0 {
+		unmatched := make(map[string]PruneSuggestion)
+		for _, pattern := range patterns {
+			if patternMatchesAny(pattern, result.PreferredHostnames) {
+				continue
+			}
+			s, ok := previous[pattern]
+			if !ok {
+				s = PruneSuggestion{Domain: domain, Pattern: pattern, Since: now}
+			}
+			s.Cycles++
+			if s.Cycles == v.PruneAfter {
+				newlyStale = append(newlyStale, s)
+			}
+			unmatched[pattern] = s
+		}
+		v.unmatched[domain] = unmatched
+	}
+	if len(newlyStale) > 0 && v.OnPrune != nil {
+		v.OnPrune(v.Name, domain, newlyStale)
+	}
+	return v.stalePatterns(domain)
+}
+
+// stalePatterns returns domain's patterns that haven't matched for
+// PruneAfter runs, sorted by pattern.
+func (v *Validator) stalePatterns(domain string) []PruneSuggestion {
+	var stale []PruneSuggestion
+	for _, s := range v.unmatched[domain] {
+		if s.Cycles >= v.PruneAfter {
+			stale = append(stale, s)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Pattern < stale[j].Pattern })
+	return stale
+}
+
+// forgetPatterns stops tracking the patterns of domains that weren't
+// validated in the latest run, eg. because they left the list.
+func (v *Validator) forgetPatterns(validated map[string]bool) {
+	for domain := range v.unmatched {
+		if !validated[domain] {
+			delete(v.unmatched, domain)
+		}
+	}
+}
+
+func patternMatchesAny(pattern string, hostnames []string) bool {
+	for _, hostname := range hostnames {
+		if checker.PolicyMatches(hostname, []string{pattern}) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package validator
 import (
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/EFForg/starttls-backend/checker"
@@ -17,6 +18,22 @@ type DomainPolicyStore interface {
 	HostnamesForDomain(string) ([]string, error)
 }
 
+// MTASTSModeStore is an interface for any back-end that tracks the MTA-STS
+// policy mode of domains admitted via MTA-STS.
+type MTASTSModeStore interface {
+	// SetMTASTSMode records a domain's current mode, returning true if it
+	// changed. Domains that weren't admitted via MTA-STS are left alone.
+	SetMTASTSMode(domain string, mode string) (bool, error)
+}
+
+// RunStore is an interface for any back-end that keeps the summary of each
+// validator's most recent run, so that other servers can report on it, and
+// so that a restarted validator can pick up where it left off.
+type RunStore interface {
+	PutValidatorRun(name string, summary RunSummary) error
+	GetValidatorRun(name string) (RunSummary, error)
+}
+
 // Called with failure by defaault.
 func reportToSentry(name string, domain string, result checker.DomainResult) {
 	raven.CaptureMessageAndWait("Validation failed for previously validated domain",
@@ -30,6 +47,7 @@ func reportToSentry(name string, domain string, result checker.DomainResult) {
 
 type checkPerformer func(string, []string) checker.DomainResult
 type resultCallback func(string, string, checker.DomainResult)
+type failingCallback func(string, string, int, checker.DomainResult)
 
 // Validator runs checks regularly against domain policies. This structure
 // defines the configurations.
@@ -47,14 +65,39 @@ type Validator struct {
 	OnFailure resultCallback
 	// OnSuccess: optional. Called when a particular policy validation succeeds.
 	OnSuccess resultCallback
+	// Modes: optional. If set, the MTA-STS mode of each domain that passes
+	// validation is recorded in it, so that domains admitted via MTA-STS
+	// follow their owners from testing to enforce.
+	Modes MTASTSModeStore
+	// PruneAfter: optional. If set, MX patterns that match none of a
+	// domain's live mailservers for this many runs in a row are suggested
+	// for pruning from its policy.
+	PruneAfter int
+	// OnPrune: optional. Called with a domain's patterns as they become
+	// suggested for pruning, eg. to let its owner know.
+	OnPrune pruneCallback
+	// OnFailing: optional. Called when a domain fails validation with how
+	// many runs in a row it has failed, eg. to warn its owner before it's
+	// removed from the list.
+	OnFailing failingCallback
+	// Runs: optional. If set, the summary of each run is stored in it, and
+	// the failure streaks of the last run are resumed on start.
+	Runs RunStore
 	// checkPerformer: performs the check.
 	checkPerformer checkPerformer
+	// previous: the last result for each domain, to report what changed.
+	previous map[string]checker.DomainResult
+	// unmatched: each domain's patterns that haven't matched a live
+	// mailserver in the latest runs.
+	unmatched map[string]map[string]PruneSuggestion
+	// failing: how many runs in a row each domain has failed.
+	failing map[string]int
 }
 
 func (v *Validator) checkPolicy(domain string, hostnames []string) checker.DomainResult {
 	if v.checkPerformer == nil {
 		c := checker.Checker{
-			Cache: checker.MakeSimpleCache(time.Hour),
+			Cache: checker.MakeBoundedCache(time.Hour, 10000),
 		}
 		v.checkPerformer = c.CheckDomain
 	}
@@ -81,9 +124,201 @@ func (v *Validator) policyPassed(name string, domain string, result checker.Doma
 	}
 }
 
+// RunSummary counts the outcomes of a single validation run.
+type RunSummary struct {
+	Time      time.Time `json:"time"`
+	Attempted int       `json:"attempted"`
+	Failed    int       `json:"failed"`
+	// Retried counts domains that were checked again after a temporary
+	// failure. They're only counted as Failed if the retry failed too.
+	Retried int `json:"retried"`
+	// TimedOut counts domains whose retry also timed out before every
+	// mailserver was checked. They're neither passed nor failed.
+	TimedOut int `json:"timed_out,omitempty"`
+	// Prune lists the MX patterns suggested for pruning, if PruneAfter is
+	// set.
+	Prune []PruneSuggestion `json:"prune,omitempty"`
+	// Failing counts how many runs in a row each failing domain has failed,
+	// as of this run.
+	Failing map[string]int `json:"failing,omitempty"`
+}
+
+// FailureRate returns the percentage of validations that failed.
+func (s RunSummary) FailureRate() float64 {
+	if s.Attempted == 0 {
+		return 0
+	}
+	return 100 * float64(s.Failed) / float64(s.Attempted)
+}
+
+var lastRuns = struct {
+	sync.Mutex
+	m map[string]RunSummary
+}{m: make(map[string]RunSummary)}
+
+// LastRun returns the summary of the most recent completed run of the
+// validator called name.
+func LastRun(name string) (RunSummary, bool) {
+	lastRuns.Lock()
+	defer lastRuns.Unlock()
+	summary, ok := lastRuns.m[name]
+	return summary, ok
+}
+
+func recordRun(name string, summary RunSummary) {
+	lastRuns.Lock()
+	defer lastRuns.Unlock()
+	lastRuns.m[name] = summary
+}
+
+// changes describes what changed since domain's previous result, and records
+// result for next time.
+func (v *Validator) changes(domain string, result checker.DomainResult) string {
+	if v.previous == nil {
+		v.previous = make(map[string]checker.DomainResult)
+	}
+	previous, ok := v.previous[domain]
+	v.previous[domain] = result
+	if !ok {
+		return ""
+	}
+	if diff := checker.Diff(previous, result); diff.Changed() {
+		return " since last run (" + diff.String() + ")"
+	}
+	return ""
+}
+
+// trackFailure counts the runs in a row domain has failed, and tells
+// OnFailing.
+func (v *Validator) trackFailure(domain string, result checker.DomainResult) {
+	if v.failing == nil {
+		v.failing = make(map[string]int)
+	}
+	v.failing[domain]++
+	if v.OnFailing != nil {
+		v.OnFailing(v.Name, domain, v.failing[domain], result)
+	}
+}
+
+// forgetFailures stops counting the failures of domains that weren't
+// validated in the latest run.
+func (v *Validator) forgetFailures(validated map[string]bool) {
+	for domain := range v.failing {
+		if !validated[domain] {
+			delete(v.failing, domain)
+		}
+	}
+}
+
+// resumeFailures picks up the failure streaks recorded by the last run, so
+// that restarting the validator doesn't reset them.
+func (v *Validator) resumeFailures() {
+	if v.Runs == nil {
+		return
+	}
+	// A validator that hasn't run yet has no streaks to resume.
+	last, err := v.Runs.GetValidatorRun(v.Name)
+	if err != nil || len(last.Failing) == 0 {
+		return
+	}
+	v.failing = make(map[string]int, len(last.Failing))
+	for domain, runs := range last.Failing {
+		v.failing[domain] = runs
+	}
+}
+
+// pendingRetry is a domain to check again at the end of a run.
+type pendingRetry struct {
+	domain    string
+	hostnames []string
+}
+
+// validate checks each domain once. Domains that fail with a temporary error
+// are checked again after the rest, and only reported if they fail again.
+func (v *Validator) validate(domains []string) RunSummary {
+	summary := RunSummary{Time: time.Now()}
+	var retries []pendingRetry
+	validated := make(map[string]bool)
+	for _, domain := range domains {
+		hostnames, err := v.Store.HostnamesForDomain(domain)
+		if err != nil {
+			log.Printf("[%s validator] Could not retrieve policy for domain %s: %v", v.Name, domain, err)
+			continue
+		}
+		validated[domain] = true
+		result := v.checkPolicy(domain, hostnames)
+		summary.Attempted++
+		if result.Status != 0 && result.ErrorClass == checker.TemporaryError {
+			retries = append(retries, pendingRetry{domain, hostnames})
+			continue
+		}
+		v.report(domain, hostnames, result, &summary)
+	}
+	for _, retry := range retries {
+		log.Printf("[%s validator] retrying %s after a temporary failure", v.Name, retry.domain)
+		summary.Retried++
+		v.report(retry.domain, retry.hostnames, v.checkPolicy(retry.domain, retry.hostnames), &summary)
+	}
+	v.forgetPatterns(validated)
+	v.forgetFailures(validated)
+	if len(v.failing) > 0 {
+		summary.Failing = make(map[string]int, len(v.failing))
+		for domain, runs := range v.failing {
+			summary.Failing[domain] = runs
+		}
+	}
+	return summary
+}
+
+func (v *Validator) report(domain string, hostnames []string, result checker.DomainResult, summary *RunSummary) {
+	if result.TimedOut {
+		// Only some mailservers were checked, so the result can't decide
+		// whether the domain's policy holds.
+		log.Printf("[%s validator] %s timed out before every mailserver was checked; not reporting it", v.Name, domain)
+		summary.TimedOut++
+		return
+	}
+	summary.Prune = append(summary.Prune, v.trackPatterns(domain, hostnames, result, summary.Time)...)
+	changes := v.changes(domain, result)
+	if result.Status != 0 {
+		log.Printf("[%s validator] %s failed%s; sending report", v.Name, domain, changes)
+		summary.Failed++
+		v.policyFailed(v.Name, domain, result)
+		v.trackFailure(domain, result)
+	} else {
+		delete(v.failing, domain)
+		v.trackMTASTSMode(domain, result)
+		v.policyPassed(v.Name, domain, result)
+	}
+}
+
+// trackMTASTSMode records the mode of domain's MTA-STS policy, if it has a
+// valid one.
+func (v *Validator) trackMTASTSMode(domain string, result checker.DomainResult) {
+	if v.Modes == nil || result.MTASTSResult == nil {
+		return
+	}
+	mts := result.MTASTSResult
+	if mts.Status != checker.Success && mts.Status != checker.Warning {
+		return
+	}
+	if mts.Mode != "testing" && mts.Mode != "enforce" {
+		return
+	}
+	changed, err := v.Modes.SetMTASTSMode(domain, mts.Mode)
+	if err != nil {
+		log.Printf("[%s validator] Could not record MTA-STS mode for %s: %v", v.Name, domain, err)
+		return
+	}
+	if changed {
+		log.Printf("[%s validator] %s's MTA-STS policy is now in %s mode", v.Name, domain, mts.Mode)
+	}
+}
+
 // Run starts the endless loop of validations. The first validation happens after the given
 // Interval. Validation failures induce `policyFailed`, and successes cause `policyPassed`.
 func (v *Validator) Run() {
+	v.resumeFailures()
 	for {
 		<-time.After(v.interval())
 		log.Printf("[%s validator] starting regular validation", v.Name)
@@ -92,18 +327,11 @@ func (v *Validator) Run() {
 			log.Printf("[%s validator] Could not retrieve domains: %v", v.Name, err)
 			continue
 		}
-		for _, domain := range domains {
-			hostnames, err := v.Store.HostnamesForDomain(domain)
-			if err != nil {
-				log.Printf("[%s validator] Could not retrieve policy for domain %s: %v", v.Name, domain, err)
-				continue
-			}
-			result := v.checkPolicy(domain, hostnames)
-			if result.Status != 0 {
-				log.Printf("[%s validator] %s failed; sending report", v.Name, domain)
-				v.policyFailed(v.Name, domain, result)
-			} else {
-				v.policyPassed(v.Name, domain, result)
+		summary := v.validate(domains)
+		recordRun(v.Name, summary)
+		if v.Runs != nil {
+			if err = v.Runs.PutValidatorRun(v.Name, summary); err != nil {
+				log.Printf("[%s validator] Could not store run summary: %v", v.Name, err)
 			}
 		}
 	}
@@ -111,11 +339,13 @@ func (v *Validator) Run() {
 
 // ValidateRegularly regularly runs checker.CheckDomain against a Domain-
 // Hostname map. Interval specifies the interval to wait between each run.
-// Failures are reported to Sentry.
-func ValidateRegularly(name string, store DomainPolicyStore, interval time.Duration) {
+// Failures are reported to Sentry. If modes is non-nil, MTA-STS modes are
+// tracked in it.
+func ValidateRegularly(name string, store DomainPolicyStore, modes MTASTSModeStore, interval time.Duration) {
 	v := Validator{
 		Name:     name,
 		Store:    store,
+		Modes:    modes,
 		Interval: interval,
 	}
 	v.Run()
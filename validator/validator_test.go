@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -93,3 +94,248 @@ func TestRegularValidationReportsErrors(t *testing.T) {
 		t.Errorf("Didn't expect normal to be reported as failure")
 	}
 }
+
+func TestLastRunSummary(t *testing.T) {
+	fakeChecker := func(domain string, hostnames []string) checker.DomainResult {
+		if domain == "fail" {
+			return checker.DomainResult{Status: 5}
+		}
+		return checker.DomainResult{Status: 0}
+	}
+	mock := mockDomainPolicyStore{
+		hostnames: map[string][]string{
+			"fail":    []string{"hostname"},
+			"normal":  []string{"hostname"},
+			"normal2": []string{"hostname"},
+			"normal3": []string{"hostname"}}}
+	v := Validator{Name: "summary test", Store: mock, Interval: 10 * time.Millisecond,
+		checkPerformer: fakeChecker, OnFailure: noop}
+	go v.Run()
+	deadline := time.After(time.Second)
+	for {
+		if summary, ok := LastRun("summary test"); ok {
+			if summary.Attempted != 4 || summary.Failed != 1 || summary.FailureRate() != 25 {
+				t.Errorf("unexpected run summary %+v", summary)
+			}
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatal("validator run wasn't recorded")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+type mockRunStore chan RunSummary
+
+func (m mockRunStore) PutValidatorRun(name string, summary RunSummary) error {
+	m <- summary
+	return nil
+}
+
+func (m mockRunStore) GetValidatorRun(name string) (RunSummary, error) {
+	return RunSummary{}, errors.New("no runs")
+}
+
+type lastRunStore struct{ last RunSummary }
+
+func (m *lastRunStore) PutValidatorRun(name string, summary RunSummary) error {
+	m.last = summary
+	return nil
+}
+
+func (m *lastRunStore) GetValidatorRun(name string) (RunSummary, error) {
+	return m.last, nil
+}
+
+func TestRunsAreStored(t *testing.T) {
+	fakeChecker := func(domain string, hostnames []string) checker.DomainResult {
+		return checker.DomainResult{Status: 5}
+	}
+	runs := make(mockRunStore, 1)
+	v := Validator{Name: "stored runs test", Store: mockDomainPolicyStore{hostnames: map[string][]string{"fail": {"hostname"}}},
+		Runs: runs, Interval: 10 * time.Millisecond, checkPerformer: fakeChecker, OnFailure: noop}
+	go v.Run()
+	select {
+	case summary := <-runs:
+		if summary.Attempted != 1 || summary.Failed != 1 {
+			t.Errorf("Unexpected stored run summary %+v", summary)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Validator run wasn't stored")
+	}
+}
+
+func TestValidatorReportsChanges(t *testing.T) {
+	v := Validator{}
+	result := checker.NewSampleDomainResult("example.com")
+	if changes := v.changes("example.com", result); changes != "" {
+		t.Errorf("Expected no changes on first run, got %q", changes)
+	}
+	broken := checker.NewSampleDomainResult("example.com")
+	broken.HostnameResults["mx.example.com"].Checks[checker.STARTTLS].Failure("No STARTTLS")
+	changes := v.changes("example.com", broken)
+	if changes != " since last run (mx.example.com starttls: Success -> Failure)" {
+		t.Errorf("Expected broken STARTTLS check to be reported, got %q", changes)
+	}
+}
+
+func TestTemporaryFailuresAreRetried(t *testing.T) {
+	attempts := make(map[string]int)
+	fakeChecker := func(domain string, hostnames []string) checker.DomainResult {
+		attempts[domain]++
+		// "flaky" recovers on its retry, "down" never does.
+		if domain == "down" || attempts[domain] == 1 {
+			return checker.DomainResult{Status: checker.DomainCouldNotConnect, ErrorClass: checker.TemporaryError}
+		}
+		return checker.DomainResult{}
+	}
+	failures := []string{}
+	mock := mockDomainPolicyStore{
+		hostnames: map[string][]string{"flaky": []string{"hostname"}, "down": []string{"hostname"}}}
+	v := Validator{Store: mock, checkPerformer: fakeChecker,
+		OnFailure: func(_ string, domain string, _ checker.DomainResult) { failures = append(failures, domain) }}
+	summary := v.validate([]string{"flaky", "down"})
+	if summary.Attempted != 2 || summary.Retried != 2 || summary.Failed != 1 {
+		t.Errorf("Expected 2 attempted, 2 retried and 1 failed, got %+v", summary)
+	}
+	if len(failures) != 1 || failures[0] != "down" {
+		t.Errorf("Expected only the domain that failed its retry to be reported, got %v", failures)
+	}
+}
+
+func TestTimedOutResultsAreNotReported(t *testing.T) {
+	fakeChecker := func(domain string, hostnames []string) checker.DomainResult {
+		return checker.DomainResult{Status: checker.DomainTimedOut, ErrorClass: checker.TemporaryError, TimedOut: true}
+	}
+	reported := 0
+	count := func(_ string, _ string, _ checker.DomainResult) { reported++ }
+	mock := mockDomainPolicyStore{hostnames: map[string][]string{"slow": []string{"hostname"}}}
+	v := Validator{Store: mock, checkPerformer: fakeChecker, OnFailure: count, OnSuccess: count}
+	summary := v.validate([]string{"slow"})
+	if summary.Retried != 1 || summary.TimedOut != 1 || summary.Failed != 0 || reported != 0 {
+		t.Errorf("Expected a timed out domain to be retried but not reported, got %+v and %d reports", summary, reported)
+	}
+}
+
+type mockModeStore struct {
+	modes map[string]string
+}
+
+func (m *mockModeStore) SetMTASTSMode(domain string, mode string) (bool, error) {
+	changed := m.modes[domain] != mode
+	m.modes[domain] = mode
+	return changed, nil
+}
+
+func TestValidatorTracksMTASTSMode(t *testing.T) {
+	mode := "enforce"
+	fakeChecker := func(domain string, hostnames []string) checker.DomainResult {
+		result := checker.DomainResult{Domain: domain, MTASTSResult: checker.MakeMTASTSResult()}
+		result.MTASTSResult.Mode = mode
+		if domain == "fail" {
+			result.Status = 5
+		}
+		return result
+	}
+	modes := &mockModeStore{modes: map[string]string{"normal": "testing", "fail": "testing"}}
+	mock := mockDomainPolicyStore{
+		hostnames: map[string][]string{
+			"fail":   []string{"hostname"},
+			"normal": []string{"hostname"}}}
+	v := Validator{Store: mock, Modes: modes, checkPerformer: fakeChecker, OnFailure: noop}
+	v.validate([]string{"fail", "normal"})
+	if modes.modes["normal"] != "enforce" {
+		t.Errorf("Expected normal to move to enforce, got %s", modes.modes["normal"])
+	}
+	if modes.modes["fail"] != "testing" {
+		t.Errorf("Expected failing domain's mode to be left alone, got %s", modes.modes["fail"])
+	}
+
+	mode = "none"
+	v.validate([]string{"normal"})
+	if modes.modes["normal"] != "enforce" {
+		t.Errorf("Expected invalid mode to be ignored, got %s", modes.modes["normal"])
+	}
+}
+
+func TestValidatorSuggestsPruning(t *testing.T) {
+	live := []string{"mx1.example.com"}
+	temporary := false
+	fakeChecker := func(domain string, hostnames []string) checker.DomainResult {
+		if temporary {
+			return checker.DomainResult{Status: checker.DomainCouldNotConnect, ErrorClass: checker.TemporaryError}
+		}
+		return checker.DomainResult{Domain: domain, PreferredHostnames: live}
+	}
+	mock := mockDomainPolicyStore{
+		hostnames: map[string][]string{"example.com": {"mx1.example.com", ".old.example.com"}}}
+	suggested := [][]PruneSuggestion{}
+	v := Validator{Store: mock, PruneAfter: 2, checkPerformer: fakeChecker, OnFailure: noop,
+		OnPrune: func(_ string, _ string, s []PruneSuggestion) { suggested = append(suggested, s) }}
+	if summary := v.validate([]string{"example.com"}); len(summary.Prune) != 0 {
+		t.Errorf("Expected no suggestions after one run, got %v", summary.Prune)
+	}
+	// Temporary failures don't count towards, or reset, the runs.
+	temporary = true
+	v.validate([]string{"example.com"})
+	temporary = false
+	summary := v.validate([]string{"example.com"})
+	if len(summary.Prune) != 1 || summary.Prune[0].Pattern != ".old.example.com" || summary.Prune[0].Cycles != 2 {
+		t.Errorf("Expected .old.example.com to be suggested for pruning, got %+v", summary.Prune)
+	}
+	summary = v.validate([]string{"example.com"})
+	if len(summary.Prune) != 1 || len(suggested) != 1 {
+		t.Errorf("Expected owner to be told once, got %v suggested in %v", summary.Prune, suggested)
+	}
+	live = []string{"mx1.example.com", "mx.old.example.com"}
+	if summary = v.validate([]string{"example.com"}); len(summary.Prune) != 0 {
+		t.Errorf("Expected matching pattern not to be suggested, got %v", summary.Prune)
+	}
+}
+
+func TestValidatorCountsFailures(t *testing.T) {
+	failing := true
+	fakeChecker := func(domain string, hostnames []string) checker.DomainResult {
+		if failing {
+			return checker.DomainResult{Domain: domain, Status: checker.DomainCouldNotConnect}
+		}
+		return checker.DomainResult{Domain: domain}
+	}
+	mock := mockDomainPolicyStore{hostnames: map[string][]string{"example.com": {"mx.example.com"}}}
+	var runs []int
+	v := Validator{Store: mock, checkPerformer: fakeChecker, OnFailure: noop,
+		OnFailing: func(_ string, _ string, n int, _ checker.DomainResult) { runs = append(runs, n) }}
+	v.validate([]string{"example.com"})
+	v.validate([]string{"example.com"})
+	failing = false
+	v.validate([]string{"example.com"})
+	failing = true
+	v.validate([]string{"example.com"})
+	// Domains that leave the list are forgotten.
+	v.validate([]string{})
+	v.validate([]string{"example.com"})
+	if len(runs) != 4 || runs[0] != 1 || runs[1] != 2 || runs[2] != 1 || runs[3] != 1 {
+		t.Errorf("Expected failures to be counted until the domain passes, got %v", runs)
+	}
+}
+
+func TestFailureStreaksSurviveRestart(t *testing.T) {
+	fakeChecker := func(domain string, hostnames []string) checker.DomainResult {
+		return checker.DomainResult{Domain: domain, Status: checker.DomainCouldNotConnect}
+	}
+	mock := mockDomainPolicyStore{hostnames: map[string][]string{"example.com": {"mx.example.com"}}}
+	runs := &lastRunStore{}
+	v := Validator{Store: mock, checkPerformer: fakeChecker, OnFailure: noop}
+	v.validate([]string{"example.com"})
+	runs.PutValidatorRun(v.Name, v.validate([]string{"example.com"}))
+	var count int
+	restarted := Validator{Store: mock, Runs: runs, checkPerformer: fakeChecker, OnFailure: noop,
+		OnFailing: func(_ string, _ string, n int, _ checker.DomainResult) { count = n }}
+	restarted.resumeFailures()
+	restarted.validate([]string{"example.com"})
+	if count != 3 {
+		t.Errorf("Expected failure streak to carry over a restart, got %d runs", count)
+	}
+}
@@ -0,0 +1,85 @@
+// Package views loads the HTML and email templates and static assets, which
+// are embedded in the binary. They can instead be loaded from a directory,
+// and reloaded whenever they're used, so they can be edited without
+// recompiling.
+package views
+
+import (
+	"embed"
+	htmltemplate "html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	texttemplate "text/template"
+)
+
+//go:embed *.html.tmpl email static
+var embedded embed.FS
+
+// Views holds parsed templates and static assets.
+type Views struct {
+	fs fs.FS
+	// Reload re-parses templates each time they're used.
+	reload bool
+
+	mu   sync.Mutex
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// New loads views from dir, reloading templates whenever they're used. If
+// dir is empty, the views embedded in the binary are used instead, and
+// templates are only parsed once.
+func New(dir string) *Views {
+	v := &Views{
+		fs:   embedded,
+		html: make(map[string]*htmltemplate.Template),
+		text: make(map[string]*texttemplate.Template),
+	}
+	if dir != "" {
+		v.fs = os.DirFS(dir)
+		v.reload = true
+	}
+	return v
+}
+
+// HTML returns the HTML template <name>.html.tmpl.
+func (v *Views) HTML(name string) (*htmltemplate.Template, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if tmpl, ok := v.html[name]; ok && !v.reload {
+		return tmpl, nil
+	}
+	tmpl, err := htmltemplate.ParseFS(v.fs, name+".html.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	v.html[name] = tmpl
+	return tmpl, nil
+}
+
+// Text returns the plain text email template email/<name>.txt.tmpl.
+func (v *Views) Text(name string) (*texttemplate.Template, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if tmpl, ok := v.text[name]; ok && !v.reload {
+		return tmpl, nil
+	}
+	tmpl, err := texttemplate.ParseFS(v.fs, "email/"+name+".txt.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	v.text[name] = tmpl
+	return tmpl, nil
+}
+
+// Static serves the files in the static directory.
+func (v *Views) Static() http.Handler {
+	static, err := fs.Sub(v.fs, "static")
+	if err != nil {
+		// fs.Sub only fails for invalid paths.
+		panic(err)
+	}
+	return http.FileServer(http.FS(static))
+}
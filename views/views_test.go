@@ -0,0 +1,57 @@
+package views
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedViews(t *testing.T) {
+	v := New("")
+	for _, name := range []string{"default", "scan", "about_scans", "queued"} {
+		if _, err := v.HTML(name); err != nil {
+			t.Errorf("Couldn't load embedded template %s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"validation", "api_key_verification", "data_export_verification", "data_erasure_verification", "submission_rejected", "promotion_confirmation", "prune_suggestion", "list_added", "validation_failure", "removal_warning", "removal_verification"} {
+		if _, err := v.Text(name); err != nil {
+			t.Errorf("Couldn't load embedded email template %s: %v", name, err)
+		}
+	}
+	w := httptest.NewRecorder()
+	v.Static().ServeHTTP(w, httptest.NewRequest("GET", "/style.css", nil))
+	if w.Code != 200 {
+		t.Errorf("Expected embedded static assets to be served, got %d", w.Code)
+	}
+}
+
+func TestOverrideDirReloads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "views")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "default.html.tmpl")
+	render := func(v *Views) string {
+		tmpl, err := v.HTML("default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		tmpl.Execute(&b, nil)
+		return b.String()
+	}
+
+	v := New(dir)
+	ioutil.WriteFile(path, []byte("before"), 0644)
+	if got := render(v); got != "before" {
+		t.Errorf("Expected template from override directory, got %q", got)
+	}
+	ioutil.WriteFile(path, []byte("after"), 0644)
+	if got := render(v); got != "after" {
+		t.Errorf("Expected edited template to be reloaded, got %q", got)
+	}
+}
@@ -0,0 +1,320 @@
+// Package webhooks delivers signed notifications of domains' state changes
+// to the callback URLs registered for them. Deliveries are queued by the
+// database in the same transaction as the change, so none are lost if a
+// server restarts, and a Dispatcher sends them, retrying failures with
+// exponential backoff.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+	raven "github.com/getsentry/raven-go"
+)
+
+// EventStateChange is the only event delivered so far.
+const EventStateChange = "domain.state"
+
+// Headers set on every delivery.
+const (
+	// SignatureHeader is set to Sign's signature of the delivery.
+	SignatureHeader = "X-Starttls-Signature"
+	// DeliveryHeader is set to the delivery's ID, which is the same for each
+	// attempt, so receivers can ignore deliveries they've already handled.
+	DeliveryHeader = "X-Starttls-Delivery"
+)
+
+// Event is the JSON body POSTed to a webhook.
+type Event struct {
+	// ID is the delivery's ID, as in DeliveryHeader.
+	ID     int64              `json:"id"`
+	Event  string             `json:"event"`
+	Domain string             `json:"domain"`
+	From   models.DomainState `json:"from"`
+	To     models.DomainState `json:"to"`
+	Reason string             `json:"reason,omitempty"`
+	// Time is when the domain changed state.
+	Time time.Time `json:"time"`
+}
+
+// Retry policy. The first retry is Backoff after the first attempt, and each
+// retry waits twice as long as the last, until MaxAttempts have been made:
+// about four hours of retries in all.
+const (
+	MaxAttempts = 8
+	Backoff     = time.Minute
+)
+
+// Timeout bounds each attempt to deliver to a webhook.
+const Timeout = 10 * time.Second
+
+// batchSize is the most deliveries attempted each time the dispatcher runs.
+const batchSize = 20
+
+// Sign returns the signature of a delivery's body, sent at t, with a
+// webhook's secret, as "t=<unix time>,v1=<signature>". The signature is the
+// hex-encoded HMAC-SHA256 of the unix time, a period, and the body.
+// Receivers should check it, and that t is recent, to reject forged and
+// replayed deliveries.
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, signature(secret, timestamp, body))
+}
+
+func signature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature made by Sign, and returns the time it was signed.
+func Verify(secret string, header string, body []byte) (time.Time, error) {
+	var timestamp, given string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			given = kv[1]
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || given == "" {
+		return time.Time{}, errors.New("malformed signature")
+	}
+	if !hmac.Equal([]byte(given), []byte(signature(secret, timestamp, body))) {
+		return time.Time{}, errors.New("signature doesn't match")
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// ValidateURL checks that a webhook URL is an HTTPS URL of a public host,
+// and returns it normalized.
+func ValidateURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.Opaque != "" {
+		return "", fmt.Errorf("%q must be an https:// URL", raw)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("%q mustn't contain credentials", raw)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return "", fmt.Errorf("%q must be a public host", raw)
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublic(ip) {
+		return "", fmt.Errorf("%q must be a public host", raw)
+	}
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// privateNets are the address ranges that aren't reachable on the internet,
+// besides loopback, link-local and multicast addresses.
+var privateNets = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// refusePrivate refuses connections to addresses that aren't public, in case
+// a webhook's hostname resolves to one.
+func refusePrivate(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+		return fmt.Errorf("refusing to deliver to non-public address %s", host)
+	}
+	return nil
+}
+
+// NewClient returns the client deliveries are sent with by default. It only
+// connects to public addresses, and doesn't follow redirects.
+func NewClient() *http.Client {
+	dialer := &net.Dialer{Timeout: Timeout, Control: refusePrivate}
+	return &http.Client{
+		Timeout:   Timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: Timeout},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// NewLoggingClient returns a client that logs deliveries instead of sending
+// them, and answers each with 204 No Content, for running without network
+// access.
+func NewLoggingClient() *http.Client {
+	return &http.Client{Transport: loggingTransport{}}
+}
+
+type loggingTransport struct{}
+
+func (loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	log.Printf("[Logged webhook delivery %s to %s]", req.Header.Get(DeliveryHeader), req.URL.Host)
+	return &http.Response{StatusCode: http.StatusNoContent, Status: "204 No Content",
+		Header: make(http.Header), Body: http.NoBody, Request: req}, nil
+}
+
+// Store is where deliveries are queued. See db.Database.
+type Store interface {
+	GetWebhook(int64) (models.Webhook, error)
+	GetDueWebhookDeliveries(time.Time, int) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(models.WebhookDelivery) error
+	AcquireLease(name string, holder string, ttl time.Duration) (bool, error)
+}
+
+// Lease is the name of the lease held by the server delivering webhooks, so
+// that only one of several servers does.
+const Lease = "webhook-dispatcher"
+
+// Dispatcher delivers queued webhook deliveries.
+type Dispatcher struct {
+	Store Store
+	// Holder identifies this server when it takes the lease.
+	Holder string
+	// Client sends deliveries. Defaults to NewClient().
+	Client *http.Client
+}
+
+// Deliver attempts the deliveries due by now, and returns how many it
+// attempted.
+func (d *Dispatcher) Deliver(now time.Time) (int, error) {
+	if d.Client == nil {
+		d.Client = NewClient()
+	}
+	due, err := d.Store.GetDueWebhookDeliveries(now, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	attempted := 0
+	for _, delivery := range due {
+		hook, err := d.Store.GetWebhook(delivery.WebhookID)
+		if err == sql.ErrNoRows {
+			// The webhook was removed since, and its deliveries with it.
+			continue
+		}
+		if err != nil {
+			return attempted, err
+		}
+		delivery = d.attempt(hook, delivery, now)
+		attempted++
+		if err = d.Store.UpdateWebhookDelivery(delivery); err != nil {
+			return attempted, err
+		}
+	}
+	return attempted, nil
+}
+
+// attempt tries to deliver to hook, and returns the delivery updated with
+// the outcome.
+func (d *Dispatcher) attempt(hook models.Webhook, delivery models.WebhookDelivery, now time.Time) models.WebhookDelivery {
+	delivery.Attempts++
+	code, err := d.post(hook, delivery)
+	delivery.ResponseCode = code
+	delivery.Error = ""
+	if err == nil {
+		delivery.Status = models.DeliveryDelivered
+		return delivery
+	}
+	delivery.Error = err.Error()
+	if delivery.Attempts >= MaxAttempts {
+		delivery.Status = models.DeliveryFailed
+	} else {
+		delivery.NextAttempt = now.Add(Backoff << uint(delivery.Attempts-1))
+	}
+	return delivery
+}
+
+// post sends delivery to hook, and returns the status it responded with.
+func (d *Dispatcher) post(hook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(Event{ID: delivery.ID, Event: EventStateChange, Domain: delivery.Domain,
+		From: delivery.From, To: delivery.To, Reason: delivery.Reason, Time: delivery.Time.UTC()})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, time.Now(), body))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// DeliverRegularly attempts due deliveries every interval, while the
+// dispatcher can take the lease. The lease outlasts the longest a run can
+// take, so only one server delivers at a time. Errors are logged.
+func (d *Dispatcher) DeliverRegularly(interval time.Duration) {
+	ttl := interval + batchSize*Timeout
+	for range time.Tick(interval) {
+		ok, err := d.Store.AcquireLease(Lease, d.Holder, ttl)
+		if err == nil && ok {
+			_, err = d.Deliver(time.Now())
+		}
+		if err != nil {
+			log.Printf("Couldn't deliver webhooks: %v", err)
+			raven.CaptureError(err, nil)
+		}
+	}
+}